@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Body of DELETE /users/me. Confirm must repeat the username, so a stray
+// request can't wipe an account.
+type DeleteUserRequest struct {
+	Confirm string `json:"confirm"`
+}
+
+// Delete account route: remove everything stored about the session's user
+// and end the session
+func (s *Server) deleteAccount(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	token, username, apiErr := s.sessionUser(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	var req DeleteUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	if strings.TrimSpace(req.Confirm) != username {
+		abortWithError(c, errInvalidRequest("Set confirm to your username to delete your account"))
+		return
+	}
+
+	removed, err := s.store.DeleteUser(ctx, username)
+	if err != nil {
+		log.Printf("Error deleting user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error deleting account"))
+		return
+	}
+	if err := s.store.DeleteSession(ctx, token); err != nil {
+		log.Printf("Error ending session of deleted user %s: %v", username, err)
+	}
+	if removed == nil {
+		removed = []string{}
+	}
+
+	// Their standings are gone from the leaderboard
+	s.leaderboard.invalidate()
+	s.broadcastLeaderboard()
+
+	log.Printf("Deleted user %s: %s", username, strings.Join(removed, ", "))
+	c.JSON(http.StatusOK, DeleteUserResponse{Username: username, Removed: removed})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"exploding-kitten/engine"
+)
+
+// Every key, hash field, set member and sorted set member that names
+// username in the Redis store
+func redisTraces(t *testing.T, store *redisStore, username string) []string {
+	t.Helper()
+	ctx := context.Background()
+	keys, err := store.rdb.Keys(ctx, "*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var traces []string
+	for _, key := range keys {
+		if strings.Contains(key, username) {
+			traces = append(traces, key)
+			continue
+		}
+		var found bool
+		switch kind := store.rdb.Type(ctx, key).Val(); kind {
+		case "hash":
+			found = store.rdb.HExists(ctx, key, username).Val()
+		case "set":
+			found = store.rdb.SIsMember(ctx, key, username).Val()
+		case "zset":
+			found = store.rdb.ZScore(ctx, key, username).Err() != redis.Nil
+		}
+		if found {
+			traces = append(traces, key+" "+username)
+		}
+	}
+	return traces
+}
+
+func TestDeleteAccountRemovesEverything(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		guest := ts.guest()
+		name := guest.Username
+
+		// A user with a won game, a game in progress, a profile and presence
+		ts.startGame(name, "Cat", engine.ExplodingKitten)
+		decodeOK[DrawCardResponse](t, ts.draw(name))
+		ts.startGame(name, "Cat", "Cat", engine.ExplodingKitten)
+		decodeOK[DrawCardResponse](t, ts.draw(name))
+		// Starting the second game reset the stats
+		ts.store.SetStats(ctx, name, 1, 0, AuditEntry{})
+		decodeOK[ProfileResponse](t, ts.request(http.MethodPut, "/profile", ProfileRequest{DisplayName: "Guest", AvatarEmoji: "🐱"}, bearer(guest.Token)...))
+		ts.store.TouchPresence(ctx, name, ts.clock.Now())
+		listed := false
+		for _, entry := range decodeOK[LeaderboardResponse](t, ts.get("/leaderboard")).Leaderboard {
+			listed = listed || entry.Username == name
+		}
+		if !listed {
+			t.Fatalf("leaderboard doesn't list %s before deleting", name)
+		}
+
+		// The body must confirm the username
+		assertError(t, ts.request(http.MethodDelete, "/users/me", DeleteUserRequest{Confirm: "someone"}, bearer(guest.Token)...), http.StatusBadRequest, ErrCodeInvalidRequest)
+
+		deleted := decodeOK[DeleteUserResponse](t, ts.request(http.MethodDelete, "/users/me", DeleteUserRequest{Confirm: name}, bearer(guest.Token)...))
+		if deleted.Username != name || len(deleted.Removed) == 0 {
+			t.Fatalf("delete = %+v", deleted)
+		}
+
+		if redis, ok := ts.store.(*redisStore); ok {
+			if traces := redisTraces(t, redis, name); len(traces) > 0 {
+				t.Fatalf("left behind: %v", traces)
+			}
+		}
+		if win, lose, _ := ts.store.GetStats(ctx, name); win != 0 || lose != 0 {
+			t.Fatalf("stats = %d/%d after deleting", win, lose)
+		}
+		if achievements, _ := ts.store.Achievements(ctx, name); len(achievements) > 0 {
+			t.Fatalf("achievements = %v after deleting", achievements)
+		}
+		if hand, _ := ts.store.GetHand(ctx, name); len(hand) > 0 {
+			t.Fatalf("hand = %v after deleting", hand)
+		}
+		if profiles, _ := ts.store.Profiles(ctx, []string{name}); len(profiles) > 0 {
+			t.Fatalf("profiles = %v after deleting", profiles)
+		}
+		if online, _ := ts.store.OnlineUsers(ctx, ts.clock.Now().Add(-time.Minute)); len(online) > 0 {
+			t.Fatalf("online = %v after deleting", online)
+		}
+		for _, entry := range decodeOK[LeaderboardResponse](t, ts.get("/leaderboard")).Leaderboard {
+			if entry.Username == name {
+				t.Fatalf("leaderboard still lists %s: %+v", name, entry)
+			}
+		}
+
+		// The session went with the account
+		assertError(t, ts.request(http.MethodDelete, "/users/me", DeleteUserRequest{Confirm: name}, bearer(guest.Token)...), http.StatusUnauthorized, ErrCodeUnauthorized)
+	})
+}
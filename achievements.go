@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// An achievement a user has earned
+type Achievement struct {
+	Name     string    `json:"name"`
+	EarnedAt time.Time `json:"earnedAt"`
+}
+
+// What an achievement rule gets to look at when a user wins
+type winRecord struct {
+	Username string
+	Wins     int64
+	Streak   int64
+	// Cards still held when the game was won
+	HandSize int
+}
+
+// Achievement rules. Adding an achievement only takes a new entry here.
+var achievementRules = []struct {
+	name   string
+	earned func(win winRecord) bool
+}{
+	{"first_win", func(win winRecord) bool { return win.Wins >= 1 }},
+	{"win_streak_5", func(win winRecord) bool { return win.Streak >= 5 }},
+	{"ten_wins", func(win winRecord) bool { return win.Wins >= 10 }},
+	{"empty_handed", func(win winRecord) bool { return win.HandSize == 0 }},
+}
+
+// Award any achievements the win qualifies for, tell the user's own sockets
+// and anyone spectating them about the new ones and return their names. Failures are logged; they never
+// undo the win.
+func (s *Server) awardAchievements(ctx context.Context, username string, wins, streak int64) []string {
+	hand, err := s.store.GetHand(ctx, username)
+	if err != nil {
+		log.Printf("Error retrieving hand for user %s: %v", username, err)
+		return nil
+	}
+	win := winRecord{Username: username, Wins: wins, Streak: streak, HandSize: len(hand)}
+
+	var earned []string
+
+	for _, rule := range achievementRules {
+		if !rule.earned(win) {
+			continue
+		}
+		added, err := s.store.AwardAchievement(ctx, username, rule.name, s.clock.Now())
+		if err != nil {
+			log.Printf("Error awarding %s to user %s: %v", rule.name, username, err)
+			continue
+		}
+		if added {
+			log.Printf("User %s earned achievement %s", username, rule.name)
+			event := SpectatorEvent{Type: "achievement", Username: username, Name: rule.name}
+			s.hub.notifyPlayer(username, event)
+			s.hub.notifySpectators(username, event)
+			earned = append(earned, rule.name)
+		}
+	}
+	return earned
+}
+
+// Achievements route
+func (s *Server) getAchievements(c *gin.Context) {
+	username := c.Param("username")
+	if !usernamePattern.MatchString(username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+
+	achievements, err := s.store.Achievements(c.Request.Context(), username)
+	if err != nil {
+		log.Printf("Error retrieving achievements for user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving achievements"))
+		return
+	}
+	if achievements == nil {
+		achievements = []Achievement{}
+	}
+
+	c.JSON(http.StatusOK, AchievementsResponse{Username: username, Achievements: achievements})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// The names of the user's achievements, as stored
+func (ts *testServer) achievements(username string) map[string]bool {
+	ts.t.Helper()
+	earned, err := ts.store.Achievements(context.Background(), username)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, achievement := range earned {
+		names[achievement.Name] = true
+	}
+	return names
+}
+
+// Win a fresh two-player room game by bob's forfeit, with alice's hand empty
+// or not
+func winRoomGame(t *testing.T, ts *testServer, emptyHanded bool) {
+	t.Helper()
+	room := ts.openRoom("alice", "bob")
+	if emptyHanded {
+		ts.deal("alice")
+	} else {
+		ts.deal("alice", "Cat")
+	}
+	decodeOK[ForfeitResponse](t, ts.post("/forfeit", User{Username: "bob", GameID: room.gameID()}))
+}
+
+// The achievement events the socket got before the game_over of the game
+func achievementsBeforeGameOver(t *testing.T, socket *testSocket) []string {
+	t.Helper()
+	var names []string
+	for {
+		event := decodeMessage[SpectatorEvent](t, socket.any())
+		switch event.Type {
+		case "achievement":
+			names = append(names, event.Name)
+		case "game_over":
+			return names
+		}
+	}
+}
+
+func TestFirstWinEarnsAchievement(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", engine.ExplodingKitten)
+		modern := ts.dial("spectate=alice")
+		modern.next("snapshot")
+		modern.hello(CapAchievements)
+		legacy := ts.dial("spectate=alice")
+		legacy.next("snapshot")
+
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+
+		if earned := ts.achievements("alice"); len(earned) != 1 || !earned["first_win"] {
+			t.Fatalf("achievements = %v, want first_win", earned)
+		}
+		if names := achievementsBeforeGameOver(t, modern); len(names) != 1 || names[0] != "first_win" {
+			t.Fatalf("pushed %v, want first_win", names)
+		}
+		// A socket that never said it understands them isn't sent any
+		if names := achievementsBeforeGameOver(t, legacy); len(names) != 0 {
+			t.Fatalf("legacy socket got %v", names)
+		}
+
+		listed := decodeOK[AchievementsResponse](t, ts.get("/achievements/alice"))
+		if len(listed.Achievements) != 1 || !listed.Achievements[0].EarnedAt.Equal(testEpoch) {
+			t.Fatalf("listed = %+v", listed)
+		}
+	})
+}
+
+func TestAchievementReachesPlayerSocket(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", engine.ExplodingKitten)
+		socket := ts.dial("username=alice")
+		socket.next("leaderboard")
+		socket.hello(CapAchievements)
+
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+
+		event := decodeMessage[SpectatorEvent](t, socket.next("achievement"))
+		if event.Username != "alice" || event.Name != "first_win" {
+			t.Fatalf("achievement = %+v, want alice's first_win", event)
+		}
+	})
+}
+
+func TestWinStreakAndTotalsEarnAchievements(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		socket := ts.dial("spectate=alice")
+		socket.next("snapshot")
+		socket.hello(CapAchievements)
+
+		for game := 1; game <= 5; game++ {
+			winRoomGame(t, ts, false)
+			names := achievementsBeforeGameOver(t, socket)
+			switch {
+			case game == 1 && (len(names) != 1 || names[0] != "first_win"):
+				t.Fatalf("first win pushed %v", names)
+			case game == 5 && (len(names) != 1 || names[0] != "win_streak_5"):
+				t.Fatalf("fifth win in a row pushed %v", names)
+			case game > 1 && game < 5 && len(names) != 0:
+				t.Fatalf("win %d pushed %v", game, names)
+			}
+		}
+
+		ts.store.SetStats(context.Background(), "alice", 9, 0, AuditEntry{})
+		winRoomGame(t, ts, true)
+		if names := achievementsBeforeGameOver(t, socket); len(names) != 2 || names[0] != "ten_wins" || names[1] != "empty_handed" {
+			t.Fatalf("tenth win, empty handed, pushed %v", names)
+		}
+		if earned := ts.achievements("alice"); len(earned) != len(achievementRules) {
+			t.Fatalf("achievements = %v, want all of them", earned)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gin middleware admitting only requests carrying the admin bearer token.
+// With no token configured the admin API is closed.
+func (s *Server) adminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.isAdmin(c) {
+			abortWithError(c, errUnauthorized())
+			return
+		}
+		c.Next()
+	}
+}
+
+// Whether the request carries the admin bearer token
+func (s *Server) isAdmin(c *gin.Context) bool {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return s.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+// Everything stored about a user, for support
+type AdminUserDump struct {
+	Username string   `json:"username"`
+	Deck     []string `json:"deck" sensitive:"true"`
+	Hand     []string `json:"hand" sensitive:"true"`
+	Defuse   int      `json:"defuse"`
+	Win      int64    `json:"win"`
+	Lose     int64    `json:"lose"`
+}
+
+type AdminStatsRequest struct {
+	Win  *int64 `json:"win"`
+	Lose *int64 `json:"lose"`
+}
+
+// The :username param, validated like any other username
+func adminUsername(c *gin.Context) (string, *APIError) {
+	username := c.Param("username")
+	if !usernamePattern.MatchString(username) {
+		return "", errInvalidUsername()
+	}
+	return username, nil
+}
+
+// Admin user route: dump the user's deck, hand, defuse count and stats
+func (s *Server) adminGetUser(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	username, apiErr := adminUsername(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	deck, err := s.store.GetDeck(ctx, username)
+	if err != nil {
+		log.Printf("Error retrieving deck for user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving deck"))
+		return
+	}
+	hand, err := s.store.GetHand(ctx, username)
+	if err != nil {
+		log.Printf("Error retrieving hand for user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving hand"))
+		return
+	}
+	defuse, err := s.store.GetDefuse(ctx, username)
+	if err != nil {
+		log.Printf("Error retrieving defuse status for user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving defuse status"))
+		return
+	}
+	win, lose, err := s.store.GetStats(ctx, username)
+	if err != nil {
+		log.Printf("Error retrieving stats for user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving stats"))
+		return
+	}
+
+	dump := AdminUserDump{Username: username, Deck: deck, Hand: hand, Defuse: defuse, Win: win, Lose: lose}
+	if dump.Deck == nil {
+		dump.Deck = []string{}
+	}
+	if dump.Hand == nil {
+		dump.Hand = []string{}
+	}
+
+	c.JSON(http.StatusOK, dump)
+}
+
+// Admin reset route: wipe the user's solo game but keep their stats
+func (s *Server) adminResetGame(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	username, apiErr := adminUsername(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	if err := s.clearGame(ctx, username, username); err != nil {
+		abortWithError(c, errStoreUnavailable("Error clearing game"))
+		return
+	}
+	s.audit(c, s.adminAuditEntry(c, "reset_game", username))
+
+	log.Printf("Admin reset the game of user %s", username)
+	c.JSON(http.StatusOK, AdminResetResponse{Message: "Game reset", Username: username})
+}
+
+// Admin stats route: set the user's win/lose counts
+func (s *Server) adminSetStats(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	username, apiErr := adminUsername(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	var req AdminStatsRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Win == nil || req.Lose == nil || *req.Win < 0 || *req.Lose < 0 {
+		abortWithError(c, errInvalidRequest("win and lose must be non-negative integers"))
+		return
+	}
+
+	// The audit entry is written along with the stats
+	before, err := s.store.SetStats(ctx, username, *req.Win, *req.Lose, s.adminAuditEntry(c, "set_stats", username))
+	if err != nil {
+		log.Printf("Error setting stats for user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error setting stats"))
+		return
+	}
+	s.leaderboard.invalidate()
+
+	logGameEvent(username, username, map[string]any{
+		"event": "admin_set_stats", "previousWin": before.Wins, "previousLose": before.Losses, winKey: *req.Win, loseKey: *req.Lose,
+	})
+	c.JSON(http.StatusOK, AdminStatsResponse{Username: username, Win: *req.Win, Lose: *req.Lose})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+const testAdminToken = "admin-secret"
+
+// The header pair a request needs to get past adminAuth
+var asAdmin = []string{"Authorization", "Bearer " + testAdminToken}
+
+// Run test once against a server on each of testStores with the admin API
+// open to testAdminToken
+func eachAdminStore(t *testing.T, test func(t *testing.T, ts *testServer)) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		test(t, newTestServerWith(t, store, testConfig(t, map[string]string{"ADMIN_TOKEN": testAdminToken})))
+	})
+}
+
+func TestAdminRoutesNeedToken(t *testing.T) {
+	eachAdminStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice")
+		for _, headers := range [][]string{nil, {"Authorization", "Bearer wrong"}, {"Authorization", testAdminToken + "x"}} {
+			known := assertError(t, ts.get("/admin/users/alice", headers...), http.StatusUnauthorized, ErrCodeUnauthorized)
+			unknown := assertError(t, ts.get("/admin/users/nobody", headers...), http.StatusUnauthorized, ErrCodeUnauthorized)
+			// Nothing tells a stranger whether the user exists
+			if !reflect.DeepEqual(known, unknown) {
+				t.Fatalf("401s differ: %+v and %+v", known, unknown)
+			}
+			assertError(t, ts.request(http.MethodDelete, "/admin/users/alice/game", nil, headers...), http.StatusUnauthorized, ErrCodeUnauthorized)
+			assertError(t, ts.post("/admin/users/alice/stats", AdminStatsRequest{}, headers...), http.StatusUnauthorized, ErrCodeUnauthorized)
+		}
+	})
+
+	// Without a token configured the admin API is closed to everyone
+	ts := newTestServer(t, newMemoryStore())
+	assertError(t, ts.get("/admin/users/alice", "Authorization", "Bearer "), http.StatusUnauthorized, ErrCodeUnauthorized)
+}
+
+func TestAdminDumpsUser(t *testing.T) {
+	eachAdminStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", engine.Defuse, engine.ExplodingKitten)
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+
+		w := ts.get("/admin/users/alice", asAdmin...)
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if want := []string{"deck", "defuse", "hand", "lose", "username", "win"}; !reflect.DeepEqual(names, want) {
+			t.Fatalf("dump fields = %v, want %v", names, want)
+		}
+
+		// Drawing the Defuse left only the bomb, which won the game
+		dump := decodeOK[AdminUserDump](t, w)
+		want := AdminUserDump{Username: "alice", Deck: []string{engine.ExplodingKitten}, Hand: []string{"Cat", engine.Defuse}, Defuse: 1, Win: 1}
+		sort.Strings(dump.Hand)
+		if !reflect.DeepEqual(dump, want) {
+			t.Fatalf("dump = %+v, want %+v", dump, want)
+		}
+
+		// A user nobody has heard of dumps empty, not null
+		if w := ts.get("/admin/users/nobody", asAdmin...); !bytes.Contains(w.Body.Bytes(), []byte(`"deck":[],"hand":[]`)) {
+			t.Fatalf("empty dump = %s", w.Body)
+		}
+	})
+}
+
+func TestAdminResetLetsStartGameDealAgain(t *testing.T) {
+	eachAdminStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", "Cat", engine.ExplodingKitten)
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		ts.store.SetStats(context.Background(), "alice", 4, 2, AuditEntry{})
+
+		reset := decodeOK[AdminResetResponse](t, ts.request(http.MethodDelete, "/admin/users/alice/game", nil, asAdmin...))
+		if reset.Username != "alice" {
+			t.Fatalf("reset = %+v", reset)
+		}
+		if deck, hand := ts.deck("alice"), ts.hand("alice"); len(deck) != 0 || len(hand) != 0 {
+			t.Fatalf("deck %v and hand %v left after the reset", deck, hand)
+		}
+		// The reset doesn't touch stats
+		if win, lose := ts.stats("alice"); win != 4 || lose != 2 {
+			t.Fatalf("stats = %d/%d after the reset, want 4/2", win, lose)
+		}
+
+		started := ts.startGame("alice")
+		if started.Message != "Game started" || started.Snapshot.Remaining != ts.soloDeck.Size {
+			t.Fatalf("start after reset = %+v", started)
+		}
+	})
+}
+
+func TestAdminSetsStats(t *testing.T) {
+	eachAdminStore(t, func(t *testing.T, ts *testServer) {
+		win, lose := int64(7), int64(3)
+		set := decodeOK[AdminStatsResponse](t, ts.post("/admin/users/alice/stats", AdminStatsRequest{Win: &win, Lose: &lose}, asAdmin...))
+		if set.Win != 7 || set.Lose != 3 {
+			t.Fatalf("set = %+v", set)
+		}
+		if win, lose := ts.stats("alice"); win != 7 || lose != 3 {
+			t.Fatalf("stats = %d/%d, want 7/3", win, lose)
+		}
+		audit := decodeOK[AdminAuditResponse](t, ts.get("/admin/audit", asAdmin...))
+		if n := len(audit.Entries); n == 0 || audit.Entries[n-1].Action != "set_stats" || audit.Entries[n-1].Username != "alice" {
+			t.Fatalf("audit = %+v", audit.Entries)
+		}
+
+		negative := int64(-1)
+		assertError(t, ts.post("/admin/users/alice/stats", AdminStatsRequest{Win: &negative, Lose: &lose}, asAdmin...), http.StatusBadRequest, ErrCodeInvalidRequest)
+		assertError(t, ts.post("/admin/users/alice/stats", AdminStatsRequest{Win: &win}, asAdmin...), http.StatusBadRequest, ErrCodeInvalidRequest)
+	})
+}
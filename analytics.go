@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"exploding-kitten/engine"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Days /analytics/summary adds up at the most, for its weekly rollup
+const maxAnalyticsDays = 7
+
+// How the game hash's draw count fields for bombs end, after the player
+const bombDrawSuffix = ":" + engine.ExplodingKitten
+
+// The counts of the games completed on a UTC day, counted as each game ends
+type DailyStats struct {
+	// "2006-01-02"
+	Day   string `json:"day"`
+	Games int64  `json:"games"`
+	// Cards drawn in the games, bombs among them
+	Draws int64 `json:"draws"`
+	Bombs int64 `json:"bombs"`
+	// Bombs a Defuse was spent on
+	Defused int64 `json:"defused"`
+	// Games that ended on a bomb going off
+	Exploded int64 `json:"exploded"`
+}
+
+// Decode a daily stats hash; missing or unreadable counts are zero
+func parseDailyStats(day string, hash map[string]string) DailyStats {
+	count := func(field string) int64 {
+		value, _ := strconv.ParseInt(hash[field], 10, 64)
+		return value
+	}
+	return DailyStats{
+		Day:      day,
+		Games:    count("games"),
+		Draws:    count("draws"),
+		Bombs:    count("bombs"),
+		Defused:  count("defused"),
+		Exploded: count("exploded"),
+	}
+}
+
+// Analytics summary route: the games completed over one or more UTC days
+type AnalyticsSummaryResponse struct {
+	// The first and last day counted, "2006-01-02"
+	From        string `json:"from"`
+	To          string `json:"to"`
+	GamesPlayed int64  `json:"gamesPlayed"`
+	// Cards drawn per game played
+	AverageDraws float64 `json:"averageDraws"`
+	// The share of games that ended on a bomb going off
+	ExplosionRate float64 `json:"explosionRate"`
+	// The share of bombs drawn that were defused
+	DefuseSuccessRate float64 `json:"defuseSuccessRate"`
+	// The counts of each day, oldest first
+	Days []DailyStats `json:"days"`
+}
+
+// n / d, or zero if there is nothing to divide by
+func rate(n, d int64) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// The summary of the days' stats added up
+func summarizeDays(days []DailyStats) AnalyticsSummaryResponse {
+	var total DailyStats
+	for _, day := range days {
+		total.Games += day.Games
+		total.Draws += day.Draws
+		total.Bombs += day.Bombs
+		total.Defused += day.Defused
+		total.Exploded += day.Exploded
+	}
+	summary := AnalyticsSummaryResponse{
+		GamesPlayed:       total.Games,
+		AverageDraws:      rate(total.Draws, total.Games),
+		ExplosionRate:     rate(total.Exploded, total.Games),
+		DefuseSuccessRate: rate(total.Defused, total.Bombs),
+		Days:              days,
+	}
+	if len(days) > 0 {
+		summary.From, summary.To = days[0].Day, days[len(days)-1].Day
+	}
+	return summary
+}
+
+// Gin middleware for the analytics routes: they need the admin token or an
+// API key, which apiKeyAuth has already checked grants the route's scope
+func (s *Server) analyticsAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.isAdmin(c) && c.GetHeader("X-Api-Key") == "" {
+			abortWithError(c, errUnauthorized())
+			return
+		}
+		c.Next()
+	}
+}
+
+// Analytics summary route: games played, draws per game, how often bombs go
+// off and how often they are defused, over the UTC day in ?date= (today by
+// default), or with ?days= over that many days up to it, at most
+// maxAnalyticsDays
+func (s *Server) analyticsSummary(c *gin.Context) {
+	today, _ := quotaDay(s.clock.Now())
+	last, err := time.Parse("2006-01-02", c.DefaultQuery("date", today))
+	if err != nil {
+		abortWithError(c, errInvalidRequest("date must be a day as YYYY-MM-DD"))
+		return
+	}
+	count := 1
+	if raw := c.Query("days"); raw != "" {
+		count, err = strconv.Atoi(raw)
+		if err != nil || count < 1 || count > maxAnalyticsDays {
+			abortWithError(c, errInvalidRequest("days must be between 1 and "+strconv.Itoa(maxAnalyticsDays)))
+			return
+		}
+	}
+
+	days := make([]string, count)
+	for i := range days {
+		days[i] = last.AddDate(0, 0, i-count+1).Format("2006-01-02")
+	}
+	stats, err := s.store.DailyStats(c.Request.Context(), days)
+	if err != nil {
+		log.Printf("Error retrieving daily stats: %v", err)
+		abortWithError(c, errStoreUnavailable("Error retrieving analytics"))
+		return
+	}
+	c.JSON(http.StatusOK, summarizeDays(stats))
+}
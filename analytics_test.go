@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+func TestAnalyticsSummaryOfSeededGames(t *testing.T) {
+	eachAdminStore(t, func(t *testing.T, ts *testServer) {
+		// A win, an explosion, and a bomb defused before the next went off
+		ts.winSoloGame("alice")
+		ts.startGame("bob", engine.ExplodingKitten, "Cat")
+		if drawn := decodeOK[DrawCardResponse](t, ts.draw("bob")); drawn.Disposition != DispositionExploded {
+			t.Fatalf("bob's bomb = %+v", drawn)
+		}
+		ts.startGame("carol", engine.Defuse, engine.ExplodingKitten, engine.ExplodingKitten, "Cat")
+		decodeOK[DrawCardResponse](t, ts.draw("carol"))
+		decodeOK[DrawCardResponse](t, ts.draw("carol"))
+		ts.resolveBomb("carol", true)
+		if drawn := decodeOK[DrawCardResponse](t, ts.draw("carol")); drawn.Disposition != DispositionExploded {
+			t.Fatalf("carol's second bomb = %+v", drawn)
+		}
+
+		day := DailyStats{Day: "2026-03-02", Games: 3, Draws: 5, Bombs: 3, Defused: 1, Exploded: 2}
+		want := AnalyticsSummaryResponse{
+			From: day.Day, To: day.Day, GamesPlayed: 3,
+			AverageDraws: rate(5, 3), ExplosionRate: rate(2, 3), DefuseSuccessRate: rate(1, 3),
+			Days: []DailyStats{day},
+		}
+		for _, path := range []string{"/analytics/summary", "/analytics/summary?date=2026-03-02"} {
+			if got := decodeOK[AnalyticsSummaryResponse](t, ts.get(path, asAdmin...)); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s = %+v\nwant %+v", path, got, want)
+			}
+		}
+
+		// A game the next day, and the two days rolled up oldest first
+		ts.clock.Advance(24 * time.Hour)
+		ts.winSoloGame("dave")
+		next := DailyStats{Day: "2026-03-03", Games: 1, Draws: 1}
+		want = AnalyticsSummaryResponse{
+			From: day.Day, To: next.Day, GamesPlayed: 4,
+			AverageDraws: rate(6, 4), ExplosionRate: rate(2, 4), DefuseSuccessRate: rate(1, 3),
+			Days: []DailyStats{day, next},
+		}
+		if got := decodeOK[AnalyticsSummaryResponse](t, ts.get("/analytics/summary?days=2", asAdmin...)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("rollup = %+v\nwant %+v", got, want)
+		}
+		quiet := decodeOK[AnalyticsSummaryResponse](t, ts.get("/analytics/summary?date=2026-03-01", asAdmin...))
+		if quiet.GamesPlayed != 0 || quiet.AverageDraws != 0 || quiet.DefuseSuccessRate != 0 || len(quiet.Days) != 1 {
+			t.Fatalf("a day without games = %+v", quiet)
+		}
+
+		// An API key needs the analytics scope
+		reader := ts.createAPIKey(ScopeAnalyticsRead)
+		if got := decodeOK[AnalyticsSummaryResponse](t, ts.get("/analytics/summary?days=2", "X-Api-Key", reader.Key)); got.GamesPlayed != 4 {
+			t.Fatalf("rollup with an API key = %+v", got)
+		}
+		other := ts.createAPIKey(ScopeLeaderboardRead)
+		assertError(t, ts.get("/analytics/summary", "X-Api-Key", other.Key), http.StatusForbidden, ErrCodeScopeMissing)
+		assertError(t, ts.get("/analytics/summary"), http.StatusUnauthorized, ErrCodeUnauthorized)
+		for _, query := range []string{"date=03-02-2026", "days=0", "days=8", "days=week"} {
+			assertError(t, ts.get("/analytics/summary?"+query, asAdmin...), http.StatusBadRequest, ErrCodeInvalidRequest)
+		}
+	})
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// What an API key may read. Keys are for embedding public data elsewhere,
+// so there are only read scopes.
+const (
+	ScopeLeaderboardRead = "leaderboard:read"
+	ScopeStatsRead       = "stats:read"
+	ScopeAnalyticsRead   = "analytics:read"
+)
+
+var apiKeyScopes = map[string]bool{ScopeLeaderboardRead: true, ScopeStatsRead: true, ScopeAnalyticsRead: true}
+
+// The scope each route needs when called with an API key, keyed like
+// routeDocs. A key can't call any other route.
+var apiKeyRouteScopes = map[string]string{
+	"GET /leaderboard":              ScopeLeaderboardRead,
+	"GET /export/leaderboard":       ScopeLeaderboardRead,
+	"GET /achievements/:username":   ScopeStatsRead,
+	"GET /export/history/:username": ScopeStatsRead,
+	"GET /h2h/:userA/:userB":        ScopeStatsRead,
+	"GET /analytics/summary":        ScopeAnalyticsRead,
+}
+
+// API keys look like ek_<id>_<secret>
+const apiKeyPrefix = "ek_"
+
+// How long a key looked up in the store is trusted, unless API_KEY_CACHE_TTL
+// says otherwise. A key revoked on another instance still works there for
+// up to this long.
+const defaultAPIKeyCacheTTL = 5 * time.Second
+
+// Requests a key may make, unless API_KEY_RATE says otherwise, and how many
+// of them it may make at once. Stricter than anything a player can do, as
+// a widget's page views all come through one key.
+const (
+	defaultAPIKeyRate = 30 // per minute
+	apiKeyBurst       = 10
+)
+
+// An API key as the store keeps it: everything but the key itself, of which
+// only a hash is kept
+type APIKey struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// SHA-256 of the key, hex encoded. Never sent to clients.
+	Hash      string    `json:"hash,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (k *APIKey) allows(scope string) bool {
+	for _, granted := range k.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateAPIKeyRequest struct {
+	// Who the key is for, to tell keys apart when revoking them
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// Admin API key creation route. Key is only ever shown here.
+type CreateAPIKeyResponse struct {
+	Key    string `json:"key" sensitive:"true"`
+	APIKey APIKey `json:"apiKey"`
+}
+
+// Admin API key list route
+type APIKeysResponse struct {
+	APIKeys []APIKey `json:"apiKeys"`
+}
+
+// Admin API key revocation route
+type RevokeAPIKeyResponse struct {
+	Message string `json:"message"`
+	ID      string `json:"id"`
+}
+
+// A new key and what the store keeps of it
+func newAPIKey(name string, scopes []string, now time.Time) (string, APIKey, error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", APIKey{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIKey{}, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(id) + "_" + hex.EncodeToString(secret)
+	return key, APIKey{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Scopes:    scopes,
+		Hash:      hashAPIKey(key),
+		CreatedAt: now.UTC(),
+	}, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// The ID part of a key, or false if it isn't shaped like one
+func apiKeyID(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", false
+	}
+	id, secret, ok := strings.Cut(key[len(apiKeyPrefix):], "_")
+	if !ok || id == "" || secret == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// Keys read from the store, so a widget's every request doesn't cost a
+// lookup. Unknown IDs are cached as nil, so guessing doesn't either.
+type apiKeyCache struct {
+	mutex   sync.Mutex
+	entries map[string]cachedAPIKey
+}
+
+type cachedAPIKey struct {
+	key     *APIKey
+	fetched time.Time
+}
+
+// Cached lookups kept before the cache is emptied, so random IDs can't grow
+// it forever
+const maxCachedAPIKeys = 10000
+
+func (c *apiKeyCache) get(id string, now time.Time, ttl time.Duration) (*APIKey, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[id]
+	if !ok || now.Sub(entry.fetched) >= ttl {
+		return nil, false
+	}
+	return entry.key, true
+}
+
+func (c *apiKeyCache) put(id string, key *APIKey, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.entries == nil || len(c.entries) >= maxCachedAPIKeys {
+		c.entries = make(map[string]cachedAPIKey)
+	}
+	c.entries[id] = cachedAPIKey{key: key, fetched: now}
+}
+
+func (c *apiKeyCache) forget(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, id)
+}
+
+// Token buckets of the keys that made requests, keyed by key ID. Each
+// instance counts on its own.
+type apiKeyLimiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*apiKeyBucket
+}
+
+type apiKeyBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Take a token from the key's bucket, which refills at perMinute
+func (l *apiKeyLimiter) allow(id string, now time.Time, perMinute int) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.buckets == nil || len(l.buckets) >= maxCachedAPIKeys {
+		l.buckets = make(map[string]*apiKeyBucket)
+	}
+	bucket := l.buckets[id]
+	if bucket == nil {
+		bucket = &apiKeyBucket{tokens: apiKeyBurst, last: now}
+		l.buckets[id] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Minutes() * float64(perMinute)
+	if bucket.tokens > apiKeyBurst {
+		bucket.tokens = apiKeyBurst
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// The key with the ID, from the cache while it is fresh
+func (s *Server) lookupAPIKey(c *gin.Context, id string) (*APIKey, *APIError) {
+	now := s.clock.Now()
+	if key, ok := s.apiKeys.get(id, now, s.apiKeyCacheTTL); ok {
+		return key, nil
+	}
+	key, err := s.store.APIKey(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("Error retrieving API key %s: %v", id, err)
+		return nil, errStoreUnavailable("Error checking API key")
+	}
+	s.apiKeys.put(id, key, now)
+	return key, nil
+}
+
+// Gin middleware for requests carrying an X-Api-Key header: the key must
+// exist, grant the scope of the route, and be within its rate limit.
+// Requests without one go through untouched.
+func (s *Server) apiKeyAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := c.GetHeader("X-Api-Key")
+		if raw == "" {
+			c.Next()
+			return
+		}
+		id, ok := apiKeyID(raw)
+		if !ok {
+			abortWithError(c, errUnauthorized())
+			return
+		}
+		key, apiErr := s.lookupAPIKey(c, id)
+		if apiErr != nil {
+			abortWithError(c, apiErr)
+			return
+		}
+		if key == nil || subtle.ConstantTimeCompare([]byte(hashAPIKey(raw)), []byte(key.Hash)) != 1 {
+			abortWithError(c, errUnauthorized())
+			return
+		}
+		scope, ok := apiKeyRouteScopes[c.Request.Method+" "+c.FullPath()]
+		if !ok || !key.allows(scope) {
+			abortWithError(c, errScopeMissing(scope))
+			return
+		}
+		if !s.apiKeyLimiter.allow(key.ID, s.clock.Now(), s.apiKeyRate) {
+			abortWithError(c, errAPIKeyRateLimited())
+			return
+		}
+		c.Next()
+	}
+}
+
+// Admin API key creation route: a key with the requested scopes, shown once
+func (s *Server) adminCreateAPIKey(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		abortWithError(c, errInvalidRequest("name must be 1 to 100 characters"))
+		return
+	}
+	if len(req.Scopes) == 0 {
+		abortWithError(c, errInvalidRequest("scopes must list at least one scope"))
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !apiKeyScopes[scope] {
+			abortWithError(c, errInvalidRequest(fmt.Sprintf("Unknown scope %q", scope)))
+			return
+		}
+	}
+
+	raw, key, err := newAPIKey(req.Name, req.Scopes, s.clock.Now())
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		abortWithError(c, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "Error generating API key"))
+		return
+	}
+	if err := s.store.SaveAPIKey(ctx, key); err != nil {
+		log.Printf("Error saving API key %s: %v", key.ID, err)
+		abortWithError(c, errStoreUnavailable("Error saving API key"))
+		return
+	}
+	entry := s.adminAuditEntry(c, "create_apikey", "")
+	entry.After = map[string]interface{}{"id": key.ID, "name": key.Name, "scopes": key.Scopes}
+	s.audit(c, entry)
+
+	log.Printf("Admin created API key %s for %s", key.ID, key.Name)
+	key.Hash = ""
+	c.JSON(http.StatusCreated, CreateAPIKeyResponse{Key: raw, APIKey: key})
+}
+
+// Admin API key list route: every key, oldest first, without the keys
+// themselves
+func (s *Server) adminAPIKeys(c *gin.Context) {
+	keys, err := s.store.APIKeys(c.Request.Context())
+	if err != nil {
+		log.Printf("Error retrieving API keys: %v", err)
+		abortWithError(c, errStoreUnavailable("Error retrieving API keys"))
+		return
+	}
+	for i := range keys {
+		keys[i].Hash = ""
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	c.JSON(http.StatusOK, APIKeysResponse{APIKeys: keys})
+}
+
+// Admin API key revocation route. The key stops working on this instance at
+// once and on the others within apiKeyCacheTTL.
+func (s *Server) adminRevokeAPIKey(c *gin.Context) {
+	id := c.Param("id")
+	revoked, err := s.store.RevokeAPIKey(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("Error revoking API key %s: %v", id, err)
+		abortWithError(c, errStoreUnavailable("Error revoking API key"))
+		return
+	}
+	if !revoked {
+		abortWithError(c, errAPIKeyNotFound())
+		return
+	}
+	s.apiKeys.forget(id)
+	entry := s.adminAuditEntry(c, "revoke_apikey", "")
+	entry.Before = id
+	s.audit(c, entry)
+
+	log.Printf("Admin revoked API key %s", id)
+	c.JSON(http.StatusOK, RevokeAPIKeyResponse{Message: "API key revoked", ID: id})
+}
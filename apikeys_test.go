@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Create an API key with the scopes through the admin API
+func (ts *testServer) createAPIKey(scopes ...string) CreateAPIKeyResponse {
+	ts.t.Helper()
+	w := ts.post("/admin/apikeys", CreateAPIKeyRequest{Name: "widget", Scopes: scopes}, asAdmin...)
+	if w.Code != http.StatusCreated {
+		ts.t.Fatalf("creating an API key: %d %s", w.Code, w.Body.String())
+	}
+	return decodeBody[CreateAPIKeyResponse](ts.t, w)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	eachAdminStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice")
+		created := ts.createAPIKey(ScopeLeaderboardRead)
+		key := []string{"X-Api-Key", created.Key}
+		if created.APIKey.Hash != "" {
+			t.Fatalf("the key's hash was sent back: %+v", created.APIKey)
+		}
+		// The store only has the hash
+		stored, err := ts.store.APIKey(context.Background(), created.APIKey.ID)
+		if err != nil || stored == nil || stored.Hash != hashAPIKey(created.Key) {
+			t.Fatalf("stored key = %+v, %v", stored, err)
+		}
+
+		decodeOK[LeaderboardResponse](t, ts.get("/leaderboard", key...))
+		assertError(t, ts.get("/h2h/alice/bob", key...), http.StatusForbidden, ErrCodeScopeMissing)
+		wins := int64(100)
+		// Routes no scope grants, stat writes among them, are closed to keys
+		assertError(t, ts.post("/admin/users/alice/stats", AdminStatsRequest{Win: &wins, Lose: new(int64)}, key...), http.StatusForbidden, ErrCodeScopeMissing)
+		assertError(t, ts.post("/draw-card", User{Username: "alice"}, key...), http.StatusForbidden, ErrCodeScopeMissing)
+		if win, _ := ts.stats("alice"); win != 0 {
+			t.Fatalf("alice has %d wins after a write with a read key", win)
+		}
+
+		// A key has to be the one created, not just shaped like it
+		assertError(t, ts.get("/leaderboard", "X-Api-Key", created.Key+"0"), http.StatusUnauthorized, ErrCodeUnauthorized)
+		assertError(t, ts.get("/leaderboard", "X-Api-Key", "ek_nope"), http.StatusUnauthorized, ErrCodeUnauthorized)
+	})
+}
+
+func TestRevokedAPIKeyStopsWorking(t *testing.T) {
+	eachAdminStore(t, func(t *testing.T, ts *testServer) {
+		created := ts.createAPIKey(ScopeLeaderboardRead)
+		key := []string{"X-Api-Key", created.Key}
+		// Another instance that has the key cached
+		other := newTestServerWith(t, ts.store, testConfig(t, map[string]string{"ADMIN_TOKEN": testAdminToken}))
+		decodeOK[LeaderboardResponse](t, ts.get("/leaderboard", key...))
+		decodeOK[LeaderboardResponse](t, other.get("/leaderboard", key...))
+
+		decodeOK[RevokeAPIKeyResponse](t, ts.request(http.MethodDelete, "/admin/apikeys/"+created.APIKey.ID, nil, asAdmin...))
+		assertError(t, ts.get("/leaderboard", key...), http.StatusUnauthorized, ErrCodeUnauthorized)
+		// The other instance trusts its cache until it goes stale
+		decodeOK[LeaderboardResponse](t, other.get("/leaderboard", key...))
+		other.clock.Advance(defaultAPIKeyCacheTTL)
+		assertError(t, other.get("/leaderboard", key...), http.StatusUnauthorized, ErrCodeUnauthorized)
+
+		assertError(t, ts.request(http.MethodDelete, "/admin/apikeys/"+created.APIKey.ID, nil, asAdmin...), http.StatusNotFound, ErrCodeAPIKeyNotFound)
+		if keys := decodeOK[APIKeysResponse](t, ts.get("/admin/apikeys", asAdmin...)).APIKeys; len(keys) != 0 {
+			t.Fatalf("keys after the revocation = %+v", keys)
+		}
+	})
+}
+
+func TestAPIKeyRateLimit(t *testing.T) {
+	eachAdminStore(t, func(t *testing.T, ts *testServer) {
+		first := []string{"X-Api-Key", ts.createAPIKey(ScopeLeaderboardRead).Key}
+		second := []string{"X-Api-Key", ts.createAPIKey(ScopeLeaderboardRead).Key}
+		for i := 0; i < apiKeyBurst; i++ {
+			decodeOK[LeaderboardResponse](t, ts.get("/leaderboard", first...))
+		}
+		assertError(t, ts.get("/leaderboard", first...), http.StatusTooManyRequests, ErrCodeRateLimited)
+		// Each key has its own bucket, and players aren't held to it
+		decodeOK[LeaderboardResponse](t, ts.get("/leaderboard", second...))
+		decodeOK[LeaderboardResponse](t, ts.get("/leaderboard"))
+
+		// A token comes back every 60s/defaultAPIKeyRate
+		ts.clock.Advance(time.Minute / defaultAPIKeyRate)
+		decodeOK[LeaderboardResponse](t, ts.get("/leaderboard", first...))
+		assertError(t, ts.get("/leaderboard", first...), http.StatusTooManyRequests, ErrCodeRateLimited)
+	})
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Entries GET /admin/audit returns unless ?limit= says otherwise
+const (
+	defaultAuditPage = 100
+	maxAuditPage     = 1000
+)
+
+// Who changed stats on behalf of the admin API
+const adminActor = "admin"
+
+// An entry in the audit log of admin actions and stat changes
+type AuditEntry struct {
+	// The entry's stream ID, set when the log is read back
+	ID string `json:"id,omitempty"`
+	// adminActor, or the player whose request or move changed the stats
+	Actor  string `json:"actor,omitempty"`
+	Action string `json:"action"`
+	// The user acted on, if any
+	Username string      `json:"username,omitempty"`
+	Before   interface{} `json:"before,omitempty"`
+	After    interface{} `json:"after,omitempty"`
+	// The X-Request-ID of the request behind the change
+	RequestID string    `json:"requestId,omitempty"`
+	Remote    string    `json:"remote,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+type requestIDContextKey struct{}
+
+// A client's X-Request-ID is kept if it is this short and plain
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+// Gin middleware giving every request an ID, the client's X-Request-ID or a
+// fresh one, echoed in the response and attached to the request's context
+// for the audit log
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Header("X-Request-ID", id)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDContextKey{}, id))
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
+
+// The ID of the request ctx belongs to, or "" outside a request, e.g. for a
+// timed-out turn
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// An audit entry for an action taken now by actor, in the request ctx
+// belongs to
+func (s *Server) newAuditEntry(ctx context.Context, actor, action, username string) AuditEntry {
+	return AuditEntry{
+		Actor:     actor,
+		Action:    action,
+		Username:  username,
+		RequestID: requestIDFrom(ctx),
+		At:        s.clock.Now().UTC(),
+	}
+}
+
+// An audit entry for an admin action taken in the request
+func (s *Server) adminAuditEntry(c *gin.Context, action, username string) AuditEntry {
+	entry := s.newAuditEntry(c.Request.Context(), adminActor, action, username)
+	entry.Remote = c.ClientIP()
+	return entry
+}
+
+// Record an admin action that no store write covers. A failed write is
+// logged rather than undoing the action.
+func (s *Server) audit(c *gin.Context, entry AuditEntry) {
+	if err := s.store.AppendAudit(c.Request.Context(), entry); err != nil {
+		log.Printf("Error writing audit entry %s for user %s: %v", entry.Action, entry.Username, err)
+	}
+}
+
+// A Redis stream ID: milliseconds, then a sequence within the millisecond
+type streamID struct {
+	ms, seq int64
+}
+
+var streamIDPattern = regexp.MustCompile(`^\d+(-\d+)?$`)
+
+// Parse a full or milliseconds-only stream ID, as ?since= takes
+func parseStreamID(id string) (streamID, bool) {
+	if !streamIDPattern.MatchString(id) {
+		return streamID{}, false
+	}
+	ms, seq, _ := strings.Cut(id, "-")
+	var parsed streamID
+	var err error
+	if parsed.ms, err = strconv.ParseInt(ms, 10, 64); err != nil {
+		return streamID{}, false
+	}
+	if seq == "" {
+		// Everything in that millisecond is at or before it
+		parsed.seq = -1
+		return parsed, true
+	}
+	if parsed.seq, err = strconv.ParseInt(seq, 10, 64); err != nil {
+		return streamID{}, false
+	}
+	return parsed, true
+}
+
+// The first ID after id, for an exclusive XRANGE start
+func (id streamID) next() streamID {
+	if id.seq == -1 {
+		return streamID{ms: id.ms + 1}
+	}
+	return streamID{ms: id.ms, seq: id.seq + 1}
+}
+
+func (id streamID) after(other streamID) bool {
+	return id.ms > other.ms || (id.ms == other.ms && id.seq > other.seq)
+}
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+// Admin audit route: up to ?limit= entries after the ?since= stream ID,
+// oldest first. Pass the response's next as since to page on.
+func (s *Server) adminAudit(c *gin.Context) {
+	var since *streamID
+	if raw := c.Query("since"); raw != "" {
+		id, ok := parseStreamID(raw)
+		if !ok {
+			abortWithError(c, errInvalidRequest("since must be an audit entry ID"))
+			return
+		}
+		since = &id
+	}
+	limit := defaultAuditPage
+	if raw := c.Query("limit"); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxAuditPage {
+			abortWithError(c, errInvalidRequest("limit must be between 1 and "+strconv.Itoa(maxAuditPage)))
+			return
+		}
+	}
+
+	entries, err := s.store.AuditLog(c.Request.Context(), since, limit)
+	if err != nil {
+		log.Printf("Error reading audit log: %v", err)
+		abortWithError(c, errStoreUnavailable("Error reading audit log"))
+		return
+	}
+	response := AdminAuditResponse{Entries: entries, Next: c.Query("since")}
+	if len(entries) > 0 {
+		response.Next = entries[len(entries)-1].ID
+	}
+	if response.Entries == nil {
+		response.Entries = []AuditEntry{}
+	}
+	c.JSON(http.StatusOK, response)
+}
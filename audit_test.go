@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// An audit entry's before or after value, decoded as a T
+func auditValue[T any](t *testing.T, value interface{}) T {
+	t.Helper()
+	var v T
+	encoded, _ := json.Marshal(value)
+	if err := json.Unmarshal(encoded, &v); err != nil {
+		t.Fatalf("decoding %s: %v", encoded, err)
+	}
+	return v
+}
+
+func TestAdminStatOverrideIsAudited(t *testing.T) {
+	eachAdminStore(t, func(t *testing.T, ts *testServer) {
+		ts.winSoloGame("alice")
+		win, lose := int64(7), int64(3)
+		headers := append([]string{"X-Request-ID", "override-1"}, asAdmin...)
+		decodeOK[AdminStatsResponse](t, ts.post("/admin/users/alice/stats", AdminStatsRequest{Win: &win, Lose: &lose}, headers...))
+
+		entries := decodeOK[AdminAuditResponse](t, ts.get("/admin/audit", asAdmin...)).Entries
+		// After the stats alice's first game set up
+		if len(entries) != 3 || entries[0].Action != "reset_stats" {
+			t.Fatalf("audit = %+v, want the new user, the win and the override", entries)
+		}
+		won, override := entries[1], entries[2]
+		if won.Action != winKey || won.Actor != "alice" || won.Username != "alice" {
+			t.Fatalf("win entry = %+v", won)
+		}
+		if before, after := auditValue[map[string]int64](t, won.Before), auditValue[map[string]int64](t, won.After); before["wins"] != 0 || after["wins"] != 1 {
+			t.Fatalf("win entry went from %v to %v", before, after)
+		}
+
+		if override.Action != "set_stats" || override.Actor != adminActor || override.Username != "alice" ||
+			override.RequestID != "override-1" || !override.At.Equal(ts.clock.Now()) {
+			t.Fatalf("override entry = %+v", override)
+		}
+		before, after := auditValue[PlayerStats](t, override.Before), auditValue[PlayerStats](t, override.After)
+		if before != (PlayerStats{Wins: 1}) || after != (PlayerStats{Wins: 7, Losses: 3}) {
+			t.Fatalf("override went from %+v to %+v, want 1/0 to 7/3", before, after)
+		}
+
+		// Paging on from the win finds only the override
+		page := decodeOK[AdminAuditResponse](t, ts.get("/admin/audit?limit=10&since="+won.ID, asAdmin...))
+		if len(page.Entries) != 1 || page.Entries[0].ID != override.ID || page.Next != override.ID {
+			t.Fatalf("page after %s = %+v", won.ID, page)
+		}
+	})
+}
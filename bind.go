@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// What can be wrong with a request body, as FieldProblem.Problem reports it
+const (
+	// There is no body
+	ProblemEmpty = "empty"
+	// The body isn't JSON
+	ProblemSyntax = "syntax"
+	// A field, or the body, holds the wrong kind of value
+	ProblemType = "type"
+	// A required field is absent, null or empty
+	ProblemMissing = "missing"
+	// The body has a field the request doesn't take
+	ProblemUnknown = "unknown"
+)
+
+// One problem with a request body, listed by ERR_INVALID_REQUEST
+type FieldProblem struct {
+	// Path of the field, e.g. "username"; "" for the body as a whole
+	Field   string `json:"field"`
+	Problem string `json:"problem"`
+	Message string `json:"message"`
+}
+
+// Decode the request's JSON body into v, a pointer to a request struct,
+// refusing fields it doesn't have and requiring those tagged
+// binding:"required". Field names must match exactly. Every problem found
+// is listed in the 400 returned, so a client can fix them all at once.
+func bindStrict(c *gin.Context, v interface{}) *APIError {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Printf("Error reading request body: %v", err)
+		return errInvalidRequest("Error reading request body")
+	}
+	if problems := bodyProblems(body, reflect.TypeOf(v).Elem()); len(problems) > 0 {
+		log.Printf("Rejected request body: %+v", problems)
+		return errMalformedBody(problems)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		// bodyProblems passed the body, so it should decode
+		log.Printf("Error parsing request: %v", err)
+		return errInvalidRequest("Invalid request")
+	}
+	return nil
+}
+
+// Every problem with body as the JSON of a t
+func bodyProblems(body []byte, t reflect.Type) []FieldProblem {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return []FieldProblem{{Problem: ProblemEmpty, Message: "Request body is empty"}}
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return []FieldProblem{{Problem: ProblemSyntax, Message: fmt.Sprintf("Malformed JSON at byte %d: %v", syntaxErr.Offset, syntaxErr)}}
+		}
+		return []FieldProblem{{Problem: ProblemType, Message: "Request body must be a JSON object"}}
+	}
+	if object == nil {
+		return []FieldProblem{{Problem: ProblemType, Message: "Request body must be a JSON object"}}
+	}
+
+	fields := jsonFields(t)
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []FieldProblem
+	for _, name := range names {
+		field, ok := fields[name]
+		if !ok {
+			problems = append(problems, FieldProblem{Field: name, Problem: ProblemUnknown, Message: fmt.Sprintf("Unknown field %q", name)})
+			continue
+		}
+		if problem, ok := fieldProblem(name, object[name], field.Type); !ok {
+			problems = append(problems, problem)
+		}
+	}
+	for _, name := range requiredFields(t) {
+		value := reflect.New(fields[name].Type)
+		if raw, ok := object[name]; ok {
+			if json.Unmarshal(raw, value.Interface()) != nil {
+				// Already reported as the wrong type
+				continue
+			}
+		}
+		if value.Elem().IsZero() {
+			problems = append(problems, FieldProblem{Field: name, Problem: ProblemMissing, Message: fmt.Sprintf("%s is required", name)})
+		}
+	}
+	return problems
+}
+
+// The problem with the value of the named field, if it doesn't decode as a
+// value of type t
+func fieldProblem(name string, raw json.RawMessage, t reflect.Type) (FieldProblem, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(reflect.New(t).Interface())
+	if err == nil {
+		return FieldProblem{}, true
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		path := name
+		if typeErr.Field != "" {
+			path += "." + typeErr.Field
+		}
+		return FieldProblem{Field: path, Problem: ProblemType, Message: fmt.Sprintf("%s must be %s, not %s", path, jsonKind(typeErr.Type), typeErr.Value)}, false
+	}
+	// A nested object with a field its type doesn't have
+	return FieldProblem{Field: name, Problem: ProblemUnknown, Message: fmt.Sprintf("%s: %s", name, strings.TrimPrefix(err.Error(), "json: "))}, false
+}
+
+// The fields of a struct type by JSON name, including those of embedded
+// structs
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for embeddedName, embedded := range jsonFields(field.Type) {
+				fields[embeddedName] = embedded
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// The JSON names of a struct type's fields tagged binding:"required"
+func requiredFields(t reflect.Type) []string {
+	var required []string
+	for name, field := range jsonFields(t) {
+		if field.Tag.Get("binding") == "required" {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required)
+	return required
+}
+
+// How a problem names the JSON kind of a Go type
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The field and kind of each problem, leaving out the messages
+func problemKinds(problems []FieldProblem) []string {
+	kinds := make([]string, len(problems))
+	for i, problem := range problems {
+		kinds[i] = problem.Field + ":" + problem.Problem
+	}
+	return kinds
+}
+
+func TestMalformedBodiesListTheirProblems(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		guest := ts.guest()
+		endpoints := []struct {
+			method, path string
+			headers      []string
+			// Bodies that are JSON objects, and the problems each has there
+			objects map[string][]string
+		}{
+			{http.MethodPost, "/start-game", nil, map[string][]string{
+				`{}`:                                   {"username:missing"},
+				`{"username": 5, "gameId": ["alice"]}`: {"gameId:type", "username:type"},
+				`{"username": "alice", "usrname": "bob"}`: {"usrname:unknown"},
+				`{"username": "", "mode": null}`:          {"username:missing"},
+			}},
+			{http.MethodPost, "/draw-card", nil, map[string][]string{
+				`{}`:                                    {"username:missing"},
+				`{"username": "alice", "requestId": 1}`: {"requestId:type"},
+				`{"username": "alice", "count": 2}`:     {"count:unknown"},
+			}},
+			{http.MethodPost, "/play-card", nil, map[string][]string{
+				`{}`:                    {"card:missing", "username:missing"},
+				`{"username": "alice"}`: {"card:missing"},
+				`{"username": "alice", "card": "Skip", "target": "bob"}`: {"target:unknown"},
+				`{"username": true, "card": 7, "extra": {}}`:             {"card:type", "extra:unknown", "username:type"},
+			}},
+			{http.MethodPut, "/profile", bearer(guest.Token), map[string][]string{
+				`{"displayName": 5}`:                            {"displayName:type"},
+				`{"displayName": "Alice", "username": "alice"}`: {"username:unknown"},
+			}},
+		}
+		for _, endpoint := range endpoints {
+			name := endpoint.method + " " + endpoint.path
+			bodies := map[string][]string{
+				"":                      {":empty"},
+				"   ":                   {":empty"},
+				`{"username": "alice",`: {":syntax"},
+				`["alice"]`:             {":type"},
+				`null`:                  {":type"},
+			}
+			for body, want := range endpoint.objects {
+				bodies[body] = want
+			}
+			for body, want := range bodies {
+				apiErr := assertError(t, ts.request(endpoint.method, endpoint.path, body, endpoint.headers...), http.StatusBadRequest, ErrCodeInvalidRequest)
+				if got := problemKinds(apiErr.Details); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s with %q: problems %v, want %v", name, body, got, want)
+				}
+			}
+		}
+	})
+}
+
+func TestProblemMessagesNameTheField(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	apiErr := assertError(t, ts.request(http.MethodPost, "/play-card", `{"username": "alice", "card": 7}`), http.StatusBadRequest, ErrCodeInvalidRequest)
+	want := []FieldProblem{{Field: "card", Problem: ProblemType, Message: "card must be a string, not number"}}
+	if !reflect.DeepEqual(apiErr.Details, want) {
+		t.Fatalf("problems = %+v, want %+v", apiErr.Details, want)
+	}
+
+	apiErr = assertError(t, ts.request(http.MethodPost, "/draw-card", `{"username": "alice"} {}`), http.StatusBadRequest, ErrCodeInvalidRequest)
+	if len(apiErr.Details) != 1 || apiErr.Details[0].Problem != ProblemSyntax || !strings.HasPrefix(apiErr.Details[0].Message, "Malformed JSON at byte ") {
+		t.Fatalf("problems with trailing data = %+v", apiErr.Details)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"exploding-kitten/engine"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long a player has to decide about a bomb unless BOMB_DECISION_TIMEOUT
+// says otherwise
+const defaultBombDecisionTimeout = 15 * time.Second
+
+// The game is blocked on a player deciding whether to spend a Defuse on the
+// bomb they drew; see Server.holdBomb
+const blockPendingDefuse = "pending_defuse"
+
+type ResolveBombRequest struct {
+	Username string `json:"username"`
+	GameID   string `json:"gameId"`
+	// Whether to spend a Defuse on the bomb; false accepts the explosion.
+	// Required.
+	UseDefuse *bool `json:"useDefuse"`
+}
+
+// A bomb drawn by a player holding a Defuse isn't settled yet: the game is
+// blocked until they say whether to use the Defuse, or until bombTimeout,
+// when it is used for them. The room learns a bomb was drawn, but not what
+// the player does about it until they decide. defuses is how many they could
+// spend on it.
+func (s *Server) holdBomb(ctx context.Context, game *GameSession, response *DrawCardResponse, defuses int) (*DrawCardResponse, *APIError) {
+	deadline := s.clock.Now().Add(s.bombTimeout)
+	if err := s.store.SetPendingBomb(ctx, game.ID, game.Username, deadline); err != nil {
+		log.Printf("Error holding the bomb of game %s: %v", game.ID, err)
+		return nil, errStoreUnavailable("Error updating game")
+	}
+	s.startBombTimer(game.ID, game.Username, deadline)
+	logGameEvent(game.Username, game.ID, map[string]any{"event": "bomb_pending", "defuses": defuses})
+
+	at := deadline.UTC()
+	response.Disposition = DispositionPendingDefuse
+	response.GameStatus = GameStatusPendingDefuse
+	response.DefuseCount = defuses
+	response.BombDeadline = &at
+	response.MessageID = MsgBombPending
+	response.Message = localize(ctx, MsgBombPending, int(s.bombTimeout/time.Second))
+	s.announceDraw(game, response)
+	return response, nil
+}
+
+// Settle the bomb the player is deciding about. The turn passes on once it
+// is defused; accepting it is an explosion like any other.
+func (s *Server) resolveBomb(ctx context.Context, req ResolveBombRequest) (*DrawCardResponse, *APIError) {
+	if req.UseDefuse == nil {
+		return nil, errInvalidRequest("useDefuse is required")
+	}
+	game, apiErr := s.resolveMove(ctx, User{Username: req.Username, GameID: req.GameID})
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	useDefuse := *req.UseDefuse
+	_, deadline, err := s.store.PendingBomb(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error checking pending bomb of game %s: %v", game.ID, err)
+		return nil, errStoreUnavailable("Error checking game status")
+	}
+	if !deadline.IsZero() && s.clock.Now().After(deadline) {
+		// Too late to choose: the timer is about to use the Defuse anyway
+		useDefuse = true
+	}
+	return s.settleBomb(ctx, game, useDefuse)
+}
+
+func (s *Server) settleBomb(ctx context.Context, game *GameSession, useDefuse bool) (*DrawCardResponse, *APIError) {
+	username := game.Username
+	if apiErr := s.checkGameActive(ctx, game); apiErr != nil {
+		return nil, apiErr
+	}
+	// The turn stays with the player until the bomb is settled
+	if game.Room != nil {
+		if apiErr := s.checkTurn(game.Room, username); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+
+	// Only one of the player's decision and the timer settles the bomb
+	claimed, err := s.store.ClaimPendingBomb(ctx, game.ID, username)
+	if err != nil {
+		log.Printf("Error claiming pending bomb of game %s: %v", game.ID, err)
+		return nil, errStoreUnavailable("Error updating game")
+	}
+	if !claimed {
+		return nil, errInvalidRequest("You have no bomb to decide about")
+	}
+	s.stopBombTimer(game.ID)
+	if apiErr := s.loadMode(ctx, game); apiErr != nil {
+		return nil, apiErr
+	}
+
+	card := cardFor(cardTheme(ctx, game), engine.ExplodingKitten)
+	response := &DrawCardResponse{Card: card, GameStatus: GameStatusActive, Effects: []DrawEffect{}}
+	deck, err := s.store.GetDeck(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error retrieving deck for game %s: %v", game.ID, err)
+		return nil, errStoreUnavailable("Error retrieving deck")
+	}
+	held, err := s.store.GetDefuse(ctx, username)
+	if err != nil {
+		log.Printf("Error retrieving defuse status for user %s: %v", username, err)
+		return nil, errStoreUnavailable("Error retrieving defuse status")
+	}
+	defuseCount, apiErr := s.pooledDefuses(ctx, game, held)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if !useDefuse {
+		defuseCount = 0
+	}
+
+	// A defused bomb goes back in where the game's seeded source puts it
+	var rng engine.RNG
+	if defuseCount > 0 {
+		if rng, err = s.gameRNG(ctx, game.ID); err != nil {
+			log.Printf("Error retrieving the seeded source of game %s: %v", game.ID, err)
+			return nil, errStoreUnavailable("Error retrieving game")
+		}
+	}
+	rules := &engine.Game{DefuseCount: defuseCount, Mode: game.Mode, Deck: deck}
+	event := rules.Settle(engine.ExplodingKitten, cardEffect(engine.ExplodingKitten), rng)
+	if rng != nil {
+		if err := s.saveGameRNG(ctx, game.ID, rng); err != nil {
+			log.Printf("Error saving the seeded source of game %s: %v", game.ID, err)
+			return nil, errStoreUnavailable("Error updating game")
+		}
+	}
+	response.Disposition = dispositionOf(event.Type)
+	response.Remaining = len(deck)
+
+	if event.Type == engine.Exploded {
+		logGameEvent(username, game.ID, map[string]any{"event": "exploded", "defuses": defuseCount, "declined": !useDefuse})
+		response.Effects = append(response.Effects, DrawEffect{Type: EffectGameLost})
+		s.announceDraw(game, response)
+		explosion, apiErr := s.handleExplosion(ctx, game, card)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		explosion.Disposition = response.Disposition
+		explosion.Effects = response.Effects
+		explosion.Remaining = response.Remaining
+		explosion.Version = s.gameVersion(ctx, game.ID)
+		explosion.Summary = game.summaries[username]
+		s.recordMove(ctx, game, MoveResolveBomb, "", explosion.GameStatus)
+		return explosion, nil
+	}
+
+	left, err := s.spendDefuse(ctx, game, defuseCount)
+	if err != nil {
+		log.Printf("Error using defuse for user %s: %v", username, err)
+		return nil, errStoreUnavailable("Error updating defuse status")
+	}
+	logGameEvent(username, game.ID, map[string]any{"event": "bomb_defused", "defusesBefore": defuseCount, "defusesLeft": left})
+	if err := s.store.InsertCard(ctx, game.ID, engine.ExplodingKitten, event.Position); err != nil {
+		log.Printf("Error putting the bomb back into game %s: %v", game.ID, err)
+		return nil, errStoreUnavailable("Error updating deck")
+	}
+	response.Effects = append(response.Effects, defuseEffect(EffectDefuseConsumed, left), DrawEffect{Type: EffectBombReturned})
+	response.MessageID = MsgBombDefused
+	response.Message = localize(ctx, MsgBombDefused)
+	response.DefuseCount = left
+	response.Remaining++
+	// rules.Deck has the bomb back in it
+	response.InevitableLoss = game.Room == nil && rules.ForcedOutcome() == engine.StatusLost
+	if s.shouldFastForward(game, response.InevitableLoss, left) {
+		if apiErr := s.fastForwardLoss(ctx, game, response); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	s.recordMove(ctx, game, MoveResolveBomb, engine.Defuse, response.GameStatus)
+
+	// The bomb was already revealed when it was drawn
+	if game.Room != nil {
+		s.hub.broadcastRoom(game.Room.Code, RoomEvent{Type: "bomb_defused", Username: username, Card: findCard(cardTheme(ctx, game), engine.ExplodingKitten)})
+	}
+	if _, err := s.finishDraws(ctx, game, 1); err != nil {
+		log.Printf("Error ending turn of user %s in game %s: %v", username, game.ID, err)
+		return nil, errStoreUnavailable("Error ending turn")
+	}
+	response.Version = s.gameVersion(ctx, game.ID)
+	response.Summary = game.summaries[username]
+	return response, nil
+}
+
+// Resolve bomb route
+func (s *Server) resolveBombRoute(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req ResolveBombRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+
+	response, apiErr := s.resolveBomb(ctx, req)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	addLegacyCardText(c, response)
+	c.JSON(http.StatusOK, response)
+}
+
+// Use the player's Defuse for them once deadline passes
+func (s *Server) startBombTimer(gameID, username string, deadline time.Time) {
+	s.timerMutex.Lock()
+	defer s.timerMutex.Unlock()
+
+	if timer := s.bombTimers[gameID]; timer != nil {
+		timer.Stop()
+	}
+	s.bombTimers[gameID] = s.clock.AfterFunc(deadline.Sub(s.clock.Now()), func() { s.bombTimedOut(gameID, username) })
+}
+
+func (s *Server) stopBombTimer(gameID string) {
+	s.timerMutex.Lock()
+	defer s.timerMutex.Unlock()
+
+	if timer := s.bombTimers[gameID]; timer != nil {
+		timer.Stop()
+		delete(s.bombTimers, gameID)
+	}
+}
+
+// The player didn't decide in time: their Defuse is used for them
+func (s *Server) bombTimedOut(gameID, username string) {
+	ctx := context.Background()
+
+	game, apiErr := s.resolveGame(ctx, User{Username: username, GameID: gameID})
+	if apiErr != nil {
+		log.Printf("Error loading game %s for bomb timeout: %s", gameID, apiErr.Message)
+		return
+	}
+	// Nobody can decide while the game is paused, so look again a whole
+	// decision window later
+	if game.Room != nil && game.Room.Pause != nil {
+		s.startBombTimer(gameID, username, s.clock.Now().Add(s.bombTimeout))
+		return
+	}
+	log.Printf("User %s didn't decide about the bomb in game %s in time, using their Defuse", username, gameID)
+	if _, apiErr := s.settleBomb(ctx, game, true); apiErr != nil {
+		// Most likely the player decided just as the timer fired
+		log.Printf("Error settling the bomb of game %s: %s", gameID, apiErr.Message)
+	}
+}
+
+// Pick the clock of a bomb still waiting on a decision back up after a
+// restart. One whose deadline passed while the server was down is defused
+// now.
+func (s *Server) restorePendingBomb(ctx context.Context, gameID string) {
+	username, deadline, err := s.store.PendingBomb(ctx, gameID)
+	if err != nil {
+		log.Printf("Error checking pending bomb of game %s: %v", gameID, err)
+		return
+	}
+	if username == "" {
+		return
+	}
+	if deadline.IsZero() {
+		deadline = s.clock.Now().Add(s.bombTimeout)
+	}
+	s.startBombTimer(gameID, username, deadline)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// Start a solo game whose first draw is a bomb drawn holding a Defuse, and
+// draw it
+func (ts *testServer) drawPendingBomb(username string) DrawCardResponse {
+	ts.t.Helper()
+	ts.startGame(username, engine.ExplodingKitten, "Cat", "Cat")
+	ts.deal(username, engine.Defuse)
+	drawn := decodeOK[DrawCardResponse](ts.t, ts.draw(username))
+	if drawn.Disposition != DispositionPendingDefuse || drawn.GameStatus != GameStatusPendingDefuse || drawn.DefuseCount != 1 {
+		ts.t.Fatalf("bomb drawn with a Defuse = %+v", drawn)
+	}
+	if want := ts.clock.Now().Add(ts.bombTimeout); drawn.BombDeadline == nil || !drawn.BombDeadline.Equal(want) {
+		ts.t.Fatalf("deadline = %v, want %v", drawn.BombDeadline, want)
+	}
+	return drawn
+}
+
+func TestPendingBombBlocksTheGame(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.drawPendingBomb("alice")
+
+		// A refresh finds the game waiting on the decision
+		snapshot := decodeOK[GameSnapshot](t, ts.get("/game/alice/snapshot?username=alice"))
+		if snapshot.Status != GameStatusPendingDefuse || snapshot.MustDiscard != "alice" || snapshot.BlockedCause != blockPendingDefuse {
+			t.Fatalf("snapshot = %s, blocked on %q for %q", snapshot.Status, snapshot.MustDiscard, snapshot.BlockedCause)
+		}
+		assertError(t, ts.draw("alice"), http.StatusConflict, ErrCodeBombPending)
+		assertError(t, ts.post("/draw-cards", DrawCardsRequest{Username: "alice", Count: 2}), http.StatusConflict, ErrCodeBombPending)
+		assertError(t, ts.post("/resolve-bomb", User{Username: "alice"}), http.StatusBadRequest, ErrCodeInvalidRequest)
+		if deck := ts.deck("alice"); len(deck) != 2 {
+			t.Fatalf("deck while the bomb is pending = %v", deck)
+		}
+	})
+}
+
+func TestAcceptingTheDefuseReturnsTheBomb(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.drawPendingBomb("alice")
+
+		defused := ts.resolveBomb("alice", true)
+		if defused.Disposition != DispositionDefused || defused.GameStatus != GameStatusActive || defused.DefuseCount != 0 || defused.Remaining != 3 {
+			t.Fatalf("defusing = %+v", defused)
+		}
+		if counts := countCards(ts.deck("alice")); counts[engine.ExplodingKitten] != 1 || counts["Cat"] != 2 {
+			t.Fatalf("deck after defusing = %v", ts.deck("alice"))
+		}
+		// The game goes on, and there is nothing left to decide
+		assertError(t, ts.post("/resolve-bomb", ResolveBombRequest{Username: "alice", UseDefuse: new(bool)}), http.StatusBadRequest, ErrCodeInvalidRequest)
+		ts.timerMutex.Lock()
+		timer := ts.bombTimers["alice"]
+		ts.timerMutex.Unlock()
+		if timer != nil {
+			t.Fatal("the bomb's timer is still set after the decision")
+		}
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+	})
+}
+
+func TestDecliningTheDefuseExplodes(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.drawPendingBomb("alice")
+
+		exploded := ts.resolveBomb("alice", false)
+		if exploded.Disposition != DispositionExploded || exploded.GameStatus != GameStatusLost || exploded.Losses != 1 {
+			t.Fatalf("declining = %+v", exploded)
+		}
+		if win, lose := ts.stats("alice"); win != 0 || lose != 1 {
+			t.Fatalf("stats = %d/%d, want 0/1", win, lose)
+		}
+		assertError(t, ts.draw("alice"), http.StatusConflict, ErrCodeGameFinished)
+		// The timer doesn't settle it again
+		ts.clock.Advance(ts.bombTimeout)
+		if win, lose := ts.stats("alice"); win != 0 || lose != 1 {
+			t.Fatalf("stats after the deadline = %d/%d", win, lose)
+		}
+	})
+}
+
+func TestUndecidedBombIsDefusedAtDeadline(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.drawPendingBomb("alice")
+
+		ts.clock.Advance(ts.bombTimeout - 1)
+		if status := decodeOK[GameSnapshot](t, ts.get("/game/alice/snapshot?username=alice")).Status; status != GameStatusPendingDefuse {
+			t.Fatalf("status just before the deadline = %s", status)
+		}
+		ts.clock.Advance(1)
+		snapshot := decodeOK[GameSnapshot](t, ts.get("/game/alice/snapshot?username=alice"))
+		if snapshot.Status != GameStatusActive || snapshot.MustDiscard != "" || snapshot.Remaining != 3 {
+			t.Fatalf("snapshot after the deadline = %+v", snapshot)
+		}
+		if counts := countCards(ts.deck("alice")); counts[engine.ExplodingKitten] != 1 {
+			t.Fatalf("deck after the timeout = %v", ts.deck("alice"))
+		}
+		if win, lose := ts.stats("alice"); win != 0 || lose != 0 {
+			t.Fatalf("stats = %d/%d after the Defuse was used", win, lose)
+		}
+	})
+}
+
+func TestRoomHearsOfPendingBombButNotTheChoice(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		player := room.Turn
+		socket := ts.dial("room=" + room.Code)
+		socket.next("snapshot")
+		ts.setDeck(room.gameID(), engine.ExplodingKitten, "Cat", "Cat")
+		ts.deal(player, engine.Defuse)
+
+		drawn := decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: player, GameID: room.gameID()}))
+		if drawn.Disposition != DispositionPendingDefuse {
+			t.Fatalf("draw = %+v", drawn)
+		}
+		ts.clock.Advance(ts.revealDelay)
+		event := decodeMessage[RoomEvent](t, socket.next("card_drawn"))
+		if event.Username != player || event.Disposition != DispositionPendingDefuse || len(event.Effects) != 0 {
+			t.Fatalf("card_drawn = %+v", event)
+		}
+
+		useDefuse := true
+		decodeOK[DrawCardResponse](t, ts.post("/resolve-bomb", ResolveBombRequest{Username: player, GameID: room.gameID(), UseDefuse: &useDefuse}))
+		if defused := decodeMessage[RoomEvent](t, socket.next("bomb_defused")); defused.Username != player {
+			t.Fatalf("bomb_defused = %+v", defused)
+		}
+		if turn := ts.room(room.Code).Turn; turn == player {
+			t.Fatalf("turn stayed with %s after the bomb was defused", player)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Bot difficulties
+const (
+	// Draws every turn and plays a held Skip at random
+	BotRandom = "random"
+	// Plays a held Skip when the odds of drawing a bomb are high and it has
+	// no Defuse to fall back on
+	BotBasic = "basic"
+)
+
+// Prefix of bot player names. Usernames can't contain ':', so a bot never
+// collides with a human player.
+const botPrefix = "bot:"
+
+// The bot waits this long plus up to another second before moving, so its
+// turns read like a human's
+const botThinkTime = time.Second
+
+// Bomb odds at which the basic bot would rather Skip than draw
+const botSkipOdds = 1.0 / 3
+
+func isBot(username string) bool {
+	return strings.HasPrefix(username, botPrefix)
+}
+
+// The bot player seated in the given room
+func botName(code string) string {
+	return botPrefix + code
+}
+
+func validBotDifficulty(difficulty string) bool {
+	return difficulty == BotRandom || difficulty == BotBasic
+}
+
+// Have the bot take its turn in the room after a short pause
+func (s *Server) scheduleBotTurn(code string) {
+	delay := botThinkTime + time.Duration(rand.Int63n(int64(time.Second)))
+	s.clock.AfterFunc(delay, func() { s.takeBotTurn(code) })
+}
+
+// Play the bot's turn through the same game logic as a human's move
+func (s *Server) takeBotTurn(code string) {
+	ctx := context.Background()
+
+	room, err := s.store.GetRoom(ctx, code)
+	if err != nil || room == nil {
+		log.Printf("Error loading room %s for the bot: %v", code, err)
+		return
+	}
+	bot := botName(code)
+	// A paused game's bot is scheduled again when it resumes
+	if room.Status != RoomActive || room.Turn != bot || room.Pause != nil {
+		return
+	}
+
+	// Wait for a pending action to resolve before moving
+	if s.hasPendingAction(code) {
+		s.scheduleBotTurn(code)
+		return
+	}
+
+	game := &GameSession{ID: room.gameID(), Username: bot, Room: room}
+
+	// Wait for a player over the hand limit to discard
+	if s.checkNotBlocked(ctx, game) != nil {
+		s.scheduleBotTurn(code)
+		return
+	}
+
+	if s.botWantsToSkip(ctx, game) {
+		if _, _, apiErr := s.playFromHand(ctx, game, "Skip"); apiErr == nil {
+			log.Printf("Bot in room %s played a Skip card", code)
+			// Come back in case the Skip gets Noped
+			s.scheduleBotTurn(code)
+			return
+		}
+	}
+
+	if apiErr := s.claimTurn(ctx, room); apiErr != nil {
+		return
+	}
+	response, apiErr := s.performDraw(ctx, game, false)
+	if apiErr != nil && apiErr.Code != ErrCodeDeckEmpty {
+		log.Printf("Error drawing for the bot in room %s: %s", code, apiErr.Message)
+	}
+	if response != nil && response.GameStatus == GameStatusMustDiscard {
+		s.botDiscard(ctx, game)
+	}
+}
+
+// Whether the bot should spend a held Skip instead of drawing
+func (s *Server) botWantsToSkip(ctx context.Context, game *GameSession) bool {
+	hand, err := s.store.GetHand(ctx, game.Username)
+	if err != nil {
+		log.Printf("Error retrieving hand for the bot %s: %v", game.Username, err)
+		return false
+	}
+
+	holding := make(map[string]bool)
+	for _, card := range hand {
+		holding[card] = true
+	}
+	if !holding["Skip"] {
+		return false
+	}
+
+	if game.Room.BotDifficulty == BotRandom {
+		return rand.Intn(2) == 0
+	}
+
+	if holding["Defuse"] {
+		return false
+	}
+
+	// The number of bombs and cards left are public, so this is fair play
+	deck, err := s.store.GetDeck(ctx, game.ID)
+	if err != nil || len(deck) == 0 {
+		return false
+	}
+	bombs := countCards(deck)["Exploding Kitten"]
+	return float64(bombs)/float64(len(deck)) >= botSkipOdds
+}
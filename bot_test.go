@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+func TestBotPlaysGameToCompletion(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		created := decodeOK[RoomResponse](t, ts.post("/create-room", CreateRoomRequest{Username: "alice", VsBot: true, Difficulty: BotBasic}))
+		bot := botName(created.Code)
+		socket := ts.dial("room=" + created.Code)
+
+		// Whoever draws second draws the bomb
+		ts.setDeck(created.GameID, "Cat", "Cat", "Cat", engine.ExplodingKitten)
+		ts.deal("alice")
+		ts.deal(bot)
+		room := ts.room(created.Code)
+		second := room.nextAlive(room.Turn)
+
+		for moves := 0; room.Status == RoomActive; moves++ {
+			if moves > 10 {
+				t.Fatalf("game still going after %d moves: %+v", moves, room)
+			}
+			if room.Turn == "alice" {
+				decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: "alice", GameID: created.GameID}))
+			} else {
+				// Longer than the bot ever thinks
+				ts.clock.Advance(2 * botThinkTime)
+			}
+			room = ts.room(created.Code)
+		}
+		ts.clock.Advance(ts.revealDelay)
+
+		if bot == second {
+			if win, lose := ts.stats("alice"); win != 1 || lose != 0 {
+				t.Fatalf("alice's stats = %d/%d after the bot exploded", win, lose)
+			}
+		} else if win, lose := ts.stats("alice"); win != 0 || lose != 1 {
+			t.Fatalf("alice's stats = %d/%d after exploding", win, lose)
+		}
+
+		// The bot's draws went out to the room like anyone's
+		botDraws := 0
+		for botDraws < 2 {
+			event := decodeMessage[RoomEvent](t, socket.next("card_drawn"))
+			if event.Username == bot {
+				botDraws++
+			}
+		}
+		if over := decodeMessage[RoomEvent](t, socket.next("game_over")); over.Loser != second {
+			t.Fatalf("game_over = %+v, want %s to lose", over, second)
+		}
+	})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// Consecutive connection failures that open the circuit
+const breakerThreshold = 5
+
+// How long an open circuit fails calls before a PING probes Redis again
+const breakerCooldown = 10 * time.Second
+
+// Circuit breaker states, also reported on /healthz
+const (
+	CircuitClosed   = "closed"
+	CircuitHalfOpen = "half-open"
+	CircuitOpen     = "open"
+)
+
+// Values of the redis_circuit_state gauge
+var circuitStateValues = map[string]float64{CircuitClosed: 0, CircuitHalfOpen: 1, CircuitOpen: 2}
+
+// Returned instead of running a Redis command while the circuit is open
+var errCircuitOpen = errors.New("redis circuit breaker is open")
+
+// circuitBreaker is a redis.Hook that stops sending commands to a Redis that
+// keeps failing to answer. After breakerThreshold connection failures in a
+// row every command fails fast for the cooldown; the first command after it
+// sends a PING, and a good answer closes the circuit again. Replies that are
+// Redis errors, such as a script's VERSION_CONFLICT, don't count as failures.
+type circuitBreaker struct {
+	clock     Clock
+	threshold int
+	cooldown  time.Duration
+	// Sends the PING of a half-open circuit
+	probe func(ctx context.Context) error
+
+	mutex    sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+var _ redis.Hook = (*circuitBreaker)(nil)
+
+func newCircuitBreaker(clock Clock, probe func(ctx context.Context) error) *circuitBreaker {
+	b := &circuitBreaker{
+		clock:     clock,
+		threshold: breakerThreshold,
+		cooldown:  breakerCooldown,
+		probe:     probe,
+	}
+	b.setState(CircuitClosed)
+	return b
+}
+
+// Marks the context of the probe PING, which passes an open circuit
+type probeContextKey struct{}
+
+func isProbe(ctx context.Context) bool {
+	return ctx.Value(probeContextKey{}) != nil
+}
+
+// Whether the error says Redis couldn't be reached rather than what it replied
+func connectionError(err error) bool {
+	var redisErr redis.Error
+	return err != nil && err != errCircuitOpen && !errors.As(err, &redisErr) &&
+		!errors.Is(err, context.Canceled)
+}
+
+// Callers hold the mutex
+func (b *circuitBreaker) setState(state string) {
+	b.state = state
+	redisCircuitState.Set(circuitStateValues[state])
+}
+
+// Callers hold the mutex
+func (b *circuitBreaker) open() {
+	b.openedAt = b.clock.Now()
+	b.setState(CircuitOpen)
+}
+
+// Let a command through, or fail it with errCircuitOpen. Once the cooldown
+// is over the first caller probes Redis with a PING and closes the circuit
+// if it answers.
+func (b *circuitBreaker) allow(ctx context.Context) error {
+	if isProbe(ctx) {
+		return nil
+	}
+
+	b.mutex.Lock()
+	if b.state == CircuitClosed {
+		b.mutex.Unlock()
+		return nil
+	}
+	if b.state == CircuitHalfOpen || b.clock.Now().Before(b.openedAt.Add(b.cooldown)) {
+		b.mutex.Unlock()
+		return errCircuitOpen
+	}
+	b.setState(CircuitHalfOpen)
+	b.mutex.Unlock()
+
+	err := b.probe(context.WithValue(ctx, probeContextKey{}, true))
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err != nil {
+		log.Printf("Warning: Redis still unreachable, keeping the circuit open: %v", err)
+		b.open()
+		return errCircuitOpen
+	}
+	log.Println("Redis answered the probe, closing the circuit")
+	b.failures = 0
+	b.setState(CircuitClosed)
+	return nil
+}
+
+// Count a command's outcome towards opening the circuit
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	if isProbe(ctx) || err == errCircuitOpen {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !connectionError(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == CircuitClosed && b.failures >= b.threshold {
+		log.Printf("Warning: %d Redis calls failed in a row, opening the circuit for %v: %v", b.failures, b.cooldown, err)
+		b.open()
+	}
+}
+
+// The circuit's state. A nil breaker, as used without Redis, is always closed.
+func (b *circuitBreaker) State() string {
+	if b == nil {
+		return CircuitClosed
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
+
+// How long callers should wait before retrying, or false if commands go
+// through. An open circuit whose cooldown is over reports false so the next
+// command can probe.
+func (b *circuitBreaker) retryAfter() (time.Duration, bool) {
+	if b == nil {
+		return 0, false
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case CircuitHalfOpen:
+		return time.Second, true
+	case CircuitOpen:
+		if wait := b.openedAt.Add(b.cooldown).Sub(b.clock.Now()); wait > 0 {
+			return wait, true
+		}
+	}
+	return 0, false
+}
+
+func (b *circuitBreaker) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, b.allow(ctx)
+}
+
+func (b *circuitBreaker) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	b.record(ctx, cmd.Err())
+	return nil
+}
+
+func (b *circuitBreaker) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, b.allow(ctx)
+}
+
+// A pipeline fails as a whole when the connection does, so its first
+// connection error is the one that counts
+func (b *circuitBreaker) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if connectionError(cmd.Err()) || cmd.Err() == errCircuitOpen {
+			err = cmd.Err()
+			break
+		}
+	}
+	b.record(ctx, err)
+	return nil
+}
+
+// Whether background work should skip touching the store for now, so loops
+// don't log an error every tick while Redis is down
+func (s *Server) storeTripped() bool {
+	_, open := s.breaker.retryAfter()
+	return open
+}
+
+// Gin middleware failing requests fast with a 503 and Retry-After while the
+// circuit is open. Health checks and metrics still answer.
+func (s *Server) circuitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if path := c.FullPath(); path == "/healthz" || path == "/metrics" {
+			c.Next()
+			return
+		}
+		if wait, open := s.breaker.retryAfter(); open {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			abortWithError(c, errStoreUnavailable("The game store is unavailable, retry later"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// Health route: whether Redis answers and the state of its circuit breaker
+func (s *Server) healthz(c *gin.Context) {
+	response := HealthResponse{Status: "ok", Store: "up", Circuit: s.breaker.State()}
+	if err := s.store.Ping(c.Request.Context()); err != nil {
+		log.Printf("Health check failed: %v", err)
+		response.Status, response.Store = "unavailable", "down"
+		// The ping may have closed or opened the circuit
+		response.Circuit = s.breaker.State()
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+	response.Circuit = s.breaker.State()
+	c.JSON(http.StatusOK, response)
+}
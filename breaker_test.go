@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"exploding-kitten/engine"
+)
+
+// A redis.Hook standing in for a Redis that can be switched off: while down
+// every command fails as if the connection had, and counts as having reached
+// it
+type outage struct {
+	mutex   sync.Mutex
+	down    bool
+	reached int
+}
+
+func (o *outage) set(down bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.down = down
+}
+
+// The commands sent while down since the last call
+func (o *outage) commands() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	reached := o.reached
+	o.reached = 0
+	return reached
+}
+
+func (o *outage) fail() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if !o.down {
+		return nil
+	}
+	o.reached++
+	return errStoreDown
+}
+
+func (o *outage) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, o.fail()
+}
+
+func (o *outage) AfterProcess(ctx context.Context, cmd redis.Cmder) error { return nil }
+
+func (o *outage) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, o.fail()
+}
+
+func (o *outage) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error { return nil }
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	store := newTestRedisStore(t, keyBuilder{})
+	ts := newTestServer(t, store)
+	redisOutage := &outage{}
+	var probed []string
+	ts.breaker = newCircuitBreaker(ts.clock, func(ctx context.Context) error {
+		probed = append(probed, ts.breaker.State())
+		return store.rdb.Ping(ctx).Err()
+	})
+	store.rdb.AddHook(ts.breaker)
+	store.rdb.AddHook(redisOutage)
+	assertCircuit := func(state string) {
+		t.Helper()
+		if got := ts.breaker.State(); got != state {
+			t.Fatalf("circuit is %s, want %s", got, state)
+		}
+		if got := testutil.ToFloat64(redisCircuitState); got != circuitStateValues[state] {
+			t.Fatalf("redis_circuit_state = %v, want %v", got, circuitStateValues[state])
+		}
+	}
+
+	// Closed: requests reach Redis
+	ts.startGame("alice", "Cat", "Cat", "Cat", engine.ExplodingKitten)
+	assertCircuit(CircuitClosed)
+	if health := decodeOK[HealthResponse](t, ts.get("/healthz")); health.Circuit != CircuitClosed {
+		t.Fatalf("health = %+v", health)
+	}
+
+	// Redis goes away, and failed calls open the circuit
+	redisOutage.set(true)
+	for i := 0; ts.breaker.State() == CircuitClosed; i++ {
+		if i == breakerThreshold {
+			t.Fatalf("circuit still closed after %d failed requests", i)
+		}
+		assertError(t, ts.draw("alice"), http.StatusServiceUnavailable, ErrCodeStoreUnavailable)
+	}
+	assertCircuit(CircuitOpen)
+	if !ts.storeTripped() {
+		t.Fatal("background loops would still use the store")
+	}
+	redisOutage.commands()
+
+	// Open: requests fail fast without reaching Redis, and health says why
+	w := ts.draw("alice")
+	assertError(t, w, http.StatusServiceUnavailable, ErrCodeStoreUnavailable)
+	if got := w.Header().Get("Retry-After"); got != strconv.Itoa(int(breakerCooldown.Seconds())) {
+		t.Fatalf("Retry-After = %q", got)
+	}
+	w = ts.get("/healthz")
+	if health := decodeBody[HealthResponse](t, w); w.Code != http.StatusServiceUnavailable || health.Circuit != CircuitOpen {
+		t.Fatalf("health = %d %+v", w.Code, health)
+	}
+	if reached := redisOutage.commands(); reached != 0 {
+		t.Fatalf("%d commands reached Redis through an open circuit", reached)
+	}
+
+	// Half-open: after the cooldown a PING probes Redis, and a failed probe
+	// opens the circuit for another cooldown
+	ts.clock.Advance(breakerCooldown)
+	assertError(t, ts.draw("alice"), http.StatusServiceUnavailable, ErrCodeStoreUnavailable)
+	if len(probed) != 1 || probed[0] != CircuitHalfOpen {
+		t.Fatalf("probed in states %v, want one half-open probe", probed)
+	}
+	if reached := redisOutage.commands(); reached != 1 {
+		t.Fatalf("%d commands reached Redis, want the probe alone", reached)
+	}
+	assertCircuit(CircuitOpen)
+
+	// Redis is back: the next probe closes the circuit and the request goes
+	// through
+	redisOutage.set(false)
+	ts.clock.Advance(breakerCooldown)
+	drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+	if drawn.Card.Type != "Cat" {
+		t.Fatalf("draw = %+v", drawn)
+	}
+	if len(probed) != 2 || probed[1] != CircuitHalfOpen {
+		t.Fatalf("probed in states %v, want two half-open probes", probed)
+	}
+	assertCircuit(CircuitClosed)
+	if health := decodeOK[HealthResponse](t, ts.get("/healthz")); health.Circuit != CircuitClosed {
+		t.Fatalf("health = %+v", health)
+	}
+}
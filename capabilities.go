@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The WebSocket protocol this server speaks. Clients that never say hello
+// are assumed to speak version 1, which predates capabilities.
+const protocolVersion = 2
+
+// Capabilities a client may ask for in its hello. A socket without one gets
+// what version 1 clients understand.
+const (
+	// Leaderboard deltas; without it every leaderboard frame is a snapshot
+	CapLeaderboardDelta = "leaderboard_delta"
+	// "achievement" spectator events; without it they aren't sent
+	CapAchievements = "achievements"
+)
+
+var supportedCapabilities = map[string]bool{
+	CapLeaderboardDelta: true,
+	CapAchievements:     true,
+}
+
+// Event types only sent to sockets with the capability for them. Legacy
+// sockets don't know these and are better off not seeing them.
+var eventCapabilities = map[string]string{
+	"achievement": CapAchievements,
+}
+
+// How long after connecting a socket may say hello. Later it keeps the
+// legacy behavior.
+const helloTimeout = 5 * time.Second
+
+// A client's hello, its first message: the protocol version it speaks and
+// the capabilities it understands
+type HelloRequest struct {
+	Type            string   `json:"type"`
+	ID              string   `json:"id"`
+	ProtocolVersion int      `json:"protocolVersion"`
+	Capabilities    []string `json:"capabilities"`
+}
+
+// The reply to a hello: the server's protocol version and the capabilities
+// it accepted, which the socket gets from then on
+type HelloResponse struct {
+	ProtocolVersion int      `json:"protocolVersion"`
+	Capabilities    []string `json:"capabilities"`
+}
+
+// Agree on the capabilities of a socket saying hello, if it is still the
+// socket's first message and within helloTimeout of connecting
+func (s *Server) hello(session *commandSession, message []byte) (*HelloResponse, *APIError) {
+	if session.commands > 1 || s.clock.Now().Sub(session.opened) > helloTimeout {
+		return nil, errInvalidRequest("hello must be the socket's first message, sent within 5 seconds of connecting")
+	}
+	var req HelloRequest
+	if err := json.Unmarshal(message, &req); err != nil {
+		return nil, errInvalidRequest("Invalid hello")
+	}
+
+	accepted := []string{}
+	for _, capability := range req.Capabilities {
+		if supportedCapabilities[capability] {
+			accepted = append(accepted, capability)
+		}
+	}
+	s.hub.setCapabilities(session.conn, accepted)
+	return &HelloResponse{ProtocolVersion: protocolVersion, Capabilities: accepted}, nil
+}
+
+// Save the capabilities agreed with a socket in its hello
+func (h *Hub) setCapabilities(conn *websocket.Conn, capabilities []string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	set := make(map[string]bool, len(capabilities))
+	for _, capability := range capabilities {
+		set[capability] = true
+	}
+	h.capabilities[conn] = set
+}
+
+// Whether the socket agreed to the capability. Callers hold the mutex.
+func (h *Hub) can(conn *websocket.Conn, capability string) bool {
+	return h.capabilities[conn][capability]
+}
+
+// The capability a socket needs to be sent the message, or "" if every
+// socket gets it
+func requiredCapability(payload []byte) string {
+	var message struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return ""
+	}
+	return eventCapabilities[message.Type]
+}
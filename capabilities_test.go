@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// Say hello with the capabilities and return the reply
+func (s *testSocket) sayHello(capabilities ...string) WSReply {
+	s.t.Helper()
+	s.send(HelloRequest{Type: CommandHello, ID: "hello", ProtocolVersion: protocolVersion, Capabilities: capabilities})
+	for {
+		if message := s.any(); message["id"] == "hello" {
+			return decodeMessage[WSReply](s.t, message)
+		}
+	}
+}
+
+func TestLegacyAndModernClientsShapeTheSameEvent(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		modern := ts.dial("")
+		modern.next("leaderboard")
+		// A capability the server doesn't know is left out of the reply
+		agreed := replyResult[HelloResponse](t, modern.sayHello(CapLeaderboardDelta, CapAchievements, "telepathy"))
+		if want := []string{CapLeaderboardDelta, CapAchievements}; agreed.ProtocolVersion != protocolVersion || !reflect.DeepEqual(agreed.Capabilities, want) {
+			t.Fatalf("hello = %+v, want version %d with %v", agreed, protocolVersion, want)
+		}
+		legacy := ts.dial("")
+		legacy.next("leaderboard")
+
+		ts.winSoloGame("alice")
+
+		delta := decodeMessage[LeaderboardDelta](t, modern.next("leaderboard_delta"))
+		if len(delta.Changes) != 1 || delta.Changes[0].Username != "alice" || delta.Changes[0].Win != 1 {
+			t.Fatalf("modern client got %+v", delta)
+		}
+		snapshot := decodeMessage[struct{ Leaderboard []LeaderboardEntry }](t, legacy.next("leaderboard")).Leaderboard
+		if len(snapshot) != 1 || snapshot[0].Username != "alice" || snapshot[0].Win != 1 {
+			t.Fatalf("legacy client got %+v", snapshot)
+		}
+	})
+}
+
+func TestLateHelloKeepsLegacyBehavior(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		late := ts.dial("")
+		late.next("leaderboard")
+		ts.clock.Advance(helloTimeout + 1)
+		assertReplyError(t, late.sayHello(CapLeaderboardDelta), http.StatusBadRequest, ErrCodeInvalidRequest)
+
+		// A hello after another message is too late as well
+		second := ts.dial("")
+		second.next("leaderboard")
+		second.command("first", CommandSubscribe, SubscribeRequest{Room: "NONE"})
+		assertReplyError(t, second.sayHello(CapLeaderboardDelta), http.StatusBadRequest, ErrCodeInvalidRequest)
+
+		ts.winSoloGame("alice")
+		for _, socket := range []*testSocket{late, second} {
+			if snapshot := decodeMessage[struct{ Leaderboard []LeaderboardEntry }](t, socket.next("leaderboard")).Leaderboard; len(snapshot) != 1 {
+				t.Fatalf("leaderboard after a refused hello = %+v", snapshot)
+			}
+		}
+	})
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"exploding-kitten/engine"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A card as clients show it. The Type is what the game logic goes by; the
+// rest is art, which a theme may replace, and text.
+type Card struct {
+	Type  string `json:"type"`
+	Emoji string `json:"emoji"`
+	// CSS color of the card's face, e.g. "#d32f2f"
+	Color string `json:"color"`
+	// Names the card's picture among the theme's images
+	ImageSlug string `json:"imageSlug"`
+	// What the card does, in a few words for the card's face and in full
+	ShortDesc string `json:"shortDesc"`
+	LongDesc  string `json:"longDesc"`
+}
+
+// Everything the server knows about a card type. Adding a card only takes a
+// registerCard call: the deck builder, /cards, the draw and the play-card
+// handlers all read it from the registry.
+type CardDefinition struct {
+	Card
+	// What drawing the card usually does, as DrawCardResponse.Disposition
+	// reports it. A bomb drawn with a Defuse in hand is defused instead.
+	Disposition string `json:"disposition"`
+	// What drawing the card does to the game
+	Effect engine.Effect `json:"-"`
+	// Whether the card can be spent with /play-card
+	PlayableFromHand bool `json:"playableFromHand"`
+	// The card only makes sense against an opponent, so it can't be played solo
+	NeedsOpponent bool `json:"needsOpponent"`
+	// Apply the card's effect when played from the hand and return the
+	// response for the player. Nope and Draw From Bottom are playable without
+	// one, as /play-card handles them itself.
+	Play func(s *Server, ctx context.Context, game *GameSession) (*PlayCardResponse, error) `json:"-"`
+}
+
+// The registered cards, in the order /cards lists them, and their index by
+// type
+var (
+	cardRegistry []CardDefinition
+	cardIndex    = map[string]int{}
+)
+
+// Add a card to the registry. A card without a type or an effect, one whose
+// type is already taken, or one with a Play function it can't be played with
+// is a programming error and panics at startup.
+func registerCard(def CardDefinition) {
+	if def.Type == "" {
+		panic("card registry: card without a type")
+	}
+	if _, ok := cardIndex[def.Type]; ok {
+		panic(fmt.Sprintf("card registry: card %q is registered twice", def.Type))
+	}
+	if def.Effect == nil {
+		panic(fmt.Sprintf("card registry: card %q has no effect", def.Type))
+	}
+	if def.Play != nil && !def.PlayableFromHand {
+		panic(fmt.Sprintf("card registry: card %q has a Play function but isn't playable from the hand", def.Type))
+	}
+	cardIndex[def.Type] = len(cardRegistry)
+	cardRegistry = append(cardRegistry, def)
+}
+
+// Filled in init because the Play functions lead back to playFromHand
+// through the bot's turn, which a package-level initializer can't refer to
+func init() {
+	registerCard(CardDefinition{
+		Card: Card{
+			Type:      "Cat",
+			Emoji:     "😼",
+			Color:     "#8d6e63",
+			ImageSlug: "cat",
+			ShortDesc: "Pair to steal",
+			LongDesc:  "Does nothing alone. Play two of the same cat to steal a card.",
+		},
+		Disposition: DispositionHeld,
+		Effect:      engine.Keep,
+	})
+	registerCard(CardDefinition{
+		Card: Card{
+			Type:      engine.Defuse,
+			Emoji:     "🙅‍♂",
+			Color:     "#43a047",
+			ImageSlug: "defuse",
+			ShortDesc: "Stops a bomb",
+			LongDesc:  "Spent on the next Exploding Kitten you draw, which goes back into the deck.",
+		},
+		Disposition: DispositionHeld,
+		Effect:      engine.Keep,
+	})
+	registerCard(CardDefinition{
+		Card: Card{
+			Type:      engine.Shuffle,
+			Emoji:     "🔀",
+			Color:     "#5e35b1",
+			ImageSlug: "shuffle",
+			ShortDesc: "Reshuffles the deck",
+			LongDesc:  "Reshuffles the deck when drawn. Held in a room, it can be played to reshuffle later.",
+		},
+		Disposition:      DispositionResolved,
+		Effect:           engine.ShuffleDeck,
+		PlayableFromHand: true,
+		Play:             (*Server).playShuffle,
+	})
+	registerCard(CardDefinition{
+		Card: Card{
+			Type:      engine.ExplodingKitten,
+			Emoji:     "💣",
+			Color:     "#d32f2f",
+			ImageSlug: "exploding-kitten",
+			ShortDesc: "You lose",
+			LongDesc:  "You lose unless you hold a Defuse.",
+		},
+		Disposition: DispositionExploded,
+		Effect:      engine.Explode,
+	})
+	registerCard(CardDefinition{
+		Card: Card{
+			Type:      "Favor",
+			Emoji:     "🙏",
+			Color:     "#f9a825",
+			ImageSlug: "favor",
+			ShortDesc: "Take a card",
+			LongDesc:  "The next player gives you a random card from their hand.",
+		},
+		Disposition:      DispositionHeld,
+		Effect:           engine.Keep,
+		PlayableFromHand: true,
+		NeedsOpponent:    true,
+		Play:             (*Server).playFavor,
+	})
+	registerCard(CardDefinition{
+		Card: Card{
+			Type:      "Skip",
+			Emoji:     "⏭️",
+			Color:     "#1e88e5",
+			ImageSlug: "skip",
+			ShortDesc: "End your turn",
+			LongDesc:  "Ends your turn without drawing.",
+		},
+		Disposition:      DispositionHeld,
+		Effect:           engine.Keep,
+		PlayableFromHand: true,
+		NeedsOpponent:    true,
+		Play:             (*Server).playSkip,
+	})
+	registerCard(CardDefinition{
+		Card: Card{
+			Type:      "Attack",
+			Emoji:     "⚔️",
+			Color:     "#c62828",
+			ImageSlug: "attack",
+			ShortDesc: "Make the next player draw two",
+			LongDesc:  "Ends your turn without drawing. The next player must draw two cards, plus any you still owed.",
+		},
+		Disposition:      DispositionHeld,
+		Effect:           engine.Keep,
+		PlayableFromHand: true,
+		NeedsOpponent:    true,
+		Play:             (*Server).playAttack,
+	})
+	registerCard(CardDefinition{
+		Card: Card{
+			Type:      "Nope",
+			Emoji:     "🚫",
+			Color:     "#e53935",
+			ImageSlug: "nope",
+			ShortDesc: "Cancel an action",
+			LongDesc:  "Cancels the action card another player just played, or a Nope.",
+		},
+		Disposition:      DispositionHeld,
+		Effect:           engine.Keep,
+		PlayableFromHand: true,
+		NeedsOpponent:    true,
+	})
+	registerCard(CardDefinition{
+		Card: Card{
+			Type:      "Draw From Bottom",
+			Emoji:     "⬇️",
+			Color:     "#00897b",
+			ImageSlug: "draw-from-bottom",
+			ShortDesc: "Draw the bottom card",
+			LongDesc:  "Draws the bottom card of the deck instead of the top one.",
+		},
+		Disposition:      DispositionHeld,
+		Effect:           engine.Keep,
+		PlayableFromHand: true,
+	})
+	registerCard(CardDefinition{
+		Card: Card{
+			Type:      "See the Future",
+			Emoji:     "🔮",
+			Color:     "#8e24aa",
+			ImageSlug: "see-the-future",
+			ShortDesc: "Peek at three cards",
+			LongDesc:  "Shows you the top three cards of the deck. Only you see them.",
+		},
+		Disposition:      DispositionHeld,
+		Effect:           engine.Keep,
+		PlayableFromHand: true,
+		Play:             (*Server).playSeeTheFuture,
+	})
+	registerCard(CardDefinition{
+		Card: Card{
+			Type:      "Tacocat",
+			Emoji:     "🌮",
+			Color:     "#fb8c00",
+			ImageSlug: "tacocat",
+			ShortDesc: "Pair to steal",
+			LongDesc:  "Does nothing alone. Play two to steal a card.",
+		},
+		Disposition: DispositionHeld,
+		Effect:      engine.Keep,
+	})
+	registerCard(CardDefinition{
+		Card: Card{
+			Type:      "Rainbow Cat",
+			Emoji:     "🌈",
+			Color:     "#ec407a",
+			ImageSlug: "rainbow-cat",
+			ShortDesc: "Pair to steal",
+			LongDesc:  "Does nothing alone. Play two to steal a card.",
+		},
+		Disposition: DispositionHeld,
+		Effect:      engine.Keep,
+	})
+	registerCard(CardDefinition{
+		Card: Card{
+			Type:      "Beard Cat",
+			Emoji:     "🧔",
+			Color:     "#6d4c41",
+			ImageSlug: "beard-cat",
+			ShortDesc: "Pair to steal",
+			LongDesc:  "Does nothing alone. Play two to steal a card.",
+		},
+		Disposition: DispositionHeld,
+		Effect:      engine.Keep,
+	})
+}
+
+// The registry entry for a card type
+func lookupCard(cardType string) (*CardDefinition, bool) {
+	i, ok := cardIndex[cardType]
+	if !ok {
+		return nil, false
+	}
+	return &cardRegistry[i], true
+}
+
+// Look up the card of a type for a response, with the theme's art
+func findCard(theme, cardType string) *Card {
+	if def, ok := lookupCard(cardType); ok {
+		card := themedCard(theme, def.Card)
+		return &card
+	}
+	return nil
+}
+
+// Map a stored card string to its Card for a response, with the theme's art.
+// A string missing from the registry comes back with its type and no emoji
+// rather than failing.
+func cardFor(theme, cardType string) Card {
+	if card := findCard(theme, cardType); card != nil {
+		return *card
+	}
+	log.Printf("Unknown card type in storage: %q", cardType)
+	return Card{Type: cardType}
+}
+
+func cardsFor(theme string, cardTypes []string) []Card {
+	result := make([]Card, len(cardTypes))
+	for i, cardType := range cardTypes {
+		result[i] = cardFor(theme, cardType)
+	}
+	return result
+}
+
+// What drawing a card of the type does. A type missing from the registry,
+// which only old stored games can hold, is kept like a Cat.
+func cardEffect(cardType string) engine.Effect {
+	if def, ok := lookupCard(cardType); ok {
+		return def.Effect
+	}
+	return engine.Keep
+}
+
+// Clients that still read the emoji-only card field ask for it with
+// ?legacy=true. The cardText field goes away in the next release.
+func addLegacyCardText(c *gin.Context, response *DrawCardResponse) {
+	if c.Query("legacy") == "true" {
+		response.CardText = response.Card.Emoji
+	}
+}
+
+// Cards route: the full card registry, with the art of the theme in ?theme=
+func getCards(c *gin.Context) {
+	theme := themeFrom(c.Request.Context())
+	cards := make([]CardDefinition, len(cardRegistry))
+	for i, def := range cardRegistry {
+		def.Card = themedCard(theme, def.Card)
+		cards[i] = def
+	}
+	c.JSON(http.StatusOK, CardsResponse{Cards: cards})
+}
+
+// Whether a card of the type can be spent with /play-card
+func cardPlayable(cardType string) bool {
+	def, ok := lookupCard(cardType)
+	return ok && def.PlayableFromHand
+}
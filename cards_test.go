@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+func TestLegacyCardTextFlag(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", "Skip", engine.ExplodingKitten)
+
+		legacy := decodeOK[DrawCardResponse](t, ts.post("/draw-card?legacy=true", User{Username: "alice"}))
+		if legacy.CardText == "" || legacy.CardText != legacy.Card.Emoji {
+			t.Fatalf("legacy draw = %+v, want cardText %q", legacy, legacy.Card.Emoji)
+		}
+
+		w := ts.draw("alice")
+		modern := decodeOK[DrawCardResponse](t, w)
+		if modern.Card.Type != "Skip" || modern.Card.Emoji == "" {
+			t.Fatalf("draw = %+v", modern)
+		}
+		if bytes.Contains(w.Body.Bytes(), []byte(`"cardText"`)) {
+			t.Fatalf("cardText sent without ?legacy=true: %s", w.Body)
+		}
+	})
+}
+
+func TestResponsesCarryCardObjects(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice")
+		// A card the registry no longer knows keeps its type
+		ts.deal("alice", "Cat", "Mystery")
+
+		hand := decodeOK[HandResponse](t, ts.get("/hand?username=alice"))
+		cat := findCard(ThemeClassic, "Cat")
+		if len(hand.Hand) != 2 || hand.Hand[0] != *cat || hand.Hand[1] != (Card{Type: "Mystery"}) {
+			t.Fatalf("hand = %+v", hand.Hand)
+		}
+
+		started := ts.startGame("alice")
+		if len(started.Snapshot.Hand) != 2 || started.Snapshot.Hand[0] != *cat {
+			t.Fatalf("snapshot hand = %+v", started.Snapshot.Hand)
+		}
+	})
+}
+
+func TestCardsRouteListsRegistry(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	w := ts.get("/cards")
+	cards := decodeOK[CardsResponse](t, w)
+	if len(cards.Cards) != len(cardRegistry) {
+		t.Fatalf("%d cards, want %d", len(cards.Cards), len(cardRegistry))
+	}
+	for i, card := range cards.Cards {
+		if card.Type != cardRegistry[i].Type || card.Emoji == "" {
+			t.Fatalf("card %d = %+v", i, card.Card)
+		}
+	}
+
+	var raw struct {
+		Cards []map[string]json.RawMessage `json:"cards"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &raw)
+	for _, field := range []string{"type", "emoji", "color", "imageSlug"} {
+		if _, ok := raw.Cards[0][field]; !ok {
+			t.Fatalf("card has no %q: %v", field, raw.Cards[0])
+		}
+	}
+}
+
+// Register a card for the length of the test
+func registerTestCard(t *testing.T, def CardDefinition) {
+	t.Helper()
+	registerCard(def)
+	t.Cleanup(func() {
+		delete(cardIndex, def.Type)
+		cardRegistry = cardRegistry[:len(cardRegistry)-1]
+	})
+}
+
+// Super Skip: ends the player's turn and skips the next player's too
+func (s *Server) playSuperSkip(ctx context.Context, game *GameSession) (*PlayCardResponse, error) {
+	if err := s.endTurn(ctx, game.Room, game.Room.nextAlive(game.Username)); err != nil {
+		return nil, err
+	}
+	return &PlayCardResponse{Message: "You played a Super Skip card! The next player is skipped too."}, nil
+}
+
+func TestCustomCardPlaysEndToEnd(t *testing.T) {
+	registerTestCard(t, CardDefinition{
+		Card: Card{
+			Type:      "Super Skip",
+			Emoji:     "⏩",
+			Color:     "#3949ab",
+			ImageSlug: "super-skip",
+			ShortDesc: "Skip two turns",
+			LongDesc:  "Ends your turn without drawing, and skips the next player's.",
+		},
+		Disposition:      DispositionHeld,
+		Effect:           engine.Keep,
+		PlayableFromHand: true,
+		NeedsOpponent:    true,
+		Play:             (*Server).playSuperSkip,
+	})
+
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		cards := decodeOK[CardsResponse](t, ts.get("/cards")).Cards
+		if last := cards[len(cards)-1]; last.Type != "Super Skip" || !last.PlayableFromHand || last.Emoji != "⏩" {
+			t.Fatalf("/cards ends with %+v", last)
+		}
+
+		room := ts.openRoom("alice", "bob", "carol")
+		order := room.turnOrder(room.Turn)
+		ts.setDeck(room.gameID(), "Super Skip", "Cat", "Cat", "Cat", "Cat")
+		drawn := decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: order[0], GameID: room.gameID()}))
+		if drawn.Card.Type != "Super Skip" || drawn.Disposition != DispositionHeld {
+			t.Fatalf("draw of the Super Skip = %+v", drawn)
+		}
+		for _, player := range order[1:] {
+			decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: player, GameID: room.gameID()}))
+		}
+
+		if w := ts.play(order[0], room.gameID(), "Super Skip"); w.Code != http.StatusAccepted {
+			t.Fatalf("playing Super Skip = %d: %s", w.Code, w.Body.String())
+		}
+		ts.clock.Advance(ts.nopeWindow)
+		if turn := ts.room(room.Code).Turn; turn != order[2] {
+			t.Fatalf("turn after the Super Skip = %q, want %q", turn, order[2])
+		}
+		if hand := ts.hand(order[0]); len(hand) != 0 {
+			t.Fatalf("hand after playing it = %v", hand)
+		}
+
+		// Solo there is no one to skip
+		ts.startGame("dave")
+		ts.deal("dave", "Super Skip")
+		assertError(t, ts.play("dave", "", "Super Skip"), http.StatusBadRequest, ErrCodeCardNotPlayable)
+	})
+}
+
+func TestBadCardRegistrationsPanic(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		def  CardDefinition
+		want string
+	}{
+		{"no type", CardDefinition{Effect: engine.Keep}, "card registry: card without a type"},
+		{"duplicate type", CardDefinition{Card: Card{Type: "Cat"}, Effect: engine.Keep}, `card registry: card "Cat" is registered twice`},
+		{"no effect", CardDefinition{Card: Card{Type: "Dud"}}, `card registry: card "Dud" has no effect`},
+		{"unplayable Play", CardDefinition{Card: Card{Type: "Dud"}, Effect: engine.Keep, Play: (*Server).playSkip}, `card registry: card "Dud" has a Play function but isn't playable from the hand`},
+	} {
+		func() {
+			defer func() {
+				if got := recover(); got != test.want {
+					t.Errorf("%s: panicked with %v, want %q", test.name, got, test.want)
+				}
+			}()
+			registerCard(test.def)
+		}()
+	}
+	if _, ok := lookupCard("Dud"); ok {
+		t.Fatal("a bad card was registered")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Messages kept of a room's chat, the longest message in characters, and
+// how often one player may send one
+const (
+	chatHistoryLength = 50
+	maxChatLength     = 280
+	chatInterval      = time.Second
+)
+
+// Words masked in chat when CHAT_BLOCKLIST isn't set
+var defaultChatBlocklist = []string{"fuck", "shit", "bitch", "asshole", "bastard", "cunt"}
+
+// One message of a room's chat
+type ChatMessage struct {
+	Username string    `json:"username"`
+	Text     string    `json:"text"`
+	SentAt   time.Time `json:"sentAt"`
+}
+
+// Payload of a chat command
+type ChatRequest struct {
+	Username string `json:"username"`
+	Room     string `json:"room"`
+	Text     string `json:"text"`
+}
+
+// Match the blocklisted words, as whole words in any case. nil when the
+// list is empty.
+func chatFilter(words []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// Replace every letter of each blocklisted word with '*'
+func maskChat(filter *regexp.Regexp, text string) string {
+	if filter == nil {
+		return text
+	}
+	return filter.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	})
+}
+
+// When each player last chatted, to hold them to one message per
+// chatInterval
+type chatLimiter struct {
+	mutex sync.Mutex
+	last  map[string]time.Time
+}
+
+func (l *chatLimiter) allow(username string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.last == nil {
+		l.last = make(map[string]time.Time)
+	}
+	if last, ok := l.last[username]; ok && now.Sub(last) < chatInterval {
+		return false
+	}
+	l.last[username] = now
+	// Forget players who have gone quiet, so the map doesn't grow forever
+	if len(l.last) > 1000 {
+		for name, at := range l.last {
+			if now.Sub(at) >= chatInterval {
+				delete(l.last, name)
+			}
+		}
+	}
+	return true
+}
+
+// Send a chat message to the room's sockets and keep it in the room's
+// history. Only the room's players may chat.
+func (s *Server) chat(ctx context.Context, req ChatRequest) (*ChatMessage, *APIError) {
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		return nil, errInvalidRequest("Chat messages can't be empty")
+	}
+	if utf8.RuneCountInString(text) > maxChatLength {
+		return nil, errChatTooLong()
+	}
+
+	code := strings.ToUpper(req.Room)
+	room, err := s.store.GetRoom(ctx, code)
+	if err != nil {
+		log.Printf("Error retrieving room %s: %v", code, err)
+		return nil, errStoreUnavailable("Error retrieving room")
+	}
+	if room == nil {
+		return nil, errRoomNotFound()
+	}
+	if !room.hasPlayer(req.Username) {
+		return nil, errNotInRoom()
+	}
+	if !s.chatLimiter.allow(req.Username, s.clock.Now()) {
+		return nil, errChatRateLimited()
+	}
+
+	message := ChatMessage{Username: req.Username, Text: maskChat(s.chatFilter, text), SentAt: s.clock.Now().UTC()}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "Internal server error")
+	}
+	// The message still goes out; only late joiners miss it
+	if err := s.store.AppendChat(ctx, code, payload, chatHistoryLength); err != nil {
+		log.Printf("Error keeping chat of room %s: %v", code, err)
+	}
+	s.hub.broadcastRoom(code, RoomEvent{Type: "chat", Username: message.Username, Message: message.Text, SentAt: &message.SentAt})
+	return &message, nil
+}
+
+// The room's recent chat, oldest first
+func (s *Server) roomChat(ctx context.Context, code string) ([]ChatMessage, error) {
+	entries, err := s.store.RoomChat(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]ChatMessage, 0, len(entries))
+	for _, entry := range entries {
+		var message ChatMessage
+		if err := json.Unmarshal(entry, &message); err != nil {
+			log.Printf("Warning: skipping unreadable chat message of room %s: %v", code, err)
+			continue
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
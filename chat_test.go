@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Sockets of alice and bob on a room they both play in
+func (ts *testServer) chatRoom() (*Room, map[string]*testSocket) {
+	ts.t.Helper()
+	room := ts.openRoom("alice", "bob")
+	sockets := make(map[string]*testSocket)
+	for _, player := range room.Players {
+		sockets[player] = ts.dial(fmt.Sprintf("room=%s&username=%s", room.Code, player))
+		sockets[player].hello()
+	}
+	return room, sockets
+}
+
+func (s *testSocket) chat(username, code, text string) WSReply {
+	s.t.Helper()
+	return s.command(text, CommandChat, ChatRequest{Username: username, Room: code, Text: text})
+}
+
+func assertReplyError(t *testing.T, reply WSReply, status int, code string) {
+	t.Helper()
+	if reply.Type != "error" || reply.Status != status || reply.Error == nil || reply.Error.Code != code {
+		t.Fatalf("reply = %+v, want %d %s", reply, status, code)
+	}
+}
+
+func TestChatLengthAndRateLimit(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room, sockets := ts.chatRoom()
+		alice := sockets["alice"]
+
+		longest := strings.Repeat("é", maxChatLength)
+		sent := replyResult[ChatMessage](t, alice.chat("alice", room.Code, longest))
+		if sent.Text != longest || !sent.SentAt.Equal(testEpoch) {
+			t.Fatalf("chat = %+v", sent)
+		}
+		heard := decodeMessage[RoomEvent](t, sockets["bob"].next("chat"))
+		if heard.Username != "alice" || heard.Message != longest || heard.SentAt == nil || !heard.SentAt.Equal(testEpoch) {
+			t.Fatalf("bob heard %+v", heard)
+		}
+
+		// One message a second; bob's are counted apart from alice's
+		assertReplyError(t, alice.chat("alice", room.Code, "again"), http.StatusTooManyRequests, ErrCodeRateLimited)
+		replyResult[ChatMessage](t, sockets["bob"].chat("bob", room.Code, "hi"))
+		ts.clock.Advance(chatInterval)
+
+		// Refused messages don't use up the second
+		assertReplyError(t, alice.chat("alice", room.Code, longest+"!"), http.StatusBadRequest, ErrCodeInvalidRequest)
+		assertReplyError(t, alice.chat("alice", room.Code, "   "), http.StatusBadRequest, ErrCodeInvalidRequest)
+		assertReplyError(t, alice.chat("carol", room.Code, "let me in"), http.StatusForbidden, ErrCodeNotInRoom)
+		assertReplyError(t, alice.chat("alice", "ZZZZZZ", "anyone?"), http.StatusNotFound, ErrCodeRoomNotFound)
+		replyResult[ChatMessage](t, alice.chat("alice", room.Code, "  trimmed  "))
+		if chat, _ := ts.roomChat(context.Background(), room.Code); len(chat) != 3 || chat[2].Text != "trimmed" {
+			t.Fatalf("history = %+v", chat)
+		}
+	})
+}
+
+func TestChatMasksBlocklistedWords(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{"CHAT_BLOCKLIST": "darn, heck"}))
+		room, sockets := ts.chatRoom()
+		sent := replyResult[ChatMessage](t, sockets["alice"].chat("alice", room.Code, "Darn it, what the HECK, darned kittens"))
+		// Whole words only, in any case, letter for letter
+		if want := "**** it, what the ****, darned kittens"; sent.Text != want {
+			t.Fatalf("masked = %q, want %q", sent.Text, want)
+		}
+		if heard := decodeMessage[RoomEvent](t, sockets["bob"].next("chat")); heard.Message != sent.Text {
+			t.Fatalf("bob heard %q", heard.Message)
+		}
+	})
+}
+
+func TestChatHistoryReplaysOnRejoin(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room, sockets := ts.chatRoom()
+		var want []ChatMessage
+		for i := 0; i < chatHistoryLength+2; i++ {
+			player := room.Players[i%2]
+			want = append(want, replyResult[ChatMessage](t, sockets[player].chat(player, room.Code, fmt.Sprintf("message %d", i))))
+			ts.clock.Advance(chatInterval)
+		}
+		want = want[2:]
+
+		// A reconnect gets the last chatHistoryLength, oldest first, masked
+		// as they were sent
+		sockets["bob"].conn.Close()
+		rejoined := ts.dial(fmt.Sprintf("room=%s&username=bob", room.Code))
+		snapshot := decodeMessage[RoomSnapshot](t, rejoined.next("snapshot"))
+		if len(snapshot.Chat) != chatHistoryLength || !reflect.DeepEqual(snapshot.Chat, want) {
+			t.Fatalf("snapshot has %d messages, from %+v", len(snapshot.Chat), snapshot.Chat[0])
+		}
+	})
+}
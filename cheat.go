@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stat updates waiting for the cheat detector before new ones are dropped
+const cheatQueueSize = 256
+
+// Who flags players on behalf of the cheat detector, in the audit log
+const cheatDetectorActor = "cheat_detector"
+
+// What counts as statistically impossible play. Usernames can be spoofed, so
+// a client that reports wins for a name it doesn't own shows up here.
+type cheatPolicy struct {
+	// A win rate above MaxWinRate is flagged once a player has finished more
+	// than MinGames. A MaxWinRate of 1 turns it off.
+	MinGames   int64
+	MaxWinRate float64
+	// More games than this finished within a minute are flagged; 0 turns it
+	// off
+	MaxGamesPerMinute int64
+	// Flag a win in a game where not a single card was drawn, other than by
+	// the other player forfeiting
+	ZeroDrawWins bool
+}
+
+var defaultCheatPolicy = cheatPolicy{
+	MinGames:          50,
+	MaxWinRate:        0.95,
+	MaxGamesPerMinute: 6,
+	ZeroDrawWins:      true,
+}
+
+// A player's stats after a game, for the detector to look into
+type statUpdate struct {
+	Username string
+	// Won a game in which no card was drawn, other than by forfeit
+	ZeroDrawWin bool
+	At          time.Time
+}
+
+// What the policy checks a player's record against
+type playerRecord struct {
+	Wins   int64
+	Losses int64
+	// Games finished in the last minute
+	RecentGames int64
+	ZeroDrawWin bool
+}
+
+// Why the record looks impossible, or "" if it doesn't
+func (p cheatPolicy) check(record playerRecord) string {
+	games := record.Wins + record.Losses
+	if games > p.MinGames && p.MaxWinRate < 1 {
+		if rate := float64(record.Wins) / float64(games); rate > p.MaxWinRate {
+			return fmt.Sprintf("win rate %.0f%% over %d games", rate*100, games)
+		}
+	}
+	if p.MaxGamesPerMinute > 0 && record.RecentGames > p.MaxGamesPerMinute {
+		return fmt.Sprintf("%d games finished within a minute", record.RecentGames)
+	}
+	if p.ZeroDrawWins && record.ZeroDrawWin {
+		return "won a game without a card being drawn"
+	}
+	return ""
+}
+
+// cheatDetector looks into stat updates from a queue on its own goroutine,
+// so finishing a game only ever does a non-blocking send
+type cheatDetector struct {
+	policy cheatPolicy
+	queue  chan statUpdate
+}
+
+func newCheatDetector(policy cheatPolicy) *cheatDetector {
+	return &cheatDetector{policy: policy, queue: make(chan statUpdate, cheatQueueSize)}
+}
+
+// Queue an update without waiting. Drops it if the queue is full; the
+// player's next game is looked into all the same.
+func (d *cheatDetector) enqueue(update statUpdate) {
+	select {
+	case d.queue <- update:
+	default:
+		log.Printf("Cheat detector queue is full, dropping the update of user %s", update.Username)
+	}
+}
+
+// Look into queued stat updates until ctx is done
+func (s *Server) detectCheats(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update := <-s.cheats.queue:
+			s.inspectStats(ctx, update)
+		}
+	}
+}
+
+// Check a player's record against the policy and flag them if it looks
+// impossible. Failures are logged; the player just isn't flagged this time.
+func (s *Server) inspectStats(ctx context.Context, update statUpdate) {
+	record := playerRecord{ZeroDrawWin: update.ZeroDrawWin}
+	var err error
+	if record.RecentGames, err = s.store.RecordFinish(ctx, update.Username, update.At, time.Minute); err != nil {
+		log.Printf("Error recording finished game of user %s: %v", update.Username, err)
+		return
+	}
+	if record.Wins, record.Losses, err = s.store.GetStats(ctx, update.Username); err != nil {
+		log.Printf("Error retrieving stats for user %s: %v", update.Username, err)
+		return
+	}
+
+	reason := s.cheats.policy.check(record)
+	if reason == "" {
+		return
+	}
+	flagged, err := s.store.FlagUser(ctx, update.Username, reason)
+	if err != nil {
+		log.Printf("Error flagging user %s: %v", update.Username, err)
+		return
+	}
+	if !flagged {
+		return
+	}
+
+	log.Printf("Flagged user %s: %s", update.Username, reason)
+	entry := s.newAuditEntry(ctx, cheatDetectorActor, "flag", update.Username)
+	entry.After = reason
+	if err := s.store.AppendAudit(ctx, entry); err != nil {
+		log.Printf("Error writing audit entry flag for user %s: %v", update.Username, err)
+	}
+	// The player drops off the public leaderboard
+	s.leaderboard.invalidate()
+	s.broadcastLeaderboard()
+}
+
+// Whether a winner's game counts as won without a card drawn. Only read
+// when the policy looks at it, and before the winner can start another game.
+func (s *Server) zeroDrawWin(ctx context.Context, game *GameSession, outcome gameOutcome) bool {
+	if !s.cheats.policy.ZeroDrawWins || outcome.LoserResult == "forfeit" {
+		return false
+	}
+	_, drawn, err := s.store.GameProgress(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error retrieving progress of game %s: %v", game.ID, err)
+		return false
+	}
+	return drawn == 0
+}
+
+// A flagged player and why they were flagged
+type FlaggedUser struct {
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
+}
+
+// Admin flagged route: every flagged player, by username
+func (s *Server) adminFlagged(c *gin.Context) {
+	flagged, err := s.store.FlaggedUsers(c.Request.Context())
+	if err != nil {
+		log.Printf("Error retrieving flagged users: %v", err)
+		abortWithError(c, errStoreUnavailable("Error retrieving flagged users"))
+		return
+	}
+	users := make([]FlaggedUser, 0, len(flagged))
+	for username, reason := range flagged {
+		users = append(users, FlaggedUser{Username: username, Reason: reason})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	c.JSON(http.StatusOK, AdminFlaggedResponse{Flagged: users})
+}
+
+// Admin unflag route: put a flagged player back on the leaderboard. They
+// can be flagged again by their next game.
+func (s *Server) adminUnflag(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	username, apiErr := adminUsername(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	cleared, err := s.store.UnflagUser(ctx, username)
+	if err != nil {
+		log.Printf("Error clearing flag of user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error clearing flag"))
+		return
+	}
+	if !cleared {
+		abortWithError(c, errNotFlagged())
+		return
+	}
+	s.audit(c, s.adminAuditEntry(c, "unflag", username))
+	s.leaderboard.invalidate()
+	s.broadcastLeaderboard()
+
+	log.Printf("Admin cleared the flag of user %s", username)
+	c.JSON(http.StatusOK, AdminResetResponse{Message: "Flag cleared", Username: username})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+// Look into the stat updates the detector has queued, as detectCheats would
+func (ts *testServer) inspectQueuedStats() {
+	for len(ts.cheats.queue) > 0 {
+		ts.inspectStats(context.Background(), <-ts.cheats.queue)
+	}
+}
+
+func TestCheatPolicyCheck(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		record playerRecord
+		want   string
+	}{
+		{"new player winning everything", playerRecord{Wins: 50}, ""},
+		{"winning everything", playerRecord{Wins: 51}, "win rate 100% over 51 games"},
+		{"winning most", playerRecord{Wins: 90, Losses: 10}, ""},
+		{"winning nearly all", playerRecord{Wins: 97, Losses: 3}, "win rate 97% over 100 games"},
+		{"six games a minute", playerRecord{RecentGames: 6}, ""},
+		{"seven games a minute", playerRecord{RecentGames: 7}, "7 games finished within a minute"},
+		{"win without a draw", playerRecord{Wins: 1, ZeroDrawWin: true}, "won a game without a card being drawn"},
+	} {
+		if got := defaultCheatPolicy.check(test.record); got != test.want {
+			t.Errorf("%s: check(%+v) = %q, want %q", test.name, test.record, got, test.want)
+		}
+	}
+
+	// Each heuristic can be turned off
+	off := cheatPolicy{MinGames: 50, MaxWinRate: 1}
+	if got := off.check(playerRecord{Wins: 500, RecentGames: 100, ZeroDrawWin: true}); got != "" {
+		t.Errorf("check with every heuristic off = %q", got)
+	}
+}
+
+func TestImpossibleRecordsAreFlaggedAndHidden(t *testing.T) {
+	eachAdminStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		// Records from before the last game, which are only set once a first
+		// game has set the user up
+		for username, stats := range map[string][2]int64{"ringer": {60, 0}, "honest": {30, 30}} {
+			ts.startGame(username, "Cat", engine.ExplodingKitten)
+			ts.store.SetStats(ctx, username, stats[0], stats[1], AuditEntry{})
+			decodeOK[DrawCardResponse](t, ts.draw(username))
+		}
+		// Seven wins inside a minute
+		for i := 0; i < 7; i++ {
+			ts.winSoloGame("speedy")
+			ts.clock.Advance(5 * time.Second)
+		}
+		ts.inspectQueuedStats()
+
+		flagged := decodeOK[AdminFlaggedResponse](t, ts.get("/admin/flagged", asAdmin...)).Flagged
+		want := []FlaggedUser{
+			{Username: "ringer", Reason: "win rate 100% over 61 games"},
+			{Username: "speedy", Reason: "7 games finished within a minute"},
+		}
+		if !reflect.DeepEqual(flagged, want) {
+			t.Fatalf("flagged = %+v, want %+v", flagged, want)
+		}
+
+		if names := leaderboardNames(ts); !reflect.DeepEqual(names, []string{"honest"}) {
+			t.Fatalf("public leaderboard = %v, want only honest", names)
+		}
+		assertError(t, ts.get("/leaderboard?includeFlagged=true"), http.StatusUnauthorized, ErrCodeUnauthorized)
+		if rows := decodeOK[LeaderboardResponse](t, ts.get("/leaderboard?includeFlagged=true", asAdmin...)).Leaderboard; len(rows) != 3 {
+			t.Fatalf("leaderboard with the flagged = %+v", rows)
+		}
+
+		// A cleared flag puts the player back
+		decodeOK[AdminResetResponse](t, ts.request(http.MethodDelete, "/admin/users/ringer/flag", nil, asAdmin...))
+		if names := leaderboardNames(ts); !reflect.DeepEqual(names, []string{"ringer", "honest"}) {
+			t.Fatalf("public leaderboard after the unflag = %v", names)
+		}
+		assertError(t, ts.request(http.MethodDelete, "/admin/users/ringer/flag", nil, asAdmin...), http.StatusNotFound, ErrCodeNotFlagged)
+	})
+}
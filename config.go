@@ -0,0 +1,581 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cards SOLO_DECK may add up to: a game needs a bomb and something else to
+// draw, and has to stay short enough to finish
+const (
+	minSoloDeckSize = 2
+	maxSoloDeckSize = 60
+)
+
+// Everything an instance is configured with. loadConfig fills it in from
+// the environment over the defaults at startup and checks it, and the server
+// is built from it; GET /admin/config shows what a running instance loaded.
+type Config struct {
+	// STORE: "redis" or "memory"
+	Store string
+	// KEY_PREFIX, put in front of every Redis key
+	KeyPrefix string
+	// REDIS_ADDR and REDIS_PASSWORD of a single Redis, a local one without
+	// a password unless set, or with REDIS_CLUSTER_ADDRS the seed nodes of a
+	// Redis Cluster
+	RedisAddr         string
+	RedisPassword     string
+	RedisClusterAddrs []string
+	// REDIS_MONITOR_INTERVAL: how often Redis is pinged for the redis_up
+	// gauge
+	RedisMonitorInterval time.Duration
+
+	// LISTEN_ADDR, TLS_CERT_FILE, TLS_KEY_FILE and HTTP_REDIRECT_ADDR; see
+	// listenConfig
+	ListenAddr       string
+	TLSCertFile      string
+	TLSKeyFile       string
+	HTTPRedirectAddr string
+	// ALLOWED_ORIGINS; without it, localhost origins suited to development
+	AllowedOrigins []string
+	// APP_ENV, e.g. "production" or "development"
+	AppEnv string
+	// DEBUG: register the /debug routes, as APP_ENV=development also does
+	Debug bool
+	// ADMIN_TOKEN, INVITE_SECRET and EVENT_WEBHOOK_URL; see Server
+	AdminToken      string
+	InviteSecret    string
+	EventWebhookURL string
+	// CONSISTENCY_CHECK: check the stored data at startup
+	ConsistencyCheck bool
+
+	// MAX_HAND_SIZE, SHUFFLE_COOLDOWN, BOMB_REVEAL_DELAY,
+	// BOMB_DECISION_TIMEOUT, NOPE_WINDOW and FAST_FORWARD_INEVITABLE; see
+	// Server
+	MaxHandSize           int
+	ShuffleCooldown       int
+	RevealDelay           time.Duration
+	BombDecisionTimeout   time.Duration
+	NopeWindow            time.Duration
+	FastForwardInevitable bool
+	// TURN_TIMEOUT: seconds a room player has to move unless the room says
+	// otherwise
+	TurnTimeout int
+	// SOLO_DECK: the classic solo deck, as Type=Count pairs separated by
+	// commas
+	SoloDeck DeckConfig
+
+	// GAME_LOCKS, GAME_LOCK_TTL, GAME_CREATION_LOCK_TTL, MAX_ACTIVE_GAMES,
+	// DAILY_GAME_QUOTA, API_KEY_CACHE_TTL, API_KEY_RATE and CHAT_BLOCKLIST;
+	// see Server
+	GameLocks           bool
+	GameLockTTL         time.Duration
+	GameCreationLockTTL time.Duration
+	MaxActiveGames      int64
+	DailyGameQuota      int64
+	APIKeyCacheTTL      time.Duration
+	APIKeyRate          int
+	ChatBlocklist       []string
+	// BROADCAST_WORKERS, WS_MAX_CONNECTIONS and WS_MAX_CONNECTIONS_PER_USER;
+	// see Hub
+	BroadcastWorkers        int
+	WSMaxConnections        int
+	WSMaxConnectionsPerUser int
+	// WS_PING_INTERVAL: how often sockets are pinged, each pong keeping
+	// their player online
+	PingInterval time.Duration
+	// ROOM_MAX_PAUSE, TOURNAMENT_NO_SHOW_TIMEOUT, DRAW_LATENCY_BUDGET,
+	// FINISHED_GAME_RETENTION, OUTBOX_CLAIM_AFTER and
+	// LEADERBOARD_MAX_STALENESS; see Server
+	RoomMaxPause            time.Duration
+	TournamentNoShowTimeout time.Duration
+	DrawLatencyBudget       time.Duration
+	FinishedGameRetention   time.Duration
+	OutboxClaimAfter        time.Duration
+	LeaderboardMaxStaleness time.Duration
+	// SESSION_TTL, IDEMPOTENCY_TTL and PRESENCE_TTL: how long a guest's
+	// session, a draw's idempotency key and a player's presence last
+	SessionTTL     time.Duration
+	IdempotencyTTL time.Duration
+	PresenceTTL    time.Duration
+	// PENDING_DRAW_TIMEOUT and OUTBOX_BATCH; see Server
+	PendingDrawTimeout time.Duration
+	OutboxBatch        int
+	// PRESENCE_SWEEP_INTERVAL, MATCHMAKING_INTERVAL,
+	// FINISHED_GAME_SWEEP_INTERVAL, PENDING_DRAW_INTERVAL and
+	// OUTBOX_INTERVAL: how often each background loop runs
+	PresenceSweepInterval     time.Duration
+	MatchmakingInterval       time.Duration
+	FinishedGameSweepInterval time.Duration
+	PendingDrawInterval       time.Duration
+	OutboxInterval            time.Duration
+
+	// EVENT_LOG_CAP, MOVE_LOG_CAP, AUDIT_LOG_CAP and LOG_RETENTION_TTL
+	Retention retentionPolicy
+	// CHEAT_MIN_GAMES, CHEAT_MAX_WIN_RATE, CHEAT_MAX_GAMES_PER_MINUTE and
+	// CHEAT_ZERO_DRAW_WINS
+	Cheats cheatPolicy
+}
+
+func defaultConfig() Config {
+	return Config{
+		Store:                "redis",
+		RedisAddr:            "localhost:6379",
+		RedisMonitorInterval: defaultRedisMonitorInterval,
+		ListenAddr:           defaultListenAddr,
+		ConsistencyCheck:     true,
+
+		MaxHandSize:         defaultMaxHandSize,
+		ShuffleCooldown:     defaultShuffleCooldown,
+		RevealDelay:         defaultRevealDelay,
+		BombDecisionTimeout: defaultBombDecisionTimeout,
+		NopeWindow:          defaultNopeWindow,
+		TurnTimeout:         defaultTurnTimeout,
+		SoloDeck:            soloDeckConfig,
+
+		GameLockTTL:             defaultGameLockTTL,
+		GameCreationLockTTL:     defaultGameCreationLockTTL,
+		MaxActiveGames:          defaultMaxActiveGames,
+		DailyGameQuota:          defaultDailyGameQuota,
+		APIKeyCacheTTL:          defaultAPIKeyCacheTTL,
+		APIKeyRate:              defaultAPIKeyRate,
+		ChatBlocklist:           defaultChatBlocklist,
+		BroadcastWorkers:        defaultBroadcastWorkers,
+		WSMaxConnections:        defaultMaxConns,
+		WSMaxConnectionsPerUser: defaultMaxUserConns,
+		PingInterval:            defaultPingInterval,
+		RoomMaxPause:            defaultMaxRoomPause,
+		TournamentNoShowTimeout: defaultNoShowTimeout,
+		DrawLatencyBudget:       defaultDrawBudget,
+		FinishedGameRetention:   defaultFinishedGameRetention,
+		OutboxClaimAfter:        defaultOutboxClaimAfter,
+		LeaderboardMaxStaleness: defaultLeaderboardMaxStaleness,
+		SessionTTL:              defaultSessionTTL,
+		IdempotencyTTL:          defaultIdempotencyTTL,
+		PresenceTTL:             defaultPresenceTTL,
+
+		PendingDrawTimeout:        defaultPendingDrawTimeout,
+		OutboxBatch:               defaultOutboxBatch,
+		PresenceSweepInterval:     defaultPresenceSweepInterval,
+		MatchmakingInterval:       defaultMatchmakingInterval,
+		FinishedGameSweepInterval: defaultFinishedGameSweepInterval,
+		PendingDrawInterval:       defaultPendingDrawInterval,
+		OutboxInterval:            defaultOutboxInterval,
+
+		Retention: defaultRetention,
+		Cheats:    defaultCheatPolicy,
+	}
+}
+
+// One environment variable of the configuration
+type configSetting struct {
+	env string
+	// Shown redacted by GET /admin/config, and never echoed in errors
+	secret bool
+	// Set the setting from the variable's value, which isn't empty
+	parse func(c *Config, raw string) error
+	// The setting as loaded
+	show func(c *Config) string
+}
+
+func stringSetting(env string, field func(*Config) *string) configSetting {
+	return configSetting{
+		env:   env,
+		parse: func(c *Config, raw string) error { *field(c) = raw; return nil },
+		show:  func(c *Config) string { return *field(c) },
+	}
+}
+
+func secretSetting(env string, field func(*Config) *string) configSetting {
+	setting := stringSetting(env, field)
+	setting.secret = true
+	return setting
+}
+
+func boolSetting(env string, field func(*Config) *bool) configSetting {
+	return configSetting{
+		env: env,
+		parse: func(c *Config, raw string) error {
+			value, err := strconv.ParseBool(raw)
+			if err != nil {
+				return fmt.Errorf("must be true or false")
+			}
+			*field(c) = value
+			return nil
+		},
+		show: func(c *Config) string { return strconv.FormatBool(*field(c)) },
+	}
+}
+
+func intSetting(env string, min int, field func(*Config) *int) configSetting {
+	return configSetting{
+		env: env,
+		parse: func(c *Config, raw string) error {
+			value, err := strconv.Atoi(raw)
+			if err != nil || value < min {
+				return fmt.Errorf("must be a whole number of at least %d", min)
+			}
+			*field(c) = value
+			return nil
+		},
+		show: func(c *Config) string { return strconv.Itoa(*field(c)) },
+	}
+}
+
+func int64Setting(env string, min int64, field func(*Config) *int64) configSetting {
+	return configSetting{
+		env: env,
+		parse: func(c *Config, raw string) error {
+			value, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || value < min {
+				return fmt.Errorf("must be a whole number of at least %d", min)
+			}
+			*field(c) = value
+			return nil
+		},
+		show: func(c *Config) string { return strconv.FormatInt(*field(c), 10) },
+	}
+}
+
+// A duration such as "1.5s"; min 0 takes any that isn't negative, and 1ns
+// any above zero
+func durationSetting(env string, min time.Duration, field func(*Config) *time.Duration) configSetting {
+	return configSetting{
+		env: env,
+		parse: func(c *Config, raw string) error {
+			value, err := time.ParseDuration(raw)
+			switch {
+			case err != nil:
+				return fmt.Errorf("must be a duration such as 1.5s or 10m")
+			case value < min && min <= time.Nanosecond:
+				if min == 0 {
+					return fmt.Errorf("must not be negative")
+				}
+				return fmt.Errorf("must be above zero")
+			case value < min:
+				return fmt.Errorf("must be at least %v", min)
+			}
+			*field(c) = value
+			return nil
+		},
+		show: func(c *Config) string { return field(c).String() },
+	}
+}
+
+// Every variable read into the Config, each at most once
+var configSettings = []configSetting{
+	{
+		env: "STORE",
+		parse: func(c *Config, raw string) error {
+			if raw != "redis" && raw != "memory" {
+				return fmt.Errorf("must be redis or memory")
+			}
+			c.Store = raw
+			return nil
+		},
+		show: func(c *Config) string { return c.Store },
+	},
+	{
+		env: "KEY_PREFIX",
+		parse: func(c *Config, raw string) error {
+			if !keyPrefixPattern.MatchString(raw) {
+				return fmt.Errorf("only letters, digits and _ . : - are allowed")
+			}
+			c.KeyPrefix = raw
+			return nil
+		},
+		show: func(c *Config) string { return c.KeyPrefix },
+	},
+	stringSetting("REDIS_ADDR", func(c *Config) *string { return &c.RedisAddr }),
+	secretSetting("REDIS_PASSWORD", func(c *Config) *string { return &c.RedisPassword }),
+	durationSetting("REDIS_MONITOR_INTERVAL", time.Second, func(c *Config) *time.Duration { return &c.RedisMonitorInterval }),
+	{
+		// The tagged key names differ from the single-node ones, so a
+		// deployment's data doesn't carry over when it switches
+		env: "REDIS_CLUSTER_ADDRS",
+		parse: func(c *Config, raw string) error {
+			var addrs []string
+			for _, addr := range strings.Split(raw, ",") {
+				addr = strings.TrimSpace(addr)
+				if _, _, err := net.SplitHostPort(addr); err != nil {
+					return fmt.Errorf("%q is not a host:port", addr)
+				}
+				addrs = append(addrs, addr)
+			}
+			c.RedisClusterAddrs = addrs
+			return nil
+		},
+		show: func(c *Config) string { return strings.Join(c.RedisClusterAddrs, ",") },
+	},
+
+	stringSetting("LISTEN_ADDR", func(c *Config) *string { return &c.ListenAddr }),
+	stringSetting("TLS_CERT_FILE", func(c *Config) *string { return &c.TLSCertFile }),
+	stringSetting("TLS_KEY_FILE", func(c *Config) *string { return &c.TLSKeyFile }),
+	stringSetting("HTTP_REDIRECT_ADDR", func(c *Config) *string { return &c.HTTPRedirectAddr }),
+	{
+		env:   "ALLOWED_ORIGINS",
+		parse: func(c *Config, raw string) error { c.AllowedOrigins = parseAllowedOrigins(raw); return nil },
+		show:  func(c *Config) string { return strings.Join(c.AllowedOrigins, ",") },
+	},
+	stringSetting("APP_ENV", func(c *Config) *string { return &c.AppEnv }),
+	boolSetting("DEBUG", func(c *Config) *bool { return &c.Debug }),
+	secretSetting("ADMIN_TOKEN", func(c *Config) *string { return &c.AdminToken }),
+	secretSetting("INVITE_SECRET", func(c *Config) *string { return &c.InviteSecret }),
+	// The URL may carry the receiver's credentials
+	secretSetting("EVENT_WEBHOOK_URL", func(c *Config) *string { return &c.EventWebhookURL }),
+	boolSetting("CONSISTENCY_CHECK", func(c *Config) *bool { return &c.ConsistencyCheck }),
+
+	intSetting("MAX_HAND_SIZE", 1, func(c *Config) *int { return &c.MaxHandSize }),
+	intSetting("SHUFFLE_COOLDOWN", 0, func(c *Config) *int { return &c.ShuffleCooldown }),
+	durationSetting("BOMB_REVEAL_DELAY", 0, func(c *Config) *time.Duration { return &c.RevealDelay }),
+	durationSetting("BOMB_DECISION_TIMEOUT", time.Nanosecond, func(c *Config) *time.Duration { return &c.BombDecisionTimeout }),
+	durationSetting("NOPE_WINDOW", 0, func(c *Config) *time.Duration { return &c.NopeWindow }),
+	boolSetting("FAST_FORWARD_INEVITABLE", func(c *Config) *bool { return &c.FastForwardInevitable }),
+	intSetting("TURN_TIMEOUT", 1, func(c *Config) *int { return &c.TurnTimeout }),
+	{
+		env: "SOLO_DECK",
+		parse: func(c *Config, raw string) error {
+			deck, err := parseDeckConfig(raw)
+			if err != nil {
+				return err
+			}
+			c.SoloDeck = deck
+			return nil
+		},
+		show: func(c *Config) string { return c.SoloDeck.String() },
+	},
+
+	boolSetting("GAME_LOCKS", func(c *Config) *bool { return &c.GameLocks }),
+	durationSetting("GAME_LOCK_TTL", time.Millisecond, func(c *Config) *time.Duration { return &c.GameLockTTL }),
+	durationSetting("GAME_CREATION_LOCK_TTL", time.Millisecond, func(c *Config) *time.Duration { return &c.GameCreationLockTTL }),
+	int64Setting("MAX_ACTIVE_GAMES", 1, func(c *Config) *int64 { return &c.MaxActiveGames }),
+	int64Setting("DAILY_GAME_QUOTA", 1, func(c *Config) *int64 { return &c.DailyGameQuota }),
+	durationSetting("API_KEY_CACHE_TTL", 0, func(c *Config) *time.Duration { return &c.APIKeyCacheTTL }),
+	intSetting("API_KEY_RATE", 1, func(c *Config) *int { return &c.APIKeyRate }),
+	{
+		// A comma-separated list; "-" masks nothing
+		env: "CHAT_BLOCKLIST",
+		parse: func(c *Config, raw string) error {
+			c.ChatBlocklist = nil
+			for _, word := range strings.Split(strings.TrimPrefix(raw, "-"), ",") {
+				if word = strings.TrimSpace(word); word != "" {
+					c.ChatBlocklist = append(c.ChatBlocklist, word)
+				}
+			}
+			return nil
+		},
+		show: func(c *Config) string {
+			if len(c.ChatBlocklist) == 0 {
+				return "-"
+			}
+			return strings.Join(c.ChatBlocklist, ",")
+		},
+	},
+	intSetting("BROADCAST_WORKERS", 1, func(c *Config) *int { return &c.BroadcastWorkers }),
+	intSetting("WS_MAX_CONNECTIONS", 1, func(c *Config) *int { return &c.WSMaxConnections }),
+	intSetting("WS_MAX_CONNECTIONS_PER_USER", 1, func(c *Config) *int { return &c.WSMaxConnectionsPerUser }),
+	durationSetting("WS_PING_INTERVAL", time.Second, func(c *Config) *time.Duration { return &c.PingInterval }),
+	durationSetting("ROOM_MAX_PAUSE", time.Nanosecond, func(c *Config) *time.Duration { return &c.RoomMaxPause }),
+	durationSetting("TOURNAMENT_NO_SHOW_TIMEOUT", time.Nanosecond, func(c *Config) *time.Duration { return &c.TournamentNoShowTimeout }),
+	durationSetting("DRAW_LATENCY_BUDGET", time.Nanosecond, func(c *Config) *time.Duration { return &c.DrawLatencyBudget }),
+	durationSetting("FINISHED_GAME_RETENTION", time.Nanosecond, func(c *Config) *time.Duration { return &c.FinishedGameRetention }),
+	durationSetting("OUTBOX_CLAIM_AFTER", time.Second, func(c *Config) *time.Duration { return &c.OutboxClaimAfter }),
+	durationSetting("LEADERBOARD_MAX_STALENESS", 0, func(c *Config) *time.Duration { return &c.LeaderboardMaxStaleness }),
+	durationSetting("SESSION_TTL", time.Minute, func(c *Config) *time.Duration { return &c.SessionTTL }),
+	durationSetting("IDEMPOTENCY_TTL", time.Second, func(c *Config) *time.Duration { return &c.IdempotencyTTL }),
+	durationSetting("PRESENCE_TTL", time.Second, func(c *Config) *time.Duration { return &c.PresenceTTL }),
+	durationSetting("PENDING_DRAW_TIMEOUT", time.Second, func(c *Config) *time.Duration { return &c.PendingDrawTimeout }),
+	intSetting("OUTBOX_BATCH", 1, func(c *Config) *int { return &c.OutboxBatch }),
+	durationSetting("PRESENCE_SWEEP_INTERVAL", time.Second, func(c *Config) *time.Duration { return &c.PresenceSweepInterval }),
+	durationSetting("MATCHMAKING_INTERVAL", 100*time.Millisecond, func(c *Config) *time.Duration { return &c.MatchmakingInterval }),
+	durationSetting("FINISHED_GAME_SWEEP_INTERVAL", time.Second, func(c *Config) *time.Duration { return &c.FinishedGameSweepInterval }),
+	durationSetting("PENDING_DRAW_INTERVAL", time.Second, func(c *Config) *time.Duration { return &c.PendingDrawInterval }),
+	durationSetting("OUTBOX_INTERVAL", time.Second, func(c *Config) *time.Duration { return &c.OutboxInterval }),
+
+	int64Setting("EVENT_LOG_CAP", 1, func(c *Config) *int64 { return &c.Retention.Events }),
+	int64Setting("MOVE_LOG_CAP", 1, func(c *Config) *int64 { return &c.Retention.Moves }),
+	int64Setting("AUDIT_LOG_CAP", 1, func(c *Config) *int64 { return &c.Retention.Audit }),
+	durationSetting("LOG_RETENTION_TTL", time.Nanosecond, func(c *Config) *time.Duration { return &c.Retention.LogTTL }),
+
+	int64Setting("CHEAT_MIN_GAMES", 0, func(c *Config) *int64 { return &c.Cheats.MinGames }),
+	{
+		env: "CHEAT_MAX_WIN_RATE",
+		parse: func(c *Config, raw string) error {
+			rate, err := strconv.ParseFloat(raw, 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return fmt.Errorf("must be above 0 and at most 1")
+			}
+			c.Cheats.MaxWinRate = rate
+			return nil
+		},
+		show: func(c *Config) string { return strconv.FormatFloat(c.Cheats.MaxWinRate, 'g', -1, 64) },
+	},
+	int64Setting("CHEAT_MAX_GAMES_PER_MINUTE", 0, func(c *Config) *int64 { return &c.Cheats.MaxGamesPerMinute }),
+	boolSetting("CHEAT_ZERO_DRAW_WINS", func(c *Config) *bool { return &c.Cheats.ZeroDrawWins }),
+}
+
+// Read the configuration through lookup, e.g. os.LookupEnv, over the
+// defaults, and check it. An empty variable counts as unset. The error lists
+// every problem found, not just the first.
+func loadConfig(lookup func(string) (string, bool)) (Config, error) {
+	config := defaultConfig()
+	var problems []string
+	for _, setting := range configSettings {
+		raw, _ := lookup(setting.env)
+		if raw == "" {
+			continue
+		}
+		if err := setting.parse(&config, raw); err != nil {
+			if setting.secret {
+				problems = append(problems, fmt.Sprintf("invalid %s: %v", setting.env, err))
+			} else {
+				problems = append(problems, fmt.Sprintf("invalid %s %q: %v", setting.env, raw, err))
+			}
+		}
+	}
+	problems = append(problems, config.validate()...)
+	if len(problems) > 0 {
+		return config, fmt.Errorf("%d problems: %s", len(problems), strings.Join(problems, "; "))
+	}
+
+	// Defaults that follow from other settings
+	if config.AllowedOrigins == nil {
+		config.AllowedOrigins = defaultAllowedOrigins
+		if config.listen().tls() {
+			config.AllowedOrigins = defaultTLSAllowedOrigins
+		}
+	}
+	if config.HTTPRedirectAddr != "" && !config.listen().tls() {
+		log.Println("Warning: HTTP_REDIRECT_ADDR is ignored without TLS_CERT_FILE and TLS_KEY_FILE")
+		config.HTTPRedirectAddr = ""
+	}
+	return config, nil
+}
+
+// The rules that hold across settings, or on a setting's range where it
+// depends on the rest; one line for each broken
+func (c Config) validate() []string {
+	var problems []string
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		problems = append(problems, "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.AppEnv == "production" && len(c.AllowedOrigins) == 0 {
+		problems = append(problems, "ALLOWED_ORIGINS must list at least one origin when APP_ENV=production")
+	}
+	if c.TurnTimeout < minTurnTimeout || c.TurnTimeout > maxTurnTimeout {
+		problems = append(problems, fmt.Sprintf("TURN_TIMEOUT must be between %d and %d seconds", minTurnTimeout, maxTurnTimeout))
+	}
+	if c.PingInterval >= c.PresenceTTL {
+		problems = append(problems, "WS_PING_INTERVAL must be shorter than PRESENCE_TTL, or players on a quiet socket go offline")
+	}
+	if c.RevealDelay >= c.OutboxClaimAfter {
+		problems = append(problems, "BOMB_REVEAL_DELAY must be shorter than OUTBOX_CLAIM_AFTER, or the outbox announces games again before they are revealed")
+	}
+	return problems
+}
+
+// Whether the /debug routes, which reveal bomb positions, are registered:
+// APP_ENV=development or DEBUG=true. Anywhere else they 404.
+func (c Config) debugRoutes() bool {
+	return c.AppEnv == "development" || c.Debug
+}
+
+func (c Config) listen() listenConfig {
+	return listenConfig{
+		addr:         c.ListenAddr,
+		certFile:     c.TLSCertFile,
+		keyFile:      c.TLSKeyFile,
+		redirectAddr: c.HTTPRedirectAddr,
+	}
+}
+
+// Admin config route: every setting by its variable, as loaded
+type AdminConfigResponse struct {
+	// Secrets read redactedValue when they are set, "" when they aren't
+	Settings map[string]string `json:"settings"`
+}
+
+// The settings as /admin/config shows them, with the secrets redacted
+func (c Config) introspect() map[string]string {
+	settings := make(map[string]string, len(configSettings))
+	for _, setting := range configSettings {
+		value := setting.show(&c)
+		if setting.secret && value != "" {
+			value = redactedValue
+		}
+		settings[setting.env] = value
+	}
+	return settings
+}
+
+// Admin config route: the configuration this instance loaded at startup,
+// for ops to check a deploy picked up what they meant it to
+func (s *Server) adminConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, AdminConfigResponse{Settings: s.config.introspect()})
+}
+
+// Load the configuration from the environment, exiting with every problem
+// listed if it is invalid
+func mustLoadConfig() Config {
+	config, err := loadConfig(os.LookupEnv)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	return config
+}
+
+// Parse a deck written as Type=Count pairs separated by commas, e.g.
+// "Cat=2,Defuse=1,Shuffle=1,Exploding Kitten=1". Every card must be
+// registered and counted once, and the deck must hold a bomb and add up to
+// between minSoloDeckSize and maxSoloDeckSize cards.
+func parseDeckConfig(raw string) (DeckConfig, error) {
+	var deck DeckConfig
+	seen := make(map[string]bool)
+	for _, pair := range strings.Split(raw, ",") {
+		cardType, rawCount, ok := strings.Cut(pair, "=")
+		cardType = strings.TrimSpace(cardType)
+		count, err := strconv.Atoi(strings.TrimSpace(rawCount))
+		if !ok || err != nil || count < 1 {
+			return DeckConfig{}, fmt.Errorf("%q is not a card type and a count of at least 1", strings.TrimSpace(pair))
+		}
+		if _, ok := lookupCard(cardType); !ok {
+			return DeckConfig{}, fmt.Errorf("unknown card %q", cardType)
+		}
+		if seen[cardType] {
+			return DeckConfig{}, fmt.Errorf("card %q is counted twice", cardType)
+		}
+		seen[cardType] = true
+		deck.Cards = append(deck.Cards, CardCount{cardType, count})
+		deck.Size += count
+	}
+	bombs := 0
+	for _, card := range deck.Cards {
+		if card.Type == "Exploding Kitten" {
+			bombs = card.Count
+		}
+	}
+	if bombs == 0 || bombs == deck.Size {
+		return DeckConfig{}, fmt.Errorf("the deck needs an Exploding Kitten and a card that isn't one")
+	}
+	if deck.Size < minSoloDeckSize || deck.Size > maxSoloDeckSize {
+		return DeckConfig{}, fmt.Errorf("the deck has %d cards, but must have between %d and %d", deck.Size, minSoloDeckSize, maxSoloDeckSize)
+	}
+	return deck, nil
+}
+
+// The deck as SOLO_DECK writes it
+func (cfg DeckConfig) String() string {
+	pairs := make([]string, len(cfg.Cards))
+	for i, card := range cfg.Cards {
+		pairs[i] = card.Type + "=" + strconv.Itoa(card.Count)
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The consistency check reads keys in batches of consistencyScanBatch with
+// a pause between them, about 1000 keys a second, so it never hammers a
+// production Redis
+const (
+	consistencyScanBatch = 100
+	consistencyScanPause = 100 * time.Millisecond
+)
+
+// Notes a report keeps; past these only the counts go on
+const maxConsistencyNotes = 100
+
+// What a consistency check found. Repairs are only ever made where the
+// intent of the data is clear; anything else is counted as unrepairable and
+// left for someone to look at.
+type ConsistencyReport struct {
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// Keys looked at
+	Checked      int64 `json:"checked"`
+	Repaired     int64 `json:"repaired"`
+	Unrepairable int64 `json:"unrepairable"`
+	// A line for each key repaired or left broken, the first
+	// maxConsistencyNotes of them
+	Notes []string `json:"notes"`
+	// Why the check stopped early, if it did
+	Error string `json:"error,omitempty"`
+}
+
+func (r *ConsistencyReport) note(format string, args ...interface{}) {
+	note := fmt.Sprintf(format, args...)
+	log.Printf("Consistency check: %s", note)
+	if len(r.Notes) < maxConsistencyNotes {
+		r.Notes = append(r.Notes, note)
+	}
+}
+
+// Record a key put right
+func (r *ConsistencyReport) repaired(format string, args ...interface{}) {
+	r.Repaired++
+	r.note(format, args...)
+}
+
+// Record a key that is wrong in a way the check can't safely fix
+func (r *ConsistencyReport) unrepairable(format string, args ...interface{}) {
+	r.Unrepairable++
+	r.note(format, args...)
+}
+
+// The cards of a stored list the registry no longer knows, each named once
+func retiredCards(cards []string) []string {
+	var retired []string
+	seen := make(map[string]bool)
+	for _, card := range cards {
+		if _, ok := lookupCard(card); !ok && !seen[card] {
+			seen[card] = true
+			retired = append(retired, card)
+		}
+	}
+	return retired
+}
+
+// The integer a defuse count written as a boolean flag stood for, if it was
+// one
+func defuseFlagCount(value string) (string, bool) {
+	held, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", false
+	}
+	if held {
+		return "1", true
+	}
+	return "0", true
+}
+
+// Sleeps consistencyScanPause after every consistencyScanBatch keys
+type scanPacer struct {
+	keys int
+}
+
+func (p *scanPacer) wait(ctx context.Context) error {
+	p.keys++
+	if p.keys%consistencyScanBatch != 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(consistencyScanPause):
+		return nil
+	}
+}
+
+// The last consistency check of this instance, and whether one is running
+type consistencyState struct {
+	mutex   sync.Mutex
+	running bool
+	last    *ConsistencyReport
+}
+
+// Admin consistency route
+type AdminConsistencyResponse struct {
+	Running bool `json:"running"`
+	// The last check to finish; nil if none has since the server started
+	Last *ConsistencyReport `json:"last"`
+}
+
+// Check the stored data for known corruptions, repairing what is safe to,
+// unless a check is already running. The summary goes to the logs and to
+// GET /admin/consistency.
+func (s *Server) checkConsistency(ctx context.Context) {
+	s.consistency.mutex.Lock()
+	if s.consistency.running {
+		s.consistency.mutex.Unlock()
+		return
+	}
+	s.consistency.running = true
+	s.consistency.mutex.Unlock()
+
+	report := &ConsistencyReport{StartedAt: s.clock.Now(), Notes: []string{}}
+	if err := s.store.CheckConsistency(ctx, report); err != nil {
+		log.Printf("Error checking stored data: %v", err)
+		report.Error = err.Error()
+	}
+	finished := s.clock.Now()
+	report.FinishedAt = &finished
+	log.Printf("Consistency check: %d keys checked, %d repaired, %d unrepairable", report.Checked, report.Repaired, report.Unrepairable)
+
+	s.consistency.mutex.Lock()
+	s.consistency.running = false
+	s.consistency.last = report
+	s.consistency.mutex.Unlock()
+}
+
+func (s *Server) consistencyStatus() AdminConsistencyResponse {
+	s.consistency.mutex.Lock()
+	defer s.consistency.mutex.Unlock()
+	return AdminConsistencyResponse{Running: s.consistency.running, Last: s.consistency.last}
+}
+
+// Admin consistency route: how the last consistency check went
+func (s *Server) adminConsistency(c *gin.Context) {
+	c.JSON(http.StatusOK, s.consistencyStatus())
+}
+
+// Admin consistency check route: start a consistency check in the
+// background, e.g. after a bad deploy. Poll GET /admin/consistency for how
+// it went.
+func (s *Server) adminCheckConsistency(c *gin.Context) {
+	go s.checkConsistency(context.Background())
+	log.Println("Admin started a consistency check")
+	s.audit(c, s.adminAuditEntry(c, "check_consistency", ""))
+	c.JSON(http.StatusAccepted, AdminConsistencyResponse{Running: true, Last: s.consistencyStatus().Last})
+}
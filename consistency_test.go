@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Start a consistency check from the admin API and wait for its report,
+// told apart from the last one by its start time
+func (ts *testServer) runConsistencyCheck() *ConsistencyReport {
+	ts.t.Helper()
+	ts.clock.Advance(time.Second)
+	started := ts.clock.Now()
+	w := ts.post("/admin/consistency", nil, asAdmin...)
+	if w.Code != http.StatusAccepted {
+		ts.t.Fatalf("starting a consistency check: %d %s", w.Code, w.Body.String())
+	}
+	var status AdminConsistencyResponse
+	eventually(ts.t, "the consistency check", func() bool {
+		status = decodeOK[AdminConsistencyResponse](ts.t, ts.get("/admin/consistency", asAdmin...))
+		return !status.Running && status.Last != nil && status.Last.StartedAt.Equal(started)
+	})
+	return status.Last
+}
+
+func TestConsistencyCheckRepairsKnownCorruptions(t *testing.T) {
+	ctx := context.Background()
+	store := newTestRedisStore(t, keyBuilder{})
+	ts := newTestServerWith(t, store, testConfig(t, map[string]string{"ADMIN_TOKEN": testAdminToken}))
+	rdb := store.rdb
+	name := store.keys.name
+
+	// What bad deploys left behind
+	rdb.Set(ctx, store.keys.key("defuse:alice"), "1", 0)
+	rdb.HSet(ctx, store.keys.user("bob"), "defuse", "true")
+	rdb.HSet(ctx, store.keys.user("carol"), "defuse", "false")
+	rdb.HSet(ctx, store.keys.user("dave"), "defuse", "maybe")
+	rdb.HSet(ctx, store.keys.user("erin"), "defuse", "1", "streak", "lots")
+	rdb.RPush(ctx, store.keys.deck("frank"), "Cat", "Barking Kitten", "Cat", "Nope", "Barking Kitten", "Imploding Kitten")
+	rdb.Set(ctx, store.keys.hand("gina"), "Cat", 0)
+	rdb.HSet(ctx, store.keys.game("hank"), "schemaVersion", currentGameSchema+1)
+
+	report := ts.runConsistencyCheck()
+	if report.Checked < 8 || report.Repaired != 4 || report.Unrepairable != 4 || report.Error != "" || report.FinishedAt == nil {
+		t.Fatalf("report = %+v", report)
+	}
+	want := []string{
+		"deleted orphaned key " + name(store.keys.key("defuse:alice")),
+		`set defuse of ` + name(store.keys.user("bob")) + ` from "true" to 1`,
+		`set defuse of ` + name(store.keys.user("carol")) + ` from "false" to 0`,
+		name(store.keys.user("dave")) + ` has defuse "maybe", which isn't a count`,
+		name(store.keys.user("erin")) + ` has streak "lots", which isn't a count`,
+		`dropped retired cards ["Barking Kitten" "Imploding Kitten"] from ` + name(store.keys.deck("frank")),
+		name(store.keys.hand("gina")) + " holds a string, not a list",
+		name(store.keys.game("hank")) + ` has schemaVersion "` + strconv.Itoa(currentGameSchema+1) + `"`,
+	}
+	notes := append([]string{}, report.Notes...)
+	sort.Strings(notes)
+	sort.Strings(want)
+	if !reflect.DeepEqual(notes, want) {
+		t.Fatalf("notes = %q\nwant %q", notes, want)
+	}
+
+	// The repairs themselves
+	if n := rdb.Exists(ctx, store.keys.key("defuse:alice")).Val(); n != 0 {
+		t.Fatal("the orphaned defuse key is still there")
+	}
+	for username, count := range map[string]string{"bob": "1", "carol": "0", "dave": "maybe"} {
+		if got := rdb.HGet(ctx, store.keys.user(username), "defuse").Val(); got != count {
+			t.Fatalf("%s's defuse = %q, want %q", username, got, count)
+		}
+	}
+	if deck := ts.deck("frank"); !reflect.DeepEqual(deck, []string{"Cat", "Cat", "Nope"}) {
+		t.Fatalf("frank's deck = %v", deck)
+	}
+	if defuse, err := store.GetDefuse(ctx, "bob"); err != nil || defuse != 1 {
+		t.Fatalf("GetDefuse(bob) = %d, %v", defuse, err)
+	}
+
+	// Nothing is left to repair, and the unrepairable are still reported
+	if again := ts.runConsistencyCheck(); again.Repaired != 0 || again.Unrepairable != 4 {
+		t.Fatalf("second report = %+v", again)
+	}
+	entries := decodeOK[AdminAuditResponse](t, ts.get("/admin/audit", asAdmin...)).Entries
+	if len(entries) != 2 || entries[0].Action != "check_consistency" {
+		t.Fatalf("audit = %+v", entries)
+	}
+}
+
+func TestConsistencyCheckDropsRetiredCardsFromMemoryDecks(t *testing.T) {
+	ts := newTestServerWith(t, newMemoryStore(), testConfig(t, map[string]string{"ADMIN_TOKEN": testAdminToken}))
+	ts.startGame("alice", "Cat", "Barking Kitten", "Nope")
+	ts.startGame("bob", "Cat")
+
+	report := ts.runConsistencyCheck()
+	if report.Checked != 2 || report.Repaired != 1 || report.Unrepairable != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	if deck := ts.deck("alice"); !reflect.DeepEqual(deck, []string{"Cat", "Nope"}) {
+		t.Fatalf("alice's deck = %v", deck)
+	}
+}
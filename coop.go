@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"exploding-kitten/engine"
+)
+
+// Whether the room's players win or lose together
+func (r *Room) coop() bool {
+	return engine.SharedResult(r.Mode)
+}
+
+// The player sharing username's fate in a co-op room, "" in any other
+func (r *Room) partnerOf(username string) string {
+	if !r.coop() {
+		return ""
+	}
+	for _, player := range r.Players {
+		if player != username {
+			return player
+		}
+	}
+	return ""
+}
+
+// The drawing player's co-op partner, "" outside a co-op room
+func coopPartner(game *GameSession) string {
+	if game.Room == nil {
+		return ""
+	}
+	return game.Room.partnerOf(game.Username)
+}
+
+// The Defuses the player can spend on a bomb, given own, how many they hold:
+// in a co-op room their partner's count as well
+func (s *Server) pooledDefuses(ctx context.Context, game *GameSession, own int) (int, *APIError) {
+	partner := coopPartner(game)
+	if partner == "" {
+		return own, nil
+	}
+	theirs, err := s.store.GetDefuse(ctx, partner)
+	if err != nil {
+		log.Printf("Error retrieving defuse status for user %s: %v", partner, err)
+		return 0, errStoreUnavailable("Error retrieving defuse status")
+	}
+	return own + theirs, nil
+}
+
+// Spend a Defuse on the player's bomb, given pooled, the Defuses they could
+// spend. A co-op player who holds none spends their partner's. Returns the
+// Defuses left to spend.
+func (s *Server) spendDefuse(ctx context.Context, game *GameSession, pooled int) (int, error) {
+	partner := coopPartner(game)
+	if partner == "" {
+		return s.store.UseDefuse(ctx, game.ID, game.Username)
+	}
+	holder := game.Username
+	own, err := s.store.GetDefuse(ctx, game.Username)
+	if err != nil {
+		return 0, err
+	}
+	if own == 0 {
+		holder = partner
+	}
+	if _, err := s.store.UseDefuse(ctx, game.ID, holder); err != nil {
+		return 0, err
+	}
+	return pooled - 1, nil
+}
+
+// End a co-op game whose deck the player has cleared of everything but
+// bombs: they and their partner win together
+func (s *Server) winCoopGame(ctx context.Context, game *GameSession) (*GameCompletion, *APIError) {
+	partner := coopPartner(game)
+	outcome := gameOutcome{
+		Winner:  game.Username,
+		Partner: partner,
+		Message: fmt.Sprintf("%s cleared the deck. %s and %s win together!", game.Username, game.Username, partner),
+	}
+	completion, apiErr := s.completeGame(ctx, game, outcome)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	s.reportGameFinished(ctx, game, game.Username, "win")
+	s.reportGameFinished(ctx, game, partner, "win")
+	s.announceGameOver(ctx, game, outcome)
+	log.Printf("Co-op game in room %s won by %s and %s", game.Room.Code, game.Username, partner)
+	return completion, nil
+}
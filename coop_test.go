@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// Open a co-op room of alice and bob, with empty hands and the deck given
+func (ts *testServer) openCoopRoom(deck ...string) *Room {
+	ts.t.Helper()
+	created := decodeOK[RoomResponse](ts.t, ts.post("/create-room", CreateRoomRequest{Username: "alice", Mode: RoomCoop}))
+	decodeOK[RoomResponse](ts.t, ts.post("/join-room", RoomRequest{Username: "bob", Code: created.Code}))
+	room := ts.room(created.Code)
+	ts.setDeck(room.gameID(), deck...)
+	ts.deal("alice")
+	ts.deal("bob")
+	return room
+}
+
+func TestCoopPlayersWinTogether(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		for _, req := range []CreateRoomRequest{{Username: "carol", Mode: "team"}, {Username: "carol", Mode: RoomCoop, Size: 3}, {Username: "carol", Mode: RoomCoop, VsBot: true}} {
+			assertError(t, ts.post("/create-room", req), http.StatusBadRequest, ErrCodeInvalidRequest)
+		}
+
+		room := ts.openCoopRoom("Cat", "Tacocat", engine.ExplodingKitten)
+		if room.Mode != RoomCoop {
+			t.Fatalf("room mode = %q", room.Mode)
+		}
+		socket := ts.dial("room=" + room.Code)
+		socket.next("snapshot")
+		first, second := room.Turn, room.nextAlive(room.Turn)
+
+		// Turns still alternate, and clearing the deck wins it for both
+		decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: first, GameID: room.gameID()}))
+		assertError(t, ts.post("/draw-card", User{Username: first, GameID: room.gameID()}), http.StatusForbidden, ErrCodeNotYourTurn)
+		if drawn := decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: second, GameID: room.gameID()})); drawn.GameStatus != GameStatusWon {
+			t.Fatalf("clearing draw = %+v", drawn)
+		}
+		ts.clock.Advance(ts.revealDelay)
+		over := decodeMessage[RoomEvent](t, socket.next("game_over"))
+		if !over.Shared || over.Winner != second || over.Partner != first || over.Loser != "" {
+			t.Fatalf("game_over = %+v", over)
+		}
+		for _, player := range []string{"alice", "bob"} {
+			if win, lose := ts.stats(player); win != 1 || lose != 0 {
+				t.Fatalf("%s's stats = %d/%d, want 1/0", player, win, lose)
+			}
+		}
+	})
+}
+
+func TestCoopPlayersLoseTogether(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openCoopRoom(engine.ExplodingKitten, "Cat", engine.ExplodingKitten, "Cat")
+		socket := ts.dial("room=" + room.Code)
+		socket.next("snapshot")
+		first, second := room.Turn, room.nextAlive(room.Turn)
+
+		// The partner's Defuse saves the player who has none
+		ts.deal(second, engine.Defuse)
+		drawn := decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: first, GameID: room.gameID()}))
+		if drawn.Disposition != DispositionPendingDefuse || drawn.DefuseCount != 1 {
+			t.Fatalf("bomb drawn with the partner's Defuse = %+v", drawn)
+		}
+		useDefuse := true
+		defused := decodeOK[DrawCardResponse](t, ts.post("/resolve-bomb", ResolveBombRequest{Username: first, GameID: room.gameID(), UseDefuse: &useDefuse}))
+		if defused.Disposition != DispositionDefused || defused.DefuseCount != 0 {
+			t.Fatalf("defusing = %+v", defused)
+		}
+		if hand := ts.hand(second); len(hand) != 0 {
+			t.Fatalf("%s still holds %v after their Defuse was spent", second, hand)
+		}
+
+		// With the pool spent, the next bomb ends it for both
+		ts.setDeck(room.gameID(), engine.ExplodingKitten, "Cat")
+		turn := ts.room(room.Code).Turn
+		partner := room.partnerOf(turn)
+		if drawn := decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: turn, GameID: room.gameID()})); drawn.Disposition != DispositionExploded {
+			t.Fatalf("bomb drawn with no Defuse left = %+v", drawn)
+		}
+		ts.clock.Advance(ts.revealDelay)
+		over := decodeMessage[RoomEvent](t, socket.next("game_over"))
+		if !over.Shared || over.Winner != "" || over.Loser != turn || over.Partner != partner {
+			t.Fatalf("game_over = %+v", over)
+		}
+		for _, player := range []string{"alice", "bob"} {
+			if win, lose := ts.stats(player); win != 0 || lose != 1 {
+				t.Fatalf("%s's stats = %d/%d, want 0/1", player, win, lose)
+			}
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A solo game as stored, for the debug deck route
+type DebugDeckResponse struct {
+	Username string `json:"username"`
+	// Remaining cards, top first
+	Deck   []string `json:"deck" sensitive:"true"`
+	Hand   []string `json:"hand" sensitive:"true"`
+	Defuse int      `json:"defuse"`
+	// "ordered", or "legacy" for decks drawn from at random
+	DeckFormat string            `json:"deckFormat"`
+	Game       map[string]string `json:"game" sensitive:"true"`
+}
+
+// Debug deck route: the player's deck in draw order, hand and game hash
+func (s *Server) debugDeck(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	username, apiErr := adminUsername(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	deck, err := s.store.GetDeck(ctx, username)
+	if err != nil {
+		log.Printf("Error retrieving deck for user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving deck"))
+		return
+	}
+	hand, err := s.store.GetHand(ctx, username)
+	if err != nil {
+		log.Printf("Error retrieving hand for user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving hand"))
+		return
+	}
+	defuse, err := s.store.GetDefuse(ctx, username)
+	if err != nil {
+		log.Printf("Error retrieving defuse count for user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving defuse count"))
+		return
+	}
+	game, err := s.store.GetGameHash(ctx, username)
+	if err != nil {
+		log.Printf("Error retrieving game hash for user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving game"))
+		return
+	}
+
+	format := "legacy"
+	if version, _ := strconv.Atoi(game["deckVersion"]); version >= orderedDeckVersion {
+		format = "ordered"
+	}
+
+	c.JSON(http.StatusOK, DebugDeckResponse{
+		Username:   username,
+		Deck:       deck,
+		Hand:       hand,
+		Defuse:     defuse,
+		DeckFormat: format,
+		Game:       game,
+	})
+}
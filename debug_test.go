@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+func TestDebugDeckInDevelopment(t *testing.T) {
+	for _, env := range []map[string]string{{"APP_ENV": "development"}, {"DEBUG": "true"}} {
+		eachGameStore(t, func(t *testing.T, store GameStore) {
+			ts := newTestServerWith(t, store, testConfig(t, env))
+			ts.startGame("alice", "Cat", engine.Defuse, engine.ExplodingKitten, "Skip")
+			decodeOK[DrawCardResponse](t, ts.draw("alice"))
+			decodeOK[DrawCardResponse](t, ts.draw("alice"))
+
+			dump := decodeOK[DebugDeckResponse](t, ts.get("/debug/deck/alice"))
+			if !reflect.DeepEqual(dump.Deck, []string{engine.ExplodingKitten, "Skip"}) {
+				t.Fatalf("deck = %v, want the draw order", dump.Deck)
+			}
+			if !reflect.DeepEqual(dump.Hand, []string{"Cat", engine.Defuse}) || dump.Defuse != 1 {
+				t.Fatalf("hand = %v with %d defuses", dump.Hand, dump.Defuse)
+			}
+			if dump.DeckFormat != "ordered" || dump.Game["status"] != GameStatusActive {
+				t.Fatalf("format %q, game %v", dump.DeckFormat, dump.Game)
+			}
+		})
+	}
+}
+
+func TestDebugRoutesAbsentInProduction(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{"APP_ENV": "production", "ALLOWED_ORIGINS": "https://catburst.example.com"}))
+		ts.startGame("alice")
+
+		// Not there at all, rather than forbidden
+		for _, req := range []struct{ method, path string }{
+			{http.MethodGet, "/debug/deck/alice"},
+			{http.MethodPost, "/debug/seed"},
+			{http.MethodDelete, "/debug/seed"},
+		} {
+			if w := ts.request(req.method, req.path, nil); w.Code != http.StatusNotFound {
+				t.Fatalf("%s %s = %d, want 404", req.method, req.path, w.Code)
+			}
+		}
+	})
+}
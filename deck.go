@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+
+	"exploding-kitten/engine"
+)
+
+// How many of a card type go into a deck
+type CardCount struct {
+	Type  string
+	Count int
+}
+
+// The composition of a deck
+type DeckConfig struct {
+	Cards []CardCount
+	// Expected number of cards, checked against Cards when building
+	Size int
+}
+
+// Deck dealt to a solo player by /start-game and by a drawn Shuffle, unless
+// SOLO_DECK says otherwise
+var soloDeckConfig = DeckConfig{
+	Cards: []CardCount{
+		{"Cat", 2},
+		{"Defuse", 1},
+		{"Shuffle", 1},
+		{"Exploding Kitten", 1},
+	},
+	Size: 5,
+}
+
+// Deck of a survival game: long, with bombs enough that nobody draws it out
+var survivalDeckConfig = DeckConfig{
+	Cards: []CardCount{
+		{"Cat", 19},
+		{"Defuse", 3},
+		{"Shuffle", 3},
+		{"Exploding Kitten", 5},
+	},
+	Size: 30,
+}
+
+// The deck a solo game of the mode is dealt
+func (s *Server) soloDeckFor(mode string) DeckConfig {
+	if mode == ModeSurvival {
+		return survivalDeckConfig
+	}
+	return s.soloDeck
+}
+
+// Shared deck for a two-player room; see roomDeckFor
+var roomDeckConfig = DeckConfig{
+	Cards: []CardCount{
+		{"Cat", 2},
+		{"Tacocat", 2},
+		{"Rainbow Cat", 2},
+		{"Beard Cat", 2},
+		{"Defuse", 2},
+		{"Shuffle", 1},
+		{"Favor", 2},
+		{"Skip", 2},
+		{"Attack", 2},
+		{"Nope", 2},
+		{"Draw From Bottom", 1},
+		{"See the Future", 1},
+		{"Exploding Kitten", 1},
+	},
+	Size: 22,
+}
+
+// The shared deck for a room of the given number of players: one bomb fewer
+// than there are players, so the game ends with the last player standing.
+// Disabled cards are left out, and if that leaves fewer than
+// minRoomDeckCards other cards, the first cat card still in makes up the
+// difference.
+func roomDeckFor(players int, disabled []string) DeckConfig {
+	left := make(map[string]bool, len(disabled))
+	for _, card := range disabled {
+		left[card] = true
+	}
+
+	var cfg DeckConfig
+	cards := 0
+	for _, count := range roomDeckConfig.Cards {
+		if left[count.Type] {
+			continue
+		}
+		if count.Type == "Exploding Kitten" {
+			count.Count = players - 1
+		} else {
+			cards += count.Count
+		}
+		cfg.Cards = append(cfg.Cards, count)
+	}
+	for i := range cfg.Cards {
+		if cards < minRoomDeckCards && catCards[cfg.Cards[i].Type] {
+			cfg.Cards[i].Count += minRoomDeckCards - cards
+			cards = minRoomDeckCards
+		}
+	}
+	cfg.Size = cards + players - 1
+	return cfg
+}
+
+// A fresh random source for shuffling a deck no game is played with, such as
+// /debug/seed's. A game's decks are shuffled by its seeded source; see
+// Server.gameRNG.
+func newDeckRand() *rand.Rand {
+	return rand.New(rand.NewSource(rand.Int63()))
+}
+
+// Build a shuffled deck. Cards are drawn from it in list order. A config
+// with a card missing from the registry, without a bomb, or whose counts
+// don't add up to its size is a programming error and panics.
+func buildDeck(cfg DeckConfig, rng engine.RNG) []string {
+	deck := make([]string, 0, cfg.Size)
+	for _, card := range cfg.Cards {
+		if _, ok := lookupCard(card.Type); !ok {
+			panic(fmt.Sprintf("deck has unregistered card %q", card.Type))
+		}
+		for i := 0; i < card.Count; i++ {
+			deck = append(deck, card.Type)
+		}
+	}
+
+	if len(deck) != cfg.Size {
+		panic(fmt.Sprintf("deck has %d cards, expected %d", len(deck), cfg.Size))
+	}
+	bombs := 0
+	for _, card := range deck {
+		if card == "Exploding Kitten" {
+			bombs++
+		}
+	}
+	if bombs == 0 {
+		panic("deck has no Exploding Kitten")
+	}
+
+	rng.Shuffle(len(deck), func(i, j int) {
+		deck[i], deck[j] = deck[j], deck[i]
+	})
+	return deck
+}
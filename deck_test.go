@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+var testSeed = [engine.SeedSize]byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+func TestSeededDeckDrawsInShuffledOrder(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		deck := buildDeck(soloDeckConfig, engine.SeededRNG(testSeed))
+		if again := buildDeck(soloDeckConfig, engine.SeededRNG(testSeed)); !reflect.DeepEqual(deck, again) {
+			t.Fatalf("one seed shuffled to %v and %v", deck, again)
+		}
+		store.CreateDeck(ctx, "alice", deck)
+
+		var drawn []string
+		for range deck {
+			version, _ := store.GameVersion(ctx, "alice")
+			card, err := store.DrawCard(ctx, "alice", "alice", version, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			drawn = append(drawn, card.Card)
+		}
+		if !reflect.DeepEqual(drawn, deck) {
+			t.Fatalf("drew %v, want the shuffled order %v", drawn, deck)
+		}
+	})
+}
+
+func TestDrawFromBottomTakesLastCard(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", engine.ExplodingKitten, "Skip")
+		ts.deal("alice", "Draw From Bottom")
+
+		drawn := decodeOK[DrawCardResponse](t, ts.play("alice", "", "Draw From Bottom"))
+		if drawn.Card.Type != "Skip" || drawn.Remaining != 2 {
+			t.Fatalf("draw = %+v", drawn)
+		}
+		if deck := ts.deck("alice"); !reflect.DeepEqual(deck, []string{"Cat", engine.ExplodingKitten}) {
+			t.Fatalf("deck = %v", deck)
+		}
+		if next := decodeOK[DrawCardResponse](t, ts.draw("alice")); next.Card.Type != "Cat" {
+			t.Fatalf("top draw after it = %+v", next)
+		}
+	})
+}
+
+func TestLegacyDeckStillDraws(t *testing.T) {
+	store := newTestRedisStore(t, keyBuilder{})
+	ctx := context.Background()
+	deck := []string{"Cat", "Skip", engine.Defuse}
+	store.CreateDeck(ctx, "alice", deck)
+	// As a deck created before the ordered model was
+	store.rdb.HDel(ctx, store.keys.game("alice"), "deckVersion")
+
+	var drawn []string
+	for range deck {
+		version, _ := store.GameVersion(ctx, "alice")
+		card, err := store.DrawCard(ctx, "alice", "alice", version, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drawn = append(drawn, card.Card)
+	}
+	sort.Strings(drawn)
+	sort.Strings(deck)
+	if !reflect.DeepEqual(drawn, deck) {
+		t.Fatalf("drew %v from the legacy deck %v", drawn, deck)
+	}
+}
+
+func TestBuildDeckComposition(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  DeckConfig
+	}{
+		{"solo", soloDeckConfig},
+		{"survival", survivalDeckConfig},
+		{"two-player room", roomDeckFor(2, nil)},
+		{"four-player room", roomDeckFor(4, nil)},
+		{"room without Nope", roomDeckFor(3, []string{"Nope"})},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deck := buildDeck(test.cfg, newDeckRand())
+			if len(deck) != test.cfg.Size {
+				t.Fatalf("deck has %d cards, want %d", len(deck), test.cfg.Size)
+			}
+			want := make(map[string]int)
+			for _, count := range test.cfg.Cards {
+				want[count.Type] += count.Count
+			}
+			if got := countCards(deck); !reflect.DeepEqual(got, want) {
+				t.Fatalf("deck holds %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRoomDeckHasOneBombFewerThanPlayers(t *testing.T) {
+	for players := 2; players <= 5; players++ {
+		if bombs := countCards(buildDeck(roomDeckFor(players, nil), newDeckRand()))[engine.ExplodingKitten]; bombs != players-1 {
+			t.Fatalf("%d players: %d bombs", players, bombs)
+		}
+	}
+}
+
+func TestBuildDeckPanicsOnBadConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  DeckConfig
+	}{
+		{"no bomb", DeckConfig{Cards: []CardCount{{"Cat", 2}}, Size: 2}},
+		{"wrong size", DeckConfig{Cards: []CardCount{{"Cat", 2}, {engine.ExplodingKitten, 1}}, Size: 5}},
+		{"unregistered card", DeckConfig{Cards: []CardCount{{"Dog", 1}, {engine.ExplodingKitten, 1}}, Size: 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("buildDeck didn't panic")
+				}
+			}()
+			buildDeck(test.cfg, newDeckRand())
+		})
+	}
+}
+
+func TestShuffleKeepsHeldDefuse(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", engine.Defuse, engine.Shuffle, "Cat", engine.ExplodingKitten)
+
+		if drawn := decodeOK[DrawCardResponse](t, ts.draw("alice")); drawn.DefuseCount != 1 {
+			t.Fatalf("defuse = %d after drawing it", drawn.DefuseCount)
+		}
+		drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if drawn.Card.Type != engine.Shuffle || drawn.DefuseCount != 1 {
+			t.Fatalf("draw = %+v, want the Shuffle with the Defuse kept", drawn)
+		}
+		if hand := ts.hand("alice"); !reflect.DeepEqual(hand, []string{engine.Defuse}) {
+			t.Fatalf("hand = %v", hand)
+		}
+		if got := len(ts.deck("alice")); got != ts.soloDeck.Size {
+			t.Fatalf("fresh deck has %d cards", got)
+		}
+	})
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"exploding-kitten/engine"
+)
+
+// A batch's rolls, one per card it may draw, are below this. The store puts
+// a bomb defused in the batch back in at its roll modulo the deck's size
+// plus one; see GameStore.DrawCards.
+const batchRollLimit = 1 << 30
+
+// How a card drawn in a batch was settled
+const (
+	DrawHeld     = string(engine.CardHeld)
+	DrawDefused  = string(engine.BombDefused)
+	DrawExploded = string(engine.Exploded)
+	DrawShuffle  = string(engine.Reshuffle)
+)
+
+// A card drawn by DrawCards and what happened to it
+type BatchDraw struct {
+	Card    string
+	Outcome string
+}
+
+type DrawCardsRequest struct {
+	Username string `json:"username"`
+	GameID   string `json:"gameId"`
+	Count    int    `json:"count"`
+}
+
+// Draw cards route: several draws in one request, as many as an Attack left
+// the player owing. The draws and their effect on the hand commit together in
+// the store, so either all of them happen or none do. The batch stops early
+// at a bomb, a Shuffle, or once only bombs are left.
+func (s *Server) drawCards(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req DrawCardsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+	if req.Count < 1 {
+		abortWithError(c, errInvalidRequest("count must be at least 1"))
+		return
+	}
+
+	game, apiErr := s.resolveMove(ctx, User{Username: req.Username, GameID: req.GameID})
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	ctx, done := s.timeDraw(ctx, game)
+	defer done()
+	if apiErr := s.checkCanDraw(ctx, game); apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if game.Room != nil {
+		if apiErr := s.checkTurn(game.Room, req.Username); apiErr != nil {
+			abortWithError(c, apiErr)
+			return
+		}
+		// A batch only settles bombs against the drawing player's own Defuses
+		if game.Room.coop() {
+			abortWithError(c, errInvalidRequest("Co-op games draw one card at a time"))
+			return
+		}
+	}
+	// Only an Attack makes a player draw more than one card
+	limit, apiErr := s.drawLimit(ctx, game)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if req.Count > limit {
+		abortWithError(c, errInvalidRequest(fmt.Sprintf("count must be between 1 and %d", limit)))
+		return
+	}
+	if game.Room != nil {
+		if apiErr := s.claimTurn(ctx, game.Room); apiErr != nil {
+			abortWithError(c, apiErr)
+			return
+		}
+	}
+
+	response, apiErr := s.performDraws(ctx, game, req.Count)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Draw and settle up to count cards, then apply what the last card set off
+func (s *Server) performDraws(ctx context.Context, game *GameSession, count int) (*DrawCardsResponse, *APIError) {
+	log.Printf("User %s is drawing %d cards", game.Username, count)
+
+	// The rolls come from the game's seeded source, like every other
+	// random choice of the game
+	rng, err := s.gameRNG(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error retrieving the seeded source of game %s: %v", game.ID, err)
+		return nil, errStoreUnavailable("Error retrieving game")
+	}
+	rolls := make([]int64, count)
+	for i := range rolls {
+		rolls[i] = int64(rng.Intn(batchRollLimit))
+	}
+	if err := s.saveGameRNG(ctx, game.ID, rng); err != nil {
+		log.Printf("Error saving the seeded source of game %s: %v", game.ID, err)
+		return nil, errStoreUnavailable("Error updating game")
+	}
+
+	var draws []BatchDraw
+	var remaining int
+	raced, err := s.drawAtVersion(ctx, game, func(version int64) error {
+		var err error
+		draws, remaining, err = s.store.DrawCards(ctx, game.ID, game.Username, version, rolls)
+		return err
+	})
+	if err == errVersionConflict {
+		return nil, errConflict()
+	}
+	if err != nil {
+		log.Printf("Error drawing cards for user %s: %v", game.Username, err)
+		return nil, errStoreUnavailable("Error drawing cards")
+	}
+	if len(draws) == 0 {
+		// See performDraw
+		if raced {
+			return nil, errConflict()
+		}
+		return nil, s.handleEmptyDeck(ctx, game)
+	}
+	if apiErr := s.loadMode(ctx, game); apiErr != nil {
+		return nil, apiErr
+	}
+
+	results := make([]BatchDrawResult, len(draws))
+	for i, draw := range draws {
+		card := cardFor(cardTheme(ctx, game), draw.Card)
+		logGameEvent(game.Username, game.ID, map[string]any{"event": "draw", "card": draw.Card, "outcome": draw.Outcome, "batch": i + 1})
+		drawsTotal.WithLabelValues(card.Type).Inc()
+		s.notifyCardDrawn(ctx, game, draw.Card, remaining+len(draws)-i-1)
+		results[i] = BatchDrawResult{Card: card, Outcome: draw.Outcome, Disposition: dispositionOf(engine.EventType(draw.Outcome))}
+		s.announceDraw(game, &DrawCardResponse{Disposition: results[i].Disposition})
+		// The last card is logged once it is known how the batch ended
+		if i < len(draws)-1 {
+			s.recordMove(ctx, game, MoveDraw, draw.Card, GameStatusActive)
+		}
+	}
+
+	// Only the last card of a batch can end it with more to do
+	last := draws[len(draws)-1]
+	lastResult := &results[len(results)-1]
+	switch last.Outcome {
+	case DrawExploded:
+		explosion, apiErr := s.handleExplosion(ctx, game, cardFor(cardTheme(ctx, game), last.Card))
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		lastResult.Message = explosion.Message
+		lastResult.MessageID = explosion.MessageID
+		s.recordMove(ctx, game, MoveDraw, last.Card, GameStatusLost)
+		return &DrawCardsResponse{
+			Results:    results,
+			Remaining:  remaining,
+			GameStatus: GameStatusLost,
+			Winner:     explosion.Winner,
+			Version:    s.gameVersion(ctx, game.ID),
+			Summary:    game.summaries[game.Username],
+		}, nil
+
+	case DrawDefused:
+		// A batch settles its bombs as it draws them, so a held Defuse is
+		// spent without asking; see Server.holdBomb for single draws
+		lastResult.MessageID = MsgBombDefused
+		lastResult.Message = localize(ctx, MsgBombDefused)
+		if game.Room != nil {
+			s.hub.broadcastRoomAfter(s.revealDelay, game.Room.Code, RoomEvent{Type: "bomb_defused", Username: game.Username, Card: findCard(cardTheme(ctx, game), last.Card)})
+		}
+
+	case DrawShuffle:
+		// Too soon after the last Shuffle the card is spent for nothing
+		claimed, apiErr := s.claimShuffle(ctx, game)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if !claimed {
+			lastResult.MessageID = MsgShuffleCooling
+			lastResult.Message = localize(ctx, MsgShuffleCooling)
+			break
+		}
+		if err := s.reshuffle(ctx, game); err != nil {
+			return nil, errStoreUnavailable("Error reshuffling deck")
+		}
+		// A solo reshuffle deals a new deck
+		if deck, err := s.store.GetDeck(ctx, game.ID); err == nil {
+			remaining = len(deck)
+		}
+		lastResult.MessageID = MsgReshuffled
+		lastResult.Message = localize(ctx, MsgReshuffled)
+	}
+
+	// A room's turn only passes on once the player has drawn all they owe
+	if _, err := s.finishDraws(ctx, game, len(draws)); err != nil {
+		log.Printf("Error ending turn of user %s in game %s: %v", game.Username, game.ID, err)
+		return nil, errStoreUnavailable("Error ending turn")
+	}
+
+	status := GameStatusActive
+	forced := false
+	if game.Room == nil && last.Outcome != DrawShuffle {
+		// A classic solo player who has drawn everything but the bombs has won
+		deck, err := s.store.GetDeck(ctx, game.ID)
+		if err != nil {
+			log.Printf("Error retrieving deck for game %s: %v", game.ID, err)
+			return nil, errStoreUnavailable("Error retrieving deck")
+		}
+		rules := &engine.Game{Deck: deck, Mode: game.Mode}
+		if rules.Won() {
+			_, message, apiErr := s.winSoloGame(ctx, game)
+			if apiErr != nil {
+				return nil, apiErr
+			}
+			lastResult.MessageID = MsgDeckCleared
+			lastResult.Message = strings.TrimSpace(lastResult.Message + " " + localize(ctx, MsgDeckCleared) + " " + message)
+			status = GameStatusWon
+		}
+		forced = rules.ForcedOutcome() == engine.StatusLost
+		if forced && s.fastForwardInevitable {
+			left, err := s.store.GetDefuse(ctx, game.Username)
+			if err != nil {
+				log.Printf("Error retrieving defuse status for user %s: %v", game.Username, err)
+				return nil, errStoreUnavailable("Error retrieving defuse status")
+			}
+			if s.shouldFastForward(game, forced, left) {
+				over := &DrawCardResponse{Card: cardFor(cardTheme(ctx, game), last.Card), Message: lastResult.Message}
+				if apiErr := s.fastForwardLoss(ctx, game, over); apiErr != nil {
+					return nil, apiErr
+				}
+				lastResult.MessageID = over.MessageID
+				lastResult.Message = over.Message
+				status = over.GameStatus
+			}
+		}
+	}
+
+	response := &DrawCardsResponse{Results: results, Remaining: remaining, GameStatus: status, InevitableLoss: forced}
+	if status == GameStatusActive {
+		hand, blocked, apiErr := s.enforceHandLimit(ctx, game)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if blocked {
+			response.GameStatus = GameStatusMustDiscard
+			response.Hand = cardsFor(cardTheme(ctx, game), hand)
+		}
+	}
+	s.recordMove(ctx, game, MoveDraw, last.Card, response.GameStatus)
+	response.Version = s.gameVersion(ctx, game.ID)
+	response.Summary = game.summaries[game.Username]
+	return response, nil
+}
+
+// A drawn Shuffle reshuffles a room's shared deck or a survival deck, or
+// deals a classic solo player a fresh one
+func (s *Server) reshuffle(ctx context.Context, game *GameSession) error {
+	if game.Room != nil || game.Mode == ModeSurvival {
+		return s.shuffleDeck(ctx, game.ID)
+	}
+	return s.resetGame(ctx, game.Username)
+}
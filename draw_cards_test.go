@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+func (ts *testServer) drawCards(username string, count int) *DrawCardsResponse {
+	ts.t.Helper()
+	response := decodeOK[DrawCardsResponse](ts.t, ts.post("/draw-cards", DrawCardsRequest{Username: username, Count: count}))
+	return &response
+}
+
+// Leave the player owing draws in the game, as an Attack would
+func (ts *testServer) owe(gameID, username string, draws int) {
+	ts.t.Helper()
+	if err := ts.store.SetPendingDraws(context.Background(), gameID, username, draws); err != nil {
+		ts.t.Fatalf("SetPendingDraws: %v", err)
+	}
+}
+
+// The card types and dispositions of a batch's results
+func batchOutcomes(response *DrawCardsResponse) []string {
+	var outcomes []string
+	for _, result := range response.Results {
+		outcomes = append(outcomes, result.Card.Type+":"+result.Disposition)
+	}
+	return outcomes
+}
+
+func TestDrawCardsStopsAtBomb(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", engine.ExplodingKitten, "Skip", "Cat")
+		ts.owe("alice", "alice", 3)
+
+		drawn := ts.drawCards("alice", 3)
+		if want := []string{"Cat:" + DispositionHeld, engine.ExplodingKitten + ":" + DispositionExploded}; !reflect.DeepEqual(batchOutcomes(drawn), want) {
+			t.Fatalf("results = %v, want %v", batchOutcomes(drawn), want)
+		}
+		if drawn.GameStatus != GameStatusLost {
+			t.Fatalf("status = %q", drawn.GameStatus)
+		}
+		// The third draw never happened
+		if deck := ts.deck("alice"); !reflect.DeepEqual(deck, []string{"Skip", "Cat"}) {
+			t.Fatalf("deck = %v", deck)
+		}
+		if _, lose := ts.stats("alice"); lose != 1 {
+			t.Fatalf("losses = %d, want 1", lose)
+		}
+	})
+}
+
+func TestDrawCardsDefusesBombAndStops(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", engine.ExplodingKitten, "Skip", "Cat")
+		ts.deal("alice", engine.Defuse)
+		ts.owe("alice", "alice", 3)
+
+		drawn := ts.drawCards("alice", 3)
+		if want := []string{"Cat:" + DispositionHeld, engine.ExplodingKitten + ":" + DispositionDefused}; !reflect.DeepEqual(batchOutcomes(drawn), want) {
+			t.Fatalf("results = %v, want %v", batchOutcomes(drawn), want)
+		}
+		if drawn.GameStatus != GameStatusActive || drawn.Remaining != 3 {
+			t.Fatalf("status = %q with %d left", drawn.GameStatus, drawn.Remaining)
+		}
+		if hand := ts.hand("alice"); !reflect.DeepEqual(hand, []string{"Cat"}) {
+			t.Fatalf("hand = %v, want the Cat with the Defuse spent", hand)
+		}
+	})
+}
+
+func TestDrawCardsFromShortDeck(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", "Skip", engine.ExplodingKitten)
+		ts.owe("alice", "alice", 3)
+
+		drawn := ts.drawCards("alice", 3)
+		if want := []string{"Cat:" + DispositionHeld, "Skip:" + DispositionHeld}; !reflect.DeepEqual(batchOutcomes(drawn), want) {
+			t.Fatalf("results = %v, want %v", batchOutcomes(drawn), want)
+		}
+		// Only the bomb is left, which wins the game
+		if drawn.GameStatus != GameStatusWon || drawn.Remaining != 1 {
+			t.Fatalf("status = %q with %d left", drawn.GameStatus, drawn.Remaining)
+		}
+		if win, _ := ts.stats("alice"); win != 1 {
+			t.Fatalf("wins = %d, want 1", win)
+		}
+	})
+}
+
+func TestDrawCardsValidatesCount(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", "Skip", engine.ExplodingKitten)
+		// Nothing owed, so one card at a time
+		for _, count := range []int{0, -1, 2} {
+			assertError(t, ts.post("/draw-cards", DrawCardsRequest{Username: "alice", Count: count}), http.StatusBadRequest, ErrCodeInvalidRequest)
+		}
+		// No more than is owed
+		ts.owe("alice", "alice", 2)
+		assertError(t, ts.post("/draw-cards", DrawCardsRequest{Username: "alice", Count: 3}), http.StatusBadRequest, ErrCodeInvalidRequest)
+		if got := len(ts.deck("alice")); got != 3 {
+			t.Fatalf("deck has %d cards after rejected batches", got)
+		}
+	})
+}
+
+// Attack the player whose turn is next in a fresh two-player room and let
+// the Nope window lapse, returning the room and who attacked whom
+func attackInRoom(t *testing.T, ts *testServer, deck ...string) (*Room, string, string) {
+	t.Helper()
+	room := ts.openRoom("alice", "bob")
+	attacker, target := room.Turn, room.nextAlive(room.Turn)
+	ts.setDeck(room.gameID(), deck...)
+	ts.deal(attacker, "Attack")
+	if w := ts.play(attacker, room.gameID(), "Attack"); w.Code != http.StatusAccepted {
+		t.Fatalf("playing Attack = %d: %s", w.Code, w.Body.String())
+	}
+	ts.clock.Advance(ts.nopeWindow)
+	if turn := ts.room(room.Code).Turn; turn != target {
+		t.Fatalf("turn = %q after the Attack, want %q", turn, target)
+	}
+	return room, attacker, target
+}
+
+func TestAttackedPlayerDrawsTwoInOneBatch(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room, attacker, target := attackInRoom(t, ts, "Cat", "Tacocat", "Beard Cat", engine.ExplodingKitten)
+
+		// Two owed, so three is an over-draw
+		assertError(t, ts.post("/draw-cards", DrawCardsRequest{Username: target, GameID: room.gameID(), Count: 3}), http.StatusBadRequest, ErrCodeInvalidRequest)
+		if deck := ts.deck(room.gameID()); len(deck) != 4 {
+			t.Fatalf("deck = %v after the rejected batch", deck)
+		}
+
+		drawn := decodeOK[DrawCardsResponse](t, ts.post("/draw-cards", DrawCardsRequest{Username: target, GameID: room.gameID(), Count: 2}))
+		if want := []string{"Cat:" + DispositionHeld, "Tacocat:" + DispositionHeld}; !reflect.DeepEqual(batchOutcomes(&drawn), want) {
+			t.Fatalf("results = %v, want %v", batchOutcomes(&drawn), want)
+		}
+		// The batch hands the turn on once, not once per card
+		if turn := ts.room(room.Code).Turn; turn != attacker {
+			t.Fatalf("turn = %q after the batch, want %q", turn, attacker)
+		}
+		if owed, err := ts.store.PendingDraws(context.Background(), room.gameID(), target); err != nil || owed != 0 {
+			t.Fatalf("still owed = %d, %v", owed, err)
+		}
+		// The attacker is back to drawing one card
+		assertError(t, ts.post("/draw-cards", DrawCardsRequest{Username: attacker, GameID: room.gameID(), Count: 2}), http.StatusBadRequest, ErrCodeInvalidRequest)
+	})
+}
+
+func TestAttackedPlayerKeepsTurnUntilPaid(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room, attacker, target := attackInRoom(t, ts, "Cat", "Tacocat", "Beard Cat", engine.ExplodingKitten)
+
+		decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: target, GameID: room.gameID()}))
+		if turn := ts.room(room.Code).Turn; turn != target {
+			t.Fatalf("turn = %q with a draw still owed, want %q", turn, target)
+		}
+		// Only the one draw left may be taken
+		assertError(t, ts.post("/draw-cards", DrawCardsRequest{Username: target, GameID: room.gameID(), Count: 2}), http.StatusBadRequest, ErrCodeInvalidRequest)
+
+		decodeOK[DrawCardsResponse](t, ts.post("/draw-cards", DrawCardsRequest{Username: target, GameID: room.gameID(), Count: 1}))
+		if turn := ts.room(room.Code).Turn; turn != attacker {
+			t.Fatalf("turn = %q once paid, want %q", turn, attacker)
+		}
+	})
+}
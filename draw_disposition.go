@@ -0,0 +1,102 @@
+package main
+
+import (
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+// Where a drawn card ended up, as /draw-card and the room's "card_drawn"
+// event report it
+const (
+	// The card went into the hand
+	DispositionHeld = "held"
+	// The card took effect as it was drawn and is gone
+	DispositionResolved = "resolved"
+	// A bomb with no Defuse to spend on it
+	DispositionExploded = "exploded"
+	// A bomb a held Defuse was spent on
+	DispositionDefused = "defused"
+	// A bomb the player holds a Defuse for and hasn't decided about yet; see
+	// Server.holdBomb
+	DispositionPendingDefuse = "pending_defuse"
+)
+
+// Kinds of DrawEffect
+const (
+	// A Defuse was spent; Remaining is how many are still held
+	EffectDefuseConsumed = "defuse_consumed"
+	// A Defuse went into the hand; Remaining is how many are held now
+	EffectDefuseGained = "defuse_gained"
+	// A defused bomb went back into the deck
+	EffectBombReturned = "bomb_returned"
+	// The remaining deck was shuffled in place
+	EffectDeckReshuffled = "deck_reshuffled"
+	// A classic solo game was dealt a fresh deck
+	EffectDeckReplaced = "deck_replaced"
+	// A Shuffle drawn too soon after the last one did nothing
+	EffectShuffleCooling = "shuffle_cooling"
+	// The hand went over the limit and the player must discard
+	EffectHandFull = "hand_full"
+	EffectGameWon  = "game_won"
+	EffectGameLost = "game_lost"
+)
+
+// A change to the game's state a draw caused
+type DrawEffect struct {
+	Type string `json:"type"`
+	// Set on defuse_consumed and defuse_gained
+	Remaining *int `json:"remaining,omitempty"`
+}
+
+// An effect that counts the player's Defuses
+func defuseEffect(effectType string, remaining int) DrawEffect {
+	return DrawEffect{Type: effectType, Remaining: &remaining}
+}
+
+// The disposition of a card the engine settled as event
+func dispositionOf(event engine.EventType) string {
+	switch event {
+	case engine.BombDefused:
+		return DispositionDefused
+	case engine.Exploded:
+		return DispositionExploded
+	case engine.Reshuffle:
+		return DispositionResolved
+	}
+	return DispositionHeld
+}
+
+// Whether the disposition gives away that the card was a bomb, which the
+// room only learns once the reveal delay is up
+func revealsBomb(disposition string) bool {
+	return disposition == DispositionDefused || disposition == DispositionExploded || disposition == DispositionPendingDefuse
+}
+
+// What a drawn Shuffle did to the game's deck; see Server.reshuffle
+func reshuffleEffect(game *GameSession) DrawEffect {
+	if game.Room != nil || game.Mode == ModeSurvival {
+		return DrawEffect{Type: EffectDeckReshuffled}
+	}
+	return DrawEffect{Type: EffectDeckReplaced}
+}
+
+// Tell the rest of the room a card was drawn and where it went, but not
+// which card it was. A bomb's draw waits for the reveal delay, and a Defuse
+// going into the hand isn't mentioned.
+func (s *Server) announceDraw(game *GameSession, response *DrawCardResponse) {
+	if game.Room == nil {
+		return
+	}
+	event := RoomEvent{Type: "card_drawn", Username: game.Username, Disposition: response.Disposition, Effects: []DrawEffect{}}
+	for _, effect := range response.Effects {
+		if effect.Type != EffectDefuseGained {
+			event.Effects = append(event.Effects, effect)
+		}
+	}
+	var delay time.Duration
+	if revealsBomb(response.Disposition) {
+		delay = s.revealDelay
+	}
+	s.hub.broadcastRoomAfter(delay, game.Room.Code, event)
+}
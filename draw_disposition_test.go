@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// Fail unless the disposition and effects are ones a client knows how to
+// show
+func assertValidDraw(t *testing.T, cardType, disposition string, effects []DrawEffect) {
+	t.Helper()
+	switch disposition {
+	case DispositionHeld, DispositionResolved, DispositionExploded, DispositionDefused, DispositionPendingDefuse:
+	default:
+		t.Fatalf("%s drew with disposition %q", cardType, disposition)
+	}
+	for _, effect := range effects {
+		switch effect.Type {
+		case EffectDefuseConsumed, EffectDefuseGained:
+			if effect.Remaining == nil {
+				t.Fatalf("%s drew with %s but no remaining count", cardType, effect.Type)
+			}
+		case EffectBombReturned, EffectDeckReshuffled, EffectDeckReplaced, EffectShuffleCooling,
+			EffectHandFull, EffectGameWon, EffectGameLost:
+		default:
+			t.Fatalf("%s drew with effect %q", cardType, effect.Type)
+		}
+	}
+}
+
+// The effect types in effects, in order
+func effectTypes(effects []DrawEffect) []string {
+	types := []string{}
+	for _, effect := range effects {
+		types = append(types, effect.Type)
+	}
+	return types
+}
+
+func TestEveryCardDrawsWithValidDisposition(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		for _, def := range cardRegistry {
+			ts.startGame("alice", def.Type, "Cat", "Cat")
+			ts.deal("alice")
+			drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+			assertValidDraw(t, def.Type, drawn.Disposition, drawn.Effects)
+			if drawn.Effects == nil {
+				t.Fatalf("%s drew with effects null, want a list", def.Type)
+			}
+
+			want, effects := def.Disposition, []string{}
+			switch def.Type {
+			case engine.ExplodingKitten:
+				effects = []string{EffectGameLost}
+			case engine.Shuffle:
+				effects = []string{EffectDeckReplaced}
+			case engine.Defuse:
+				effects = []string{EffectDefuseGained}
+			}
+			if drawn.Disposition != want || !reflect.DeepEqual(effectTypes(drawn.Effects), effects) {
+				t.Fatalf("%s drew as %s with %v, want %s with %v", def.Type, drawn.Disposition, effectTypes(drawn.Effects), want, effects)
+			}
+		}
+
+		// A bomb drawn holding a Defuse waits for the player, then is defused
+		ts.startGame("alice", engine.ExplodingKitten, "Cat", "Cat")
+		ts.deal("alice", engine.Defuse)
+		pending := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		assertValidDraw(t, engine.ExplodingKitten, pending.Disposition, pending.Effects)
+		if pending.Disposition != DispositionPendingDefuse {
+			t.Fatalf("bomb drawn with a Defuse = %+v", pending)
+		}
+		defused := ts.resolveBomb("alice", true)
+		assertValidDraw(t, engine.ExplodingKitten, defused.Disposition, defused.Effects)
+		if want := []string{EffectDefuseConsumed, EffectBombReturned}; defused.Disposition != DispositionDefused ||
+			!reflect.DeepEqual(effectTypes(defused.Effects), want) || *defused.Effects[0].Remaining != 0 {
+			t.Fatalf("defusing = %s with %+v, want %v", defused.Disposition, defused.Effects, want)
+		}
+	})
+}
+
+func TestRoomCardDrawnMirrorsDraw(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		for i, def := range cardRegistry {
+			room := ts.openRoom(fmt.Sprintf("alice%d", i), fmt.Sprintf("bob%d", i))
+			socket := ts.dial("room=" + room.Code)
+			socket.next("snapshot")
+			ts.setDeck(room.gameID(), def.Type, "Cat", "Cat")
+			for _, player := range room.Players {
+				ts.deal(player)
+			}
+
+			drawn := decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: room.Turn, GameID: room.gameID()}))
+			assertValidDraw(t, def.Type, drawn.Disposition, drawn.Effects)
+			if revealsBomb(drawn.Disposition) {
+				ts.clock.Advance(ts.revealDelay)
+			}
+
+			// The room hears the same, less the Defuse going into the hand. An
+			// event with no effects leaves them out.
+			event := decodeMessage[RoomEvent](t, socket.next("card_drawn"))
+			assertValidDraw(t, def.Type, event.Disposition, event.Effects)
+			want := []string{}
+			for _, effect := range effectTypes(drawn.Effects) {
+				if effect != EffectDefuseGained {
+					want = append(want, effect)
+				}
+			}
+			if event.Username != room.Turn || event.Disposition != drawn.Disposition || !reflect.DeepEqual(effectTypes(event.Effects), want) {
+				t.Fatalf("%s: card_drawn = %+v, draw was %s with %v", def.Type, event, drawn.Disposition, effectTypes(drawn.Effects))
+			}
+			socket.conn.Close()
+		}
+	})
+}
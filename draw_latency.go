@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// How long a draw may take before it is logged as slow, when
+// DRAW_LATENCY_BUDGET isn't set
+const defaultDrawBudget = 100 * time.Millisecond
+
+// The Redis commands one draw sent and how long each took
+type drawTimings struct {
+	mutex    sync.Mutex
+	commands []string
+}
+
+type drawTimingsKey struct{}
+
+type commandStartKey struct{}
+
+func (t *drawTimings) add(name string, took time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.commands = append(t.commands, name+"="+took.Round(time.Microsecond).String())
+}
+
+// The commands in the order they were sent, e.g. "hmget+hget=1.2ms,evalsha=3ms"
+func (t *drawTimings) String() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return strings.Join(t.commands, ",")
+}
+
+// Time the draw made with the returned context. The returned func, called
+// once the draw is over, logs the draw with the commands it sent if it took
+// longer than drawBudget.
+func (s *Server) timeDraw(ctx context.Context, game *GameSession) (context.Context, func()) {
+	timings := &drawTimings{}
+	start := time.Now()
+	return context.WithValue(ctx, drawTimingsKey{}, timings), func() {
+		took := time.Since(start)
+		if took <= s.drawBudget {
+			return
+		}
+		slowDrawsTotal.Inc()
+		logGameEvent(game.Username, game.ID, map[string]any{
+			"event":    "slow_draw",
+			"took":     took.Round(time.Microsecond),
+			"budget":   s.drawBudget,
+			"commands": timings.String(),
+		})
+	}
+}
+
+// A Redis hook timing every command sent on behalf of a draw in timeDraw
+type commandTimer struct{}
+
+func (commandTimer) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return startCommand(ctx), nil
+}
+
+func (commandTimer) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	recordCommand(ctx, cmd.Name())
+	return nil
+}
+
+func (commandTimer) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return startCommand(ctx), nil
+}
+
+// A pipeline is one round trip, so it is timed as one: hmget+hget=1.2ms
+func (commandTimer) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	names := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		if name := cmd.Name(); name != "multi" && name != "exec" {
+			names = append(names, name)
+		}
+	}
+	recordCommand(ctx, strings.Join(names, "+"))
+	return nil
+}
+
+func startCommand(ctx context.Context) context.Context {
+	if ctx.Value(drawTimingsKey{}) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, commandStartKey{}, time.Now())
+}
+
+func recordCommand(ctx context.Context, name string) {
+	timings, _ := ctx.Value(drawTimingsKey{}).(*drawTimings)
+	start, ok := ctx.Value(commandStartKey{}).(time.Time)
+	if timings == nil || !ok {
+		return
+	}
+	timings.add(name, time.Since(start))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// slowStore is a GameStore whose draws read the game's state delay late,
+// standing in for a Redis under load
+type slowStore struct {
+	GameStore
+	delay time.Duration
+}
+
+func (s *slowStore) DrawState(ctx context.Context, gameID, username string) (*DrawState, error) {
+	time.Sleep(s.delay)
+	return s.GameStore.DrawState(ctx, gameID, username)
+}
+
+// A Redis hook counting round trips: a pipeline is one
+type roundTripCounter struct{ trips atomic.Int64 }
+
+func (c *roundTripCounter) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	c.trips.Add(1)
+	return ctx, nil
+}
+
+func (c *roundTripCounter) AfterProcess(ctx context.Context, cmd redis.Cmder) error { return nil }
+
+func (c *roundTripCounter) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	c.trips.Add(1)
+	return ctx, nil
+}
+
+func (c *roundTripCounter) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestSlowDrawIsLogged(t *testing.T) {
+	store := newTestRedisStore(t, keyBuilder{})
+	store.rdb.AddHook(commandTimer{})
+	slow := &slowStore{GameStore: store, delay: 30 * time.Millisecond}
+	ts := newTestServerWith(t, slow, testConfig(t, map[string]string{"DRAW_LATENCY_BUDGET": "20ms"}))
+	ts.startGame("alice", "Cat", "Cat", "Cat")
+	capture := captureLog(t)
+	before := testutil.ToFloat64(slowDrawsTotal)
+
+	decodeOK[DrawCardResponse](t, ts.draw("alice"))
+	if got := testutil.ToFloat64(slowDrawsTotal) - before; got != 1 {
+		t.Fatalf("slow_draws_total went up by %v, want 1", got)
+	}
+	var warning string
+	for _, line := range strings.Split(capture.String(), "\n") {
+		if strings.HasPrefix(line, "event=slow_draw user=alice game=alice ") {
+			warning = line
+		}
+	}
+	if warning == "" || !strings.Contains(warning, " budget=20ms ") || !strings.Contains(warning, "commands=") {
+		t.Fatalf("no slow draw warning with the budget and commands in %q", capture)
+	}
+
+	// A draw inside the budget isn't mentioned
+	slow.delay = 0
+	decodeOK[DrawCardResponse](t, ts.draw("alice"))
+	if got := testutil.ToFloat64(slowDrawsTotal) - before; got != 1 || strings.Count(capture.String(), "event=slow_draw") != 1 {
+		t.Fatalf("a fast draw was logged as slow: %q", capture)
+	}
+}
+
+// Read a draw's checks and take its card straight from the store, as
+// drawTurn does
+func storeDraw(tb testing.TB, store GameStore, gameID string) {
+	tb.Helper()
+	ctx := context.Background()
+	state, err := store.DrawState(ctx, gameID, gameID)
+	if err != nil {
+		tb.Fatalf("DrawState: %v", err)
+	}
+	if _, err := store.DrawCard(ctx, gameID, gameID, state.Version, false, nil); err != nil {
+		tb.Fatalf("DrawCard: %v", err)
+	}
+}
+
+func TestDrawTakesTwoRoundTrips(t *testing.T) {
+	store := newTestRedisStore(t, keyBuilder{})
+	counter := &roundTripCounter{}
+	store.rdb.AddHook(counter)
+	ts := newTestServer(t, store)
+	ts.startGame("alice", "Cat", "Cat", "Cat")
+	// The first draw loads the script
+	storeDraw(t, store, "alice")
+
+	counter.trips.Store(0)
+	storeDraw(t, store, "alice")
+	if trips := counter.trips.Load(); trips != 2 {
+		t.Fatalf("a draw took %d round trips, want the checks and the pop", trips)
+	}
+}
+
+// Draws from a big deck, reporting roundtrips/op: one for the checks and one
+// for the pop, where reading each check on its own took four to six
+func BenchmarkDraw(b *testing.B) {
+	server := miniredis.RunT(b)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer rdb.Close()
+	counter := &roundTripCounter{}
+	rdb.AddHook(counter)
+	store := newRedisStore(rdb)
+	deck := make([]string, 1000)
+	for i := range deck {
+		deck[i] = "Cat"
+	}
+
+	var trips int64
+	for i := 0; i < b.N; i++ {
+		if i%len(deck) == 0 {
+			b.StopTimer()
+			if err := store.CreateDeck(context.Background(), "alice", deck); err != nil {
+				b.Fatalf("CreateDeck: %v", err)
+			}
+			b.StartTimer()
+		}
+		counter.trips.Store(0)
+		storeDraw(b, store, "alice")
+		trips += counter.trips.Load()
+	}
+	b.ReportMetric(float64(trips)/float64(b.N), "roundtrips/op")
+}
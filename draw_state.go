@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// What a draw checks before it takes a card, as GameStore.DrawState reads it
+type DrawState struct {
+	Status  string
+	Version int64
+	// Who has to discard or decide about a bomb before the game goes on, if
+	// anyone, and why
+	MustDiscard  string
+	BlockedCause string
+	// ModeClassic or ModeSurvival
+	Mode string
+	// Defuses the drawing player holds
+	Defuses int
+}
+
+// A card GameStore.DrawCard took off the deck
+type DrawnCard struct {
+	// "" when the deck was empty
+	Card      string
+	Remaining int
+	// Whether only bombs are left
+	Cleared bool
+}
+
+// Fill in game.draw the first time a draw needs it, and the mode with it.
+// Everything a draw looks at before taking its card comes from here, so
+// the checks and the draw share a single read.
+func (s *Server) loadDrawState(ctx context.Context, game *GameSession) (*DrawState, *APIError) {
+	if game.draw != nil {
+		return game.draw, nil
+	}
+	state, err := s.store.DrawState(ctx, game.ID, game.Username)
+	if err != nil {
+		log.Printf("Error retrieving draw state of game %s: %v", game.ID, err)
+		return nil, errStoreUnavailable("Error checking game status")
+	}
+	game.draw = state
+	return state, nil
+}
+
+// Reject a draw on a finished solo game or one waiting for a discard. Does
+// what checkGameActive and checkNotBlocked do, in one read.
+func (s *Server) checkCanDraw(ctx context.Context, game *GameSession) *APIError {
+	state, apiErr := s.loadDrawState(ctx, game)
+	if apiErr != nil {
+		return apiErr
+	}
+	if game.Room == nil && gameOver(state.Status) {
+		return errGameOver(state.Status)
+	}
+	if state.MustDiscard != "" {
+		return s.blockedError(state.MustDiscard, state.BlockedCause)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Add the player to the room's elimination order
+func (s *Server) recordElimination(ctx context.Context, room *Room, username string) *APIError {
+	eliminated, err := s.store.EliminatePlayer(ctx, room.Code, username)
+	if err != nil {
+		log.Printf("Error eliminating user %s from room %s: %v", username, room.Code, err)
+		return errStoreUnavailable("Error updating room")
+	}
+	room.Eliminated = eliminated
+	return nil
+}
+
+// Knock the player out of a room game that goes on without them. Their hand
+// is emptied, any draws they owe are dropped and the turn passes on if it
+// was theirs. They keep their socket
+// and follow the rest of the game. The room hears event, with the
+// elimination order filled in, after delay.
+func (s *Server) eliminatePlayer(ctx context.Context, game *GameSession, event RoomEvent, delay time.Duration) *APIError {
+	room := game.Room
+	if apiErr := s.recordElimination(ctx, room, game.Username); apiErr != nil {
+		return apiErr
+	}
+	if err := s.store.ClearHand(ctx, game.Username); err != nil {
+		log.Printf("Error clearing hand for user %s: %v", game.Username, err)
+		return errStoreUnavailable("Error clearing hand")
+	}
+	if err := s.store.SetPendingDraws(ctx, game.ID, game.Username, 0); err != nil {
+		log.Printf("Error clearing draws owed by user %s: %v", game.Username, err)
+		return errStoreUnavailable("Error updating game")
+	}
+	if room.Turn == game.Username {
+		if err := s.endTurn(ctx, room, game.Username); err != nil {
+			log.Printf("Error ending turn in room %s: %v", room.Code, err)
+			return errStoreUnavailable("Error ending turn")
+		}
+	}
+
+	log.Printf("User %s was eliminated from room %s, %d players left", game.Username, room.Code, len(room.alive()))
+	event.Type = "player_eliminated"
+	event.Username = game.Username
+	event.Eliminated = room.Eliminated
+	s.hub.broadcastRoomAfter(delay, room.Code, event)
+	return nil
+}
+
+// How a room game ends once the player has gone out as the second-to-last
+// player standing, given result, "lose" or "forfeit", for how they went out.
+// The last player standing wins and the first player out takes the loss;
+// nobody placed in between is credited either way. In a two-player room
+// that is simply the player and their opponent; in a co-op room, the player
+// takes their partner down with them.
+func roomOutcome(room *Room, username, result string) gameOutcome {
+	if partner := room.partnerOf(username); partner != "" {
+		return gameOutcome{Loser: username, LoserResult: result, Partner: partner, Eliminated: room.Eliminated}
+	}
+	outcome := gameOutcome{
+		Winner:      room.nextAlive(username),
+		Loser:       username,
+		LoserResult: result,
+		Eliminated:  room.Eliminated,
+	}
+	if len(room.Eliminated) > 0 && room.Eliminated[0] != username {
+		outcome.Loser, outcome.LoserResult = room.Eliminated[0], "lose"
+	}
+	return outcome
+}
+
+// The player's losses for a response, when the game's loss went to someone
+// else. A failed lookup only loses the count.
+func (s *Server) lossesOf(ctx context.Context, username string) int64 {
+	_, losses, err := s.store.GetStats(ctx, username)
+	if err != nil {
+		log.Printf("Error retrieving stats for user %s: %v", username, err)
+	}
+	return losses
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+func TestFourPlayerGameEliminatesInOrder(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob", "carol", "dave")
+		socket := ts.dial("room=" + room.Code)
+		socket.next("snapshot")
+		for _, player := range room.Players {
+			ts.deal(player)
+		}
+		// Nobody holds a Defuse, so every bomb knocks its drawer out
+		ts.setDeck(room.gameID(), engine.ExplodingKitten, "Cat", engine.ExplodingKitten, "Cat", engine.ExplodingKitten, "Cat")
+		order := room.turnOrder(room.Turn)
+
+		// The turn skips whoever is out: the second and fourth players draw
+		// Cats, and the second goes out on the last bomb
+		for i, drawer := range []string{order[0], order[1], order[2], order[3], order[1]} {
+			if turn := ts.room(room.Code).Turn; turn != drawer {
+				t.Fatalf("draw %d: turn = %s, want %s", i, turn, drawer)
+			}
+			drawn := decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: drawer, GameID: room.gameID()}))
+			if drawn.Card.Type == engine.ExplodingKitten {
+				ts.clock.Advance(ts.revealDelay)
+			}
+		}
+
+		for _, out := range [][]string{{order[0]}, {order[0], order[2]}} {
+			event := decodeMessage[RoomEvent](t, socket.next("player_eliminated"))
+			if event.Username != out[len(out)-1] || !reflect.DeepEqual(event.Eliminated, out) {
+				t.Fatalf("player_eliminated = %+v, want %v out", event, out)
+			}
+		}
+		over := decodeMessage[RoomEvent](t, socket.next("game_over"))
+		if want := []string{order[0], order[2], order[1]}; over.Winner != order[3] || over.Loser != order[0] || !reflect.DeepEqual(over.Eliminated, want) {
+			t.Fatalf("game_over = %+v, want %s to win and %v out", over, order[3], want)
+		}
+		if final := ts.room(room.Code); final.Status != RoomFinished || !reflect.DeepEqual(final.Eliminated, over.Eliminated) {
+			t.Fatalf("room after the game = %s with %v out", final.Status, final.Eliminated)
+		}
+
+		// Only first place wins and only the first out loses
+		for player, want := range map[string][2]int64{order[0]: {0, 1}, order[1]: {0, 0}, order[2]: {0, 0}, order[3]: {1, 0}} {
+			if win, lose := ts.stats(player); win != want[0] || lose != want[1] {
+				t.Fatalf("%s's stats = %d/%d, want %d/%d", player, win, lose, want[0], want[1])
+			}
+		}
+	})
+}
@@ -0,0 +1,191 @@
+// Package engine holds the rules of the game: what a drawn card does to a
+// player's hand. It knows nothing about Redis or HTTP; the server loads the
+// state it needs, lets the engine decide, and persists the resulting event.
+package engine
+
+// Card types the rules treat specially. Every other card is kept in the hand.
+const (
+	ExplodingKitten = "Exploding Kitten"
+	Defuse          = "Defuse"
+	Shuffle         = "Shuffle"
+)
+
+// Where a game stands
+const (
+	StatusActive = "active"
+	StatusLost   = "lost"
+	StatusWon    = "won"
+)
+
+// Ways to play a solo game. Classic is won by drawing every card that isn't
+// a bomb; survival can't be won, and scores how many cards are drawn before a
+// bomb goes off.
+const (
+	ModeClassic  = "classic"
+	ModeSurvival = "survival"
+)
+
+// How a room makes up for the advantage of going first. Balanced rooms deal
+// the player going second a Defuse.
+const (
+	BalanceNone     = "none"
+	BalanceBalanced = "balanced"
+)
+
+// Ways to play a room. Versus rooms are won by the last player standing; the
+// players of a co-op room share the deck and its fate, all winning once it
+// is cleared and all losing when any of them explodes.
+const (
+	RoomVersus = "versus"
+	RoomCoop   = "coop"
+)
+
+// Whether the players of a room played as mode win and lose together
+func SharedResult(mode string) bool {
+	return mode == RoomCoop
+}
+
+// The cards each seat starts with, in turn order from the first player:
+// nothing, except the extra Defuse the second seat gets in a balanced room
+func OpeningHands(seats int, balance string) [][]string {
+	hands := make([][]string, seats)
+	if balance == BalanceBalanced && seats > 1 {
+		hands[1] = []string{Defuse}
+	}
+	return hands
+}
+
+// What settling a drawn card did. The values double as the outcomes the
+// store's batch draw reports.
+type EventType string
+
+const (
+	// The card went into the hand
+	CardHeld EventType = "held"
+	// The card was a bomb and a held Defuse was spent on it
+	BombDefused EventType = "defused"
+	// The card was a bomb and there was no Defuse: the game is lost
+	Exploded EventType = "exploded"
+	// The card was a Shuffle: the deck must be reshuffled
+	Reshuffle EventType = "shuffle"
+)
+
+// The randomness the rules use. *rand.Rand satisfies it, as does the seeded
+// source of SeededRNG, which lets anyone replay a game's shuffle.
+type RNG interface {
+	Intn(n int) int
+	Shuffle(n int, swap func(i, j int))
+}
+
+type Event struct {
+	Type EventType
+	Card string
+	// Where a defused bomb went back into the deck, counted from the top
+	Position int
+}
+
+// The part of a player's game the rules act on
+type Game struct {
+	// Remaining cards, top first
+	Deck        []string
+	Hand        []string
+	DefuseCount int
+	Status      string
+	// ModeClassic or ModeSurvival; "" is classic
+	Mode string
+}
+
+// What drawing a card does: an Effect settles the card the player just drew
+// against their game. The server's card registry says which card has which,
+// so a new card only needs an effect of its own if none of these fits.
+type Effect func(g *Game, card string, rng RNG) Event
+
+// Settle a card the player just drew with its effect
+func (g *Game) Settle(card string, effect Effect, rng RNG) Event {
+	return effect(g, card, rng)
+}
+
+// The card goes into the hand, and a Defuse counts toward DefuseCount
+func Keep(g *Game, card string, rng RNG) Event {
+	g.Hand = append(g.Hand, card)
+	if card == Defuse {
+		g.DefuseCount++
+	}
+	return Event{Type: CardHeld, Card: card}
+}
+
+// The card is a bomb. Each bomb costs one Defuse, and a defused bomb goes
+// back into the deck at a random position; without one the game is lost.
+func Explode(g *Game, card string, rng RNG) Event {
+	if g.DefuseCount > 0 {
+		g.Hand = removeFirst(g.Hand, Defuse)
+		g.DefuseCount--
+		position := g.Insert(card, rng.Intn(len(g.Deck)+1))
+		return Event{Type: BombDefused, Card: card, Position: position}
+	}
+	g.Status = StatusLost
+	return Event{Type: Exploded, Card: card}
+}
+
+// The deck must be reshuffled, which the server does as it stores it
+func ShuffleDeck(g *Game, card string, rng RNG) Event {
+	return Event{Type: Reshuffle, Card: card}
+}
+
+// Put a card into the deck at position from the top, clamped to the deck,
+// and return where it went
+func (g *Game) Insert(card string, position int) int {
+	if position < 0 {
+		position = 0
+	}
+	if position > len(g.Deck) {
+		position = len(g.Deck)
+	}
+	g.Deck = append(g.Deck[:position:position], append([]string{card}, g.Deck[position:]...)...)
+	return position
+}
+
+// Whether the player has drawn every card that isn't a bomb. What is left
+// can only hurt them, so a solo game is won at that point.
+func (g *Game) Cleared() bool {
+	for _, card := range g.Deck {
+		if card != ExplodingKitten {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether the game is won: a classic game once it is Cleared. A survival
+// game only ever ends on a bomb.
+func (g *Game) Won() bool {
+	return g.Mode != ModeSurvival && g.Cleared()
+}
+
+// How the game ends however it is played from here: StatusLost once only
+// bombs are left in a game that can't be won, or "" while it is still open.
+// Holding a Defuse for every bomb doesn't change it, since each bomb defused
+// goes back into the deck; Defuses only put the loss off.
+func (g *Game) ForcedOutcome() string {
+	if len(g.Deck) == 0 || g.Won() || !g.Cleared() {
+		return ""
+	}
+	return StatusLost
+}
+
+// Shuffle the remaining deck in place
+func (g *Game) Shuffle(rng RNG) {
+	rng.Shuffle(len(g.Deck), func(i, j int) {
+		g.Deck[i], g.Deck[j] = g.Deck[j], g.Deck[i]
+	})
+}
+
+// Drop the first copy of card from the list
+func removeFirst(list []string, card string) []string {
+	for i, c := range list {
+		if c == card {
+			return append(list[:i:i], list[i+1:]...)
+		}
+	}
+	return list
+}
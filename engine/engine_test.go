@@ -0,0 +1,235 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+// An RNG whose Intn always picks the same number, clamped to n, and whose
+// Shuffle reverses
+type fixedRNG int
+
+func (r fixedRNG) Intn(n int) int {
+	if int(r) >= n {
+		return n - 1
+	}
+	return int(r)
+}
+
+func (r fixedRNG) Shuffle(n int, swap func(i, j int)) {
+	for i := 0; i < n/2; i++ {
+		swap(i, n-1-i)
+	}
+}
+
+func TestKeepHoldsCard(t *testing.T) {
+	for _, card := range []string{"Cat", "Tacocat", "Rainbow Cat", "Beard Cat", "Skip", "Favor", "Nope", "See the Future", "Draw From Bottom"} {
+		g := &Game{Deck: []string{"Cat"}, Hand: []string{"Skip"}, Status: StatusActive}
+		event := g.Settle(card, Keep, fixedRNG(0))
+		if want := (Event{Type: CardHeld, Card: card}); event != want {
+			t.Errorf("%s: event = %+v, want %+v", card, event, want)
+		}
+		if !reflect.DeepEqual(g.Hand, []string{"Skip", card}) || g.DefuseCount != 0 || g.Status != StatusActive {
+			t.Errorf("%s: game = %+v", card, g)
+		}
+	}
+}
+
+func TestKeepCountsDefuse(t *testing.T) {
+	g := &Game{Status: StatusActive}
+	g.Settle(Defuse, Keep, fixedRNG(0))
+	g.Settle(Defuse, Keep, fixedRNG(0))
+	if g.DefuseCount != 2 || !reflect.DeepEqual(g.Hand, []string{Defuse, Defuse}) {
+		t.Fatalf("game = %+v", g)
+	}
+}
+
+func TestExplodeWithoutDefuseLoses(t *testing.T) {
+	g := &Game{Deck: []string{"Cat"}, Hand: []string{"Skip"}, Status: StatusActive}
+	event := g.Settle(ExplodingKitten, Explode, fixedRNG(0))
+	if want := (Event{Type: Exploded, Card: ExplodingKitten}); event != want {
+		t.Fatalf("event = %+v, want %+v", event, want)
+	}
+	if g.Status != StatusLost || !reflect.DeepEqual(g.Deck, []string{"Cat"}) || !reflect.DeepEqual(g.Hand, []string{"Skip"}) {
+		t.Fatalf("game = %+v", g)
+	}
+}
+
+func TestExplodeSpendsDefuse(t *testing.T) {
+	tests := []struct {
+		pick     int
+		position int
+		deck     []string
+	}{
+		{0, 0, []string{ExplodingKitten, "Cat", "Skip"}},
+		{1, 1, []string{"Cat", ExplodingKitten, "Skip"}},
+		// The bottom of the deck is a place too
+		{2, 2, []string{"Cat", "Skip", ExplodingKitten}},
+		{9, 2, []string{"Cat", "Skip", ExplodingKitten}},
+	}
+	for _, test := range tests {
+		g := &Game{Deck: []string{"Cat", "Skip"}, Hand: []string{"Nope", Defuse, Defuse}, DefuseCount: 2, Status: StatusActive}
+		event := g.Settle(ExplodingKitten, Explode, fixedRNG(test.pick))
+		if want := (Event{Type: BombDefused, Card: ExplodingKitten, Position: test.position}); event != want {
+			t.Errorf("pick %d: event = %+v, want %+v", test.pick, event, want)
+		}
+		if !reflect.DeepEqual(g.Deck, test.deck) {
+			t.Errorf("pick %d: deck = %v, want %v", test.pick, g.Deck, test.deck)
+		}
+		if g.DefuseCount != 1 || !reflect.DeepEqual(g.Hand, []string{"Nope", Defuse}) || g.Status != StatusActive {
+			t.Errorf("pick %d: game = %+v", test.pick, g)
+		}
+	}
+}
+
+func TestEachBombCostsDefuse(t *testing.T) {
+	g := &Game{Hand: []string{Defuse}, DefuseCount: 1, Status: StatusActive}
+	if event := g.Settle(ExplodingKitten, Explode, fixedRNG(0)); event.Type != BombDefused {
+		t.Fatalf("first bomb = %+v", event)
+	}
+	g.Deck = g.Deck[1:]
+	if event := g.Settle(ExplodingKitten, Explode, fixedRNG(0)); event.Type != Exploded || g.Status != StatusLost {
+		t.Fatalf("second bomb = %+v, game %+v", event, g)
+	}
+}
+
+func TestShuffleDeckLeavesGameForServer(t *testing.T) {
+	g := &Game{Deck: []string{"Cat", ExplodingKitten}, Hand: []string{Defuse}, DefuseCount: 1, Status: StatusActive}
+	event := g.Settle(Shuffle, ShuffleDeck, fixedRNG(0))
+	if want := (Event{Type: Reshuffle, Card: Shuffle}); event != want {
+		t.Fatalf("event = %+v, want %+v", event, want)
+	}
+	// The Shuffle isn't held, and a held Defuse stays
+	if !reflect.DeepEqual(g.Hand, []string{Defuse}) || g.DefuseCount != 1 || !reflect.DeepEqual(g.Deck, []string{"Cat", ExplodingKitten}) {
+		t.Fatalf("game = %+v", g)
+	}
+}
+
+func TestInsertClampsPosition(t *testing.T) {
+	tests := []struct {
+		position, want int
+		deck           []string
+	}{
+		{-3, 0, []string{"X", "a", "b"}},
+		{1, 1, []string{"a", "X", "b"}},
+		{7, 2, []string{"a", "b", "X"}},
+	}
+	for _, test := range tests {
+		original := []string{"a", "b"}
+		g := &Game{Deck: original}
+		if got := g.Insert("X", test.position); got != test.want || !reflect.DeepEqual(g.Deck, test.deck) {
+			t.Errorf("Insert at %d = %d with deck %v, want %d with %v", test.position, got, g.Deck, test.want, test.deck)
+		}
+		if !reflect.DeepEqual(original, []string{"a", "b"}) {
+			t.Errorf("Insert at %d changed the old deck to %v", test.position, original)
+		}
+	}
+}
+
+func TestOutcomes(t *testing.T) {
+	tests := []struct {
+		name    string
+		game    Game
+		cleared bool
+		won     bool
+		forced  string
+	}{
+		{"cards left", Game{Deck: []string{"Cat", ExplodingKitten}}, false, false, ""},
+		{"only bombs", Game{Deck: []string{ExplodingKitten, ExplodingKitten}}, true, true, ""},
+		{"empty deck", Game{}, true, true, ""},
+		{"survival with cards left", Game{Deck: []string{"Cat", ExplodingKitten}, Mode: ModeSurvival}, false, false, ""},
+		{"survival with only bombs", Game{Deck: []string{ExplodingKitten}, Mode: ModeSurvival}, true, false, StatusLost},
+		{"survival with Defuses for every bomb", Game{Deck: []string{ExplodingKitten}, Hand: []string{Defuse, Defuse}, DefuseCount: 2, Mode: ModeSurvival}, true, false, StatusLost},
+		{"survival with an empty deck", Game{Mode: ModeSurvival}, true, false, ""},
+	}
+	for _, test := range tests {
+		if got := test.game.Cleared(); got != test.cleared {
+			t.Errorf("%s: Cleared = %v", test.name, got)
+		}
+		if got := test.game.Won(); got != test.won {
+			t.Errorf("%s: Won = %v", test.name, got)
+		}
+		if got := test.game.ForcedOutcome(); got != test.forced {
+			t.Errorf("%s: ForcedOutcome = %q", test.name, got)
+		}
+	}
+}
+
+func TestShuffleUsesRNG(t *testing.T) {
+	g := &Game{Deck: []string{"a", "b", "c", "d"}}
+	g.Shuffle(fixedRNG(0))
+	if !reflect.DeepEqual(g.Deck, []string{"d", "c", "b", "a"}) {
+		t.Fatalf("deck = %v", g.Deck)
+	}
+}
+
+func TestSeededRNGRepeats(t *testing.T) {
+	seed := [SeedSize]byte{42}
+	first, second := &Game{Deck: []string{"a", "b", "c", "d", "e", "f"}}, &Game{Deck: []string{"a", "b", "c", "d", "e", "f"}}
+	first.Shuffle(SeededRNG(seed))
+	second.Shuffle(SeededRNG(seed))
+	if !reflect.DeepEqual(first.Deck, second.Deck) {
+		t.Fatalf("one seed shuffled to %v and %v", first.Deck, second.Deck)
+	}
+	if a, b := SeededRNG(seed).Intn(1000), SeededRNG(seed).Intn(1000); a != b {
+		t.Fatalf("one seed gave %d and %d", a, b)
+	}
+}
+
+func TestRestoredRNGCarriesOn(t *testing.T) {
+	seed := [SeedSize]byte{7}
+	rng := SeededRNG(seed)
+	rng.Intn(1000)
+	state, err := MarshalRNG(rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreRNG(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if a, b := rng.Intn(1000), restored.Intn(1000); a != b {
+			t.Fatalf("draw %d: %d from the source, %d restored", i, a, b)
+		}
+	}
+
+	// Only a seeded source has a state to keep
+	if _, err := MarshalRNG(fixedRNG(0)); err == nil {
+		t.Fatal("took the state of a fixed source")
+	}
+}
+
+func TestCommitment(t *testing.T) {
+	seed := [SeedSize]byte{1, 2, 3}
+	commitment := Commit("room:ABCD", seed)
+	if !VerifyCommitment("room:ABCD", seed, commitment) {
+		t.Fatal("seed doesn't verify against its own commitment")
+	}
+	tampered := seed
+	tampered[0]++
+	if VerifyCommitment("room:ABCD", tampered, commitment) {
+		t.Fatal("tampered seed verifies")
+	}
+	if VerifyCommitment("room:WXYZ", seed, commitment) {
+		t.Fatal("seed verifies for another game")
+	}
+}
+
+func TestOpeningHands(t *testing.T) {
+	if hands := OpeningHands(3, BalanceNone); !reflect.DeepEqual(hands, [][]string{nil, nil, nil}) {
+		t.Fatalf("unbalanced hands = %v", hands)
+	}
+	if hands := OpeningHands(3, BalanceBalanced); !reflect.DeepEqual(hands, [][]string{nil, {Defuse}, nil}) {
+		t.Fatalf("balanced hands = %v", hands)
+	}
+	if hands := OpeningHands(1, BalanceBalanced); !reflect.DeepEqual(hands, [][]string{nil}) {
+		t.Fatalf("one seat = %v", hands)
+	}
+}
+
+func TestSharedResult(t *testing.T) {
+	if !SharedResult(RoomCoop) || SharedResult(RoomVersus) || SharedResult("") {
+		t.Fatal("only co-op rooms share their result")
+	}
+}
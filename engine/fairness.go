@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/rand/v2"
+)
+
+// Bytes of a game's seed
+const SeedSize = 32
+
+// A ChaCha8 source seeded with a game's seed. The same seed always gives the
+// same numbers, so a revealed seed lets anyone recompute what was shuffled
+// with it.
+func SeededRNG(seed [SeedSize]byte) RNG {
+	return newSeededRNG(rand.NewChaCha8(seed))
+}
+
+// A source SeededRNG made, carrying on from the state MarshalRNG took of it
+func RestoreRNG(state []byte) (RNG, error) {
+	source := new(rand.ChaCha8)
+	if err := source.UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	return newSeededRNG(source), nil
+}
+
+// Where a source SeededRNG or RestoreRNG made is up to, for RestoreRNG to
+// carry on from. No other source has a state to take.
+func MarshalRNG(rng RNG) ([]byte, error) {
+	seeded, ok := rng.(seededRNG)
+	if !ok {
+		return nil, errors.New("engine: not a seeded source")
+	}
+	return seeded.source.MarshalBinary()
+}
+
+type seededRNG struct {
+	*rand.Rand
+	source *rand.ChaCha8
+}
+
+func newSeededRNG(source *rand.ChaCha8) seededRNG {
+	return seededRNG{rand.New(source), source}
+}
+
+func (r seededRNG) Intn(n int) int {
+	return r.IntN(n)
+}
+
+// The commitment to a game's seed, published when the game starts: the hex
+// SHA-256 of the game ID and the hex seed, joined by ':'. It binds the seed
+// to the game without giving it away.
+func Commit(gameID string, seed [SeedSize]byte) string {
+	sum := sha256.Sum256([]byte(gameID + ":" + hex.EncodeToString(seed[:])))
+	return hex.EncodeToString(sum[:])
+}
+
+// Whether a revealed seed is the one the game committed to
+func VerifyCommitment(gameID string, seed [SeedSize]byte, commitment string) bool {
+	return Commit(gameID, seed) == commitment
+}
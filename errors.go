@@ -0,0 +1,357 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIError is the error returned to clients. Code is a stable machine-readable
+// identifier the frontend can switch on; Message is for humans.
+type APIError struct {
+	Code       string `json:"code"`
+	HTTPStatus int    `json:"-"`
+	Message    string `json:"message"`
+	// Set on ERR_GAME_LOCKED: the device holding the game
+	Lock *GameLockHolder `json:"lock,omitempty"`
+	// Set on ERR_QUOTA_EXCEEDED: the cap the user hit
+	Quota *QuotaExceeded `json:"quota,omitempty"`
+	// Set on ERR_INVALID_REQUEST for a body that couldn't be bound: what
+	// was wrong with it, field by field
+	Details []FieldProblem `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Error codes
+const (
+	ErrCodeInvalidRequest   = "ERR_INVALID_REQUEST"
+	ErrCodeInvalidUsername  = "ERR_INVALID_USERNAME"
+	ErrCodeUsernameTaken    = "ERR_USERNAME_TAKEN"
+	ErrCodeUnauthorized     = "ERR_UNAUTHORIZED"
+	ErrCodeScopeMissing     = "ERR_SCOPE_MISSING"
+	ErrCodeAPIKeyNotFound   = "ERR_API_KEY_NOT_FOUND"
+	ErrCodeDeckEmpty        = "ERR_DECK_EMPTY"
+	ErrCodeGameFinished     = "ERR_GAME_FINISHED"
+	ErrCodeGameNotFinished  = "ERR_GAME_NOT_FINISHED"
+	ErrCodeNoSeed           = "ERR_NO_SEED"
+	ErrCodeRoomNotFound     = "ERR_ROOM_NOT_FOUND"
+	ErrCodeRoomFull         = "ERR_ROOM_FULL"
+	ErrCodeRoomNotReady     = "ERR_ROOM_NOT_READY"
+	ErrCodeNotInRoom        = "ERR_NOT_IN_ROOM"
+	ErrCodeNotRoomOwner     = "ERR_NOT_ROOM_OWNER"
+	ErrCodeInviteInvalid    = "ERR_INVITE_INVALID"
+	ErrCodeInviteExpired    = "ERR_INVITE_EXPIRED"
+	ErrCodeInviteRevoked    = "ERR_INVITE_REVOKED"
+	ErrCodeNotYourTurn      = "ERR_NOT_YOUR_TURN"
+	ErrCodeEliminated       = "ERR_ELIMINATED"
+	ErrCodeCardNotInHand    = "ERR_CARD_NOT_IN_HAND"
+	ErrCodeCardNotPlayable  = "ERR_CARD_NOT_PLAYABLE"
+	ErrCodeCardDisabled     = "ERR_CARD_DISABLED"
+	ErrCodeActionPending    = "ERR_ACTION_PENDING"
+	ErrCodeNoPendingAction  = "ERR_NO_PENDING_ACTION"
+	ErrCodeNothingToSteal   = "ERR_NOTHING_TO_STEAL"
+	ErrCodeMustDiscard      = "ERR_MUST_DISCARD"
+	ErrCodeBombPending      = "ERR_BOMB_PENDING"
+	ErrCodeShuffleCooldown  = "ERR_SHUFFLE_COOLDOWN"
+	ErrCodeRequestInFlight  = "ERR_REQUEST_IN_PROGRESS"
+	ErrCodeConflict         = "ERR_CONFLICT"
+	ErrCodeGameLocked       = "ERR_GAME_LOCKED"
+	ErrCodeRoomPaused       = "ERR_ROOM_PAUSED"
+	ErrCodePauseConflict    = "ERR_PAUSE_CONFLICT"
+	ErrCodeNoTournament     = "ERR_TOURNAMENT_NOT_FOUND"
+	ErrCodeTournamentClosed = "ERR_TOURNAMENT_CLOSED"
+	ErrCodeUnknownCommand   = "ERR_UNKNOWN_COMMAND"
+	ErrCodeRateLimited      = "ERR_RATE_LIMITED"
+	ErrCodeQuotaExceeded    = "ERR_QUOTA_EXCEEDED"
+	ErrCodeNotFlagged       = "ERR_NOT_FLAGGED"
+	ErrCodeNeverMet         = "ERR_NEVER_MET"
+	ErrCodeIncompatible     = "ERR_INCOMPATIBLE_GAME"
+	ErrCodeStoreUnavailable = "ERR_STORE_UNAVAILABLE"
+	ErrCodeServerBusy       = "ERR_SERVER_BUSY"
+	ErrCodeInternal         = "ERR_INTERNAL"
+)
+
+func newAPIError(status int, code, message string) *APIError {
+	return &APIError{Code: code, HTTPStatus: status, Message: message}
+}
+
+func errInvalidRequest(message string) *APIError {
+	return newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, message)
+}
+
+func errMalformedBody(problems []FieldProblem) *APIError {
+	err := errInvalidRequest("Invalid request body")
+	err.Details = problems
+	return err
+}
+
+func errInvalidUsername() *APIError {
+	return newAPIError(http.StatusBadRequest, ErrCodeInvalidUsername,
+		"Username must be 1-32 characters of letters, digits, '.', '_' or '-'")
+}
+
+func errUnauthorized() *APIError {
+	return newAPIError(http.StatusUnauthorized, ErrCodeUnauthorized, "Missing or invalid credentials")
+}
+
+func errScopeMissing(scope string) *APIError {
+	if scope == "" {
+		return newAPIError(http.StatusForbidden, ErrCodeScopeMissing, "API keys can't call this route")
+	}
+	return newAPIError(http.StatusForbidden, ErrCodeScopeMissing, "This API key lacks the "+scope+" scope")
+}
+
+func errAPIKeyNotFound() *APIError {
+	return newAPIError(http.StatusNotFound, ErrCodeAPIKeyNotFound, "API key not found")
+}
+
+func errDeckEmpty(message string) *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeDeckEmpty, message)
+}
+
+func errGameFinished() *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeGameFinished, "This game has already finished")
+}
+
+// A draw on a solo game that is over, saying how it ended
+func errGameOver(status string) *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeGameFinished, fmt.Sprintf("This game is over: you %s. Start a rematch to play again.", status))
+}
+
+func errGameNotFinished() *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeGameNotFinished, "This game is still in progress")
+}
+
+func errReplayUnavailable() *APIError {
+	return newAPIError(http.StatusForbidden, ErrCodeGameNotFinished, "Replays are only available once the game has finished")
+}
+
+func errFairnessUnavailable() *APIError {
+	return newAPIError(http.StatusForbidden, ErrCodeGameNotFinished, "The seed is only revealed once the game has finished")
+}
+
+func errNoSeed() *APIError {
+	return newAPIError(http.StatusNotFound, ErrCodeNoSeed, "This game was dealt before seeds were committed")
+}
+
+func errRoomNotFound() *APIError {
+	return newAPIError(http.StatusNotFound, ErrCodeRoomNotFound, "Room not found")
+}
+
+func errRoomFull() *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeRoomFull, "Room is full")
+}
+
+func errRoomNotReady() *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeRoomNotReady, "Waiting for another player to join")
+}
+
+func errNotInRoom() *APIError {
+	return newAPIError(http.StatusForbidden, ErrCodeNotInRoom, "You are not a player in this room")
+}
+
+func errNotRoomOwner() *APIError {
+	return newAPIError(http.StatusForbidden, ErrCodeNotRoomOwner, "Only the room's creator can do that")
+}
+
+// The token is malformed or its signature doesn't match
+func errInviteInvalid() *APIError {
+	return newAPIError(http.StatusBadRequest, ErrCodeInviteInvalid, "This invite link is not valid")
+}
+
+func errInviteExpired() *APIError {
+	return newAPIError(http.StatusGone, ErrCodeInviteExpired, "This invite link has expired")
+}
+
+func errInviteRevoked() *APIError {
+	return newAPIError(http.StatusGone, ErrCodeInviteRevoked, "This invite link has been revoked")
+}
+
+func errNotYourTurn() *APIError {
+	return newAPIError(http.StatusForbidden, ErrCodeNotYourTurn, "It is not your turn")
+}
+
+func errPlayerEliminated() *APIError {
+	return newAPIError(http.StatusForbidden, ErrCodeEliminated, "You are out of this game")
+}
+
+func errCardNotInHand(card string) *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeCardNotInHand, "You don't hold a "+card+" card")
+}
+
+func errCardNotPlayable(message string) *APIError {
+	return newAPIError(http.StatusBadRequest, ErrCodeCardNotPlayable, message)
+}
+
+func errCardDisabled(card string) *APIError {
+	return newAPIError(http.StatusBadRequest, ErrCodeCardDisabled, fmt.Sprintf("%s is disabled in this room", card))
+}
+
+func errActionPending() *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeActionPending, "Waiting for a played card to resolve")
+}
+
+func errNoPendingAction() *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeNoPendingAction, "There is no action to Nope")
+}
+
+func errPairNotHeld(card string) *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeCardNotInHand, "You don't hold two "+card+" cards")
+}
+
+func errNothingToSteal(opponent string) *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeNothingToSteal, opponent+" has no cards to steal")
+}
+
+func errMustDiscard(username string, limit int) *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeMustDiscard, fmt.Sprintf("%s must discard down to %d cards first", username, limit))
+}
+
+func errBombPending(username string) *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeBombPending, fmt.Sprintf("%s must decide about the bomb they drew first", username))
+}
+
+func errShuffleCooldown(cooldown int) *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeShuffleCooldown, fmt.Sprintf("The deck was shuffled less than %d moves ago", cooldown))
+}
+
+func errUsernameInUse() *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeUsernameTaken, "That username is already taken")
+}
+
+func errRequestInFlight() *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeRequestInFlight, "A request with this idempotency key is still in progress")
+}
+
+func errGameCreating() *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeRequestInFlight, "Your game is still being set up, please try again")
+}
+
+func errConflict() *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeConflict, "The game changed while you were drawing, please try again")
+}
+
+func errDeviceRequired() *APIError {
+	return newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "An X-Device-Id of 1-64 letters, digits, '.', '_', ':' or '-' is required")
+}
+
+// Another of the player's devices is playing the game
+func errGameLocked(holder string, left time.Duration) *APIError {
+	apiErr := newAPIError(http.StatusLocked, ErrCodeGameLocked, "This game is being played on another device. Take it over to play here.")
+	apiErr.Lock = &GameLockHolder{DeviceID: holder, ExpiresInMs: left.Milliseconds()}
+	return apiErr
+}
+
+func errRoomPaused(pause *RoomPause) *APIError {
+	return newAPIError(http.StatusLocked, ErrCodeRoomPaused, fmt.Sprintf("%s paused the game; it resumes by %s at the latest", pause.By, pause.ResumesAt.UTC().Format(time.RFC3339)))
+}
+
+func errPauseConflict(message string) *APIError {
+	return newAPIError(http.StatusConflict, ErrCodePauseConflict, message)
+}
+
+func errTournamentNotFound() *APIError {
+	return newAPIError(http.StatusNotFound, ErrCodeNoTournament, "Tournament not found")
+}
+
+func errTournamentClosed() *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeTournamentClosed, "Tournament registration is closed")
+}
+
+func errUnknownCommand(command string) *APIError {
+	return newAPIError(http.StatusBadRequest, ErrCodeUnknownCommand, fmt.Sprintf("Unknown command %q", command))
+}
+
+func errRateLimited() *APIError {
+	return newAPIError(http.StatusTooManyRequests, ErrCodeRateLimited, "Too many commands, slow down")
+}
+
+func errQuotaExceeded(quota *QuotaExceeded) *APIError {
+	message := "Too many games in progress; finish or forfeit one first"
+	if quota.Quota == QuotaDailyGames {
+		message = "Too many games started today"
+	}
+	err := newAPIError(http.StatusTooManyRequests, ErrCodeQuotaExceeded, message)
+	err.Quota = quota
+	return err
+}
+
+func errAPIKeyRateLimited() *APIError {
+	return newAPIError(http.StatusTooManyRequests, ErrCodeRateLimited, "Too many requests with this API key, slow down")
+}
+
+func errChatRateLimited() *APIError {
+	return newAPIError(http.StatusTooManyRequests, ErrCodeRateLimited, "One chat message per second, slow down")
+}
+
+func errChatTooLong() *APIError {
+	return newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Chat messages are at most %d characters", maxChatLength))
+}
+
+func errIncompatibleGame(version int) *APIError {
+	return newAPIError(http.StatusConflict, ErrCodeIncompatible, fmt.Sprintf("This game was saved by a newer server (schema %d) and can't be loaded here", version))
+}
+
+func errNotFlagged() *APIError {
+	return newAPIError(http.StatusNotFound, ErrCodeNotFlagged, "User is not flagged")
+}
+
+func errNeverMet() *APIError {
+	return newAPIError(http.StatusNotFound, ErrCodeNeverMet, "These players have never played each other")
+}
+
+func errStoreUnavailable(message string) *APIError {
+	return newAPIError(http.StatusServiceUnavailable, ErrCodeStoreUnavailable, message)
+}
+
+func errTooManyConnections() *APIError {
+	return newAPIError(http.StatusServiceUnavailable, ErrCodeServerBusy, "Too many live connections, try again later")
+}
+
+// Record the error on the context and stop the handler chain. The error
+// middleware renders it once the handler returns.
+func abortWithError(c *gin.Context, err *APIError) {
+	c.Error(err)
+	c.Abort()
+}
+
+// Gin middleware rendering errors recorded by handlers as
+// {"error":{"code":...,"message":...}}
+func errorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		var apiErr *APIError
+		if !errors.As(c.Errors.Last().Err, &apiErr) {
+			log.Printf("Unhandled error: %v", c.Errors.Last().Err)
+			apiErr = newAPIError(http.StatusInternalServerError, ErrCodeInternal, "Internal server error")
+		}
+		c.JSON(apiErr.HTTPStatus, ErrorResponse{Error: apiErr})
+	}
+}
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+// Parse the request body into a User and validate the username
+func bindUser(c *gin.Context) (User, *APIError) {
+	var user User
+	if apiErr := bindStrict(c, &user); apiErr != nil {
+		return user, apiErr
+	}
+	if !usernamePattern.MatchString(user.Username) {
+		return user, errInvalidUsername()
+	}
+	return user, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Pub/sub channels carrying hub messages between server instances
+const (
+	eventsPrefix       = "events:"
+	leaderboardChannel = eventsPrefix + "leaderboard"
+	userChannelPrefix  = eventsPrefix + "user:"
+	roomChannelPrefix  = eventsPrefix + "room:"
+	// Messages for the player's own sockets, as opposed to their spectators'
+	playerChannelPrefix = eventsPrefix + "player:"
+	// Bracket updates for the sockets following a tournament
+	tournamentChannelPrefix = eventsPrefix + "tournament:"
+)
+
+func userChannel(username string) string   { return userChannelPrefix + username }
+func roomChannel(code string) string       { return roomChannelPrefix + code }
+func playerChannel(username string) string { return playerChannelPrefix + username }
+func tournamentChannel(id string) string   { return tournamentChannelPrefix + id }
+
+// EventBus carries hub messages to every server instance, each of which
+// delivers them to its own connections
+type EventBus interface {
+	Publish(ctx context.Context, channel string, payload []byte) error
+}
+
+// localBus delivers straight to the hub, for a single instance
+type localBus struct {
+	hub *Hub
+}
+
+func (b localBus) Publish(ctx context.Context, channel string, payload []byte) error {
+	b.hub.deliver(channel, payload)
+	return nil
+}
+
+// Backoff between attempts to resubscribe after losing Redis
+const (
+	minResubscribeBackoff = 500 * time.Millisecond
+	maxResubscribeBackoff = 30 * time.Second
+)
+
+// redisBus publishes through Redis pub/sub. Every instance, including the
+// publisher, receives the message back through its subscriber. Channels are
+// named under KEY_PREFIX like the store's keys, so deployments sharing a
+// Redis don't hear each other.
+type redisBus struct {
+	rdb    redis.UniversalClient
+	hub    *Hub
+	prefix string
+}
+
+func newRedisBus(rdb redis.UniversalClient, hub *Hub, prefix string) *redisBus {
+	return &redisBus{rdb: rdb, hub: hub, prefix: prefix}
+}
+
+func (b *redisBus) Publish(ctx context.Context, channel string, payload []byte) error {
+	return b.rdb.Publish(ctx, b.prefix+channel, payload).Err()
+}
+
+// Subscribe to every events channel and fan messages out to the local hub
+// until ctx is done, resubscribing with backoff whenever Redis drops
+func (b *redisBus) run(ctx context.Context) {
+	backoff := minResubscribeBackoff
+	for ctx.Err() == nil {
+		err := b.listen(ctx, func() { backoff = minResubscribeBackoff })
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Event subscription lost, retrying in %s: %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxResubscribeBackoff {
+			backoff = maxResubscribeBackoff
+		}
+	}
+}
+
+// Deliver messages from one subscription until it fails. subscribed is
+// called once Redis has confirmed the subscription.
+func (b *redisBus) listen(ctx context.Context, subscribed func()) error {
+	pubsub := b.rdb.PSubscribe(ctx, b.prefix+eventsPrefix+"*")
+	defer pubsub.Close()
+
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	subscribed()
+	log.Println("Subscribed to event channels")
+
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+		b.hub.deliver(strings.TrimPrefix(msg.Channel, b.prefix), []byte(msg.Payload))
+	}
+}
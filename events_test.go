@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// A test server as one of several instances sharing the Redis at addr,
+// its hub on a redisBus
+func newTestInstance(t *testing.T, server *miniredis.Miniredis) *testServer {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	ts := newTestServer(t, newRedisStore(rdb))
+
+	bus := newRedisBus(rdb, ts.hub, "")
+	ts.hub.bus = bus
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go bus.run(ctx)
+	return ts
+}
+
+// Wait until the instances have all subscribed to the events channels
+func waitForSubscribers(t *testing.T, server *miniredis.Miniredis, instances int) {
+	t.Helper()
+	deadline := time.Now().Add(socketTimeout)
+	for server.PubSubNumPat() < instances {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d instances subscribed", server.PubSubNumPat(), instances)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEventsReachOtherInstances(t *testing.T) {
+	server := miniredis.RunT(t)
+	a, b := newTestInstance(t, server), newTestInstance(t, server)
+	waitForSubscribers(t, server, 2)
+
+	spectator := b.dial("spectate=alice")
+	spectator.next("snapshot")
+
+	a.hub.notifySpectators("alice", SpectatorEvent{Type: "card_drawn", Username: "alice", Remaining: 7})
+	event := decodeMessage[SpectatorEvent](t, spectator.next("card_drawn"))
+	if event.Username != "alice" || event.Remaining != 7 {
+		t.Fatalf("instance B got %+v", event)
+	}
+}
+
+func TestEventBusResubscribesAfterRedisRestart(t *testing.T) {
+	server := miniredis.RunT(t)
+	a, b := newTestInstance(t, server), newTestInstance(t, server)
+	waitForSubscribers(t, server, 2)
+
+	server.Close()
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitForSubscribers(t, server, 2)
+
+	spectator := b.dial("spectate=alice")
+	spectator.next("snapshot")
+	a.hub.notifySpectators("alice", SpectatorEvent{Type: "card_drawn", Username: "alice", Remaining: 3})
+	if event := decodeMessage[SpectatorEvent](t, spectator.next("card_drawn")); event.Remaining != 3 {
+		t.Fatalf("instance B got %+v after the restart", event)
+	}
+}
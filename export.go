@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Formats of the /export downloads
+const (
+	ExportCSV  = "csv"
+	ExportJSON = "json"
+)
+
+// Byte order mark that makes Excel read a CSV file as UTF-8
+const utf8BOM = "\xef\xbb\xbf"
+
+// Parse the format query param; CSV unless asked otherwise
+func exportFormat(c *gin.Context) (string, *APIError) {
+	switch format := c.DefaultQuery("format", ExportCSV); format {
+	case ExportCSV, ExportJSON:
+		return format, nil
+	}
+	return "", errInvalidRequest(`format must be "csv" or "json"`)
+}
+
+// Write rows to the response as they come, as a CSV file with a header line
+// or as a JSON array of the records, so a large export is never held whole
+// in memory. next returns the next record and its CSV fields, or false once
+// there are no more.
+func streamExport(c *gin.Context, format, name string, header []string, next func() (interface{}, []string, bool)) {
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+
+	if format == ExportJSON {
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		c.Status(http.StatusOK)
+		encoder := json.NewEncoder(c.Writer)
+		c.Writer.WriteString("[")
+		for i := 0; ; i++ {
+			record, _, ok := next()
+			if !ok {
+				break
+			}
+			if i > 0 {
+				c.Writer.WriteString(",")
+			}
+			if err := encoder.Encode(record); err != nil {
+				log.Printf("Error writing %s export: %v", name, err)
+				return
+			}
+		}
+		c.Writer.WriteString("]")
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	if c.Query("bom") == "true" {
+		c.Writer.WriteString(utf8BOM)
+	}
+	// csv.Writer quotes fields holding commas, quotes or newlines, and hands
+	// its buffer to the response whenever it fills
+	writer := csv.NewWriter(c.Writer)
+	writer.Write(header)
+	for {
+		_, fields, ok := next()
+		if !ok {
+			break
+		}
+		if err := writer.Write(fields); err != nil {
+			log.Printf("Error writing %s export: %v", name, err)
+			return
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Error writing %s export: %v", name, err)
+	}
+}
+
+// Leaderboard export route: the same rows as GET /leaderboard, with the same
+// query params
+func (s *Server) exportLeaderboard(c *gin.Context) {
+	format, apiErr := exportFormat(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	query, apiErr := s.parseLeaderboardQuery(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	entries, err := s.fetchAllUserStats(c.Request.Context(), query)
+	if err != nil {
+		log.Printf("Error fetching leaderboard: %v", err)
+		abortWithError(c, errStoreUnavailable("Error fetching leaderboard"))
+		return
+	}
+
+	header := []string{"rank", "username", "win", "lose", "totalGames", "winRate"}
+	i := 0
+	streamExport(c, format, "leaderboard", header, func() (interface{}, []string, bool) {
+		if i == len(entries) {
+			return nil, nil, false
+		}
+		entry := entries[i]
+		i++
+		return entry, []string{
+			strconv.Itoa(entry.Rank),
+			entry.Username,
+			strconv.FormatInt(entry.Win, 10),
+			strconv.FormatInt(entry.Lose, 10),
+			strconv.FormatInt(entry.TotalGames, 10),
+			strconv.FormatFloat(entry.WinRate, 'f', 4, 64),
+		}, true
+	})
+}
+
+// History export route: the moves of the player's finished solo game, as
+// GET /game/:gameId/replay returns them
+func (s *Server) exportHistory(c *gin.Context) {
+	format, apiErr := exportFormat(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	username := c.Param("username")
+	if !usernamePattern.MatchString(username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+
+	moves, apiErr := s.finishedMoves(c.Request.Context(), &GameSession{ID: username, Username: username})
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	header := []string{"seq", "actor", "action", "card", "resultingStatus", "ts"}
+	i := 0
+	streamExport(c, format, "history-"+username, header, func() (interface{}, []string, bool) {
+		if i == len(moves) {
+			return nil, nil, false
+		}
+		move := moves[i]
+		i++
+		return move, []string{
+			strconv.FormatInt(move.Seq, 10),
+			move.Actor,
+			move.Action,
+			move.Card,
+			move.ResultingStatus,
+			move.TS.Format(time.RFC3339Nano),
+		}, true
+	})
+}
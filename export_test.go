@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+// Parse a CSV export, checking it is an attachment named filename
+func parseCSVExport(t *testing.T, ts *testServer, path, filename string) [][]string {
+	t.Helper()
+	w := ts.get(path)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", path, w.Code, w.Body)
+	}
+	if got, want := w.Header().Get("Content-Disposition"), `attachment; filename="`+filename+`"`; got != want {
+		t.Fatalf("Content-Disposition = %q, want %q", got, want)
+	}
+	body := w.Body.String()
+	if strings.HasPrefix(body, utf8BOM) != strings.Contains(path, "bom=true") {
+		t.Fatalf("BOM present is %t for %s", strings.HasPrefix(body, utf8BOM), path)
+	}
+	rows, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(body, utf8BOM))).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing %s: %v", body, err)
+	}
+	return rows
+}
+
+func TestLeaderboardExportMatchesJSON(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		// A name that needs quoting, as only a seeded or legacy user could have
+		for username, stats := range map[string][2]int64{"alice": {3, 1}, `o"brien, jr`: {2, 2}, "bob": {0, 1}} {
+			ts.store.SetStats(ctx, username, stats[0], stats[1], AuditEntry{})
+		}
+
+		live := decodeOK[LeaderboardResponse](t, ts.get("/leaderboard")).Leaderboard
+		w := ts.get("/export/leaderboard?format=json")
+		var exported []LeaderboardEntry
+		if err := json.Unmarshal(w.Body.Bytes(), &exported); err != nil {
+			t.Fatalf("decoding %s: %v", w.Body, err)
+		}
+		if len(live) != 3 || !reflect.DeepEqual(exported, live) {
+			t.Fatalf("JSON export = %+v, leaderboard = %+v", exported, live)
+		}
+
+		if body := ts.get("/export/leaderboard").Body.String(); !strings.Contains(body, `,"o""brien, jr",`) {
+			t.Fatalf("CSV export doesn't quote the name: %s", body)
+		}
+		for _, path := range []string{"/export/leaderboard", "/export/leaderboard?format=csv&bom=true"} {
+			rows := parseCSVExport(t, ts, path, "leaderboard.csv")
+			if want := []string{"rank", "username", "win", "lose", "totalGames", "winRate"}; !reflect.DeepEqual(rows[0], want) {
+				t.Fatalf("header = %q", rows[0])
+			}
+			if len(rows) != len(live)+1 {
+				t.Fatalf("%s has %d rows, want %d", path, len(rows)-1, len(live))
+			}
+			for i, entry := range live {
+				row := rows[i+1]
+				rank, _ := strconv.Atoi(row[0])
+				win, _ := strconv.ParseInt(row[2], 10, 64)
+				lose, _ := strconv.ParseInt(row[3], 10, 64)
+				games, _ := strconv.ParseInt(row[4], 10, 64)
+				rate, _ := strconv.ParseFloat(row[5], 64)
+				if rank != entry.Rank || row[1] != entry.Username || win != entry.Win || lose != entry.Lose ||
+					games != entry.TotalGames || math.Abs(rate-entry.WinRate) > 1e-4 {
+					t.Fatalf("row %d = %q, leaderboard has %+v", i, row, entry)
+				}
+			}
+		}
+	})
+}
+
+func TestHistoryExportMatchesReplay(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", "Tacocat", engine.ExplodingKitten)
+		ts.draw("alice")
+		ts.draw("alice")
+		moves := decodeOK[ReplayResponse](t, ts.get("/game/alice/replay?username=alice")).Moves
+
+		rows := parseCSVExport(t, ts, "/export/history/alice?format=csv", "history-alice.csv")
+		if len(moves) != 2 || len(rows) != len(moves)+1 {
+			t.Fatalf("%d rows for %d moves", len(rows)-1, len(moves))
+		}
+		for i, move := range moves {
+			row := rows[i+1]
+			seq, _ := strconv.ParseInt(row[0], 10, 64)
+			at, err := time.Parse(time.RFC3339Nano, row[5])
+			if err != nil || seq != move.Seq || row[1] != move.Actor || row[2] != move.Action ||
+				row[3] != move.Card || row[4] != move.ResultingStatus || !at.Equal(move.TS) {
+				t.Fatalf("row %d = %q, replay has %+v", i, row, move)
+			}
+		}
+
+		w := ts.get("/export/history/alice?format=json")
+		var exported []Move
+		if err := json.Unmarshal(w.Body.Bytes(), &exported); err != nil {
+			t.Fatalf("decoding %s: %v", w.Body, err)
+		}
+		if !reflect.DeepEqual(exported, moves) {
+			t.Fatalf("JSON export = %+v, replay = %+v", exported, moves)
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"exploding-kitten/engine"
+)
+
+// Shuffle a new deck with a fresh seed and store both. The seed stays hidden
+// until the game is over; the commitment to it is in the game's snapshot
+// from the start, so it can't be swapped after the fact. Returns the seeded
+// source, for whatever else the game's start leaves to chance; a caller
+// that uses it saves it again with saveGameRNG.
+func (s *Server) dealSeededDeck(ctx context.Context, gameID string, cfg DeckConfig) (engine.RNG, error) {
+	var seed [engine.SeedSize]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return nil, err
+	}
+	rng := engine.SeededRNG(seed)
+	if err := s.store.CreateDeck(ctx, gameID, buildDeck(cfg, rng)); err != nil {
+		return nil, err
+	}
+	if err := s.store.SetDeckConfig(ctx, gameID, cfg.fingerprint()); err != nil {
+		return nil, err
+	}
+	if err := s.store.SetGameSeed(ctx, gameID, hex.EncodeToString(seed[:]), engine.Commit(gameID, seed)); err != nil {
+		return nil, err
+	}
+	return rng, s.saveGameRNG(ctx, gameID, rng)
+}
+
+// The game's seeded source, carrying on from its last random choice, so that
+// every choice the game leaves to chance follows from the seed it committed
+// to. A game that predates the source's state gets a fresh seed, which
+// covers its choices from here on but was never committed to. Save it with
+// saveGameRNG once it has been used.
+func (s *Server) gameRNG(ctx context.Context, gameID string) (engine.RNG, error) {
+	state, err := s.store.GameRNG(ctx, gameID)
+	if err != nil {
+		return nil, err
+	}
+	if state != "" {
+		decoded, err := hex.DecodeString(state)
+		if err == nil {
+			var rng engine.RNG
+			if rng, err = engine.RestoreRNG(decoded); err == nil {
+				return rng, nil
+			}
+		}
+		log.Printf("Warning: reseeding game %s, whose source can't be restored: %v", gameID, err)
+	}
+	var seed [engine.SeedSize]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return nil, err
+	}
+	return engine.SeededRNG(seed), nil
+}
+
+// Keep where the game's seeded source is up to, for gameRNG
+func (s *Server) saveGameRNG(ctx context.Context, gameID string, rng engine.RNG) error {
+	state, err := engine.MarshalRNG(rng)
+	if err != nil {
+		return err
+	}
+	return s.store.SetGameRNG(ctx, gameID, hex.EncodeToString(state))
+}
+
+// Check a revealed hex seed against a game's commitment. Returns the opening
+// deck it shuffles to, top first, and whether it matches; anyone holding the
+// two can run the same check.
+func verifyFairness(gameID, seedHex, commitment string, cfg DeckConfig) ([]string, bool) {
+	decoded, err := hex.DecodeString(seedHex)
+	if err != nil || len(decoded) != engine.SeedSize {
+		return nil, false
+	}
+	var seed [engine.SeedSize]byte
+	copy(seed[:], decoded)
+	if !engine.VerifyCommitment(gameID, seed, commitment) {
+		return nil, false
+	}
+	return buildDeck(cfg, engine.SeededRNG(seed)), true
+}
+
+// Fairness route: a finished game's seed, so its players can check that the
+// deck they drew from was the one committed to when it started. Every later
+// random choice, a reshuffle or a defused bomb going back in, carries on
+// from the same seeded source, so replaying the game's moves from the
+// opening deck reproduces them too.
+func (s *Server) getGameFairness(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	username := c.Query("username")
+	if !usernamePattern.MatchString(username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+	game, apiErr := s.resolveGame(ctx, User{Username: username, GameID: c.Param("gameId")})
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	finished, apiErr := s.gameFinished(ctx, game)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if !finished {
+		abortWithError(c, errFairnessUnavailable())
+		return
+	}
+	seed, commitment, err := s.store.GameSeed(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error retrieving seed of game %s: %v", game.ID, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving game seed"))
+		return
+	}
+	if seed == "" {
+		abortWithError(c, errNoSeed())
+		return
+	}
+
+	var cfg DeckConfig
+	if game.Room != nil {
+		cfg = roomDeckFor(len(game.Room.Players), game.Room.DisabledCards)
+	} else {
+		if apiErr := s.loadMode(ctx, game); apiErr != nil {
+			abortWithError(c, apiErr)
+			return
+		}
+		cfg = s.soloDeckFor(game.Mode)
+	}
+	deck, verified := verifyFairness(game.ID, seed, commitment, cfg)
+
+	c.JSON(http.StatusOK, FairnessResponse{
+		GameID:      game.ID,
+		Commitment:  commitment,
+		Seed:        seed,
+		Verified:    verified,
+		OpeningDeck: deck,
+	})
+}
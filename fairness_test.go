@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// Draw until the player's solo game is over, using any Defuse and
+// discarding down to the hand limit as needed
+func (ts *testServer) playOut(username string) DrawCardResponse {
+	ts.t.Helper()
+	useDefuse := true
+	var drawn DrawCardResponse
+	for i := 0; drawn.GameStatus != GameStatusWon && drawn.GameStatus != GameStatusLost; i++ {
+		if i > 100 {
+			ts.t.Fatalf("%s's game still going after %d moves", username, i)
+		}
+		switch drawn.GameStatus {
+		case GameStatusPendingDefuse:
+			drawn = decodeOK[DrawCardResponse](ts.t, ts.post("/resolve-bomb", ResolveBombRequest{Username: username, UseDefuse: &useDefuse}))
+		case GameStatusMustDiscard:
+			card := drawn.Hand[0].Type
+			for _, held := range drawn.Hand {
+				if held.Type != engine.Defuse {
+					card = held.Type
+				}
+			}
+			discarded := decodeOK[DiscardResponse](ts.t, ts.post("/discard", DiscardRequest{Username: username, Card: card}))
+			drawn.GameStatus = discarded.GameStatus
+		default:
+			drawn = decodeOK[DrawCardResponse](ts.t, ts.draw(username))
+		}
+	}
+	return drawn
+}
+
+func TestFairnessRevealsCommittedSeed(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		started := ts.startGame("alice")
+		dealt := ts.deck("alice")
+		if len(started.Snapshot.Commitment) != 64 {
+			t.Fatalf("commitment = %q, want a hex SHA-256", started.Snapshot.Commitment)
+		}
+		// Nothing is revealed while the game can still be played
+		assertError(t, ts.get("/game/alice/fairness?username=alice"), http.StatusForbidden, ErrCodeGameNotFinished)
+
+		ts.playOut("alice")
+		fairness := decodeOK[FairnessResponse](t, ts.get("/game/alice/fairness?username=alice"))
+		if !fairness.Verified || fairness.GameID != "alice" || fairness.Commitment != started.Snapshot.Commitment {
+			t.Fatalf("fairness = %+v, committed to %q", fairness, started.Snapshot.Commitment)
+		}
+		if !reflect.DeepEqual(fairness.OpeningDeck, dealt) {
+			t.Fatalf("seed shuffles to %v, the game was dealt %v", fairness.OpeningDeck, dealt)
+		}
+		// Anyone can run the same check with what the route revealed
+		if deck, ok := verifyFairness("alice", fairness.Seed, fairness.Commitment, ts.soloDeck); !ok || !reflect.DeepEqual(deck, dealt) {
+			t.Fatalf("verifyFairness = %v, %t", deck, ok)
+		}
+	})
+}
+
+func TestTamperedSeedFailsVerification(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		ts.startGame("alice")
+		ts.playOut("alice")
+		seed, commitment, err := ts.store.GameSeed(ctx, "alice")
+		if err != nil || seed == "" {
+			t.Fatalf("seed = %q, %v", seed, err)
+		}
+
+		flipped := "0"
+		if seed[0] == '0' {
+			flipped = "1"
+		}
+		tampered := flipped + seed[1:]
+		for name, check := range map[string][3]string{
+			"a changed seed":        {"alice", tampered, commitment},
+			"another game's ID":     {"bob", seed, commitment},
+			"a changed commitment":  {"alice", seed, strings.Repeat("0", len(commitment))},
+			"a short seed":          {"alice", seed[:len(seed)-2], commitment},
+			"a seed that isn't hex": {"alice", "zz" + seed[2:], commitment},
+		} {
+			if deck, ok := verifyFairness(check[0], check[1], check[2], ts.soloDeck); ok || deck != nil {
+				t.Errorf("%s verified, shuffling to %v", name, deck)
+			}
+		}
+
+		// A seed swapped in the store after the start is caught too
+		if err := ts.store.SetGameSeed(ctx, "alice", tampered, commitment); err != nil {
+			t.Fatal(err)
+		}
+		fairness := decodeOK[FairnessResponse](t, ts.get("/game/alice/fairness?username=alice"))
+		if fairness.Verified || fairness.OpeningDeck != nil || fairness.Seed != tampered {
+			t.Fatalf("fairness with a swapped seed = %+v", fairness)
+		}
+		var raw [engine.SeedSize]byte
+		if engine.VerifyCommitment("alice", raw, commitment) {
+			t.Fatal("a zero seed verified")
+		}
+	})
+}
+
+// A defused bomb's way back in and a Shuffle's new deck both carry on from
+// the source the committed seed started, so the seed reproduces them
+func TestDefuseAndShuffleFollowTheSeed(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		// The seed's source as the game's start left it
+		seeded := func(gameID string) engine.RNG {
+			seed, _, err := ts.store.GameSeed(context.Background(), gameID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var raw [engine.SeedSize]byte
+			hex.Decode(raw[:], []byte(seed))
+			rng := engine.SeededRNG(raw)
+			buildDeck(ts.soloDeck, rng)
+			return rng
+		}
+
+		ts.startGame("alice", engine.Defuse, engine.ExplodingKitten, engine.Shuffle, "Cat", "Tacocat")
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		ts.resolveBomb("alice", true)
+		rules := &engine.Game{Deck: []string{engine.Shuffle, "Cat", "Tacocat"}}
+		rules.Insert(engine.ExplodingKitten, seeded("alice").Intn(len(rules.Deck)+1))
+		if deck := ts.deck("alice"); !reflect.DeepEqual(deck, rules.Deck) {
+			t.Fatalf("bomb went back in as %v, the seed puts it %v", deck, rules.Deck)
+		}
+
+		ts.startGame("bob", engine.Shuffle, "Cat", engine.ExplodingKitten)
+		if drawn := decodeOK[DrawCardResponse](t, ts.draw("bob")); drawn.MessageID != MsgReshuffled {
+			t.Fatalf("Shuffle = %+v", drawn)
+		}
+		if deck, want := ts.deck("bob"), buildDeck(ts.soloDeck, seeded("bob")); !reflect.DeepEqual(deck, want) {
+			t.Fatalf("Shuffle dealt %v, the seed deals %v", deck, want)
+		}
+	})
+}
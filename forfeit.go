@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Forfeit route: give up the game and take the loss. In a room the player
+// is knocked out, and once only one player is left standing they are
+// credited with the win.
+func (s *Server) forfeit(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user, apiErr := bindUser(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	game, apiErr := s.resolveMove(ctx, user)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	var response *ForfeitResponse
+	if game.Room != nil {
+		response, apiErr = s.forfeitRoom(ctx, game)
+	} else {
+		response, apiErr = s.forfeitSolo(ctx, game)
+	}
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// A solo game is over once its deck is gone
+func (s *Server) forfeitSolo(ctx context.Context, game *GameSession) (*ForfeitResponse, *APIError) {
+	deck, err := s.store.GetDeck(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error retrieving deck for user %s: %v", game.Username, err)
+		return nil, errStoreUnavailable("Error retrieving deck")
+	}
+	if len(deck) == 0 {
+		return nil, errGameFinished()
+	}
+
+	// Report and log the move before the game hash holding its progress and
+	// move seq is cleared
+	s.reportGameFinished(ctx, game, game.Username, "forfeit")
+	s.recordMove(ctx, game, MoveForfeit, "", GameStatusLost)
+	if err := s.clearGame(ctx, game.ID, game.Username); err != nil {
+		return nil, errStoreUnavailable("Error clearing game")
+	}
+	outcome := gameOutcome{Loser: game.Username, LoserResult: "forfeit"}
+	completion, apiErr := s.completeGame(ctx, game, outcome)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	s.announceGameOver(ctx, game, outcome)
+
+	log.Printf("User %s forfeited their game", game.Username)
+	return &ForfeitResponse{
+		Message:   localize(ctx, MsgForfeited, completion.Losses),
+		MessageID: MsgForfeited,
+		Losses:    completion.Losses,
+	}, nil
+}
+
+func (s *Server) forfeitRoom(ctx context.Context, game *GameSession) (*ForfeitResponse, *APIError) {
+	room := game.Room
+	switch room.Status {
+	case RoomWaiting:
+		return nil, errRoomNotReady()
+	case RoomFinished:
+		return nil, errGameFinished()
+	}
+	if room.isEliminated(game.Username) {
+		return nil, errPlayerEliminated()
+	}
+	if len(room.alive()) > 2 {
+		return s.forfeitElimination(ctx, game)
+	}
+
+	// Stats go first so achievements see the hands the game ended with. This
+	// also stops the turn clock and drops any action waiting out its Nope
+	// window.
+	if apiErr := s.recordElimination(ctx, room, game.Username); apiErr != nil {
+		return nil, apiErr
+	}
+	outcome := roomOutcome(room, game.Username, "forfeit")
+	winner := outcome.Winner
+	outcome.Message = fmt.Sprintf("%s forfeited. %s wins!", game.Username, winner)
+	if outcome.Partner != "" {
+		outcome.Message = fmt.Sprintf("%s forfeited, taking %s down too.", game.Username, outcome.Partner)
+	}
+	completion, apiErr := s.completeGame(ctx, game, outcome)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	losses := completion.Losses
+	if outcome.Loser != game.Username {
+		losses = s.lossesOf(ctx, game.Username)
+	}
+	s.reportGameFinished(ctx, game, game.Username, "forfeit")
+	if outcome.Partner != "" {
+		s.reportGameFinished(ctx, game, outcome.Partner, "lose")
+	} else {
+		s.reportGameFinished(ctx, game, winner, "win")
+	}
+	s.recordMove(ctx, game, MoveForfeit, "", GameStatusLost)
+	if err := s.clearGame(ctx, game.ID, room.Players...); err != nil {
+		return nil, errStoreUnavailable("Error clearing game")
+	}
+
+	s.announceGameOver(ctx, game, outcome)
+
+	log.Printf("User %s forfeited in room %s", game.Username, room.Code)
+	return &ForfeitResponse{
+		Message:   localize(ctx, MsgForfeited, losses),
+		MessageID: MsgForfeited,
+		Losses:    losses,
+		Winner:    winner,
+	}, nil
+}
+
+// Give up a room game that goes on without the player
+func (s *Server) forfeitElimination(ctx context.Context, game *GameSession) (*ForfeitResponse, *APIError) {
+	event := RoomEvent{Message: fmt.Sprintf("%s forfeited and is out of the game", game.Username)}
+	if apiErr := s.eliminatePlayer(ctx, game, event, 0); apiErr != nil {
+		return nil, apiErr
+	}
+	s.reportGameFinished(ctx, game, game.Username, "forfeit")
+	s.recordMove(ctx, game, MoveForfeit, "", GameStatusLost)
+
+	log.Printf("User %s forfeited in room %s", game.Username, game.Room.Code)
+	losses := s.lossesOf(ctx, game.Username)
+	return &ForfeitResponse{
+		Message:   localize(ctx, MsgForfeited, losses),
+		MessageID: MsgForfeited,
+		Losses:    losses,
+	}, nil
+}
+
+// Delete the game's deck, empty the players' hands and free the slot the
+// game held for each of them
+func (s *Server) clearGame(ctx context.Context, gameID string, players ...string) error {
+	if err := s.store.DeleteDeck(ctx, gameID); err != nil {
+		log.Printf("Error deleting deck for game %s: %v", gameID, err)
+		return err
+	}
+	for _, player := range players {
+		if err := s.store.ClearHand(ctx, player); err != nil {
+			log.Printf("Error clearing hand for user %s: %v", player, err)
+			return err
+		}
+	}
+	s.releaseActiveGame(ctx, gameID, players)
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+func TestForfeitSoloGame(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", engine.ExplodingKitten)
+		ts.deal("alice", "Skip")
+		spectator := ts.dial("spectate=alice")
+		spectator.next("snapshot")
+
+		forfeited := decodeOK[ForfeitResponse](t, ts.post("/forfeit", User{Username: "alice"}))
+		if forfeited.Losses != 1 || forfeited.MessageID != MsgForfeited {
+			t.Fatalf("forfeit = %+v", forfeited)
+		}
+		if win, lose := ts.stats("alice"); win != 0 || lose != 1 {
+			t.Fatalf("stats = %d/%d, want 0/1", win, lose)
+		}
+		if deck, hand := ts.deck("alice"), ts.hand("alice"); len(deck) != 0 || len(hand) != 0 {
+			t.Fatalf("deck %v and hand %v left after forfeiting", deck, hand)
+		}
+		if over := decodeMessage[SpectatorEvent](t, spectator.next("game_over")); over.Result != "forfeit" || over.Loser != "alice" {
+			t.Fatalf("game_over = %+v", over)
+		}
+
+		// Twice is once too many
+		assertError(t, ts.post("/forfeit", User{Username: "alice"}), http.StatusConflict, ErrCodeGameFinished)
+		if _, lose := ts.stats("alice"); lose != 1 {
+			t.Fatalf("%d losses after forfeiting twice, want 1", lose)
+		}
+	})
+}
+
+func TestForfeitRoomGameAwardsOpponent(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		socket := ts.dial("room=" + room.Code)
+
+		forfeited := decodeOK[ForfeitResponse](t, ts.post("/forfeit", User{Username: "bob", GameID: room.gameID()}))
+		if forfeited.Winner != "alice" || forfeited.Losses != 1 {
+			t.Fatalf("forfeit = %+v", forfeited)
+		}
+		if win, _ := ts.stats("alice"); win != 1 {
+			t.Fatalf("alice has %d wins, want 1", win)
+		}
+		if _, lose := ts.stats("bob"); lose != 1 {
+			t.Fatalf("bob has %d losses, want 1", lose)
+		}
+		if status := ts.room(room.Code).Status; status != RoomFinished {
+			t.Fatalf("room is %q", status)
+		}
+		if over := decodeMessage[RoomEvent](t, socket.next("game_over")); over.Winner != "alice" {
+			t.Fatalf("game_over = %+v", over)
+		}
+
+		for _, player := range []string{"alice", "bob"} {
+			assertError(t, ts.post("/forfeit", User{Username: player, GameID: room.gameID()}), http.StatusConflict, ErrCodeGameFinished)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+)
+
+// How long a request may hold the lock on creating a player's game before
+// it lapses, e.g. if the instance died holding it, unless
+// GAME_CREATION_LOCK_TTL says otherwise
+const defaultGameCreationLockTTL = 10 * time.Second
+
+// How long a request waits for another creating the same player's game, and
+// how often it checks whether that one is done
+const (
+	gameCreationWait = 3 * time.Second
+	gameCreationPoll = 50 * time.Millisecond
+)
+
+// How long releasing the lock may take once the request that held it is
+// over, its own context perhaps cancelled by the client going away
+const gameCreationUnlockTimeout = 2 * time.Second
+
+// Take the lock on creating the player's solo game, so two requests racing
+// to start it don't each deal a deck and reset the stats. A request that
+// finds the lock held waits for it, then goes on to find the game the other
+// created. Returns the token the lock is held with, which callers pass to
+// unlockGameCreation to release it.
+func (s *Server) lockGameCreation(ctx context.Context, username string) (string, *APIError) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("Error generating a game creation token for user %s: %v", username, err)
+		return "", newAPIError(http.StatusInternalServerError, ErrCodeInternal, "Error starting game")
+	}
+	token := hex.EncodeToString(buf)
+
+	deadline := s.clock.Now().Add(gameCreationWait)
+	for {
+		locked, err := s.store.LockGameCreation(ctx, username, token, s.gameCreationLockTTL)
+		if err != nil {
+			log.Printf("Error locking game creation for user %s: %v", username, err)
+			return "", errStoreUnavailable("Error starting game")
+		}
+		if locked {
+			return token, nil
+		}
+		if s.clock.Now().After(deadline) {
+			return "", errGameCreating()
+		}
+		poll := make(chan struct{})
+		timer := s.clock.AfterFunc(gameCreationPoll, func() { close(poll) })
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", errGameCreating()
+		case <-poll:
+		}
+	}
+}
+
+// Release the lock on creating the player's game if token still holds it.
+// It is released even if the request was cancelled meanwhile, so the
+// player's next try isn't kept waiting for it to lapse.
+func (s *Server) unlockGameCreation(username, token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), gameCreationUnlockTimeout)
+	defer cancel()
+	if err := s.store.UnlockGameCreation(ctx, username, token); err != nil {
+		// It lapses after s.gameCreationLockTTL
+		log.Printf("Error unlocking game creation for user %s: %v", username, err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentStartGamesDealOneDeck(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		const requests = 10
+		responses := make([]*httptest.ResponseRecorder, requests)
+		var wg sync.WaitGroup
+		for i := range responses {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				responses[i] = ts.post("/start-game", User{Username: "alice"})
+			}(i)
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		// The requests left waiting poll on the server's clock
+	waiting:
+		for {
+			select {
+			case <-done:
+				break waiting
+			case <-time.After(5 * time.Millisecond):
+				if ts.clock.pending() > 0 {
+					ts.clock.Advance(gameCreationPoll)
+				}
+			}
+		}
+
+		var gameID string
+		for i, w := range responses {
+			started := decodeOK[StartGameResponse](t, w)
+			if i == 0 {
+				gameID = started.GameID
+			} else if started.GameID != gameID {
+				t.Fatalf("request %d started game %q, not %q", i, started.GameID, gameID)
+			}
+		}
+		if deck := ts.deck("alice"); len(deck) != ts.soloDeck.Size {
+			t.Fatalf("deck holds %d cards, want %d", len(deck), ts.soloDeck.Size)
+		}
+	})
+}
+
+func TestUnlockingGameCreationNeedsTheToken(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		lock := func(token string) bool {
+			locked, err := ts.store.LockGameCreation(ctx, "alice", token, ts.gameCreationLockTTL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return locked
+		}
+		if !lock("first") {
+			t.Fatal("the free lock wasn't taken")
+		}
+
+		// A request whose lock lapsed can't release the next one's
+		if err := ts.store.UnlockGameCreation(ctx, "alice", "stale"); err != nil {
+			t.Fatal(err)
+		}
+		if lock("second") {
+			t.Fatal("the lock was released with the wrong token")
+		}
+		if err := ts.store.UnlockGameCreation(ctx, "alice", "first"); err != nil {
+			t.Fatal(err)
+		}
+		if !lock("second") {
+			t.Fatal("the lock wasn't released with its token")
+		}
+	})
+}
+
+func TestGameCreationIsUnlockedAfterTheClientGoesAway(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx, cancel := context.WithCancel(context.Background())
+		token, apiErr := ts.lockGameCreation(ctx, "alice")
+		if apiErr != nil {
+			t.Fatal(apiErr)
+		}
+		cancel()
+		ts.unlockGameCreation("alice", token)
+		if locked, err := ts.store.LockGameCreation(context.Background(), "alice", "next", ts.gameCreationLockTTL); err != nil || !locked {
+			t.Fatalf("LockGameCreation after the cancelled request = %v, %v", locked, err)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long a device keeps a game after its last move, unless GAME_LOCK_TTL
+// says otherwise
+const defaultGameLockTTL = 30 * time.Second
+
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// The device holding a game lock, as ERR_GAME_LOCKED reports it
+type GameLockHolder struct {
+	DeviceID string `json:"deviceId"`
+	// How long until the lock lapses unless its device moves again
+	ExpiresInMs int64 `json:"expiresInMs"`
+}
+
+type TakeoverRequest struct {
+	Username string `json:"username"`
+	GameID   string `json:"gameId"`
+	// The player agreed to take the game from their other device. Required.
+	Confirm bool `json:"confirm"`
+}
+
+// Takeover route
+type TakeoverResponse struct {
+	Message  string `json:"message"`
+	GameID   string `json:"gameId"`
+	DeviceID string `json:"deviceId"`
+	// The device the game was taken from, "" if none held it
+	PreviousDeviceID string `json:"previousDeviceId,omitempty"`
+}
+
+// Sent to the player's sockets when one of their devices takes a game over,
+// so the one that held it can stop
+type GameTakeoverEvent struct {
+	Type             string `json:"type"`
+	GameID           string `json:"gameId"`
+	DeviceID         string `json:"deviceId"`
+	PreviousDeviceID string `json:"previousDeviceId"`
+}
+
+type deviceContextKey struct{}
+
+func withDevice(ctx context.Context, deviceID string) context.Context {
+	return context.WithValue(ctx, deviceContextKey{}, deviceID)
+}
+
+// The device attached to ctx, or "" if the request didn't say
+func deviceFrom(ctx context.Context) string {
+	deviceID, _ := ctx.Value(deviceContextKey{}).(string)
+	return deviceID
+}
+
+// Gin middleware attaching the X-Device-Id header to the request context
+func deviceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if deviceID := c.GetHeader("X-Device-Id"); deviceID != "" {
+			c.Request = c.Request.WithContext(withDevice(c.Request.Context(), deviceID))
+		}
+		c.Next()
+	}
+}
+
+// Resolve the game a move is made in, as resolveGame does, refusing it while
+// the room's game is paused, and with GAME_LOCKS on claim it for the device
+// making the move. In a solo game the move confirms the player got the card
+// they last drew.
+func (s *Server) resolveMove(ctx context.Context, user User) (*GameSession, *APIError) {
+	game, apiErr := s.resolveGame(ctx, user)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := checkNotPaused(game); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := s.claimGameLock(ctx, game); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := s.confirmDraw(ctx, game); apiErr != nil {
+		return nil, apiErr
+	}
+	return game, nil
+}
+
+// Take or refresh the player's lock on the game for the device the move
+// comes from. Moves from another of their devices are refused with a 423
+// until the lock lapses or is taken over. Bots have no devices.
+func (s *Server) claimGameLock(ctx context.Context, game *GameSession) *APIError {
+	if !s.gameLocks || isBot(game.Username) {
+		return nil
+	}
+	deviceID := deviceFrom(ctx)
+	if !deviceIDPattern.MatchString(deviceID) {
+		return errDeviceRequired()
+	}
+	holder, left, err := s.store.AcquireGameLock(ctx, game.ID, game.Username, deviceID, s.gameLockTTL)
+	if err != nil {
+		log.Printf("Error locking game %s for user %s: %v", game.ID, game.Username, err)
+		return errStoreUnavailable("Error checking game lock")
+	}
+	if holder != "" {
+		return errGameLocked(holder, left)
+	}
+	return nil
+}
+
+// Takeover route: move the player's game to the device asking, once they
+// confirm, and tell the device that held it
+func (s *Server) takeover(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req TakeoverRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+	if !s.gameLocks {
+		abortWithError(c, errInvalidRequest("Games aren't locked to a device on this server"))
+		return
+	}
+	if !req.Confirm {
+		abortWithError(c, errInvalidRequest("confirm must be true to take the game over"))
+		return
+	}
+	deviceID := deviceFrom(ctx)
+	if !deviceIDPattern.MatchString(deviceID) {
+		abortWithError(c, errDeviceRequired())
+		return
+	}
+
+	game, apiErr := s.resolveGame(ctx, User{Username: req.Username, GameID: req.GameID})
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	previous, err := s.store.TakeOverGameLock(ctx, game.ID, game.Username, deviceID, s.gameLockTTL)
+	if err != nil {
+		log.Printf("Error taking over game %s for user %s: %v", game.ID, game.Username, err)
+		abortWithError(c, errStoreUnavailable("Error taking over game"))
+		return
+	}
+	if previous != "" && previous != deviceID {
+		log.Printf("User %s took game %s over from device %s to %s", game.Username, game.ID, previous, deviceID)
+		s.hub.notifyPlayer(game.Username, GameTakeoverEvent{
+			Type:             "game_taken_over",
+			GameID:           game.ID,
+			DeviceID:         deviceID,
+			PreviousDeviceID: previous,
+		})
+	}
+
+	c.JSON(http.StatusOK, TakeoverResponse{
+		Message:          "This device now plays the game",
+		GameID:           game.ID,
+		DeviceID:         deviceID,
+		PreviousDeviceID: previous,
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSecondDeviceTakesGameOver(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{"GAME_LOCKS": "true"}))
+		ts.startGame("alice", "Cat", "Cat", "Cat", "Cat", "Cat")
+		phone := []string{"X-Device-Id", "phone"}
+		laptop := []string{"X-Device-Id", "laptop"}
+		drawOn := func(device []string) *httptest.ResponseRecorder {
+			return ts.post("/draw-card", User{Username: "alice"}, device...)
+		}
+
+		assertError(t, drawOn(nil), http.StatusBadRequest, ErrCodeInvalidRequest)
+		decodeOK[DrawCardResponse](t, drawOn(phone))
+		decodeOK[DrawCardResponse](t, drawOn(phone))
+
+		// The laptop is told who has the game and for how long
+		locked := assertError(t, drawOn(laptop), http.StatusLocked, ErrCodeGameLocked)
+		if locked.Lock == nil || locked.Lock.DeviceID != "phone" || locked.Lock.ExpiresInMs <= 0 || locked.Lock.ExpiresInMs > defaultGameLockTTL.Milliseconds() {
+			t.Fatalf("lock = %+v", locked.Lock)
+		}
+		if deck := ts.deck("alice"); len(deck) != 3 {
+			t.Fatalf("deck after the refused draw = %v", deck)
+		}
+
+		socket := ts.dial("username=alice")
+		socket.next("leaderboard")
+		assertError(t, ts.post("/takeover", TakeoverRequest{Username: "alice"}, laptop...), http.StatusBadRequest, ErrCodeInvalidRequest)
+		taken := decodeOK[TakeoverResponse](t, ts.post("/takeover", TakeoverRequest{Username: "alice", Confirm: true}, laptop...))
+		if taken.DeviceID != "laptop" || taken.PreviousDeviceID != "phone" || taken.GameID != "alice" {
+			t.Fatalf("takeover = %+v", taken)
+		}
+		event := decodeMessage[GameTakeoverEvent](t, socket.next("game_taken_over"))
+		if event.GameID != "alice" || event.DeviceID != "laptop" || event.PreviousDeviceID != "phone" {
+			t.Fatalf("game_taken_over = %+v", event)
+		}
+
+		// Now the phone is the one refused
+		decodeOK[DrawCardResponse](t, drawOn(laptop))
+		if locked := assertError(t, drawOn(phone), http.StatusLocked, ErrCodeGameLocked); locked.Lock.DeviceID != "laptop" {
+			t.Fatalf("lock after the takeover = %+v", locked.Lock)
+		}
+		if deck := ts.deck("alice"); len(deck) != 2 {
+			t.Fatalf("deck = %v, want only the laptop's draw taken", deck)
+		}
+	})
+}
+
+func TestTakeoverNeedsGameLocks(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice")
+		assertError(t, ts.post("/takeover", TakeoverRequest{Username: "alice", Confirm: true}, "X-Device-Id", "laptop"), http.StatusBadRequest, ErrCodeInvalidRequest)
+		// Without locks any device plays
+		decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: "alice"}))
+	})
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// A player's totals, as sent with game_over
+type PlayerStats struct {
+	Wins   int64 `json:"wins"`
+	Losses int64 `json:"losses"`
+	// From the player's profile; left out where the stats are a record of
+	// counts, such as an admin change
+	DisplayName string `json:"displayName,omitempty"`
+	AvatarEmoji string `json:"avatarEmoji,omitempty"`
+}
+
+// How a game ended, for announceGameOver. A solo game has only a winner or
+// only a loser.
+type gameOutcome struct {
+	Winner string
+	Loser  string
+	// What the loser did: "lose" or "forfeit"
+	LoserResult string
+	// Shown to the room, e.g. "alice exploded. bob wins!"
+	Message string
+	Card    *Card
+	// Ended on a drawn bomb: announced once the bomb is revealed
+	Reveal bool
+	// A survival run, which doesn't count toward wins and losses
+	Unranked bool
+	// A room's elimination order, first out first, ending with the player
+	// whose elimination ended the game
+	Eliminated []string
+	// In a co-op room, the other player, who shares the result of Winner if
+	// there is one and of Loser otherwise
+	Partner string
+}
+
+// The players who won: the winner and a co-op partner who shares the win
+func (o gameOutcome) winners() []string {
+	var winners []string
+	if o.Winner != "" {
+		winners = append(winners, o.Winner)
+		if o.Partner != "" {
+			winners = append(winners, o.Partner)
+		}
+	}
+	return winners
+}
+
+// The players who lost: the loser and a co-op partner who shares the loss
+func (o gameOutcome) losers() []string {
+	var losers []string
+	if o.Loser != "" {
+		losers = append(losers, o.Loser)
+		if o.Partner != "" && o.Winner == "" {
+			losers = append(losers, o.Partner)
+		}
+	}
+	return losers
+}
+
+// How a game ended, for GameStore.CompleteGame
+type GameResult struct {
+	GameID string
+	// Set for a room game, whose status lives in the room hash
+	RoomCode string
+	// GameStatusWon or GameStatusLost for a solo game, RoomFinished for a room
+	Status string
+	// Players to credit: "" for a bot, or the missing side of a solo game
+	Winner string
+	Loser  string
+	// A co-op game's other player, credited with the same result as Winner,
+	// or as Loser if there is no winner
+	Partner string
+	// Who ended the game, for the audit entries of the stats it changes
+	Audit AuditEntry
+	// The UTC day whose daily stats count the game, "2006-01-02"
+	Day string
+	// The game ended on a drawn bomb going off
+	Exploded bool
+	// The encoded outboxAnnouncement of the game's end, kept in the outbox
+	// until announceGameOver has sent it
+	Announcement []byte
+	// The game counts toward Winner and Loser's head-to-head record, as
+	// played at EndedAt
+	HeadToHead bool
+	EndedAt    time.Time
+}
+
+// What CompleteGame wrote
+type GameCompletion struct {
+	// False if the game had already ended and nothing changed
+	Completed bool
+	// The winner's win count and streak, and the loser's loss count
+	Wins   int64
+	Streak int64
+	Losses int64
+	// The same for a co-op partner credited with the result
+	Partner *GameCompletion
+	// The outbox entry holding the result's Announcement
+	OutboxID string
+}
+
+// End the game the way outcome says and credit its players, then do what
+// follows from it: metrics, windowed leaderboards, achievements, the
+// players' summaries and, in a room, stopping the turn clock and moving its
+// tournament on. Returns errGameFinished if the game had already ended, so a
+// game is never won or lost twice. Events are up to the caller, once this
+// has returned.
+func (s *Server) completeGame(ctx context.Context, game *GameSession, outcome gameOutcome) (*GameCompletion, *APIError) {
+	now := s.clock.Now()
+	day, _ := quotaDay(now)
+	result := GameResult{
+		GameID:     game.ID,
+		Status:     GameStatusLost,
+		Audit:      s.newAuditEntry(ctx, game.Username, "", ""),
+		Day:        day,
+		Exploded:   outcome.Reveal,
+		HeadToHead: countsHeadToHead(game.Room, outcome),
+		EndedAt:    now,
+	}
+	if outcome.Winner != "" {
+		result.Status = GameStatusWon
+	}
+	if game.Room != nil {
+		result.RoomCode = game.Room.Code
+		result.Status = RoomFinished
+	}
+	if !isBot(outcome.Winner) && !outcome.Unranked {
+		result.Winner = outcome.Winner
+	}
+	if !isBot(outcome.Loser) && !outcome.Unranked {
+		result.Loser = outcome.Loser
+	}
+	if !isBot(outcome.Partner) && !outcome.Unranked {
+		result.Partner = outcome.Partner
+	}
+
+	announcement, err := json.Marshal(outboxAnnouncement{GameID: game.ID, Username: game.Username, RoomCode: result.RoomCode, Outcome: outcome})
+	if err != nil {
+		// The end is still announced, only not redelivered if this
+		// instance dies first
+		log.Printf("Error encoding the end of game %s for the outbox: %v", game.ID, err)
+	} else {
+		result.Announcement = announcement
+	}
+
+	completion, err := s.store.CompleteGame(ctx, result)
+	if err != nil {
+		log.Printf("Error completing game %s: %v", game.ID, err)
+		return nil, errStoreUnavailable("Error finishing game")
+	}
+	if !completion.Completed {
+		log.Printf("Game %s had already ended", game.ID)
+		return nil, errGameFinished()
+	}
+	game.outboxID = completion.OutboxID
+	if game.Room != nil {
+		game.Room.Status = RoomFinished
+		s.releaseRoom(ctx, game.Room.Code)
+	}
+	s.markFinished(ctx, game.ID)
+	players := []string{game.Username}
+	if game.Room != nil {
+		players = game.Room.Players
+	}
+	s.releaseActiveGame(ctx, game.ID, players)
+
+	earned := make(map[string][]string)
+	if result.Winner != "" {
+		earned[result.Winner] = s.creditedWin(ctx, game, outcome, result.Winner, completion)
+	}
+	if result.Loser != "" {
+		s.creditedLoss(ctx, game, result.Loser, completion)
+	}
+	if partner := completion.Partner; partner != nil {
+		if outcome.Winner != "" {
+			earned[result.Partner] = s.creditedWin(ctx, game, outcome, result.Partner, partner)
+		} else {
+			s.creditedLoss(ctx, game, result.Partner, partner)
+		}
+	}
+	// The leaderboard is broadcast by announceGameOver, after the players
+	// have heard the game is over
+	s.leaderboard.invalidate()
+	s.summarizeGame(ctx, game, outcome, completion, earned)
+	if game.Room != nil {
+		s.tournamentGameOver(ctx, game.Room, outcome.Winner)
+	}
+	return completion, nil
+}
+
+// What follows from a player being credited with a win: returns the
+// achievements it earned them
+func (s *Server) creditedWin(ctx context.Context, game *GameSession, outcome gameOutcome, username string, completion *GameCompletion) []string {
+	s.cheats.enqueue(statUpdate{Username: username, ZeroDrawWin: s.zeroDrawWin(ctx, game, outcome), At: s.clock.Now()})
+	gamesFinishedTotal.WithLabelValues(winKey).Inc()
+	s.recordWindowResult(ctx, username, true)
+	logGameEvent(username, game.ID, map[string]any{"event": "stats", winKey: completion.Wins, "streak": completion.Streak})
+	return s.awardAchievements(ctx, username, completion.Wins, completion.Streak)
+}
+
+// What follows from a player being credited with a loss
+func (s *Server) creditedLoss(ctx context.Context, game *GameSession, username string, completion *GameCompletion) {
+	s.cheats.enqueue(statUpdate{Username: username, At: s.clock.Now()})
+	gamesFinishedTotal.WithLabelValues(loseKey).Inc()
+	s.recordWindowResult(ctx, username, false)
+	logGameEvent(username, game.ID, map[string]any{"event": "stats", loseKey: completion.Losses})
+}
+
+// Tell the players' sockets and the room that the game is over, with the
+// stats it produced, and only then move the leaderboard. Everything goes out
+// in this order, held back together after a drawn bomb until it is revealed,
+// so a client following both never sees the leaderboard change before it
+// learns how the game ended. Once it has, the game's outbox entry is
+// dropped; see redeliverOutbox.
+func (s *Server) announceGameOver(ctx context.Context, game *GameSession, outcome gameOutcome) {
+	var delay time.Duration
+	if outcome.Reveal {
+		delay = s.revealDelay
+	}
+
+	winners, losers := outcome.winners(), outcome.losers()
+	players := append(append([]string{}, winners...), losers...)
+	shared := outcome.Partner != ""
+	profiles := s.profilesOf(ctx, players)
+	stats := make(map[string]PlayerStats)
+	for _, username := range players {
+		wins, losses, err := s.store.GetStats(ctx, username)
+		if err != nil {
+			log.Printf("Error retrieving stats for user %s: %v", username, err)
+		}
+		profile := profiles[username]
+		stats[username] = PlayerStats{Wins: wins, Losses: losses, DisplayName: profile.DisplayName, AvatarEmoji: profile.AvatarEmoji}
+	}
+
+	for _, loser := range losers {
+		// A co-op partner goes down with the loser however they went out
+		result := outcome.LoserResult
+		if loser != outcome.Loser {
+			result = "lose"
+		}
+		loserStats := stats[loser]
+		s.hub.notifySpectatorsAfter(delay, loser, SpectatorEvent{
+			Type:     "game_over",
+			EventID:  game.outboxID,
+			Username: loser,
+			Result:   result,
+			Winner:   outcome.Winner,
+			Loser:    outcome.Loser,
+			Shared:   shared,
+			Stats:    &loserStats,
+			Summary:  spectatorSummary(game.summaries[loser]),
+		})
+	}
+	for _, winner := range winners {
+		winnerStats := stats[winner]
+		s.hub.notifySpectatorsAfter(delay, winner, SpectatorEvent{
+			Type:     "game_over",
+			EventID:  game.outboxID,
+			Username: winner,
+			Result:   "win",
+			Winner:   outcome.Winner,
+			Loser:    outcome.Loser,
+			Shared:   shared,
+			Stats:    &winnerStats,
+			Summary:  spectatorSummary(game.summaries[winner]),
+		})
+	}
+	if game.Room != nil {
+		// The player who went out last, who is the loser unless the room
+		// had more than two players, or in a co-op game won the player who
+		// cleared the deck
+		last := outcome.Loser
+		if len(outcome.Eliminated) > 0 {
+			last = outcome.Eliminated[len(outcome.Eliminated)-1]
+		}
+		if last == "" {
+			last = outcome.Winner
+		}
+		var headToHead *HeadToHeadRecord
+		if countsHeadToHead(game.Room, outcome) {
+			record, err := s.store.HeadToHead(ctx, outcome.Winner, outcome.Loser)
+			if err != nil {
+				log.Printf("Error retrieving head-to-head record of %s and %s: %v", outcome.Winner, outcome.Loser, err)
+			} else {
+				headToHead = &record
+			}
+		}
+		s.hub.broadcastRoomAfter(delay, game.Room.Code, RoomEvent{
+			Type:       "game_over",
+			EventID:    game.outboxID,
+			Username:   last,
+			Card:       outcome.Card,
+			Message:    outcome.Message,
+			Winner:     outcome.Winner,
+			Loser:      outcome.Loser,
+			Shared:     shared,
+			Partner:    outcome.Partner,
+			Stats:      stats,
+			Summaries:  game.summaries,
+			Eliminated: outcome.Eliminated,
+			HeadToHead: headToHead,
+		})
+	}
+
+	s.broadcastLeaderboardAfter(delay)
+	if id := game.outboxID; id != "" {
+		s.hub.dispatch(outboxChannel, delay, func() { s.ackOutbox(id) })
+	}
+}
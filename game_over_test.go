@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// The wins a leaderboard snapshot shows for the player
+func leaderboardWins(t *testing.T, message map[string]interface{}, username string) int64 {
+	t.Helper()
+	for _, entry := range decodeMessage[struct{ Leaderboard []LeaderboardEntry }](t, message).Leaderboard {
+		if entry.Username == username {
+			return entry.Win
+		}
+	}
+	return 0
+}
+
+func TestGameOverReachesPlayersBeforeLeaderboard(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		loser, winner := room.Turn, room.nextAlive(room.Turn)
+		ts.setDeck(room.gameID(), engine.ExplodingKitten, "Cat")
+		ts.deal(loser)
+		ts.deal(winner)
+
+		// Leaderboard sockets, one following the loser's games and the
+		// other the room
+		sockets := map[string]*testSocket{loser: ts.dial("username=" + loser), winner: ts.dial("username=" + winner)}
+		for player, follow := range map[string]SubscribeRequest{loser: {Spectate: loser}, winner: {Room: room.Code}} {
+			sockets[player].hello()
+			if reply := sockets[player].command("follow", CommandSubscribe, follow); reply.Status != http.StatusOK {
+				t.Fatalf("subscribing = %+v", reply)
+			}
+		}
+
+		decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: loser, GameID: room.gameID()}))
+		ts.clock.Advance(ts.revealDelay)
+
+		for player, socket := range sockets {
+			// Standings sent before the game ended don't have its result yet
+			var over map[string]interface{}
+			for over == nil {
+				switch message := socket.any(); message["type"] {
+				case "leaderboard":
+					if wins := leaderboardWins(t, message, winner); wins != 0 {
+						t.Fatalf("%s's socket saw %s's win on the leaderboard before game_over", player, winner)
+					}
+				case "game_over":
+					over = message
+				}
+			}
+			// A spectator's event or the room's, which agree on the winner
+			if event := decodeMessage[struct{ Winner string }](t, over); event.Winner != winner {
+				t.Fatalf("%s's socket got game_over %+v", player, event)
+			}
+			if wins := leaderboardWins(t, socket.next("leaderboard"), winner); wins != 1 {
+				t.Fatalf("%s's socket got a leaderboard with %d wins for %s", player, wins, winner)
+			}
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Caps on what one user may play, unless MAX_ACTIVE_GAMES and
+// DAILY_GAME_QUOTA say otherwise or an admin gives the user caps of their own
+const (
+	defaultMaxActiveGames = 3
+	defaultDailyGameQuota = 200
+)
+
+// The caps a GameSlot can be refused on
+const (
+	// Games in progress at once, solo and in rooms
+	QuotaActiveGames = "active_games"
+	// Games started with /start-game on one UTC day
+	QuotaDailyGames = "daily_games"
+)
+
+// A user's own caps, raised by an admin. Zero keeps the server's.
+type GameLimits struct {
+	MaxActiveGames int64 `json:"maxActiveGames"`
+	DailyGames     int64 `json:"dailyGames"`
+}
+
+// What GameStore.ClaimGameSlot decided
+type GameSlot struct {
+	// The cap the game was refused on, "" if it got its slot
+	Refused string
+	// The cap refused on, or the user's active games cap if none was
+	Limit int64
+	// Games the user started today, counting this one if it got its slot
+	Started int64
+	// The user's active games, this one included if it got its slot
+	Active []string
+}
+
+// Set on ERR_QUOTA_EXCEEDED: the cap that was hit
+type QuotaExceeded struct {
+	// "active_games" or "daily_games"
+	Quota string `json:"quota"`
+	Limit int64  `json:"limit"`
+	// The user's games in progress, one of which must end before another
+	// can start
+	ActiveGames []string `json:"activeGames"`
+	// When the daily count starts over, for "daily_games"
+	ResetsAt *time.Time `json:"resetsAt,omitempty"`
+}
+
+type AdminLimitsRequest struct {
+	// The user's own caps; 0 or left out reverts to the server's
+	MaxActiveGames int64 `json:"maxActiveGames"`
+	DailyGames     int64 `json:"dailyGames"`
+}
+
+// Admin limits route
+type AdminLimitsResponse struct {
+	Username string     `json:"username"`
+	Limits   GameLimits `json:"limits"`
+	// The caps the user is held to, their own or the server's
+	Effective GameLimits `json:"effective"`
+}
+
+// The UTC day a game started at counts toward, and when it ends
+func quotaDay(at time.Time) (string, time.Time) {
+	at = at.UTC()
+	start := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	return start.Format("2006-01-02"), start.AddDate(0, 0, 1)
+}
+
+// Take a slot for a new game of the user's, refusing with a 429 if they have
+// too many games in progress or have started too many today
+func (s *Server) claimGameSlot(ctx context.Context, username, gameID string) *APIError {
+	now := s.clock.Now()
+	day, resetsAt := quotaDay(now)
+	slot, err := s.store.ClaimGameSlot(ctx, username, gameID, day, s.maxActiveGames, s.dailyGameQuota, resetsAt.Sub(now))
+	if err != nil {
+		log.Printf("Error claiming a game slot for user %s: %v", username, err)
+		return errStoreUnavailable("Error checking game limits")
+	}
+	if slot.Refused == "" {
+		return nil
+	}
+
+	log.Printf("User %s is over the %s cap of %d", username, slot.Refused, slot.Limit)
+	quota := &QuotaExceeded{Quota: slot.Refused, Limit: slot.Limit, ActiveGames: slot.Active}
+	if quota.ActiveGames == nil {
+		quota.ActiveGames = []string{}
+	}
+	if slot.Refused == QuotaDailyGames {
+		quota.ResetsAt = &resetsAt
+	}
+	return errQuotaExceeded(quota)
+}
+
+// Count a room's game among its players' active games. Bots have no caps.
+func (s *Server) trackActiveGame(ctx context.Context, room *Room) {
+	if err := s.store.TrackActiveGame(ctx, room.gameID(), humanPlayers(room.Players)); err != nil {
+		log.Printf("Error tracking game %s as active: %v", room.gameID(), err)
+	}
+}
+
+// Free the slot a game that ended held for each of its players
+func (s *Server) releaseActiveGame(ctx context.Context, gameID string, players []string) {
+	if err := s.store.ReleaseActiveGame(ctx, gameID, humanPlayers(players)); err != nil {
+		log.Printf("Error releasing active game %s: %v", gameID, err)
+	}
+}
+
+// The players that aren't bots
+func humanPlayers(players []string) []string {
+	var humans []string
+	for _, username := range players {
+		if !isBot(username) {
+			humans = append(humans, username)
+		}
+	}
+	return humans
+}
+
+// The caps the user is held to: their own where they have one, otherwise
+// the server's
+func (s *Server) effectiveLimits(limits GameLimits) GameLimits {
+	if limits.MaxActiveGames <= 0 {
+		limits.MaxActiveGames = s.maxActiveGames
+	}
+	if limits.DailyGames <= 0 {
+		limits.DailyGames = s.dailyGameQuota
+	}
+	return limits
+}
+
+// Admin limits route: give the user caps of their own, e.g. to let a
+// tournament account play more games at once
+func (s *Server) adminSetLimits(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	username, apiErr := adminUsername(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	var req AdminLimitsRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.MaxActiveGames < 0 || req.DailyGames < 0 {
+		abortWithError(c, errInvalidRequest("maxActiveGames and dailyGames must be non-negative integers"))
+		return
+	}
+
+	before, err := s.store.GameLimits(ctx, username)
+	if err != nil {
+		log.Printf("Error retrieving game limits for user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving game limits"))
+		return
+	}
+	limits := GameLimits{MaxActiveGames: req.MaxActiveGames, DailyGames: req.DailyGames}
+	if err := s.store.SetGameLimits(ctx, username, limits); err != nil {
+		log.Printf("Error setting game limits for user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error setting game limits"))
+		return
+	}
+
+	log.Printf("Admin set the game limits of user %s to %+v", username, limits)
+	entry := s.adminAuditEntry(c, "set_limits", username)
+	entry.Before, entry.After = before, limits
+	s.audit(c, entry)
+
+	c.JSON(http.StatusOK, AdminLimitsResponse{Username: username, Limits: limits, Effective: s.effectiveLimits(limits)})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestActiveGamesCap(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		var rooms []string
+		for _, opponent := range []string{"bob", "carol", "dave"} {
+			rooms = append(rooms, ts.openRoom("alice", opponent).gameID())
+		}
+		sort.Strings(rooms)
+
+		refused := assertError(t, ts.post("/start-game", User{Username: "alice"}), http.StatusTooManyRequests, ErrCodeQuotaExceeded)
+		if want := (&QuotaExceeded{Quota: QuotaActiveGames, Limit: defaultMaxActiveGames, ActiveGames: rooms}); !reflect.DeepEqual(refused.Quota, want) {
+			t.Fatalf("quota = %+v, want %+v", refused.Quota, want)
+		}
+		// Only alice is over it
+		ts.startGame("bob")
+
+		// A forfeit frees its slot
+		decodeOK[ForfeitResponse](t, ts.post("/forfeit", User{Username: "alice", GameID: rooms[0]}))
+		ts.startGame("alice")
+
+		// Rooms joined count too, so ending the solo game doesn't free a slot
+		// once alice is in another one
+		fourth := ts.openRoom("erin", "alice")
+		decodeOK[ForfeitResponse](t, ts.post("/forfeit", User{Username: "alice"}))
+		refused = assertError(t, ts.post("/start-game", User{Username: "alice"}), http.StatusTooManyRequests, ErrCodeQuotaExceeded)
+		want := []string{rooms[1], rooms[2], fourth.gameID()}
+		sort.Strings(want)
+		if !reflect.DeepEqual(refused.Quota.ActiveGames, want) {
+			t.Fatalf("active games = %v, want %v", refused.Quota.ActiveGames, want)
+		}
+	})
+}
+
+func TestDailyGamesQuotaResetsAtMidnight(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{"ADMIN_TOKEN": testAdminToken, "DAILY_GAME_QUOTA": "3"}))
+		// Midday, so the day's games can't spill over midnight
+		for i := 0; i < 3; i++ {
+			ts.winSoloGame("alice")
+			ts.clock.Advance(time.Minute)
+		}
+		midnight := time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC)
+		refused := assertError(t, ts.post("/start-game", User{Username: "alice"}), http.StatusTooManyRequests, ErrCodeQuotaExceeded)
+		if q := refused.Quota; q.Quota != QuotaDailyGames || q.Limit != 3 || q.ResetsAt == nil || !q.ResetsAt.Equal(midnight) {
+			t.Fatalf("quota = %+v", q)
+		}
+
+		// An admin can give the user more
+		limits := decodeOK[AdminLimitsResponse](t, ts.request(http.MethodPut, "/admin/users/alice/limits", AdminLimitsRequest{DailyGames: 4}, asAdmin...))
+		if limits.Effective != (GameLimits{MaxActiveGames: defaultMaxActiveGames, DailyGames: 4}) {
+			t.Fatalf("limits = %+v", limits)
+		}
+		ts.winSoloGame("alice")
+		if q := assertError(t, ts.post("/start-game", User{Username: "alice"}), http.StatusTooManyRequests, ErrCodeQuotaExceeded).Quota; q.Limit != 4 {
+			t.Fatalf("quota after the raise = %+v", q)
+		}
+
+		// The count starts over at midnight UTC, not a day after the first game
+		ts.clock.Advance(midnight.Sub(ts.clock.Now()) - time.Second)
+		assertError(t, ts.post("/start-game", User{Username: "alice"}), http.StatusTooManyRequests, ErrCodeQuotaExceeded)
+		ts.clock.Advance(time.Second)
+		ts.winSoloGame("alice")
+	})
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// How a finished game went for one of its players, all in one place so a
+// client doesn't have to piece it together. Sent with the draw that ended
+// the game and the game_over events, and kept with the game's move log.
+type GameSummary struct {
+	GameID   string `json:"gameId"`
+	Username string `json:"username"`
+	// "win", "lose" or "forfeit"
+	Result string `json:"result"`
+	// Cards the player drew, in total and by type
+	TotalDraws int64            `json:"totalDraws"`
+	Draws      map[string]int64 `json:"draws"`
+	// Defuses the player spent on bombs
+	DefusesUsed int64 `json:"defusesUsed"`
+	DurationMs  int64 `json:"durationMs"`
+	// The player's totals after the game. A survival run doesn't change them.
+	Wins   int64 `json:"wins"`
+	Losses int64 `json:"losses"`
+	// The player's win streak after the game, 0 once a loss ended it. Left
+	// at 0 for an unranked game, which doesn't touch it.
+	Streak   int64 `json:"streak"`
+	Unranked bool  `json:"unranked,omitempty"`
+	// Achievements the game earned the player
+	Achievements []string `json:"achievements"`
+	// The player's place on the all-time leaderboard after the game, 0 if
+	// they aren't on it
+	Rank int `json:"rank,omitempty"`
+}
+
+// Fields of a game hash counting what one player did in the game, what
+// they still owe, and holding their summary once it is over. A new game
+// drops them all.
+const (
+	drawCountPrefix    = "drawn:"
+	defusesUsedPrefix  = "defusesUsed:"
+	summaryPrefix      = "summary:"
+	pendingDrawsPrefix = "pendingDraws:"
+)
+
+// The count of cards of a type the player drew
+func drawCountField(username, card string) string {
+	return drawCountPrefix + username + ":" + card
+}
+
+// The count of Defuses the player spent
+func defusesUsedField(username string) string {
+	return defusesUsedPrefix + username
+}
+
+// The player's encoded GameSummary
+func summaryField(username string) string {
+	return summaryPrefix + username
+}
+
+// The draws an Attack left the player owing
+func pendingDrawsField(username string) string {
+	return pendingDrawsPrefix + username
+}
+
+// The fields among those of a game hash that belong to its players
+func playerGameFields(fields []string) []string {
+	var player []string
+	for _, field := range fields {
+		for _, prefix := range []string{drawCountPrefix, defusesUsedPrefix, summaryPrefix, pendingDrawsPrefix} {
+			if strings.HasPrefix(field, prefix) {
+				player = append(player, field)
+				break
+			}
+		}
+	}
+	return player
+}
+
+// Sum up a game that just ended for each of its human players, keep the
+// summaries with its move log and hold them on game for the responses and
+// events announcing the end. earned is the achievements each winner earned.
+// Failures only lose the summaries.
+func (s *Server) summarizeGame(ctx context.Context, game *GameSession, outcome gameOutcome, completion *GameCompletion, earned map[string][]string) {
+	hash, err := s.store.GetGameHash(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error retrieving game %s to summarize it: %v", game.ID, err)
+		return
+	}
+	startedAt, _, err := parseGameProgress(hash["startedAt"], hash["cardsDrawn"])
+	if err != nil {
+		log.Printf("Error reading progress of game %s: %v", game.ID, err)
+	}
+	ranks := s.leaderboardRanks(ctx)
+
+	game.summaries = make(map[string]*GameSummary)
+	winners := outcome.winners()
+	for i, username := range append(winners, outcome.losers()...) {
+		if isBot(username) {
+			continue
+		}
+		summary := &GameSummary{
+			GameID:       game.ID,
+			Username:     username,
+			Result:       "win",
+			Draws:        map[string]int64{},
+			Unranked:     outcome.Unranked,
+			Achievements: []string{},
+			Rank:         ranks[username],
+		}
+		if i < len(winners) {
+			credited := completion
+			if username == outcome.Partner {
+				credited = completion.Partner
+			}
+			if !outcome.Unranked && credited != nil {
+				summary.Streak = credited.Streak
+			}
+			summary.Achievements = append(summary.Achievements, earned[username]...)
+		} else {
+			summary.Result = outcome.LoserResult
+			if summary.Result == "" {
+				summary.Result = "lose"
+			}
+		}
+
+		prefix := drawCountPrefix + username + ":"
+		for field, value := range hash {
+			if card := strings.TrimPrefix(field, prefix); card != field {
+				count, _ := strconv.ParseInt(value, 10, 64)
+				summary.Draws[card] = count
+				summary.TotalDraws += count
+			}
+		}
+		summary.DefusesUsed, _ = strconv.ParseInt(hash[defusesUsedField(username)], 10, 64)
+		if !startedAt.IsZero() {
+			summary.DurationMs = s.clock.Now().Sub(startedAt).Milliseconds()
+		}
+		if summary.Wins, summary.Losses, err = s.store.GetStats(ctx, username); err != nil {
+			log.Printf("Error retrieving stats for user %s: %v", username, err)
+		}
+
+		payload, err := json.Marshal(summary)
+		if err != nil {
+			log.Printf("Error encoding summary of game %s for user %s: %v", game.ID, username, err)
+			continue
+		}
+		if err := s.store.SaveGameSummary(ctx, game.ID, username, payload); err != nil {
+			log.Printf("Error saving summary of game %s for user %s: %v", game.ID, username, err)
+		}
+		game.summaries[username] = summary
+	}
+}
+
+// Players' ranks on the cached all-time leaderboard, which holds the rows of
+// everyone online, so of anyone who just finished a game
+func (s *Server) leaderboardRanks(ctx context.Context) map[string]int {
+	payload, _, err := s.cachedLeaderboard(ctx)
+	if err != nil {
+		log.Println("Error fetching leaderboard data:", err)
+		return nil
+	}
+	var entries []LeaderboardEntry
+	if err := json.Unmarshal(payload, &entries); err != nil {
+		log.Println("Error decoding leaderboard data:", err)
+		return nil
+	}
+	ranks := make(map[string]int, len(entries))
+	for _, entry := range entries {
+		ranks[entry.Username] = entry.Rank
+	}
+	return ranks
+}
+
+// The player's kept summary of a finished game, nil if there is none
+func (s *Server) savedSummary(ctx context.Context, game *GameSession) *GameSummary {
+	return s.savedSummaries(ctx, game.ID, []string{game.Username})[game.Username]
+}
+
+// The players' kept summaries of a finished game, leaving out those who have
+// none
+func (s *Server) savedSummaries(ctx context.Context, gameID string, players []string) map[string]*GameSummary {
+	hash, err := s.store.GetGameHash(ctx, gameID)
+	if err != nil {
+		log.Printf("Error retrieving summary of game %s: %v", gameID, err)
+		return nil
+	}
+	summaries := make(map[string]*GameSummary)
+	for _, username := range players {
+		payload := hash[summaryField(username)]
+		if payload == "" {
+			continue
+		}
+		var summary GameSummary
+		if err := json.Unmarshal([]byte(payload), &summary); err != nil {
+			log.Printf("Warning: unreadable summary of game %s for user %s: %v", gameID, username, err)
+			continue
+		}
+		summaries[username] = &summary
+	}
+	return summaries
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+func TestSeededGameSummary(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		first, second := room.Turn, room.nextAlive(room.Turn)
+		ts.setDeck(room.gameID(), "Cat", "Tacocat", engine.ExplodingKitten, engine.ExplodingKitten)
+		ts.deal(first, engine.Defuse)
+		socket := ts.dial("room=" + room.Code)
+		socket.next("snapshot")
+		drawAfter := func(player string) DrawCardResponse {
+			ts.clock.Advance(5 * time.Second)
+			return decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: player, GameID: room.gameID()}))
+		}
+
+		drawAfter(first)
+		drawAfter(second)
+		if drawn := drawAfter(first); drawn.Disposition != DispositionPendingDefuse {
+			t.Fatalf("draw of the first bomb = %+v", drawn)
+		}
+		// With only a bomb left the defused one goes back next to it
+		useDefuse := true
+		decodeOK[DrawCardResponse](t, ts.post("/resolve-bomb", ResolveBombRequest{Username: first, GameID: room.gameID(), UseDefuse: &useDefuse}))
+		lost := drawAfter(second)
+		if lost.GameStatus != GameStatusLost || lost.Summary == nil {
+			t.Fatalf("draw of the second bomb = %+v", lost)
+		}
+
+		ranks := make(map[string]int)
+		for _, row := range decodeOK[LeaderboardResponse](t, ts.get("/leaderboard")).Leaderboard {
+			ranks[row.Username] = row.Rank
+		}
+		var earned []string
+		for _, achievement := range decodeOK[AchievementsResponse](t, ts.get("/achievements/"+first)).Achievements {
+			earned = append(earned, achievement.Name)
+		}
+		want := map[string]*GameSummary{
+			first: {
+				GameID:       room.gameID(),
+				Username:     first,
+				Result:       "win",
+				TotalDraws:   2,
+				Draws:        map[string]int64{"Cat": 1, engine.ExplodingKitten: 1},
+				DefusesUsed:  1,
+				DurationMs:   20000,
+				Wins:         1,
+				Streak:       1,
+				Achievements: earned,
+				Rank:         ranks[first],
+			},
+			second: {
+				GameID:       room.gameID(),
+				Username:     second,
+				Result:       "lose",
+				TotalDraws:   2,
+				Draws:        map[string]int64{"Tacocat": 1, engine.ExplodingKitten: 1},
+				DurationMs:   20000,
+				Losses:       1,
+				Achievements: []string{},
+			},
+		}
+		// Without a win the loser isn't ranked
+		if ranks[first] != 1 || ranks[second] != 0 || len(earned) == 0 {
+			t.Fatalf("ranks %v, achievements %v", ranks, earned)
+		}
+		if !reflect.DeepEqual(lost.Summary, want[second]) {
+			t.Fatalf("loser's summary = %+v, want %+v", lost.Summary, want[second])
+		}
+
+		// The room's game_over carries both, and the replay keeps them
+		ts.clock.Advance(ts.revealDelay)
+		over := decodeMessage[RoomEvent](t, socket.next("game_over"))
+		if !reflect.DeepEqual(over.Summaries, want) {
+			t.Fatalf("game_over summaries = %+v, want %+v", over.Summaries, want)
+		}
+		for _, player := range []string{first, second} {
+			replay := decodeOK[ReplayResponse](t, ts.get("/game/"+room.gameID()+"/replay?username="+player))
+			if !reflect.DeepEqual(replay.Summary, want[player]) {
+				t.Fatalf("%s's replay summary = %+v, want %+v", player, replay.Summary, want[player])
+			}
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long a session token stays valid unless SESSION_TTL says otherwise
+const defaultSessionTTL = 30 * 24 * time.Hour
+
+// Names tried before POST /guest gives up
+const guestNameAttempts = 10
+
+// Parts of generated guest names, e.g. "SwiftKitten_4821"
+var (
+	guestAdjectives = []string{"Swift", "Fuzzy", "Sneaky", "Brave", "Sleepy", "Lucky", "Grumpy", "Fluffy", "Clever", "Daring"}
+	guestNouns      = []string{"Kitten", "Whisker", "Paw", "Tabby", "Mouser", "Purr", "Tiger", "Meow"}
+)
+
+type ClaimRequest struct {
+	Username string `json:"username"`
+}
+
+// A readable random guest name
+func guestName() string {
+	return fmt.Sprintf("%s%s_%04d",
+		guestAdjectives[mathrand.Intn(len(guestAdjectives))],
+		guestNouns[mathrand.Intn(len(guestNouns))],
+		mathrand.Intn(10000))
+}
+
+// A random session token
+func newSessionToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// The user the request's bearer session token belongs to
+func (s *Server) sessionUser(c *gin.Context) (string, string, *APIError) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		return "", "", errUnauthorized()
+	}
+	username, err := s.store.SessionUser(c.Request.Context(), token)
+	if err != nil {
+		log.Printf("Error looking up session: %v", err)
+		return "", "", errStoreUnavailable("Error looking up session")
+	}
+	if username == "" {
+		return "", "", errUnauthorized()
+	}
+	s.touchPresence(c.Request.Context(), username)
+	return token, username, nil
+}
+
+// Guest route: create a player with a generated name and a session token
+func (s *Server) createGuest(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var username string
+	for i := 0; i < guestNameAttempts && username == ""; i++ {
+		name := guestName()
+		created, err := s.store.CreateGuest(ctx, name)
+		if err != nil {
+			log.Printf("Error creating guest %s: %v", name, err)
+			abortWithError(c, errStoreUnavailable("Error creating guest"))
+			return
+		}
+		if created {
+			username = name
+		} else {
+			log.Printf("Guest name %s is taken, retrying", name)
+		}
+	}
+	if username == "" {
+		abortWithError(c, errStoreUnavailable("Could not find a free guest name"))
+		return
+	}
+
+	token, err := newSessionToken()
+	if err == nil {
+		err = s.store.CreateSession(ctx, token, username, s.sessionTTL)
+	}
+	if err != nil {
+		log.Printf("Error creating session for guest %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error creating session"))
+		return
+	}
+	s.leaderboard.invalidate()
+
+	log.Printf("Created guest %s", username)
+	c.JSON(http.StatusOK, GuestResponse{Username: username, Token: token, Guest: true})
+}
+
+// Claim route: give a guest a permanent username. Their stats, achievements
+// and solo game move to the new name.
+func (s *Server) claimGuest(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	token, guest, apiErr := s.sessionUser(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	var req ClaimRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+
+	guests, err := s.store.Guests(ctx)
+	if err != nil {
+		log.Printf("Error fetching guests: %v", err)
+		abortWithError(c, errStoreUnavailable("Error fetching guests"))
+		return
+	}
+	if !guests[guest] {
+		abortWithError(c, errInvalidRequest("Only guests can claim a username"))
+		return
+	}
+
+	if err := s.store.RenameUser(ctx, guest, req.Username); err != nil {
+		if err == errUsernameTaken {
+			abortWithError(c, errUsernameInUse())
+			return
+		}
+		log.Printf("Error renaming guest %s to %s: %v", guest, req.Username, err)
+		abortWithError(c, errStoreUnavailable("Error claiming username"))
+		return
+	}
+	if err := s.store.CreateSession(ctx, token, req.Username, s.sessionTTL); err != nil {
+		log.Printf("Error moving session of %s to %s: %v", guest, req.Username, err)
+		abortWithError(c, errStoreUnavailable("Error updating session"))
+		return
+	}
+	s.leaderboard.invalidate()
+
+	log.Printf("Guest %s claimed username %s", guest, req.Username)
+	c.JSON(http.StatusOK, ClaimResponse{Username: req.Username, Previous: guest, Token: token})
+}
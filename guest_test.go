@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// A store on which the first collisions guest names are already taken by
+// the time CreateGuest checks them
+type collidingStore struct {
+	GameStore
+	mutex      sync.Mutex
+	collisions int
+	tried      []string
+}
+
+func (s *collidingStore) CreateGuest(ctx context.Context, username string) (bool, error) {
+	s.mutex.Lock()
+	s.tried = append(s.tried, username)
+	collide := len(s.tried) <= s.collisions
+	s.mutex.Unlock()
+	if collide {
+		if err := s.GameStore.CreateDeck(ctx, username, []string{"Cat"}); err != nil {
+			return false, err
+		}
+	}
+	return s.GameStore.CreateGuest(ctx, username)
+}
+
+func (ts *testServer) guest() GuestResponse {
+	ts.t.Helper()
+	return decodeOK[GuestResponse](ts.t, ts.post("/guest", nil))
+}
+
+func bearer(token string) []string {
+	return []string{"Authorization", "Bearer " + token}
+}
+
+func TestGuestRetriesTakenNames(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, backing GameStore) {
+		store := &collidingStore{GameStore: backing, collisions: 2}
+		ts := newTestServer(t, store)
+
+		guest := ts.guest()
+		if len(store.tried) != 3 || guest.Username != store.tried[2] || !guest.Guest {
+			t.Fatalf("guest = %+v after trying %v", guest, store.tried)
+		}
+		guests, err := backing.Guests(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(guests) != 1 || !guests[guest.Username] {
+			t.Fatalf("guests = %v, want only %s", guests, guest.Username)
+		}
+	})
+}
+
+func TestGuestGivesUpWhenEveryNameIsTaken(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, backing GameStore) {
+		store := &collidingStore{GameStore: backing, collisions: guestNameAttempts}
+		ts := newTestServer(t, store)
+
+		assertError(t, ts.post("/guest", nil), http.StatusServiceUnavailable, ErrCodeStoreUnavailable)
+		if len(store.tried) != guestNameAttempts {
+			t.Fatalf("tried %d names, want %d", len(store.tried), guestNameAttempts)
+		}
+	})
+}
+
+func TestClaimRenamesGuest(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		guest := ts.guest()
+		ts.startGame(guest.Username, "Cat", "Skip", engine.ExplodingKitten)
+		decodeOK[DrawCardResponse](t, ts.draw(guest.Username))
+		ts.store.SetStats(ctx, guest.Username, 3, 1, AuditEntry{})
+
+		claimed := decodeOK[ClaimResponse](t, ts.post("/claim", ClaimRequest{Username: "alice"}, bearer(guest.Token)...))
+		if claimed.Username != "alice" || claimed.Previous != guest.Username || claimed.Token != guest.Token {
+			t.Fatalf("claim = %+v", claimed)
+		}
+
+		if win, lose := ts.stats("alice"); win != 3 || lose != 1 {
+			t.Fatalf("alice's stats = %d/%d, want 3/1", win, lose)
+		}
+		if hand := ts.hand("alice"); !reflect.DeepEqual(hand, []string{"Cat"}) {
+			t.Fatalf("alice's hand = %v", hand)
+		}
+		if deck := ts.deck("alice"); !reflect.DeepEqual(deck, []string{"Skip", engine.ExplodingKitten}) {
+			t.Fatalf("alice's deck = %v", deck)
+		}
+
+		// Nothing is left under the guest name
+		if hand := ts.hand(guest.Username); len(hand) != 0 {
+			t.Fatalf("guest hand = %v", hand)
+		}
+		if deck := ts.deck(guest.Username); len(deck) != 0 {
+			t.Fatalf("guest deck = %v", deck)
+		}
+		if exists, err := ts.store.UserExists(ctx, guest.Username); err != nil || exists {
+			t.Fatalf("guest still exists: %v, %v", exists, err)
+		}
+		guests, _ := ts.store.Guests(ctx)
+		if len(guests) != 0 {
+			t.Fatalf("guests = %v after the claim", guests)
+		}
+
+		// The session follows the player, and only guests can claim
+		if username, _ := ts.store.SessionUser(ctx, guest.Token); username != "alice" {
+			t.Fatalf("session belongs to %q", username)
+		}
+		assertError(t, ts.post("/claim", ClaimRequest{Username: "alicia"}, bearer(guest.Token)...), http.StatusBadRequest, ErrCodeInvalidRequest)
+	})
+}
+
+func TestClaimRejectsTakenName(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		ts.startGame("bob")
+		guest := ts.guest()
+		ts.store.SetStats(ctx, guest.Username, 2, 0, AuditEntry{})
+
+		assertError(t, ts.post("/claim", ClaimRequest{Username: "bob"}, bearer(guest.Token)...), http.StatusConflict, ErrCodeUsernameTaken)
+
+		// Both players are untouched
+		if win, _ := ts.stats(guest.Username); win != 2 {
+			t.Fatalf("guest wins = %d, want 2", win)
+		}
+		if got := len(ts.deck("bob")); got != ts.soloDeck.Size {
+			t.Fatalf("bob's deck has %d cards", got)
+		}
+		if username, _ := ts.store.SessionUser(ctx, guest.Token); username != guest.Username {
+			t.Fatalf("session belongs to %q", username)
+		}
+	})
+}
+
+func TestClaimNeedsSession(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		assertError(t, ts.post("/claim", ClaimRequest{Username: "alice"}), http.StatusUnauthorized, ErrCodeUnauthorized)
+		assertError(t, ts.post("/claim", ClaimRequest{Username: "alice"}, bearer("nope")...), http.StatusUnauthorized, ErrCodeUnauthorized)
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Two players' record against each other over the room games they played
+// with no one else
+type HeadToHeadRecord struct {
+	UserA string `json:"userA"`
+	UserB string `json:"userB"`
+	// Games each of them won
+	WinsA int64 `json:"winsA"`
+	WinsB int64 `json:"winsB"`
+	Games int64 `json:"games"`
+	// When their last game ended; nil if they never met
+	LastPlayed *time.Time `json:"lastPlayed,omitempty"`
+}
+
+// The field of a head-to-head hash counting the player's wins. No username
+// holds a colon, so it can't be taken for "games" or "lastPlayed".
+func headToHeadWinsField(username string) string { return "wins:" + username }
+
+// Decode the head-to-head hash of a and b, with a's side first; missing or
+// unreadable counts are zero
+func parseHeadToHead(a, b string, hash map[string]string) HeadToHeadRecord {
+	count := func(field string) int64 {
+		value, _ := strconv.ParseInt(hash[field], 10, 64)
+		return value
+	}
+	record := HeadToHeadRecord{
+		UserA: a,
+		UserB: b,
+		WinsA: count(headToHeadWinsField(a)),
+		WinsB: count(headToHeadWinsField(b)),
+		Games: count("games"),
+	}
+	if ms := count("lastPlayed"); ms > 0 {
+		lastPlayed := time.UnixMilli(ms).UTC()
+		record.LastPlayed = &lastPlayed
+	}
+	return record
+}
+
+// Whether a room game's outcome counts toward its players' head-to-head
+// record: the room had two players, both human, and one of them won
+func countsHeadToHead(room *Room, outcome gameOutcome) bool {
+	return room != nil && len(room.Players) == 2 && !outcome.Unranked && outcome.Partner == "" &&
+		outcome.Winner != "" && !isBot(outcome.Winner) && outcome.Loser != "" && !isBot(outcome.Loser)
+}
+
+// Head-to-head route: how two players have done against each other, the
+// first one named on side A
+func (s *Server) getHeadToHead(c *gin.Context) {
+	a, b := c.Param("userA"), c.Param("userB")
+	if !usernamePattern.MatchString(a) || !usernamePattern.MatchString(b) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+	if a == b {
+		abortWithError(c, errInvalidRequest("A player has no record against themselves"))
+		return
+	}
+
+	record, err := s.store.HeadToHead(c.Request.Context(), a, b)
+	if err != nil {
+		log.Printf("Error retrieving head-to-head record of %s and %s: %v", a, b, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving head-to-head record"))
+		return
+	}
+	if record.Games == 0 {
+		abortWithError(c, errNeverMet())
+		return
+	}
+	c.JSON(http.StatusOK, record)
+}
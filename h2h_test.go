@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+// Play a room game of alice and bob that winner wins, and return its
+// game_over event
+func (ts *testServer) playHeadToHead(winner string) RoomEvent {
+	ts.t.Helper()
+	room := ts.openRoom("alice", "bob")
+	socket := ts.dial("room=" + room.Code)
+	socket.next("snapshot")
+	ts.deal("alice")
+	ts.deal("bob")
+	turn := room.Turn
+	if turn == winner {
+		ts.setDeck(room.gameID(), "Cat", engine.ExplodingKitten)
+		decodeOK[DrawCardResponse](ts.t, ts.post("/draw-card", User{Username: turn, GameID: room.gameID()}))
+		turn = room.nextAlive(turn)
+	} else {
+		ts.setDeck(room.gameID(), engine.ExplodingKitten, "Cat")
+	}
+	if drawn := decodeOK[DrawCardResponse](ts.t, ts.post("/draw-card", User{Username: turn, GameID: room.gameID()})); drawn.Disposition != DispositionExploded {
+		ts.t.Fatalf("bomb drawn by %s = %+v", turn, drawn)
+	}
+	// Announced once the bomb is revealed, the end stamped when it was drawn
+	ts.clock.Advance(ts.revealDelay)
+	return decodeMessage[RoomEvent](ts.t, socket.next("game_over"))
+}
+
+func TestHeadToHeadFromBothSides(t *testing.T) {
+	eachAdminStore(t, func(t *testing.T, ts *testServer) {
+		assertError(t, ts.get("/h2h/alice/bob"), http.StatusNotFound, ErrCodeNeverMet)
+
+		var lastPlayed time.Time
+		for i, winner := range []string{"alice", "bob", "alice"} {
+			ts.clock.Advance(time.Minute)
+			lastPlayed = ts.clock.Now()
+			over := ts.playHeadToHead(winner)
+			line := over.HeadToHead
+			if over.Winner != winner || line == nil || line.UserA != winner || line.Games != int64(i+1) || !line.LastPlayed.Equal(lastPlayed) {
+				t.Fatalf("game %d's game_over = %+v, head to head %+v", i+1, over, line)
+			}
+		}
+
+		want := HeadToHeadRecord{UserA: "alice", UserB: "bob", WinsA: 2, WinsB: 1, Games: 3, LastPlayed: &lastPlayed}
+		if got := decodeOK[HeadToHeadRecord](t, ts.get("/h2h/alice/bob")); !reflect.DeepEqual(got, want) {
+			t.Fatalf("alice vs bob = %+v, want %+v", got, want)
+		}
+		want = HeadToHeadRecord{UserA: "bob", UserB: "alice", WinsA: 1, WinsB: 2, Games: 3, LastPlayed: &lastPlayed}
+		if got := decodeOK[HeadToHeadRecord](t, ts.get("/h2h/bob/alice")); !reflect.DeepEqual(got, want) {
+			t.Fatalf("bob vs alice = %+v, want %+v", got, want)
+		}
+
+		reader := ts.createAPIKey(ScopeStatsRead)
+		if got := decodeOK[HeadToHeadRecord](t, ts.get("/h2h/bob/alice", "X-Api-Key", reader.Key)); got.Games != 3 {
+			t.Fatalf("with an API key = %+v", got)
+		}
+		assertError(t, ts.get("/h2h/alice/carol"), http.StatusNotFound, ErrCodeNeverMet)
+		assertError(t, ts.get("/h2h/alice/alice"), http.StatusBadRequest, ErrCodeInvalidRequest)
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cards a hand may hold when MAX_HAND_SIZE isn't set
+const defaultMaxHandSize = 8
+
+// Why a game is blocked, as stored in the game hash
+const blockHandLimit = "hand_limit"
+
+type DiscardRequest struct {
+	Username string `json:"username"`
+	GameID   string `json:"gameId"`
+	Card     string `json:"card"`
+}
+
+// After a draw that took the player's hand past the limit, block the game
+// until they discard. Returns the hand and whether the game is now blocked.
+func (s *Server) enforceHandLimit(ctx context.Context, game *GameSession) ([]string, bool, *APIError) {
+	hand, err := s.store.GetHand(ctx, game.Username)
+	if err != nil {
+		log.Printf("Error retrieving hand for user %s: %v", game.Username, err)
+		return nil, false, errStoreUnavailable("Error retrieving hand")
+	}
+	if len(hand) <= s.maxHandSize {
+		return hand, false, nil
+	}
+
+	if err := s.store.SetDiscardBlock(ctx, game.ID, game.Username, blockHandLimit); err != nil {
+		log.Printf("Error blocking game %s for a discard: %v", game.ID, err)
+		return nil, false, errStoreUnavailable("Error updating game")
+	}
+	log.Printf("User %s holds %d cards and must discard", game.Username, len(hand))
+	if game.Room != nil {
+		s.hub.broadcastRoom(game.Room.Code, RoomEvent{
+			Type:     "discard_required",
+			Username: game.Username,
+			Message:  fmt.Sprintf("%s must discard down to %d cards", game.Username, s.maxHandSize),
+		})
+	}
+	return hand, true, nil
+}
+
+// Reject draws and plays while a player of the game has to discard
+func (s *Server) checkNotBlocked(ctx context.Context, game *GameSession) *APIError {
+	username, cause, err := s.store.DiscardBlock(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error checking discard block of game %s: %v", game.ID, err)
+		return errStoreUnavailable("Error checking game status")
+	}
+	if username != "" {
+		return s.blockedError(username, cause)
+	}
+	return nil
+}
+
+// The error for a move on a game blocked on the player for the cause
+func (s *Server) blockedError(username, cause string) *APIError {
+	if cause == blockPendingDefuse {
+		return errBombPending(username)
+	}
+	return errMustDiscard(username, s.maxHandSize)
+}
+
+// Drop a card from a hand that is over the limit, unblocking the game once
+// the hand fits
+func (s *Server) discard(ctx context.Context, req DiscardRequest) (*DiscardResponse, *APIError) {
+	game, apiErr := s.resolveMove(ctx, User{Username: req.Username, GameID: req.GameID})
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	blocked, cause, err := s.store.DiscardBlock(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error checking discard block of game %s: %v", game.ID, err)
+		return nil, errStoreUnavailable("Error checking game status")
+	}
+	if blocked != game.Username || cause == blockPendingDefuse {
+		return nil, errInvalidRequest("You don't have to discard")
+	}
+
+	removed, err := s.store.RemoveFromHand(ctx, game.Username, req.Card)
+	if err != nil {
+		log.Printf("Error removing %s from hand for user %s: %v", req.Card, game.Username, err)
+		return nil, errStoreUnavailable("Error updating hand")
+	}
+	if !removed {
+		return nil, errCardNotInHand(req.Card)
+	}
+	hand, err := s.store.GetHand(ctx, game.Username)
+	if err != nil {
+		log.Printf("Error retrieving hand for user %s: %v", game.Username, err)
+		return nil, errStoreUnavailable("Error retrieving hand")
+	}
+
+	status := GameStatusMustDiscard
+	if len(hand) <= s.maxHandSize {
+		if err := s.store.ClearDiscardBlock(ctx, game.ID); err != nil {
+			log.Printf("Error unblocking game %s: %v", game.ID, err)
+			return nil, errStoreUnavailable("Error updating game")
+		}
+		status = GameStatusActive
+	}
+
+	log.Printf("User %s discarded a %s card", game.Username, req.Card)
+	s.recordMove(ctx, game, MoveDiscard, req.Card, status)
+	if game.Room != nil {
+		s.hub.broadcastRoom(game.Room.Code, RoomEvent{Type: "card_discarded", Username: game.Username, Card: findCard(cardTheme(ctx, game), req.Card)})
+	}
+
+	return &DiscardResponse{
+		Message:    fmt.Sprintf("You discarded a %s card.", req.Card),
+		Hand:       cardsFor(cardTheme(ctx, game), hand),
+		GameStatus: status,
+	}, nil
+}
+
+// Discard route
+func (s *Server) discardCard(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req DiscardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+
+	response, apiErr := s.discard(ctx, req)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// A bot over the limit drops its least useful card: anything but a Defuse
+func (s *Server) botDiscard(ctx context.Context, game *GameSession) {
+	hand, err := s.store.GetHand(ctx, game.Username)
+	if err != nil || len(hand) == 0 {
+		log.Printf("Error retrieving hand for the bot %s: %v", game.Username, err)
+		return
+	}
+	card := hand[0]
+	for _, held := range hand {
+		if held != "Defuse" {
+			card = held
+			break
+		}
+	}
+	if _, apiErr := s.discard(ctx, DiscardRequest{Username: game.Username, GameID: game.ID, Card: card}); apiErr != nil {
+		log.Printf("Error discarding for the bot %s: %s", game.Username, apiErr.Message)
+	}
+}
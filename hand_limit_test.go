@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+func TestHandLimitBlocksUntilDiscard(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Tacocat", "Cat", "Cat", engine.ExplodingKitten)
+		full := make([]string, defaultMaxHandSize)
+		for i := range full {
+			full[i] = "Cat"
+		}
+		ts.deal("alice", full...)
+
+		// The draw past the limit goes into the hand and blocks the game
+		drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if drawn.GameStatus != GameStatusMustDiscard || len(drawn.Hand) != defaultMaxHandSize+1 {
+			t.Fatalf("draw = %s with %d cards", drawn.GameStatus, len(drawn.Hand))
+		}
+		assertError(t, ts.draw("alice"), http.StatusConflict, ErrCodeMustDiscard)
+		assertError(t, ts.post("/draw-cards", DrawCardsRequest{Username: "alice", Count: 2}), http.StatusConflict, ErrCodeMustDiscard)
+
+		// A refresh finds the game still blocked
+		snapshot := decodeOK[GameSnapshot](t, ts.get("/game/alice/snapshot?username=alice"))
+		if snapshot.Status != GameStatusMustDiscard || snapshot.MustDiscard != "alice" || snapshot.BlockedCause != blockHandLimit {
+			t.Fatalf("snapshot = %s, blocked on %q for %q", snapshot.Status, snapshot.MustDiscard, snapshot.BlockedCause)
+		}
+
+		assertError(t, ts.post("/discard", DiscardRequest{Username: "alice", Card: "Defuse"}), http.StatusConflict, ErrCodeCardNotInHand)
+		discarded := decodeOK[DiscardResponse](t, ts.post("/discard", DiscardRequest{Username: "alice", Card: "Tacocat"}))
+		if discarded.GameStatus != GameStatusActive || len(discarded.Hand) != defaultMaxHandSize {
+			t.Fatalf("discard = %s with %d cards", discarded.GameStatus, len(discarded.Hand))
+		}
+		if hand := ts.hand("alice"); len(hand) != defaultMaxHandSize || hand[len(hand)-1] != "Cat" {
+			t.Fatalf("hand = %v", hand)
+		}
+		assertError(t, ts.post("/discard", DiscardRequest{Username: "alice", Card: "Cat"}), http.StatusBadRequest, ErrCodeInvalidRequest)
+
+		// Play resumes, and the next draw over the limit blocks again
+		snapshot = decodeOK[GameSnapshot](t, ts.get("/game/alice/snapshot?username=alice"))
+		if snapshot.Status != GameStatusActive || snapshot.MustDiscard != "" {
+			t.Fatalf("snapshot after the discard = %s, blocked on %q", snapshot.Status, snapshot.MustDiscard)
+		}
+		if drawn := decodeOK[DrawCardResponse](t, ts.draw("alice")); drawn.Card.Type != "Cat" || drawn.GameStatus != GameStatusMustDiscard {
+			t.Fatalf("draw after the discard = %+v", drawn)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+
+	"github.com/gorilla/websocket"
+)
+
+// The part of the store the hub uses to number events and keep them for
+// clients that reconnect
+type eventHistory interface {
+	NextEventSeq(ctx context.Context, stream string) (int64, error)
+	AppendEvent(ctx context.Context, stream string, event []byte) error
+	EventHistory(ctx context.Context, stream string) ([][]byte, int64, error)
+}
+
+// Sent instead of a replay when the missed events are no longer retained
+type resyncEvent struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// Missing lastSeq query param: a fresh connection with nothing to replay
+const noLastSeq = -1
+
+// Reserve the stream's next sequence number. Events go out unnumbered if the
+// store can't be reached rather than not at all.
+func (h *Hub) nextSeq(stream string) int64 {
+	if h.history == nil {
+		return 0
+	}
+	seq, err := h.history.NextEventSeq(context.Background(), stream)
+	if err != nil {
+		log.Printf("Error numbering event for %s: %v", stream, err)
+		return 0
+	}
+	return seq
+}
+
+// Keep an encoded event for replay
+func (h *Hub) record(stream string, payload []byte) {
+	if err := h.history.AppendEvent(context.Background(), stream, payload); err != nil {
+		log.Printf("Error recording event for %s: %v", stream, err)
+	}
+}
+
+// The retained events after lastSeq, oldest first. Returns false when some of
+// them have fallen out of the history and the client has to resync.
+func (h *Hub) missedEvents(stream string, lastSeq int64) ([][]byte, bool, error) {
+	entries, latest, err := h.history.EventHistory(context.Background(), stream)
+	if err != nil {
+		return nil, false, err
+	}
+	if lastSeq > latest {
+		// The stream was reset, e.g. by a new game
+		return nil, false, nil
+	}
+	if lastSeq == latest {
+		return nil, true, nil
+	}
+
+	type numbered struct {
+		seq     int64
+		payload []byte
+	}
+	var missed []numbered
+	for _, entry := range entries {
+		var head struct {
+			Seq int64 `json:"seq"`
+		}
+		if err := json.Unmarshal(entry, &head); err != nil {
+			continue
+		}
+		if head.Seq > lastSeq && head.Seq <= latest {
+			missed = append(missed, numbered{head.Seq, entry})
+		}
+	}
+
+	// Concurrent events can land in the history slightly out of order
+	sort.Slice(missed, func(i, j int) bool { return missed[i].seq < missed[j].seq })
+	if len(missed) == 0 || missed[0].seq != lastSeq+1 {
+		return nil, false, nil
+	}
+
+	payloads := make([][]byte, len(missed))
+	for i, event := range missed {
+		payloads[i] = event.payload
+	}
+	return payloads, true, nil
+}
+
+// Replay what the connection missed since lastSeq, then add it to conns. The
+// hub lock is held throughout so no live event slips in between. Returns
+// false if a resync message was sent instead of the replay.
+func (h *Hub) resume(stream string, lastSeq int64, conn *websocket.Conn, conns func() map[*websocket.Conn]bool) (bool, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	replay, ok := [][]byte(nil), false
+	if h.history != nil {
+		var err error
+		if replay, ok, err = h.missedEvents(stream, lastSeq); err != nil {
+			log.Printf("Error reading event history for %s: %v", stream, err)
+		}
+	}
+
+	if ok {
+		for _, payload := range replay {
+			if err := h.pool.send(conn, payload); err != nil {
+				return false, err
+			}
+		}
+	} else if err := h.send(conn, resyncEvent{
+		Type:    "resync",
+		Message: "Missed events are no longer available. Fetch the game state again.",
+	}); err != nil {
+		return false, err
+	}
+
+	conns()[conn] = true
+	websocketConnections.Inc()
+	return ok, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestResumeReplaysMissedEvents(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", "Skip", "Shuffle", "Cat", "Skip", "Cat")
+		socket := ts.dial("spectate=alice")
+		socket.next("snapshot")
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		seen := decodeMessage[SpectatorEvent](t, socket.next("card_drawn"))
+		socket.conn.Close()
+
+		// Two moves go by while the client is away
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+
+		socket = ts.dial(fmt.Sprintf("spectate=alice&lastSeq=%d", seen.Seq))
+		for i, want := range []string{"Skip", "Shuffle"} {
+			event := decodeMessage[SpectatorEvent](t, socket.any())
+			if event.Type != "card_drawn" || event.Seq != seen.Seq+int64(i)+1 || event.Card == nil || event.Card.Type != want {
+				t.Fatalf("replayed event %d = %+v, want the %s drawn at seq %d", i, event, want, seen.Seq+int64(i)+1)
+			}
+		}
+
+		// Nothing else was replayed: the next message is the next live one
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if event := decodeMessage[SpectatorEvent](t, socket.any()); event.Seq != seen.Seq+3 {
+			t.Fatalf("after the replay got %+v, want seq %d", event, seen.Seq+3)
+		}
+	})
+}
+
+func TestResumeFromUnknownSeqResyncs(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", "Skip", "Cat")
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+
+		socket := ts.dial("spectate=alice&lastSeq=99")
+		if event := socket.any(); event["type"] != "resync" {
+			t.Fatalf("first message = %v, want a resync", event)
+		}
+	})
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Hub tracks the active WebSocket connections: leaderboard clients, with
+// the player each one belongs to if it said, spectators keyed by the username they are watching, room sockets keyed
+// by room code and tournament sockets keyed by tournament ID. Frames for a connection are queued on the send pool, whose
+// workers write them in order, so nothing waits on a slow socket. Broadcasts
+// go through the bus so connections on other instances receive them too.
+type Hub struct {
+	bus        EventBus
+	history    eventHistory
+	pool       *sendPool
+	mutex      sync.Mutex
+	clients    map[*websocket.Conn]bool
+	clientUser map[*websocket.Conn]string
+	// Cancels the context of the work done for each leaderboard client; see
+	// register
+	clientCancel map[*websocket.Conn]context.CancelFunc
+	// The standings last sent to the leaderboard clients, numbered from 1,
+	// and the version each client holds; see nextStandings
+	standings        []LeaderboardEntry
+	standingsVersion uint64
+	clientVersion    map[*websocket.Conn]uint64
+	spectators       map[string]map[*websocket.Conn]bool
+	rooms            map[string]map[*websocket.Conn]bool
+	tournaments      map[string]map[*websocket.Conn]bool
+	// What each socket that said hello understands; see Server.hello
+	capabilities map[*websocket.Conn]map[string]bool
+
+	// Sockets open on this instance, of every kind, and those of each
+	// player, oldest first, held under maxConns and maxUserConns; see
+	// reserveConn and admitUserConn
+	maxConns     int
+	maxUserConns int
+	conns        int
+	userConns    map[string][]*websocket.Conn
+
+	// Messages held back by a delay, such as a bomb reveal, and the ones
+	// queued behind them; see dispatch
+	clock      Clock
+	queueMutex sync.Mutex
+	queue      []queuedMessage
+	queueTimer Timer
+}
+
+func newHub() *Hub {
+	h := &Hub{
+		clients:       make(map[*websocket.Conn]bool),
+		clientUser:    make(map[*websocket.Conn]string),
+		clientCancel:  make(map[*websocket.Conn]context.CancelFunc),
+		clientVersion: make(map[*websocket.Conn]uint64),
+		spectators:    make(map[string]map[*websocket.Conn]bool),
+		rooms:         make(map[string]map[*websocket.Conn]bool),
+		tournaments:   make(map[string]map[*websocket.Conn]bool),
+		capabilities:  make(map[*websocket.Conn]map[string]bool),
+		maxConns:      defaultMaxConns,
+		maxUserConns:  defaultMaxUserConns,
+		userConns:     make(map[string][]*websocket.Conn),
+		clock:         realClock{},
+	}
+	h.bus = localBus{hub: h}
+	h.pool = newSendPool(defaultBroadcastWorkers, func() time.Time { return h.clock.Now() })
+	return h
+}
+
+// Register a leaderboard connection. username is the player whose own row
+// it gets after the top rows, "" if it didn't say. Store calls made for the
+// connection take the returned context, which unregister cancels, so a
+// client that drops doesn't leave them running.
+func (h *Hub) register(ctx context.Context, conn *websocket.Conn, username string) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	h.mutex.Lock()
+	h.clients[conn] = true
+	h.clientCancel[conn] = cancel
+	if username != "" {
+		h.clientUser[conn] = username
+	}
+	h.mutex.Unlock()
+	websocketConnections.Inc()
+	return ctx
+}
+
+// Whether conn is a leaderboard connection
+func (h *Hub) isClient(conn *websocket.Conn) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.clients[conn]
+}
+
+// Set the player of a leaderboard connection. Returns false if conn isn't
+// one.
+func (h *Hub) identifyClient(conn *websocket.Conn, username string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if !h.clients[conn] {
+		return false
+	}
+	h.clientUser[conn] = username
+	return true
+}
+
+// The player's sockets on this instance: the leaderboard connections that
+// said they are the player's, and any socket opened with their ?username=.
+// Callers hold the mutex.
+func (h *Hub) playerConns(username string) map[*websocket.Conn]bool {
+	conns := make(map[*websocket.Conn]bool)
+	for conn, user := range h.clientUser {
+		if user == username {
+			conns[conn] = true
+		}
+	}
+	for _, conn := range h.userConns[username] {
+		conns[conn] = true
+	}
+	return conns
+}
+
+// Whether the player has a socket open on this instance. One on another
+// instance would still get notifyPlayer's messages, but isn't known here.
+func (h *Hub) hasPlayerConns(username string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.playerConns(username)) > 0
+}
+
+// Unregister a leaderboard connection, cancelling whatever is still being
+// done for it
+func (h *Hub) unregister(conn *websocket.Conn) {
+	h.mutex.Lock()
+	if h.clients[conn] {
+		h.clientCancel[conn]()
+		delete(h.clients, conn)
+		delete(h.clientUser, conn)
+		delete(h.clientCancel, conn)
+		delete(h.clientVersion, conn)
+		websocketConnections.Dec()
+	}
+	h.mutex.Unlock()
+}
+
+// The connections spectating the given player, created on first use.
+// Callers hold the mutex.
+func (h *Hub) spectatorConns(username string) map[*websocket.Conn]bool {
+	if h.spectators[username] == nil {
+		h.spectators[username] = make(map[*websocket.Conn]bool)
+	}
+	return h.spectators[username]
+}
+
+// Register a connection spectating the given player
+func (h *Hub) registerSpectator(username string, conn *websocket.Conn) {
+	h.mutex.Lock()
+	h.spectatorConns(username)[conn] = true
+	h.mutex.Unlock()
+	websocketConnections.Inc()
+}
+
+// Register a reconnecting spectator after replaying the events it missed
+func (h *Hub) resumeSpectator(username string, conn *websocket.Conn, lastSeq int64) (bool, error) {
+	return h.resume(username, lastSeq, conn, func() map[*websocket.Conn]bool { return h.spectatorConns(username) })
+}
+
+// Unregister a spectator connection
+func (h *Hub) unregisterSpectator(username string, conn *websocket.Conn) {
+	h.mutex.Lock()
+	if h.spectators[username][conn] {
+		delete(h.spectators[username], conn)
+		websocketConnections.Dec()
+	}
+	if len(h.spectators[username]) == 0 {
+		delete(h.spectators, username)
+	}
+	h.mutex.Unlock()
+}
+
+// The connections following a room, created on first use. Callers hold the
+// mutex.
+func (h *Hub) roomConns(code string) map[*websocket.Conn]bool {
+	if h.rooms[code] == nil {
+		h.rooms[code] = make(map[*websocket.Conn]bool)
+	}
+	return h.rooms[code]
+}
+
+// Register a connection following a room's events
+func (h *Hub) registerRoom(code string, conn *websocket.Conn) {
+	h.mutex.Lock()
+	h.roomConns(code)[conn] = true
+	h.mutex.Unlock()
+	websocketConnections.Inc()
+}
+
+// Register a reconnecting room socket after replaying the events it missed
+func (h *Hub) resumeRoom(code string, conn *websocket.Conn, lastSeq int64) (bool, error) {
+	return h.resume(roomGameIDPrefix+code, lastSeq, conn, func() map[*websocket.Conn]bool { return h.roomConns(code) })
+}
+
+// Unregister a room connection
+func (h *Hub) unregisterRoom(code string, conn *websocket.Conn) {
+	h.mutex.Lock()
+	if h.rooms[code][conn] {
+		delete(h.rooms[code], conn)
+		websocketConnections.Dec()
+	}
+	if len(h.rooms[code]) == 0 {
+		delete(h.rooms, code)
+	}
+	h.mutex.Unlock()
+}
+
+// Register a connection following a tournament's bracket
+func (h *Hub) registerTournament(id string, conn *websocket.Conn) {
+	h.mutex.Lock()
+	if h.tournaments[id] == nil {
+		h.tournaments[id] = make(map[*websocket.Conn]bool)
+	}
+	h.tournaments[id][conn] = true
+	h.mutex.Unlock()
+	websocketConnections.Inc()
+}
+
+// Unregister a tournament connection
+func (h *Hub) unregisterTournament(id string, conn *websocket.Conn) {
+	h.mutex.Lock()
+	if h.tournaments[id][conn] {
+		delete(h.tournaments[id], conn)
+		websocketConnections.Dec()
+	}
+	if len(h.tournaments[id]) == 0 {
+		delete(h.tournaments, id)
+	}
+	h.mutex.Unlock()
+}
+
+// Send a message to a single connection
+func (h *Hub) send(conn *websocket.Conn, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return h.pool.send(conn, payload)
+}
+
+// Close a connection once it is done, dropping the frames still queued for it
+func (h *Hub) close(conn *websocket.Conn) {
+	h.pool.remove(conn)
+	h.mutex.Lock()
+	delete(h.capabilities, conn)
+	h.mutex.Unlock()
+	conn.Close()
+}
+
+// Send a message to every leaderboard client
+func (h *Hub) broadcast(v interface{}) {
+	h.broadcastAfter(0, v)
+}
+
+func (h *Hub) broadcastAfter(delay time.Duration, v interface{}) {
+	h.dispatch(leaderboardChannel, delay, func() { h.publish(leaderboardChannel, "", v) })
+}
+
+// Send an event to everyone spectating the given player. Events are numbered
+// per player so a spectator can catch up after reconnecting.
+func (h *Hub) notifySpectators(username string, event SpectatorEvent) {
+	h.notifySpectatorsAfter(0, username, event)
+}
+
+// Numbers are given out when the event is sent, so they follow its position
+// in the stream
+func (h *Hub) notifySpectatorsAfter(delay time.Duration, username string, event SpectatorEvent) {
+	h.dispatch(userChannel(username), delay, func() {
+		event.Seq = h.nextSeq(username)
+		h.publish(userChannel(username), username, event)
+	})
+}
+
+// Send a message to the player's sockets; see playerConns
+func (h *Hub) notifyPlayer(username string, v interface{}) {
+	h.publish(playerChannel(username), "", v)
+}
+
+// Send an event to every socket following the room. Events are numbered per
+// room so a socket can catch up after reconnecting.
+func (h *Hub) broadcastRoom(code string, event RoomEvent) {
+	h.broadcastRoomAfter(0, code, event)
+}
+
+func (h *Hub) broadcastRoomAfter(delay time.Duration, code string, event RoomEvent) {
+	stream := roomGameIDPrefix + code
+	h.dispatch(roomChannel(code), delay, func() {
+		event.Seq = h.nextSeq(stream)
+		h.publish(roomChannel(code), stream, event)
+	})
+}
+
+// Send a message to every socket following the tournament. Each one carries
+// the whole bracket, so there is nothing to replay.
+func (h *Hub) broadcastTournament(id string, v interface{}) {
+	h.publish(tournamentChannel(id), "", v)
+}
+
+// Publish a message on the bus so every instance delivers it, keeping it in
+// the stream's history first if it has one. If the bus is unreachable, at
+// least this instance's connections get it.
+func (h *Hub) publish(channel, stream string, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding message for %s: %v", channel, err)
+		return
+	}
+	if stream != "" && h.history != nil {
+		h.record(stream, payload)
+	}
+	if err := h.bus.Publish(context.Background(), channel, payload); err != nil {
+		log.Printf("Error publishing to %s, delivering locally: %v", channel, err)
+		h.deliver(channel, payload)
+	}
+}
+
+// Queue a message from the bus for this instance's connections following
+// its channel. Leaderboard clients get what changed since the standings they
+// hold, limited to the top rows and their own, or a snapshot if they never
+// asked for deltas. Events a socket has no capability for are left out.
+func (h *Hub) deliver(channel string, payload []byte) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var conns map[*websocket.Conn]bool
+	var leaderboard *leaderboardFrames
+	switch {
+	case channel == leaderboardChannel:
+		conns = h.clients
+		if message, entries, ok := parseLeaderboardMessage(payload); ok {
+			if leaderboard = h.nextStandings(message.Window, entries); leaderboard == nil {
+				return
+			}
+		}
+	case strings.HasPrefix(channel, userChannelPrefix):
+		conns = h.spectators[strings.TrimPrefix(channel, userChannelPrefix)]
+	case strings.HasPrefix(channel, roomChannelPrefix):
+		conns = h.rooms[strings.TrimPrefix(channel, roomChannelPrefix)]
+	case strings.HasPrefix(channel, playerChannelPrefix):
+		conns = h.playerConns(strings.TrimPrefix(channel, playerChannelPrefix))
+	case strings.HasPrefix(channel, tournamentChannelPrefix):
+		conns = h.tournaments[strings.TrimPrefix(channel, tournamentChannelPrefix)]
+	}
+
+	var required string
+	if leaderboard == nil && len(conns) > 0 {
+		required = requiredCapability(payload)
+	}
+	for conn := range conns {
+		if required != "" && !h.can(conn, required) {
+			continue
+		}
+		frame := payload
+		if leaderboard != nil {
+			var err error
+			if h.can(conn, CapLeaderboardDelta) {
+				frame, err = leaderboard.frame(h.clientUser[conn], h.clientVersion[conn])
+			} else {
+				frame, err = leaderboard.snapshot(h.clientUser[conn])
+			}
+			if err != nil {
+				log.Printf("Error encoding leaderboard for a client: %v", err)
+				continue
+			}
+		}
+		// A closed connection is unregistered by its reader; a backed-up one
+		// misses the frame, and a leaderboard client gets a snapshot next
+		if err := h.pool.send(conn, frame); err != nil {
+			continue
+		}
+		if leaderboard != nil {
+			h.clientVersion[conn] = leaderboard.version
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long a response is kept for replay to retried requests, unless
+// IDEMPOTENCY_TTL says otherwise
+const defaultIdempotencyTTL = 5 * time.Minute
+
+const maxIdempotencyKeyLength = 128
+
+// A response saved under an idempotency key
+type idempotentResponse struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// The client's idempotency key: the Idempotency-Key header, or the requestId
+// field of the body
+func requestIdempotencyKey(c *gin.Context, user User) string {
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		return key
+	}
+	return user.RequestID
+}
+
+// Run the handler at most once per idempotency key. The first request claims
+// the key and saves its response; duplicates get the saved response back
+// byte for byte. Store failures release the key so the client can retry.
+func (s *Server) respondIdempotent(c *gin.Context, gameID, key string, handle func() (interface{}, *APIError)) {
+	ctx := c.Request.Context()
+
+	if key == "" {
+		response, apiErr := handle()
+		if apiErr != nil {
+			abortWithError(c, apiErr)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		abortWithError(c, errInvalidRequest("Idempotency key is too long"))
+		return
+	}
+
+	claimed, err := s.store.ClaimIdempotencyKey(ctx, gameID, key, s.idempotencyTTL)
+	if err != nil {
+		log.Printf("Error claiming idempotency key for game %s: %v", gameID, err)
+		abortWithError(c, errStoreUnavailable("Error checking idempotency key"))
+		return
+	}
+
+	if !claimed {
+		saved, err := s.store.GetIdempotentResponse(ctx, gameID, key)
+		if err != nil {
+			log.Printf("Error retrieving idempotent response for game %s: %v", gameID, err)
+			abortWithError(c, errStoreUnavailable("Error checking idempotency key"))
+			return
+		}
+		if saved == nil {
+			abortWithError(c, errRequestInFlight())
+			return
+		}
+
+		var replay idempotentResponse
+		if err := json.Unmarshal(saved, &replay); err != nil {
+			log.Printf("Error decoding idempotent response for game %s: %v", gameID, err)
+			abortWithError(c, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "Internal server error"))
+			return
+		}
+
+		log.Printf("Replaying response for idempotency key %s in game %s", key, gameID)
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(replay.Status, gin.MIMEJSON+"; charset=utf-8", replay.Body)
+		return
+	}
+
+	response, apiErr := handle()
+
+	// Nothing happened to the game, so let the retry run for real
+	if apiErr != nil && apiErr.HTTPStatus >= http.StatusInternalServerError {
+		if err := s.store.ReleaseIdempotencyKey(ctx, gameID, key); err != nil {
+			log.Printf("Error releasing idempotency key for game %s: %v", gameID, err)
+		}
+		abortWithError(c, apiErr)
+		return
+	}
+
+	replay := idempotentResponse{Status: http.StatusOK}
+	if apiErr != nil {
+		replay.Status = apiErr.HTTPStatus
+		replay.Body, err = json.Marshal(ErrorResponse{Error: apiErr})
+	} else {
+		replay.Body, err = json.Marshal(response)
+	}
+	if err != nil {
+		log.Printf("Error encoding response for game %s: %v", gameID, err)
+		abortWithError(c, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "Internal server error"))
+		return
+	}
+
+	saved, err := json.Marshal(replay)
+	if err == nil {
+		err = s.store.SaveIdempotentResponse(ctx, gameID, key, saved, s.idempotencyTTL)
+	}
+	if err != nil {
+		// The draw already happened; the client still gets its response
+		log.Printf("Error saving idempotent response for game %s: %v", gameID, err)
+	}
+
+	c.Data(replay.Status, gin.MIMEJSON+"; charset=utf-8", replay.Body)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+func TestDrawReplaysIdempotentRetry(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", "Skip", "Cat", engine.ExplodingKitten)
+
+		first := ts.post("/draw-card", User{Username: "alice"}, "Idempotency-Key", "k1")
+		retry := ts.post("/draw-card", User{Username: "alice"}, "Idempotency-Key", "k1")
+		if first.Code != http.StatusOK || retry.Code != http.StatusOK {
+			t.Fatalf("statuses %d and %d", first.Code, retry.Code)
+		}
+		if !bytes.Equal(first.Body.Bytes(), retry.Body.Bytes()) {
+			t.Fatalf("retry answered\n%s\nnot\n%s", retry.Body, first.Body)
+		}
+		if retry.Header().Get("Idempotent-Replayed") != "true" {
+			t.Fatal("retry not marked as replayed")
+		}
+		if got := len(ts.deck("alice")); got != 3 {
+			t.Fatalf("deck has %d cards after a retried draw, want 3", got)
+		}
+
+		// The body's requestId is a key too, and a new key draws again
+		byField := decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: "alice", RequestID: "k2"}))
+		if byField.Card.Type != "Skip" {
+			t.Fatalf("draw with a new key = %+v", byField)
+		}
+		if got := len(ts.deck("alice")); got != 2 {
+			t.Fatalf("deck has %d cards, want 2", got)
+		}
+	})
+}
+
+func TestConcurrentIdempotentDrawsDrawOnce(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", "Skip", "Cat", engine.ExplodingKitten)
+
+		codes := make([]int, 5)
+		var wg sync.WaitGroup
+		for i := range codes {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				codes[i] = ts.post("/draw-card", User{Username: "alice"}, "Idempotency-Key", "k1").Code
+			}(i)
+		}
+		wg.Wait()
+
+		for _, code := range codes {
+			// A duplicate arriving while the first is still drawing is told
+			// to retry
+			if code != http.StatusOK && code != http.StatusConflict {
+				t.Fatalf("statuses %v", codes)
+			}
+		}
+		if got := len(ts.deck("alice")); got != 3 {
+			t.Fatalf("deck has %d cards after %d identical draws, want 3", got, len(codes))
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"exploding-kitten/engine"
+)
+
+// Whether the solo game can only be lost from here; see
+// engine.Game.ForcedOutcome. A bomb the player is still deciding about
+// counts as left in the deck, since defusing it puts it back. In a room only
+// bombs left means someone loses, not that this player does, so rooms never
+// report it. A failed deck read only loses the indicator.
+func (s *Server) lossForced(ctx context.Context, game *GameSession, pendingBomb bool) bool {
+	if game.Room != nil {
+		return false
+	}
+	deck, err := s.store.GetDeck(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error retrieving deck for game %s: %v", game.ID, err)
+		return false
+	}
+	if pendingBomb {
+		deck = append(deck, engine.ExplodingKitten)
+	}
+	rules := &engine.Game{Deck: deck, Mode: game.Mode}
+	return rules.ForcedOutcome() == engine.StatusLost
+}
+
+// Whether to end a forced loss now rather than wait on the draw that loses
+// it: only with fastForwardInevitable, and only once the player has no
+// Defuse left, as each one would still let a survival run score another draw
+func (s *Server) shouldFastForward(game *GameSession, forced bool, defuses int) bool {
+	return s.fastForwardInevitable && forced && defuses == 0 && game.Room == nil && game.Mode == ModeSurvival
+}
+
+// End the run of a player left with only bombs and no Defuse, as if they had
+// drawn the next one, and tell them in the response to the move that left
+// them there
+func (s *Server) fastForwardLoss(ctx context.Context, game *GameSession, response *DrawCardResponse) *APIError {
+	logGameEvent(game.Username, game.ID, map[string]any{"event": "loss_fast_forwarded"})
+	over, apiErr := s.endSurvivalRun(ctx, game, response.Card, false)
+	if apiErr != nil {
+		return apiErr
+	}
+	response.MessageID = MsgLossForced
+	response.Message = strings.TrimSpace(response.Message + " " + localize(ctx, MsgLossForced, over.Score, over.BestScore))
+	response.GameStatus = over.GameStatus
+	response.Score, response.BestScore = over.Score, over.BestScore
+	response.Effects = append(response.Effects, DrawEffect{Type: EffectGameLost})
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+func TestOnlyBombsLeftIsAnInevitableLoss(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startSurvival("alice", "Cat", "Cat", engine.ExplodingKitten)
+		if drawn := decodeOK[DrawCardResponse](t, ts.draw("alice")); drawn.InevitableLoss {
+			t.Fatalf("draw with a Cat still in the deck = %+v", drawn)
+		}
+		drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if !drawn.InevitableLoss || drawn.GameStatus != GameStatusActive {
+			t.Fatalf("draw leaving only the bomb = %+v", drawn)
+		}
+		snapshot := decodeOK[GameSnapshot](t, ts.get("/game/alice/snapshot?username=alice"))
+		if !snapshot.InevitableLoss || snapshot.Status != GameStatusActive {
+			t.Fatalf("snapshot = %+v", snapshot)
+		}
+
+		// Without fast-forwarding it takes the draw of the bomb to lose
+		over := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if over.GameStatus != GameStatusLost || over.Score != 2 {
+			t.Fatalf("draw of the bomb = %+v", over)
+		}
+	})
+}
+
+func TestHeldDefuseStillIsAnInevitableLoss(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{"FAST_FORWARD_INEVITABLE": "true"}))
+		ts.startSurvival("alice", "Cat", engine.ExplodingKitten)
+		ts.deal("alice", engine.Defuse)
+
+		// The Defuse could still buy a draw, so the run goes on
+		drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if !drawn.InevitableLoss || drawn.GameStatus != GameStatusActive || drawn.DefuseCount != 1 {
+			t.Fatalf("draw leaving only the bomb = %+v", drawn)
+		}
+		drawn = decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if drawn.GameStatus != GameStatusPendingDefuse {
+			t.Fatalf("draw of the bomb = %+v", drawn)
+		}
+		// Deciding about the bomb it still counts as in the deck
+		if snapshot := decodeOK[GameSnapshot](t, ts.get("/game/alice/snapshot?username=alice")); !snapshot.InevitableLoss {
+			t.Fatalf("snapshot with the bomb pending = %+v", snapshot)
+		}
+
+		// Spending the last Defuse leaves nothing to play for
+		over := ts.resolveBomb("alice", true)
+		if !over.InevitableLoss || over.GameStatus != GameStatusLost || over.MessageID != MsgLossForced || over.DefuseCount != 0 {
+			t.Fatalf("defusing the last bomb = %+v", over)
+		}
+		assertError(t, ts.draw("alice"), http.StatusConflict, ErrCodeGameFinished)
+	})
+}
+
+func TestInevitableLossIsFastForwarded(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{"FAST_FORWARD_INEVITABLE": "true"}))
+		ts.startSurvival("alice", "Cat", "Cat", engine.ExplodingKitten)
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+
+		over := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if !over.InevitableLoss || over.GameStatus != GameStatusLost || over.MessageID != MsgLossForced || over.Card.Type != "Cat" {
+			t.Fatalf("draw leaving only the bomb = %+v", over)
+		}
+		if over.Score != 2 || over.BestScore != 2 || ts.survivalBest("alice") != 2 {
+			t.Fatalf("fast-forwarded run scored %d, best %d", over.Score, over.BestScore)
+		}
+		if deck := ts.deck("alice"); len(deck) != 1 || deck[0] != engine.ExplodingKitten {
+			t.Fatalf("deck after the fast-forward = %v", deck)
+		}
+		assertError(t, ts.draw("alice"), http.StatusConflict, ErrCodeGameFinished)
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Where an injected card goes into the deck. Players are only told that the
+// deck changed, never where.
+const (
+	InjectTop    = "top"
+	InjectBottom = "bottom"
+	InjectRandom = "random"
+)
+
+// Target of an injection reaching every room with a game in progress
+const injectAllRooms = "all"
+
+type InjectCardRequest struct {
+	// A card type from the registry
+	Card string `json:"card"`
+	// "all", or the code of one room
+	Target string `json:"target"`
+	// "top", "bottom" or "random"
+	Position string `json:"position"`
+}
+
+// A room an injection left alone, and why: "finished", "not_started" for a
+// room still waiting for players, "disabled" for a room playing without the
+// card, or "error" if its deck couldn't be updated
+type InjectionSkip struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// Admin card injection route
+type InjectCardResponse struct {
+	Card     string `json:"card"`
+	Position string `json:"position"`
+	// The rooms the card went into
+	Injected []string        `json:"injected"`
+	Skipped  []InjectionSkip `json:"skipped"`
+}
+
+// Admin card injection route: put a card into the decks of the targeted
+// rooms, for tournament organizers to shake up games being streamed. Each
+// room learns its deck changed through a "deck_changed" event.
+func (s *Server) adminInjectCard(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req InjectCardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	def, ok := lookupCard(req.Card)
+	if !ok {
+		abortWithError(c, errInvalidRequest(fmt.Sprintf("Unknown card %q", req.Card)))
+		return
+	}
+	if req.Position != InjectTop && req.Position != InjectBottom && req.Position != InjectRandom {
+		abortWithError(c, errInvalidRequest(`position must be "top", "bottom" or "random"`))
+		return
+	}
+
+	var rooms []*Room
+	switch req.Target {
+	case "":
+		abortWithError(c, errInvalidRequest(`target must be "all" or a room code`))
+		return
+	case injectAllRooms:
+		active, err := s.store.ActiveRooms(ctx)
+		if err != nil {
+			log.Printf("Error listing active rooms: %v", err)
+			abortWithError(c, errStoreUnavailable("Error listing rooms"))
+			return
+		}
+		rooms = active
+	default:
+		code := strings.ToUpper(req.Target)
+		room, err := s.store.GetRoom(ctx, code)
+		if err != nil {
+			log.Printf("Error retrieving room %s: %v", code, err)
+			abortWithError(c, errStoreUnavailable("Error retrieving room"))
+			return
+		}
+		if room == nil {
+			abortWithError(c, errRoomNotFound())
+			return
+		}
+		rooms = []*Room{room}
+	}
+
+	response := InjectCardResponse{Card: def.Type, Position: req.Position, Injected: []string{}, Skipped: []InjectionSkip{}}
+	for _, room := range rooms {
+		if room.cardDisabled(def.Type) {
+			response.Skipped = append(response.Skipped, InjectionSkip{Code: room.Code, Reason: "disabled"})
+			continue
+		}
+		size, injected, err := s.store.InjectCard(ctx, room.Code, def.Type, injectDepth(req.Position))
+		if err != nil {
+			// The rooms already done keep their card, so carry on
+			log.Printf("Error injecting %s into room %s: %v", def.Type, room.Code, err)
+			response.Skipped = append(response.Skipped, InjectionSkip{Code: room.Code, Reason: "error"})
+			continue
+		}
+		if !injected {
+			// Finished since it was listed, or never in progress
+			reason := "finished"
+			if room.Status == RoomWaiting {
+				reason = "not_started"
+			}
+			response.Skipped = append(response.Skipped, InjectionSkip{Code: room.Code, Reason: reason})
+			continue
+		}
+		response.Injected = append(response.Injected, room.Code)
+
+		game := &GameSession{ID: room.gameID(), Username: adminActor, Room: room}
+		s.recordMove(ctx, game, MoveInject, def.Type, GameStatusActive)
+		card := themedCard(room.theme(), def.Card)
+		s.hub.broadcastRoom(room.Code, RoomEvent{
+			Type:      "deck_changed",
+			Username:  adminActor,
+			Card:      &card,
+			Message:   fmt.Sprintf("A wild %s appears!", def.Type),
+			Remaining: size,
+		})
+	}
+
+	log.Printf("Admin injected %s (%s) into %d rooms, skipping %d", def.Type, req.Position, len(response.Injected), len(response.Skipped))
+	entry := s.adminAuditEntry(c, "inject_card", "")
+	entry.After = map[string]interface{}{"card": def.Type, "target": req.Target, "position": req.Position, "rooms": response.Injected}
+	s.audit(c, entry)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// How far down the deck a card injected at position goes, from 0 for the top
+// to 1 for the bottom
+func injectDepth(position string) float64 {
+	switch position {
+	case InjectTop:
+		return 0
+	case InjectBottom:
+		return 1
+	}
+	return rand.Float64()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// staleRoomsStore is a GameStore whose ActiveRooms also lists the rooms in
+// stale, as a listing taken just before they finished would
+type staleRoomsStore struct {
+	GameStore
+	stale []*Room
+}
+
+func (s *staleRoomsStore) ActiveRooms(ctx context.Context) ([]*Room, error) {
+	rooms, err := s.GameStore.ActiveRooms(ctx)
+	return append(rooms, s.stale...), err
+}
+
+func TestInjectCardIntoActiveRooms(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		stale := &staleRoomsStore{GameStore: store}
+		ts := newTestServerWith(t, stale, testConfig(t, map[string]string{"ADMIN_TOKEN": testAdminToken}))
+		first, second := ts.openRoom("alice", "bob"), ts.openRoom("carol", "dave")
+		finished := ts.openRoom("erin", "frank")
+		for _, room := range []*Room{first, second, finished} {
+			ts.setDeck(room.gameID(), "Cat", "Cat", "Cat")
+		}
+		listed, _ := ts.store.ActiveRooms(ctx)
+		decodeOK[ForfeitResponse](t, ts.post("/forfeit", User{Username: "erin", GameID: finished.gameID()}))
+		for _, room := range listed {
+			if room.Code == finished.Code {
+				stale.stale = []*Room{room}
+			}
+		}
+		socket := ts.dial("room=" + first.Code)
+		socket.next("snapshot")
+
+		injected := decodeOK[InjectCardResponse](t, ts.post("/admin/inject-card", InjectCardRequest{Card: engine.ExplodingKitten, Target: "all", Position: InjectTop}, asAdmin...))
+		sort.Strings(injected.Injected)
+		want := []string{first.Code, second.Code}
+		sort.Strings(want)
+		if !reflect.DeepEqual(injected.Injected, want) || !reflect.DeepEqual(injected.Skipped, []InjectionSkip{{Code: finished.Code, Reason: "finished"}}) {
+			t.Fatalf("injection = %+v", injected)
+		}
+		for _, room := range []*Room{first, second} {
+			if deck := ts.deck(room.gameID()); len(deck) != 4 || deck[0] != engine.ExplodingKitten {
+				t.Fatalf("deck of %s = %v", room.Code, deck)
+			}
+		}
+		if deck := ts.deck(finished.gameID()); countCards(deck)[engine.ExplodingKitten] != 0 {
+			t.Fatalf("deck of the finished room = %v", deck)
+		}
+
+		// The room hears its deck changed, but not where the card went
+		changed := socket.next("deck_changed")
+		if _, ok := changed["position"]; ok {
+			t.Fatalf("deck_changed gives the position away: %v", changed)
+		}
+		if event := decodeMessage[RoomEvent](t, changed); event.Card == nil || event.Card.Type != engine.ExplodingKitten || event.Remaining != 4 {
+			t.Fatalf("deck_changed = %+v", event)
+		}
+
+		// The move log and the audit stream both have it
+		moves, _ := ts.store.Moves(ctx, first.gameID())
+		if len(moves) == 0 {
+			t.Fatal("no moves logged")
+		}
+		var move Move
+		if err := json.Unmarshal(moves[len(moves)-1], &move); err != nil || move.Action != MoveInject || move.Actor != adminActor || move.Card != engine.ExplodingKitten {
+			t.Fatalf("last move = %+v", move)
+		}
+		entries := decodeOK[AdminAuditResponse](t, ts.get("/admin/audit", asAdmin...)).Entries
+		if last := entries[len(entries)-1]; last.Action != "inject_card" {
+			t.Fatalf("last audit entry = %+v", last)
+		}
+
+		// One room, at the bottom; a finished room named directly is skipped
+		one := decodeOK[InjectCardResponse](t, ts.post("/admin/inject-card", InjectCardRequest{Card: "Defuse", Target: second.Code, Position: InjectBottom}, asAdmin...))
+		if len(one.Injected) != 1 || len(one.Skipped) != 0 {
+			t.Fatalf("injection into %s = %+v", second.Code, one)
+		}
+		if deck := ts.deck(second.gameID()); len(deck) != 5 || deck[4] != engine.Defuse {
+			t.Fatalf("deck of %s = %v", second.Code, deck)
+		}
+		skipped := decodeOK[InjectCardResponse](t, ts.post("/admin/inject-card", InjectCardRequest{Card: "Cat", Target: finished.Code, Position: InjectRandom}, asAdmin...))
+		if len(skipped.Injected) != 0 || len(skipped.Skipped) != 1 || skipped.Skipped[0].Reason != "finished" {
+			t.Fatalf("injection into the finished room = %+v", skipped)
+		}
+
+		assertError(t, ts.post("/admin/inject-card", InjectCardRequest{Card: "Joker", Target: "all", Position: InjectTop}, asAdmin...), http.StatusBadRequest, ErrCodeInvalidRequest)
+		assertError(t, ts.post("/admin/inject-card", InjectCardRequest{Card: "Cat", Target: "all", Position: "middle"}, asAdmin...), http.StatusBadRequest, ErrCodeInvalidRequest)
+	})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long an invite is valid when the request doesn't say, and the longest
+// it may be. Revocations are kept for maxInviteTTL, after which every invite
+// they could cancel has expired anyway.
+const (
+	defaultInviteTTL = 15 * time.Minute
+	maxInviteTTL     = 24 * time.Hour
+)
+
+type InviteRequest struct {
+	Username string `json:"username"`
+	// Seconds the invite is valid for; defaults to 15 minutes
+	TTL int `json:"ttl"`
+}
+
+// What an invite token says, once its signature checks out
+type invite struct {
+	Code      string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// A fresh random key for signing invites, for when INVITE_SECRET isn't set
+func newInviteSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("Error generating invite secret: %v", err)
+	}
+	return secret
+}
+
+// Encode and sign an invite as payload.signature, both base64url. The payload
+// is code.expiry.issued, in unix seconds and ms.
+func (s *Server) signInvite(inv invite) string {
+	payload := inv.Code + "." + strconv.FormatInt(inv.ExpiresAt.Unix(), 10) + "." + strconv.FormatInt(inv.IssuedAt.UnixMilli(), 10)
+	mac := hmac.New(sha256.New, s.inviteSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Check an invite token's signature and expiry and return what it says
+func (s *Server) parseInvite(token string) (*invite, *APIError) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errInviteInvalid()
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInviteInvalid()
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, errInviteInvalid()
+	}
+	mac := hmac.New(sha256.New, s.inviteSecret)
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, errInviteInvalid()
+	}
+
+	parts := strings.Split(string(payload), ".")
+	if len(parts) != 3 {
+		return nil, errInviteInvalid()
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, errInviteInvalid()
+	}
+	issued, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, errInviteInvalid()
+	}
+	inv := &invite{Code: parts[0], IssuedAt: time.UnixMilli(issued), ExpiresAt: time.Unix(expires, 0)}
+	if !s.clock.Now().Before(inv.ExpiresAt) {
+		return nil, errInviteExpired()
+	}
+	return inv, nil
+}
+
+// The room code an invite token admits to, unless it is invalid, expired or
+// revoked along with the room's other invites
+func (s *Server) redeemInvite(ctx context.Context, token string) (string, *APIError) {
+	inv, apiErr := s.parseInvite(token)
+	if apiErr != nil {
+		return "", apiErr
+	}
+	revokedAt, err := s.store.InvitesRevokedAt(ctx, inv.Code)
+	if err != nil {
+		log.Printf("Error checking invites of room %s: %v", inv.Code, err)
+		return "", errStoreUnavailable("Error checking invite")
+	}
+	if !inv.IssuedAt.After(revokedAt) {
+		return "", errInviteRevoked()
+	}
+	return inv.Code, nil
+}
+
+// Whether a join request's code is an invite token rather than a room code,
+// which never contains a '.'
+func isInviteToken(code string) bool {
+	return strings.Contains(code, ".")
+}
+
+// Invite route: a signed link token for a player of the room to share
+func (s *Server) createInvite(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req InviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+	ttl := defaultInviteTTL
+	if req.TTL != 0 {
+		ttl = time.Duration(req.TTL) * time.Second
+	}
+	if ttl < time.Minute || ttl > maxInviteTTL {
+		abortWithError(c, errInvalidRequest(fmt.Sprintf("ttl must be between 60 and %d seconds", int(maxInviteTTL.Seconds()))))
+		return
+	}
+
+	room, apiErr := s.inviteRoom(ctx, c.Param("code"), req.Username)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	now := s.clock.Now()
+	inv := invite{Code: room.Code, IssuedAt: now, ExpiresAt: now.Add(ttl)}
+
+	log.Printf("User %s created an invite to room %s", req.Username, room.Code)
+	c.JSON(http.StatusOK, InviteResponse{Code: room.Code, Token: s.signInvite(inv), ExpiresAt: inv.ExpiresAt.UTC()})
+}
+
+// Revoke invites route: the room's owner cancels every invite issued so far
+func (s *Server) revokeInvites(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req InviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+	room, apiErr := s.inviteRoom(ctx, c.Param("code"), req.Username)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if room.Players[0] != req.Username {
+		abortWithError(c, errNotRoomOwner())
+		return
+	}
+
+	if apiErr := s.revokeRoomInvites(ctx, room.Code); apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	log.Printf("User %s revoked the invites to room %s", req.Username, room.Code)
+	c.JSON(http.StatusOK, RevokeInvitesResponse{Code: room.Code, Message: "Invites revoked"})
+}
+
+// Admin revoke invites route
+func (s *Server) adminRevokeInvites(c *gin.Context) {
+	ctx := c.Request.Context()
+	code := strings.ToUpper(c.Param("code"))
+
+	room, err := s.store.GetRoom(ctx, code)
+	if err != nil {
+		log.Printf("Error retrieving room %s: %v", code, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving room"))
+		return
+	}
+	if room == nil {
+		abortWithError(c, errRoomNotFound())
+		return
+	}
+	if apiErr := s.revokeRoomInvites(ctx, code); apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	entry := s.adminAuditEntry(c, "revoke_invites", "")
+	entry.After = code
+	s.audit(c, entry)
+	c.JSON(http.StatusOK, RevokeInvitesResponse{Code: code, Message: "Invites revoked"})
+}
+
+func (s *Server) revokeRoomInvites(ctx context.Context, code string) *APIError {
+	if err := s.store.RevokeInvites(ctx, code, s.clock.Now(), maxInviteTTL); err != nil {
+		log.Printf("Error revoking invites of room %s: %v", code, err)
+		return errStoreUnavailable("Error revoking invites")
+	}
+	return nil
+}
+
+// The room of an invite route, which only its players may use
+func (s *Server) inviteRoom(ctx context.Context, code, username string) (*Room, *APIError) {
+	code = strings.ToUpper(code)
+	room, err := s.store.GetRoom(ctx, code)
+	if err != nil {
+		log.Printf("Error retrieving room %s: %v", code, err)
+		return nil, errStoreUnavailable("Error retrieving room")
+	}
+	if room == nil {
+		return nil, errRoomNotFound()
+	}
+	if !room.hasPlayer(username) {
+		return nil, errNotInRoom()
+	}
+	return room, nil
+}
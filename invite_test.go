@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A room of size players with only alice in it, and an invite to it from her
+func (ts *testServer) inviteRoom(size int) (string, string) {
+	ts.t.Helper()
+	created := decodeOK[RoomResponse](ts.t, ts.post("/create-room", CreateRoomRequest{Username: "alice", Size: size}))
+	return created.Code, ts.invite(created.Code, "alice")
+}
+
+func (ts *testServer) invite(code, username string) string {
+	ts.t.Helper()
+	return decodeOK[InviteResponse](ts.t, ts.post("/rooms/"+code+"/invite", InviteRequest{Username: username})).Token
+}
+
+func (ts *testServer) joinWith(username, token string) *httptest.ResponseRecorder {
+	return ts.post("/join-room", RoomRequest{Username: username, Code: token})
+}
+
+func TestInviteJoinsUntilExpiry(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		code, token := ts.inviteRoom(3)
+		if joined := decodeOK[RoomResponse](t, ts.joinWith("bob", token)); joined.Room == nil || joined.Room.Code != code {
+			t.Fatalf("joined %+v with an invite to %q", joined.Room, code)
+		}
+
+		// Still good a second before it expires, until the room fills up
+		ts.clock.Advance(defaultInviteTTL - time.Second)
+		decodeOK[RoomResponse](t, ts.joinWith("carol", token))
+		if players := ts.room(code).Players; len(players) != 3 {
+			t.Fatalf("players = %v", players)
+		}
+		assertError(t, ts.joinWith("dave", token), http.StatusConflict, ErrCodeRoomFull)
+
+		ts.clock.Advance(time.Second)
+		assertError(t, ts.joinWith("dave", token), http.StatusGone, ErrCodeInviteExpired)
+	})
+}
+
+func TestTamperedInvitesAreRejected(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		_, token := ts.inviteRoom(2)
+		other, _ := ts.inviteRoom(2)
+		payload, signature, _ := strings.Cut(token, ".")
+		decoded, _ := base64.RawURLEncoding.DecodeString(payload)
+		_, rest, _ := strings.Cut(string(decoded), ".")
+		flipped := "A"
+		if signature[0] == 'A' {
+			flipped = "B"
+		}
+
+		// Someone else's server signs with its own secret
+		forger := newTestServer(t, newMemoryStore())
+		forged := forger.signInvite(invite{Code: other, IssuedAt: testEpoch, ExpiresAt: testEpoch.Add(time.Hour)})
+
+		for name, tampered := range map[string]string{
+			"another room":        base64.RawURLEncoding.EncodeToString([]byte(other+"."+rest)) + "." + signature,
+			"a later expiry":      base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(decoded), ".", ".9", 1))) + "." + signature,
+			"a changed signature": payload + "." + flipped + signature[1:],
+			"no signature":        payload + ".",
+			"not base64":          "!!!.???",
+			"another secret":      forged,
+		} {
+			t.Run(name, func(t *testing.T) {
+				assertError(t, ts.joinWith("bob", tampered), http.StatusBadRequest, ErrCodeInviteInvalid)
+			})
+		}
+		decodeOK[RoomResponse](t, ts.joinWith("bob", token))
+	})
+}
+
+func TestRevokedInvitesAreRejected(t *testing.T) {
+	eachAdminStore(t, func(t *testing.T, ts *testServer) {
+		code, token := ts.inviteRoom(4)
+		decodeOK[RoomResponse](t, ts.joinWith("bob", token))
+
+		// Only the owner revokes, and only players invite
+		assertError(t, ts.request(http.MethodDelete, "/rooms/"+code+"/invite", InviteRequest{Username: "bob"}), http.StatusForbidden, ErrCodeNotRoomOwner)
+		assertError(t, ts.post("/rooms/"+code+"/invite", InviteRequest{Username: "mallory"}), http.StatusForbidden, ErrCodeNotInRoom)
+
+		byBob := ts.invite(code, "bob")
+		ts.clock.Advance(time.Second)
+		decodeOK[RevokeInvitesResponse](t, ts.request(http.MethodDelete, "/rooms/"+code+"/invite", InviteRequest{Username: "alice"}))
+		// Every invite issued before, whoever issued it
+		assertError(t, ts.joinWith("carol", token), http.StatusGone, ErrCodeInviteRevoked)
+		assertError(t, ts.joinWith("carol", byBob), http.StatusGone, ErrCodeInviteRevoked)
+
+		// An invite issued since is good until an admin revokes it too
+		ts.clock.Advance(time.Second)
+		fresh := ts.invite(code, "alice")
+		decodeOK[RoomResponse](t, ts.joinWith("carol", fresh))
+		ts.clock.Advance(time.Second)
+		decodeOK[RevokeInvitesResponse](t, ts.request(http.MethodDelete, "/admin/rooms/"+code+"/invites", nil, asAdmin...))
+		assertError(t, ts.joinWith("dave", fresh), http.StatusGone, ErrCodeInviteRevoked)
+		if players := ts.room(code).Players; len(players) != 3 {
+			t.Fatalf("players = %v", players)
+		}
+	})
+}
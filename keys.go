@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// A KEY_PREFIX may only hold characters that mean nothing to SCAN's MATCH
+var keyPrefixPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]*$`)
+
+// Builds the stores' keys. The Redis store puts KEY_PREFIX in front of every
+// key it touches, so deployments sharing one Redis never see each other's
+// data; the memory store's builder has no prefix.
+//
+// Against a Redis Cluster, what a key belongs to is wrapped in a hash tag,
+// e.g. "deck:{alice}", so the keys a script or transaction touches together
+// hash to one slot: a user's keys and their solo game's share the
+// username's, a room's and its game's share the room code's, and the win,
+// lose and audit keys share "{stats}".
+type keyBuilder struct {
+	prefix  string
+	cluster bool
+}
+
+// The key called name, under the prefix
+func (k keyBuilder) key(name string) string { return k.prefix + name }
+
+// The name of a prefixed key, as SCAN returns it
+func (k keyBuilder) name(key string) string { return strings.TrimPrefix(key, k.prefix) }
+
+// id as the hash tag of the keys it owns, when running against a cluster
+func (k keyBuilder) tag(id string) string {
+	if !k.cluster {
+		return id
+	}
+	return "{" + id + "}"
+}
+
+// The game's part of its keys: a room's game is tagged by its room code
+func (k keyBuilder) gameTag(gameID string) string {
+	if code, ok := roomCodeFromGameID(gameID); ok {
+		return roomGameIDPrefix + k.tag(code)
+	}
+	return k.tag(gameID)
+}
+
+// The ID in the rest of a scanned key after its kind, without its hash tag.
+// No username, room code or game ID holds a brace.
+func (k keyBuilder) id(key, kind string) string {
+	return strings.NewReplacer("{", "", "}", "").Replace(strings.TrimPrefix(key, k.key(kind)))
+}
+
+func (k keyBuilder) deck(gameID string) string    { return k.key("deck:" + k.gameTag(gameID)) }
+func (k keyBuilder) game(gameID string) string    { return k.key("game:" + k.gameTag(gameID)) }
+func (k keyBuilder) user(username string) string  { return k.key("user:" + k.tag(username)) }
+func (k keyBuilder) hand(username string) string  { return k.key("hand:" + k.tag(username)) }
+func (k keyBuilder) room(code string) string      { return k.key("room:" + k.tag(code)) }
+func (k keyBuilder) roomState(code string) string { return k.key("room:" + k.tag(code) + ":state") }
+func (k keyBuilder) chat(code string) string      { return k.key("room:" + k.tag(code) + ":chat") }
+func (k keyBuilder) idempotency(gameID, key string) string {
+	return k.key("idem:" + k.gameTag(gameID) + ":" + key)
+}
+func (k keyBuilder) pendingDraw(gameID string) string { return k.key("pending:" + k.gameTag(gameID)) }
+func (k keyBuilder) events(stream string) string      { return k.key("events:" + k.gameTag(stream)) }
+func (k keyBuilder) moves(gameID string) string       { return k.key("game:" + k.gameTag(gameID) + ":moves") }
+func (k keyBuilder) achievements(username string) string {
+	return k.key("achievements:" + k.tag(username))
+}
+func (k keyBuilder) session(token string) string     { return k.key("session:" + token) }
+func (k keyBuilder) finishes(username string) string { return k.key("finishes:" + k.tag(username)) }
+func (k keyBuilder) profile(username string) string  { return k.key("profile:" + k.tag(username)) }
+func (k keyBuilder) tournament(id string) string     { return k.key("tournament:" + id) }
+
+// The counts of the games completed on a UTC day, "2006-01-02"
+func (k keyBuilder) daily(day string) string { return k.key("agg:" + day) }
+
+// The player's lock on the game. A solo game is the player's alone; in a
+// room each player locks their own seat.
+func (k keyBuilder) gameLock(gameID, username string) string {
+	if gameID == username {
+		return k.key("lock:" + k.tag(gameID))
+	}
+	return k.key("lock:" + k.gameTag(gameID) + ":" + username)
+}
+
+// The head-to-head record of two players, the same key whichever is named
+// first. It has the slot of the first in order.
+func (k keyBuilder) headToHead(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return k.key("h2h:" + k.tag(a) + ":" + b)
+}
+
+// The record of a game completed on a cluster whose writes outside the
+// game's slot are still to be made; see completeGameInSlots
+func (k keyBuilder) completion(gameID, id string) string {
+	return k.key("completion:" + k.gameTag(gameID) + ":" + id)
+}
+
+// Marks the write of the completion id to key as made, in key's slot
+func (k keyBuilder) applied(key, id string) string {
+	return k.key("applied:{" + hashTag(key) + "}:" + id)
+}
+
+// The part of key a cluster hashes: its hash tag if it has one
+func hashTag(key string) string {
+	if open := strings.IndexByte(key, '{'); open >= 0 {
+		if end := strings.IndexByte(key[open+1:], '}'); end > 0 {
+			return key[open+1 : open+1+end]
+		}
+	}
+	return key
+}
+
+// Held while a request creates the user's solo game
+func (k keyBuilder) creating(username string) string { return k.key("creating:" + k.tag(username)) }
+
+// The games the user is playing, as GameStore.ClaimGameSlot counts them
+func (k keyBuilder) activeGames(username string) string { return k.key("active:" + k.tag(username)) }
+
+// How many games the user started on a UTC day, "2006-01-02"
+func (k keyBuilder) gamesStarted(username, day string) string {
+	return k.key("started:" + k.tag(username) + ":" + day)
+}
+func (k keyBuilder) window(bucket string, isWin bool) string {
+	if isWin {
+		return k.key("leaderboard:" + bucket)
+	}
+	return k.key("leaderboard:" + bucket + ":lose")
+}
+
+func (k keyBuilder) win() string            { return k.key(winKey + k.stats()) }
+func (k keyBuilder) lose() string           { return k.key(loseKey + k.stats()) }
+func (k keyBuilder) audit() string          { return k.key(auditKey + k.stats()) }
+func (k keyBuilder) guests() string         { return k.key(guestsKey) }
+func (k keyBuilder) flagged() string        { return k.key(flaggedKey) }
+func (k keyBuilder) seeded() string         { return k.key(seededKey) }
+func (k keyBuilder) apiKeys() string        { return k.key(apiKeysKey) }
+func (k keyBuilder) online() string         { return k.key(onlineKey) }
+func (k keyBuilder) matchQueue() string     { return k.key(matchQueueKey) }
+func (k keyBuilder) survival() string       { return k.key(survivalKey) }
+func (k keyBuilder) revokedInvites() string { return k.key(revokedInvitesKey) }
+func (k keyBuilder) outbox() string         { return k.key(outboxKey) }
+
+// The hash tag of the win, lose and audit keys, which setStatsScript and
+// recordResultsScript touch together
+func (k keyBuilder) stats() string {
+	if !k.cluster {
+		return ""
+	}
+	return ":{stats}"
+}
+
+// Every key holding state of the user, including their solo game
+func (k keyBuilder) userKeys(username string) []string {
+	return []string{
+		k.user(username), k.hand(username), k.deck(username), k.game(username),
+		k.achievements(username), k.events(username), k.moves(username),
+		k.finishes(username), k.profile(username), k.gameLock(username, username),
+		k.activeGames(username), k.pendingDraw(username),
+	}
+}
+
+// How the names of the store's keys start; anything else in Redis isn't ours
+var storeKeyPrefixes = []string{
+	"deck:", "game:", "user:", "hand:", "room:", "idem:", "events:",
+	"achievements:", "leaderboard:", "session:", "invites:", "finishes:",
+	"profile:", "lock:", "active:", "started:", "tournament:",
+	"pending:", "agg:", "creating:", "h2h:",
+}
+
+// Whether an unprefixed key is one the store would have written
+func isStoreKey(key string) bool {
+	switch key {
+	case winKey, loseKey, auditKey, guestsKey, flaggedKey, seededKey, apiKeysKey, onlineKey, matchQueueKey, outboxKey:
+		return true
+	}
+	for _, prefix := range storeKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Move the store's unprefixed keys under KEY_PREFIX, for a deployment that
+// ran without one. A key whose prefixed name is already taken is left where
+// it is. Returns how many keys were moved and how many were left.
+func (s *redisStore) MigrateKeyPrefix(ctx context.Context) (int, int, error) {
+	var moved, left int
+	iter := s.rdb.Scan(ctx, 0, "*", 1000).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		// Keys moved during the scan may come round again
+		if strings.HasPrefix(key, s.keys.prefix) || !isStoreKey(key) {
+			continue
+		}
+		renamed, err := s.rdb.RenameNX(ctx, key, s.keys.key(key)).Result()
+		if isNoSuchKey(err) {
+			// Expired or swept since the scan
+			continue
+		}
+		if err != nil {
+			return moved, left, err
+		}
+		if !renamed {
+			log.Printf("Not moving %s: %s already exists", key, s.keys.key(key))
+			left++
+			continue
+		}
+		moved++
+	}
+	return moved, left, iter.Err()
+}
+
+// The -migrate-prefix run: move the keys, report and exit
+func migrateKeyPrefix(ctx context.Context, store *redisStore) {
+	if store.keys.prefix == "" {
+		log.Fatal("-migrate-prefix needs KEY_PREFIX to be set")
+	}
+	if store.keys.cluster {
+		// It scans a single node, and moves keys that predate hash tags
+		log.Fatal("-migrate-prefix can't run against a Redis Cluster")
+	}
+	moved, left, err := store.MigrateKeyPrefix(ctx)
+	if err != nil {
+		log.Fatalf("Error migrating keys to prefix %q after moving %d: %v", store.keys.prefix, moved, err)
+	}
+	log.Printf("Moved %d keys under prefix %q; %d were left because the prefixed key exists", moved, store.keys.prefix, left)
+}
+
+func isNoSuchKey(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "no such key")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"exploding-kitten/engine"
+)
+
+// A redisStore under each prefix, all on one miniredis
+func sharedRedisStores(t *testing.T, prefixes ...string) (*miniredis.Miniredis, []*redisStore) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	var stores []*redisStore
+	for _, prefix := range prefixes {
+		rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { rdb.Close() })
+		store := newRedisStore(rdb)
+		store.keys = keyBuilder{prefix: prefix}
+		stores = append(stores, store)
+	}
+	return server, stores
+}
+
+// Play the same games on a server as any other deployment would: alice
+// loses a solo game and sits in a room with bob. Returns the room's code.
+func playDeployment(ts *testServer) string {
+	ts.t.Helper()
+	ts.startGame("alice", engine.ExplodingKitten, "Cat")
+	decodeOK[DrawCardResponse](ts.t, ts.draw("alice"))
+	return ts.openRoom("alice", "bob").Code
+}
+
+// The names on the server's leaderboard, in order
+func leaderboardNames(ts *testServer) []string {
+	ts.t.Helper()
+	var names []string
+	for _, entry := range decodeOK[LeaderboardResponse](ts.t, ts.get("/leaderboard")).Leaderboard {
+		names = append(names, entry.Username)
+	}
+	return names
+}
+
+// The keys on server under prefix, with it taken off and the room code
+// written as CODE
+func keysUnder(server *miniredis.Miniredis, prefix, code string) []string {
+	var names []string
+	for _, key := range server.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			names = append(names, strings.ReplaceAll(strings.TrimPrefix(key, prefix), code, "CODE"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestKeyPrefixesIsolateDeployments(t *testing.T) {
+	server, stores := sharedRedisStores(t, "staging:", "prod:")
+	staging, prod := newTestServer(t, stores[0]), newTestServer(t, stores[1])
+
+	stagingRoom, prodRoom := playDeployment(staging), playDeployment(prod)
+	// Only staging has carol
+	staging.store.SetStats(context.Background(), "carol", 5, 0, AuditEntry{})
+
+	for _, key := range server.Keys() {
+		if !strings.HasPrefix(key, "staging:") && !strings.HasPrefix(key, "prod:") {
+			t.Fatalf("key %q is under neither prefix", key)
+		}
+	}
+	for name, ts := range map[string]*testServer{"staging": staging, "prod": prod} {
+		if win, lose := ts.stats("alice"); win != 0 || lose != 1 {
+			t.Fatalf("%s has alice at %d/%d, want the one loss played there", name, win, lose)
+		}
+	}
+	if names := leaderboardNames(prod); len(names) != 1 || names[0] != "alice" {
+		t.Fatalf("prod's leaderboard = %v, want only alice", names)
+	}
+	if names := leaderboardNames(staging); len(names) != 2 || names[0] != "carol" {
+		t.Fatalf("staging's leaderboard = %v, want carol and alice", names)
+	}
+
+	// The same games leave the same keys, bar carol's and the room codes
+	stagingKeys, prodKeys := keysUnder(server, "staging:", stagingRoom), keysUnder(server, "prod:", prodRoom)
+	if len(prodKeys) == 0 || len(stagingKeys) != len(prodKeys) {
+		t.Fatalf("staging has keys %v, prod %v", stagingKeys, prodKeys)
+	}
+	for i := range prodKeys {
+		if stagingKeys[i] != prodKeys[i] {
+			t.Fatalf("staging has keys %v, prod %v", stagingKeys, prodKeys)
+		}
+	}
+
+	// A game going on in one deployment is none of the other's business
+	staging.startGame("dave", "Cat", "Cat")
+	if deck := prod.deck("dave"); len(deck) != 0 {
+		t.Fatalf("prod sees staging's deck for dave: %v", deck)
+	}
+}
+
+func TestMigrateKeyPrefixMovesStoreKeys(t *testing.T) {
+	ctx := context.Background()
+	server, stores := sharedRedisStores(t, "", "prod:")
+	code := playDeployment(newTestServer(t, stores[0]))
+	before := keysUnder(server, "", code)
+	server.Set("someone-elses", "value")
+	// Already written under the prefix, so left alone
+	server.Set("prod:seeded", "newer")
+	server.Set("seeded", "older")
+
+	moved, left, err := stores[1].MigrateKeyPrefix(ctx)
+	if err != nil {
+		t.Fatalf("MigrateKeyPrefix: %v", err)
+	}
+	if moved != len(before) || left != 1 {
+		t.Fatalf("moved %d and left %d, want %d and 1", moved, left, len(before))
+	}
+	for _, key := range server.Keys() {
+		if !strings.HasPrefix(key, "prod:") && key != "someone-elses" && key != "seeded" {
+			t.Fatalf("key %q wasn't moved", key)
+		}
+	}
+	if value, _ := server.Get("prod:seeded"); value != "newer" {
+		t.Fatalf("prod:seeded = %q, want the prefixed value kept", value)
+	}
+
+	// The prefixed deployment picks up where the unprefixed one left off
+	prod := newTestServer(t, stores[1])
+	if win, lose := prod.stats("alice"); win != 0 || lose != 1 {
+		t.Fatalf("alice's stats after the move = %d/%d", win, lose)
+	}
+	if names := leaderboardNames(prod); len(names) != 1 || names[0] != "alice" {
+		t.Fatalf("leaderboard after the move = %v", names)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// One player's row on the leaderboard
+type LeaderboardEntry struct {
+	Rank       int     `json:"rank"`
+	Username   string  `json:"username"`
+	Win        int64   `json:"win"`
+	Lose       int64   `json:"lose"`
+	TotalGames int64   `json:"totalGames"`
+	WinRate    float64 `json:"winRate"`
+	// From the player's profile; see Profile
+	DisplayName string `json:"displayName"`
+	AvatarEmoji string `json:"avatarEmoji"`
+}
+
+// Rows GET /leaderboard returns unless ?limit= says otherwise
+const (
+	defaultLeaderboardPage = 100
+	maxLeaderboardPage     = 1000
+)
+
+// Leaderboard sort keys
+const (
+	SortByWins    = "wins"
+	SortByWinRate = "winrate"
+	SortByGames   = "games"
+)
+
+// Leaderboard windows: all-time, or the games finished in the current UTC
+// day or ISO week
+const (
+	WindowAll    = "all"
+	WindowDaily  = "daily"
+	WindowWeekly = "weekly"
+)
+
+// How long the bucket of each window is kept, comfortably past its end
+var windowTTLs = map[string]time.Duration{
+	WindowDaily:  48 * time.Hour,
+	WindowWeekly: 14 * 24 * time.Hour,
+}
+
+// The bucket of a window holding results at the given time, e.g.
+// "daily:2024-06-12" or "weekly:2024-W24". Weeks are ISO weeks, so the last
+// days of December can fall into week 1 of the next year.
+func windowBucket(window string, at time.Time) string {
+	at = at.UTC()
+	switch window {
+	case WindowDaily:
+		return "daily:" + at.Format("2006-01-02")
+	case WindowWeekly:
+		year, week := at.ISOWeek()
+		return fmt.Sprintf("weekly:%04d-W%02d", year, week)
+	}
+	return ""
+}
+
+// Count a finished game in the current daily and weekly leaderboards.
+// Failures are logged; the all-time stats are what counts.
+func (s *Server) recordWindowResult(ctx context.Context, username string, isWin bool) {
+	now := s.clock.Now()
+	for _, window := range []string{WindowDaily, WindowWeekly} {
+		if err := s.store.RecordWindowResult(ctx, windowBucket(window, now), username, isWin, windowTTLs[window]); err != nil {
+			log.Printf("Error recording %s result for user %s: %v", window, username, err)
+		}
+	}
+}
+
+// The leaderboard message sent to WebSocket clients. Version numbers the
+// standings on the client's instance, so later deltas can say which
+// standings they apply to.
+type LeaderboardMessage struct {
+	Type        string          `json:"type"`
+	Window      string          `json:"window"`
+	Version     uint64          `json:"version,omitempty"`
+	Leaderboard json.RawMessage `json:"leaderboard"`
+}
+
+// What changed on the leaderboard, sent to WebSocket clients holding the
+// standings at BaseVersion: the rows that are new or whose rank or counts
+// changed, and the players no longer shown. Applying it gives Version.
+type LeaderboardDelta struct {
+	Type        string             `json:"type"`
+	Window      string             `json:"window"`
+	BaseVersion uint64             `json:"baseVersion"`
+	Version     uint64             `json:"version"`
+	Changes     []LeaderboardEntry `json:"changes"`
+	Removed     []string           `json:"removed"`
+}
+
+// How the leaderboard is filtered and ordered
+type leaderboardQuery struct {
+	Window   string
+	Sort     string
+	Desc     bool
+	MinGames int64
+	// Leave out guests that haven't claimed a name
+	ExcludeGuests bool
+	// Keep players flagged as suspected cheats, which only admins may ask for
+	IncludeFlagged bool
+}
+
+// The order used by the WebSocket broadcast and a bare GET /leaderboard
+var defaultLeaderboardQuery = leaderboardQuery{Window: WindowAll, Sort: SortByWins, Desc: true}
+
+// Parse the window, sort, order, minGames, includeGuests and includeFlagged
+// query params
+func (s *Server) parseLeaderboardQuery(c *gin.Context) (leaderboardQuery, *APIError) {
+	query := defaultLeaderboardQuery
+
+	switch window := c.DefaultQuery("window", WindowAll); window {
+	case WindowAll, WindowDaily, WindowWeekly:
+		query.Window = window
+	default:
+		return query, errInvalidRequest(`window must be "all", "daily" or "weekly"`)
+	}
+
+	switch sortBy := c.DefaultQuery("sort", SortByWins); sortBy {
+	case SortByWins, SortByWinRate, SortByGames:
+		query.Sort = sortBy
+	default:
+		return query, errInvalidRequest(`sort must be "wins", "winrate" or "games"`)
+	}
+
+	switch c.DefaultQuery("order", "desc") {
+	case "desc":
+		query.Desc = true
+	case "asc":
+		query.Desc = false
+	default:
+		return query, errInvalidRequest(`order must be "asc" or "desc"`)
+	}
+
+	if minGames := c.Query("minGames"); minGames != "" {
+		n, err := strconv.ParseInt(minGames, 10, 64)
+		if err != nil || n < 0 {
+			return query, errInvalidRequest("minGames must be a non-negative integer")
+		}
+		query.MinGames = n
+	}
+
+	switch c.DefaultQuery("includeGuests", "true") {
+	case "true":
+		query.ExcludeGuests = false
+	case "false":
+		query.ExcludeGuests = true
+	default:
+		return query, errInvalidRequest(`includeGuests must be "true" or "false"`)
+	}
+
+	switch c.DefaultQuery("includeFlagged", "false") {
+	case "true":
+		if !s.isAdmin(c) {
+			return query, errUnauthorized()
+		}
+		query.IncludeFlagged = true
+	case "false":
+		query.IncludeFlagged = false
+	default:
+		return query, errInvalidRequest(`includeFlagged must be "true" or "false"`)
+	}
+
+	return query, nil
+}
+
+// The value a query sorts entries by
+func (q leaderboardQuery) key(entry LeaderboardEntry) float64 {
+	switch q.Sort {
+	case SortByWinRate:
+		return entry.WinRate
+	case SortByGames:
+		return float64(entry.TotalGames)
+	}
+	return float64(entry.Win)
+}
+
+// A player's row before it is ranked, or false if the query leaves them out
+// for having played too few games
+func newLeaderboardEntry(stats UserStats, query leaderboardQuery) (LeaderboardEntry, bool) {
+	entry := LeaderboardEntry{
+		Username:   stats.Username,
+		Win:        stats.Win,
+		Lose:       stats.Lose,
+		TotalGames: stats.Win + stats.Lose,
+	}
+	if entry.TotalGames < query.MinGames {
+		return entry, false
+	}
+	if entry.TotalGames > 0 {
+		entry.WinRate = float64(entry.Win) / float64(entry.TotalGames)
+	}
+	return entry, true
+}
+
+// Order entries by the query. Players tied on the sort key are listed by
+// username.
+func sortLeaderboard(entries []LeaderboardEntry, query leaderboardQuery) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := query.key(entries[i]), query.key(entries[j])
+		if a != b {
+			return query.better(a, b)
+		}
+		return entries[i].Username < entries[j].Username
+	})
+}
+
+// Whether sort key a ranks above b
+func (q leaderboardQuery) better(a, b float64) bool {
+	if q.Desc {
+		return a > b
+	}
+	return a < b
+}
+
+// Sort and rank every entry of the leaderboard. Players tied on the sort key
+// share a rank.
+func rankLeaderboard(entries []LeaderboardEntry, query leaderboardQuery) {
+	sortLeaderboard(entries, query)
+	for i := range entries {
+		if i > 0 && query.key(entries[i]) == query.key(entries[i-1]) {
+			entries[i].Rank = entries[i-1].Rank
+		} else {
+			entries[i].Rank = i + 1
+		}
+	}
+}
+
+// Parse the ?limit= and ?offset= of a page of the leaderboard
+func parseLeaderboardPage(c *gin.Context) (offset, limit int, apiErr *APIError) {
+	limit = defaultLeaderboardPage
+	if raw := c.Query("limit"); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxLeaderboardPage {
+			return 0, 0, errInvalidRequest("limit must be between 1 and " + strconv.Itoa(maxLeaderboardPage))
+		}
+	}
+	if raw := c.Query("offset"); raw != "" {
+		var err error
+		offset, err = strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return 0, 0, errInvalidRequest("offset must be a non-negative integer")
+		}
+	}
+	return offset, limit, nil
+}
+
+// The offset of the page after one of n rows, or 0 if it wasn't full and so
+// was the last
+func nextLeaderboardPage(offset, limit, n int) int {
+	if n < limit {
+		return 0
+	}
+	return offset + limit
+}
+
+// Leaderboard route: up to ?limit= rows after the first ?offset=. Pass the
+// response's next as offset to page on.
+func (s *Server) getLeaderboard(c *gin.Context) {
+	switch c.Query("mode") {
+	case ModeSurvival:
+		s.getSurvivalLeaderboard(c)
+		return
+	case "", ModeClassic:
+	default:
+		abortWithError(c, errInvalidRequest(`mode must be "classic" or "survival"`))
+		return
+	}
+
+	query, apiErr := s.parseLeaderboardQuery(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	offset, limit, apiErr := parseLeaderboardPage(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	entries, err := s.fetchTopUserStats(c.Request.Context(), query, offset, limit, nil)
+	if err != nil {
+		log.Printf("Error fetching leaderboard: %v", err)
+		abortWithError(c, errStoreUnavailable("Error fetching leaderboard"))
+		return
+	}
+
+	c.JSON(http.StatusOK, LeaderboardResponse{Leaderboard: entries, Next: nextLeaderboardPage(offset, limit, len(entries))})
+}
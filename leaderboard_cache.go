@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// Longest a cached leaderboard is served without a rebuild, in case an
+// invalidation is missed (e.g. stats changed by another instance), unless
+// LEADERBOARD_MAX_STALENESS says otherwise
+const defaultLeaderboardMaxStaleness = 5 * time.Second
+
+// leaderboardCache holds the serialized default leaderboard, cut to what
+// sockets are sent: the top rows and the rows of the players online. It is
+// rebuilt on the first read after an invalidation. version only moves when
+// the content does, so broadcasts can tell whether there is anything new to
+// send.
+type leaderboardCache struct {
+	mutex   sync.RWMutex
+	payload []byte
+	builtAt time.Time
+	valid   bool
+	version uint64
+	// Version last broadcast to the leaderboard clients
+	broadcastVersion uint64
+}
+
+// Drop the cached leaderboard so the next read rebuilds it
+func (lc *leaderboardCache) invalidate() {
+	lc.mutex.Lock()
+	lc.valid = false
+	lc.mutex.Unlock()
+}
+
+// The serialized default leaderboard and its version, from the cache when fresh
+func (s *Server) cachedLeaderboard(ctx context.Context) ([]byte, uint64, error) {
+	lc := s.leaderboard
+	now := s.clock.Now()
+
+	lc.mutex.RLock()
+	if lc.valid && now.Sub(lc.builtAt) < s.leaderboardMaxStaleness {
+		payload, version := lc.payload, lc.version
+		lc.mutex.RUnlock()
+		leaderboardCacheTotal.WithLabelValues("hit").Inc()
+		return payload, version, nil
+	}
+	lc.mutex.RUnlock()
+
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	// Another reader may have rebuilt it while we waited for the lock
+	if lc.valid && now.Sub(lc.builtAt) < s.leaderboardMaxStaleness {
+		leaderboardCacheTotal.WithLabelValues("hit").Inc()
+		return lc.payload, lc.version, nil
+	}
+	leaderboardCacheTotal.WithLabelValues("miss").Inc()
+
+	// Sockets only get the top rows and their own player's, so only the
+	// players online can need one below the top
+	online := map[string]bool{}
+	if usernames, err := s.store.OnlineUsers(ctx, now.Add(-s.presenceTTL)); err != nil {
+		log.Println("Error fetching online users:", err)
+	} else {
+		for _, username := range usernames {
+			online[username] = true
+		}
+	}
+	entries, err := s.fetchTopUserStats(ctx, defaultLeaderboardQuery, 0, wsLeaderboardTop, online)
+	if err != nil {
+		return nil, 0, err
+	}
+	payload, err := json.Marshal(entries)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !bytes.Equal(payload, lc.payload) {
+		lc.version++
+	}
+	lc.payload = payload
+	lc.builtAt = now
+	lc.valid = true
+	return lc.payload, lc.version, nil
+}
+
+// Claim the right to broadcast version. Returns false if the clients already
+// have it.
+func (lc *leaderboardCache) markBroadcast(version uint64) bool {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	if version == lc.broadcastVersion {
+		return false
+	}
+	lc.broadcastVersion = version
+	return true
+}
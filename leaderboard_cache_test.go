@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Leaderboard cache misses so far, each a read of every player's stats
+func leaderboardRebuilds() float64 {
+	return testutil.ToFloat64(leaderboardCacheTotal.WithLabelValues("miss"))
+}
+
+func TestSocketsShareOneLeaderboardRead(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		const sockets = 50
+		rebuilds := leaderboardRebuilds()
+		hits := testutil.ToFloat64(leaderboardCacheTotal.WithLabelValues("hit"))
+		// The broadcast of the new standings builds it
+		ts.winSoloGame("alice")
+		first := ts.dial("")
+		first.next("leaderboard")
+		for i := 1; i < sockets; i++ {
+			ts.dial("").next("leaderboard")
+		}
+		if n := leaderboardRebuilds() - rebuilds; n != 1 {
+			t.Fatalf("a game's end and %d sockets read the stats %v times, want once", sockets, n)
+		}
+		if n := testutil.ToFloat64(leaderboardCacheTotal.WithLabelValues("hit")) - hits; n < sockets {
+			t.Fatalf("%v cache hits, want one a socket", n)
+		}
+
+		// The next game's end invalidates it, and its broadcast rebuilds it
+		// once for every socket
+		ts.winSoloGame("bob")
+		frame := decodeMessage[struct{ Leaderboard []LeaderboardEntry }](t, first.next("leaderboard"))
+		if len(frame.Leaderboard) != 2 {
+			t.Fatalf("leaderboard after bob's win = %+v", frame.Leaderboard)
+		}
+		ts.dial("").next("leaderboard")
+		if n := leaderboardRebuilds() - rebuilds; n != 2 {
+			t.Fatalf("stats read %v times after a game ended, want twice", n)
+		}
+
+		// A missed invalidation lasts no longer than the staleness cap
+		ts.clock.Advance(ts.leaderboardMaxStaleness)
+		ts.dial("").next("leaderboard")
+		if n := leaderboardRebuilds() - rebuilds; n != 3 {
+			t.Fatalf("stats read %v times once the cache went stale, want 3", n)
+		}
+	})
+}
+
+// Connects 500 sockets, each sent the leaderboard as it opens, and reports
+// how many times the stats were read for them
+func BenchmarkConnectSockets(b *testing.B) {
+	const conns = 500
+	ctx := context.Background()
+	store := newMemoryStore()
+	for i := 0; i < 1000; i++ {
+		store.SetStats(ctx, fmt.Sprintf("user%04d", i), int64(i%100), int64(i%37), AuditEntry{})
+	}
+	config, err := loadConfig(func(name string) (string, bool) {
+		if name == "WS_MAX_CONNECTIONS" {
+			return "100000", true
+		}
+		return "", false
+	})
+	if err != nil {
+		b.Fatalf("loadConfig: %v", err)
+	}
+	s := newServer(store, config)
+	listener := httptest.NewServer(s.router())
+	defer listener.Close()
+	defer s.hub.pool.stop(ctx)
+	url := "ws" + strings.TrimPrefix(listener.URL, "http") + "/ws"
+
+	rebuilds := leaderboardRebuilds()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < conns; j++ {
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var frame struct{ Type string }
+			for frame.Type != "leaderboard" {
+				if err := conn.ReadJSON(&frame); err != nil {
+					b.Fatal(err)
+				}
+			}
+			conn.Close()
+		}
+	}
+	b.ReportMetric((leaderboardRebuilds()-rebuilds)/float64(b.N), "stats-reads/op")
+}
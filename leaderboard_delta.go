@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/gorilla/websocket"
+)
+
+// The rows of after that are new or differ from before, by username, and
+// the players of before missing from after, both in rank order
+func diffLeaderboard(before, after []LeaderboardEntry) ([]LeaderboardEntry, []string) {
+	previous := make(map[string]LeaderboardEntry, len(before))
+	for _, entry := range before {
+		previous[entry.Username] = entry
+	}
+	current := make(map[string]bool, len(after))
+
+	changes := []LeaderboardEntry{}
+	for _, entry := range after {
+		current[entry.Username] = true
+		if old, ok := previous[entry.Username]; !ok || old != entry {
+			changes = append(changes, entry)
+		}
+	}
+	removed := []string{}
+	for _, entry := range before {
+		if !current[entry.Username] {
+			removed = append(removed, entry.Username)
+		}
+	}
+	return changes, removed
+}
+
+func sortByRank(entries []LeaderboardEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Rank != entries[j].Rank {
+			return entries[i].Rank < entries[j].Rank
+		}
+		return entries[i].Username < entries[j].Username
+	})
+}
+
+func equalLeaderboards(a, b []LeaderboardEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// The frames one leaderboard broadcast sends: a delta from the previous
+// standings for clients holding them, and a full snapshot for the rest.
+// Each distinct frame is encoded once: every client's view is the top rows
+// plus its own, so clients whose player is in the top rows or unknown share
+// theirs.
+type leaderboardFrames struct {
+	window      string
+	before      []LeaderboardEntry
+	after       []LeaderboardEntry
+	baseVersion uint64
+	version     uint64
+	frames      map[string][]byte
+}
+
+// The frame for a client of the given player, "" if it didn't say, holding
+// the standings at clientVersion
+func (f *leaderboardFrames) frame(username string, clientVersion uint64) ([]byte, error) {
+	if clientVersion != f.baseVersion {
+		return f.snapshot(username)
+	}
+
+	after, own := topLeaderboard(f.after, username)
+	before, ownBefore := topLeaderboard(f.before, username)
+	key := "delta:"
+	if own || ownBefore {
+		key += username
+	}
+	return f.encode(key, func() (interface{}, error) {
+		changes, removed := diffLeaderboard(before, after)
+		return LeaderboardDelta{
+			Type:        "leaderboard_delta",
+			Window:      f.window,
+			BaseVersion: f.baseVersion,
+			Version:     f.version,
+			Changes:     changes,
+			Removed:     removed,
+		}, nil
+	})
+}
+
+// The full snapshot frame for a client of the given player, whatever
+// standings it holds
+func (f *leaderboardFrames) snapshot(username string) ([]byte, error) {
+	after, own := topLeaderboard(f.after, username)
+	key := "snapshot:"
+	if own {
+		key += username
+	}
+	return f.encode(key, func() (interface{}, error) {
+		rows, err := json.Marshal(after)
+		if err != nil {
+			return nil, err
+		}
+		return LeaderboardMessage{Type: "leaderboard", Window: f.window, Version: f.version, Leaderboard: rows}, nil
+	})
+}
+
+func (f *leaderboardFrames) encode(key string, build func() (interface{}, error)) ([]byte, error) {
+	if frame, ok := f.frames[key]; ok {
+		return frame, nil
+	}
+	message, err := build()
+	if err != nil {
+		return nil, err
+	}
+	frame, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	f.frames[key] = frame
+	return frame, nil
+}
+
+// Read a message sent to leaderboard clients. Returns false for messages
+// that aren't the leaderboard, such as presence updates, which every client
+// gets as they are.
+func parseLeaderboardMessage(payload []byte) (LeaderboardMessage, []LeaderboardEntry, bool) {
+	var message LeaderboardMessage
+	if err := json.Unmarshal(payload, &message); err != nil || message.Type != "leaderboard" {
+		return message, nil, false
+	}
+	var entries []LeaderboardEntry
+	if err := json.Unmarshal(message.Leaderboard, &entries); err != nil {
+		return message, nil, false
+	}
+	return message, entries, true
+}
+
+// Move this instance's standings on to entries and return the frames that
+// tell the clients, or nil if the clients already have them. Callers hold
+// the mutex.
+func (h *Hub) nextStandings(window string, entries []LeaderboardEntry) *leaderboardFrames {
+	if h.standingsVersion > 0 && equalLeaderboards(h.standings, entries) {
+		return nil
+	}
+	f := &leaderboardFrames{
+		window:      window,
+		before:      h.standings,
+		after:       entries,
+		baseVersion: h.standingsVersion,
+		version:     h.standingsVersion + 1,
+		frames:      make(map[string][]byte),
+	}
+	h.standings = entries
+	h.standingsVersion = f.version
+	return f
+}
+
+// Send a leaderboard client a full snapshot of this instance's standings.
+// entries become the standings if there are none yet.
+func (h *Hub) sendLeaderboardSnapshot(conn *websocket.Conn, window string, entries []LeaderboardEntry) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.standingsVersion == 0 {
+		h.standings = entries
+		h.standingsVersion = 1
+	}
+	f := &leaderboardFrames{window: window, after: h.standings, version: h.standingsVersion, frames: make(map[string][]byte)}
+	// No client version matches the unset base, so this is a snapshot
+	frame, err := f.frame(h.clientUser[conn], h.standingsVersion)
+	if err != nil {
+		return err
+	}
+	if err := h.pool.send(conn, frame); err != nil {
+		return err
+	}
+	if h.clients[conn] {
+		h.clientVersion[conn] = h.standingsVersion
+	}
+	return nil
+}
+
+// Version of this instance's standings, 0 before the first
+func (h *Hub) leaderboardVersion() uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.standingsVersion
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func row(rank int, username string, win, lose int64) LeaderboardEntry {
+	return LeaderboardEntry{Rank: rank, Username: username, Win: win, Lose: lose, TotalGames: win + lose, WinRate: float64(win) / float64(win+lose)}
+}
+
+func TestDiffLeaderboard(t *testing.T) {
+	standings := []LeaderboardEntry{row(1, "alice", 3, 1), row(2, "bob", 2, 2), row(3, "carol", 1, 3)}
+	for _, test := range []struct {
+		name    string
+		before  []LeaderboardEntry
+		after   []LeaderboardEntry
+		changes []LeaderboardEntry
+		removed []string
+	}{
+		{"unchanged", standings, standings, []LeaderboardEntry{}, []string{}},
+		{"first standings", nil, standings, standings, []string{}},
+		{
+			"counts change in place",
+			standings,
+			[]LeaderboardEntry{row(1, "alice", 3, 1), row(2, "bob", 2, 3), row(3, "carol", 1, 3)},
+			[]LeaderboardEntry{row(2, "bob", 2, 3)},
+			[]string{},
+		},
+		{
+			"a pass moves both ranks",
+			standings,
+			[]LeaderboardEntry{row(1, "alice", 3, 1), row(2, "carol", 4, 3), row(3, "bob", 2, 2)},
+			[]LeaderboardEntry{row(2, "carol", 4, 3), row(3, "bob", 2, 2)},
+			[]string{},
+		},
+		{
+			"a newcomer pushes the last out",
+			standings,
+			[]LeaderboardEntry{row(1, "dave", 9, 0), row(2, "alice", 3, 1), row(3, "bob", 2, 2)},
+			[]LeaderboardEntry{row(1, "dave", 9, 0), row(2, "alice", 3, 1), row(3, "bob", 2, 2)},
+			[]string{"carol"},
+		},
+		{"everyone leaves", standings, nil, []LeaderboardEntry{}, []string{"alice", "bob", "carol"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			changes, removed := diffLeaderboard(test.before, test.after)
+			if !reflect.DeepEqual(changes, test.changes) || !reflect.DeepEqual(removed, test.removed) {
+				t.Fatalf("diff = %+v, removed %q; want %+v, removed %q", changes, removed, test.changes, test.removed)
+			}
+		})
+	}
+}
+
+func TestStaleClientsGetSnapshots(t *testing.T) {
+	before := []LeaderboardEntry{row(1, "alice", 3, 1)}
+	after := []LeaderboardEntry{row(1, "alice", 4, 1)}
+	f := &leaderboardFrames{window: WindowAll, before: before, after: after, baseVersion: 4, version: 5, frames: make(map[string][]byte)}
+	for version, want := range map[uint64]string{4: "leaderboard_delta", 3: "leaderboard", 0: "leaderboard"} {
+		frame, err := f.frame("", version)
+		var message struct{ Type string }
+		if err != nil || json.Unmarshal(frame, &message) != nil || message.Type != want {
+			t.Fatalf("frame for a client at version %d = %s, %v; want %s", version, frame, err, want)
+		}
+	}
+}
+
+// A client's copy of the standings, kept by applying deltas
+type heldStandings struct {
+	version uint64
+	rows    map[string]LeaderboardEntry
+}
+
+func (h *heldStandings) apply(t *testing.T, delta LeaderboardDelta) {
+	t.Helper()
+	if delta.BaseVersion != h.version || delta.Version != h.version+1 {
+		t.Fatalf("delta from version %d to %d applied to %d", delta.BaseVersion, delta.Version, h.version)
+	}
+	for _, entry := range delta.Changes {
+		h.rows[entry.Username] = entry
+	}
+	for _, username := range delta.Removed {
+		delete(h.rows, username)
+	}
+	h.version = delta.Version
+}
+
+func (h *heldStandings) sorted() []LeaderboardEntry {
+	entries := make([]LeaderboardEntry, 0, len(h.rows))
+	for _, entry := range h.rows {
+		entries = append(entries, entry)
+	}
+	sortByRank(entries)
+	return entries
+}
+
+func TestDeltasAddUpToSnapshot(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		setStats := func(stats map[string][2]int64) {
+			for username, counts := range stats {
+				ts.store.SetStats(ctx, username, counts[0], counts[1], AuditEntry{})
+			}
+			ts.leaderboard.invalidate()
+			ts.broadcastLeaderboard()
+		}
+		setStats(map[string][2]int64{"alice": {3, 1}, "bob": {2, 2}, "carol": {1, 3}})
+
+		socket := ts.dial("")
+		first := decodeMessage[struct {
+			Version     uint64
+			Leaderboard []LeaderboardEntry
+		}](t, socket.next("leaderboard"))
+		held := &heldStandings{version: first.Version, rows: map[string]LeaderboardEntry{}}
+		for _, entry := range first.Leaderboard {
+			held.rows[entry.Username] = entry
+		}
+		socket.hello(CapLeaderboardDelta)
+
+		for _, change := range []map[string][2]int64{
+			{"bob": {5, 2}},
+			{"dave": {9, 0}, "carol": {1, 4}},
+			{"alice": {3, 6}},
+		} {
+			setStats(change)
+			held.apply(t, decodeMessage[LeaderboardDelta](t, socket.next("leaderboard_delta")))
+		}
+
+		fresh := decodeMessage[struct{ Leaderboard []LeaderboardEntry }](t, ts.dial("").next("leaderboard")).Leaderboard
+		if got := held.sorted(); len(fresh) != 4 || !reflect.DeepEqual(got, fresh) {
+			t.Fatalf("after three deltas the client holds %+v, a snapshot has %+v", got, fresh)
+		}
+	})
+}
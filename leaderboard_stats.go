@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+)
+
+// Users read from the store at a time when building a leaderboard
+const statsPageSize = 1000
+
+// One user's win/lose counts, as the store keeps them
+type UserStats struct {
+	Username string
+	Win      int64
+	Lose     int64
+}
+
+// Pages through users' counts, so a leaderboard over every player never
+// needs them all in memory at once. Each user is returned exactly once, in
+// no particular order.
+type StatsIterator interface {
+	// The next page of users, or false once there are none left or reading
+	// them failed; see Err
+	Next(ctx context.Context) ([]UserStats, bool)
+	// Why Next stopped early, if it did
+	Err() error
+}
+
+// Pages through counts already in memory, such as a window's
+type sliceStatsIterator struct {
+	stats    []UserStats
+	pageSize int
+}
+
+func (it *sliceStatsIterator) Next(ctx context.Context) ([]UserStats, bool) {
+	if len(it.stats) == 0 {
+		return nil, false
+	}
+	n := it.pageSize
+	if n > len(it.stats) {
+		n = len(it.stats)
+	}
+	page := it.stats[:n]
+	it.stats = it.stats[n:]
+	return page, true
+}
+
+func (it *sliceStatsIterator) Err() error {
+	return nil
+}
+
+// The counts of a map of them, by username
+func statsOf(counts map[string]*UserStats) []UserStats {
+	stats := make([]UserStats, 0, len(counts))
+	for _, count := range counts {
+		stats = append(stats, *count)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Username < stats[j].Username })
+	return stats
+}
+
+// The counts the query ranks: the all-time ones paged from the store, or a
+// window's, which only holds the players of a day or a week and is read at
+// once
+func (s *Server) statsFor(ctx context.Context, query leaderboardQuery) (StatsIterator, error) {
+	if query.Window == WindowAll {
+		return s.store.LeaderboardStats(ctx, statsPageSize), nil
+	}
+	stats, err := s.store.WindowLeaderboard(ctx, windowBucket(query.Window, s.clock.Now()))
+	if err != nil {
+		return nil, err
+	}
+	return &sliceStatsIterator{stats: stats, pageSize: statsPageSize}, nil
+}
+
+// Whether a player belongs on the leaderboard of the query, which leaves out
+// flagged players unless an admin asked for them, and guests if asked to
+func (s *Server) leaderboardFilter(ctx context.Context, query leaderboardQuery) (func(username string) bool, error) {
+	var flagged map[string]string
+	if !query.IncludeFlagged {
+		var err error
+		if flagged, err = s.store.FlaggedUsers(ctx); err != nil {
+			log.Printf("Error fetching flagged users: %v", err)
+			return nil, err
+		}
+	}
+	var guests map[string]bool
+	if query.ExcludeGuests {
+		var err error
+		if guests, err = s.store.Guests(ctx); err != nil {
+			log.Printf("Error fetching guests: %v", err)
+			return nil, err
+		}
+	}
+	return func(username string) bool {
+		_, isFlagged := flagged[username]
+		return !isFlagged && !guests[username]
+	}, nil
+}
+
+// Read every row of the query's leaderboard a page at a time, calling visit
+// with each one it keeps, unranked
+func (s *Server) eachLeaderboardEntry(ctx context.Context, query leaderboardQuery, visit func(LeaderboardEntry)) error {
+	stats, err := s.statsFor(ctx, query)
+	if err != nil {
+		log.Printf("Error fetching user stats: %v", err)
+		return err
+	}
+	keep, err := s.leaderboardFilter(ctx, query)
+	if err != nil {
+		return err
+	}
+	for {
+		page, ok := stats.Next(ctx)
+		if !ok {
+			break
+		}
+		for _, stat := range page {
+			if !keep(stat.Username) {
+				continue
+			}
+			if entry, ok := newLeaderboardEntry(stat, query); ok {
+				visit(entry)
+			}
+		}
+	}
+	if err := stats.Err(); err != nil {
+		log.Printf("Error fetching user stats: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Every player's row of the leaderboard, ranked by query. The rows are all
+// returned, so they are all held, but the store's counts are only read a
+// page at a time.
+func (s *Server) fetchAllUserStats(ctx context.Context, query leaderboardQuery) ([]LeaderboardEntry, error) {
+	entries := []LeaderboardEntry{}
+	if err := s.eachLeaderboardEntry(ctx, query, func(entry LeaderboardEntry) {
+		entries = append(entries, entry)
+	}); err != nil {
+		return nil, err
+	}
+	rankLeaderboard(entries, query)
+	s.attachProfiles(ctx, entries)
+	return entries, nil
+}
+
+// Up to n rows of the leaderboard after the first offset, ranked by query,
+// then the rows of the players in also who rank below them, in rank order.
+// Only the best rows seen so far and the rows of the players in also are
+// held while reading, so memory doesn't grow with the number of players.
+func (s *Server) fetchTopUserStats(ctx context.Context, query leaderboardQuery, offset, n int, also map[string]bool) ([]LeaderboardEntry, error) {
+	// The rows before offset are read too, as they decide the ranks of the
+	// rest
+	n += offset
+	// The best rows seen so far by username, cut back to n whenever they
+	// reach twice that
+	best := make(map[string]LeaderboardEntry, 2*n)
+	var own []LeaderboardEntry
+	if err := s.eachLeaderboardEntry(ctx, query, func(entry LeaderboardEntry) {
+		if also[entry.Username] {
+			own = append(own, entry)
+		}
+		best[entry.Username] = entry
+		if len(best) >= 2*n {
+			kept := topEntries(best, n, query)
+			clear(best)
+			for _, entry := range kept {
+				best[entry.Username] = entry
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	// Every player ranking above a top row is in the top, so its ranks
+	// follow from its order
+	top := topEntries(best, n, query)
+	rankLeaderboard(top, query)
+
+	shown := make(map[string]bool, len(top))
+	for _, entry := range top {
+		shown[entry.Username] = true
+	}
+	var below []LeaderboardEntry
+	for _, entry := range own {
+		if !shown[entry.Username] {
+			below = append(below, entry)
+		}
+	}
+	page := top[min(offset, len(top)):]
+	if len(below) == 0 {
+		s.attachProfiles(ctx, page)
+		return page, nil
+	}
+
+	// The players below the top are ranked by counting, in a second read,
+	// the players ahead of each
+	sortLeaderboard(below, query)
+	ahead := make([]int, len(below)+1)
+	if err := s.eachLeaderboardEntry(ctx, query, func(entry LeaderboardEntry) {
+		// The first of the rows the entry beats, and so of every one after it
+		key := query.key(entry)
+		ahead[sort.Search(len(below), func(i int) bool { return query.better(key, query.key(below[i])) })]++
+	}); err != nil {
+		return nil, err
+	}
+	passed := 0
+	for i, entry := range below {
+		passed += ahead[i]
+		entry.Rank = passed + 1
+		page = append(page, entry)
+	}
+	s.attachProfiles(ctx, page)
+	return page, nil
+}
+
+// The first n of rows in the query's order
+func topEntries(rows map[string]LeaderboardEntry, n int, query leaderboardQuery) []LeaderboardEntry {
+	entries := make([]LeaderboardEntry, 0, len(rows))
+	for _, entry := range rows {
+		entries = append(entries, entry)
+	}
+	sortLeaderboard(entries, query)
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestStatsIteratorReturnsEachUserOnce(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		const users, pageSize = 250, 16
+		for i := 0; i < users; i++ {
+			store.SetStats(ctx, fmt.Sprintf("user%03d", i), int64(i), int64(users-i), AuditEntry{})
+		}
+
+		seen := make(map[string]int)
+		it := store.LeaderboardStats(ctx, pageSize)
+		for {
+			page, ok := it.Next(ctx)
+			if !ok {
+				break
+			}
+			for _, stats := range page {
+				seen[stats.Username]++
+				i, _ := strconv.Atoi(stats.Username[len("user"):])
+				if stats.Win != int64(i) || stats.Lose != int64(users-i) {
+					t.Fatalf("%s = %d/%d, want %d/%d", stats.Username, stats.Win, stats.Lose, i, users-i)
+				}
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("iterating: %v", err)
+		}
+		if len(seen) != users {
+			t.Fatalf("iterated %d users, want %d", len(seen), users)
+		}
+		for username, n := range seen {
+			if n != 1 {
+				t.Fatalf("%s returned %d times", username, n)
+			}
+		}
+	})
+}
+
+func TestTopLeaderboardRanksTiesAndPlayersBelowIt(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		// Groups of five players share each win count, so ties straddle the
+		// top and the trims made while reading it
+		const users, top = 60, 7
+		for i := 0; i < users; i++ {
+			ts.store.SetStats(ctx, fmt.Sprintf("user%02d", i), int64(i/5), 1, AuditEntry{})
+		}
+		all, err := ts.fetchAllUserStats(ctx, defaultLeaderboardQuery)
+		if err != nil {
+			t.Fatalf("fetchAllUserStats: %v", err)
+		}
+		want := make(map[string]LeaderboardEntry, len(all))
+		for _, entry := range all {
+			want[entry.Username] = entry
+		}
+
+		also := map[string]bool{"user00": true, "user33": true, "user57": true}
+		got, err := ts.fetchTopUserStats(ctx, defaultLeaderboardQuery, 0, top, also)
+		if err != nil {
+			t.Fatalf("fetchTopUserStats: %v", err)
+		}
+		if len(got) != top+2 {
+			t.Fatalf("got %d rows, want the top %d and 2 below it: %+v", len(got), top, got)
+		}
+		for i, entry := range got {
+			if i < top && entry != all[i] {
+				t.Fatalf("row %d = %+v, want %+v", i, entry, all[i])
+			}
+			if entry != want[entry.Username] {
+				t.Fatalf("%s = %+v, want %+v", entry.Username, entry, want[entry.Username])
+			}
+		}
+		if got[top].Username != "user33" || got[top+1].Username != "user00" {
+			t.Fatalf("rows below the top = %+v", got[top:])
+		}
+	})
+}
+
+// Builds the socket leaderboard's top rows out of 100k users by reading
+// them all into one slice first, as fetchAllUserStats did, and streamed
+// through the store's iterator. The memory store is used since miniredis
+// ignores HSCAN's COUNT and answers in one page.
+func BenchmarkTopLeaderboard(b *testing.B) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	const users = 100000
+	for i := 0; i < users; i++ {
+		username := fmt.Sprintf("user%06d", i)
+		store.wins[username], store.loses[username] = int64(i%100), int64(i%37)
+	}
+	config, err := loadConfig(func(string) (string, bool) { return "", false })
+	if err != nil {
+		b.Fatalf("loadConfig: %v", err)
+	}
+	s := newServer(store, config)
+
+	b.Run("all", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var entries []LeaderboardEntry
+			it := store.LeaderboardStats(ctx, statsPageSize)
+			for {
+				page, ok := it.Next(ctx)
+				if !ok {
+					break
+				}
+				for _, stats := range page {
+					if entry, ok := newLeaderboardEntry(stats, defaultLeaderboardQuery); ok {
+						entries = append(entries, entry)
+					}
+				}
+			}
+			sortLeaderboard(entries, defaultLeaderboardQuery)
+			if len(entries) < wsLeaderboardTop {
+				b.Fatal("short leaderboard")
+			}
+		}
+	})
+
+	b.Run("streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			entries, err := s.fetchTopUserStats(ctx, defaultLeaderboardQuery, 0, wsLeaderboardTop, nil)
+			if err != nil || len(entries) != wsLeaderboardTop {
+				b.Fatalf("read %d rows: %v", len(entries), err)
+			}
+		}
+	})
+}
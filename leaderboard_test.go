@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+// A dozen players, with ties on every sort key
+var leaderboardPlayers = []struct {
+	username  string
+	win, lose int64
+}{
+	{"amy", 10, 0}, {"ben", 10, 5}, {"cal", 8, 2}, {"dee", 8, 2},
+	{"eve", 5, 5}, {"fay", 5, 0}, {"gus", 3, 9}, {"hal", 1, 1},
+	{"ida", 0, 4}, {"jo", 2, 8}, {"kim", 6, 6}, {"lou", 4, 0},
+}
+
+func seedLeaderboard(t *testing.T, ts *testServer) {
+	t.Helper()
+	for _, player := range leaderboardPlayers {
+		if _, err := ts.store.SetStats(context.Background(), player.username, player.win, player.lose, AuditEntry{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// The leaderboard as "rank:username" rows
+func leaderboardRows(t *testing.T, ts *testServer, query string) string {
+	t.Helper()
+	response := decodeOK[LeaderboardResponse](t, ts.get("/leaderboard?"+query))
+	rows := make([]string, len(response.Leaderboard))
+	for i, entry := range response.Leaderboard {
+		rows[i] = fmt.Sprintf("%d:%s", entry.Rank, entry.Username)
+	}
+	return strings.Join(rows, " ")
+}
+
+func TestLeaderboardSortModes(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "1:amy 1:ben 3:cal 3:dee 5:kim 6:eve 6:fay 8:lou 9:gus 10:jo 11:hal 12:ida"},
+		{"sort=wins&order=asc", "1:ida 2:hal 3:jo 4:gus 5:lou 6:eve 6:fay 8:kim 9:cal 9:dee 11:amy 11:ben"},
+		{"sort=winrate&minGames=5", "1:amy 1:fay 3:cal 3:dee 5:ben 6:eve 6:kim 8:gus 9:jo"},
+		{"sort=winrate&order=asc&minGames=10", "1:jo 2:gus 3:eve 3:kim 5:ben 6:cal 6:dee 8:amy"},
+		{"sort=games&order=asc", "1:hal 2:ida 2:lou 4:fay 5:amy 5:cal 5:dee 5:eve 5:jo 10:gus 10:kim 12:ben"},
+		{"sort=games&minGames=12", "1:ben 2:gus 2:kim"},
+	}
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		seedLeaderboard(t, ts)
+		for _, test := range tests {
+			if got := leaderboardRows(t, ts, test.query); got != test.want {
+				t.Errorf("?%s:\n got %s\nwant %s", test.query, got, test.want)
+			}
+		}
+	})
+}
+
+func TestLeaderboardEntryTotals(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		seedLeaderboard(t, ts)
+		response := decodeOK[LeaderboardResponse](t, ts.get("/leaderboard?sort=winrate&minGames=15"))
+		if len(response.Leaderboard) != 1 {
+			t.Fatalf("leaderboard = %+v", response.Leaderboard)
+		}
+		if ben := response.Leaderboard[0]; ben.Username != "ben" || ben.TotalGames != 15 || ben.WinRate != 10.0/15 {
+			t.Fatalf("row = %+v", ben)
+		}
+	})
+}
+
+func TestLeaderboardPages(t *testing.T) {
+	// Pages of three split the tie of cal and dee, which keep their rank
+	want := []string{"1:amy 1:ben 3:cal", "3:dee 5:kim 6:eve", "6:fay 8:lou 9:gus", "10:jo 11:hal 12:ida", ""}
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		seedLeaderboard(t, ts)
+		offset := 0
+		for i, rows := range want {
+			query := fmt.Sprintf("limit=3&offset=%d", offset)
+			if got := leaderboardRows(t, ts, query); got != rows {
+				t.Fatalf("?%s:\n got %s\nwant %s", query, got, rows)
+			}
+			// The empty page after the last full one ends it
+			wantNext := offset + 3
+			if i == len(want)-1 {
+				wantNext = 0
+			}
+			if next := decodeOK[LeaderboardResponse](t, ts.get("/leaderboard?"+query)).Next; next != wantNext {
+				t.Fatalf("?%s: next = %d, want %d", query, next, wantNext)
+			}
+			offset = wantNext
+		}
+	})
+}
+
+func TestLeaderboardRejectsBadQueries(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	for _, query := range []string{
+		"sort=losses", "order=up", "minGames=-1", "minGames=many",
+		"limit=0", "limit=1001", "limit=all", "offset=-1", "mode=survival&offset=first",
+	} {
+		assertError(t, ts.get("/leaderboard?"+query), http.StatusBadRequest, ErrCodeInvalidRequest)
+	}
+}
+
+func TestWindowBuckets(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		at            time.Time
+		daily, weekly string
+	}{
+		{time.Date(2024, 6, 12, 9, 0, 0, 0, time.UTC), "daily:2024-06-12", "weekly:2024-W24"},
+		// Late evening in New York is already tomorrow in UTC
+		{time.Date(2024, 6, 12, 21, 0, 0, 0, newYork), "daily:2024-06-13", "weekly:2024-W24"},
+		// A Monday at the end of December starts week 1 of the next year
+		{time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC), "daily:2024-12-30", "weekly:2025-W01"},
+		{time.Date(2024, 12, 29, 23, 59, 59, 0, time.UTC), "daily:2024-12-29", "weekly:2024-W52"},
+		// And the first days of January can belong to the last year's week 53
+		{time.Date(2021, 1, 3, 12, 0, 0, 0, time.UTC), "daily:2021-01-03", "weekly:2020-W53"},
+		{time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC), "daily:2021-01-04", "weekly:2021-W01"},
+	}
+	for _, test := range tests {
+		if got := windowBucket(WindowDaily, test.at); got != test.daily {
+			t.Errorf("daily bucket of %v = %q, want %q", test.at, got, test.daily)
+		}
+		if got := windowBucket(WindowWeekly, test.at); got != test.weekly {
+			t.Errorf("weekly bucket of %v = %q, want %q", test.at, got, test.weekly)
+		}
+	}
+}
+
+// Win a solo game as username at the fake clock's time
+func (ts *testServer) winSoloGame(username string) {
+	ts.t.Helper()
+	ts.startGame(username, "Cat", engine.ExplodingKitten)
+	if drawn := decodeOK[DrawCardResponse](ts.t, ts.draw(username)); drawn.GameStatus != GameStatusWon {
+		ts.t.Fatalf("draw = %+v, want a win", drawn)
+	}
+}
+
+func TestLeaderboardWindowsRollOverAtYearEnd(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		// Sunday night, in the last hour of ISO week 2024-W52
+		ts.clock.Advance(time.Date(2024, 12, 29, 23, 0, 0, 0, time.UTC).Sub(ts.clock.Now()))
+		ts.winSoloGame("alice")
+		if rows := leaderboardRows(t, ts, "window=weekly"); rows != "1:alice" {
+			t.Fatalf("weekly = %q", rows)
+		}
+
+		// Two hours later it is Monday, in 2025-W01
+		ts.clock.Advance(2 * time.Hour)
+		ts.winSoloGame("bob")
+		for _, window := range []string{WindowDaily, WindowWeekly} {
+			if rows := leaderboardRows(t, ts, "window="+window); rows != "1:bob" {
+				t.Fatalf("%s = %q, want only bob", window, rows)
+			}
+		}
+		if rows := leaderboardRows(t, ts, "window=all"); rows != "1:alice 1:bob" {
+			t.Fatalf("all = %q", rows)
+		}
+
+		// alice's win stays in the week it was won in
+		stats, err := ts.store.WindowLeaderboard(context.Background(), "weekly:2024-W52")
+		if err != nil || len(stats) != 1 || stats[0].Username != "alice" || stats[0].Win != 1 {
+			t.Fatalf("weekly:2024-W52 = %+v, %v", stats, err)
+		}
+	})
+}
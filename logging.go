@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Log what happened in a game as key=value pairs, so every value is tagged
+// with what it is: event=draw user=alice game=alice card=Defuse remaining=7.
+// fields["event"] comes first, then the user and game, then the other
+// fields by name. Strings with spaces or quotes are quoted.
+func logGameEvent(username, gameID string, fields map[string]any) {
+	var line strings.Builder
+	if event, ok := fields["event"]; ok {
+		writeLogField(&line, "event", event)
+	}
+	writeLogField(&line, "user", username)
+	writeLogField(&line, "game", gameID)
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if name != "event" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeLogField(&line, name, fields[name])
+	}
+	log.Print(line.String())
+}
+
+func writeLogField(line *strings.Builder, name string, value any) {
+	if line.Len() > 0 {
+		line.WriteByte(' ')
+	}
+	text := fmt.Sprint(value)
+	if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+		text = strconv.Quote(text)
+	}
+	line.WriteString(name)
+	line.WriteByte('=')
+	line.WriteString(text)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// Log output collected for a test, which background goroutines may write to
+// while the test reads it
+type logCapture struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (c *logCapture) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.buf.Write(p)
+}
+
+// Whether a logged line has want in it
+func (c *logCapture) has(want string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, line := range strings.Split(c.buf.String(), "\n") {
+		if strings.Contains(line, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *logCapture) String() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.buf.String()
+}
+
+// Collect the log until the test ends, without timestamps
+func captureLog(t *testing.T) *logCapture {
+	capture := &logCapture{}
+	flags := log.Flags()
+	log.SetOutput(capture)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(io.Discard)
+		log.SetFlags(flags)
+	})
+	return capture
+}
+
+func TestLogGameEventTagsValues(t *testing.T) {
+	capture := captureLog(t)
+	logGameEvent("o brien", "room:ABCD", map[string]any{"event": "draw", "remaining": 7, "card": "Beard Cat", "bottom": false, "note": ""})
+	if want := `event=draw user="o brien" game=room:ABCD bottom=false card="Beard Cat" note="" remaining=7` + "\n"; capture.String() != want {
+		t.Fatalf("logged %q, want %q", capture, want)
+	}
+}
+
+func TestDrawAndStatsLogsTagValues(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		capture := captureLog(t)
+
+		ts.startGame("alice", "Cat", engine.ExplodingKitten)
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		ts.startGame("bob", engine.ExplodingKitten, "Cat")
+		decodeOK[DrawCardResponse](t, ts.draw("bob"))
+		ts.clock.Advance(ts.revealDelay)
+
+		for _, want := range []string{
+			"event=draw user=alice game=alice bottom=false card=Cat remaining=1",
+			"event=stats user=alice game=alice streak=1 win=1",
+			"event=draw user=bob game=bob bottom=false card=\"Exploding Kitten\" remaining=1",
+			"event=exploded user=bob game=bob defuses=0",
+			"event=stats user=bob game=bob lose=1",
+		} {
+			if !capture.has(want) {
+				t.Errorf("no line with %q in:\n%s", want, capture)
+			}
+		}
+	})
+}
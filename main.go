@@ -2,510 +2,1370 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
-	"math/rand"
-	"time"
+	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/websocket"
-)
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
-
-type Card struct {
-	Type  string `json:"type"`
-	Emoji string `json:"emoji"`
-}
+	"exploding-kitten/engine"
+)
 
 type User struct {
-	Username string `json:"username"`
+	Username string `json:"username" binding:"required"`
+	GameID   string `json:"gameId"`
+	// Idempotency key for /draw-card, as an alternative to the header
+	RequestID string `json:"requestId,omitempty"`
+	// Mode of a new game, for /start-game: "classic" (the default) or
+	// "survival"
+	Mode string `json:"mode,omitempty"`
 }
-// Example cards (deck)
-var cards = []Card{
-	{"Cat", "😼"},
-	{"Defuse", "🙅‍♂"},
-	{"Shuffle", "🔀"},
-	{"Exploding Kitten", "💣"},
+
+// Server carries the dependencies shared by the handlers
+type Server struct {
+	store GameStore
+	hub   *Hub
+	clock Clock
+	// What the server was built from, for GET /admin/config
+	config Config
+
+	// How long the other player has to Nope an action card
+	nopeWindow time.Duration
+	// Cards a hand may hold before the player has to discard
+	maxHandSize int
+	// Moves after a Shuffle before another one is allowed
+	shuffleCooldown int
+	// How long sockets see a drawn bomb face down before its outcome
+	revealDelay time.Duration
+	// How long a game's end may sit in the outbox unannounced before this
+	// instance takes it for lost, and the entries it takes at a time
+	outboxClaimAfter time.Duration
+	outboxBatch      int
+	// Longest a cached leaderboard is served without a rebuild
+	leaderboardMaxStaleness time.Duration
+	// How long a draw may take before it is logged as slow
+	drawBudget time.Duration
+	// How long a finished game is kept before it is swept
+	finishedRetention time.Duration
+	// How long a solo draw waits to be confirmed before it goes back on the
+	// deck
+	pendingDrawTimeout time.Duration
+	// Action cards waiting out their Nope window, keyed by room code
+	pending      map[string]*pendingAction
+	pendingMutex sync.Mutex
+	// Turn clocks of active rooms, keyed by room code, and the clocks of
+	// bombs waiting on a decision, keyed by game ID
+	turnTimers map[string]Timer
+	bombTimers map[string]Timer
+	// Ends rooms' pauses once they run out, keyed by room code
+	pauseTimers map[string]Timer
+	// Forfeit tournament players who never showed up, keyed by room code
+	noShowTimers map[string]Timer
+	timerMutex   sync.Mutex
+	// How long a room's game may be paused in all
+	maxRoomPause time.Duration
+	// How long a tournament player has to show up for their match
+	noShowTimeout time.Duration
+	// How long a player has to decide about a bomb before their Defuse is
+	// used for them
+	bombTimeout time.Duration
+	// End a survival run as soon as only bombs are left and the player has
+	// no Defuse, rather than on the draw that can only blow them up
+	fastForwardInevitable bool
+	// Lock each player's game to the device that last moved in it, for
+	// gameLockTTL after the move
+	gameLocks   bool
+	gameLockTTL time.Duration
+	// How long a request may hold the lock on creating a player's game
+	gameCreationLockTTL time.Duration
+	// Caps on each user's games in progress and games started per UTC day
+	maxActiveGames int64
+	dailyGameQuota int64
+	// Seconds a room player has to move unless the room says otherwise
+	turnTimeout int
+	// The deck a classic solo game is dealt
+	soloDeck DeckConfig
+	// How long a guest's session, a draw's idempotency key and a player's
+	// presence last
+	sessionTTL     time.Duration
+	idempotencyTTL time.Duration
+	presenceTTL    time.Duration
+	// How often sockets are pinged
+	pingInterval time.Duration
+
+	// Words masked in room chat; nil masks nothing
+	chatFilter  *regexp.Regexp
+	chatLimiter chatLimiter
+
+	// Bearer token for the /admin routes; empty keeps them closed
+	adminToken string
+	// API keys looked up lately, how long they are trusted, and the
+	// requests per minute each key may make
+	apiKeys        apiKeyCache
+	apiKeyCacheTTL time.Duration
+	apiKeyLimiter  apiKeyLimiter
+	apiKeyRate     int
+	// HMAC key signing invite tokens
+	inviteSecret []byte
+	// Register the /debug routes, which reveal bomb positions
+	debug bool
+
+	// Browser origins allowed by CORS and the WebSocket upgrader
+	allowedOrigins []string
+	upgrader       websocket.Upgrader
+
+	// Delivers game events to EVENT_WEBHOOK_URL; nil when it isn't set
+	webhook *webhookSender
+	// Flags players whose stats look impossible
+	cheats *cheatDetector
+	// Trips when Redis stops answering; nil for stores without one
+	breaker *circuitBreaker
+
+	leaderboard *leaderboardCache
+	// Games already brought up to the current schema; see upgradeGame
+	migrated migratedGames
+	// The last check of the stored data; see checkConsistency
+	consistency consistencyState
 }
 
-var ctx = context.Background()
+// Build a server configured by config, as loadConfig returns it. Without an
+// INVITE_SECRET, invites are signed with a key of this process's own.
+func newServer(store GameStore, config Config) *Server {
+	hub := newHub()
+	hub.history = store
+	hub.pool.workers = config.BroadcastWorkers
+	hub.maxConns = config.WSMaxConnections
+	hub.maxUserConns = config.WSMaxConnectionsPerUser
+	s := &Server{
+		store:           store,
+		hub:             hub,
+		clock:           realClock{},
+		config:          config,
+		nopeWindow:      config.NopeWindow,
+		maxHandSize:     config.MaxHandSize,
+		shuffleCooldown: config.ShuffleCooldown,
+		revealDelay:     config.RevealDelay,
+		drawBudget:      config.DrawLatencyBudget,
+		pending:         make(map[string]*pendingAction),
+		turnTimers:      make(map[string]Timer),
+		bombTimers:      make(map[string]Timer),
+		pauseTimers:     make(map[string]Timer),
+		maxRoomPause:    config.RoomMaxPause,
+		noShowTimers:    make(map[string]Timer),
+		noShowTimeout:   config.TournamentNoShowTimeout,
+		bombTimeout:     config.BombDecisionTimeout,
+		apiKeyCacheTTL:  config.APIKeyCacheTTL,
+		apiKeyRate:      config.APIKeyRate,
+		gameLocks:       config.GameLocks,
+		gameLockTTL:     config.GameLockTTL,
+		maxActiveGames:  config.MaxActiveGames,
+		dailyGameQuota:  config.DailyGameQuota,
+		turnTimeout:     config.TurnTimeout,
+		soloDeck:        config.SoloDeck,
+		sessionTTL:      config.SessionTTL,
+		idempotencyTTL:  config.IdempotencyTTL,
+		presenceTTL:     config.PresenceTTL,
+		pingInterval:    config.PingInterval,
+
+		fastForwardInevitable: config.FastForwardInevitable,
+		finishedRetention:     config.FinishedGameRetention,
+		allowedOrigins:        config.AllowedOrigins,
+		adminToken:            config.AdminToken,
+		debug:                 config.debugRoutes(),
+		leaderboard:           &leaderboardCache{},
+		cheats:                newCheatDetector(config.Cheats),
+		inviteSecret:          []byte(config.InviteSecret),
+		chatFilter:            chatFilter(config.ChatBlocklist),
+
+		gameCreationLockTTL:     config.GameCreationLockTTL,
+		outboxClaimAfter:        config.OutboxClaimAfter,
+		outboxBatch:             config.OutboxBatch,
+		pendingDrawTimeout:      config.PendingDrawTimeout,
+		leaderboardMaxStaleness: config.LeaderboardMaxStaleness,
+	}
+	if config.InviteSecret == "" {
+		s.inviteSecret = newInviteSecret()
+	}
+	s.upgrader = websocket.Upgrader{
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		EnableCompression: true,
+		CheckOrigin:       s.checkWebSocketOrigin,
+	}
+	return s
+}
 
+// Connect to the Redis at REDIS_ADDR and check that it answers. Given the
+// seed nodes of a Redis Cluster, connect to the cluster instead.
+func connectRedis(ctx context.Context, config Config) redis.UniversalClient {
+	var rdb redis.UniversalClient
+	if len(config.RedisClusterAddrs) > 0 {
+		rdb = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    config.RedisClusterAddrs,
+			Password: config.RedisPassword,
+		})
+	} else {
+		rdb = redis.NewClient(&redis.Options{
+			Addr:     config.RedisAddr,
+			Password: config.RedisPassword,
+			DB:       0, // Use default DB
+		})
+	}
+	log.Println("Connected to Redis")
 
-var rdb *redis.Client
-var upgrader = websocket.Upgrader{
-    ReadBufferSize:  1024,
-    WriteBufferSize: 1024,
-    CheckOrigin:     func(r *http.Request) bool { return true }, // Allow all origins
+	// Test the Redis connection
+	if _, err := rdb.Ping(ctx).Result(); err != nil {
+		log.Fatalf("Could not connect to Redis: %v", err)
+	}
+	log.Println("Connected to Redis Cloud")
+	return rdb
 }
 
 func main() {
+	// -migrate-prefix moves the keys of a deployment that ran without
+	// KEY_PREFIX under it, then exits
+	migratePrefix := flag.Bool("migrate-prefix", false, "move unprefixed Redis keys under KEY_PREFIX and exit")
+	flag.Parse()
+
 	log.Println("Starting server...")
+	// Cancelled on SIGINT or SIGTERM, which stops the background loops and
+	// shuts the listeners down
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := validateThemes(); err != nil {
+		log.Fatalf("Error registering card themes: %v", err)
+	}
+	config := mustLoadConfig()
+	// Against a cluster the keys are hash-tagged, so the tagged names differ
+	// from the single-node ones
+	keys := keyBuilder{prefix: config.KeyPrefix, cluster: len(config.RedisClusterAddrs) > 0}
+
+	// STORE=memory runs without Redis, for local development. Nothing
+	// survives a restart, and with no pub/sub the instance has to run alone.
+	var rdb redis.UniversalClient
+	var server *Server
+	switch config.Store {
+	case "redis":
+		rdb = connectRedis(ctx, config)
+		store := newRedisStore(rdb)
+		store.retention = config.Retention
+		store.keys = keys
+		if *migratePrefix {
+			migrateKeyPrefix(ctx, store)
+			return
+		}
+		if err := store.MigrateAuditLog(ctx); err != nil {
+			log.Fatalf("Error moving the audit log into a stream: %v", err)
+		}
+		server = newServer(store, config)
+		server.breaker = newCircuitBreaker(server.clock, func(ctx context.Context) error {
+			return rdb.Ping(ctx).Err()
+		})
+		rdb.AddHook(server.breaker)
+		rdb.AddHook(commandTimer{})
+	case "memory":
+		log.Println("Warning: STORE=memory keeps all data in this process; it is lost on restart and not shared with other instances")
+		store := newMemoryStore()
+		store.retention = config.Retention
+		server = newServer(store, config)
+	}
 
-	// Setup Redis
-	rdb = redis.NewClient(&redis.Options{
-        Addr:     "redis-13480.c16.us-east-1-3.ec2.redns.redis-cloud.com:13480", // Redis Cloud endpoint
-        Password: "tdrbW6wUfkTI6rj7YKPdzZBXNKp2KsIb", // Redis Cloud password
-        DB:       0,         // Use default DB
-    })
-	log.Println("Connected to Redis")
+	if config.InviteSecret == "" {
+		log.Println("Warning: INVITE_SECRET is not set; invite links stop working on restart and on other instances")
+	}
 
-	// Test the Redis connection
-    _, err := rdb.Ping(ctx).Result()
-    if err != nil {
-        log.Fatalf("Could not connect to Redis: %v", err)
-    }
-    log.Println("Connected to Redis Cloud")
+	go server.detectCheats(ctx)
 
-	// Setup Gin router
-	router := gin.Default()
+	if config.EventWebhookURL != "" {
+		server.webhook = newWebhookSender(config.EventWebhookURL)
+		go server.webhook.run(ctx)
+	}
+
+	// Share hub broadcasts with the other instances through Redis pub/sub.
+	// The memory store keeps the hub's local bus.
+	if rdb != nil {
+		bus := newRedisBus(rdb, server.hub, keys.prefix)
+		server.hub.bus = bus
+		go bus.run(ctx)
+		// Keep the redis_up gauge current
+		go monitorRedis(server.store, config.RedisMonitorInterval)
+	}
+
+	// Pick up the turn clocks of games left running by the last run
+	if err := server.restoreRooms(ctx); err != nil {
+		log.Printf("Error restoring turn timers: %v", err)
+	}
+
+	// Look over the stored data for what bad deploys left behind, unless
+	// CONSISTENCY_CHECK=false
+	if config.ConsistencyCheck {
+		go server.checkConsistency(ctx)
+	}
+
+	go server.sweepPresence(ctx, config.PresenceSweepInterval)
+	go server.runMatchmaker(ctx, config.MatchmakingInterval)
+	go server.sweepFinishedGames(ctx, config.FinishedGameSweepInterval)
+	go server.runDrawReaper(ctx, config.PendingDrawInterval)
+	go server.runOutbox(ctx, config.OutboxInterval)
+
+	// Run server
+	err := serve(ctx, config.listen(), server.router())
+	// Bombs waiting to be revealed go out now rather than never, and every
+	// queued frame is written before exiting
+	server.hub.flushQueue()
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	server.hub.pool.stop(drainCtx)
+	cancel()
+	if err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+	log.Println("Server stopped")
+}
+
+// Setup Gin router
+func (s *Server) router() *gin.Engine {
+	// accessLogMiddleware takes the place of gin's logger, which has no bodies
+	router := gin.New()
+	router.Use(gin.Recovery())
 
 	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"}, // Frontend origin
+		AllowOriginFunc:  s.originAllowed,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Idempotency-Key", "X-Request-ID", "X-Api-Key", "X-Device-Id"},
+		ExposeHeaders:    []string{"Content-Length", "X-Request-ID"},
 		AllowCredentials: true,
 	}))
 
+	router.Use(requestIDMiddleware())
+	router.Use(accessLogMiddleware())
+	router.Use(metricsMiddleware())
+	router.Use(errorMiddleware())
+	router.Use(s.circuitMiddleware())
+	router.Use(localeMiddleware())
+	router.Use(deviceMiddleware())
+	router.Use(themeMiddleware())
+	router.Use(s.apiKeyAuth())
+
 	// Routes
-	router.POST("/start-game", startGame)
-	router.POST("/draw-card", drawCard)
+	router.POST("/start-game", s.startGame)
+	router.POST("/draw-card", s.drawCard)
+	router.POST("/ack-draw", s.ackDraw)
+	router.POST("/draw-cards", s.drawCards)
+	router.GET("/hand", s.getHand)
+	router.GET("/cards", getCards)
+	router.GET("/odds", s.getOdds)
+	router.GET("/game/:gameId/snapshot", s.getGameSnapshot)
+	router.GET("/game/:gameId/replay", s.getGameReplay)
+	router.GET("/game/:gameId/fairness", s.getGameFairness)
+	router.POST("/create-room", s.createRoom)
+	router.POST("/join-room", s.joinRoom)
+	router.POST("/rooms/:code/invite", s.createInvite)
+	router.DELETE("/rooms/:code/invite", s.revokeInvites)
+	router.POST("/rooms/:code/pause", s.pauseRoom)
+	router.POST("/rooms/:code/resume", s.resumeRoom)
+	router.POST("/matchmake", s.matchmake)
+	router.POST("/tournaments", s.createTournament)
+	router.GET("/tournaments/:id", s.getTournament)
+	router.POST("/tournaments/:id/join", s.joinTournament)
+	router.DELETE("/matchmake", s.leaveMatchmaking)
+	router.POST("/play-card", s.playCard)
+	router.POST("/play-pair", s.playPair)
+	router.POST("/discard", s.discardCard)
+	router.POST("/resolve-bomb", s.resolveBombRoute)
+	router.POST("/forfeit", s.forfeit)
+	router.POST("/takeover", s.takeover)
+	router.POST("/rematch", s.rematch)
+	router.POST("/guest", s.createGuest)
+	router.POST("/claim", s.claimGuest)
+	router.DELETE("/users/me", s.deleteAccount)
+	router.PUT("/profile", s.updateProfile)
+	router.GET("/leaderboard", s.getLeaderboard)
+	router.GET("/achievements/:username", s.getAchievements)
+	router.GET("/h2h/:userA/:userB", s.getHeadToHead)
+	router.GET("/online", s.getOnline)
+	router.GET("/export/leaderboard", s.exportLeaderboard)
+	router.GET("/export/history/:username", s.exportHistory)
+	router.GET("/analytics/summary", s.analyticsAuth(), s.analyticsSummary)
 
 	// WebSocket for real-time updates
-	router.GET("/ws", serveWs)
-
-	// Run server
-	log.Println("Running server on localhost:8080")
-	router.Run("0.0.0.0:8080")
-}
-
-// Initialize a deck for the user
-func initializeDeck(userID string) error {
-	deckKey := "deck:" + userID
+	router.GET("/ws", s.serveWs)
+
+	// Support tools, behind the admin token
+	admin := router.Group("/admin", s.adminAuth())
+	admin.GET("/users/:username", s.adminGetUser)
+	admin.DELETE("/users/:username/game", s.adminResetGame)
+	admin.POST("/users/:username/stats", s.adminSetStats)
+	admin.PUT("/users/:username/limits", s.adminSetLimits)
+	admin.GET("/storage", s.adminStorage)
+	admin.GET("/consistency", s.adminConsistency)
+	admin.GET("/config", s.adminConfig)
+	admin.POST("/consistency", s.adminCheckConsistency)
+	admin.GET("/audit", s.adminAudit)
+	admin.GET("/connections", s.adminConnections)
+	admin.GET("/flagged", s.adminFlagged)
+	admin.DELETE("/users/:username/flag", s.adminUnflag)
+	admin.DELETE("/rooms/:code/invites", s.adminRevokeInvites)
+	admin.POST("/apikeys", s.adminCreateAPIKey)
+	admin.GET("/apikeys", s.adminAPIKeys)
+	admin.DELETE("/apikeys/:id", s.adminRevokeAPIKey)
+	admin.POST("/inject-card", s.adminInjectCard)
+
+	// Development only: left out entirely in production
+	if s.debug {
+		log.Println("Debug routes enabled")
+		router.GET("/debug/deck/:username", s.debugDeck)
+		router.POST("/debug/seed", s.debugSeed)
+		router.DELETE("/debug/seed", s.debugWipeSeed)
+	}
 
-	log.Printf("Initializing deck for user: %s", userID)
+	// Prometheus metrics
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/healthz", s.healthz)
 
-	// Shuffle and add cards to the deck
-	rand.Seed(time.Now().UnixNano())
-	shuffledDeck := []string{
-		"Cat", "Cat", "Defuse", "Shuffle", "Exploding Kitten", // Example deck of 5 cards
-	}
+	// API spec, built from the routes registered above
+	spec := buildOpenAPISpec(append(router.Routes(), gin.RouteInfo{Method: http.MethodGet, Path: "/openapi.json"}))
+	router.GET("/openapi.json", serveOpenAPI(spec))
 
-	// Shuffle the deck (optional)
-	rand.Shuffle(len(shuffledDeck), func(i, j int) {
-		shuffledDeck[i], shuffledDeck[j] = shuffledDeck[j], shuffledDeck[i]
-	})
+	return router
+}
 
-	log.Printf("Shuffled deck for user: %s", userID)
+// Initialize a deck for the user
+func (s *Server) initializeDeck(ctx context.Context, userID, mode string) error {
+	log.Printf("Initializing %s deck for user: %s", mode, userID)
 
-	// Store the entire deck in Redis in one command
-	err := rdb.RPush(ctx, deckKey, shuffledDeck).Err()
-	if err != nil {
+	// Shuffle once here; from now on cards are drawn in list order
+	if _, err := s.dealSeededDeck(ctx, userID, s.soloDeckFor(mode)); err != nil {
 		log.Printf("Error initializing deck for user %s: %v", userID, err)
 		return err
 	}
+	if err := s.store.SetGameStatus(ctx, userID, GameStatusActive); err != nil {
+		log.Printf("Error setting game status for user %s: %v", userID, err)
+		return err
+	}
+	if err := s.store.SetGameMode(ctx, userID, mode); err != nil {
+		log.Printf("Error setting game mode for user %s: %v", userID, err)
+		return err
+	}
+	if err := s.store.MarkGameStarted(ctx, userID, s.clock.Now()); err != nil {
+		log.Printf("Error recording game start for user %s: %v", userID, err)
+		return err
+	}
 
 	log.Printf("Deck initialized for user: %s", userID)
 	return nil
 }
 
 // Start game route
-func startGame(c *gin.Context) {
-	var user User
-	if err := c.BindJSON(&user); err != nil {
-		log.Printf("Error parsing request: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+func (s *Server) startGame(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user, apiErr := bindUser(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	mode, apiErr := parseGameMode(user.Mode)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
 		return
 	}
 
 	log.Printf("Starting game for user: %s", user.Username)
 
-	// Redis key for the user's deck
-	deckKey := "deck:" + user.Username
+	// A request racing this one for the same player finds the game it
+	// created, and resumes it
+	token, apiErr := s.lockGameCreation(ctx, user.Username)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	defer s.unlockGameCreation(user.Username, token)
 
 	// Check if a deck already exists for this user
-	existingDeck, err := rdb.LRange(ctx, deckKey, 0, -1).Result()
-	if err != nil && err != redis.Nil { // Check for Redis errors, ignore if it's a 'nil' error (key doesn't exist)
+	existingDeck, err := s.store.GetDeck(ctx, user.Username)
+	if err != nil {
 		log.Printf("Error checking existing deck for user %s: %v", user.Username, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking existing deck"})
+		abortWithError(c, errStoreUnavailable("Error checking existing deck"))
 		return
 	}
 
-	// If a deck exists, return the existing deck
-	if len(existingDeck) > 0 {
-		log.Printf("Resuming game for user: %s", user.Username)
-		c.JSON(http.StatusOK, gin.H{
-			"message":  "Resuming game",
-			"username": user.Username,
-			"deck":     existingDeck,
-		})
+	status, err := s.store.GetGameStatus(ctx, user.Username)
+	if err != nil {
+		log.Printf("Error checking game status for user %s: %v", user.Username, err)
+		abortWithError(c, errStoreUnavailable("Error checking game status"))
+		return
+	}
+
+	// If a deck exists, resume the game, whatever mode was asked for, unless
+	// it was dealt from a deck no longer dealt. The leftovers of a finished
+	// or stale game are replaced by a new one.
+	game := &GameSession{ID: user.Username, Username: user.Username}
+	stale := false
+	if len(existingDeck) > 0 && !gameOver(status) {
+		resume, apiErr := s.resumable(ctx, game, existingDeck)
+		if apiErr != nil {
+			abortWithError(c, apiErr)
+			return
+		}
+		if resume {
+			log.Printf("Resuming game for user: %s", user.Username)
+			s.respondStartGame(c, game, "Resuming game", false)
+			return
+		}
+		stale = true
+	}
+
+	// A new game needs a slot under the player's caps
+	if apiErr := s.claimGameSlot(ctx, user.Username, game.ID); apiErr != nil {
+		abortWithError(c, apiErr)
 		return
 	}
 
 	// If no deck exists, initialize a new one
-	err = initializeDeck(user.Username)
+	err = s.initializeDeck(ctx, user.Username, mode)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error initializing deck"})
+		abortWithError(c, errStoreUnavailable("Error initializing deck"))
 		return
 	}
 
-	// Retrieve the newly initialized deck from Redis
-	newDeck, err := rdb.LRange(ctx, deckKey, 0, -1).Result()
-	if err != nil {
-		log.Printf("Error retrieving new deck for user %s: %v", user.Username, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving new deck"})
+	// Cards held from a previous game don't carry over
+	if err := s.store.ClearHand(ctx, user.Username); err != nil {
+		log.Printf("Error clearing hand for user %s: %v", user.Username, err)
+		abortWithError(c, errStoreUnavailable("Error clearing hand"))
 		return
 	}
 
-	rdb.HSet(ctx, "win", user.Username, 0);
-	rdb.HSet(ctx, "lose", user.Username, 0);
+	if err := s.store.ResetStats(ctx, user.Username, s.newAuditEntry(ctx, user.Username, "reset_stats", user.Username)); err != nil {
+		log.Printf("Error resetting stats for user %s: %v", user.Username, err)
+		abortWithError(c, errStoreUnavailable("Error resetting stats"))
+		return
+	}
+	s.leaderboard.invalidate()
+
+	gamesStartedTotal.Inc()
 
 	log.Printf("Game started for user: %s", user.Username)
-	c.JSON(http.StatusOK, gin.H{
-		"message":  "Game started",
-		"username": user.Username,
-		"deck":     newDeck,
-	})
+	if stale {
+		s.respondStartGame(c, game, "Your unfinished game no longer matched the deck we deal, so a new one was started", true)
+		return
+	}
+	s.respondStartGame(c, game, "Game started", false)
 }
 
-func drawCard(c *gin.Context) {
-	var user User
-	if err := c.BindJSON(&user); err != nil {
-		log.Printf("Error parsing request: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+// Answer /start-game with the game's snapshot
+func (s *Server) respondStartGame(c *gin.Context, game *GameSession, message string, staleDiscarded bool) {
+	snapshot, apiErr := s.gameSnapshot(c.Request.Context(), game)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
 		return
 	}
+	c.JSON(http.StatusOK, StartGameResponse{
+		Message:  message,
+		Username: game.Username,
+		GameID:   game.ID,
+		Snapshot: snapshot,
+
+		StaleGameDiscarded: staleDiscarded,
+	})
+}
+
+// The game a player is acting on: their solo game or a room they are in
+type GameSession struct {
+	ID       string
+	Username string
+	Room     *Room
+	// ModeClassic or ModeSurvival, once loadMode has read it
+	Mode string
+	// What the draw in progress read before taking its card; see
+	// loadDrawState
+	draw *DrawState
+	// How the game went for each of its human players, once completeGame
+	// has ended it
+	summaries map[string]*GameSummary
+	// Whether the draw in progress is held until the player confirms it,
+	// and the idempotency key of its request; see PendingDraw
+	holdDraw bool
+	drawKey  string
+	// The outbox entry announcing the game's end, once completeGame has
+	// ended it
+	outboxID string
+}
 
-	log.Printf("User %s is drawing a card", user.Username)
+// Resolve the game a request refers to. Requests without a gameId act on the
+// player's solo game.
+func (s *Server) resolveGame(ctx context.Context, user User) (*GameSession, *APIError) {
+	s.touchPresence(ctx, user.Username)
+	if user.GameID == "" || user.GameID == user.Username {
+		game := &GameSession{ID: user.Username, Username: user.Username}
+		if apiErr := s.upgradeGame(ctx, game); apiErr != nil {
+			return nil, apiErr
+		}
+		return game, nil
+	}
 
-	// Retrieve the deck for the user from Redis
-	deckKey := "deck:" + user.Username
-	deck, err := rdb.LRange(ctx, deckKey, 0, -1).Result()
+	code, ok := roomCodeFromGameID(user.GameID)
+	if !ok {
+		return nil, errInvalidRequest("Unknown gameId")
+	}
+	room, err := s.store.GetRoom(ctx, code)
 	if err != nil {
-		log.Printf("Error retrieving deck for user %s: %v", user.Username, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error retrieving deck"})
-		return
+		log.Printf("Error retrieving room %s: %v", code, err)
+		return nil, errStoreUnavailable("Error retrieving room")
 	}
+	if room == nil {
+		return nil, errRoomNotFound()
+	}
+	if !room.hasPlayer(user.Username) {
+		return nil, errNotInRoom()
+	}
+	game := &GameSession{ID: room.gameID(), Username: user.Username, Room: room}
+	if apiErr := s.upgradeGame(ctx, game); apiErr != nil {
+		return nil, apiErr
+	}
+	return game, nil
+}
 
-	if len(deck) == 0 {
-		updateUserStats(user.Username , true);
+func (s *Server) drawCard(c *gin.Context) {
+	ctx := c.Request.Context()
 
-		log.Printf("No cards left in the deck for user: %s", user.Username)
-		c.JSON(http.StatusBadRequest, gin.H{"message": "No cards left in the deck"})
+	user, apiErr := bindUser(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
 		return
-	}	
+	}
 
-	// Randomly select a card index
-	rand.Seed(time.Now().UnixNano())
-	cardIndex := rand.Intn(len(deck))
-	drawnCard := deck[cardIndex]
+	game, apiErr := s.resolveMove(ctx, user)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	// A retried request gets the first draw's response instead of a second card
+	key := requestIdempotencyKey(c, user)
+	game.holdDraw, game.drawKey = true, key
+	s.respondIdempotent(c, game.ID, key, func() (interface{}, *APIError) {
+		response, apiErr := s.drawTurn(ctx, game)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		addLegacyCardText(c, response)
+		return response, nil
+	})
+}
 
-	log.Printf("User %s drew card: %s", user.Username, drawnCard)
+// Take the player's draw for the turn. In a room only the player whose turn
+// it is may draw.
+func (s *Server) drawTurn(ctx context.Context, game *GameSession) (*DrawCardResponse, *APIError) {
+	ctx, done := s.timeDraw(ctx, game)
+	defer done()
 
-	// Remove the drawn card from the Redis list
-	_, err = rdb.LRem(ctx, deckKey, 1, drawnCard).Result()
+	if apiErr := s.checkCanDraw(ctx, game); apiErr != nil {
+		return nil, apiErr
+	}
+	if game.Room != nil {
+		if apiErr := s.checkTurn(game.Room, game.Username); apiErr != nil {
+			return nil, apiErr
+		}
+		if apiErr := s.claimTurn(ctx, game.Room); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	return s.performDraw(ctx, game, false)
+}
+
+// Draw the top card of the game's deck (or the bottom one, for Draw From
+// Bottom) and resolve it. Humans and bots both draw through here.
+func (s *Server) performDraw(ctx context.Context, game *GameSession, fromBottom bool) (*DrawCardResponse, *APIError) {
+	log.Printf("User %s is drawing a card", game.Username)
+
+	hold := s.drawHold(game)
+	var drawn DrawnCard
+	raced, err := s.drawAtVersion(ctx, game, func(version int64) error {
+		var err error
+		drawn, err = s.store.DrawCard(ctx, game.ID, game.Username, version, fromBottom, hold)
+		return err
+	})
+	if err == errVersionConflict {
+		return nil, errConflict()
+	}
 	if err != nil {
-		log.Printf("Error removing card from deck for user %s: %v", user.Username, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error removing card from deck"})
-		return
+		log.Printf("Error drawing card for user %s: %v", game.Username, err)
+		return nil, errStoreUnavailable("Error drawing card")
 	}
 
+	drawnCard, remaining := drawn.Card, drawn.Remaining
+	if drawnCard == "" {
+		// The draw that beat this one to the last card settles the game
+		if raced {
+			return nil, errConflict()
+		}
+		return nil, s.handleEmptyDeck(ctx, game)
+	}
+
+	logGameEvent(game.Username, game.ID, map[string]any{"event": "draw", "card": drawnCard, "bottom": fromBottom, "remaining": remaining})
+
+	s.notifyCardDrawn(ctx, game, drawnCard, remaining)
+
 	// Call the function to handle the drawn card
-	handleDrawnCard(c, drawnCard, user.Username)
-}
-
-func updateUserStats(username string, isWin bool) {
-    // Initialize winCount and loseCount
-    var winCount, loseCount int
-
-    if isWin {
-        // Retrieve the current win count for the user
-        winStr, err := rdb.HGet(ctx, "win", username).Result()
-        if err != nil {
-            if err == redis.Nil {
-                winCount = 0 // No wins recorded yet
-            } else {
-                log.Printf("Error retrieving win count for user %s: %v", username, err)
-                return // Exit on error
-            }
-        } else {
-            winCount, err = strconv.Atoi(winStr)
-            if err != nil {
-                log.Printf("Error converting win count to integer for user %s: %v", username, err)
-                winCount = 0 // Default to 0 on conversion error
-            }
-        }
-
-        // Increment the win count by 1 for the current match
-        newWinCount := winCount + 1
-
-		log.Printf("Count Username : %s" , username)
-		log.Printf("Previous : " , winCount , "Now : " , newWinCount)
-        if err := rdb.HSet(ctx, "win", username, newWinCount).Err(); err != nil {
-            log.Printf("Error updating win count for user %s: %v", username, err)
-            return // Exit on error
-        }
-        log.Printf("User %s has now won %d times", username, newWinCount)
-
-    } else {
-        // Fetch the current lose count for the user
-    loseStr, err := rdb.HGet(ctx, "lose", username).Result()
-    log.Printf("Loadstr for user %s: %s", username, loseStr)
-
-    if err != nil {
-        if err == redis.Nil {
-            log.Printf("User %s not found in 'lose' hash.", username)
-            loseCount = 0 // User not found, default to 0
-        } else {
-            log.Printf("Error retrieving lose count for user %s: %v", username, err)
-            return // Exit on error
-        }
-    } else {
-        // Successfully retrieved loseStr, convert to int
-        loseCount, err = strconv.Atoi(loseStr)
-        if err != nil {
-            log.Printf("Error converting lose count to integer for user %s: %v", username, err)
-            loseCount = 0 // Default to 0 on conversion error
-        }
-    }
-
-    // Increment the lose count by 1 for the current match
-    newLoseCount := loseCount + 1 // Increment the count
-    log.Printf("Count for Username %s: %d", username, loseCount)
-    log.Printf("Previous : %d Now : %d", loseCount, newLoseCount)
-
-    // Update the lose count in Redis
-    if err := rdb.HSet(ctx, "lose", username, newLoseCount).Err(); err != nil {
-        log.Printf("Error updating lose count for user %s: %v", username, err)
-        return // Exit on error
-    }
-    log.Printf("User %s has now lost %d times", username, newLoseCount)
-
-    }
-}
-
-func handleDrawnCard(c *gin.Context, drawnCard string, username string) {
-	var emoji string
-	var cardType string
+	response, apiErr := s.handleDrawnCard(ctx, drawn, game)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	// Only a draw that left nothing but bombs can have settled the game
+	switch response.GameStatus {
+	case GameStatusActive, GameStatusMustDiscard, GameStatusPendingDefuse:
+		if drawn.Cleared {
+			response.InevitableLoss = s.lossForced(ctx, game, response.GameStatus == GameStatusPendingDefuse)
+		}
+	}
+	if response.GameStatus == GameStatusActive && s.shouldFastForward(game, response.InevitableLoss, response.DefuseCount) {
+		if apiErr := s.fastForwardLoss(ctx, game, response); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	action := MoveDraw
+	if fromBottom {
+		action = MoveDrawBottom
+	}
+	s.recordMove(ctx, game, action, drawnCard, response.GameStatus)
+
+	// A defused bomb goes back in, and a solo reshuffle deals a new deck
+	response.Remaining = remaining
+	switch {
+	case response.Disposition == DispositionDefused:
+		response.Remaining++
+	case drawnCard == engine.Shuffle:
+		if deck, err := s.store.GetDeck(ctx, game.ID); err == nil {
+			response.Remaining = len(deck)
+		}
+	}
+	if hold != nil && !heldDrawPending(response) {
+		if apiErr := s.confirmDraw(ctx, game); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	response.Version = s.gameVersion(ctx, game.ID)
+	response.Summary = game.summaries[game.Username]
+	return response, nil
+}
+
+// Draws on one game retried after losing a race to another change of it
+const drawRetries = 3
+
+// Run a draw against the game's current version, re-reading the game's draw
+// state and retrying when another draw or status change got in first. The
+// first attempt uses the state the draw's checks read, if they did. Returns
+// whether it had to retry, and errVersionConflict if it keeps losing.
+func (s *Server) drawAtVersion(ctx context.Context, game *GameSession, draw func(version int64) error) (bool, error) {
+	for attempt := 0; attempt < drawRetries; attempt++ {
+		if game.draw == nil || attempt > 0 {
+			state, err := s.store.DrawState(ctx, game.ID, game.Username)
+			if err != nil {
+				return attempt > 0, err
+			}
+			game.draw = state
+		}
+		if err := draw(game.draw.Version); err != errVersionConflict {
+			return attempt > 0, err
+		}
+		log.Printf("Draw on game %s conflicted at version %d, retrying", game.ID, game.draw.Version)
+	}
+	return true, errVersionConflict
+}
+
+// The game's version for a response. A failed lookup only loses the field.
+func (s *Server) gameVersion(ctx context.Context, gameID string) int64 {
+	version, err := s.store.GameVersion(ctx, gameID)
+	if err != nil {
+		log.Printf("Error retrieving version of game %s: %v", gameID, err)
+	}
+	return version
+}
+
+// Drawing from an empty solo deck means the player survived every card and wins.
+// A room whose deck runs out ends without a winner.
+func (s *Server) handleEmptyDeck(ctx context.Context, game *GameSession) *APIError {
+	log.Printf("No cards left in the deck for game: %s", game.ID)
+
+	if game.Room != nil {
+		if err := s.finishRoom(ctx, game.Room); err != nil {
+			return errStoreUnavailable("Error finishing game")
+		}
+		// The room hears it in English; the player in their own language
+		message := messageCatalog[defaultLocale][MsgDeckEmptyDraw]
+		s.hub.broadcastRoom(game.Room.Code, RoomEvent{Type: "game_over", Username: game.Username, Message: message})
+		return errDeckEmpty(localize(ctx, MsgDeckEmptyDraw))
+	}
+
+	// Survival runs end on a bomb, never by outlasting the deck
+	if apiErr := s.loadMode(ctx, game); apiErr != nil {
+		return apiErr
+	}
+	if game.Mode == ModeSurvival {
+		return errDeckEmpty(localize(ctx, MsgDeckEmpty))
+	}
+
+	_, message, apiErr := s.winSoloGame(ctx, game)
+	if apiErr != nil {
+		return apiErr
+	}
+	return errDeckEmpty(localize(ctx, MsgDeckEmpty) + " " + message)
+}
+
+// End a solo game as won and return the ID and text of what to tell the player
+func (s *Server) winSoloGame(ctx context.Context, game *GameSession) (string, string, *APIError) {
+	hand, err := s.store.GetHand(ctx, game.Username)
+	if err != nil {
+		log.Printf("Error retrieving hand for user %s: %v", game.Username, err)
+		return "", "", errStoreUnavailable("Error retrieving hand")
+	}
+
+	completion, apiErr := s.completeGame(ctx, game, gameOutcome{Winner: game.Username})
+	if apiErr != nil {
+		return "", "", apiErr
+	}
+
+	s.announceGameOver(ctx, game, gameOutcome{Winner: game.Username})
+	s.reportGameFinished(ctx, game, game.Username, "win")
+
+	if len(hand) == 0 {
+		return MsgWinEmptyHand, localize(ctx, MsgWinEmptyHand, completion.Wins), nil
+	}
+	return MsgWinHolding, localize(ctx, MsgWinHolding, describeHand(ctx, hand), completion.Wins), nil
+}
+
+// Summarize a hand as e.g. "2 Cat, 1 Defuse", in the locale attached to ctx
+func describeHand(ctx context.Context, hand []string) string {
+	counts := make(map[string]int)
+	var order []string
+	for _, card := range hand {
+		if counts[card] == 0 {
+			order = append(order, card)
+		}
+		counts[card]++
+	}
+
+	parts := make([]string, len(order))
+	for i, card := range order {
+		parts[i] = fmt.Sprintf("%d %s", counts[card], localCardName(ctx, card))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (s *Server) handleDrawnCard(ctx context.Context, drawn DrawnCard, game *GameSession) (*DrawCardResponse, *APIError) {
+	username := game.Username
 
 	// Find the emoji and card type based on the drawn card
-	for _, card := range cards {
-		if card.Type == drawnCard {
-			cardType = card.Type
-			emoji = card.Emoji
+	card := cardFor(cardTheme(ctx, game), drawn.Card)
+	cardType := card.Type
+
+	log.Printf("Handling card for user %s: %s (%s)", username, cardType, card.Emoji)
+	drawsTotal.WithLabelValues(cardType).Inc()
+
+	response := &DrawCardResponse{Card: card, GameStatus: GameStatusActive, Effects: []DrawEffect{}}
+	if apiErr := s.loadMode(ctx, game); apiErr != nil {
+		return nil, apiErr
+	}
+
+	// The engine decides what the card does; this persists and announces it.
+	// Only a bomb about to be defused needs the deck, and the game's seeded
+	// source, to pick where it goes back in.
+	defuseCount, apiErr := s.pooledDefuses(ctx, game, game.draw.Defuses)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if cardType == engine.ExplodingKitten && defuseCount > 0 && !isBot(username) {
+		// The player chooses whether to spend their Defuse on it
+		return s.holdBomb(ctx, game, response, defuseCount)
+	}
+	rules := &engine.Game{DefuseCount: defuseCount, Mode: game.Mode}
+	var rng engine.RNG
+	if cardType == engine.ExplodingKitten && defuseCount > 0 {
+		deck, err := s.store.GetDeck(ctx, game.ID)
+		if err != nil {
+			log.Printf("Error retrieving deck for game %s: %v", game.ID, err)
+			return nil, errStoreUnavailable("Error retrieving deck")
+		}
+		rules.Deck = deck
+		if rng, err = s.gameRNG(ctx, game.ID); err != nil {
+			log.Printf("Error retrieving the seeded source of game %s: %v", game.ID, err)
+			return nil, errStoreUnavailable("Error retrieving game")
+		}
+	}
+
+	event := rules.Settle(cardType, cardEffect(cardType), rng)
+	if rng != nil {
+		if err := s.saveGameRNG(ctx, game.ID, rng); err != nil {
+			log.Printf("Error saving the seeded source of game %s: %v", game.ID, err)
+			return nil, errStoreUnavailable("Error updating game")
+		}
+	}
+	response.Disposition = dispositionOf(event.Type)
+	switch event.Type {
+	case engine.BombDefused:
+		// Spend the held Defuse and put the bomb back
+		left, err := s.spendDefuse(ctx, game, defuseCount)
+		if err != nil {
+			log.Printf("Error using defuse for user %s: %v", username, err)
+			return nil, errStoreUnavailable("Error updating defuse status")
+		}
+		logGameEvent(username, game.ID, map[string]any{"event": "bomb_defused", "defusesBefore": defuseCount, "defusesLeft": left})
+		if err := s.store.InsertCard(ctx, game.ID, cardType, event.Position); err != nil {
+			log.Printf("Error putting the bomb back into game %s: %v", game.ID, err)
+			return nil, errStoreUnavailable("Error updating deck")
+		}
+		response.Effects = append(response.Effects, defuseEffect(EffectDefuseConsumed, left), DrawEffect{Type: EffectBombReturned})
+
+		// Send a response back to the user confirming they defused the bomb
+		response.MessageID = MsgBombDefused
+		response.Message = localize(ctx, MsgBombDefused)
+
+	case engine.Exploded:
+		logGameEvent(username, game.ID, map[string]any{"event": "exploded", "defuses": defuseCount})
+		// The room hears of the draw before the game over it causes
+		response.Effects = append(response.Effects, DrawEffect{Type: EffectGameLost})
+		s.announceDraw(game, response)
+		explosion, apiErr := s.handleExplosion(ctx, game, card)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		explosion.Disposition = response.Disposition
+		explosion.Effects = response.Effects
+		return explosion, nil
+
+	case engine.Reshuffle:
+		log.Printf("User %s drew a Shuffle card", username)
+
+		// Too soon after the last Shuffle the card is spent for nothing
+		claimed, apiErr := s.claimShuffle(ctx, game)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if !claimed {
+			response.MessageID = MsgShuffleCooling
+			response.Message = localize(ctx, MsgShuffleCooling)
+			response.Effects = append(response.Effects, DrawEffect{Type: EffectShuffleCooling})
 			break
 		}
+		if err := s.reshuffle(ctx, game); err != nil {
+			return nil, errStoreUnavailable("Error reshuffling deck")
+		}
+		response.Effects = append(response.Effects, reshuffleEffect(game))
+
+		response.MessageID = MsgReshuffled
+		response.Message = localize(ctx, MsgReshuffled)
+
+	case engine.CardHeld:
+		log.Printf("User %s drew a %s card", username, cardType)
+
+		if err := s.store.HoldCard(ctx, username, cardType); err != nil {
+			log.Printf("Error adding card to hand for user %s: %v", username, err)
+			return nil, errStoreUnavailable("Error adding card to hand")
+		}
+		if cardType == engine.Defuse {
+			response.Effects = append(response.Effects, defuseEffect(EffectDefuseGained, rules.DefuseCount))
+		}
+
+		response.MessageID = heldCardMessage(cardType)
+		response.Message = localize(ctx, response.MessageID, localCardName(ctx, cardType))
 	}
 
-	log.Printf("Handling card for user %s: %s (%s)", username, cardType, emoji)
+	// A classic solo player who has drawn everything but the bombs has won,
+	// and so have a pair of co-op players
+	response.DefuseCount = rules.DefuseCount
+	if game.Room != nil && game.Room.coop() && event.Type != engine.Reshuffle && drawn.Cleared {
+		// The room hears of the draw before the game over it causes
+		response.Effects = append(response.Effects, DrawEffect{Type: EffectGameWon})
+		s.announceDraw(game, response)
+		completion, apiErr := s.winCoopGame(ctx, game)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		response.MessageID = MsgDeckCleared
+		response.Message += " " + localize(ctx, MsgDeckCleared) + " " + localize(ctx, MsgCoopWin, coopPartner(game), completion.Wins)
+		response.GameStatus = GameStatusWon
+		return response, nil
+	}
+	if game.Room == nil && event.Type != engine.Reshuffle && game.Mode != ModeSurvival && drawn.Cleared {
+		_, message, apiErr := s.winSoloGame(ctx, game)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		response.MessageID = MsgDeckCleared
+		response.Message += " " + localize(ctx, MsgDeckCleared) + " " + message
+		response.GameStatus = GameStatusWon
+		response.Effects = append(response.Effects, DrawEffect{Type: EffectGameWon})
+	}
 
-	switch cardType {
-	case "Exploding Kitten":
-		// Check if the user has a defuse card
-		hasDefuse, err := rdb.HGet(ctx, "user:"+username, "defuse").Result()
-		if err != nil {
-			log.Printf("Error retrieving defuse status for user %s: %v", username, err)
+	// The room learns of a full hand from the discard_required event
+	s.announceDraw(game, response)
+	if event.Type == engine.BombDefused && game.Room != nil {
+		s.hub.broadcastRoomAfter(s.revealDelay, game.Room.Code, RoomEvent{Type: "bomb_defused", Username: username, Card: findCard(cardTheme(ctx, game), cardType)})
+	}
+
+	// A card that took the hand past the limit blocks the game until the
+	// player discards
+	if event.Type == engine.CardHeld && response.GameStatus == GameStatusActive {
+		hand, blocked, apiErr := s.enforceHandLimit(ctx, game)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if blocked {
+			response.GameStatus = GameStatusMustDiscard
+			response.Hand = cardsFor(cardTheme(ctx, game), hand)
+			response.Effects = append(response.Effects, DrawEffect{Type: EffectHandFull})
 		}
+	}
 
-		log.Printf("Can defuse : %t" , hasDefuse)
+	// Pass the turn to the next player still in the game, unless an Attack
+	// left this one owing more draws
+	if _, err := s.finishDraws(ctx, game, 1); err != nil {
+		log.Printf("Error ending turn of user %s in game %s: %v", username, game.ID, err)
+		return nil, errStoreUnavailable("Error ending turn")
+	}
 
-		defuseCount, _ := strconv.Atoi(hasDefuse)
-		
-		if defuseCount > 0 {  // If user has defuse card
-			log.Printf("User %s used a Defuse card to defuse the Exploding Kitten!", username)
-			
-			// Set the defuse status to false in Redis
-			rdb.HSet(ctx, "user:"+username, "defuse" , 0)
-	
-			// Send a response back to the user confirming they defused the bomb
-			c.JSON(http.StatusOK, gin.H{"message": "You defused the Exploding Kitten using your Defuse card!", "card": emoji})
-			return
+	return response, nil
+}
+
+// The message ID of what the player is told about a card that went into
+// their hand. Every one of them takes the card's name.
+func heldCardMessage(cardType string) string {
+	switch {
+	case cardType == engine.Defuse:
+		return MsgDefuseHeld
+	case cardPlayable(cardType):
+		// Action cards are kept until the player chooses to play them
+		return MsgActionCardHeld
+	}
+	return MsgCardHeld
+}
+
+// The player drew a bomb without a Defuse and loses. In a room with more
+// than one other player left they are only knocked out; otherwise the last
+// player standing is credited with the win.
+func (s *Server) handleExplosion(ctx context.Context, game *GameSession, card Card) (*DrawCardResponse, *APIError) {
+	username := game.Username
+	if game.Mode == ModeSurvival {
+		return s.endSurvivalRun(ctx, game, card, true)
+	}
+	if game.Room != nil && len(game.Room.alive()) > 2 {
+		return s.handleElimination(ctx, game, card)
+	}
+
+	outcome := gameOutcome{Loser: username, LoserResult: "lose"}
+	if game.Room != nil {
+		if apiErr := s.recordElimination(ctx, game.Room, username); apiErr != nil {
+			return nil, apiErr
 		}
-	
-		log.Printf("User %s drew an Exploding Kitten without a Defuse card!", username)
-		c.JSON(http.StatusOK, gin.H{"message": "You drew an Exploding Kitten! You lose!", "card": emoji})
+		outcome = roomOutcome(game.Room, username, "lose")
+		outcome.Message = fmt.Sprintf("%s exploded. %s wins!", username, outcome.Winner)
+		if outcome.Partner != "" {
+			outcome.Message = fmt.Sprintf("%s exploded, taking %s down too.", username, outcome.Partner)
+		}
+		outcome.Card = findCard(cardTheme(ctx, game), "Exploding Kitten")
+	}
+	outcome.Reveal = true
+	completion, apiErr := s.completeGame(ctx, game, outcome)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	s.reportGameFinished(ctx, game, username, "lose")
+	if outcome.Winner != "" {
+		s.reportGameFinished(ctx, game, outcome.Winner, "win")
+	}
+	if outcome.Partner != "" {
+		s.reportGameFinished(ctx, game, outcome.Partner, "lose")
+	}
+	s.announceGameOver(ctx, game, outcome)
 
-		updateUserStats(username , false)
-	rdb.HSet(ctx, "defuse:"+username, "false")
-		return
-	
-	case "Defuse":
-		log.Printf("User %s drew a Defuse card", username)
-		c.JSON(http.StatusOK, gin.H{"message": "You drew a Defuse card! Keep this to defuse an Exploding Kitten.", "card": emoji})
-		
-		// Save defuse card status in Redis for future use
-		rdb.HSet(ctx, "user:"+username, "defuse", 1)
-		return
-	
+	losses := completion.Losses
+	if outcome.Loser != username {
+		losses = s.lossesOf(ctx, username)
+	}
+	return &DrawCardResponse{
+		Message:    localize(ctx, MsgExploded, losses),
+		MessageID:  MsgExploded,
+		Card:       card,
+		GameStatus: GameStatusLost,
+		Losses:     losses,
+		Winner:     outcome.Winner,
+	}, nil
+}
 
-	case "Shuffle":
-		log.Printf("User %s drew a Shuffle card", username)
-		resetGame(username)
-		c.JSON(http.StatusOK, gin.H{"message": "You drew a Shuffle card! The deck is reshuffled.", "card": emoji})
+// The player drew a bomb without a Defuse in a room that goes on without
+// them. Their stats are left alone until the game is over.
+func (s *Server) handleElimination(ctx context.Context, game *GameSession, card Card) (*DrawCardResponse, *APIError) {
+	event := RoomEvent{
+		Card:    findCard(cardTheme(ctx, game), "Exploding Kitten"),
+		Message: fmt.Sprintf("%s exploded and is out of the game", game.Username),
+	}
+	if apiErr := s.eliminatePlayer(ctx, game, event, s.revealDelay); apiErr != nil {
+		return nil, apiErr
+	}
+	s.reportGameFinished(ctx, game, game.Username, "eliminated")
+
+	return &DrawCardResponse{
+		Message:    localize(ctx, MsgEliminated),
+		MessageID:  MsgEliminated,
+		Card:       card,
+		GameStatus: GameStatusLost,
+		Losses:     s.lossesOf(ctx, game.Username),
+	}, nil
+}
+
+// Hand the turn to the next player still in the game and start their clock,
+// waking the bot if it's up next
+func (s *Server) endTurn(ctx context.Context, room *Room, username string) error {
+	room.Turn = room.nextAlive(username)
+	if err := s.store.UpdateRoom(ctx, room); err != nil {
+		return err
+	}
+	s.startTurnTimer(room)
+	if isBot(room.Turn) {
+		s.scheduleBotTurn(room.Code)
+	}
+	return nil
+}
+
+// Take draws off what the player owes and return what is left. In a room
+// the turn passes on once they owe nothing more; a player an Attack left
+// owing more keeps it, on a fresh clock.
+func (s *Server) finishDraws(ctx context.Context, game *GameSession, draws int) (int, error) {
+	room := game.Room
+	left, err := s.store.PayPendingDraws(ctx, game.ID, game.Username, draws)
+	if err != nil || room == nil {
+		return left, err
+	}
+	if left == 0 {
+		return 0, s.endTurn(ctx, room, game.Username)
+	}
+	s.startTurnTimer(room)
+	if isBot(room.Turn) {
+		s.scheduleBotTurn(room.Code)
+	}
+	return left, nil
+}
+
+// The most cards the player may draw in one go: what an Attack left them
+// owing, or the one card of an ordinary turn
+func (s *Server) drawLimit(ctx context.Context, game *GameSession) (int, *APIError) {
+	owed, err := s.store.PendingDraws(ctx, game.ID, game.Username)
+	if err != nil {
+		log.Printf("Error retrieving draws owed by user %s: %v", game.Username, err)
+		return 0, errStoreUnavailable("Error retrieving game")
+	}
+	return max(owed, 1), nil
+}
+
+// Shuffle the remaining cards of a game's deck in place, with the game's
+// seeded source
+func (s *Server) shuffleDeck(ctx context.Context, gameID string) error {
+	deck, err := s.store.GetDeck(ctx, gameID)
+	if err != nil {
+		return err
+	}
+	rng, err := s.gameRNG(ctx, gameID)
+	if err != nil {
+		return err
+	}
+	rules := &engine.Game{Deck: deck}
+	rules.Shuffle(rng)
+	if err := s.saveGameRNG(ctx, gameID, rng); err != nil {
+		return err
+	}
+	return s.store.CreateDeck(ctx, gameID, rules.Deck)
+}
+
+// Hand route: the cards the player is holding
+func (s *Server) getHand(c *gin.Context) {
+	username := c.Query("username")
+	if !usernamePattern.MatchString(username) {
+		abortWithError(c, errInvalidUsername())
 		return
+	}
 
-	default:
-		log.Printf("User %s drew a Cat card", username)
-	
-		// Log successful removal of the cat card
-		c.JSON(http.StatusOK, gin.H{
-			"message": "You drew a Cat card! One Cat card has been removed from your deck.",
-			"card":    emoji,
-		})
-		return		
+	hand, err := s.store.GetHand(c.Request.Context(), username)
+	if err != nil {
+		log.Printf("Error retrieving hand for user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving hand"))
+		return
 	}
+	c.JSON(http.StatusOK, HandResponse{Username: username, Hand: cardsFor(themeFrom(c.Request.Context()), hand)})
 }
 
-func resetGame(username string) {
+// Deal a classic solo game a fresh deck, as a drawn Shuffle does
+func (s *Server) resetGame(ctx context.Context, username string) error {
 	log.Printf("Resetting game for user: %s", username)
 
-	// Initialize deck
-	deck := []string{"Cat", "Cat", "Defuse", "Shuffle", "Exploding Kitten",}
+	// Replace the previous deck with a fresh one, shuffled by the game's
+	// seeded source. A held Defuse is kept.
+	rng, err := s.gameRNG(ctx, username)
+	if err != nil {
+		log.Printf("Error retrieving the seeded source of game %s: %v", username, err)
+		return err
+	}
+	deck := buildDeck(s.soloDeck, rng)
+	if err := s.saveGameRNG(ctx, username, rng); err != nil {
+		log.Printf("Error saving the seeded source of game %s: %v", username, err)
+		return err
+	}
+	if err := s.store.CreateDeck(ctx, username, deck); err != nil {
+		log.Printf("Error resetting deck for user %s: %v", username, err)
+		return err
+	}
 
-	// Shuffle the deck
-	rand.Seed(time.Now().UnixNano()) // Ensure randomness on each run
-	rand.Shuffle(len(deck), func(i, j int) {
-		deck[i], deck[j] = deck[j], deck[i]
-	})
+	log.Printf("Game reset for user: %s with cards: %v", username, deck)
+	return nil
+}
+
+// Serve WebSocket connection for leaderboard
+func (s *Server) serveWs(c *gin.Context) {
+	// A full instance refuses with a 503 before the upgrade, so the client
+	// can retry, or be balanced onto another
+	if !s.hub.reserveConn() {
+		log.Println("WebSocket refused: connection limit reached")
+		abortWithError(c, errTooManyConnections())
+		return
+	}
+	defer s.hub.releaseConn()
 
-	// Select the first 5 cards from the shuffled deck
-	randomCards := deck[:5]
+	// A disallowed origin is refused with a 403 before the upgrade
+	conn, err := s.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Println("WebSocket upgrade failed:", err)
+		return
+	}
+	configureConn(conn)
+
+	// Sockets that say whose they are keep that player online, and count
+	// toward the player's cap
+	player := ""
+	if username := c.Query("username"); usernamePattern.MatchString(username) {
+		player = username
+		s.trackSocketPresence(conn, username)
+		s.hub.admitUserConn(username, conn)
+		defer s.hub.removeUserConn(username, conn)
+	}
+	s.hub.pool.open(conn, player)
 
-	// Create deck key for the user
-	deckKey := "deck:" + username
+	// Reconnecting game sockets say which events they have already seen
+	lastSeq := int64(noLastSeq)
+	if seq := c.Query("lastSeq"); seq != "" {
+		if lastSeq, err = strconv.ParseInt(seq, 10, 64); err != nil || lastSeq < 0 {
+			lastSeq = 0
+		}
+	}
 
-	// Clear the previous deck in Redis
-	rdb.Del(ctx, deckKey)
+	// Commands sent over the socket answer in the language it was opened
+	// with, and are moves of the device it names with ?deviceId=
+	ctx := withLocale(context.Background(), requestLocale(c.Request))
+	ctx = withDevice(ctx, c.Query("deviceId"))
 
-	// Add the random cards to the deck in Redis
-	rdb.RPush(ctx, deckKey, randomCards)
-	rdb.HSet(ctx, "user:"+username, "defuse" , 0)
+	// Spectators watch a single player's game instead of the leaderboard
+	if username := c.Query("spectate"); username != "" {
+		s.serveSpectator(ctx, conn, username, lastSeq)
+		return
+	}
 
-	log.Printf("Game reset for user: %s with cards: %v", username, randomCards)
-}
+	// Room sockets receive the room's game events
+	if code := c.Query("room"); code != "" {
+		s.serveRoomSocket(ctx, conn, strings.ToUpper(code), lastSeq)
+		return
+	}
 
-// Active WebSocket connections
-var clients = make(map[*websocket.Conn]bool)
-var mutex = &sync.Mutex{} // Lock to synchronize access to clients map
+	// Tournament sockets receive the bracket each time it changes
+	if id := c.Query("tournament"); id != "" {
+		s.serveTournamentSocket(ctx, conn, id)
+		return
+	}
 
-// Serve WebSocket connection for leaderboard
-func serveWs(c *gin.Context) {
-    conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-    if err != nil {
-        log.Println("WebSocket upgrade failed:", err)
-        return
-    }
-
-    // Register new connection
-    mutex.Lock()
-    clients[conn] = true
-    mutex.Unlock()
-    defer func() {
-        mutex.Lock()
-        delete(clients, conn)
-        mutex.Unlock()
-        conn.Close()
-    }()
-
-    log.Println("WebSocket connection established")
-
-    // Send initial leaderboard data to the new connection
-    if err := sendLeaderboard(conn); err != nil {
-        log.Println("Error sending initial leaderboard data:", err)
-        return
-    }
-
-    // Ping periodically to keep the connection alive
-    go func() {
-        ticker := time.NewTicker(30 * time.Second) // Ping every 30 seconds
-        defer ticker.Stop()
-        for {
-            <-ticker.C
-            if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-                log.Println("Ping failed:", err)
-                return
-            }
-        }
-    }()
-
-    // Keep connection alive
-    for {
-        if _, _, err := conn.ReadMessage(); err != nil {
-            log.Println("WebSocket connection closed:", err)
-            break
-        }
-    }
+	// Register new connection. It is served from here on; the initial
+	// leaderboard is read and queued on its own goroutine, which gives up if
+	// the client leaves first.
+	ctx = s.hub.register(ctx, conn, player)
+	defer func() {
+		s.hub.unregister(conn)
+		s.hub.close(conn)
+	}()
+
+	log.Println("WebSocket connection established")
+
+	go s.sendInitialLeaderboard(ctx, conn)
+	go s.keepAlive(conn)
+
+	// Serve commands until the connection closes
+	err = s.readCommands(ctx, conn, SubscribeRequest{})
+	log.Println("WebSocket connection closed:", err)
 }
 
 // Broadcast updated leaderboard to all clients
-func broadcastLeaderboard() {
-    // Fetch updated leaderboard data
-    leaderboardData, err := fetchAllUserStats()
-    if err != nil {
-        log.Println("Error fetching leaderboard data:", err)
-        return
-    }
-
-    // Send updated leaderboard to each connected client
-    mutex.Lock()
-    for conn := range clients {
-        if err := conn.WriteJSON(leaderboardData); err != nil {
-            log.Println("Error sending leaderboard to a client:", err)
-            conn.Close()
-            delete(clients, conn) // Remove client on error
-        }
-    }
-    mutex.Unlock()
-}
-
-// Helper function to send leaderboard data to a single connection
-func sendLeaderboard(conn *websocket.Conn) error {
-    leaderboardData, err := fetchAllUserStats()
-    if err != nil {
-        return err
-    }
-    return conn.WriteJSON(leaderboardData)
-}
-
-
-// Helper function to fetch all users' data from Redis
-func fetchAllUserStats() ([]map[string]string, error) {
-	// Fetch all user win data
-	winData, err := rdb.HGetAll(ctx, "win").Result()
-	if err != nil {
-		log.Printf("Error fetching win data: %v", err)
-		return nil, err
+func (s *Server) broadcastLeaderboard() {
+	s.broadcastLeaderboardAfter(0)
+}
+
+// Broadcast the leaderboard as it is now, once delay has passed
+func (s *Server) broadcastLeaderboardAfter(delay time.Duration) {
+	// The standings can't be read while Redis is down; the next change after
+	// it recovers broadcasts them
+	if s.storeTripped() {
+		return
 	}
 
-	// Fetch all user lose data
-	loseData, err := rdb.HGetAll(ctx, "lose").Result()
+	// Fetch updated leaderboard data
+	leaderboardData, version, err := s.cachedLeaderboard(context.Background())
 	if err != nil {
-		log.Printf("Error fetching lose data: %v", err)
-		return nil, err
+		log.Println("Error fetching leaderboard data:", err)
+		return
 	}
 
-	// Combine win and lose data into a single slice of maps
-	var userStats []map[string]string
-	for username, wins := range winData {
-		// Find corresponding lose count, default to "0" if not found
-		loses, ok := loseData[username]
-		if !ok {
-			loses = "0"
-		}
+	// Nothing changed since the clients' last frame
+	if !s.leaderboard.markBroadcast(version) {
+		return
+	}
 
-		// Add each user’s stats to the list
-		stats := map[string]string{
-			"username": username,
-			"win":      wins,
-			"lose":     loses,
-		}
-		userStats = append(userStats, stats)
+	// Send updated leaderboard to each connected client
+	s.hub.broadcastAfter(delay, LeaderboardMessage{Type: "leaderboard", Window: WindowAll, Leaderboard: leaderboardData})
+}
+
+// Send a new leaderboard connection its first snapshot. A connection that
+// can't get one is closed, unless it closed first.
+func (s *Server) sendInitialLeaderboard(ctx context.Context, conn *websocket.Conn) {
+	err := s.sendLeaderboard(ctx, conn)
+	if err == nil || ctx.Err() != nil {
+		return
 	}
+	log.Println("Error sending initial leaderboard data:", err)
+	conn.Close()
+}
 
-	log.Println("Fetched user stats:", userStats)
-	return userStats, nil
-}
\ No newline at end of file
+// Helper function to send a full leaderboard snapshot to a single
+// connection: the top rows, and its player's own. ctx is the connection's;
+// nothing is read once it is done.
+func (s *Server) sendLeaderboard(ctx context.Context, conn *websocket.Conn) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	leaderboardData, _, err := s.cachedLeaderboard(ctx)
+	if err != nil {
+		return err
+	}
+	var entries []LeaderboardEntry
+	if err := json.Unmarshal(leaderboardData, &entries); err != nil {
+		return err
+	}
+	return s.hub.sendLeaderboardSnapshot(conn, WindowAll, entries)
+}
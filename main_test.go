@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+func TestStartGameDealsSoloDeck(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		started := ts.startGame("alice")
+		if started.Message != "Game started" || started.GameID != "alice" {
+			t.Fatalf("start = %+v", started)
+		}
+		if got := started.Snapshot.Remaining; got != ts.soloDeck.Size {
+			t.Fatalf("remaining = %d, want %d", got, ts.soloDeck.Size)
+		}
+		if started.Snapshot.Status != GameStatusActive {
+			t.Fatalf("status = %q", started.Snapshot.Status)
+		}
+
+		// A second start picks the same game up
+		resumed := ts.startGame("alice")
+		if resumed.Message != "Resuming game" || resumed.Snapshot.Remaining != ts.soloDeck.Size {
+			t.Fatalf("resume = %+v", resumed)
+		}
+	})
+}
+
+func TestDrawCardHoldsCatCard(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", "Cat", engine.ExplodingKitten)
+
+		drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if drawn.Card.Type != "Cat" || drawn.Disposition != DispositionHeld || drawn.GameStatus != GameStatusActive {
+			t.Fatalf("draw = %+v", drawn)
+		}
+		if drawn.Remaining != 2 {
+			t.Fatalf("remaining = %d, want 2", drawn.Remaining)
+		}
+		if hand := ts.hand("alice"); len(hand) != 1 || hand[0] != "Cat" {
+			t.Fatalf("hand = %v", hand)
+		}
+	})
+}
+
+func TestDrawCardWinsOnceOnlyBombsAreLeft(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", engine.ExplodingKitten)
+
+		drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if drawn.GameStatus != GameStatusWon {
+			t.Fatalf("status = %q, want won", drawn.GameStatus)
+		}
+		if win, lose := ts.stats("alice"); win != 1 || lose != 0 {
+			t.Fatalf("stats = %d/%d, want 1/0", win, lose)
+		}
+
+		// The game is over
+		assertError(t, ts.draw("alice"), http.StatusConflict, ErrCodeGameFinished)
+	})
+}
+
+func TestDrawCardExplodesWithoutDefuse(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", engine.ExplodingKitten, "Cat")
+
+		drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if drawn.GameStatus != GameStatusLost || drawn.Disposition != DispositionExploded {
+			t.Fatalf("draw = %+v", drawn)
+		}
+		if drawn.Losses != 1 {
+			t.Fatalf("losses = %d, want 1", drawn.Losses)
+		}
+		if win, lose := ts.stats("alice"); win != 0 || lose != 1 {
+			t.Fatalf("stats = %d/%d, want 0/1", win, lose)
+		}
+	})
+}
+
+func TestDrawCardShuffleDealsFreshDeck(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", engine.Shuffle, "Cat", engine.ExplodingKitten)
+
+		drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if drawn.Card.Type != engine.Shuffle || drawn.Disposition != DispositionResolved {
+			t.Fatalf("draw = %+v", drawn)
+		}
+		if got := len(ts.deck("alice")); got != ts.soloDeck.Size {
+			t.Fatalf("deck after Shuffle has %d cards, want %d", got, ts.soloDeck.Size)
+		}
+	})
+}
+
+func TestDrawCardRejectsBadRequests(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		assertError(t, ts.draw("not a name"), http.StatusBadRequest, ErrCodeInvalidUsername)
+		assertError(t, ts.post("/draw-card", "{"), http.StatusBadRequest, ErrCodeInvalidRequest)
+		assertError(t, ts.post("/start-game", User{Username: "alice", Mode: "speedrun"}), http.StatusBadRequest, ErrCodeInvalidRequest)
+	})
+}
+
+func TestHandlersReportStoreFailures(t *testing.T) {
+	tests := []struct {
+		name  string
+		fail  string
+		route string
+	}{
+		{"deck lookup on start", "GetDeck", "/start-game"},
+		{"deal on start", "CreateDeck", "/start-game"},
+		{"draw state", "DrawState", "/draw-card"},
+		{"draw", "DrawCard", "/draw-card"},
+		{"holding the card", "HoldCard", "/draw-card"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			eachGameStore(t, func(t *testing.T, backing GameStore) {
+				store := newFaultyStore(backing)
+				ts := newTestServer(t, store)
+				if test.route == "/draw-card" {
+					ts.startGame("alice", "Cat", "Cat", engine.ExplodingKitten)
+				}
+
+				store.breakCalls(test.fail)
+				assertError(t, ts.post(test.route, User{Username: "alice"}), http.StatusServiceUnavailable, ErrCodeStoreUnavailable)
+			})
+		})
+	}
+}
+
+func TestDrawCardReportsFailedCompletion(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, backing GameStore) {
+		store := newFaultyStore(backing)
+		ts := newTestServer(t, store)
+		ts.startGame("alice", "Cat", engine.ExplodingKitten)
+
+		store.breakCalls("CompleteGame")
+		assertError(t, ts.draw("alice"), http.StatusServiceUnavailable, ErrCodeStoreUnavailable)
+		if win, lose := ts.stats("alice"); win != 0 || lose != 0 {
+			t.Fatalf("stats = %d/%d after a failed completion", win, lose)
+		}
+	})
+}
+
+func TestConcurrentDrawsOnLastCard(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		for round := 0; round < 20; round++ {
+			username := fmt.Sprintf("racer%d", round)
+			ts.startGame(username, "Cat")
+
+			codes := make([]int, 2)
+			start := make(chan struct{})
+			var wg sync.WaitGroup
+			for i := range codes {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					<-start
+					codes[i] = ts.draw(username).Code
+				}(i)
+			}
+			close(start)
+			wg.Wait()
+
+			sort.Ints(codes)
+			if codes[0] != http.StatusOK || codes[1] != http.StatusConflict {
+				t.Fatalf("round %d: statuses %v, want one 200 and one 409", round, codes)
+			}
+			if win, _ := ts.stats(username); win != 1 {
+				t.Fatalf("round %d: %d wins, want 1", round, win)
+			}
+		}
+	})
+}
+
+func TestStoreDrawAtOneVersionSucceedsOnce(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		store.CreateDeck(ctx, "alice", []string{"Cat"})
+		version, _ := store.GameVersion(ctx, "alice")
+
+		results := make([]error, 2)
+		cards := make([]string, 2)
+		var wg sync.WaitGroup
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				drawn, err := store.DrawCard(ctx, "alice", "alice", version, false, nil)
+				cards[i], results[i] = drawn.Card, err
+			}(i)
+		}
+		wg.Wait()
+
+		drew, conflicts := 0, 0
+		for i, err := range results {
+			switch {
+			case err == nil && cards[i] == "Cat":
+				drew++
+			case err == errVersionConflict:
+				conflicts++
+			default:
+				t.Fatalf("draw %d: %q, %v", i, cards[i], err)
+			}
+		}
+		if drew != 1 || conflicts != 1 {
+			t.Fatalf("%d draws and %d conflicts, want one of each", drew, conflicts)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How often runMatchmaker pairs players queued on any instance, unless
+// MATCHMAKING_INTERVAL says otherwise
+const defaultMatchmakingInterval = time.Second
+
+// Statuses of a matchmaking response
+const (
+	MatchQueued = "queued"
+	MatchFound  = "matched"
+	MatchLeft   = "left"
+)
+
+// Returned by seatMatch when no free room code was found
+var errNoRoomCode = errors.New("could not allocate a room code")
+
+// Pair queued players two at a time until fewer than two are left, seating
+// each pair in a new room. Returns the rooms that were started.
+func (s *Server) matchPlayers(ctx context.Context) []*Room {
+	var rooms []*Room
+	for {
+		pair, err := s.store.PopMatch(ctx)
+		if err != nil {
+			log.Printf("Error taking players off the matchmaking queue: %v", err)
+			return rooms
+		}
+		if pair == nil {
+			return rooms
+		}
+
+		room, err := s.seatMatch(ctx, pair)
+		if err != nil {
+			// Put them back so the next round can try again
+			log.Printf("Error seating matched players %v: %v", pair, err)
+			for _, username := range pair {
+				if err := s.store.EnqueueMatch(ctx, username, s.clock.Now()); err != nil {
+					log.Printf("Error requeueing user %s: %v", username, err)
+				}
+			}
+			return rooms
+		}
+		rooms = append(rooms, room)
+	}
+}
+
+// Start a room game between the two players and tell each of them where it is
+func (s *Server) seatMatch(ctx context.Context, pair []string) (*Room, error) {
+	code, err := s.openRoom(ctx, pair[0], minRoomSize, s.turnTimeout, BalanceNone, RoomVersus, ThemeClassic)
+	if err != nil {
+		return nil, err
+	}
+	if code == "" {
+		return nil, errNoRoomCode
+	}
+	room, err := s.store.JoinRoom(ctx, code, pair[1])
+	if err != nil {
+		return nil, err
+	}
+	if err := s.startRoomGame(ctx, room); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Matched users %s and %s in room %s", pair[0], pair[1], code)
+	for _, username := range pair {
+		s.hub.notifySpectators(username, SpectatorEvent{Type: "match_found", Username: username, Room: code})
+	}
+	return room, nil
+}
+
+// Run matchPlayers every interval until ctx is done
+func (s *Server) runMatchmaker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.storeTripped() {
+				s.matchPlayers(ctx)
+			}
+		}
+	}
+}
+
+// Matchmake route: queue the session's user for a two-player room. They are
+// seated right away if someone is already waiting.
+func (s *Server) matchmake(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	_, username, apiErr := s.sessionUser(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	if err := s.store.EnqueueMatch(ctx, username, s.clock.Now()); err != nil {
+		log.Printf("Error queueing user %s for a match: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error joining the queue"))
+		return
+	}
+	for _, room := range s.matchPlayers(ctx) {
+		if room.hasPlayer(username) {
+			c.JSON(http.StatusOK, MatchmakeResponse{Status: MatchFound, Code: room.Code, GameID: room.gameID(), Room: room})
+			return
+		}
+	}
+
+	// Position is 0 if another instance has just paired them; match_found
+	// is on its way
+	position, err := s.store.MatchQueuePosition(ctx, username)
+	if err != nil {
+		log.Printf("Error retrieving queue position of user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving queue position"))
+		return
+	}
+	log.Printf("User %s is waiting for a match at position %d", username, position)
+	c.JSON(http.StatusOK, MatchmakeResponse{Status: MatchQueued, Position: position})
+}
+
+// Leave matchmaking route
+func (s *Server) leaveMatchmaking(c *gin.Context) {
+	_, username, apiErr := s.sessionUser(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	left, err := s.store.LeaveMatchQueue(c.Request.Context(), username)
+	if err != nil {
+		log.Printf("Error removing user %s from the matchmaking queue: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error leaving the queue"))
+		return
+	}
+	if !left {
+		abortWithError(c, errInvalidRequest("You are not waiting for a match"))
+		return
+	}
+	log.Printf("User %s left the matchmaking queue", username)
+	c.JSON(http.StatusOK, MatchmakeResponse{Status: MatchLeft})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestSimultaneousMatchmakingPairsTwo(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		guests := []GuestResponse{ts.guest(), ts.guest(), ts.guest()}
+
+		responses := make([]MatchmakeResponse, len(guests))
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for i, guest := range guests {
+			wg.Add(1)
+			go func(i int, token string) {
+				defer wg.Done()
+				<-start
+				w := ts.post("/matchmake", nil, bearer(token)...)
+				if w.Code != http.StatusOK {
+					t.Errorf("matchmake: %d %s", w.Code, w.Body)
+					return
+				}
+				responses[i] = decodeBody[MatchmakeResponse](t, w)
+			}(i, guest.Token)
+		}
+		close(start)
+		wg.Wait()
+		if t.Failed() {
+			return
+		}
+
+		rooms, err := ts.store.ActiveRooms(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rooms) != 1 || len(rooms[0].Players) != 2 {
+			t.Fatalf("rooms = %+v, want one of two players", rooms)
+		}
+		room := rooms[0]
+
+		var paired, waiting []string
+		for i, guest := range guests {
+			response := responses[i]
+			if room.hasPlayer(guest.Username) {
+				paired = append(paired, guest.Username)
+				if response.Status == MatchFound && response.Code != room.Code {
+					t.Fatalf("%s was matched into %s, not %s", guest.Username, response.Code, room.Code)
+				}
+				if position, _ := ts.store.MatchQueuePosition(ctx, guest.Username); position != 0 {
+					t.Fatalf("paired %s is still queued at %d", guest.Username, position)
+				}
+				continue
+			}
+			waiting = append(waiting, guest.Username)
+			if response.Status != MatchQueued || response.Position < 1 {
+				t.Fatalf("leftover %s got %+v", guest.Username, response)
+			}
+			if position, _ := ts.store.MatchQueuePosition(ctx, guest.Username); position != 1 {
+				t.Fatalf("leftover %s is at %d, want 1", guest.Username, position)
+			}
+			left := decodeOK[MatchmakeResponse](t, ts.request(http.MethodDelete, "/matchmake", nil, bearer(guest.Token)...))
+			if left.Status != MatchLeft {
+				t.Fatalf("leaving = %+v", left)
+			}
+		}
+		if len(paired) != 2 || len(waiting) != 1 {
+			t.Fatalf("paired %v and left %v waiting", paired, waiting)
+		}
+		if pair, _ := ts.store.PopMatch(ctx); pair != nil {
+			t.Fatalf("queue still pairs %v", pair)
+		}
+	})
+}
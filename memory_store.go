@@ -0,0 +1,2216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+var _ GameStore = (*memoryStore)(nil)
+
+// memoryStore is an in-memory GameStore with the same semantics as redisStore.
+// All state lives in maps guarded by a single mutex. It backs STORE=memory,
+// for running the server without Redis: everything is lost on restart and
+// other instances can't see it.
+type memoryStore struct {
+	mutex  sync.Mutex
+	decks  map[string][]string
+	games  map[string]map[string]string
+	hands  map[string][]string
+	defuse map[string]int
+	rooms  map[string]Room
+	wins   map[string]int64
+	loses  map[string]int64
+	idem   map[string]idempotentEntry
+	events map[string][][]byte
+	moves  map[string][][]byte
+	chats  map[string][][]byte
+	// Audit entries with their IDs, oldest first
+	audit  []AuditEntry
+	streak map[string]int64
+	earned map[string][]Achievement
+	guests map[string]bool
+	// Suspected cheats -> the reason they were flagged
+	flagged map[string]string
+	// Usernames generated by POST /debug/seed
+	seeded   map[string]bool
+	apiKeys  map[string]APIKey
+	profiles map[string]Profile
+	// Username -> when their recent games ended, oldest first
+	finishes map[string][]time.Time
+	// Session token -> username
+	sessions map[string]string
+	// Username -> when they were last seen
+	online map[string]time.Time
+	// Usernames waiting for a match, longest-waiting first
+	matchQueue []string
+	// Room code -> when its invites were last revoked
+	revokedInvites map[string]time.Time
+	// Best survival run of each username
+	survival map[string]int64
+	// Time-bucketed leaderboards keyed like the Redis sorted sets
+	windows map[string]map[string]int64
+	// room:{code}:state hashes keyed by room code
+	roomStates map[string]map[string]string
+	// The device holding each game lock, keyed like the Redis keys, and the
+	// token holding each lock on creating a game
+	locks map[string]string
+	// Username -> the games they are playing
+	activeGames map[string]map[string]bool
+	// Games started by a user on a day, keyed like the Redis keys
+	gamesStarted map[string]int64
+	// Caps users have of their own
+	limits map[string]GameLimits
+	// Encoded tournaments keyed like the Redis keys, so each update works on
+	// a copy
+	tournaments map[string][]byte
+	// Game ID -> the draw held until its player confirms it, with the game
+	// version the draw left
+	pendingDraws map[string]pendingDraw
+	// The counts of the games completed on each UTC day
+	daily map[string]DailyStats
+	// Head-to-head hashes keyed like the Redis keys
+	headToHead map[string]map[string]string
+	// Announcements waiting to be acknowledged, oldest first, and the
+	// number of the last one added
+	outbox    []memoryOutboxEntry
+	outboxSeq int64
+	// When keys given a TTL expire, keyed like the Redis keys. An expired
+	// key is dropped the next time it is touched.
+	expires map[string]time.Time
+
+	retention retentionPolicy
+	// Names the keys above; it never has a prefix, as nothing else shares
+	// this process's memory
+	keys keyBuilder
+}
+
+// A held draw, and the game version it left
+type pendingDraw struct {
+	PendingDraw
+	version int64
+}
+
+// A claimed idempotency key. response stays nil until it is saved.
+type idempotentEntry struct {
+	response []byte
+	expires  time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		decks:    make(map[string][]string),
+		games:    make(map[string]map[string]string),
+		hands:    make(map[string][]string),
+		defuse:   make(map[string]int),
+		rooms:    make(map[string]Room),
+		wins:     make(map[string]int64),
+		loses:    make(map[string]int64),
+		idem:     make(map[string]idempotentEntry),
+		events:   make(map[string][][]byte),
+		moves:    make(map[string][][]byte),
+		chats:    make(map[string][][]byte),
+		streak:   make(map[string]int64),
+		earned:   make(map[string][]Achievement),
+		guests:   make(map[string]bool),
+		flagged:  make(map[string]string),
+		seeded:   make(map[string]bool),
+		apiKeys:  make(map[string]APIKey),
+		profiles: make(map[string]Profile),
+		finishes: make(map[string][]time.Time),
+		sessions: make(map[string]string),
+		online:   make(map[string]time.Time),
+		windows:  make(map[string]map[string]int64),
+		survival: make(map[string]int64),
+
+		roomStates: make(map[string]map[string]string),
+		locks:      make(map[string]string),
+		expires:    make(map[string]time.Time),
+
+		activeGames:  make(map[string]map[string]bool),
+		gamesStarted: make(map[string]int64),
+		limits:       make(map[string]GameLimits),
+		tournaments:  make(map[string][]byte),
+		pendingDraws: make(map[string]pendingDraw),
+		daily:        make(map[string]DailyStats),
+		headToHead:   make(map[string]map[string]string),
+
+		revokedInvites: make(map[string]time.Time),
+		retention:      defaultRetention,
+	}
+}
+
+// Remove the first copy of card from list
+func removeFirst(list []string, card string) ([]string, bool) {
+	for i, c := range list {
+		if c == card {
+			return append(list[:i:i], list[i+1:]...), true
+		}
+	}
+	return list, false
+}
+
+func (s *memoryStore) CreateDeck(ctx context.Context, gameID string, deck []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.decks[gameID] = append([]string(nil), deck...)
+	s.gameHash(gameID)["deckVersion"] = strconv.Itoa(orderedDeckVersion)
+	s.gameHash(gameID)["schemaVersion"] = strconv.Itoa(currentGameSchema)
+	s.bumpVersion(gameID)
+	return nil
+}
+
+func (s *memoryStore) InsertCard(ctx context.Context, gameID, card string, position int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	rules := &engine.Game{Deck: s.decks[gameID]}
+	rules.Insert(card, position)
+	s.decks[gameID] = rules.Deck
+	s.bumpVersion(gameID)
+	return nil
+}
+
+func (s *memoryStore) GameVersion(ctx context.Context, gameID string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.gameVersion(gameID), nil
+}
+
+// Callers hold the mutex
+func (s *memoryStore) gameVersion(gameID string) int64 {
+	version, _ := strconv.ParseInt(s.games[gameID]["version"], 10, 64)
+	return version
+}
+
+// Callers hold the mutex
+func (s *memoryStore) bumpVersion(gameID string) {
+	s.gameHash(gameID)["version"] = strconv.FormatInt(s.gameVersion(gameID)+1, 10)
+}
+
+// The game hash for gameID, created on first use. Callers hold the mutex.
+func (s *memoryStore) gameHash(gameID string) map[string]string {
+	if s.games[gameID] == nil {
+		s.games[gameID] = make(map[string]string)
+	}
+	return s.games[gameID]
+}
+
+// Give key a TTL, like EXPIRE. Callers hold the mutex.
+func (s *memoryStore) expire(key string, ttl time.Duration) {
+	s.expires[key] = time.Now().Add(ttl)
+}
+
+// Whether key has outlived its TTL, forgetting the TTL if so; the caller
+// then drops the key's data. Callers hold the mutex.
+func (s *memoryStore) expired(key string) bool {
+	at, ok := s.expires[key]
+	if !ok || time.Now().Before(at) {
+		return false
+	}
+	delete(s.expires, key)
+	return true
+}
+
+func (s *memoryStore) GetGameStatus(ctx context.Context, gameID string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.games[gameID]["status"], nil
+}
+
+func (s *memoryStore) SetGameStatus(ctx context.Context, gameID, status string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.gameHash(gameID)["status"] = status
+	s.bumpVersion(gameID)
+	return nil
+}
+
+func (s *memoryStore) IncrGamesPlayed(ctx context.Context, gameID string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	played, _ := strconv.ParseInt(s.games[gameID]["gamesPlayed"], 10, 64)
+	played++
+	s.gameHash(gameID)["gamesPlayed"] = strconv.FormatInt(played, 10)
+	return played, nil
+}
+
+func (s *memoryStore) MarkGameStarted(ctx context.Context, gameID string, at time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	game := s.gameHash(gameID)
+	game["startedAt"] = strconv.FormatInt(at.UnixMilli(), 10)
+	game["cardsDrawn"] = "0"
+	game["moveSeq"] = "0"
+	delete(game, "mustDiscard")
+	delete(game, "blockedCause")
+	delete(game, "bombDeadline")
+	delete(game, "lastShuffleSeq")
+	delete(game, "finishedAt")
+	fields := make([]string, 0, len(game))
+	for field := range game {
+		fields = append(fields, field)
+	}
+	for _, field := range playerGameFields(fields) {
+		delete(game, field)
+	}
+	delete(s.moves, gameID)
+	return nil
+}
+
+func (s *memoryStore) MarkGameFinished(ctx context.Context, gameID string, at time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.gameHash(gameID)["finishedAt"] = strconv.FormatInt(at.UnixMilli(), 10)
+	return nil
+}
+
+func (s *memoryStore) GameProgress(ctx context.Context, gameID string) (time.Time, int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return parseGameProgress(s.games[gameID]["startedAt"], s.games[gameID]["cardsDrawn"])
+}
+
+func (s *memoryStore) GetGameHash(ctx context.Context, gameID string) (map[string]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	game := make(map[string]string, len(s.games[gameID]))
+	for field, value := range s.games[gameID] {
+		game[field] = value
+	}
+	return game, nil
+}
+
+// The player's defuse count stands in for their user hash
+func (s *memoryStore) MigrateGame(ctx context.Context, gameID, username string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, dealt := s.decks[gameID]
+	record := gameRecord{Game: copyHash(s.games[gameID]), Dealt: dealt}
+	from, err := gameSchemaVersion(record)
+	if err != nil || from == 0 || from == currentGameSchema {
+		return from, err
+	}
+	solo := gameID == username
+	if solo {
+		record.User = make(map[string]string)
+		if count, ok := s.defuse[username]; ok {
+			record.User["defuse"] = strconv.Itoa(count)
+		}
+	}
+
+	migrateGameRecord(&record, from)
+	s.games[gameID] = record.Game
+	if solo && record.User["defuse"] != "" {
+		s.defuse[username], _ = strconv.Atoi(record.User["defuse"])
+	}
+	return from, nil
+}
+
+func (s *memoryStore) SaveGameSummary(ctx context.Context, gameID, username string, summary []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.gameHash(gameID)[summaryField(username)] = string(summary)
+	return nil
+}
+
+// Count a card the user drew in the game hash. Callers hold the mutex.
+func (s *memoryStore) countDraw(gameID, username, card string) {
+	s.incrGameField(gameID, "cardsDrawn")
+	s.incrGameField(gameID, drawCountField(username, card))
+}
+
+// Add one to a counter of the game hash. Callers hold the mutex.
+func (s *memoryStore) incrGameField(gameID, field string) {
+	count, _ := strconv.ParseInt(s.games[gameID][field], 10, 64)
+	s.gameHash(gameID)[field] = strconv.FormatInt(count+1, 10)
+}
+
+// Take one from a counter of the game hash. Callers hold the mutex.
+func (s *memoryStore) decrGameField(gameID, field string) {
+	count, _ := strconv.ParseInt(s.games[gameID][field], 10, 64)
+	s.gameHash(gameID)[field] = strconv.FormatInt(count-1, 10)
+}
+
+func (s *memoryStore) DeleteDeck(ctx context.Context, gameID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.decks, gameID)
+	delete(s.games, gameID)
+	delete(s.events, gameID)
+	return nil
+}
+
+func (s *memoryStore) GetDeck(ctx context.Context, gameID string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.decks[gameID]...), nil
+}
+
+func (s *memoryStore) DrawCard(ctx context.Context, gameID, username string, version int64, fromBottom bool, hold *PendingDraw) (DrawnCard, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.gameVersion(gameID) != version {
+		return DrawnCard{}, errVersionConflict
+	}
+	deck := s.decks[gameID]
+	if len(deck) == 0 {
+		return DrawnCard{Cleared: true}, nil
+	}
+
+	index := 0
+	switch {
+	case s.games[gameID]["deckVersion"] == "":
+		index = rand.Intn(len(deck))
+	case fromBottom:
+		index = len(deck) - 1
+	}
+	card := deck[index]
+	s.decks[gameID] = append(deck[:index:index], deck[index+1:]...)
+	s.countDraw(gameID, username, card)
+	s.bumpVersion(gameID)
+	if hold != nil {
+		draw := pendingDraw{PendingDraw: *hold, version: s.gameVersion(gameID)}
+		draw.GameID, draw.Username, draw.Card = gameID, username, card
+		s.pendingDraws[gameID] = draw
+	}
+	left := &engine.Game{Deck: s.decks[gameID]}
+	return DrawnCard{Card: card, Remaining: len(left.Deck), Cleared: left.Cleared()}, nil
+}
+
+func (s *memoryStore) ConfirmDraw(ctx context.Context, gameID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.pendingDraws, gameID)
+	return nil
+}
+
+func (s *memoryStore) ReturnPendingDraws(ctx context.Context, before time.Time) ([]PendingDraw, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var returned []PendingDraw
+	for gameID, draw := range s.pendingDraws {
+		if !draw.At.Before(before) {
+			continue
+		}
+		delete(s.pendingDraws, gameID)
+		if s.gameVersion(gameID) != draw.version {
+			continue
+		}
+		hand, removed := removeFirst(s.hands[draw.Username], draw.Card)
+		s.hands[draw.Username] = hand
+		if removed && draw.Card == "Defuse" {
+			s.defuse[draw.Username]--
+		}
+		s.decks[gameID] = append([]string{draw.Card}, s.decks[gameID]...)
+		s.decrGameField(gameID, "cardsDrawn")
+		s.decrGameField(gameID, drawCountField(draw.Username, draw.Card))
+		s.bumpVersion(gameID)
+		if draw.Key != "" {
+			delete(s.idem, s.keys.idempotency(gameID, draw.Key))
+		}
+		returned = append(returned, draw.PendingDraw)
+	}
+	return returned, nil
+}
+
+// A batch draw's roll as the source settling its card: a defused bomb goes
+// back in at the roll modulo the deck's size plus one, as drawCardsScript
+// puts it. Settling a card never shuffles.
+type rollRNG int64
+
+func (r rollRNG) Intn(n int) int { return int(int64(r) % int64(n)) }
+
+func (r rollRNG) Shuffle(n int, swap func(i, j int)) { panic("a batch draw's roll can't shuffle") }
+
+func (s *memoryStore) DrawCards(ctx context.Context, gameID, username string, version int64, rolls []int64) ([]BatchDraw, int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.gameVersion(gameID) != version {
+		return nil, 0, errVersionConflict
+	}
+	ordered := s.games[gameID]["deckVersion"] != ""
+
+	var draws []BatchDraw
+	for i := 0; i < len(rolls) && len(s.decks[gameID]) > 0; i++ {
+		deck := s.decks[gameID]
+		index := 0
+		if !ordered {
+			index = rollRNG(rolls[i]).Intn(len(deck))
+		}
+		card := deck[index]
+		s.decks[gameID] = append(deck[:index:index], deck[index+1:]...)
+		s.countDraw(gameID, username, card)
+		s.bumpVersion(gameID)
+
+		rules := &engine.Game{Deck: s.decks[gameID], Hand: s.hands[username], DefuseCount: s.defuse[username]}
+		outcome := string(rules.Settle(card, cardEffect(card), rollRNG(rolls[i])).Type)
+		s.decks[gameID], s.hands[username], s.defuse[username] = rules.Deck, rules.Hand, rules.DefuseCount
+		if outcome == DrawDefused {
+			s.incrGameField(gameID, defusesUsedField(username))
+		}
+		draws = append(draws, BatchDraw{Card: card, Outcome: outcome})
+		if outcome != DrawHeld || rules.Cleared() {
+			break
+		}
+	}
+	return draws, len(s.decks[gameID]), nil
+}
+
+func (s *memoryStore) GameState(ctx context.Context, gameID, username, roomCode string) (*GameState, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state := &GameState{
+		Remaining:   len(s.decks[gameID]),
+		Hand:        append([]string(nil), s.hands[username]...),
+		DefuseCount: s.defuse[username],
+		Status:      s.games[gameID]["status"],
+		Version:     s.gameVersion(gameID),
+	}
+	state.EventSeq, _ = strconv.ParseInt(s.games[gameID]["eventSeq"], 10, 64)
+	state.MustDiscard = s.games[gameID]["mustDiscard"]
+	state.BlockedCause = s.games[gameID]["blockedCause"]
+	state.MoveSeq, _ = strconv.ParseInt(s.games[gameID]["moveSeq"], 10, 64)
+	state.LastShuffleSeq, _ = strconv.ParseInt(s.games[gameID]["lastShuffleSeq"], 10, 64)
+	state.Mode = s.games[gameID]["mode"]
+	state.Commitment = s.games[gameID]["commitment"]
+	if _, deadline, err := parsePendingBomb(state.MustDiscard, state.BlockedCause, s.games[gameID]["bombDeadline"]); err == nil {
+		state.BombDeadline = deadline
+	}
+	if roomCode != "" {
+		state.TurnDeadline = roomStateFromHash(s.roomStates[roomCode]).TurnDeadline
+	}
+	return state, nil
+}
+
+func (s *memoryStore) SetGameMode(ctx context.Context, gameID, mode string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.gameHash(gameID)["mode"] = mode
+	return nil
+}
+
+func (s *memoryStore) DrawState(ctx context.Context, gameID, username string) (*DrawState, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	game := s.games[gameID]
+	state := &DrawState{
+		Status:      game["status"],
+		Version:     s.gameVersion(gameID),
+		MustDiscard: game["mustDiscard"],
+		// Nil for a game never blocked, which reads as ""
+		BlockedCause: game["blockedCause"],
+		Mode:         ModeClassic,
+		Defuses:      s.defuse[username],
+	}
+	if mode := game["mode"]; mode != "" {
+		state.Mode = mode
+	}
+	return state, nil
+}
+
+func (s *memoryStore) GameMode(ctx context.Context, gameID string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if mode := s.games[gameID]["mode"]; mode != "" {
+		return mode, nil
+	}
+	return ModeClassic, nil
+}
+
+func (s *memoryStore) SetDeckConfig(ctx context.Context, gameID, config string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.gameHash(gameID)["deckConfig"] = config
+	return nil
+}
+
+func (s *memoryStore) DeckConfig(ctx context.Context, gameID string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.games[gameID]["deckConfig"], nil
+}
+
+func (s *memoryStore) SetGameSeed(ctx context.Context, gameID, seed, commitment string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	game := s.gameHash(gameID)
+	game["seed"] = seed
+	game["commitment"] = commitment
+	return nil
+}
+
+func (s *memoryStore) GameSeed(ctx context.Context, gameID string) (string, string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.games[gameID]["seed"], s.games[gameID]["commitment"], nil
+}
+
+func (s *memoryStore) SetGameRNG(ctx context.Context, gameID, state string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.gameHash(gameID)["rng"] = state
+	return nil
+}
+
+func (s *memoryStore) GameRNG(ctx context.Context, gameID string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.games[gameID]["rng"], nil
+}
+
+func (s *memoryStore) SetPendingDraws(ctx context.Context, gameID, username string, count int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if count <= 0 {
+		delete(s.games[gameID], pendingDrawsField(username))
+		return nil
+	}
+	s.gameHash(gameID)[pendingDrawsField(username)] = strconv.Itoa(count)
+	return nil
+}
+
+func (s *memoryStore) PendingDraws(ctx context.Context, gameID, username string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	count, _ := strconv.Atoi(s.games[gameID][pendingDrawsField(username)])
+	return count, nil
+}
+
+func (s *memoryStore) PayPendingDraws(ctx context.Context, gameID, username string, draws int) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	owed, _ := strconv.Atoi(s.games[gameID][pendingDrawsField(username)])
+	left := owed - draws
+	if left <= 0 {
+		delete(s.games[gameID], pendingDrawsField(username))
+		return 0, nil
+	}
+	s.games[gameID][pendingDrawsField(username)] = strconv.Itoa(left)
+	return left, nil
+}
+
+func (s *memoryStore) SetDiscardBlock(ctx context.Context, gameID, username, cause string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	game := s.gameHash(gameID)
+	game["mustDiscard"] = username
+	game["blockedCause"] = cause
+	return nil
+}
+
+func (s *memoryStore) ClearDiscardBlock(ctx context.Context, gameID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.games[gameID], "mustDiscard")
+	delete(s.games[gameID], "blockedCause")
+	delete(s.games[gameID], "bombDeadline")
+	return nil
+}
+
+func (s *memoryStore) DiscardBlock(ctx context.Context, gameID string) (string, string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.games[gameID]["mustDiscard"], s.games[gameID]["blockedCause"], nil
+}
+
+func (s *memoryStore) SetPendingBomb(ctx context.Context, gameID, username string, deadline time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	game := s.gameHash(gameID)
+	game["mustDiscard"] = username
+	game["blockedCause"] = blockPendingDefuse
+	game["bombDeadline"] = strconv.FormatInt(deadline.UnixMilli(), 10)
+	return nil
+}
+
+func (s *memoryStore) PendingBomb(ctx context.Context, gameID string) (string, time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	game := s.games[gameID]
+	return parsePendingBomb(game["mustDiscard"], game["blockedCause"], game["bombDeadline"])
+}
+
+func (s *memoryStore) ClaimPendingBomb(ctx context.Context, gameID, username string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	game := s.games[gameID]
+	if game["mustDiscard"] != username || game["blockedCause"] != blockPendingDefuse {
+		return false, nil
+	}
+	delete(game, "mustDiscard")
+	delete(game, "blockedCause")
+	delete(game, "bombDeadline")
+	return true, nil
+}
+
+func (s *memoryStore) ClaimShuffle(ctx context.Context, gameID string, cooldown int) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	game := s.gameHash(gameID)
+	moveSeq, _ := strconv.ParseInt(game["moveSeq"], 10, 64)
+	last, _ := strconv.ParseInt(game["lastShuffleSeq"], 10, 64)
+	next := moveSeq + 1
+	if last > 0 && next-last < int64(cooldown) {
+		return false, nil
+	}
+	game["lastShuffleSeq"] = strconv.FormatInt(next, 10)
+	return true, nil
+}
+
+func (s *memoryStore) GetDefuse(ctx context.Context, username string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.defuse[username], nil
+}
+
+func (s *memoryStore) SetDefuse(ctx context.Context, username string, count int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.defuse[username] = count
+	return nil
+}
+
+func (s *memoryStore) GetHand(ctx context.Context, username string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.hands[username]...), nil
+}
+
+func (s *memoryStore) HoldCard(ctx context.Context, username string, card string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hands[username] = append(s.hands[username], card)
+	if card == "Defuse" {
+		s.defuse[username]++
+	}
+	return nil
+}
+
+func (s *memoryStore) RemoveFromHand(ctx context.Context, username string, card string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	hand, removed := removeFirst(s.hands[username], card)
+	if !removed {
+		return false, nil
+	}
+	s.hands[username] = hand
+	if card == "Defuse" {
+		s.defuse[username]--
+	}
+	return true, nil
+}
+
+func (s *memoryStore) UseDefuse(ctx context.Context, gameID, username string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hands[username], _ = removeFirst(s.hands[username], "Defuse")
+	s.defuse[username]--
+	s.incrGameField(gameID, defusesUsedField(username))
+	return s.defuse[username], nil
+}
+
+func (s *memoryStore) ClearHand(ctx context.Context, username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.hands, username)
+	s.defuse[username] = 0
+	return nil
+}
+
+func (s *memoryStore) DealHand(ctx context.Context, username string, cards []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hands[username] = append([]string(nil), cards...)
+	s.defuse[username] = defusesIn(cards)
+	return nil
+}
+
+func (s *memoryStore) TakeRandomCard(ctx context.Context, from, to string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	hand := s.hands[from]
+	if len(hand) == 0 {
+		return "", nil
+	}
+	card := hand[rand.Intn(len(hand))]
+	s.hands[from], _ = removeFirst(hand, card)
+	s.hands[to] = append(s.hands[to], card)
+	if card == "Defuse" {
+		s.defuse[from]--
+		s.defuse[to]++
+	}
+	return card, nil
+}
+
+func (s *memoryStore) StealWithPair(ctx context.Context, thief, victim, card string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if countOf(s.hands[thief], card) < 2 {
+		return "", errPairMissing
+	}
+	victimHand := s.hands[victim]
+	if len(victimHand) == 0 {
+		return "", errHandIsEmpty
+	}
+	stolen := victimHand[rand.Intn(len(victimHand))]
+
+	s.hands[thief], _ = removeFirst(s.hands[thief], card)
+	s.hands[thief], _ = removeFirst(s.hands[thief], card)
+	s.hands[victim], _ = removeFirst(victimHand, stolen)
+	s.hands[thief] = append(s.hands[thief], stolen)
+	if stolen == "Defuse" {
+		s.defuse[victim]--
+		s.defuse[thief]++
+	}
+	return stolen, nil
+}
+
+func (s *memoryStore) CreateRoom(ctx context.Context, code string, owner string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.rooms[code]; ok {
+		return false, nil
+	}
+	s.rooms[code] = Room{Code: code, Players: []string{owner}, Status: RoomWaiting, Size: minRoomSize}
+	return true, nil
+}
+
+func (s *memoryStore) GetRoom(ctx context.Context, code string) (*Room, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	room, ok := s.rooms[code]
+	if !ok {
+		return nil, nil
+	}
+	room.Players = append([]string(nil), room.Players...)
+	room.Eliminated = append([]string(nil), room.Eliminated...)
+	room.DisabledCards = append([]string(nil), room.DisabledCards...)
+	return &room, nil
+}
+
+func (s *memoryStore) JoinRoom(ctx context.Context, code string, username string) (*Room, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	room, ok := s.rooms[code]
+	if !ok {
+		return nil, errNoSuchRoom
+	}
+	room.Players = append([]string(nil), room.Players...)
+	room.Eliminated = append([]string(nil), room.Eliminated...)
+	if room.hasPlayer(username) {
+		return &room, nil
+	}
+	if room.Status != RoomWaiting || len(room.Players) >= room.Size {
+		return nil, errRoomIsFull
+	}
+	room.Players = append(room.Players, username)
+	s.rooms[code] = room
+	return &room, nil
+}
+
+func (s *memoryStore) UpdateRoom(ctx context.Context, room *Room) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, ok := s.rooms[room.Code]
+	if !ok {
+		return errNoSuchRoom
+	}
+	stored.Turn = room.Turn
+	stored.Status = room.Status
+	stored.BotDifficulty = room.BotDifficulty
+	stored.TurnTimeout = room.TurnTimeout
+	stored.Size = room.Size
+	stored.Mode = room.Mode
+	stored.Theme = room.Theme
+	s.rooms[room.Code] = stored
+	return nil
+}
+
+func (s *memoryStore) EliminatePlayer(ctx context.Context, code, username string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	room, ok := s.rooms[code]
+	if !ok {
+		return nil, errNoSuchRoom
+	}
+	if !room.isEliminated(username) {
+		room.Eliminated = append(append([]string(nil), room.Eliminated...), username)
+		s.rooms[code] = room
+	}
+	return append([]string(nil), room.Eliminated...), nil
+}
+
+func (s *memoryStore) ClaimTurn(ctx context.Context, code string, version int64) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	room, ok := s.rooms[code]
+	if !ok || room.TurnVersion != version {
+		return false, nil
+	}
+	room.TurnVersion++
+	s.rooms[code] = room
+	return true, nil
+}
+
+func (s *memoryStore) PauseRoom(ctx context.Context, code string, pause RoomPause) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	room, ok := s.rooms[code]
+	if !ok {
+		return false, errNoSuchRoom
+	}
+	if room.Pause != nil {
+		return false, nil
+	}
+	room.Pause = &pause
+	s.rooms[code] = room
+	return true, nil
+}
+
+func (s *memoryStore) ResumeRoom(ctx context.Context, code string, at time.Time) (*RoomPause, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	room, ok := s.rooms[code]
+	if !ok || room.Pause == nil {
+		return nil, nil
+	}
+	pause := room.Pause
+	if paused := at.Sub(pause.At).Milliseconds(); paused > 0 {
+		room.PausedMs += paused
+	}
+	room.Pause = nil
+	s.rooms[code] = room
+	return pause, nil
+}
+
+func (s *memoryStore) SetDisabledCards(ctx context.Context, code string, cards []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	room, ok := s.rooms[code]
+	if !ok {
+		return errNoSuchRoom
+	}
+	room.DisabledCards = append([]string(nil), cards...)
+	s.rooms[code] = room
+	return nil
+}
+
+func (s *memoryStore) SetRoomTournament(ctx context.Context, code, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	room, ok := s.rooms[code]
+	if !ok {
+		return errNoSuchRoom
+	}
+	room.Tournament = id
+	s.rooms[code] = room
+	return nil
+}
+
+func (s *memoryStore) CreateTournament(ctx context.Context, tournament *Tournament, ttl time.Duration) error {
+	payload, err := json.Marshal(tournament)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := s.keys.tournament(tournament.ID)
+	s.tournaments[key] = payload
+	s.expire(key, ttl)
+	return nil
+}
+
+// The tournament stored under key, nil if there is none. Callers hold the
+// mutex.
+func (s *memoryStore) tournament(key string) (*Tournament, error) {
+	if s.expired(key) {
+		delete(s.tournaments, key)
+	}
+	payload, ok := s.tournaments[key]
+	if !ok {
+		return nil, nil
+	}
+	var tournament Tournament
+	if err := json.Unmarshal(payload, &tournament); err != nil {
+		return nil, err
+	}
+	return &tournament, nil
+}
+
+func (s *memoryStore) GetTournament(ctx context.Context, id string) (*Tournament, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.tournament(s.keys.tournament(id))
+}
+
+func (s *memoryStore) UpdateTournament(ctx context.Context, id string, update func(*Tournament) error) (*Tournament, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := s.keys.tournament(id)
+	tournament, err := s.tournament(key)
+	if err != nil {
+		return nil, err
+	}
+	if tournament == nil {
+		return nil, errNoTournament
+	}
+	if err := update(tournament); err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(tournament)
+	if err != nil {
+		return nil, err
+	}
+	s.tournaments[key] = payload
+	return tournament, nil
+}
+
+func (s *memoryStore) EnqueueMatch(ctx context.Context, username string, at time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.matchQueuePosition(username) == 0 {
+		s.matchQueue = append(s.matchQueue, username)
+	}
+	return nil
+}
+
+func (s *memoryStore) MatchQueuePosition(ctx context.Context, username string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.matchQueuePosition(username), nil
+}
+
+// Callers hold the mutex
+func (s *memoryStore) matchQueuePosition(username string) int64 {
+	for i, queued := range s.matchQueue {
+		if queued == username {
+			return int64(i + 1)
+		}
+	}
+	return 0
+}
+
+func (s *memoryStore) LeaveMatchQueue(ctx context.Context, username string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var removed bool
+	s.matchQueue, removed = removeFirst(s.matchQueue, username)
+	return removed, nil
+}
+
+func (s *memoryStore) PopMatch(ctx context.Context) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.matchQueue) < 2 {
+		return nil, nil
+	}
+	pair := append([]string(nil), s.matchQueue[:2]...)
+	s.matchQueue = s.matchQueue[2:]
+	return pair, nil
+}
+
+func (s *memoryStore) RevokeInvites(ctx context.Context, code string, at time.Time, keep time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.revokedInvites[code] = at
+	for room, revoked := range s.revokedInvites {
+		if revoked.Before(at.Add(-keep)) {
+			delete(s.revokedInvites, room)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) InvitesRevokedAt(ctx context.Context, code string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.revokedInvites[code], nil
+}
+
+func (s *memoryStore) ActiveRooms(ctx context.Context) ([]*Room, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var rooms []*Room
+	for _, room := range s.rooms {
+		if room.Status == RoomActive {
+			room := room
+			room.Players = append([]string(nil), room.Players...)
+			rooms = append(rooms, &room)
+		}
+	}
+	return rooms, nil
+}
+
+func (s *memoryStore) InjectCard(ctx context.Context, code, card string, depth float64) (int, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if room, ok := s.rooms[code]; !ok || room.Status != RoomActive {
+		return 0, false, nil
+	}
+	gameID := roomGameIDPrefix + code
+	rules := &engine.Game{Deck: s.decks[gameID]}
+	rules.Insert(card, int(depth*float64(len(rules.Deck)+1)))
+	s.decks[gameID] = rules.Deck
+	s.bumpVersion(gameID)
+	return len(rules.Deck), true, nil
+}
+
+// The room's state hash, created on first use. Callers hold the mutex.
+func (s *memoryStore) roomState(code string) map[string]string {
+	state := s.roomStates[code]
+	if state == nil {
+		state = make(map[string]string)
+		s.roomStates[code] = state
+	}
+	return state
+}
+
+func (s *memoryStore) SetTurnDeadline(ctx context.Context, code string, deadline time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.roomState(code)["turnDeadline"] = strconv.FormatInt(deadline.UnixMilli(), 10)
+	return nil
+}
+
+func (s *memoryStore) SetPendingAction(ctx context.Context, code string, pending *PendingState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state := s.roomState(code)
+	if pending == nil {
+		for _, field := range pendingStateFields {
+			delete(state, field)
+		}
+		return nil
+	}
+	fields := pending.hashFields()
+	for i := 0; i < len(fields); i += 2 {
+		state[fields[i].(string)] = fmt.Sprint(fields[i+1])
+	}
+	return nil
+}
+
+func (s *memoryStore) GetRoomState(ctx context.Context, code string) (*RoomState, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return roomStateFromHash(s.roomStates[code]), nil
+}
+
+func (s *memoryStore) SetBalanceMode(ctx context.Context, code, mode string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.roomState(code)["balanceMode"] = mode
+	return nil
+}
+
+func (s *memoryStore) SetFirstPlayer(ctx context.Context, code, username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.roomState(code)["firstPlayer"] = username
+	return nil
+}
+
+func (s *memoryStore) DeleteRoomState(ctx context.Context, code string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.roomStates, code)
+	return nil
+}
+
+func (s *memoryStore) ResetStats(ctx context.Context, username string, audit AuditEntry) error {
+	_, err := s.SetStats(ctx, username, 0, 0, audit)
+	return err
+}
+
+func (s *memoryStore) GetStats(ctx context.Context, username string) (int64, int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.wins[username], s.loses[username], nil
+}
+
+func (s *memoryStore) SetStats(ctx context.Context, username string, win, lose int64, audit AuditEntry) (*PlayerStats, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	before := &PlayerStats{Wins: s.wins[username], Losses: s.loses[username]}
+	s.wins[username] = win
+	s.loses[username] = lose
+	audit.Before, audit.After = *before, PlayerStats{Wins: win, Losses: lose}
+	s.appendAudit(audit)
+	return before, nil
+}
+
+func (s *memoryStore) CompleteGame(ctx context.Context, result GameResult) (*GameCompletion, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if result.RoomCode != "" {
+		room, ok := s.rooms[result.RoomCode]
+		if ok && room.Status != "" && room.Status != RoomActive {
+			return &GameCompletion{}, nil
+		}
+		if ok {
+			room.Status = result.Status
+			s.rooms[result.RoomCode] = room
+		}
+	} else {
+		game := s.gameHash(result.GameID)
+		if status := game["status"]; status != "" && status != GameStatusActive {
+			return &GameCompletion{}, nil
+		}
+		game["status"] = result.Status
+		s.bumpVersion(result.GameID)
+	}
+	s.countDailyStats(result)
+	if result.HeadToHead {
+		key := s.keys.headToHead(result.Winner, result.Loser)
+		record := s.headToHead[key]
+		if record == nil {
+			record = make(map[string]string)
+			s.headToHead[key] = record
+		}
+		for _, field := range []string{headToHeadWinsField(result.Winner), "games"} {
+			count, _ := strconv.ParseInt(record[field], 10, 64)
+			record[field] = strconv.FormatInt(count+1, 10)
+		}
+		record["lastPlayed"] = strconv.FormatInt(result.EndedAt.UnixMilli(), 10)
+	}
+	var outboxID string
+	if result.Announcement != nil {
+		s.outboxSeq++
+		outboxID = strconv.FormatInt(s.outboxSeq, 10)
+		s.outbox = append(s.outbox, memoryOutboxEntry{
+			OutboxEntry: OutboxEntry{ID: outboxID, Payload: result.Announcement},
+			handedOut:   time.Now(),
+		})
+	}
+
+	completion := s.creditResult(result.Winner, result.Loser, result.Audit)
+	if result.Partner != "" {
+		if result.Winner != "" {
+			completion.Partner = s.creditResult(result.Partner, "", result.Audit)
+		} else {
+			completion.Partner = s.creditResult("", result.Partner, result.Audit)
+		}
+	}
+	completion.OutboxID = outboxID
+	return completion, nil
+}
+
+// A game's completion is one write here, never left part way
+func (s *memoryStore) FinishCompletions(ctx context.Context, endedBefore time.Time) (int, error) {
+	return 0, nil
+}
+
+// An outbox entry and when it was last handed out, or added if it never was
+type memoryOutboxEntry struct {
+	OutboxEntry
+	handedOut time.Time
+}
+
+func (s *memoryStore) ClaimOutbox(ctx context.Context, consumer string, idle time.Duration, count int) ([]OutboxEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	var entries []OutboxEntry
+	for i := range s.outbox {
+		if len(entries) == count {
+			break
+		}
+		if now.Sub(s.outbox[i].handedOut) >= idle {
+			s.outbox[i].handedOut = now
+			entries = append(entries, s.outbox[i].OutboxEntry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *memoryStore) AckOutbox(ctx context.Context, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, entry := range s.outbox {
+		if entry.ID == id {
+			s.outbox = append(s.outbox[:i], s.outbox[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Count the ended game in the stats of its day. Callers hold the mutex.
+func (s *memoryStore) countDailyStats(result GameResult) {
+	stats := s.daily[result.Day]
+	stats.Games++
+	for field, value := range s.games[result.GameID] {
+		count, _ := strconv.ParseInt(value, 10, 64)
+		switch {
+		case field == "cardsDrawn":
+			stats.Draws += count
+		case strings.HasPrefix(field, defusesUsedPrefix):
+			stats.Defused += count
+		case strings.HasPrefix(field, drawCountPrefix) && strings.HasSuffix(field, bombDrawSuffix):
+			stats.Bombs += count
+		}
+	}
+	if result.Exploded {
+		stats.Exploded++
+	}
+	s.daily[result.Day] = stats
+}
+
+func (s *memoryStore) HeadToHead(ctx context.Context, a, b string) (HeadToHeadRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return parseHeadToHead(a, b, s.headToHead[s.keys.headToHead(a, b)]), nil
+}
+
+func (s *memoryStore) DailyStats(ctx context.Context, days []string) ([]DailyStats, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := make([]DailyStats, len(days))
+	for i, day := range days {
+		stats[i] = s.daily[day]
+		stats[i].Day = day
+	}
+	return stats, nil
+}
+
+// Credit the winner and the loser of an ended game, either of which may be
+// "". Callers hold the mutex.
+func (s *memoryStore) creditResult(winner, loser string, entry AuditEntry) *GameCompletion {
+	completion := &GameCompletion{Completed: true}
+	if winner != "" {
+		s.wins[winner]++
+		s.streak[winner]++
+		completion.Wins = s.wins[winner]
+		completion.Streak = s.streak[winner]
+
+		audit := entry
+		audit.Action, audit.Username = winKey, winner
+		audit.Before = map[string]int64{"wins": completion.Wins - 1, "streak": completion.Streak - 1}
+		audit.After = map[string]int64{"wins": completion.Wins, "streak": completion.Streak}
+		s.appendAudit(audit)
+	}
+	if loser != "" {
+		s.loses[loser]++
+		s.streak[loser] = 0
+		completion.Losses = s.loses[loser]
+
+		audit := entry
+		audit.Action, audit.Username = loseKey, loser
+		audit.Before = map[string]int64{"losses": completion.Losses - 1}
+		audit.After = map[string]int64{"losses": completion.Losses}
+		s.appendAudit(audit)
+	}
+	return completion
+}
+
+func (s *memoryStore) AwardAchievement(ctx context.Context, username, name string, at time.Time) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, earned := range s.earned[username] {
+		if earned.Name == name {
+			return false, nil
+		}
+	}
+	s.earned[username] = append(s.earned[username], Achievement{Name: name, EarnedAt: at.Truncate(time.Second).UTC()})
+	return true, nil
+}
+
+func (s *memoryStore) Achievements(ctx context.Context, username string) ([]Achievement, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Achievement(nil), s.earned[username]...), nil
+}
+
+func (s *memoryStore) LeaderboardStats(ctx context.Context, pageSize int) StatsIterator {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	usernames := make([]string, 0, len(s.wins))
+	for username := range s.wins {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return &memoryStatsIterator{store: s, pageSize: pageSize, usernames: usernames}
+}
+
+// Pages through the users who had wins counted when it was created, reading
+// each page's counts as it gets to it
+type memoryStatsIterator struct {
+	store     *memoryStore
+	pageSize  int
+	usernames []string
+}
+
+func (it *memoryStatsIterator) Next(ctx context.Context) ([]UserStats, bool) {
+	if len(it.usernames) == 0 {
+		return nil, false
+	}
+	n := it.pageSize
+	if n > len(it.usernames) {
+		n = len(it.usernames)
+	}
+	it.store.mutex.Lock()
+	defer it.store.mutex.Unlock()
+	page := make([]UserStats, n)
+	for i, username := range it.usernames[:n] {
+		page[i] = UserStats{Username: username, Win: it.store.wins[username], Lose: it.store.loses[username]}
+	}
+	it.usernames = it.usernames[n:]
+	return page, true
+}
+
+func (it *memoryStatsIterator) Err() error {
+	return nil
+}
+
+func (s *memoryStore) RecordWindowResult(ctx context.Context, bucket, username string, isWin bool, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := s.keys.window(bucket, isWin)
+	if s.windows[key] == nil || s.expired(key) {
+		s.windows[key] = make(map[string]int64)
+	}
+	s.windows[key][username]++
+	s.expire(key, ttl)
+	return nil
+}
+
+func (s *memoryStore) WindowLeaderboard(ctx context.Context, bucket string) ([]UserStats, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, key := range []string{s.keys.window(bucket, true), s.keys.window(bucket, false)} {
+		if s.expired(key) {
+			delete(s.windows, key)
+		}
+	}
+	counts := make(map[string]*UserStats)
+	count := func(username string) *UserStats {
+		if counts[username] == nil {
+			counts[username] = &UserStats{Username: username}
+		}
+		return counts[username]
+	}
+	for username, wins := range s.windows[s.keys.window(bucket, true)] {
+		count(username).Win = wins
+	}
+	for username, loses := range s.windows[s.keys.window(bucket, false)] {
+		count(username).Lose = loses
+	}
+	return statsOf(counts), nil
+}
+func (s *memoryStore) RecordSurvivalScore(ctx context.Context, username string, score int64) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if best, ok := s.survival[username]; !ok || score > best {
+		s.survival[username] = score
+	}
+	return s.survival[username], nil
+}
+
+func (s *memoryStore) SurvivalLeaderboard(ctx context.Context, offset, limit int) ([]SurvivalEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entries := make([]SurvivalEntry, 0, len(s.survival))
+	for username, score := range s.survival {
+		entries = append(entries, SurvivalEntry{Username: username, Score: score})
+	}
+	sortSurvivalEntries(entries)
+	rankSurvivalEntries(entries, 0, 1)
+	return entries[min(offset, len(entries)):min(offset+limit, len(entries))], nil
+}
+
+func (s *memoryStore) AppendAudit(ctx context.Context, entry AuditEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.appendAudit(entry)
+	return nil
+}
+
+// Append the entry under an ID made like Redis makes stream IDs, from its
+// time and a sequence, and drop the oldest entries past the cap
+func (s *memoryStore) appendAudit(entry AuditEntry) {
+	id := streamID{ms: entry.At.UnixMilli()}
+	if len(s.audit) > 0 {
+		last, _ := parseStreamID(s.audit[len(s.audit)-1].ID)
+		if !id.after(last) {
+			id = last.next()
+		}
+	}
+	entry.ID = id.String()
+	s.audit = append(s.audit, entry)
+	if int64(len(s.audit)) > s.retention.Audit {
+		s.audit = s.audit[int64(len(s.audit))-s.retention.Audit:]
+	}
+}
+
+func (s *memoryStore) AuditLog(ctx context.Context, since *streamID, limit int) ([]AuditEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var entries []AuditEntry
+	for _, entry := range s.audit {
+		if len(entries) == limit {
+			break
+		}
+		if id, _ := parseStreamID(entry.ID); since == nil || !since.next().after(id) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *memoryStore) NextEventSeq(ctx context.Context, stream string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	game := s.gameHash(stream)
+	seq, _ := strconv.ParseInt(game["eventSeq"], 10, 64)
+	seq++
+	game["eventSeq"] = strconv.FormatInt(seq, 10)
+	return seq, nil
+}
+
+func (s *memoryStore) AppendEvent(ctx context.Context, stream string, event []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.expired(s.keys.events(stream)) {
+		delete(s.events, stream)
+	}
+	s.events[stream] = cappedAppend(s.events[stream], event, s.retention.Events)
+	s.expire(s.keys.events(stream), s.retention.LogTTL)
+	return nil
+}
+
+func (s *memoryStore) EventHistory(ctx context.Context, stream string) ([][]byte, int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.expired(s.keys.events(stream)) {
+		delete(s.events, stream)
+	}
+	seq, _ := strconv.ParseInt(s.games[stream]["eventSeq"], 10, 64)
+	return append([][]byte(nil), s.events[stream]...), seq, nil
+}
+
+func (s *memoryStore) NextMoveSeq(ctx context.Context, gameID string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	game := s.gameHash(gameID)
+	seq, _ := strconv.ParseInt(game["moveSeq"], 10, 64)
+	seq++
+	game["moveSeq"] = strconv.FormatInt(seq, 10)
+	return seq, nil
+}
+
+func (s *memoryStore) AppendMove(ctx context.Context, gameID string, move []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.expired(s.keys.moves(gameID)) {
+		delete(s.moves, gameID)
+	}
+	s.moves[gameID] = cappedAppend(s.moves[gameID], move, s.retention.Moves)
+	s.expire(s.keys.moves(gameID), s.retention.LogTTL)
+	return nil
+}
+
+func (s *memoryStore) AppendChat(ctx context.Context, code string, message []byte, limit int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.chats[code] = cappedAppend(s.chats[code], message, limit)
+	return nil
+}
+
+func (s *memoryStore) RoomChat(ctx context.Context, code string) ([][]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([][]byte(nil), s.chats[code]...), nil
+}
+
+func (s *memoryStore) Moves(ctx context.Context, gameID string) ([][]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.expired(s.keys.moves(gameID)) {
+		delete(s.moves, gameID)
+	}
+	return append([][]byte(nil), s.moves[gameID]...), nil
+}
+
+func (s *memoryStore) SweepFinishedGames(ctx context.Context, before time.Time) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var swept []string
+	for gameID, game := range s.games {
+		finished, err := strconv.ParseInt(game["finishedAt"], 10, 64)
+		if err != nil || finished >= before.UnixMilli() {
+			continue
+		}
+		delete(s.decks, gameID)
+		delete(s.events, gameID)
+		delete(s.moves, gameID)
+		if code, ok := roomCodeFromGameID(gameID); ok {
+			delete(s.games, gameID)
+			delete(s.rooms, code)
+			delete(s.roomStates, code)
+			delete(s.chats, code)
+		} else {
+			delete(game, "finishedAt")
+		}
+		swept = append(swept, gameID)
+	}
+	return swept, nil
+}
+
+// Every key is measured, by the length of the strings it holds
+// Only decks can hold what the memory store's types leave room to go wrong
+func (s *memoryStore) CheckConsistency(ctx context.Context, report *ConsistencyReport) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for gameID, deck := range s.decks {
+		report.Checked++
+		retired := retiredCards(deck)
+		if len(retired) == 0 {
+			continue
+		}
+		kept := make([]string, 0, len(deck))
+		for _, card := range deck {
+			if _, ok := lookupCard(card); ok {
+				kept = append(kept, card)
+			}
+		}
+		s.decks[gameID] = kept
+		report.repaired("dropped retired cards %q from %s", retired, s.keys.deck(gameID))
+	}
+	return nil
+}
+
+func (s *memoryStore) StorageUsage(ctx context.Context, samples int) ([]KeyUsage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tally := newStorageTally(math.MaxInt)
+	add := func(key string, bytes int) {
+		pattern, _ := tally.count(key)
+		tally.sample(pattern, int64(bytes))
+	}
+	for gameID, deck := range s.decks {
+		add(s.keys.deck(gameID), stringsSize(deck))
+	}
+	for gameID, game := range s.games {
+		add(s.keys.game(gameID), hashSize(game))
+	}
+	for username, hand := range s.hands {
+		add(s.keys.hand(username), stringsSize(hand))
+	}
+	for stream, events := range s.events {
+		add(s.keys.events(stream), entriesSize(events))
+	}
+	for gameID, moves := range s.moves {
+		add(s.keys.moves(gameID), entriesSize(moves))
+	}
+	for code, chat := range s.chats {
+		add(s.keys.chat(code), entriesSize(chat))
+	}
+	for code, room := range s.rooms {
+		add(s.keys.room(code), len(room.Code)+len(room.Turn)+len(room.Status)+stringsSize(room.Players))
+	}
+	for code, state := range s.roomStates {
+		add(s.keys.roomState(code), hashSize(state))
+	}
+	for key, entry := range s.idem {
+		add(key, len(entry.response))
+	}
+	for username, earned := range s.earned {
+		bytes := 0
+		for _, achievement := range earned {
+			bytes += len(achievement.Name)
+		}
+		add(s.keys.achievements(username), bytes)
+	}
+	for token, username := range s.sessions {
+		add(s.keys.session(token), len(username))
+	}
+	for username, profile := range s.profiles {
+		add(s.keys.profile(username), len(profile.DisplayName)+len(profile.AvatarEmoji))
+	}
+	for key, bucket := range s.windows {
+		bytes := 0
+		for username := range bucket {
+			bytes += len(username)
+		}
+		add(key, bytes)
+	}
+	if len(s.audit) > 0 {
+		size := 0
+		for _, entry := range s.audit {
+			encoded, _ := json.Marshal(entry)
+			size += len(encoded)
+		}
+		add(auditKey, size)
+	}
+	return tally.report(), nil
+}
+
+func stringsSize(values []string) int {
+	size := 0
+	for _, value := range values {
+		size += len(value)
+	}
+	return size
+}
+
+func entriesSize(entries [][]byte) int {
+	size := 0
+	for _, entry := range entries {
+		size += len(entry)
+	}
+	return size
+}
+
+func hashSize(hash map[string]string) int {
+	size := 0
+	for field, value := range hash {
+		size += len(field) + len(value)
+	}
+	return size
+}
+
+func (s *memoryStore) ClaimIdempotencyKey(ctx context.Context, gameID, key string, ttl time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	k := s.keys.idempotency(gameID, key)
+	if entry, ok := s.idem[k]; ok && time.Now().Before(entry.expires) {
+		return false, nil
+	}
+	s.idem[k] = idempotentEntry{expires: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (s *memoryStore) GetIdempotentResponse(ctx context.Context, gameID, key string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.idem[s.keys.idempotency(gameID, key)]
+	if !ok || !time.Now().Before(entry.expires) {
+		return nil, nil
+	}
+	return entry.response, nil
+}
+
+func (s *memoryStore) SaveIdempotentResponse(ctx context.Context, gameID, key string, response []byte, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.idem[s.keys.idempotency(gameID, key)] = idempotentEntry{
+		response: append([]byte(nil), response...),
+		expires:  time.Now().Add(ttl),
+	}
+	return nil
+}
+
+func (s *memoryStore) ReleaseIdempotencyKey(ctx context.Context, gameID, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.idem, s.keys.idempotency(gameID, key))
+	return nil
+}
+
+func (s *memoryStore) UserExists(ctx context.Context, username string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.userExists(username), nil
+}
+
+func (s *memoryStore) userExists(username string) bool {
+	_, won := s.wins[username]
+	_, lost := s.loses[username]
+	_, dealt := s.decks[username]
+	_, held := s.hands[username]
+	_, defuse := s.defuse[username]
+	_, playing := s.games[username]
+	_, profiled := s.profiles[username]
+	return won || lost || dealt || held || defuse || playing || profiled || len(s.earned[username]) > 0
+}
+
+func (s *memoryStore) CreateGuest(ctx context.Context, username string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.userExists(username) {
+		return false, nil
+	}
+	s.wins[username] = 0
+	s.loses[username] = 0
+	s.guests[username] = true
+	return true, nil
+}
+
+func (s *memoryStore) Guests(ctx context.Context) (map[string]bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	guests := make(map[string]bool, len(s.guests))
+	for name := range s.guests {
+		guests[name] = true
+	}
+	return guests, nil
+}
+
+func (s *memoryStore) RecordFinish(ctx context.Context, username string, at time.Time, window time.Duration) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	recent := s.finishes[username][:0:0]
+	for _, finished := range s.finishes[username] {
+		if !finished.Before(at.Add(-window)) {
+			recent = append(recent, finished)
+		}
+	}
+	s.finishes[username] = append(recent, at)
+	return int64(len(s.finishes[username])), nil
+}
+
+func (s *memoryStore) FlagUser(ctx context.Context, username, reason string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, flagged := s.flagged[username]; flagged {
+		return false, nil
+	}
+	s.flagged[username] = reason
+	return true, nil
+}
+
+func (s *memoryStore) UnflagUser(ctx context.Context, username string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, flagged := s.flagged[username]
+	delete(s.flagged, username)
+	return flagged, nil
+}
+
+func (s *memoryStore) FlaggedUsers(ctx context.Context) (map[string]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	flagged := make(map[string]string, len(s.flagged))
+	for username, reason := range s.flagged {
+		flagged[username] = reason
+	}
+	return flagged, nil
+}
+
+func (s *memoryStore) SaveAPIKey(ctx context.Context, key APIKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key.Scopes = append([]string(nil), key.Scopes...)
+	s.apiKeys[key.ID] = key
+	return nil
+}
+
+func (s *memoryStore) APIKey(ctx context.Context, id string) (*APIKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key, ok := s.apiKeys[id]
+	if !ok {
+		return nil, nil
+	}
+	key.Scopes = append([]string(nil), key.Scopes...)
+	return &key, nil
+}
+
+func (s *memoryStore) APIKeys(ctx context.Context) ([]APIKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keys := make([]APIKey, 0, len(s.apiKeys))
+	for _, key := range s.apiKeys {
+		key.Scopes = append([]string(nil), key.Scopes...)
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *memoryStore) RevokeAPIKey(ctx context.Context, id string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.apiKeys[id]
+	delete(s.apiKeys, id)
+	return ok, nil
+}
+
+func (s *memoryStore) RenameUser(ctx context.Context, from, to string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.userExists(to) {
+		return errUsernameTaken
+	}
+	renameKey(s.decks, from, to)
+	renameKey(s.games, from, to)
+	renameKey(s.hands, from, to)
+	renameKey(s.defuse, from, to)
+	renameKey(s.wins, from, to)
+	renameKey(s.loses, from, to)
+	renameKey(s.events, from, to)
+	renameKey(s.moves, from, to)
+	renameKey(s.expires, s.keys.events(from), s.keys.events(to))
+	renameKey(s.expires, s.keys.moves(from), s.keys.moves(to))
+	renameKey(s.streak, from, to)
+	renameKey(s.earned, from, to)
+	renameKey(s.finishes, from, to)
+	renameKey(s.flagged, from, to)
+	renameKey(s.profiles, from, to)
+	delete(s.guests, from)
+	return nil
+}
+
+// Move m[from] to m[to], if it is set
+func renameKey[V any](m map[string]V, from, to string) {
+	if v, ok := m[from]; ok {
+		m[to] = v
+		delete(m, from)
+	}
+}
+
+func (s *memoryStore) DeleteUser(ctx context.Context, username string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var removed []string
+	note := func(key string, present bool) {
+		if present {
+			removed = append(removed, key)
+		}
+	}
+	_, defuse := s.defuse[username]
+	_, streak := s.streak[username]
+	_, limited := s.limits[username]
+	note(s.keys.user(username), defuse || streak || limited)
+	note(s.keys.hand(username), len(s.hands[username]) > 0)
+	note(s.keys.deck(username), len(s.decks[username]) > 0)
+	note(s.keys.game(username), len(s.games[username]) > 0)
+	note(s.keys.achievements(username), len(s.earned[username]) > 0)
+	note(s.keys.events(username), len(s.events[username]) > 0)
+	note(s.keys.moves(username), len(s.moves[username]) > 0)
+	note(s.keys.finishes(username), len(s.finishes[username]) > 0)
+	_, profiled := s.profiles[username]
+	note(s.keys.profile(username), profiled)
+	_, locked := s.locks[s.keys.gameLock(username, username)]
+	note(s.keys.gameLock(username, username), locked)
+	note(s.keys.activeGames(username), len(s.activeGames[username]) > 0)
+	_, pending := s.pendingDraws[username]
+	note(s.keys.pendingDraw(username), pending)
+	_, won := s.wins[username]
+	note(winKey, won)
+	_, lost := s.loses[username]
+	note(loseKey, lost)
+	for key, counts := range s.windows {
+		_, ranked := counts[username]
+		note(key, ranked)
+		delete(counts, username)
+	}
+	_, survived := s.survival[username]
+	note(survivalKey, survived)
+	_, online := s.online[username]
+	note(onlineKey, online)
+	note(guestsKey, s.guests[username])
+	_, flagged := s.flagged[username]
+	note(flaggedKey, flagged)
+	note(seededKey, s.seeded[username])
+	started := s.keys.gamesStarted(username, "")
+	for key := range s.gamesStarted {
+		if strings.HasPrefix(key, started) {
+			note(key, true)
+			delete(s.gamesStarted, key)
+		}
+	}
+
+	delete(s.defuse, username)
+	delete(s.streak, username)
+	delete(s.hands, username)
+	delete(s.decks, username)
+	delete(s.games, username)
+	delete(s.earned, username)
+	delete(s.events, username)
+	delete(s.moves, username)
+	delete(s.expires, s.keys.events(username))
+	delete(s.expires, s.keys.moves(username))
+	delete(s.wins, username)
+	delete(s.loses, username)
+	delete(s.survival, username)
+	delete(s.online, username)
+	delete(s.guests, username)
+	delete(s.finishes, username)
+	delete(s.flagged, username)
+	delete(s.seeded, username)
+	delete(s.profiles, username)
+	delete(s.locks, s.keys.gameLock(username, username))
+	delete(s.expires, s.keys.gameLock(username, username))
+	delete(s.activeGames, username)
+	delete(s.limits, username)
+	delete(s.pendingDraws, username)
+	sort.Strings(removed)
+	return removed, nil
+}
+
+func (s *memoryStore) SetProfile(ctx context.Context, username string, profile Profile) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.profiles[username] = profile
+	return nil
+}
+
+func (s *memoryStore) Profiles(ctx context.Context, usernames []string) (map[string]Profile, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	profiles := make(map[string]Profile)
+	for _, username := range usernames {
+		if profile, ok := s.profiles[username]; ok {
+			profiles[username] = profile
+		}
+	}
+	return profiles, nil
+}
+
+func (s *memoryStore) SeedUsers(ctx context.Context, users []SeedUser) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, user := range users {
+		name := user.Username
+		s.wins[name] = user.Wins
+		s.loses[name] = user.Losses
+		s.streak[name] = user.Streak
+		s.defuse[name] = 0
+		s.games[name] = map[string]string{
+			"status":        user.Status,
+			"gamesPlayed":   strconv.FormatInt(user.GamesPlayed, 10),
+			"mode":          ModeClassic,
+			"schemaVersion": strconv.Itoa(currentGameSchema),
+			"version":       "1",
+		}
+		delete(s.decks, name)
+		if len(user.Deck) > 0 {
+			s.decks[name] = append([]string(nil), user.Deck...)
+			s.games[name]["deckVersion"] = strconv.Itoa(orderedDeckVersion)
+		}
+		delete(s.moves, name)
+		if len(user.Moves) > 0 {
+			s.moves[name] = append([][]byte(nil), user.Moves...)
+			s.expire(s.keys.moves(name), s.retention.LogTTL)
+		}
+		s.seeded[name] = true
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteSeeded(ctx context.Context) (int, error) {
+	s.mutex.Lock()
+	users := s.seeded
+	s.seeded = make(map[string]bool)
+	s.mutex.Unlock()
+	for username := range users {
+		if _, err := s.DeleteUser(ctx, username); err != nil {
+			return 0, err
+		}
+	}
+	return len(users), nil
+}
+
+func (s *memoryStore) TouchPresence(ctx context.Context, username string, at time.Time) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, present := s.online[username]
+	s.online[username] = at
+	return !present, nil
+}
+
+func (s *memoryStore) OnlineUsers(ctx context.Context, since time.Time) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var users []string
+	for username, seen := range s.online {
+		if !seen.Before(since) {
+			users = append(users, username)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return s.online[users[i]].After(s.online[users[j]]) })
+	return users, nil
+}
+
+func (s *memoryStore) ExpirePresence(ctx context.Context, before time.Time) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var gone []string
+	for username, seen := range s.online {
+		if seen.Before(before) {
+			gone = append(gone, username)
+			delete(s.online, username)
+		}
+	}
+	return gone, nil
+}
+
+func (s *memoryStore) AcquireGameLock(ctx context.Context, gameID, username, deviceID string, ttl time.Duration) (string, time.Duration, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := s.keys.gameLock(gameID, username)
+	if s.expired(key) {
+		delete(s.locks, key)
+	}
+	if holder, ok := s.locks[key]; ok && holder != deviceID {
+		return holder, time.Until(s.expires[key]), nil
+	}
+	s.locks[key] = deviceID
+	s.expire(key, ttl)
+	return "", 0, nil
+}
+
+func (s *memoryStore) TakeOverGameLock(ctx context.Context, gameID, username, deviceID string, ttl time.Duration) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := s.keys.gameLock(gameID, username)
+	if s.expired(key) {
+		delete(s.locks, key)
+	}
+	holder := s.locks[key]
+	s.locks[key] = deviceID
+	s.expire(key, ttl)
+	return holder, nil
+}
+
+func (s *memoryStore) LockGameCreation(ctx context.Context, username, token string, ttl time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := s.keys.creating(username)
+	if s.expired(key) {
+		delete(s.locks, key)
+	}
+	if _, ok := s.locks[key]; ok {
+		return false, nil
+	}
+	s.locks[key] = token
+	s.expire(key, ttl)
+	return true, nil
+}
+
+func (s *memoryStore) UnlockGameCreation(ctx context.Context, username, token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := s.keys.creating(username)
+	if s.expired(key) || s.locks[key] != token {
+		return nil
+	}
+	delete(s.locks, key)
+	delete(s.expires, key)
+	return nil
+}
+
+func (s *memoryStore) ClaimGameSlot(ctx context.Context, username, gameID, day string, maxActive, daily int64, ttl time.Duration) (*GameSlot, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if limit := s.limits[username].MaxActiveGames; limit > 0 {
+		maxActive = limit
+	}
+	if limit := s.limits[username].DailyGames; limit > 0 {
+		daily = limit
+	}
+	if s.activeGames[username] == nil {
+		s.activeGames[username] = make(map[string]bool)
+	}
+	active := s.activeGames[username]
+	for id := range active {
+		if s.games[id] == nil {
+			delete(active, id)
+		}
+	}
+	key := s.keys.gamesStarted(username, day)
+	if s.expired(key) {
+		delete(s.gamesStarted, key)
+	}
+
+	slot := &GameSlot{Limit: maxActive, Started: s.gamesStarted[key]}
+	switch {
+	case slot.Started >= daily:
+		slot.Refused, slot.Limit = QuotaDailyGames, daily
+	case !active[gameID] && int64(len(active)) >= maxActive:
+		slot.Refused = QuotaActiveGames
+	default:
+		active[gameID] = true
+		s.gamesStarted[key]++
+		s.expire(key, ttl)
+		slot.Started = s.gamesStarted[key]
+	}
+	for id := range active {
+		slot.Active = append(slot.Active, id)
+	}
+	sort.Strings(slot.Active)
+	return slot, nil
+}
+
+func (s *memoryStore) TrackActiveGame(ctx context.Context, gameID string, usernames []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, username := range usernames {
+		if s.activeGames[username] == nil {
+			s.activeGames[username] = make(map[string]bool)
+		}
+		s.activeGames[username][gameID] = true
+	}
+	return nil
+}
+
+func (s *memoryStore) ReleaseActiveGame(ctx context.Context, gameID string, usernames []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, username := range usernames {
+		delete(s.activeGames[username], gameID)
+	}
+	return nil
+}
+
+func (s *memoryStore) GameLimits(ctx context.Context, username string) (GameLimits, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.limits[username], nil
+}
+
+func (s *memoryStore) SetGameLimits(ctx context.Context, username string, limits GameLimits) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if limits.MaxActiveGames < 0 {
+		limits.MaxActiveGames = 0
+	}
+	if limits.DailyGames < 0 {
+		limits.DailyGames = 0
+	}
+	if limits == (GameLimits{}) {
+		delete(s.limits, username)
+	} else {
+		s.limits[username] = limits
+	}
+	return nil
+}
+
+func (s *memoryStore) CreateSession(ctx context.Context, token, username string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sessions[token] = username
+	s.expire(s.keys.session(token), ttl)
+	return nil
+}
+
+func (s *memoryStore) SessionUser(ctx context.Context, token string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.expired(s.keys.session(token)) {
+		delete(s.sessions, token)
+	}
+	return s.sessions[token], nil
+}
+
+func (s *memoryStore) DeleteSession(ctx context.Context, token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.sessions, token)
+	delete(s.expires, s.keys.session(token))
+	return nil
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error {
+	return nil
+}
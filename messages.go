@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Locale used when the client asks for none we have
+const defaultLocale = "en"
+
+// IDs of the messages in responses, sent as messageId so clients can render
+// their own text
+const (
+	MsgCardHeld       = "card_held"
+	MsgActionCardHeld = "action_card_held"
+	MsgDefuseHeld     = "defuse_held"
+	MsgBombDefused    = "bomb_defused"
+	MsgBombPending    = "bomb_pending"
+	MsgReshuffled     = "reshuffled"
+	MsgShuffleCooling = "shuffle_cooling_down"
+	MsgExploded       = "exploded"
+	MsgEliminated     = "eliminated"
+	MsgSurvivalOver   = "survival_over"
+	MsgLossForced     = "loss_forced"
+	MsgWinEmptyHand   = "win_empty_hand"
+	MsgWinHolding     = "win_holding"
+	MsgCoopWin        = "coop_win"
+	MsgDeckCleared    = "deck_cleared"
+	MsgDeckEmpty      = "deck_empty"
+	MsgDeckEmptyDraw  = "deck_empty_draw"
+	MsgForfeited      = "forfeited"
+)
+
+// Message templates by locale, then message ID. Arguments are filled in with
+// fmt, so every locale takes the same ones in the same order.
+var messageCatalog = map[string]map[string]string{
+	"en": {
+		MsgCardHeld:       "You drew a %s card! It has been added to your hand.",
+		MsgActionCardHeld: "You drew a %s card! Play it from your hand when you need it.",
+		MsgDefuseHeld:     "You drew a %s card! Keep this to defuse an Exploding Kitten.",
+		MsgBombDefused:    "You defused the Exploding Kitten using your Defuse card!",
+		MsgBombPending:    "You drew an Exploding Kitten! Use your Defuse or accept the explosion within %d seconds.",
+		MsgReshuffled:     "You drew a Shuffle card! The deck is reshuffled.",
+		MsgShuffleCooling: "You drew a Shuffle card, but the deck was shuffled too recently. Nothing happens.",
+		MsgExploded:       "You drew an Exploding Kitten! You lose! Total losses: %d",
+		MsgEliminated:     "You drew an Exploding Kitten and are out of the game! You can keep watching until it ends.",
+		MsgSurvivalOver:   "You drew an Exploding Kitten after surviving %d draws! Best run: %d",
+		MsgLossForced:     "Only Exploding Kittens are left and you have no Defuse: your run ends after surviving %d draws! Best run: %d",
+		MsgWinEmptyHand:   "You win with an empty hand! Total wins: %d",
+		MsgWinHolding:     "You win holding %s! Total wins: %d",
+		MsgCoopWin:        "You and %s win together! Total wins: %d",
+		MsgDeckCleared:    "Only Exploding Kittens are left in the deck.",
+		MsgDeckEmpty:      "No cards left in the deck.",
+		MsgDeckEmptyDraw:  "No cards left in the deck. The game is a draw.",
+		MsgForfeited:      "You forfeited the game. Total losses: %d",
+	},
+	"es": {
+		MsgCardHeld:       "¡Robaste una carta %s! Se ha añadido a tu mano.",
+		MsgActionCardHeld: "¡Robaste una carta %s! Juégala desde tu mano cuando la necesites.",
+		MsgDefuseHeld:     "¡Robaste una carta %s! Guárdala para desactivar un Gatito Explosivo.",
+		MsgBombDefused:    "¡Desactivaste el Gatito Explosivo con tu carta Desactivar!",
+		MsgBombPending:    "¡Robaste un Gatito Explosivo! Usa tu carta Desactivar o acepta la explosión en %d segundos.",
+		MsgReshuffled:     "¡Robaste una carta Barajar! El mazo se ha barajado.",
+		MsgShuffleCooling: "Robaste una carta Barajar, pero el mazo se barajó hace muy poco. No pasa nada.",
+		MsgExploded:       "¡Robaste un Gatito Explosivo! ¡Pierdes! Derrotas totales: %d",
+		MsgEliminated:     "¡Robaste un Gatito Explosivo y quedas fuera de la partida! Puedes seguir mirando hasta que termine.",
+		MsgSurvivalOver:   "¡Robaste un Gatito Explosivo tras sobrevivir %d robos! Mejor partida: %d",
+		MsgLossForced:     "Solo quedan Gatitos Explosivos y no tienes ninguna carta Desactivar: ¡tu partida termina tras sobrevivir %d robos! Mejor partida: %d",
+		MsgWinEmptyHand:   "¡Ganas con la mano vacía! Victorias totales: %d",
+		MsgWinHolding:     "¡Ganas con %s en la mano! Victorias totales: %d",
+		MsgCoopWin:        "¡%s y tú ganáis juntos! Victorias totales: %d",
+		MsgDeckCleared:    "En el mazo solo quedan Gatitos Explosivos.",
+		MsgDeckEmpty:      "No quedan cartas en el mazo.",
+		MsgDeckEmptyDraw:  "No quedan cartas en el mazo. La partida termina en empate.",
+		MsgForfeited:      "Abandonaste la partida. Derrotas totales: %d",
+	},
+}
+
+// Card names by locale, for every locale but English, whose names are the
+// card types
+var cardNames = map[string]map[string]string{
+	"es": {
+		"Cat":              "Gato",
+		"Defuse":           "Desactivar",
+		"Shuffle":          "Barajar",
+		"Exploding Kitten": "Gatito Explosivo",
+		"Favor":            "Favor",
+		"Skip":             "Saltar",
+		"Attack":           "Ataque",
+		"Nope":             "Nope",
+		"Draw From Bottom": "Robar de abajo",
+		"Tacocat":          "Tacogato",
+		"Rainbow Cat":      "Gato Arcoíris",
+		"Beard Cat":        "Gato Barbudo",
+		"See the Future":   "Ver el futuro",
+	},
+}
+
+type localeContextKey struct{}
+
+// Attach the locale messages should be written in
+func withLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeContextKey{}, locale)
+}
+
+// The locale attached to ctx, or the default
+func localeFrom(ctx context.Context) string {
+	if locale, ok := ctx.Value(localeContextKey{}).(string); ok {
+		return locale
+	}
+	return defaultLocale
+}
+
+// The locale a request asks for: ?lang= if it names one we have, otherwise
+// the best supported match in Accept-Language, otherwise English
+func requestLocale(r *http.Request) string {
+	if locale, ok := supportedLocale(r.URL.Query().Get("lang")); ok {
+		return locale
+	}
+	for _, tag := range parseAcceptLanguage(r.Header.Get("Accept-Language")) {
+		if locale, ok := supportedLocale(tag); ok {
+			return locale
+		}
+	}
+	return defaultLocale
+}
+
+// Match a language tag like "es-MX" to a catalog locale, by its primary
+// subtag if the whole tag isn't in the catalog
+func supportedLocale(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if _, ok := messageCatalog[tag]; ok {
+		return tag, true
+	}
+	primary, _, _ := strings.Cut(tag, "-")
+	if _, ok := messageCatalog[primary]; ok {
+		return primary, true
+	}
+	return "", false
+}
+
+// The language tags of an Accept-Language header, most preferred first.
+// Tags keep their order among equal weights; q=0 and malformed weights are
+// dropped, and "*" is left to the caller's default.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				continue
+			}
+			q = parsed
+		}
+		if q == 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag, q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	result := make([]string, len(tags))
+	for i, tag := range tags {
+		result[i] = tag.tag
+	}
+	return result
+}
+
+// Attach the request's locale to its context for the handlers
+func localeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(withLocale(c.Request.Context(), requestLocale(c.Request)))
+		c.Next()
+	}
+}
+
+// The message in the locale attached to ctx, falling back to English
+func localize(ctx context.Context, id string, args ...interface{}) string {
+	template, ok := messageCatalog[localeFrom(ctx)][id]
+	if !ok {
+		template = messageCatalog[defaultLocale][id]
+	}
+	return fmt.Sprintf(template, args...)
+}
+
+// The card's name in the locale attached to ctx
+func localCardName(ctx context.Context, cardType string) string {
+	if name, ok := cardNames[localeFrom(ctx)][cardType]; ok {
+		return name
+	}
+	return cardType
+}
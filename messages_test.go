@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Every locale has every English message, taking the same arguments, and a
+// name for every card in the registry, so a new card or message can't
+// quietly fall back to English
+func TestCatalogIsComplete(t *testing.T) {
+	for locale, messages := range messageCatalog {
+		for id, english := range messageCatalog[defaultLocale] {
+			message, ok := messages[id]
+			if !ok {
+				t.Errorf("%s has no message %s", locale, id)
+				continue
+			}
+			if got, want := strings.Count(message, "%"), strings.Count(english, "%"); got != want {
+				t.Errorf("%s message %s takes %d arguments, English takes %d", locale, id, got, want)
+			}
+		}
+		if locale == defaultLocale {
+			continue
+		}
+		for _, card := range cardRegistry {
+			if _, ok := cardNames[locale][card.Type]; !ok {
+				t.Errorf("%s has no name for card %s", locale, card.Type)
+			}
+		}
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"es", []string{"es"}},
+		{"en-US,en;q=0.9,es;q=0.8", []string{"en-US", "en", "es"}},
+		{"en;q=0.5, es-MX;q=0.9", []string{"es-MX", "en"}},
+		// Equal weights keep their order
+		{"fr, es, en", []string{"fr", "es", "en"}},
+		// q=0 means not wanted, and a bad weight drops its tag
+		{"es;q=0, en", []string{"en"}},
+		{"es;q=2, fr;q=abc, en;q=0.1", []string{"en"}},
+		{"*, es;q=0.5", []string{"es"}},
+	}
+	for _, test := range tests {
+		if got := parseAcceptLanguage(test.header); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseAcceptLanguage(%q) = %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestRequestLocale(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		header string
+		want   string
+	}{
+		{"nothing asked for", "/", "", "en"},
+		{"unknown locale", "/", "fr-FR, de;q=0.9", "en"},
+		{"region of a known locale", "/", "es-MX", "es"},
+		{"weighted list", "/", "fr;q=0.9, es;q=0.8, en;q=0.1", "es"},
+		{"lang overrides the header", "/?lang=en", "es", "en"},
+		{"unknown lang falls back to the header", "/?lang=xx", "es", "es"},
+		{"unknown lang and header", "/?lang=xx", "fr", "en"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.target, nil)
+			if test.header != "" {
+				r.Header.Set("Accept-Language", test.header)
+			}
+			if got := requestLocale(r); got != test.want {
+				t.Fatalf("locale = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDrawMessagesAreLocalized(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", "Cat", "Cat")
+
+		spanish := decodeOK[DrawCardResponse](t, ts.request(http.MethodPost, "/draw-card", User{Username: "alice"}, "Accept-Language", "es-ES,en;q=0.5"))
+		if spanish.MessageID != MsgCardHeld || spanish.Message != "¡Robaste una carta Gato! Se ha añadido a tu mano." {
+			t.Fatalf("Spanish draw = %q (%s)", spanish.Message, spanish.MessageID)
+		}
+		english := decodeOK[DrawCardResponse](t, ts.request(http.MethodPost, "/draw-card", User{Username: "alice"}, "Accept-Language", "tlh"))
+		if english.MessageID != MsgCardHeld || english.Message != "You drew a Cat card! It has been added to your hand." {
+			t.Fatalf("fallback draw = %q (%s)", english.Message, english.MessageID)
+		}
+	})
+}
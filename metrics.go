@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics exposed on /metrics
+var (
+	gamesStartedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "games_started_total",
+		Help: "Number of new games started.",
+	})
+
+	staleGamesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stale_games_total",
+		Help: "Solo games resumed with a deck dealt from a composition no longer dealt, by outcome (migrated or discarded).",
+	}, []string{"outcome"})
+
+	drawsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "draws_total",
+		Help: "Number of cards drawn, by card type.",
+	}, []string{"card"})
+
+	drawsReturnedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "draws_returned_total",
+		Help: "Number of drawn cards put back on the deck because their player never confirmed getting them.",
+	})
+
+	outboxRedeliveredTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "outbox_redelivered_total",
+		Help: "Number of game ends announced again from the outbox because the instance that ended them never announced them.",
+	})
+
+	gamesFinishedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "games_finished_total",
+		Help: "Number of finished games, by result.",
+	}, []string{"result"})
+
+	websocketConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "websocket_connections",
+		Help: "Number of open WebSocket connections.",
+	})
+
+	websocketEvictionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "websocket_evictions_total",
+		Help: "Number of WebSocket clients dropped for falling behind on their frames.",
+	})
+
+	sendQueueDepth = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "websocket_send_queue_depth",
+		Help:    "Frames waiting for a WebSocket client, measured as each one is queued.",
+		Buckets: []float64{1, 2, 4, 8, 16, 32, 64},
+	})
+
+	slowDrawsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "slow_draws_total",
+		Help: "Number of draws that took longer than DRAW_LATENCY_BUDGET.",
+	})
+
+	websocketSendWaitSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "websocket_send_wait_seconds",
+		Help:    "Time WebSocket frames waited in their connection's send queue, by message type.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 15),
+	}, []string{"type"})
+
+	websocketWriteSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "websocket_write_seconds",
+		Help:    "Time taken to write WebSocket frames to their sockets, by message type.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 15),
+	}, []string{"type"})
+
+	redisUp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "redis_up",
+		Help: "Whether the last Redis health check succeeded (1) or failed (0).",
+	})
+
+	redisCircuitState = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "redis_circuit_state",
+		Help: "State of the Redis circuit breaker: 0 closed, 1 half-open, 2 open.",
+	})
+
+	leaderboardCacheTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "leaderboard_cache_total",
+		Help: "Leaderboard cache lookups, by result (hit or miss).",
+	}, []string{"result"})
+
+	webhookDeliveriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_deliveries_total",
+		Help: "Webhook events, by result (delivered or dropped).",
+	}, []string{"result"})
+
+	handlerLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Latency of HTTP handlers.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// Gin middleware recording handler latency
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		handlerLatency.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// How often Redis is pinged for the redis_up gauge unless
+// REDIS_MONITOR_INTERVAL says otherwise
+const defaultRedisMonitorInterval = 15 * time.Second
+
+// Periodically ping Redis to keep the redis_up gauge current
+func monitorRedis(store GameStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := store.Ping(context.Background()); err != nil {
+			redisUp.Set(0)
+		} else {
+			redisUp.Set(1)
+		}
+		<-ticker.C
+	}
+}
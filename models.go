@@ -0,0 +1,341 @@
+package main
+
+import (
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+// Response bodies of the HTTP API. /openapi.json is generated from these, so
+// a field added here shows up in the spec.
+
+// Where a game stands after a draw
+const (
+	GameStatusActive = engine.StatusActive
+	GameStatusLost   = engine.StatusLost
+	GameStatusWon    = engine.StatusWon
+	// The hand is over the limit and a card must be discarded before anyone
+	// draws again
+	GameStatusMustDiscard = "must_discard"
+	// The player drew a bomb holding a Defuse and hasn't said whether to use it
+	GameStatusPendingDefuse = "pending_defuse"
+)
+
+// Solo game modes, chosen at /start-game
+const (
+	ModeClassic  = engine.ModeClassic
+	ModeSurvival = engine.ModeSurvival
+)
+
+// How a room makes up for the advantage of going first, chosen at
+// /create-room
+const (
+	BalanceNone     = engine.BalanceNone
+	BalanceBalanced = engine.BalanceBalanced
+)
+
+// Ways to play a room, chosen at /create-room
+const (
+	RoomVersus = engine.RoomVersus
+	RoomCoop   = engine.RoomCoop
+)
+
+// Body of every error response
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+// Start game route. The deck itself is never sent, only how many cards are left.
+type StartGameResponse struct {
+	Message  string        `json:"message"`
+	Username string        `json:"username"`
+	GameID   string        `json:"gameId"`
+	Snapshot *GameSnapshot `json:"snapshot"`
+	// Set when the game in progress was dealt from a deck the server no
+	// longer deals, so it was thrown away and this one started instead
+	StaleGameDiscarded bool `json:"staleGameDiscarded,omitempty"`
+}
+
+// Game snapshot route: what one player can see of a game
+type GameSnapshot struct {
+	GameID      string `json:"gameId"`
+	Username    string `json:"username"`
+	Status      string `json:"status"`
+	Mode        string `json:"mode"`
+	Remaining   int    `json:"remaining"`
+	Hand        []Card `json:"hand"`
+	DefuseCount int    `json:"defuseCount"`
+	// Whose turn it is, and when it times out, in a room
+	Turn         string     `json:"turn,omitempty"`
+	TurnDeadline *time.Time `json:"turnDeadline,omitempty"`
+	Version      int64      `json:"version"`
+	// Seq of the game's latest event, to pass as lastSeq when reconnecting
+	LastSeq int64 `json:"lastSeq"`
+	// Set while the game waits on a player, either to discard or to decide
+	// about a bomb: who, and why
+	MustDiscard  string `json:"mustDiscard,omitempty"`
+	BlockedCause string `json:"blockedCause,omitempty"`
+	// When the undecided bomb is defused for them, if that's what it waits on
+	BombDeadline *time.Time `json:"bombDeadline,omitempty"`
+	// Whether a solo game can only be lost from here; see
+	// engine.Game.ForcedOutcome
+	InevitableLoss bool `json:"inevitableLoss"`
+	// Moves left before a Shuffle can be played again; 0 when it can be
+	ShuffleCooldown int64 `json:"shuffleCooldown"`
+	// SHA-256 commitment to the seed the deck was shuffled with, revealed by
+	// GET /game/:gameId/fairness once the game is over
+	Commitment string `json:"commitment,omitempty"`
+}
+
+// Replay route
+type ReplayResponse struct {
+	GameID string `json:"gameId"`
+	Moves  []Move `json:"moves"`
+	// How the game went for the player asking, if it was summarized
+	Summary *GameSummary `json:"summary,omitempty"`
+}
+
+// Fairness route: the seed a finished game's deck was shuffled with, and
+// the opening deck it gives
+type FairnessResponse struct {
+	GameID     string `json:"gameId"`
+	Commitment string `json:"commitment"`
+	Seed       string `json:"seed" sensitive:"true"`
+	// Whether the seed matches the commitment published at the start
+	Verified bool `json:"verified"`
+	// The deck as dealt, top first, recomputed from the seed when verified
+	OpeningDeck []string `json:"openingDeck,omitempty" sensitive:"true"`
+}
+
+// Rematch route. Like /start-game, the new deck is never sent, only the
+// game's snapshot.
+type RematchResponse struct {
+	Message     string        `json:"message"`
+	Username    string        `json:"username"`
+	GameID      string        `json:"gameId"`
+	Snapshot    *GameSnapshot `json:"snapshot"`
+	GamesPlayed int64         `json:"gamesPlayed"`
+}
+
+// Draw card route, and Draw From Bottom
+type DrawCardResponse struct {
+	// Message in the request's language; MessageID names it in the catalog
+	Message   string `json:"message"`
+	MessageID string `json:"messageId"`
+	Card      Card   `json:"card"`
+	// Emoji of the card, only for ?legacy=true clients
+	CardText    string `json:"cardText,omitempty"`
+	Remaining   int    `json:"remaining"`
+	GameStatus  string `json:"gameStatus"`
+	DefuseCount int    `json:"defuseCount"`
+	// Version of the game after the draw; see GameStore.GameVersion
+	Version int64 `json:"version"`
+	// Where the card went: DispositionHeld, DispositionResolved,
+	// DispositionExploded, DispositionDefused or DispositionPendingDefuse
+	Disposition string `json:"disposition"`
+	// Set with DispositionPendingDefuse: when the Defuse is used for the
+	// player if they haven't decided by then
+	BombDeadline *time.Time `json:"bombDeadline,omitempty"`
+	// Whether the solo game can only be lost from here: every card left is
+	// a bomb
+	InevitableLoss bool `json:"inevitableLoss"`
+	// What else the draw changed, in the order it happened
+	Effects []DrawEffect `json:"effects"`
+	// Set when the draw lost the game
+	Losses int64  `json:"losses,omitempty"`
+	Winner string `json:"winner,omitempty"`
+	// The hand, when the draw took it over the limit
+	Hand []Card `json:"hand,omitempty"`
+	// Set when the draw ended a survival run: its score and the player's best
+	Score     int64 `json:"score,omitempty"`
+	BestScore int64 `json:"bestScore,omitempty"`
+	// Set when the draw ended the game: how it went for the player
+	Summary *GameSummary `json:"summary,omitempty"`
+}
+
+// One card of a /draw-cards batch
+type BatchDrawResult struct {
+	Card    Card   `json:"card"`
+	Outcome string `json:"outcome"`
+	// Where the card went; see DrawCardResponse.Disposition
+	Disposition string `json:"disposition"`
+	Message     string `json:"message,omitempty"`
+	MessageID   string `json:"messageId,omitempty"`
+}
+
+// Draw cards route
+type DrawCardsResponse struct {
+	Results    []BatchDrawResult `json:"results"`
+	Remaining  int               `json:"remaining"`
+	GameStatus string            `json:"gameStatus"`
+	Winner     string            `json:"winner,omitempty"`
+	Version    int64             `json:"version"`
+	// The hand, when the batch took it over the limit
+	Hand []Card `json:"hand,omitempty"`
+	// See DrawCardResponse.InevitableLoss
+	InevitableLoss bool `json:"inevitableLoss"`
+	// See DrawCardResponse.Summary
+	Summary *GameSummary `json:"summary,omitempty"`
+}
+
+// Discard route
+type DiscardResponse struct {
+	Message    string `json:"message"`
+	Hand       []Card `json:"hand"`
+	GameStatus string `json:"gameStatus"`
+}
+
+// Play card route, including Nope
+type PlayCardResponse struct {
+	Message string `json:"message"`
+	// End of the Nope window for a card held in a room
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// The card handed over by a Favor
+	Received *Card `json:"received,omitempty"`
+	// Set by a See the Future, whose cards go to the player's sockets
+	Peeked bool `json:"peeked,omitempty"`
+	// The peeked cards, only when the player had no socket to send them to
+	Peek *PeekResult `json:"peek,omitempty" sensitive:"true"`
+}
+
+// Odds route
+type OddsResponse struct {
+	GameID    string             `json:"gameId"`
+	Remaining int                `json:"remaining"`
+	Odds      map[string]float64 `json:"odds"`
+	Counts    map[string]int     `json:"counts"`
+	Version   int64              `json:"version"`
+}
+
+// Forfeit route
+type ForfeitResponse struct {
+	Message   string `json:"message"`
+	MessageID string `json:"messageId"`
+	Losses    int64  `json:"losses"`
+	Winner    string `json:"winner,omitempty"`
+}
+
+// Hand route
+type HandResponse struct {
+	Username string `json:"username"`
+	Hand     []Card `json:"hand"`
+}
+
+// Cards route
+type CardsResponse struct {
+	Cards []CardDefinition `json:"cards"`
+}
+
+// Create and join room routes
+type RoomResponse struct {
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+	GameID  string `json:"gameId"`
+	Room    *Room  `json:"room,omitempty"`
+}
+
+// Invite route. Token goes in /join-room's code field.
+type InviteResponse struct {
+	Code      string    `json:"code"`
+	Token     string    `json:"token" sensitive:"true"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Revoke invites routes
+type RevokeInvitesResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Leaderboard route
+type LeaderboardResponse struct {
+	Leaderboard []LeaderboardEntry `json:"leaderboard"`
+	// With ?mode=survival: best survival runs, in place of the win/lose
+	// leaderboard
+	Survival []SurvivalEntry `json:"survival,omitempty"`
+	// The offset of the next page, or 0 if this was the last
+	Next int `json:"next,omitempty"`
+}
+
+// Achievements route
+type AchievementsResponse struct {
+	Username     string        `json:"username"`
+	Achievements []Achievement `json:"achievements"`
+}
+
+// Online route
+type OnlineResponse struct {
+	Users []string `json:"users"`
+}
+
+// Matchmake routes
+type MatchmakeResponse struct {
+	Status string `json:"status"`
+	// 1-based place in the queue while queued
+	Position int64  `json:"position,omitempty"`
+	Code     string `json:"code,omitempty"`
+	GameID   string `json:"gameId,omitempty"`
+	Room     *Room  `json:"room,omitempty"`
+}
+
+// Guest route
+type GuestResponse struct {
+	Username string `json:"username"`
+	Token    string `json:"token" sensitive:"true"`
+	Guest    bool   `json:"guest"`
+}
+
+// Claim route
+type ClaimResponse struct {
+	Username string `json:"username"`
+	Previous string `json:"previous"`
+	Token    string `json:"token" sensitive:"true"`
+}
+
+// Delete account route: the keys that held the user's data
+type DeleteUserResponse struct {
+	Username string   `json:"username"`
+	Removed  []string `json:"removed"`
+}
+
+// Health route
+type HealthResponse struct {
+	Status  string `json:"status"`
+	Store   string `json:"store"`
+	Circuit string `json:"circuit"`
+}
+
+// Admin reset route
+type AdminResetResponse struct {
+	Message  string `json:"message"`
+	Username string `json:"username"`
+}
+
+// Admin stats route
+type AdminStatsResponse struct {
+	Username string `json:"username"`
+	Win      int64  `json:"win"`
+	Lose     int64  `json:"lose"`
+}
+
+// Admin audit route
+type AdminAuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+	// The ID to pass as since for the next page
+	Next string `json:"next,omitempty"`
+}
+
+// Admin flagged route
+type AdminFlaggedResponse struct {
+	Flagged []FlaggedUser `json:"flagged"`
+}
+
+// Admin storage route. Byte counts are estimates scaled up from the sampled
+// keys of each pattern.
+type AdminStorageResponse struct {
+	Patterns    []KeyUsage `json:"patterns"`
+	Keys        int64      `json:"keys"`
+	ApproxBytes int64      `json:"approxBytes"`
+	// Keys measured per pattern
+	Samples int `json:"samples"`
+}
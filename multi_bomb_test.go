@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// Say whether username uses a Defuse on the bomb they drew
+func (ts *testServer) resolveBomb(username string, useDefuse bool) DrawCardResponse {
+	ts.t.Helper()
+	return decodeOK[DrawCardResponse](ts.t, ts.post("/resolve-bomb", ResolveBombRequest{Username: username, UseDefuse: &useDefuse}))
+}
+
+func TestSecondBombWithOneDefuseLoses(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		// Wherever the defused bomb goes back, a bomb is on top after it
+		ts.startGame("alice", engine.Defuse, engine.ExplodingKitten, engine.ExplodingKitten, "Cat")
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+
+		if first := decodeOK[DrawCardResponse](t, ts.draw("alice")); first.GameStatus != GameStatusPendingDefuse {
+			t.Fatalf("first bomb = %+v", first)
+		}
+		defused := ts.resolveBomb("alice", true)
+		if defused.Disposition != DispositionDefused || defused.DefuseCount != 0 || defused.Remaining != 3 {
+			t.Fatalf("defusing = %+v", defused)
+		}
+		if counts := countCards(ts.deck("alice")); counts[engine.ExplodingKitten] != 2 || counts["Cat"] != 1 {
+			t.Fatalf("deck = %v, want both bombs and the Cat", ts.deck("alice"))
+		}
+
+		second := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if second.Card.Type != engine.ExplodingKitten || second.Disposition != DispositionExploded || second.GameStatus != GameStatusLost {
+			t.Fatalf("second bomb = %+v", second)
+		}
+		if win, lose := ts.stats("alice"); win != 0 || lose != 1 {
+			t.Fatalf("stats = %d/%d, want 0/1", win, lose)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Default time the other player has to Nope an action card
+const defaultNopeWindow = 3 * time.Second
+
+// An action card played in a room whose effect is on hold until the Nope
+// window lapses. Each Nope flips whether the action will happen and reopens
+// the window, so a Nope can itself be Noped.
+type pendingAction struct {
+	game      *GameSession
+	card      string
+	playable  *CardDefinition
+	lastActor string
+	nopes     int
+	deadline  time.Time
+	timer     Timer
+}
+
+// The action is cancelled if it has been Noped an odd number of times
+func (p *pendingAction) cancelled() bool {
+	return p.nopes%2 == 1
+}
+
+// Whether the room has an action waiting out its Nope window
+func (s *Server) hasPendingAction(code string) bool {
+	s.pendingMutex.Lock()
+	defer s.pendingMutex.Unlock()
+	return s.pending[code] != nil
+}
+
+// Drop the room's pending action without applying it, e.g. when the game ends
+func (s *Server) dropPendingAction(code string) {
+	s.pendingMutex.Lock()
+	defer s.pendingMutex.Unlock()
+	if action := s.pending[code]; action != nil {
+		action.timer.Stop()
+		delete(s.pending, code)
+		s.savePendingAction(code, nil)
+	}
+}
+
+// Put a played action card on hold and announce the Nope window to the room
+func (s *Server) holdAction(game *GameSession, card string, playable *CardDefinition) time.Time {
+	s.pendingMutex.Lock()
+	defer s.pendingMutex.Unlock()
+
+	action := &pendingAction{
+		game:      game,
+		card:      card,
+		playable:  playable,
+		lastActor: game.Username,
+		deadline:  s.clock.Now().Add(s.nopeWindow),
+	}
+	code := game.Room.Code
+	action.timer = s.clock.AfterFunc(s.nopeWindow, func() { s.resolveAction(code, action) })
+	s.pending[code] = action
+	s.savePendingAction(code, action)
+
+	deadline := action.deadline
+	s.hub.broadcastRoom(code, RoomEvent{
+		Type:      "action_pending",
+		Username:  game.Username,
+		Card:      findCard(game.Room.theme(), card),
+		ExpiresAt: &deadline,
+	})
+	return deadline
+}
+
+// Play a Nope against the room's pending action
+func (s *Server) playNope(ctx context.Context, game *GameSession) (*PlayCardResponse, *APIError) {
+	if game.Room == nil {
+		return nil, errCardNotPlayable("Nope can only be played in a room")
+	}
+
+	s.pendingMutex.Lock()
+	defer s.pendingMutex.Unlock()
+
+	action := s.pending[game.Room.Code]
+	if action == nil {
+		return nil, errNoPendingAction()
+	}
+	if action.lastActor == game.Username {
+		return nil, errCardNotPlayable("You can't Nope your own action")
+	}
+
+	removed, err := s.store.RemoveFromHand(ctx, game.Username, "Nope")
+	if err != nil {
+		log.Printf("Error removing Nope from hand for user %s: %v", game.Username, err)
+		return nil, errStoreUnavailable("Error updating hand")
+	}
+	if !removed {
+		return nil, errCardNotInHand("Nope")
+	}
+
+	// Reopen the window so the other player can answer with their own Nope
+	action.timer.Stop()
+	action.nopes++
+	action.lastActor = game.Username
+	action.deadline = s.clock.Now().Add(s.nopeWindow)
+	code := game.Room.Code
+	action.timer = s.clock.AfterFunc(s.nopeWindow, func() { s.resolveAction(code, action) })
+	s.savePendingAction(code, action)
+
+	log.Printf("User %s played Nope on %s in room %s (nopes: %d)", game.Username, action.card, code, action.nopes)
+	s.recordMove(ctx, game, MovePlay, "Nope", GameStatusActive)
+
+	deadline := action.deadline
+	s.hub.broadcastRoom(code, RoomEvent{
+		Type:      "action_noped",
+		Username:  game.Username,
+		Card:      findCard(game.Room.theme(), action.card),
+		ExpiresAt: &deadline,
+	})
+
+	return &PlayCardResponse{Message: "You played a Nope card!", ExpiresAt: &deadline}, nil
+}
+
+// Apply or drop the action once its Nope window has lapsed
+func (s *Server) resolveAction(code string, action *pendingAction) {
+	s.pendingMutex.Lock()
+	if s.pending[code] != action {
+		// Superseded by a Nope that reopened the window
+		s.pendingMutex.Unlock()
+		return
+	}
+	delete(s.pending, code)
+	s.pendingMutex.Unlock()
+	s.savePendingAction(code, nil)
+
+	if action.cancelled() {
+		log.Printf("%s played by %s in room %s was Noped", action.card, action.game.Username, code)
+		s.hub.broadcastRoom(code, RoomEvent{
+			Type:     "action_cancelled",
+			Username: action.game.Username,
+			Card:     findCard(action.game.Room.theme(), action.card),
+		})
+		return
+	}
+
+	ctx := context.Background()
+
+	// Reload the room so the effect sees its current state
+	room, err := s.store.GetRoom(ctx, code)
+	if err != nil || room == nil {
+		log.Printf("Error reloading room %s to apply %s: %v", code, action.card, err)
+		return
+	}
+	game := &GameSession{ID: room.gameID(), Username: action.game.Username, Room: room}
+
+	response, err := action.playable.Play(s, ctx, game)
+	if err != nil {
+		log.Printf("Error applying %s for user %s: %v", action.card, game.Username, err)
+		return
+	}
+
+	s.hub.broadcastRoom(code, RoomEvent{
+		Type:     "action_resolved",
+		Username: game.Username,
+		Card:     findCard(game.Room.theme(), action.card),
+		Message:  response.Message,
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Play a Skip in a fresh two-player room, returning the room as it was and
+// who holds the turn and who can Nope
+func playHeldSkip(t *testing.T, ts *testServer, playerHand, opponentHand []string) (*Room, string, string) {
+	t.Helper()
+	room := ts.openRoom("alice", "bob")
+	player, opponent := room.Turn, room.nextAlive(room.Turn)
+	ts.deal(player, append([]string{"Skip"}, playerHand...)...)
+	ts.deal(opponent, opponentHand...)
+
+	if w := ts.play(player, room.gameID(), "Skip"); w.Code != http.StatusAccepted {
+		t.Fatalf("playing Skip = %d: %s", w.Code, w.Body.String())
+	}
+	return room, player, opponent
+}
+
+func TestActionAppliesWhenNopeWindowLapses(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room, player, opponent := playHeldSkip(t, ts, nil, nil)
+
+		ts.clock.Advance(ts.nopeWindow - time.Millisecond)
+		if turn := ts.room(room.Code).Turn; turn != player {
+			t.Fatalf("turn = %q before the window lapsed, want %q", turn, player)
+		}
+		ts.clock.Advance(time.Millisecond)
+		if turn := ts.room(room.Code).Turn; turn != opponent {
+			t.Fatalf("turn = %q after the window, want %q", turn, opponent)
+		}
+		if ts.hasPendingAction(room.Code) {
+			t.Fatal("action still pending")
+		}
+	})
+}
+
+func TestNopeCancelsAction(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room, player, opponent := playHeldSkip(t, ts, nil, []string{"Nope"})
+
+		ts.clock.Advance(ts.nopeWindow / 2)
+		noped := decodeOK[PlayCardResponse](t, ts.play(opponent, room.gameID(), "Nope"))
+		// The Nope reopens the window in full
+		if want := ts.clock.Now().Add(ts.nopeWindow); noped.ExpiresAt == nil || !noped.ExpiresAt.Equal(want) {
+			t.Fatalf("Nope window ends %v, want %v", noped.ExpiresAt, want)
+		}
+
+		ts.clock.Advance(ts.nopeWindow)
+		if turn := ts.room(room.Code).Turn; turn != player {
+			t.Fatalf("turn = %q after a Noped Skip, want %q", turn, player)
+		}
+		if hand := ts.hand(opponent); len(hand) != 0 {
+			t.Fatalf("Nope still in hand: %v", hand)
+		}
+	})
+}
+
+func TestNopeOnNopeLetsActionThrough(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room, player, opponent := playHeldSkip(t, ts, []string{"Nope"}, []string{"Nope"})
+
+		decodeOK[PlayCardResponse](t, ts.play(opponent, room.gameID(), "Nope"))
+		// Nobody Nopes their own Nope
+		assertError(t, ts.play(opponent, room.gameID(), "Nope"), http.StatusBadRequest, ErrCodeCardNotPlayable)
+		decodeOK[PlayCardResponse](t, ts.play(player, room.gameID(), "Nope"))
+
+		ts.clock.Advance(ts.nopeWindow)
+		if turn := ts.room(room.Code).Turn; turn != opponent {
+			t.Fatalf("turn = %q after a Noped Nope, want %q", turn, opponent)
+		}
+	})
+}
+
+func TestNopeNeedsPendingAction(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		opponent := room.nextAlive(room.Turn)
+		ts.deal(opponent, "Nope")
+		assertError(t, ts.play(opponent, room.gameID(), "Nope"), http.StatusConflict, ErrCodeNoPendingAction)
+
+		ts.startGame("carol")
+		ts.deal("carol", "Nope")
+		assertError(t, ts.play("carol", "", "Nope"), http.StatusBadRequest, ErrCodeCardNotPlayable)
+	})
+}
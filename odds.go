@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Count the cards of each type in a deck
+func countCards(deck []string) map[string]int {
+	counts := make(map[string]int)
+	for _, card := range deck {
+		counts[card]++
+	}
+	return counts
+}
+
+// Odds route: the chance of drawing each card type next. Only the makeup of
+// the remaining deck is public, never its order.
+func (s *Server) getOdds(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user := User{Username: c.Query("username"), GameID: c.Query("gameId")}
+	if !usernamePattern.MatchString(user.Username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+	game, apiErr := s.resolveGame(ctx, user)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if game.Room != nil && game.Room.Status == RoomFinished {
+		abortWithError(c, errGameFinished())
+		return
+	}
+	if apiErr := s.checkGameActive(ctx, game); apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	deck, err := s.store.GetDeck(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error retrieving deck for game %s: %v", game.ID, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving deck"))
+		return
+	}
+
+	counts := countCards(deck)
+	odds := make(map[string]float64, len(counts))
+	for card, count := range counts {
+		odds[card] = float64(count) / float64(len(deck))
+	}
+
+	c.JSON(http.StatusOK, OddsResponse{GameID: game.ID, Remaining: len(deck), Odds: odds, Counts: counts, Version: s.gameVersion(ctx, game.ID)})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+func (ts *testServer) odds(query string) OddsResponse {
+	ts.t.Helper()
+	return decodeOK[OddsResponse](ts.t, ts.get("/odds?"+query))
+}
+
+func TestOddsOfFreshDeck(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", engine.Defuse, "Cat", engine.Shuffle, engine.ExplodingKitten)
+
+		odds := ts.odds("username=alice")
+		if odds.Remaining != 5 {
+			t.Fatalf("remaining = %d", odds.Remaining)
+		}
+		want := map[string]float64{"Cat": 0.4, engine.Defuse: 0.2, engine.Shuffle: 0.2, engine.ExplodingKitten: 0.2}
+		if !reflect.DeepEqual(odds.Odds, want) {
+			t.Fatalf("odds = %v, want %v", odds.Odds, want)
+		}
+		if want := map[string]int{"Cat": 2, engine.Defuse: 1, engine.Shuffle: 1, engine.ExplodingKitten: 1}; !reflect.DeepEqual(odds.Counts, want) {
+			t.Fatalf("counts = %v, want %v", odds.Counts, want)
+		}
+	})
+}
+
+func TestOddsOfPartlyDrawnDeck(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", "Cat", "Skip", engine.ExplodingKitten)
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+
+		odds := ts.odds("username=alice")
+		want := map[string]float64{"Skip": 0.5, engine.ExplodingKitten: 0.5}
+		if odds.Remaining != 2 || !reflect.DeepEqual(odds.Odds, want) {
+			t.Fatalf("odds = %+v, want %v of 2 cards", odds, want)
+		}
+		if odds.Version != drawn.Version {
+			t.Fatalf("version = %d, want the last draw's %d", odds.Version, drawn.Version)
+		}
+	})
+}
+
+func TestOddsOfEmptyDeck(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat")
+		// Take the last card straight from the store, which leaves the game
+		// running on an empty deck
+		ctx := context.Background()
+		version, _ := ts.store.GameVersion(ctx, "alice")
+		if _, err := ts.store.DrawCard(ctx, "alice", "alice", version, false, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		odds := ts.odds("username=alice")
+		if odds.Remaining != 0 || len(odds.Odds) != 0 || len(odds.Counts) != 0 {
+			t.Fatalf("odds = %+v", odds)
+		}
+	})
+}
+
+func TestOddsOfFinishedGame(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", engine.ExplodingKitten, "Cat")
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+
+		assertError(t, ts.get("/odds?username=alice"), http.StatusConflict, ErrCodeGameFinished)
+	})
+}
+
+func TestOddsOfRoomNeedsPlayer(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		ts.setDeck(room.gameID(), "Cat", engine.ExplodingKitten)
+
+		if odds := ts.odds("username=bob&gameId=" + room.gameID()); odds.Remaining != 2 {
+			t.Fatalf("odds = %+v", odds)
+		}
+		assertError(t, ts.get("/odds?username=carol&gameId="+room.gameID()), http.StatusForbidden, ErrCodeNotInRoom)
+	})
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A minimal OpenAPI 3 document: enough for clients to generate models from
+type OpenAPISpec struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       OpenAPIInfo                     `json:"info"`
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components OpenAPIComponents               `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIComponents struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+type Operation struct {
+	Summary     string              `json:"summary,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// What the spec says about one route. Request and Response are zero values
+// of the body types; nil means there is no JSON body.
+type routeDoc struct {
+	Summary  string
+	Query    []string
+	Request  interface{}
+	Response interface{}
+	// Status of a successful response, when it isn't 200
+	Status int
+	// Keep the response body in the access log. Routes are left out unless
+	// they opt in, as admin, debug and key routes answer with what a log
+	// must never hold.
+	LogBody bool
+}
+
+// Documentation of the routes, keyed by "METHOD /path" as gin reports them.
+// A route missing here still appears in the spec, just without schemas.
+var routeDocs = map[string]routeDoc{
+	"POST /start-game":                   {Summary: "Start or resume a solo game", Query: []string{"theme"}, Request: User{}, Response: StartGameResponse{}, LogBody: true},
+	"POST /draw-card":                    {Summary: "Draw the top card", Query: []string{"legacy", "lang", "theme"}, Request: User{}, Response: DrawCardResponse{}, LogBody: true},
+	"POST /ack-draw":                     {Summary: "Confirm the card last drawn in a solo game arrived, so it isn't put back on the deck", Request: User{}, Response: AckDrawResponse{}},
+	"POST /draw-cards":                   {Summary: "Draw several cards at once", Request: DrawCardsRequest{}, Response: DrawCardsResponse{}, LogBody: true},
+	"GET /hand":                          {Summary: "Cards the player is holding", Query: []string{"username", "theme"}, Response: HandResponse{}},
+	"GET /odds":                          {Summary: "Chance of drawing each card type next", Query: []string{"username", "gameId"}, Response: OddsResponse{}},
+	"GET /game/:gameId/snapshot":         {Summary: "What a player can see of a game, for redrawing after a reload", Query: []string{"username", "theme"}, Response: GameSnapshot{}},
+	"GET /game/:gameId/replay":           {Summary: "Every move of a finished game, in order", Query: []string{"username"}, Response: ReplayResponse{}},
+	"GET /game/:gameId/fairness":         {Summary: "The seed a finished game's deck was shuffled with, checked against its commitment", Query: []string{"username"}, Response: FairnessResponse{}},
+	"GET /cards":                         {Summary: "The card registry, in the art of a theme", Query: []string{"theme"}, Response: CardsResponse{}},
+	"POST /create-room":                  {Summary: "Create a room for 2 to 5 players", Request: CreateRoomRequest{}, Response: RoomResponse{}},
+	"POST /join-room":                    {Summary: "Join a room by code or invite token", Request: RoomRequest{}, Response: RoomResponse{}},
+	"POST /rooms/:code/invite":           {Summary: "Create an expiring invite token for the room", Request: InviteRequest{}, Response: InviteResponse{}},
+	"POST /rooms/:code/pause":            {Summary: "Pause the room's game, stopping its turn clock; moves get a 423 until it resumes", Request: PauseRequest{}, Response: PauseResponse{}},
+	"POST /rooms/:code/resume":           {Summary: "Resume the room's paused game", Request: PauseRequest{}, Response: PauseResponse{}},
+	"DELETE /rooms/:code/invite":         {Summary: "Revoke every invite to the room issued so far (owner only)", Request: InviteRequest{}, Response: RevokeInvitesResponse{}},
+	"POST /matchmake":                    {Summary: "Wait for an opponent, or join one who is waiting", Response: MatchmakeResponse{}},
+	"DELETE /matchmake":                  {Summary: "Stop waiting for an opponent", Response: MatchmakeResponse{}},
+	"POST /tournaments":                  {Summary: "Create a single-elimination tournament from a list of players, or open it for registration", Request: CreateTournamentRequest{}, Response: TournamentResponse{}},
+	"GET /tournaments/:id":               {Summary: "The tournament's live bracket", Response: Tournament{}},
+	"POST /tournaments/:id/join":         {Summary: "Register the session's user for the tournament, which starts once it is full", Response: TournamentResponse{}},
+	"POST /play-card":                    {Summary: "Play a card from the hand", Request: PlayCardRequest{}, Response: PlayCardResponse{}, LogBody: true},
+	"POST /play-pair":                    {Summary: "Play two matching cats to steal a card", Request: PlayPairRequest{}, Response: PlayCardResponse{}, LogBody: true},
+	"POST /discard":                      {Summary: "Discard a card from a hand over the size limit", Request: DiscardRequest{}, Response: DiscardResponse{}, LogBody: true},
+	"POST /resolve-bomb":                 {Summary: "Use a Defuse on a drawn bomb, or accept the explosion", Request: ResolveBombRequest{}, Response: DrawCardResponse{}, LogBody: true},
+	"POST /forfeit":                      {Summary: "Give up the game", Request: User{}, Response: ForfeitResponse{}, LogBody: true},
+	"POST /takeover":                     {Summary: "Move the game to the device in X-Device-Id, when GAME_LOCKS is on", Request: TakeoverRequest{}, Response: TakeoverResponse{}},
+	"POST /rematch":                      {Summary: "Start a new game after a finished one", Request: User{}, Response: RematchResponse{}},
+	"POST /guest":                        {Summary: "Create a guest player", Response: GuestResponse{}},
+	"POST /claim":                        {Summary: "Give a guest a permanent username", Request: ClaimRequest{}, Response: ClaimResponse{}},
+	"DELETE /users/me":                   {Summary: "Delete the session's account and all its data", Request: DeleteUserRequest{}, Response: DeleteUserResponse{}},
+	"PUT /profile":                       {Summary: "Set the session's display name and avatar", Request: ProfileRequest{}, Response: ProfileResponse{}},
+	"GET /leaderboard":                   {Summary: "Ranked player stats, or best survival runs", Query: []string{"mode", "window", "sort", "order", "minGames", "includeGuests", "includeFlagged"}, Response: LeaderboardResponse{}},
+	"GET /export/leaderboard":            {Summary: "The leaderboard as a CSV or JSON download", Query: []string{"format", "bom", "window", "sort", "order", "minGames", "includeGuests"}},
+	"GET /export/history/:username":      {Summary: "The moves of a player's finished solo game as a CSV or JSON download", Query: []string{"format", "bom"}},
+	"GET /achievements/:username":        {Summary: "Achievements a player has earned", Response: AchievementsResponse{}},
+	"GET /h2h/:userA/:userB":             {Summary: "Two players' record against each other in the room games they played alone together", Response: HeadToHeadRecord{}},
+	"GET /analytics/summary":             {Summary: "Games played, draws per game, explosion and defuse rates over a UTC day or up to a week of them (admin token or API key)", Query: []string{"date", "days"}, Response: AnalyticsSummaryResponse{}},
+	"GET /online":                        {Summary: "Players seen in the last minute", Response: OnlineResponse{}},
+	"GET /ws":                            {Summary: "WebSocket upgrade for live updates; send a hello first to agree on capabilities", Query: []string{"spectate", "room", "tournament", "lastSeq", "username", "lang", "deviceId"}, Status: http.StatusSwitchingProtocols},
+	"GET /admin/users/:username":         {Summary: "Dump a user's state", Response: AdminUserDump{}},
+	"DELETE /admin/users/:username/game": {Summary: "Reset a user's solo game", Response: AdminResetResponse{}},
+	"POST /admin/users/:username/stats":  {Summary: "Set a user's win/lose counts", Request: AdminStatsRequest{}, Response: AdminStatsResponse{}},
+	"PUT /admin/users/:username/limits":  {Summary: "Give a user their own caps on games in progress and games started per day", Request: AdminLimitsRequest{}, Response: AdminLimitsResponse{}},
+	"GET /admin/audit":                   {Summary: "Page through the audit log of admin actions and stat changes", Query: []string{"since", "limit"}, Response: AdminAuditResponse{}},
+	"GET /admin/connections":             {Summary: "This instance's WebSocket connections, the slowest to write to first", Query: []string{"offset", "limit"}, Response: AdminConnectionsResponse{}},
+	"GET /admin/flagged":                 {Summary: "Players flagged as suspected cheats, with why", Response: AdminFlaggedResponse{}},
+	"DELETE /admin/users/:username/flag": {Summary: "Clear a player's cheat flag", Response: AdminResetResponse{}},
+	"DELETE /admin/rooms/:code/invites":  {Summary: "Revoke every invite to a room issued so far", Response: RevokeInvitesResponse{}},
+	"POST /admin/apikeys":                {Summary: "Create an API key for reading public data, shown only in this response", Request: CreateAPIKeyRequest{}, Response: CreateAPIKeyResponse{}, Status: http.StatusCreated},
+	"GET /admin/apikeys":                 {Summary: "Every API key, without the keys themselves", Response: APIKeysResponse{}},
+	"DELETE /admin/apikeys/:id":          {Summary: "Revoke an API key", Response: RevokeAPIKeyResponse{}},
+	"POST /admin/inject-card":            {Summary: "Put a card into the decks of every active room or of one room", Request: InjectCardRequest{}, Response: InjectCardResponse{}},
+	"GET /admin/consistency":             {Summary: "How the last check of the stored data for corruptions went", Response: AdminConsistencyResponse{}},
+	"GET /admin/config":                  {Summary: "The configuration this instance loaded, by environment variable, with secrets redacted", Response: AdminConfigResponse{}},
+	"POST /admin/consistency":            {Summary: "Check the stored data for corruptions in the background, repairing what is safe to", Response: AdminConsistencyResponse{}, Status: http.StatusAccepted},
+	"GET /admin/storage":                 {Summary: "Approximate key counts and memory per key pattern", Query: []string{"sample"}, Response: AdminStorageResponse{}},
+	"GET /debug/deck/:username":          {Summary: "A player's deck in draw order (development only)", Response: DebugDeckResponse{}},
+	"POST /debug/seed":                   {Summary: "Generate users for load testing (development only)", Request: SeedRequest{}, Response: SeedResponse{}},
+	"DELETE /debug/seed":                 {Summary: "Delete every generated user (development only)", Response: SeedWipeResponse{}},
+	"GET /healthz":                       {Summary: "Whether the store answers, and its circuit breaker state", Response: HealthResponse{}},
+	"GET /metrics":                       {Summary: "Prometheus metrics"},
+	"GET /openapi.json":                  {Summary: "This document"},
+}
+
+// Build the spec for the routes registered on the router
+func buildOpenAPISpec(routes gin.RoutesInfo) *OpenAPISpec {
+	spec := &OpenAPISpec{
+		OpenAPI:    "3.0.3",
+		Info:       OpenAPIInfo{Title: "Exploding Kitten API", Version: "1.0.0"},
+		Paths:      make(map[string]map[string]Operation),
+		Components: OpenAPIComponents{Schemas: make(map[string]*Schema)},
+	}
+	schemas := spec.Components.Schemas
+	errorSchema := schemaFor(reflect.TypeOf(ErrorResponse{}), schemas)
+
+	for _, route := range routes {
+		doc := routeDocs[route.Method+" "+route.Path]
+		op := Operation{Summary: doc.Summary, Responses: make(map[string]Response)}
+
+		path, params := openAPIPath(route.Path)
+		for _, name := range params {
+			op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+		for _, name := range doc.Query {
+			op.Parameters = append(op.Parameters, Parameter{Name: name, In: "query", Schema: &Schema{Type: "string"}})
+		}
+
+		if doc.Request != nil {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  jsonContent(schemaFor(reflect.TypeOf(doc.Request), schemas)),
+			}
+		}
+
+		status := doc.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := Response{Description: http.StatusText(status)}
+		if doc.Response != nil {
+			success.Content = jsonContent(schemaFor(reflect.TypeOf(doc.Response), schemas))
+		}
+		op.Responses[strconv.Itoa(status)] = success
+		op.Responses["default"] = Response{Description: "Error", Content: jsonContent(errorSchema)}
+
+		if spec.Paths[path] == nil {
+			spec.Paths[path] = make(map[string]Operation)
+		}
+		spec.Paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	return spec
+}
+
+// Turn gin's /users/:username into /users/{username} and list the params
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			params = append(params, part[1:])
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/"), params
+}
+
+func jsonContent(schema *Schema) map[string]MediaType {
+	return map[string]MediaType{gin.MIMEJSON: {Schema: schema}}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// The schema of a Go type, following its json tags. Named structs go into
+// components once and are referred to by $ref.
+func schemaFor(t reflect.Type, components map[string]*Schema) *Schema {
+	if t.Kind() == reflect.Ptr {
+		schema := schemaFor(t.Elem(), components)
+		if schema.Ref != "" {
+			return schema
+		}
+		schema.Nullable = true
+		return schema
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		if t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64 {
+			return &Schema{Type: "integer", Format: "int64"}
+		}
+		return &Schema{Type: "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return &Schema{Type: "number"}
+	case t.Kind() == reflect.String:
+		return &Schema{Type: "string"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return &Schema{Type: "array", Items: schemaFor(t.Elem(), components)}
+	case t.Kind() == reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaFor(t.Elem(), components)}
+	case t.Kind() == reflect.Struct:
+		return structSchema(t, components)
+	}
+	// interface{} and anything else: any value
+	return &Schema{}
+}
+
+func structSchema(t reflect.Type, components map[string]*Schema) *Schema {
+	ref := &Schema{Ref: "#/components/schemas/" + t.Name()}
+	if t.Name() != "" {
+		if _, ok := components[t.Name()]; ok {
+			return ref
+		}
+		// Reserve the name first so recursive types terminate
+		components[t.Name()] = &Schema{}
+	}
+
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		// An embedded struct's fields are encoded as the outer struct's own
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			embedded := structSchema(field.Type, components)
+			if field.Type.Name() != "" {
+				embedded = components[field.Type.Name()]
+			}
+			for property, fieldSchema := range embedded.Properties {
+				schema.Properties[property] = fieldSchema
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = schemaFor(field.Type, components)
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+
+	if t.Name() == "" {
+		return schema
+	}
+	components[t.Name()] = schema
+	return ref
+}
+
+// OpenAPI route: the spec of every route on the router
+func serveOpenAPI(spec *OpenAPISpec) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, spec)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// Decode the body strictly into a new value of the route's documented
+// response type
+func decodeDocumented(t *testing.T, route string, body []byte) {
+	t.Helper()
+	doc, ok := routeDocs[route]
+	if !ok || doc.Response == nil {
+		t.Fatalf("%s has no documented response", route)
+	}
+	v := reflect.New(reflect.TypeOf(doc.Response)).Interface()
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		t.Fatalf("%s: decoding %T from %s: %v", route, v, body, err)
+	}
+}
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	ts := newTestServerWith(t, newMemoryStore(), testConfig(t, map[string]string{"APP_ENV": "development"}))
+	registered := make(map[string]bool)
+	for _, route := range ts.routes.Routes() {
+		key := route.Method + " " + route.Path
+		registered[key] = true
+		if _, ok := routeDocs[key]; !ok {
+			t.Errorf("%s is missing from routeDocs", key)
+		}
+	}
+	for key := range routeDocs {
+		if !registered[key] {
+			t.Errorf("routeDocs has %s, which isn't registered", key)
+		}
+	}
+}
+
+func TestResponsesMatchDocumentedTypes(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{"ADMIN_TOKEN": testAdminToken, "APP_ENV": "development"}))
+		ts.startGame("alice", "Cat", "Skip", engine.ExplodingKitten, "Cat")
+
+		requests := []struct {
+			route   string
+			path    string
+			body    interface{}
+			headers []string
+		}{
+			{route: "POST /draw-card", path: "/draw-card", body: User{Username: "alice"}},
+			{route: "POST /draw-cards", path: "/draw-cards", body: DrawCardsRequest{Username: "alice", Count: 1}},
+			{route: "GET /hand", path: "/hand?username=alice"},
+			{route: "GET /odds", path: "/odds?username=alice"},
+			{route: "GET /game/:gameId/snapshot", path: "/game/alice/snapshot?username=alice"},
+			{route: "GET /cards", path: "/cards"},
+			{route: "POST /create-room", path: "/create-room", body: CreateRoomRequest{Username: "bob", Size: 2}},
+			{route: "POST /guest", path: "/guest"},
+			{route: "GET /leaderboard", path: "/leaderboard"},
+			{route: "GET /achievements/:username", path: "/achievements/alice"},
+			{route: "GET /online", path: "/online"},
+			{route: "GET /healthz", path: "/healthz"},
+			{route: "GET /debug/deck/:username", path: "/debug/deck/alice"},
+			{route: "GET /admin/users/:username", path: "/admin/users/alice", headers: asAdmin},
+			{route: "POST /admin/users/:username/stats", path: "/admin/users/alice/stats", body: `{"win": 2, "lose": 1}`, headers: asAdmin},
+			{route: "GET /admin/audit", path: "/admin/audit", headers: asAdmin},
+			{route: "GET /admin/connections", path: "/admin/connections", headers: asAdmin},
+			{route: "GET /admin/flagged", path: "/admin/flagged", headers: asAdmin},
+			{route: "POST /admin/apikeys", path: "/admin/apikeys", body: CreateAPIKeyRequest{Name: "stats site", Scopes: []string{ScopeLeaderboardRead}}, headers: asAdmin},
+			{route: "GET /admin/apikeys", path: "/admin/apikeys", headers: asAdmin},
+			{route: "GET /admin/config", path: "/admin/config", headers: asAdmin},
+			{route: "GET /admin/storage", path: "/admin/storage", headers: asAdmin},
+			{route: "POST /forfeit", path: "/forfeit", body: User{Username: "alice"}},
+			{route: "DELETE /admin/users/:username/game", path: "/admin/users/alice/game", headers: asAdmin},
+		}
+		for _, req := range requests {
+			method, _, _ := strings.Cut(req.route, " ")
+			w := ts.request(method, req.path, req.body, req.headers...)
+			status := routeDocs[req.route].Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if w.Code != status {
+				t.Fatalf("%s: status %d, want %d: %s", req.route, w.Code, status, w.Body.String())
+			}
+			decodeDocumented(t, req.route, w.Body.Bytes())
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Origins allowed when ALLOWED_ORIGINS isn't set: the frontend's dev server
+var defaultAllowedOrigins = []string{"http://localhost:3000"}
+
+// The default origins when the server terminates TLS itself, for a frontend
+// dev server running on https too
+var defaultTLSAllowedOrigins = []string{"http://localhost:3000", "https://localhost:3000"}
+
+// Parse ALLOWED_ORIGINS: a comma-separated list of origins such as
+// "https://catburst.example.com,http://localhost:3000". "*" allows any
+// origin and is meant for development.
+func parseAllowedOrigins(value string) []string {
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, normalizeOrigin(origin))
+		}
+	}
+	return origins
+}
+
+// Whether browsers on origin may call the API and open sockets. The CORS
+// middleware asks for every request carrying an Origin header and refuses
+// the rest with a 403, WebSocket upgrades included.
+func (s *Server) originAllowed(origin string) bool {
+	normalized := normalizeOrigin(origin)
+	for _, allowed := range s.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, normalized) {
+			return true
+		}
+	}
+	log.Printf("Warning: rejected request from origin %q", origin)
+	return false
+}
+
+// Drop the scheme's default port, so "https://catburst.example.com:443"
+// matches "https://catburst.example.com"
+func normalizeOrigin(origin string) string {
+	switch {
+	case strings.HasPrefix(strings.ToLower(origin), "https://"):
+		return strings.TrimSuffix(origin, ":443")
+	case strings.HasPrefix(strings.ToLower(origin), "http://"):
+		return strings.TrimSuffix(origin, ":80")
+	}
+	return origin
+}
+
+// CheckOrigin of the WebSocket upgrader. Requests without an Origin header
+// don't come from a browser and are let through, as gorilla does by default.
+func (s *Server) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || s.originAllowed(origin)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSocketOriginCheck(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{"ALLOWED_ORIGINS": "https://catburst.example.com, http://localhost:3000/"}))
+
+		tests := []struct {
+			origin string
+			status int
+		}{
+			{"https://catburst.example.com", http.StatusSwitchingProtocols},
+			{"https://CatBurst.example.com:443", http.StatusSwitchingProtocols},
+			{"http://localhost:3000", http.StatusSwitchingProtocols},
+			// Not a browser, so nothing to check
+			{"", http.StatusSwitchingProtocols},
+			{"https://evil.example.com", http.StatusForbidden},
+			{"http://catburst.example.com", http.StatusForbidden},
+			{"https://catburst.example.com.evil.example", http.StatusForbidden},
+		}
+		for _, test := range tests {
+			var headers []string
+			if test.origin != "" {
+				headers = []string{"Origin", test.origin}
+			}
+			_, status, err := ts.tryDial("spectate=alice", headers...)
+			if status != test.status {
+				t.Errorf("origin %q: handshake status %d (%v), want %d", test.origin, status, err, test.status)
+			}
+		}
+	})
+}
+
+func TestWildcardOriginAllowsAnySocket(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{"ALLOWED_ORIGINS": "*"}))
+		if _, status, err := ts.tryDial("spectate=alice", "Origin", "https://anywhere.example"); err != nil {
+			t.Fatalf("handshake status %d: %v", status, err)
+		}
+	})
+}
+
+func TestCORSUsesAllowedOrigins(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{"ALLOWED_ORIGINS": "https://catburst.example.com"}))
+
+		w := ts.get("/cards", "Origin", "https://catburst.example.com")
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://catburst.example.com" {
+			t.Fatalf("allowed origin: %d with Access-Control-Allow-Origin %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+		if w := ts.get("/cards", "Origin", "https://evil.example.com"); w.Code != http.StatusForbidden {
+			t.Fatalf("disallowed origin: %d", w.Code)
+		}
+	})
+}
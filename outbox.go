@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// How long a game's end may sit in the outbox unannounced before another
+// instance takes it for lost and announces it again, unless
+// OUTBOX_CLAIM_AFTER says otherwise. Well past the reveal delay, which holds
+// the announcement back.
+const defaultOutboxClaimAfter = 30 * time.Second
+
+// How often each instance looks for lost announcements, and the entries it
+// takes from the outbox at a time, unless OUTBOX_INTERVAL and OUTBOX_BATCH
+// say otherwise
+const (
+	defaultOutboxInterval = 5 * time.Second
+	defaultOutboxBatch    = 100
+)
+
+// The hub channel an announcement's ack is queued on, after its events
+const outboxChannel = "outbox"
+
+// An entry of the outbox, which holds the end of every game until it has
+// been announced
+type OutboxEntry struct {
+	ID      string
+	Payload []byte
+}
+
+// What an outbox entry holds: enough to announce the game's end again
+type outboxAnnouncement struct {
+	GameID   string      `json:"gameId"`
+	Username string      `json:"username"`
+	RoomCode string      `json:"roomCode,omitempty"`
+	Outcome  gameOutcome `json:"outcome"`
+}
+
+// Names this instance among those taking entries from the outbox
+func outboxConsumer() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "instance"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+func (s *Server) ackOutbox(id string) {
+	if err := s.store.AckOutbox(context.Background(), id); err != nil {
+		// The game's end is announced again after s.outboxClaimAfter, and
+		// clients drop the repeat by its event ID
+		log.Printf("Error acknowledging outbox entry %s: %v", id, err)
+	}
+}
+
+// Announce again the end of every game whose instance ended it but never
+// got to announce it, e.g. because it died in between. The stats and
+// summaries are read back from the store; the events carry the entry's ID as
+// the first announcement's did, so a client that did get that one can tell.
+func (s *Server) redeliverOutbox(ctx context.Context, consumer string) {
+	entries, err := s.store.ClaimOutbox(ctx, consumer, s.outboxClaimAfter, s.outboxBatch)
+	if err != nil {
+		log.Printf("Error claiming outbox entries: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		var announcement outboxAnnouncement
+		if err := json.Unmarshal(entry.Payload, &announcement); err != nil {
+			log.Printf("Warning: dropping unreadable outbox entry %s: %v", entry.ID, err)
+			s.ackOutbox(entry.ID)
+			continue
+		}
+		game := &GameSession{ID: announcement.GameID, Username: announcement.Username, outboxID: entry.ID}
+		if announcement.RoomCode != "" {
+			// The room's players decide whether the game counts head to
+			// head; the code alone still announces it
+			room, err := s.store.GetRoom(ctx, announcement.RoomCode)
+			if err != nil {
+				log.Printf("Error retrieving room %s to announce the end of its game: %v", announcement.RoomCode, err)
+			}
+			if room == nil {
+				room = &Room{Code: announcement.RoomCode}
+			}
+			game.Room = room
+		}
+		outcome := announcement.Outcome
+		game.summaries = s.savedSummaries(ctx, game.ID, append(outcome.winners(), outcome.losers()...))
+		// The bomb was revealed long ago
+		outcome.Reveal = false
+
+		log.Printf("Announcing the end of game %s again from outbox entry %s", game.ID, entry.ID)
+		outboxRedeliveredTotal.Inc()
+		s.announceGameOver(ctx, game, outcome)
+	}
+}
+
+// Finish crediting the games whose instance ended them but failed part way
+// through the rest, as only a cluster can leave it. The instance completing
+// a game has s.outboxClaimAfter to finish it first.
+func (s *Server) finishCompletions(ctx context.Context) {
+	finished, err := s.store.FinishCompletions(ctx, s.clock.Now().Add(-s.outboxClaimAfter))
+	if err != nil {
+		log.Printf("Error finishing game completions: %v", err)
+	}
+	if finished > 0 {
+		log.Printf("Finished crediting %d ended games", finished)
+		s.leaderboard.invalidate()
+	}
+}
+
+// Run finishCompletions, then redeliverOutbox for the announcements it
+// added, every interval until ctx is done
+func (s *Server) runOutbox(ctx context.Context, interval time.Duration) {
+	consumer := outboxConsumer()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.storeTripped() {
+				s.finishCompletions(ctx)
+				s.redeliverOutbox(ctx, consumer)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"exploding-kitten/engine"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// A store whose instance dies as it acknowledges an outbox entry, so the
+// ack never reaches the store
+type lostAcks struct {
+	GameStore
+	acks atomic.Int64
+}
+
+func (s *lostAcks) AckOutbox(ctx context.Context, id string) error {
+	s.acks.Add(1)
+	return nil
+}
+
+// A client that drops a game_over it has seen before, by its event ID
+type dedupingClient struct {
+	t      *testing.T
+	frames int
+	seen   map[string]RoomEvent
+}
+
+func (c *dedupingClient) receive(message map[string]interface{}) {
+	c.t.Helper()
+	event := decodeMessage[RoomEvent](c.t, message)
+	if event.EventID == "" {
+		c.t.Fatalf("game_over without an event ID: %+v", event)
+	}
+	c.frames++
+	if _, ok := c.seen[event.EventID]; !ok {
+		c.seen[event.EventID] = event
+	}
+}
+
+// Take every game_over the socket gets until it has been quiet for wait
+func (c *dedupingClient) drain(socket *testSocket, wait time.Duration) {
+	c.t.Helper()
+	for {
+		socket.conn.SetReadDeadline(time.Now().Add(wait))
+		var message map[string]interface{}
+		if err := socket.conn.ReadJSON(&message); err != nil {
+			var timeout net.Error
+			if errors.As(err, &timeout) && timeout.Timeout() {
+				return
+			}
+			c.t.Fatalf("reading the socket: %v", err)
+		}
+		if message["type"] == "game_over" {
+			c.receive(message)
+		}
+	}
+}
+
+func TestOutboxRedeliversExactlyOnceAfterADeadDispatcher(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		store := ts.store
+		dying := &lostAcks{GameStore: store}
+		first := newTestServer(t, dying)
+		client := &dedupingClient{t: t, seen: map[string]RoomEvent{}}
+
+		room := first.openRoom("alice", "bob")
+		first.setDeck(room.gameID(), engine.ExplodingKitten, "Cat")
+		first.deal("alice")
+		first.deal("bob")
+		socket := first.dial("room=" + room.Code)
+		socket.next("snapshot")
+
+		// The game ends and is announced, but the instance dies before its
+		// ack reaches the store
+		decodeOK[DrawCardResponse](t, first.post("/draw-card", User{Username: room.Turn, GameID: room.gameID()}))
+		first.clock.Advance(first.revealDelay)
+		client.receive(socket.next("game_over"))
+		eventually(t, "the ack", func() bool { return dying.acks.Load() == 1 })
+		socket.conn.Close()
+
+		// Another instance's dispatcher leaves it until it has been idle
+		// long enough to be taken for lost
+		second := newTestServer(t, store)
+		socket = second.dial("room=" + room.Code)
+		socket.next("snapshot")
+		redelivered := testutil.ToFloat64(outboxRedeliveredTotal)
+		second.redeliverOutbox(ctx, "second")
+		if n := testutil.ToFloat64(outboxRedeliveredTotal) - redelivered; n != 0 {
+			t.Fatalf("%v game ends announced again before they were idle", n)
+		}
+
+		second.outboxClaimAfter = time.Millisecond
+		time.Sleep(2 * second.outboxClaimAfter)
+		second.redeliverOutbox(ctx, "second")
+		client.receive(socket.next("game_over"))
+
+		// Acked this time, so no dispatcher announces it again
+		time.Sleep(2 * second.outboxClaimAfter)
+		second.redeliverOutbox(ctx, "second")
+		second.redeliverOutbox(ctx, "third")
+		client.drain(socket, 100*time.Millisecond)
+		if n := testutil.ToFloat64(outboxRedeliveredTotal) - redelivered; n != 1 {
+			t.Fatalf("announced again %v times, want once", n)
+		}
+		if left, err := store.ClaimOutbox(ctx, "check", 0, defaultOutboxBatch); err != nil || len(left) != 0 {
+			t.Fatalf("outbox still holds %v, %v", left, err)
+		}
+
+		if client.frames != 2 || len(client.seen) != 1 {
+			t.Fatalf("client got %d game_over frames, %d of them new", client.frames, len(client.seen))
+		}
+		for _, event := range client.seen {
+			if event.Winner == "" || event.Loser != room.Turn {
+				t.Fatalf("game_over = %+v", event)
+			}
+		}
+	})
+}
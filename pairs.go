@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cat cards do nothing alone, but two of the same one steal a card
+var catCards = map[string]bool{
+	"Cat":         true,
+	"Tacocat":     true,
+	"Rainbow Cat": true,
+	"Beard Cat":   true,
+}
+
+type PlayPairRequest struct {
+	Username string `json:"username"`
+	GameID   string `json:"gameId"`
+	CardType string `json:"cardType"`
+}
+
+// Play pair route: spend two matching cats to steal a random card from the
+// next player still in the game
+func (s *Server) playPair(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req PlayPairRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+
+	response, apiErr := s.stealWithPair(ctx, req)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Steal for the requesting player. The pair is only spent if the player
+// stolen from has a card to give, and the room sees that a card was stolen but not which.
+func (s *Server) stealWithPair(ctx context.Context, req PlayPairRequest) (*PlayCardResponse, *APIError) {
+	if !catCards[req.CardType] {
+		return nil, errCardNotPlayable(fmt.Sprintf("%q can't be played as a pair", req.CardType))
+	}
+	game, apiErr := s.resolveMove(ctx, User{Username: req.Username, GameID: req.GameID})
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if game.Room == nil {
+		return nil, errCardNotPlayable("Pairs can only be played in a room")
+	}
+	if apiErr := checkCardEnabled(game, req.CardType); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := s.checkTurn(game.Room, game.Username); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := s.checkNotBlocked(ctx, game); apiErr != nil {
+		return nil, apiErr
+	}
+
+	// A pair is a move: it takes the turn from under a pending timeout
+	if apiErr := s.claimTurn(ctx, game.Room); apiErr != nil {
+		return nil, apiErr
+	}
+	s.startTurnTimer(game.Room)
+
+	opponent := game.Room.nextAlive(game.Username)
+	stolen, err := s.store.StealWithPair(ctx, game.Username, opponent, req.CardType)
+	switch {
+	case err == errPairMissing:
+		return nil, errPairNotHeld(req.CardType)
+	case err == errHandIsEmpty:
+		return nil, errNothingToSteal(opponent)
+	case err != nil:
+		log.Printf("Error stealing from %s for user %s: %v", opponent, game.Username, err)
+		return nil, errStoreUnavailable("Error updating hands")
+	}
+
+	log.Printf("User %s played a pair of %s and stole %s from %s", game.Username, req.CardType, stolen, opponent)
+	s.recordMove(ctx, game, MovePlayPair, req.CardType, GameStatusActive)
+	s.hub.broadcastRoom(game.Room.Code, RoomEvent{
+		Type:     "card_stolen",
+		Username: game.Username,
+		Card:     findCard(cardTheme(ctx, game), req.CardType),
+		Message:  fmt.Sprintf("%s stole a card from %s", game.Username, opponent),
+	})
+
+	received := cardFor(cardTheme(ctx, game), stolen)
+	return &PlayCardResponse{
+		Message:  fmt.Sprintf("You played two %s cards and stole a %s card from %s!", req.CardType, stolen, opponent),
+		Received: &received,
+	}, nil
+}
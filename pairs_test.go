@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func (ts *testServer) playPair(username, gameID, cardType string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	return ts.post("/play-pair", PlayPairRequest{Username: username, GameID: gameID, CardType: cardType})
+}
+
+func TestPairStealsCard(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		player, opponent := room.Turn, room.nextAlive(room.Turn)
+		socket := ts.dial("room=" + room.Code)
+		ts.deal(player, "Tacocat", "Skip", "Tacocat")
+		ts.deal(opponent, "Favor")
+
+		played := decodeOK[PlayCardResponse](t, ts.playPair(player, room.gameID(), "Tacocat"))
+		if played.Received == nil || played.Received.Type != "Favor" {
+			t.Fatalf("play = %+v, want the Favor", played)
+		}
+		hand := ts.hand(player)
+		sort.Strings(hand)
+		if !reflect.DeepEqual(hand, []string{"Favor", "Skip"}) {
+			t.Fatalf("%s's hand = %v, want the pair spent and the Favor added", player, hand)
+		}
+		if hand := ts.hand(opponent); len(hand) != 0 {
+			t.Fatalf("%s's hand = %v", opponent, hand)
+		}
+
+		// The room hears of the steal, but not what was taken
+		event := socket.next("card_stolen")
+		stolen := decodeMessage[RoomEvent](t, event)
+		if stolen.Username != player || stolen.Card == nil || stolen.Card.Type != "Tacocat" {
+			t.Fatalf("card_stolen = %+v", stolen)
+		}
+		if encoded, _ := json.Marshal(event); strings.Contains(string(encoded), "Favor") {
+			t.Fatalf("card_stolen gives away the card: %s", encoded)
+		}
+
+		// A pair doesn't end the turn
+		if turn := ts.room(room.Code).Turn; turn != player {
+			t.Fatalf("turn = %q after the pair", turn)
+		}
+	})
+}
+
+func TestPairFromEmptyHandIsRejected(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		player, opponent := room.Turn, room.nextAlive(room.Turn)
+		ts.deal(player, "Beard Cat", "Beard Cat")
+		ts.deal(opponent)
+
+		assertError(t, ts.playPair(player, room.gameID(), "Beard Cat"), http.StatusConflict, ErrCodeNothingToSteal)
+		// The pair isn't spent on nothing
+		if hand := ts.hand(player); !reflect.DeepEqual(hand, []string{"Beard Cat", "Beard Cat"}) {
+			t.Fatalf("%s's hand = %v", player, hand)
+		}
+	})
+}
+
+func TestPairOutOfTurnIsRejected(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		player, opponent := room.Turn, room.nextAlive(room.Turn)
+		ts.deal(player, "Skip")
+		ts.deal(opponent, "Rainbow Cat", "Rainbow Cat")
+
+		assertError(t, ts.playPair(opponent, room.gameID(), "Rainbow Cat"), http.StatusForbidden, ErrCodeNotYourTurn)
+		if hand := ts.hand(player); !reflect.DeepEqual(hand, []string{"Skip"}) {
+			t.Fatalf("%s's hand = %v", player, hand)
+		}
+		if hand := ts.hand(opponent); len(hand) != 2 {
+			t.Fatalf("%s's hand = %v", opponent, hand)
+		}
+	})
+}
+
+func TestPairNeedsTwoMatchingCats(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		player, opponent := room.Turn, room.nextAlive(room.Turn)
+		ts.deal(player, "Tacocat", "Rainbow Cat", "Skip", "Skip")
+		ts.deal(opponent, "Favor")
+
+		assertError(t, ts.playPair(player, room.gameID(), "Tacocat"), http.StatusConflict, ErrCodeCardNotInHand)
+		assertError(t, ts.playPair(player, room.gameID(), "Skip"), http.StatusBadRequest, ErrCodeCardNotPlayable)
+		if hand := ts.hand(opponent); len(hand) != 1 {
+			t.Fatalf("%s's hand = %v", opponent, hand)
+		}
+	})
+}
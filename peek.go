@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Cards a See the Future shows from the top of the deck
+const peekCards = 3
+
+// How long a client should keep showing peeked cards
+const peekDisplay = 10 * time.Second
+
+// The top cards of the deck, as a See the Future shows them. Bomb positions
+// must stay out of logs, so this is sent to the player's sockets, never in
+// a room event, and only carried by an HTTP response as a fallback, where
+// the access log redacts it.
+type PeekResult struct {
+	GameID string `json:"gameId"`
+	// Top card first
+	Cards     []Card    `json:"cards"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Sent to the player's sockets on a See the Future. Nobody else gets it and
+// it isn't kept in any event stream, so a reconnecting socket doesn't get it
+// back.
+type PeekMessage struct {
+	Type string `json:"type"`
+	PeekResult
+}
+
+// See the Future: show the player the top cards of the deck, over their
+// sockets if they have any open here, otherwise in the response
+func (s *Server) playSeeTheFuture(ctx context.Context, game *GameSession) (*PlayCardResponse, error) {
+	deck, err := s.store.GetDeck(ctx, game.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(deck) > peekCards {
+		deck = deck[:peekCards]
+	}
+	peek := PeekResult{GameID: game.ID, Cards: cardsFor(cardTheme(ctx, game), deck), ExpiresAt: s.clock.Now().Add(peekDisplay)}
+
+	// Never the cards themselves: they would give away where the bombs are
+	logGameEvent(game.Username, game.ID, map[string]any{"event": "peek", "cards": len(peek.Cards)})
+
+	if s.hub.hasPlayerConns(game.Username) {
+		s.hub.notifyPlayer(game.Username, PeekMessage{Type: "peek", PeekResult: peek})
+		return &PlayCardResponse{
+			Message: fmt.Sprintf("You played a See the Future card! The top %d cards were sent to your live connection.", len(peek.Cards)),
+			Peeked:  true,
+		}, nil
+	}
+
+	// A held card resolves after its response went out, so without a socket
+	// the cards can't reach the player at all
+	if game.Room != nil {
+		log.Printf("User %s has no socket to see the future of game %s on", game.Username, game.ID)
+	}
+	return &PlayCardResponse{
+		Message: fmt.Sprintf("You played a See the Future card! Here are the top %d cards.", len(peek.Cards)),
+		Peeked:  true,
+		Peek:    &peek,
+	}, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long a card drawn into a solo hand waits for its player to confirm
+// they got it before it goes back on the deck, and how often
+// runDrawReaper looks for such cards, unless PENDING_DRAW_TIMEOUT and
+// PENDING_DRAW_INTERVAL say otherwise
+const (
+	defaultPendingDrawTimeout  = 60 * time.Second
+	defaultPendingDrawInterval = 10 * time.Second
+)
+
+// A card drawn by /draw-card in a solo game that the player hasn't yet
+// confirmed receiving. If the response never reached them, the card would be
+// lost from the game; instead the draw is held until a retry of the request,
+// /ack-draw or the player's next move confirms it, and undone once it has
+// waited s.pendingDrawTimeout.
+type PendingDraw struct {
+	// Filled in by the store
+	GameID   string
+	Username string
+	Card     string
+	// The idempotency key of the request that drew the card, dropped if the
+	// draw is undone so a retry draws again
+	Key string
+	At  time.Time
+}
+
+type AckDrawResponse struct {
+	Message string `json:"message"`
+}
+
+// The hold for the draw in progress, nil unless it is a /draw-card in a solo
+// game
+func (s *Server) drawHold(game *GameSession) *PendingDraw {
+	if !game.holdDraw || game.Room != nil {
+		return nil
+	}
+	return &PendingDraw{Key: game.drawKey, At: s.clock.Now()}
+}
+
+// Whether a held draw needs confirming: only a card that simply went into
+// the hand of a game still going on can be put back. Bombs, Shuffles and
+// draws that ended the game stand at once.
+func heldDrawPending(response *DrawCardResponse) bool {
+	return response.Disposition == DispositionHeld && response.GameStatus == GameStatusActive
+}
+
+// Confirm the player got the card of their last draw in a solo game
+func (s *Server) confirmDraw(ctx context.Context, game *GameSession) *APIError {
+	if game.Room != nil {
+		return nil
+	}
+	if err := s.store.ConfirmDraw(ctx, game.ID); err != nil {
+		log.Printf("Error confirming last draw of game %s: %v", game.ID, err)
+		return errStoreUnavailable("Error confirming draw")
+	}
+	return nil
+}
+
+// Ack draw route: confirm the card the last /draw-card drew arrived, so it
+// isn't put back on the deck. Any move confirms it too.
+func (s *Server) ackDraw(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user, apiErr := bindUser(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	game, apiErr := s.resolveGame(ctx, user)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if apiErr := s.confirmDraw(ctx, game); apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	c.JSON(http.StatusOK, AckDrawResponse{Message: "Draw confirmed"})
+}
+
+// Put back every card drawn more than s.pendingDrawTimeout ago that its player
+// hasn't confirmed
+func (s *Server) returnPendingDraws(ctx context.Context) {
+	returned, err := s.store.ReturnPendingDraws(ctx, s.clock.Now().Add(-s.pendingDrawTimeout))
+	if err != nil {
+		log.Printf("Error returning unconfirmed draws: %v", err)
+	}
+	for _, draw := range returned {
+		log.Printf("Returned unconfirmed %s drawn by user %s to the deck of game %s", draw.Card, draw.Username, draw.GameID)
+		drawsReturnedTotal.Inc()
+	}
+}
+
+// Run returnPendingDraws every interval until ctx is done
+func (s *Server) runDrawReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.storeTripped() {
+				s.returnPendingDraws(ctx)
+			}
+		}
+	}
+}
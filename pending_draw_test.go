@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+func TestUnackedDrawReturnsToDeckHead(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		ts.startGame("alice", "Tacocat", "Cat", engine.ExplodingKitten)
+		ts.deal("alice")
+		draw := func() DrawCardResponse {
+			return decodeOK[DrawCardResponse](t, ts.request(http.MethodPost, "/draw-card", User{Username: "alice"}, "Idempotency-Key", "draw-1"))
+		}
+
+		// The response never arrives
+		if drawn := draw(); drawn.Card.Type != "Tacocat" {
+			t.Fatalf("drew %+v", drawn.Card)
+		}
+		ts.clock.Advance(ts.pendingDrawTimeout)
+		ts.returnPendingDraws(ctx)
+		if deck := ts.deck("alice"); len(deck) != 2 {
+			t.Fatalf("deck = %v: the draw went back before it timed out", deck)
+		}
+
+		ts.clock.Advance(time.Millisecond)
+		ts.returnPendingDraws(ctx)
+		if deck := ts.deck("alice"); !reflect.DeepEqual(deck, []string{"Tacocat", "Cat", engine.ExplodingKitten}) {
+			t.Fatalf("deck after the reaper = %v, want the card back on top", deck)
+		}
+		if hand := ts.hand("alice"); len(hand) != 0 {
+			t.Fatalf("hand after the reaper = %v", hand)
+		}
+		if hash, _ := ts.store.GetGameHash(ctx, "alice"); hash["cardsDrawn"] != "0" {
+			t.Fatalf("cardsDrawn = %q after the draw was undone", hash["cardsDrawn"])
+		}
+
+		// The retry isn't answered from the first draw's response, but draws
+		// the same card again
+		if drawn := draw(); drawn.Card.Type != "Tacocat" || drawn.Remaining != 2 {
+			t.Fatalf("retried draw = %+v", drawn)
+		}
+		if hand := ts.hand("alice"); !reflect.DeepEqual(hand, []string{"Tacocat"}) {
+			t.Fatalf("hand after the retry = %v", hand)
+		}
+	})
+}
+
+func TestConfirmedDrawStays(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		ts.startGame("alice", "Tacocat", "Cat", "Cat", engine.ExplodingKitten)
+		ts.deal("alice")
+
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		decodeOK[AckDrawResponse](t, ts.post("/ack-draw", User{Username: "alice"}))
+		ts.clock.Advance(ts.pendingDrawTimeout + time.Millisecond)
+		ts.returnPendingDraws(ctx)
+		if deck := ts.deck("alice"); !reflect.DeepEqual(deck, []string{"Cat", "Cat", engine.ExplodingKitten}) {
+			t.Fatalf("deck after an acknowledged draw = %v", deck)
+		}
+
+		// Only the newest draw, never acknowledged, goes back
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		ts.clock.Advance(ts.pendingDrawTimeout + time.Millisecond)
+		ts.returnPendingDraws(ctx)
+		if deck := ts.deck("alice"); !reflect.DeepEqual(deck, []string{"Cat", "Cat", engine.ExplodingKitten}) {
+			t.Fatalf("deck after the reaper = %v", deck)
+		}
+		if hand := ts.hand("alice"); !reflect.DeepEqual(hand, []string{"Tacocat"}) {
+			t.Fatalf("hand after the reaper = %v", hand)
+		}
+	})
+}
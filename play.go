@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PlayCardRequest struct {
+	Username string `json:"username" binding:"required"`
+	GameID   string `json:"gameId"`
+	Card     string `json:"card" binding:"required"`
+}
+
+// Play card route: spend a card from the hand for its effect
+func (s *Server) playCard(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req PlayCardRequest
+	if apiErr := bindStrict(c, &req); apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+
+	status, response, apiErr := s.play(ctx, req)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if drawn, ok := response.(*DrawCardResponse); ok {
+		addLegacyCardText(c, drawn)
+	}
+
+	c.JSON(status, response)
+}
+
+// Play a card for the requesting player. The response is a *DrawCardResponse
+// for Draw From Bottom and a *PlayCardResponse otherwise.
+func (s *Server) play(ctx context.Context, req PlayCardRequest) (int, interface{}, *APIError) {
+	game, apiErr := s.resolveMove(ctx, User{Username: req.Username, GameID: req.GameID})
+	if apiErr != nil {
+		return 0, nil, apiErr
+	}
+	if apiErr := s.checkNotBlocked(ctx, game); apiErr != nil {
+		return 0, nil, apiErr
+	}
+	if apiErr := checkCardEnabled(game, req.Card); apiErr != nil {
+		return 0, nil, apiErr
+	}
+
+	// Nope answers another player's action rather than taking a turn
+	if req.Card == "Nope" {
+		response, apiErr := s.playNope(ctx, game)
+		if apiErr != nil {
+			return 0, nil, apiErr
+		}
+		return http.StatusOK, response, nil
+	}
+
+	// Draw From Bottom is the player's draw for the turn, so it resolves
+	// immediately with the drawn card as the response
+	if req.Card == "Draw From Bottom" {
+		response, apiErr := s.playDrawFromBottom(ctx, game)
+		if apiErr != nil {
+			return 0, nil, apiErr
+		}
+		return http.StatusOK, response, nil
+	}
+
+	status, response, apiErr := s.playFromHand(ctx, game, req.Card)
+	if apiErr != nil {
+		return 0, nil, apiErr
+	}
+	return status, response, nil
+}
+
+// Spend a playable card from the player's hand. In a room the effect is held
+// for the Nope window and the status is 202; solo it applies immediately.
+func (s *Server) playFromHand(ctx context.Context, game *GameSession, card string) (int, *PlayCardResponse, *APIError) {
+	playable, ok := lookupCard(card)
+	if !ok || playable.Play == nil {
+		return 0, nil, errCardNotPlayable(fmt.Sprintf("%q can't be played from the hand", card))
+	}
+
+	if game.Room != nil {
+		if apiErr := s.checkTurn(game.Room, game.Username); apiErr != nil {
+			return 0, nil, apiErr
+		}
+	} else if playable.NeedsOpponent {
+		return 0, nil, errCardNotPlayable(fmt.Sprintf("%s can only be played in a room", card))
+	}
+
+	removed, err := s.store.RemoveFromHand(ctx, game.Username, card)
+	if err != nil {
+		log.Printf("Error removing %s from hand for user %s: %v", card, game.Username, err)
+		return 0, nil, errStoreUnavailable("Error updating hand")
+	}
+	if !removed {
+		return 0, nil, errCardNotInHand(card)
+	}
+
+	// A Shuffle too soon after the last one is refused and goes back
+	if card == "Shuffle" {
+		claimed, apiErr := s.claimShuffle(ctx, game)
+		if apiErr == nil && !claimed {
+			apiErr = errShuffleCooldown(s.shuffleCooldown)
+		}
+		if apiErr != nil {
+			if err := s.store.HoldCard(ctx, game.Username, card); err != nil {
+				log.Printf("Error returning %s to the hand of user %s: %v", card, game.Username, err)
+			}
+			return 0, nil, apiErr
+		}
+	}
+
+	// Playing a card is a move: it takes the turn from under a pending
+	// timeout and restarts the clock
+	if game.Room != nil {
+		if apiErr := s.claimPlayedTurn(ctx, game, card); apiErr != nil {
+			return 0, nil, apiErr
+		}
+		s.startTurnTimer(game.Room)
+	}
+
+	log.Printf("User %s played a %s card", game.Username, card)
+	s.recordMove(ctx, game, MovePlay, card, GameStatusActive)
+
+	// In a room the opponent gets a chance to Nope before the effect applies
+	if game.Room != nil {
+		deadline := s.holdAction(game, card, playable)
+		return http.StatusAccepted, &PlayCardResponse{
+			Message:   fmt.Sprintf("You played a %s card! It takes effect unless your opponent plays a Nope.", card),
+			ExpiresAt: &deadline,
+		}, nil
+	}
+
+	response, err := playable.Play(s, ctx, game)
+	if err != nil {
+		log.Printf("Error applying %s for user %s: %v", card, game.Username, err)
+		return 0, nil, errStoreUnavailable("Error applying card effect")
+	}
+
+	return http.StatusOK, response, nil
+}
+
+// Shuffle: reshuffle the remaining deck
+func (s *Server) playShuffle(ctx context.Context, game *GameSession) (*PlayCardResponse, error) {
+	if err := s.shuffleDeck(ctx, game.ID); err != nil {
+		return nil, err
+	}
+	return &PlayCardResponse{Message: "You played a Shuffle card! The deck is reshuffled."}, nil
+}
+
+// Skip: end the turn without drawing. A player an Attack left owing draws
+// only skips one of them.
+func (s *Server) playSkip(ctx context.Context, game *GameSession) (*PlayCardResponse, error) {
+	left, err := s.finishDraws(ctx, game, 1)
+	if err != nil {
+		return nil, err
+	}
+	if left > 0 {
+		return &PlayCardResponse{Message: fmt.Sprintf("You played a Skip card! You still have to draw %d.", left)}, nil
+	}
+	return &PlayCardResponse{Message: "You played a Skip card! Your turn ends without drawing."}, nil
+}
+
+// Attack: end the turn without drawing and make the next player still in
+// the game draw two cards, on top of any the attacker still owed
+func (s *Server) playAttack(ctx context.Context, game *GameSession) (*PlayCardResponse, error) {
+	owed, err := s.store.PendingDraws(ctx, game.ID, game.Username)
+	if err != nil {
+		return nil, err
+	}
+	target := game.Room.nextAlive(game.Username)
+	draws := owed + 2
+	if err := s.store.SetPendingDraws(ctx, game.ID, target, draws); err != nil {
+		return nil, err
+	}
+	if err := s.store.SetPendingDraws(ctx, game.ID, game.Username, 0); err != nil {
+		return nil, err
+	}
+	if err := s.endTurn(ctx, game.Room, game.Username); err != nil {
+		return nil, err
+	}
+	return &PlayCardResponse{Message: fmt.Sprintf("You played an Attack card! %s must draw %d cards.", target, draws)}, nil
+}
+
+// Favor: the next player still in the game gives a random card from their
+// hand
+func (s *Server) playFavor(ctx context.Context, game *GameSession) (*PlayCardResponse, error) {
+	opponent := game.Room.nextAlive(game.Username)
+	given, err := s.store.TakeRandomCard(ctx, opponent, game.Username)
+	if err != nil {
+		return nil, err
+	}
+	if given == "" {
+		return &PlayCardResponse{Message: fmt.Sprintf("You played a Favor card, but %s has no cards to give!", opponent)}, nil
+	}
+
+	log.Printf("User %s received %s from %s", game.Username, given, opponent)
+	received := cardFor(cardTheme(ctx, game), given)
+	return &PlayCardResponse{
+		Message:  fmt.Sprintf("You played a Favor card! %s gave you a %s card.", opponent, given),
+		Received: &received,
+	}, nil
+}
+
+// Draw From Bottom: draw the bottom card of the deck instead of the top one
+func (s *Server) playDrawFromBottom(ctx context.Context, game *GameSession) (*DrawCardResponse, *APIError) {
+	if apiErr := s.checkGameActive(ctx, game); apiErr != nil {
+		return nil, apiErr
+	}
+	if game.Room != nil {
+		if apiErr := s.checkTurn(game.Room, game.Username); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+
+	removed, err := s.store.RemoveFromHand(ctx, game.Username, "Draw From Bottom")
+	if err != nil {
+		log.Printf("Error removing Draw From Bottom from hand for user %s: %v", game.Username, err)
+		return nil, errStoreUnavailable("Error updating hand")
+	}
+	if !removed {
+		return nil, errCardNotInHand("Draw From Bottom")
+	}
+	if game.Room != nil {
+		if apiErr := s.claimPlayedTurn(ctx, game, "Draw From Bottom"); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+
+	log.Printf("User %s played a Draw From Bottom card", game.Username)
+
+	if game.Room != nil {
+		s.hub.broadcastRoom(game.Room.Code, RoomEvent{
+			Type:     "card_played",
+			Username: game.Username,
+			Card:     findCard(cardTheme(ctx, game), "Draw From Bottom"),
+		})
+	}
+
+	return s.performDraw(ctx, game, true)
+}
+
+// Claim the turn for a card just taken from the hand, handing the card back
+// if the turn was lost to a timeout in the meantime
+func (s *Server) claimPlayedTurn(ctx context.Context, game *GameSession, card string) *APIError {
+	apiErr := s.claimTurn(ctx, game.Room)
+	if apiErr == nil {
+		return nil
+	}
+	if err := s.store.HoldCard(ctx, game.Username, card); err != nil {
+		log.Printf("Error returning %s to the hand of user %s: %v", card, game.Username, err)
+	}
+	return apiErr
+}
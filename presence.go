@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// A player counts as online for this long after their last request or pong,
+// unless PRESENCE_TTL says otherwise
+const defaultPresenceTTL = 60 * time.Second
+
+// How often sweepPresence looks for players who went quiet, unless
+// PRESENCE_SWEEP_INTERVAL says otherwise
+const defaultPresenceSweepInterval = 15 * time.Second
+
+// Sent to leaderboard sockets when a player comes online or goes offline
+type PresenceMessage struct {
+	Type     string `json:"type"`
+	Username string `json:"username"`
+}
+
+// Mark the player as seen now, announcing them if they weren't online.
+// Failures are only logged: presence is best effort.
+func (s *Server) touchPresence(ctx context.Context, username string) {
+	if isBot(username) {
+		return
+	}
+	arrived, err := s.store.TouchPresence(ctx, username, s.clock.Now())
+	if err != nil {
+		log.Printf("Error updating presence of user %s: %v", username, err)
+		return
+	}
+	if arrived {
+		log.Printf("User %s is online", username)
+		s.hub.broadcast(PresenceMessage{Type: "user_online", Username: username})
+	}
+}
+
+// Keep the player online for as long as the socket answers pings
+func (s *Server) trackSocketPresence(conn *websocket.Conn, username string) {
+	s.touchPresence(context.Background(), username)
+	conn.SetPongHandler(func(string) error {
+		s.touchPresence(context.Background(), username)
+		return nil
+	})
+}
+
+// Drop players not seen within the presence TTL and announce them as offline. The
+// store hands each expired player to only one caller, so every instance can
+// sweep and the event still fires once.
+func (s *Server) expirePresence(ctx context.Context) {
+	gone, err := s.store.ExpirePresence(ctx, s.clock.Now().Add(-s.presenceTTL))
+	if err != nil {
+		log.Printf("Error expiring presence: %v", err)
+		return
+	}
+	for _, username := range gone {
+		log.Printf("User %s went offline", username)
+		s.hub.broadcast(PresenceMessage{Type: "user_offline", Username: username})
+	}
+}
+
+// Run expirePresence every interval until ctx is done
+func (s *Server) sweepPresence(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.storeTripped() {
+				s.expirePresence(ctx)
+			}
+		}
+	}
+}
+
+// Online route: the players seen within the presence TTL, most recent first
+func (s *Server) getOnline(c *gin.Context) {
+	users, err := s.store.OnlineUsers(c.Request.Context(), s.clock.Now().Add(-s.presenceTTL))
+	if err != nil {
+		log.Printf("Error fetching online users: %v", err)
+		abortWithError(c, errStoreUnavailable("Error fetching online users"))
+		return
+	}
+	if users == nil {
+		users = []string{}
+	}
+	c.JSON(http.StatusOK, OnlineResponse{Users: users})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func (ts *testServer) online() []string {
+	ts.t.Helper()
+	return decodeOK[OnlineResponse](ts.t, ts.get("/online")).Users
+}
+
+// The next presence message on the socket
+func (s *testSocket) presence() PresenceMessage {
+	s.t.Helper()
+	for {
+		message := s.any()
+		if message["type"] == "user_online" || message["type"] == "user_offline" {
+			return decodeMessage[PresenceMessage](s.t, message)
+		}
+	}
+}
+
+func TestPresenceExpiresAfterTTL(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		socket := ts.dial("")
+		socket.hello()
+
+		// An authenticated request is a heartbeat
+		guest := ts.guest()
+		decodeOK[ProfileResponse](t, ts.request(http.MethodPut, "/profile", ProfileRequest{DisplayName: "Alice"}, bearer(guest.Token)...))
+		if got := socket.presence(); got != (PresenceMessage{Type: "user_online", Username: guest.Username}) {
+			t.Fatalf("presence = %+v", got)
+		}
+		if users := ts.online(); !reflect.DeepEqual(users, []string{guest.Username}) {
+			t.Fatalf("online = %v", users)
+		}
+
+		ts.clock.Advance(ts.presenceTTL - time.Millisecond)
+		ts.expirePresence(ctx)
+		if users := ts.online(); len(users) != 1 {
+			t.Fatalf("online = %v before the TTL ran out", users)
+		}
+
+		// Two instances sweep the same store; only one announces the player
+		ts.clock.Advance(2 * time.Millisecond)
+		other := newTestServer(t, ts.store)
+		other.hub = ts.hub
+		other.clock.Advance(ts.clock.Now().Sub(other.clock.Now()))
+		ts.expirePresence(ctx)
+		other.expirePresence(ctx)
+		ts.expirePresence(ctx)
+		if users := ts.online(); len(users) != 0 {
+			t.Fatalf("online = %v after the TTL", users)
+		}
+		if got := socket.presence(); got != (PresenceMessage{Type: "user_offline", Username: guest.Username}) {
+			t.Fatalf("presence = %+v", got)
+		}
+
+		// The next presence message is about someone else, so the offline
+		// event didn't repeat
+		ts.touchPresence(ctx, "bob")
+		if got := socket.presence(); got != (PresenceMessage{Type: "user_online", Username: "bob"}) {
+			t.Fatalf("presence after the sweeps = %+v", got)
+		}
+	})
+}
+
+func TestPresenceRefreshedByHeartbeat(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		ts.touchPresence(ctx, "alice")
+		for i := 0; i < 3; i++ {
+			ts.clock.Advance(ts.presenceTTL / 2)
+			ts.touchPresence(ctx, "alice")
+			ts.expirePresence(ctx)
+		}
+		if users := ts.online(); !reflect.DeepEqual(users, []string{"alice"}) {
+			t.Fatalf("online = %v after regular heartbeats", users)
+		}
+	})
+}
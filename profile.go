@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Longest display name, in characters
+const maxDisplayNameLength = 30
+
+// Avatar of a player who hasn't picked one
+const defaultAvatarEmoji = "🐱"
+
+// Avatars a player may pick
+var avatarEmojis = map[string]bool{
+	"🐱": true, "😺": true, "😸": true, "😹": true, "😻": true, "😼": true,
+	"😽": true, "🙀": true, "😿": true, "😾": true, "🐈": true, "🐈‍⬛": true,
+	"🦁": true, "🐯": true, "💣": true, "🌮": true, "🌈": true, "🧔": true,
+}
+
+// How a player shows up next to their stats. The username stays the key
+// everything is stored under; the display name is only shown.
+type Profile struct {
+	DisplayName string `json:"displayName"`
+	AvatarEmoji string `json:"avatarEmoji"`
+}
+
+// The profile shown for a player who hasn't saved one: their username and
+// the default avatar
+func defaultProfile(username string) Profile {
+	return Profile{DisplayName: username, AvatarEmoji: defaultAvatarEmoji}
+}
+
+// Body of PUT /profile. An empty avatarEmoji picks the default.
+type ProfileRequest struct {
+	DisplayName string `json:"displayName"`
+	AvatarEmoji string `json:"avatarEmoji"`
+}
+
+// Profile route
+type ProfileResponse struct {
+	Username string  `json:"username"`
+	Profile  Profile `json:"profile"`
+}
+
+// Check a requested profile: a display name of 1 to 30 printable
+// characters, surrounding spaces trimmed, and an avatar from avatarEmojis
+func validateProfile(req ProfileRequest) (Profile, *APIError) {
+	name := strings.TrimSpace(req.DisplayName)
+	if length := utf8.RuneCountInString(name); length < 1 || length > maxDisplayNameLength {
+		return Profile{}, errInvalidRequest(fmt.Sprintf("displayName must be 1 to %d characters", maxDisplayNameLength))
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return Profile{}, errInvalidRequest("displayName may only hold printable characters")
+		}
+	}
+	avatar := req.AvatarEmoji
+	if avatar == "" {
+		avatar = defaultAvatarEmoji
+	}
+	if !avatarEmojis[avatar] {
+		return Profile{}, errInvalidRequest("avatarEmoji is not one of the allowed avatars")
+	}
+	return Profile{DisplayName: name, AvatarEmoji: avatar}, nil
+}
+
+// Profile route: set the session's user's display name and avatar
+func (s *Server) updateProfile(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	_, username, apiErr := s.sessionUser(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	var req ProfileRequest
+	if apiErr := bindStrict(c, &req); apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	profile, apiErr := validateProfile(req)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	if err := s.store.SetProfile(ctx, username, profile); err != nil {
+		log.Printf("Error saving profile of user %s: %v", username, err)
+		abortWithError(c, errStoreUnavailable("Error saving profile"))
+		return
+	}
+
+	// Leaderboard rows show the new name
+	s.leaderboard.invalidate()
+	s.broadcastLeaderboard()
+
+	c.JSON(http.StatusOK, ProfileResponse{Username: username, Profile: profile})
+}
+
+// The profiles of the players, read in one round trip, with the default
+// for those who haven't saved one. If the read fails everyone gets the
+// default; a response is better off without avatars than failing.
+func (s *Server) profilesOf(ctx context.Context, usernames []string) map[string]Profile {
+	saved, err := s.store.Profiles(ctx, usernames)
+	if err != nil {
+		log.Printf("Error retrieving profiles of %d users: %v", len(usernames), err)
+	}
+	profiles := make(map[string]Profile, len(usernames))
+	for _, username := range usernames {
+		profile, ok := saved[username]
+		if !ok {
+			profile = defaultProfile(username)
+		}
+		profiles[username] = profile
+	}
+	return profiles
+}
+
+// Fill in the display name and avatar of every leaderboard entry
+func (s *Server) attachProfiles(ctx context.Context, entries []LeaderboardEntry) {
+	usernames := make([]string, len(entries))
+	for i, entry := range entries {
+		usernames[i] = entry.Username
+	}
+	profiles := s.profilesOf(ctx, usernames)
+	for i := range entries {
+		profile := profiles[entries[i].Username]
+		entries[i].DisplayName = profile.DisplayName
+		entries[i].AvatarEmoji = profile.AvatarEmoji
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// A Redis hook keeping the size of each call that reads profiles: 1 for a
+// lone command, the number of commands for a pipeline
+type profileReads struct {
+	mutex sync.Mutex
+	calls []int
+}
+
+func readsProfile(cmd redis.Cmder) bool {
+	args := cmd.Args()
+	return cmd.Name() == "hmget" && len(args) > 1 && strings.HasPrefix(fmt.Sprint(args[1]), "profile:")
+}
+
+func (p *profileReads) record(cmds []redis.Cmder) {
+	n := 0
+	for _, cmd := range cmds {
+		if readsProfile(cmd) {
+			n++
+		}
+	}
+	if n > 0 {
+		p.mutex.Lock()
+		p.calls = append(p.calls, n)
+		p.mutex.Unlock()
+	}
+}
+
+func (p *profileReads) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	p.record([]redis.Cmder{cmd})
+	return ctx, nil
+}
+
+func (p *profileReads) AfterProcess(ctx context.Context, cmd redis.Cmder) error { return nil }
+
+func (p *profileReads) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	p.record(cmds)
+	return ctx, nil
+}
+
+func (p *profileReads) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestProfileValidation(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		guest := ts.guest()
+		for _, req := range []ProfileRequest{
+			{DisplayName: ""},
+			{DisplayName: "   "},
+			{DisplayName: strings.Repeat("a", maxDisplayNameLength+1)},
+			{DisplayName: "bell\a"},
+			{DisplayName: "Alice", AvatarEmoji: "🐶"},
+			{DisplayName: "Alice", AvatarEmoji: "cat"},
+		} {
+			assertError(t, ts.request(http.MethodPut, "/profile", req, bearer(guest.Token)...), http.StatusBadRequest, ErrCodeInvalidRequest)
+		}
+		assertError(t, ts.request(http.MethodPut, "/profile", ProfileRequest{DisplayName: "Alice"}), http.StatusUnauthorized, ErrCodeUnauthorized)
+
+		// Thirty characters, however many bytes, with the spaces around them
+		// trimmed and the default avatar
+		name := strings.Repeat("é", maxDisplayNameLength)
+		saved := decodeOK[ProfileResponse](t, ts.request(http.MethodPut, "/profile", ProfileRequest{DisplayName: " " + name + " "}, bearer(guest.Token)...))
+		if saved.Username != guest.Username || saved.Profile != (Profile{DisplayName: name, AvatarEmoji: defaultAvatarEmoji}) {
+			t.Fatalf("saved %+v", saved)
+		}
+	})
+}
+
+func TestLeaderboardProfilesReadInOnePipeline(t *testing.T) {
+	ctx := context.Background()
+	store := newTestRedisStore(t, keyBuilder{})
+	reads := &profileReads{}
+	store.rdb.AddHook(reads)
+	ts := newTestServer(t, store)
+	for i := 0; i < 20; i++ {
+		username := fmt.Sprintf("player%02d", i)
+		store.SetStats(ctx, username, int64(40-i), 0, AuditEntry{})
+		if i%2 == 0 {
+			store.SetProfile(ctx, username, Profile{DisplayName: "Player " + fmt.Sprint(i), AvatarEmoji: "🦁"})
+		}
+	}
+
+	rows := decodeOK[LeaderboardResponse](t, ts.get("/leaderboard")).Leaderboard
+	if len(reads.calls) != 1 || reads.calls[0] != 20 {
+		t.Fatalf("profiles were read in calls of %v, want one of 20", reads.calls)
+	}
+	for i, row := range rows {
+		want := defaultProfile(row.Username)
+		if i%2 == 0 {
+			want = Profile{DisplayName: "Player " + fmt.Sprint(i), AvatarEmoji: "🦁"}
+		}
+		if row.Username != fmt.Sprintf("player%02d", i) || row.DisplayName != want.DisplayName || row.AvatarEmoji != want.AvatarEmoji {
+			t.Fatalf("row %d = %+v, want %+v", i, row, want)
+		}
+	}
+}
+
+func TestProfilesShowInGameOverAndRoomSnapshot(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		ts.store.SetProfile(context.Background(), "alice", Profile{DisplayName: "Queen Alice", AvatarEmoji: "😻"})
+		socket := ts.dial("room=" + room.Code)
+		snapshot := decodeMessage[RoomSnapshot](t, socket.next("snapshot"))
+		if snapshot.Profiles["alice"] != (Profile{DisplayName: "Queen Alice", AvatarEmoji: "😻"}) || snapshot.Profiles["bob"] != defaultProfile("bob") {
+			t.Fatalf("snapshot profiles = %+v", snapshot.Profiles)
+		}
+
+		decodeOK[ForfeitResponse](t, ts.post("/forfeit", User{Username: "bob", GameID: room.gameID()}))
+		over := decodeMessage[RoomEvent](t, socket.next("game_over"))
+		if alice := over.Stats["alice"]; alice.DisplayName != "Queen Alice" || alice.AvatarEmoji != "😻" || alice.Wins != 1 {
+			t.Fatalf("game_over stats for alice = %+v", alice)
+		}
+		if bob := over.Stats["bob"]; bob.DisplayName != "bob" || bob.AvatarEmoji != defaultAvatarEmoji {
+			t.Fatalf("game_over stats for bob = %+v", bob)
+		}
+		// The display name is only shown: alice is still stored as alice
+		if win, _ := ts.stats("alice"); win != 1 {
+			t.Fatalf("alice has %d wins", win)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// What a sensitive field's value is replaced with in the access log
+const redactedValue = "[redacted]"
+
+// Bytes of a response body the access log keeps, after redaction
+const accessLogBodyLimit = 1024
+
+// The routes whose response bodies the access log keeps, keyed like
+// routeDocs; see routeDoc.LogBody
+var loggedBodyRoutes = logBodyRoutes(routeDocs)
+
+func logBodyRoutes(docs map[string]routeDoc) map[string]bool {
+	routes := make(map[string]bool)
+	for route, doc := range docs {
+		if doc.LogBody {
+			routes[route] = true
+		}
+	}
+	return routes
+}
+
+// The JSON names of the fields tagged sensitive:"true" in each route's
+// response, keyed like routeDocs. A sensitive field is something a client
+// must get but that must never reach a log: session tokens, peeked cards.
+var sensitiveFields = sensitiveRouteFields(routeDocs)
+
+func sensitiveRouteFields(docs map[string]routeDoc) map[string]map[string]bool {
+	fields := make(map[string]map[string]bool)
+	for route, doc := range docs {
+		if doc.Response == nil {
+			continue
+		}
+		names := make(map[string]bool)
+		collectSensitiveFields(reflect.TypeOf(doc.Response), names, make(map[reflect.Type]bool))
+		if len(names) > 0 {
+			fields[route] = names
+		}
+	}
+	return fields
+}
+
+// Add the JSON names of t's sensitive fields to names, looking through
+// pointers, slices and maps into nested structs
+func collectSensitiveFields(t reflect.Type, names map[string]bool, seen map[reflect.Type]bool) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return
+	}
+	seen[t] = true
+	for name, field := range jsonFields(t) {
+		if field.Tag.Get("sensitive") == "true" {
+			names[name] = true
+			continue
+		}
+		collectSensitiveFields(field.Type, names, seen)
+	}
+}
+
+// Captures what a handler writes, for the access log
+type bodyLogWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyLogWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyLogWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Gin middleware logging a line per request, with the response body of the
+// routes that opt in to it. Their sensitive fields are redacted wherever they
+// appear in it.
+func accessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		writer := &bodyLogWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		var line strings.Builder
+		writeLogField(&line, "event", "access")
+		writeLogField(&line, "method", c.Request.Method)
+		writeLogField(&line, "path", c.Request.URL.Path)
+		writeLogField(&line, "status", c.Writer.Status())
+		writeLogField(&line, "ms", time.Since(start).Milliseconds())
+		writeLogField(&line, "request", requestIDFrom(c.Request.Context()))
+		route := c.Request.Method + " " + c.FullPath()
+		if loggedBodyRoutes[route] && writer.body.Len() > 0 && strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "application/json") {
+			body := redactBody(writer.body.Bytes(), sensitiveFields[route])
+			if len(body) > accessLogBodyLimit {
+				body = append(body[:accessLogBodyLimit:accessLogBodyLimit], "..."...)
+			}
+			writeLogField(&line, "body", string(body))
+		}
+		log.Print(line.String())
+	}
+}
+
+// body with the values of the named fields replaced at any depth. A body
+// that isn't JSON can't hold them and comes back as it is.
+func redactBody(body []byte, names map[string]bool) []byte {
+	if len(names) == 0 {
+		return body
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return body
+	}
+	redacted, err := json.Marshal(redactValue(value, names))
+	if err != nil {
+		return []byte(redactedValue)
+	}
+	return redacted
+}
+
+func redactValue(value interface{}, names map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if names[key] {
+				v[key] = redactedValue
+			} else {
+				v[key] = redactValue(field, names)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item, names)
+		}
+	}
+	return value
+}
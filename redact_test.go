@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// The access log's line for the request to path, failing the test when
+// there isn't exactly one
+func accessLine(t *testing.T, capture *logCapture, method, path string) string {
+	t.Helper()
+	var found []string
+	for _, line := range strings.Split(capture.String(), "\n") {
+		if strings.Contains(line, "event=access method="+method+" path="+path+" ") {
+			found = append(found, line)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("access lines for %s %s = %q", method, path, found)
+	}
+	return found[0]
+}
+
+func TestPeekFallbackIsRedactedInAccessLog(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		capture := captureLog(t)
+		ts.startGame("alice", "Beard Cat", engine.ExplodingKitten, "Rainbow Cat")
+		ts.deal("alice", "See the Future")
+
+		played := decodeOK[PlayCardResponse](t, ts.post("/play-card", PlayCardRequest{Username: "alice", Card: "See the Future"}))
+		if !played.Peeked || played.Peek == nil || len(played.Peek.Cards) != 3 || played.Peek.Cards[1].Type != engine.ExplodingKitten {
+			t.Fatalf("played = %+v", played)
+		}
+		line := accessLine(t, capture, http.MethodPost, "/play-card")
+		if !strings.Contains(line, "body=") || !strings.Contains(line, redactedValue) {
+			t.Fatalf("access line = %q, want the body with the peek redacted", line)
+		}
+		for _, card := range []string{"Beard Cat", engine.ExplodingKitten, "Rainbow Cat"} {
+			if strings.Contains(line, card) {
+				t.Fatalf("access line gives away %s: %q", card, line)
+			}
+		}
+	})
+}
+
+func TestPeekGoesOverTheSocket(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Beard Cat", engine.ExplodingKitten, "Rainbow Cat")
+		ts.deal("alice", "See the Future")
+		socket := ts.dial("username=alice")
+		socket.next("leaderboard")
+
+		played := decodeOK[PlayCardResponse](t, ts.post("/play-card", PlayCardRequest{Username: "alice", Card: "See the Future"}))
+		if !played.Peeked || played.Peek != nil {
+			t.Fatalf("played with a socket open = %+v", played)
+		}
+		peek := decodeMessage[PeekMessage](t, socket.next("peek"))
+		if peek.GameID != "alice" || len(peek.Cards) != 3 || peek.Cards[0].Type != "Beard Cat" || peek.Cards[1].Type != engine.ExplodingKitten || peek.Cards[2].Type != "Rainbow Cat" {
+			t.Fatalf("peek = %+v", peek)
+		}
+		if want := ts.clock.Now().Add(peekDisplay); !peek.ExpiresAt.Equal(want) {
+			t.Fatalf("peek expires at %v, want %v", peek.ExpiresAt, want)
+		}
+		// The cards are still there to be drawn in that order
+		if drawn := decodeOK[DrawCardResponse](t, ts.draw("alice")); drawn.Card.Type != "Beard Cat" {
+			t.Fatalf("drew %+v after the peek", drawn.Card)
+		}
+	})
+}
+
+func TestSecretRoutesLogNoBody(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{"ADMIN_TOKEN": testAdminToken, "APP_ENV": "development"}))
+		ts.startGame("alice")
+		ts.playOut("alice")
+		capture := captureLog(t)
+
+		key := ts.createAPIKey(ScopeLeaderboardRead)
+		fairness := decodeOK[FairnessResponse](t, ts.get("/game/alice/fairness?username=alice"))
+		decodeOK[AdminUserDump](t, ts.get("/admin/users/alice", asAdmin...))
+		ts.startGame("bob", "Beard Cat", engine.ExplodingKitten)
+		decodeOK[DebugDeckResponse](t, ts.get("/debug/deck/bob"))
+
+		for _, request := range []struct{ method, path, secret string }{
+			{http.MethodPost, "/admin/apikeys", key.Key},
+			{http.MethodGet, "/game/alice/fairness", fairness.Seed},
+			{http.MethodGet, "/admin/users/alice", "deck"},
+			{http.MethodGet, "/debug/deck/bob", "Beard Cat"},
+		} {
+			line := accessLine(t, capture, request.method, request.path)
+			if strings.Contains(line, "body=") || strings.Contains(line, request.secret) {
+				t.Errorf("access line for %s %s = %q", request.method, request.path, line)
+			}
+		}
+		// Logged or not, the secrets are never written out in full
+		if log := capture.String(); strings.Contains(log, key.Key) || strings.Contains(log, fairness.Seed) {
+			t.Fatalf("log holds a secret:\n%s", log)
+		}
+	})
+}
+
+func TestEverySecretFieldIsSensitive(t *testing.T) {
+	for route, names := range map[string][]string{
+		"POST /admin/apikeys":        {"key"},
+		"GET /debug/deck/:username":  {"deck", "hand", "game"},
+		"GET /admin/users/:username": {"deck", "hand"},
+		"GET /game/:gameId/fairness": {"seed", "openingDeck"},
+		"POST /rooms/:code/invite":   {"token"},
+		"POST /play-card":            {"peek"},
+		"POST /guest":                {"token"},
+	} {
+		for _, name := range names {
+			if !sensitiveFields[route][name] {
+				t.Errorf("%s field %s isn't tagged sensitive", route, name)
+			}
+		}
+	}
+	// Only the gameplay routes keep their bodies
+	for route := range loggedBodyRoutes {
+		if strings.Contains(route, "/admin/") || strings.Contains(route, "/debug/") || strings.Contains(route, "fairness") {
+			t.Errorf("%s logs its response body", route)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func gameOver(status string) bool {
+	return status == GameStatusLost || status == GameStatusWon
+}
+
+// Reject moves on a solo game that has ended. Rooms track this in the room.
+func (s *Server) checkGameActive(ctx context.Context, game *GameSession) *APIError {
+	if game.Room != nil {
+		return nil
+	}
+	status, err := s.store.GetGameStatus(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error checking game status for user %s: %v", game.Username, err)
+		return errStoreUnavailable("Error checking game status")
+	}
+	if gameOver(status) {
+		return errGameOver(status)
+	}
+	return nil
+}
+
+// Rematch route: deal a finished solo game a fresh deck and an empty hand.
+// Stats carry over, unlike /start-game.
+func (s *Server) rematch(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user, apiErr := bindUser(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	game, apiErr := s.resolveGame(ctx, user)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if game.Room != nil {
+		abortWithError(c, errInvalidRequest("Rematches are only for solo games"))
+		return
+	}
+
+	// A rematch racing this one finds the game already restarted
+	token, apiErr := s.lockGameCreation(ctx, game.Username)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	defer s.unlockGameCreation(game.Username, token)
+
+	status, err := s.store.GetGameStatus(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error checking game status for user %s: %v", game.Username, err)
+		abortWithError(c, errStoreUnavailable("Error checking game status"))
+		return
+	}
+	if !gameOver(status) {
+		abortWithError(c, errGameNotFinished())
+		return
+	}
+
+	// Emptying the hand resets the defuse count too
+	if err := s.store.ClearHand(ctx, game.Username); err != nil {
+		log.Printf("Error clearing hand for user %s: %v", game.Username, err)
+		abortWithError(c, errStoreUnavailable("Error clearing hand"))
+		return
+	}
+	// Nor does the new game owe draws the last one did
+	if err := s.store.SetPendingDraws(ctx, game.ID, game.Username, 0); err != nil {
+		log.Printf("Error clearing draws owed by user %s: %v", game.Username, err)
+		abortWithError(c, errStoreUnavailable("Error starting rematch"))
+		return
+	}
+	// A rematch is played in the same mode
+	if apiErr := s.loadMode(ctx, game); apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if err := s.initializeDeck(ctx, game.ID, game.Mode); err != nil {
+		abortWithError(c, errStoreUnavailable("Error initializing deck"))
+		return
+	}
+	played, err := s.store.IncrGamesPlayed(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error counting games played for user %s: %v", game.Username, err)
+		abortWithError(c, errStoreUnavailable("Error starting rematch"))
+		return
+	}
+	snapshot, apiErr := s.gameSnapshot(ctx, game)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	gamesStartedTotal.Inc()
+
+	log.Printf("User %s started rematch %d", game.Username, played)
+	c.JSON(http.StatusOK, RematchResponse{
+		Message:     fmt.Sprintf("Rematch started after you %s", status),
+		Username:    game.Username,
+		GameID:      game.ID,
+		Snapshot:    snapshot,
+		GamesPlayed: played,
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+func TestRematchAfterLoss(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", engine.ExplodingKitten, "Cat", "Skip")
+		ts.owe("alice", "alice", 2)
+		if lost := decodeOK[DrawCardResponse](t, ts.draw("alice")); lost.GameStatus != GameStatusLost {
+			t.Fatalf("draw = %+v, want a loss", lost)
+		}
+
+		// The leftover deck is dead
+		assertError(t, ts.draw("alice"), http.StatusConflict, ErrCodeGameFinished)
+		assertError(t, ts.post("/draw-cards", DrawCardsRequest{Username: "alice", Count: 1}), http.StatusConflict, ErrCodeGameFinished)
+
+		w := ts.post("/rematch", User{Username: "alice"})
+		rematch := decodeOK[RematchResponse](t, w)
+		if rematch.GamesPlayed != 1 || rematch.Snapshot == nil || rematch.Snapshot.Remaining != ts.soloDeck.Size || rematch.Snapshot.Status != GameStatusActive {
+			t.Fatalf("rematch = %+v", rematch)
+		}
+		// Only how many cards are left, not where the bombs are
+		assertNoDeck(t, w.Body.Bytes())
+		if hand := ts.hand("alice"); len(hand) != 0 {
+			t.Fatalf("hand after rematch = %v", hand)
+		}
+		// Draws the lost game still owed don't carry over
+		if owed, err := ts.store.PendingDraws(context.Background(), "alice", "alice"); err != nil || owed != 0 {
+			t.Fatalf("owed after rematch = %d, %v", owed, err)
+		}
+
+		ts.setDeck("alice", "Cat", engine.ExplodingKitten, "Skip")
+		drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if drawn.Card.Type != "Cat" || drawn.GameStatus != GameStatusActive || drawn.DefuseCount != 0 {
+			t.Fatalf("draw after rematch = %+v", drawn)
+		}
+		// Unlike /start-game, a rematch keeps the stats
+		if _, lose := ts.stats("alice"); lose != 1 {
+			t.Fatalf("losses = %d after rematch, want 1", lose)
+		}
+	})
+}
+
+func TestRematchCountsGames(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", engine.ExplodingKitten)
+		for round := int64(1); round <= 3; round++ {
+			decodeOK[DrawCardResponse](t, ts.draw("alice"))
+			rematch := decodeOK[RematchResponse](t, ts.post("/rematch", User{Username: "alice"}))
+			if rematch.GamesPlayed != round {
+				t.Fatalf("rematch %d counted %d games", round, rematch.GamesPlayed)
+			}
+			ts.setDeck("alice", "Cat", engine.ExplodingKitten)
+		}
+		if win, _ := ts.stats("alice"); win != 3 {
+			t.Fatalf("wins = %d, want 3", win)
+		}
+	})
+}
+
+func TestRematchNeedsFinishedGame(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice")
+		assertError(t, ts.post("/rematch", User{Username: "alice"}), http.StatusConflict, ErrCodeGameNotFinished)
+
+		room := ts.openRoom("bob", "carol")
+		assertError(t, ts.post("/rematch", User{Username: "bob", GameID: room.gameID()}), http.StatusBadRequest, ErrCodeInvalidRequest)
+	})
+}
+
+func TestStartGameDoesNotResumeFinishedGame(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", engine.ExplodingKitten, "Cat")
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+
+		started := ts.startGame("alice")
+		if started.Message != "Game started" || started.Snapshot.Status != GameStatusActive || started.Snapshot.Remaining != ts.soloDeck.Size {
+			t.Fatalf("start after a loss = %+v", started)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Kinds of move in a game's log
+const (
+	MoveDraw       = "draw"
+	MoveDrawBottom = "draw_bottom"
+	MovePlay       = "play"
+	MovePlayPair   = "play_pair"
+	MoveForfeit    = "forfeit"
+	MoveDiscard    = "discard"
+	// Deciding about a bomb drawn holding a Defuse; Card is "Defuse" if it
+	// was used
+	MoveResolveBomb = "resolve_bomb"
+	// A card put into the deck by an admin, whose actor is adminActor
+	MoveInject = "inject"
+)
+
+// One entry of a game's move log, recorded where the move's events are sent
+type Move struct {
+	Seq    int64  `json:"seq"`
+	Actor  string `json:"actor"`
+	Action string `json:"action"`
+	// The card drawn or played, or the cat type of a pair
+	Card            string    `json:"card,omitempty"`
+	ResultingStatus string    `json:"resultingStatus"`
+	TS              time.Time `json:"ts"`
+}
+
+// Append a move to the game's log. The move has already happened, so a
+// failure only loses the entry.
+func (s *Server) recordMove(ctx context.Context, game *GameSession, action, card, status string) {
+	seq, err := s.store.NextMoveSeq(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error reserving move seq of game %s: %v", game.ID, err)
+		return
+	}
+	move := Move{
+		Seq:             seq,
+		Actor:           game.Username,
+		Action:          action,
+		Card:            card,
+		ResultingStatus: status,
+		TS:              s.clock.Now().UTC(),
+	}
+	payload, err := json.Marshal(move)
+	if err != nil {
+		log.Printf("Error encoding move of game %s: %v", game.ID, err)
+		return
+	}
+	if err := s.store.AppendMove(ctx, game.ID, payload); err != nil {
+		log.Printf("Error logging move of game %s: %v", game.ID, err)
+	}
+}
+
+// Whether the game has ended, so its moves can be shown without giving
+// anything away
+func (s *Server) gameFinished(ctx context.Context, game *GameSession) (bool, *APIError) {
+	if game.Room != nil {
+		return game.Room.Status == RoomFinished, nil
+	}
+	status, err := s.store.GetGameStatus(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error retrieving status of game %s: %v", game.ID, err)
+		return false, errStoreUnavailable("Error retrieving game status")
+	}
+	return gameOver(status), nil
+}
+
+// Replay route: every move of a finished game, in order. Refused while the
+// game is running, since the log shows the cards drawn.
+func (s *Server) getGameReplay(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	username := c.Query("username")
+	if !usernamePattern.MatchString(username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+	game, apiErr := s.resolveGame(ctx, User{Username: username, GameID: c.Param("gameId")})
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	moves, apiErr := s.finishedMoves(ctx, game)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, ReplayResponse{GameID: game.ID, Moves: moves, Summary: s.savedSummary(ctx, game)})
+}
+
+// The logged moves of a game, oldest first, once it has finished
+func (s *Server) finishedMoves(ctx context.Context, game *GameSession) ([]Move, *APIError) {
+	finished, apiErr := s.gameFinished(ctx, game)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if !finished {
+		return nil, errReplayUnavailable()
+	}
+
+	entries, err := s.store.Moves(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error retrieving moves of game %s: %v", game.ID, err)
+		return nil, errStoreUnavailable("Error retrieving moves")
+	}
+	moves := make([]Move, 0, len(entries))
+	for _, entry := range entries {
+		var move Move
+		if err := json.Unmarshal(entry, &move); err != nil {
+			log.Printf("Warning: skipping unreadable move of game %s: %v", game.ID, err)
+			continue
+		}
+		moves = append(moves, move)
+	}
+	return moves, nil
+}
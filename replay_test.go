@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// Replay a finished game's moves through the engine, from the opening deck
+// its revealed seed shuffles to, and check the final state matches the
+// server's. The deck has no Defuse or Shuffle, so nothing but the opening
+// deck is left to chance.
+func TestReplayReproducesSeededGame(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{
+			"SOLO_DECK": "Cat=3,Tacocat=2,Beard Cat=1,Exploding Kitten=1",
+		}))
+		for round := 0; round < 5; round++ {
+			username := fmt.Sprintf("player%d", round)
+			started := ts.startGame(username)
+			assertError(t, ts.get("/game/"+username+"/replay?username="+username), http.StatusForbidden, ErrCodeGameNotFinished)
+
+			var last DrawCardResponse
+			for last.GameStatus == "" || last.GameStatus == GameStatusActive {
+				last = decodeOK[DrawCardResponse](t, ts.draw(username))
+			}
+			replay := decodeOK[ReplayResponse](t, ts.get("/game/"+username+"/replay?username="+username))
+			fairness := decodeOK[FairnessResponse](t, ts.get("/game/"+username+"/fairness?username="+username))
+			if !fairness.Verified || fairness.Commitment != started.Snapshot.Commitment {
+				t.Fatalf("fairness = %+v, committed to %q", fairness, started.Snapshot.Commitment)
+			}
+
+			game := &engine.Game{Deck: append([]string(nil), fairness.OpeningDeck...), Status: engine.StatusActive}
+			for i, move := range replay.Moves {
+				if move.Seq != int64(i+1) || move.Action != MoveDraw || move.Actor != username {
+					t.Fatalf("move %d = %+v", i, move)
+				}
+				card := game.Deck[0]
+				if card != move.Card {
+					t.Fatalf("move %d drew %s, the seed's deck has %s on top", i, move.Card, card)
+				}
+				game.Deck = game.Deck[1:]
+				game.Settle(card, cardRegistry[cardIndex[card]].Effect, nil)
+				if game.Status == engine.StatusActive && game.Won() {
+					game.Status = engine.StatusWon
+				}
+			}
+
+			if game.Status != last.GameStatus || replay.Moves[len(replay.Moves)-1].ResultingStatus != last.GameStatus {
+				t.Fatalf("round %d: replay ends %s, the game ended %s", round, game.Status, last.GameStatus)
+			}
+			if len(game.Deck) != last.Remaining {
+				t.Fatalf("round %d: replay leaves %d cards, the game %d", round, len(game.Deck), last.Remaining)
+			}
+			if hand := ts.hand(username); !reflect.DeepEqual(hand, game.Hand) && len(hand)+len(game.Hand) > 0 {
+				t.Fatalf("round %d: replay holds %v, the game %v", round, game.Hand, hand)
+			}
+		}
+	})
+}
+
+// The same with Defuses and a Shuffle in the deck: the defused bomb's way back
+// in and the fresh deck the Shuffle deals carry on from the seed's source, so
+// replaying the moves with it ends where the game did. Which games use both
+// is down to the seed, so rounds are played until some have.
+func TestReplayReproducesDefuseAndShuffle(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{
+			"SOLO_DECK":        "Defuse=2,Shuffle=1,Cat=2,Exploding Kitten=2",
+			"SHUFFLE_COOLDOWN": "0",
+			"MAX_HAND_SIZE":    "50",
+		}))
+		covered := 0
+		for round := 0; round < 40 && covered < 3; round++ {
+			username := fmt.Sprintf("player%d", round)
+			ts.startGame(username)
+
+			// Spend a Defuse on the first bomb, and let the next go off
+			defused := false
+			var status string
+			for status == "" || status == GameStatusActive || status == GameStatusPendingDefuse {
+				if status == GameStatusPendingDefuse {
+					status = ts.resolveBomb(username, !defused).GameStatus
+					defused = true
+					continue
+				}
+				status = decodeOK[DrawCardResponse](t, ts.draw(username)).GameStatus
+			}
+			replay := decodeOK[ReplayResponse](t, ts.get("/game/"+username+"/replay?username="+username))
+			fairness := decodeOK[FairnessResponse](t, ts.get("/game/"+username+"/fairness?username="+username))
+			if !fairness.Verified {
+				t.Fatalf("fairness = %+v", fairness)
+			}
+
+			var seed [engine.SeedSize]byte
+			hex.Decode(seed[:], []byte(fairness.Seed))
+			rng := engine.SeededRNG(seed)
+			game := &engine.Game{Deck: buildDeck(ts.soloDeck, rng), Status: engine.StatusActive}
+			if !reflect.DeepEqual(game.Deck, fairness.OpeningDeck) {
+				t.Fatalf("round %d: the seed deals %v, the route %v", round, game.Deck, fairness.OpeningDeck)
+			}
+			shuffled, bombDefused := false, false
+			for i, move := range replay.Moves {
+				switch move.Action {
+				case MoveDraw:
+					card := game.Deck[0]
+					if card != move.Card {
+						t.Fatalf("round %d, move %d drew %s, the replay has %s on top", round, i, move.Card, card)
+					}
+					game.Deck = game.Deck[1:]
+					if card == engine.ExplodingKitten && game.DefuseCount > 0 {
+						// Left for the player to decide about
+						continue
+					}
+					if game.Settle(card, cardEffect(card), rng).Type == engine.Reshuffle {
+						game.Deck = buildDeck(ts.soloDeck, rng)
+						shuffled = true
+					}
+				case MoveResolveBomb:
+					if move.Card != engine.Defuse {
+						game.DefuseCount = 0
+					}
+					bombDefused = game.Settle(engine.ExplodingKitten, engine.Explode, rng).Type == engine.BombDefused || bombDefused
+				default:
+					t.Fatalf("round %d, move %d = %+v", round, i, move)
+				}
+				if game.Status == engine.StatusActive && game.Won() {
+					game.Status = engine.StatusWon
+				}
+			}
+
+			if game.Status != status {
+				t.Fatalf("round %d: replay ends %s, the game ended %s", round, game.Status, status)
+			}
+			if deck := ts.deck(username); !reflect.DeepEqual(deck, game.Deck) {
+				t.Fatalf("round %d: replay leaves %v, the game %v", round, game.Deck, deck)
+			}
+			if hand := ts.hand(username); !reflect.DeepEqual(hand, game.Hand) && len(hand)+len(game.Hand) > 0 {
+				t.Fatalf("round %d: replay holds %v, the game %v", round, game.Hand, hand)
+			}
+			if shuffled && bombDefused {
+				covered++
+			}
+		}
+		if covered == 0 {
+			t.Fatal("no game both defused a bomb and reshuffled")
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long a finished game's deck, events and moves are kept when
+// FINISHED_GAME_RETENTION isn't set, and how often they are swept when
+// FINISHED_GAME_SWEEP_INTERVAL isn't
+const (
+	defaultFinishedGameRetention     = 24 * time.Hour
+	defaultFinishedGameSweepInterval = 10 * time.Minute
+)
+
+// Keys of each pattern whose memory /admin/storage measures, unless ?sample=
+// says otherwise
+const (
+	defaultStorageSamples = 20
+	maxStorageSamples     = 1000
+)
+
+// How much the stores keep of their append-only logs. Every append goes
+// through the store's capped append, which trims the list to its cap.
+type retentionPolicy struct {
+	// Entries kept per event stream and per move log
+	Events int64
+	Moves  int64
+	// Entries kept of the audit log, which never expires. Redis trims its
+	// stream to about this many.
+	Audit int64
+	// How long event streams and move logs outlive their last append
+	LogTTL time.Duration
+}
+
+var defaultRetention = retentionPolicy{
+	Events: eventHistoryLength,
+	Moves:  eventHistoryLength,
+	Audit:  10000,
+	LogTTL: eventHistoryTTL,
+}
+
+// Append entry to a log kept in memory, dropping the oldest entries past
+// limit
+func cappedAppend(entries [][]byte, entry []byte, limit int64) [][]byte {
+	entries = append(entries, append([]byte(nil), entry...))
+	if int64(len(entries)) > limit {
+		entries = entries[int64(len(entries))-limit:]
+	}
+	return entries
+}
+
+// The approximate memory of the keys matching one pattern
+type KeyUsage struct {
+	Pattern string `json:"pattern"`
+	Keys    int64  `json:"keys"`
+	// Keys whose memory was measured; ApproxBytes scales their average up
+	// to every key of the pattern
+	SampledKeys int64 `json:"sampledKeys"`
+	ApproxBytes int64 `json:"approxBytes"`
+}
+
+// Last segments naming a kind of key rather than its ID
+var keyPatternSuffixes = map[string]bool{"moves": true, "state": true, "chat": true, "lose": true}
+
+// The pattern a key is reported under: its prefix with the IDs replaced by
+// "*", e.g. game:room:ABC234:moves is game:*:moves. Keys without IDs, such
+// as win or audit, are their own pattern.
+func keyPattern(key string) string {
+	parts := strings.Split(key, ":")
+	if len(parts) == 1 {
+		return key
+	}
+	pattern := parts[0] + ":*"
+	if last := parts[len(parts)-1]; len(parts) > 2 && keyPatternSuffixes[last] {
+		pattern += ":" + last
+	}
+	return pattern
+}
+
+// Key counts per pattern, with the memory of the first samples keys of each
+type storageTally struct {
+	samples int
+	usage   map[string]*KeyUsage
+	bytes   map[string]int64
+}
+
+func newStorageTally(samples int) *storageTally {
+	return &storageTally{samples: samples, usage: make(map[string]*KeyUsage), bytes: make(map[string]int64)}
+}
+
+// Count a key. Returns its pattern and whether that pattern still wants a
+// sample.
+func (t *storageTally) count(key string) (string, bool) {
+	pattern := keyPattern(key)
+	usage := t.usage[pattern]
+	if usage == nil {
+		usage = &KeyUsage{Pattern: pattern}
+		t.usage[pattern] = usage
+	}
+	usage.Keys++
+	return pattern, usage.SampledKeys < int64(t.samples)
+}
+
+func (t *storageTally) sample(pattern string, bytes int64) {
+	t.usage[pattern].SampledKeys++
+	t.bytes[pattern] += bytes
+}
+
+// The patterns, largest first
+func (t *storageTally) report() []KeyUsage {
+	report := make([]KeyUsage, 0, len(t.usage))
+	for pattern, usage := range t.usage {
+		if usage.SampledKeys > 0 {
+			usage.ApproxBytes = t.bytes[pattern] * usage.Keys / usage.SampledKeys
+		}
+		report = append(report, *usage)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].ApproxBytes != report[j].ApproxBytes {
+			return report[i].ApproxBytes > report[j].ApproxBytes
+		}
+		return report[i].Pattern < report[j].Pattern
+	})
+	return report
+}
+
+// Admin storage route: approximate key counts and memory per key pattern,
+// measured on ?sample= keys of each pattern
+func (s *Server) adminStorage(c *gin.Context) {
+	samples := defaultStorageSamples
+	if raw := c.Query("sample"); raw != "" {
+		var err error
+		samples, err = strconv.Atoi(raw)
+		if err != nil || samples < 0 || samples > maxStorageSamples {
+			abortWithError(c, errInvalidRequest("sample must be between 0 and "+strconv.Itoa(maxStorageSamples)))
+			return
+		}
+	}
+
+	usage, err := s.store.StorageUsage(c.Request.Context(), samples)
+	if err != nil {
+		log.Printf("Error measuring storage: %v", err)
+		abortWithError(c, errStoreUnavailable("Error measuring storage"))
+		return
+	}
+	var keys, bytes int64
+	for _, pattern := range usage {
+		keys += pattern.Keys
+		bytes += pattern.ApproxBytes
+	}
+
+	c.JSON(http.StatusOK, AdminStorageResponse{
+		Patterns:    usage,
+		Keys:        keys,
+		ApproxBytes: bytes,
+		Samples:     samples,
+	})
+}
+
+// Start the game's retention window. The game is over either way, so a
+// failure only keeps it around until it is restarted or deleted.
+func (s *Server) markFinished(ctx context.Context, gameID string) {
+	if err := s.store.MarkGameFinished(ctx, gameID, s.clock.Now()); err != nil {
+		log.Printf("Error marking game %s finished: %v", gameID, err)
+	}
+}
+
+// Drop the deck, events and moves of games that finished more than
+// finishedRetention ago, and the rooms they were played in
+func (s *Server) sweepFinished(ctx context.Context) {
+	swept, err := s.store.SweepFinishedGames(ctx, s.clock.Now().Add(-s.finishedRetention))
+	if err != nil {
+		log.Printf("Error sweeping finished games: %v", err)
+	}
+	if len(swept) > 0 {
+		log.Printf("Swept %d finished games", len(swept))
+	}
+}
+
+// Run sweepFinished every interval until ctx is done
+func (s *Server) sweepFinishedGames(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.storeTripped() {
+				s.sweepFinished(ctx)
+			}
+		}
+	}
+}
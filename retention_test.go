@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Give the store a retention policy, as main does from the configuration
+func setRetention(store GameStore, policy retentionPolicy) {
+	switch store := store.(type) {
+	case *memoryStore:
+		store.retention = policy
+	case *redisStore:
+		store.retention = policy
+	}
+}
+
+// The entries as strings, for comparing
+func entryStrings(entries [][]byte) []string {
+	out := make([]string, len(entries))
+	for i, entry := range entries {
+		out[i] = string(entry)
+	}
+	return out
+}
+
+func TestAppendsKeepToTheirCaps(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		setRetention(store, retentionPolicy{Events: 3, Moves: 2, Audit: 4, LogTTL: time.Hour})
+		for i := 1; i <= 6; i++ {
+			entry := []byte(fmt.Sprint(i))
+			if err := store.AppendEvent(ctx, "alice", entry); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.AppendMove(ctx, "alice", entry); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.AppendChat(ctx, "ABCD", entry, 5); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.AppendAudit(ctx, AuditEntry{Action: "reset", Username: fmt.Sprint(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		events, _, err := store.EventHistory(ctx, "alice")
+		if err != nil || !reflect.DeepEqual(entryStrings(events), []string{"4", "5", "6"}) {
+			t.Fatalf("events = %q, %v; want the last 3", entryStrings(events), err)
+		}
+		moves, err := store.Moves(ctx, "alice")
+		if err != nil || !reflect.DeepEqual(entryStrings(moves), []string{"5", "6"}) {
+			t.Fatalf("moves = %q, %v; want the last 2", entryStrings(moves), err)
+		}
+		chat, err := store.RoomChat(ctx, "ABCD")
+		if err != nil || !reflect.DeepEqual(entryStrings(chat), []string{"2", "3", "4", "5", "6"}) {
+			t.Fatalf("chat = %q, %v; want the last 5", entryStrings(chat), err)
+		}
+		// Redis trims the audit stream approximately, so it may keep more,
+		// but never the whole log and always the newest entry
+		audit, err := store.AuditLog(ctx, nil, 100)
+		if err != nil || len(audit) == 0 || len(audit) >= 6 || audit[len(audit)-1].Username != "6" {
+			t.Fatalf("audit = %+v, %v; want it trimmed to about 4", audit, err)
+		}
+	})
+}
+
+func TestSweeperRemovesOnlyExpiredGames(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		room := roomGameIDPrefix + "ABCD"
+		for _, gameID := range []string{"old", "recent", "active", room} {
+			if err := store.CreateDeck(ctx, gameID, []string{"Cat", "Tacocat"}); err != nil {
+				t.Fatal(err)
+			}
+			store.IncrGamesPlayed(ctx, gameID)
+			store.MarkGameStarted(ctx, gameID, testEpoch)
+			store.AppendMove(ctx, gameID, []byte("move"))
+			store.AppendEvent(ctx, gameID, []byte("event"))
+		}
+		store.MarkGameFinished(ctx, "old", testEpoch.Add(time.Minute))
+		store.MarkGameFinished(ctx, room, testEpoch.Add(time.Minute))
+		store.MarkGameFinished(ctx, "recent", testEpoch.Add(2*time.Hour))
+
+		swept, err := store.SweepFinishedGames(ctx, testEpoch.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]bool{"old": true, room: true}; len(swept) != len(want) || !want[swept[0]] || !want[swept[1]] {
+			t.Fatalf("swept %q, want old and the room game", swept)
+		}
+
+		for gameID, kept := range map[string]bool{"old": false, room: false, "recent": true, "active": true} {
+			deck, _ := store.GetDeck(ctx, gameID)
+			moves, _ := store.Moves(ctx, gameID)
+			events, _, _ := store.EventHistory(ctx, gameID)
+			if (len(deck) > 0) != kept || (len(moves) > 0) != kept || (len(events) > 0) != kept {
+				t.Fatalf("%s after the sweep has %d cards, %d moves, %d events; kept = %t", gameID, len(deck), len(moves), len(events), kept)
+			}
+		}
+		// The solo game keeps its count of games played, and is no longer
+		// marked, so a second sweep leaves it alone
+		if played, err := store.IncrGamesPlayed(ctx, "old"); err != nil || played != 2 {
+			t.Fatalf("games played = %d, %v; want the count kept", played, err)
+		}
+		if swept, err := store.SweepFinishedGames(ctx, testEpoch.Add(3*time.Hour)); err != nil || len(swept) != 1 || swept[0] != "recent" {
+			t.Fatalf("second sweep = %q, %v; want only recent", swept, err)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+// How long a drawn bomb stays hidden when BOMB_REVEAL_DELAY isn't set
+const defaultRevealDelay = 1500 * time.Millisecond
+
+// A message held back by a delay, or waiting behind one for the same channel
+type queuedMessage struct {
+	channel string
+	due     time.Time
+	send    func()
+}
+
+// Send a message on the channel now, or once delay has passed. A message for
+// a channel with messages still waiting goes out after them, so each room and
+// player stream keeps its order.
+func (h *Hub) dispatch(channel string, delay time.Duration, send func()) {
+	h.queueMutex.Lock()
+	defer h.queueMutex.Unlock()
+
+	if delay <= 0 && !h.queuedFor(channel) {
+		send()
+		return
+	}
+	h.queue = append(h.queue, queuedMessage{channel: channel, due: h.clock.Now().Add(delay), send: send})
+	h.scheduleQueue()
+}
+
+// Whether the channel has messages waiting. Callers hold the queue mutex.
+func (h *Hub) queuedFor(channel string) bool {
+	for _, message := range h.queue {
+		if message.channel == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// Send the waiting messages that are due, or all of them when flushing, in
+// the order they were queued. Callers hold the queue mutex.
+func (h *Hub) drainQueue(flush bool) {
+	now := h.clock.Now()
+	held := make(map[string]bool)
+	waiting := h.queue[:0]
+	for _, message := range h.queue {
+		if held[message.channel] || (!flush && message.due.After(now)) {
+			held[message.channel] = true
+			waiting = append(waiting, message)
+			continue
+		}
+		message.send()
+	}
+	for i := len(waiting); i < len(h.queue); i++ {
+		h.queue[i] = queuedMessage{}
+	}
+	h.queue = waiting
+	h.scheduleQueue()
+}
+
+// (Re)arm the timer for the earliest message that can go out when it is
+// due: the first waiting on each channel. One queued behind it may be due
+// sooner, but waits, and arming for it would fire the timer over and over.
+// Callers hold the queue mutex.
+func (h *Hub) scheduleQueue() {
+	if h.queueTimer != nil {
+		h.queueTimer.Stop()
+		h.queueTimer = nil
+	}
+	if len(h.queue) == 0 {
+		return
+	}
+	due := h.queue[0].due
+	first := map[string]bool{h.queue[0].channel: true}
+	for _, message := range h.queue[1:] {
+		if first[message.channel] {
+			continue
+		}
+		first[message.channel] = true
+		if message.due.Before(due) {
+			due = message.due
+		}
+	}
+	h.queueTimer = h.clock.AfterFunc(due.Sub(h.clock.Now()), func() {
+		h.queueMutex.Lock()
+		defer h.queueMutex.Unlock()
+		h.drainQueue(false)
+	})
+}
+
+// Send every waiting message now, e.g. before shutting down, so no reveal is
+// lost
+func (h *Hub) flushQueue() {
+	h.queueMutex.Lock()
+	defer h.queueMutex.Unlock()
+	h.drainQueue(true)
+}
+
+// Tell the player's spectators about a drawn card. A bomb is shown face down
+// and revealed after s.revealDelay, at the same moment as its outcome reaches
+// the room, so every socket sees it together.
+func (s *Server) notifyCardDrawn(ctx context.Context, game *GameSession, cardType string, remaining int) {
+	username, theme := game.Username, cardTheme(ctx, game)
+	if cardType != engine.ExplodingKitten {
+		s.hub.notifySpectators(username, SpectatorEvent{Type: "card_drawn", Username: username, Card: findCard(theme, cardType), Remaining: remaining})
+		return
+	}
+	s.hub.notifySpectators(username, SpectatorEvent{Type: "card_drawn", Username: username, Remaining: remaining})
+	s.hub.notifySpectatorsAfter(s.revealDelay, username, SpectatorEvent{
+		Type:      "bomb_revealed",
+		Username:  username,
+		Card:      findCard(theme, cardType),
+		Remaining: remaining,
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+// The messages the hub is holding back
+func (ts *testServer) queued() int {
+	ts.hub.queueMutex.Lock()
+	defer ts.hub.queueMutex.Unlock()
+	return len(ts.hub.queue)
+}
+
+// Draw the bomb on top of alice's deck, which she has no Defuse for, and
+// check the player hears the outcome at once and the spectator a face down
+// card
+func (ts *testServer) drawHiddenBomb(spectator *testSocket) {
+	ts.t.Helper()
+	drawn := decodeOK[DrawCardResponse](ts.t, ts.draw("alice"))
+	if drawn.Card.Type != engine.ExplodingKitten || drawn.GameStatus != GameStatusLost {
+		ts.t.Fatalf("draw = %+v, want the bomb and the loss", drawn)
+	}
+	if event := spectator.next("card_drawn"); event["card"] != nil {
+		ts.t.Fatalf("card_drawn = %v, want the bomb face down", event)
+	}
+	if ts.queued() == 0 {
+		ts.t.Fatal("nothing held back after the bomb")
+	}
+}
+
+// The reveal, then the outcome it held back
+func expectReveal(t *testing.T, spectator *testSocket) {
+	t.Helper()
+	revealed := spectator.any()
+	if card, _ := revealed["card"].(map[string]interface{}); revealed["type"] != "bomb_revealed" || card["type"] != engine.ExplodingKitten {
+		t.Fatalf("first message after the delay = %v, want bomb_revealed", revealed)
+	}
+	if over := spectator.any(); over["type"] != "game_over" || over["loser"] != "alice" {
+		t.Fatalf("message after the reveal = %v, want game_over", over)
+	}
+}
+
+func TestBombRevealWaitsForDelay(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", engine.ExplodingKitten, "Cat")
+		spectator := ts.dial("spectate=alice")
+		spectator.next("snapshot")
+		ts.drawHiddenBomb(spectator)
+
+		ts.clock.Advance(ts.revealDelay - time.Millisecond)
+		if ts.queued() == 0 {
+			t.Fatalf("reveal sent before the %v delay", ts.revealDelay)
+		}
+		ts.clock.Advance(time.Millisecond)
+		if n := ts.queued(); n != 0 {
+			t.Fatalf("%d messages still held after the delay", n)
+		}
+		expectReveal(t, spectator)
+	})
+}
+
+func TestShutdownFlushesPendingReveals(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", engine.ExplodingKitten, "Cat")
+		spectator := ts.dial("spectate=alice")
+		spectator.next("snapshot")
+		ts.drawHiddenBomb(spectator)
+
+		// As main does once serve returns, with the clock never reaching the
+		// reveal
+		ts.hub.flushQueue()
+		if n := ts.queued(); n != 0 {
+			t.Fatalf("%d messages still held after the flush", n)
+		}
+		expectReveal(t, spectator)
+	})
+}
+
+// A message queued without delay behind a reveal waits for it, without the
+// queue's timer firing for it in the meantime
+func TestMessageBehindRevealWaitsQuietly(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	var sent []string
+	ts.hub.dispatch("room:ABCD", ts.revealDelay, func() { sent = append(sent, "reveal") })
+	ts.hub.dispatch("room:ABCD", 0, func() { sent = append(sent, "chat") })
+	ts.hub.dispatch("room:WXYZ", 0, func() { sent = append(sent, "other room") })
+
+	advanced := make(chan struct{})
+	go func() {
+		ts.clock.Advance(ts.revealDelay / 2)
+		close(advanced)
+	}()
+	select {
+	case <-advanced:
+	case <-time.After(socketTimeout):
+		t.Fatal("the clock never got past the held message")
+	}
+	if n := ts.clock.pending(); n != 1 || len(sent) != 1 || sent[0] != "other room" {
+		t.Fatalf("sent %q with %d timers set, want only the other room's message", sent, n)
+	}
+
+	ts.clock.Advance(ts.revealDelay / 2)
+	if want := []string{"other room", "reveal", "chat"}; !reflect.DeepEqual(sent, want) || ts.queued() != 0 {
+		t.Fatalf("sent %q, want %q", sent, want)
+	}
+}
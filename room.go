@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"exploding-kitten/engine"
+)
+
+// Room statuses
+const (
+	RoomWaiting  = "waiting"
+	RoomActive   = "active"
+	RoomFinished = "finished"
+)
+
+// Players a room can seat. A room seats the minimum unless its creator
+// asks for more.
+const (
+	minRoomSize = 2
+	maxRoomSize = 5
+)
+
+// Prefix distinguishing room game IDs from solo games, whose ID is the
+// player's username (usernames can't contain ':')
+const roomGameIDPrefix = "room:"
+
+// A multiplayer room. Players share one deck and take turns drawing.
+type Room struct {
+	Code    string   `json:"code"`
+	Players []string `json:"players"`
+	Turn    string   `json:"turn"`
+	Status  string   `json:"status"`
+	// Set when one of the players is a server-side bot
+	BotDifficulty string `json:"botDifficulty,omitempty"`
+	// Seconds a player has to move before a draw is forced on them
+	TurnTimeout int `json:"turnTimeout"`
+	// Bumped by every move so a move and a timeout can't both take a turn
+	TurnVersion int64 `json:"turnVersion"`
+	// Players the room seats; the game starts once they have all joined
+	Size int `json:"size"`
+	// Players knocked out so far, first out first. They stay in Players and
+	// keep following the room until the game is over.
+	Eliminated []string `json:"eliminated,omitempty"`
+	// Set while a player has the game paused; see pauseRoom
+	Pause *RoomPause `json:"pause,omitempty"`
+	// How long the game has been paused so far, not counting a pause still
+	// going on
+	PausedMs int64 `json:"pausedMs,omitempty"`
+	// The ID of the tournament the room seats a match of
+	Tournament string `json:"tournament,omitempty"`
+	// Cards the room plays without
+	DisabledCards []string `json:"disabledCards,omitempty"`
+	// RoomCoop when the players win or lose together; "" is versus
+	Mode string `json:"mode,omitempty"`
+	// The theme the room's cards are shown in; "" is classic
+	Theme string `json:"theme,omitempty"`
+}
+
+type RoomRequest struct {
+	Username string `json:"username"`
+	// A room code, or the token of an invite to the room
+	Code string `json:"code"`
+}
+
+func roomFromHash(code string, fields map[string]string) *Room {
+	room := &Room{
+		Code:   code,
+		Turn:   fields["turn"],
+		Status: fields["status"],
+
+		BotDifficulty: fields["botDifficulty"],
+		Tournament:    fields["tournament"],
+		Mode:          fields["mode"],
+		Theme:         fields["theme"],
+	}
+	room.TurnTimeout, _ = strconv.Atoi(fields["turnTimeout"])
+	room.TurnVersion, _ = strconv.ParseInt(fields["turnVersion"], 10, 64)
+	if room.Size, _ = strconv.Atoi(fields["size"]); room.Size == 0 {
+		// Rooms opened before sizes were configurable
+		room.Size = minRoomSize
+	}
+	if fields["players"] != "" {
+		room.Players = strings.Split(fields["players"], ",")
+	}
+	if fields["eliminated"] != "" {
+		room.Eliminated = strings.Split(fields["eliminated"], ",")
+	}
+	if fields["disabledCards"] != "" {
+		room.DisabledCards = strings.Split(fields["disabledCards"], ",")
+	}
+	room.Pause = roomPauseFromHash(fields)
+	room.PausedMs, _ = strconv.ParseInt(fields["pausedMs"], 10, 64)
+	return room
+}
+
+// The game ID of the room's shared deck
+func (r *Room) gameID() string {
+	return roomGameIDPrefix + r.Code
+}
+
+func (r *Room) hasPlayer(username string) bool {
+	for _, player := range r.Players {
+		if player == username {
+			return true
+		}
+	}
+	return false
+}
+
+// The players in turn order, starting from first
+func (r *Room) turnOrder(first string) []string {
+	order := make([]string, 0, len(r.Players))
+	for i, player := range r.Players {
+		if player == first {
+			order = append(order, r.Players[i:]...)
+			return append(order, r.Players[:i]...)
+		}
+	}
+	return append(order, r.Players...)
+}
+
+func (r *Room) isEliminated(username string) bool {
+	for _, player := range r.Eliminated {
+		if player == username {
+			return true
+		}
+	}
+	return false
+}
+
+// The players still in the game, in seat order
+func (r *Room) alive() []string {
+	alive := make([]string, 0, len(r.Players))
+	for _, player := range r.Players {
+		if !r.isEliminated(player) {
+			alive = append(alive, player)
+		}
+	}
+	return alive
+}
+
+// The player seated after username who is still in the game, or "" if
+// nobody else is. In a two-player room that is the opponent. Turns, Skip,
+// Favor and pairs all pass to or target this player.
+func (r *Room) nextAlive(username string) string {
+	seat := 0
+	for i, player := range r.Players {
+		if player == username {
+			seat = i
+		}
+	}
+	for i := 1; i <= len(r.Players); i++ {
+		player := r.Players[(seat+i)%len(r.Players)]
+		if player != username && !r.isEliminated(player) {
+			return player
+		}
+	}
+	return ""
+}
+
+// Extract the room code from a game ID, if it belongs to a room
+func roomCodeFromGameID(gameID string) (string, bool) {
+	if !strings.HasPrefix(gameID, roomGameIDPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(gameID, roomGameIDPrefix), true
+}
+
+// Characters used in room codes, without look-alikes such as 0/O and 1/I
+const roomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+func newRoomCode() string {
+	code := make([]byte, 6)
+	for i := range code {
+		code[i] = roomCodeAlphabet[rand.Intn(len(roomCodeAlphabet))]
+	}
+	return string(code)
+}
+
+type CreateRoomRequest struct {
+	Username string `json:"username"`
+	// Players the room seats, 2 to 5; defaults to 2
+	Size int `json:"size"`
+	// Seat a server-side bot as the second player of a two-player room and
+	// start right away
+	VsBot      bool   `json:"vsBot"`
+	Difficulty string `json:"difficulty"`
+	// Seconds each player has to move; defaults to 30
+	TurnTimeout int `json:"turnTimeout"`
+	// "balanced" deals the player going second an extra Defuse; defaults to
+	// "none"
+	BalanceMode string `json:"balanceMode"`
+	// Cards to leave out of the deck, e.g. ["Skip"]; the Exploding Kitten
+	// and Defuse can't be
+	DisabledCards []string `json:"disabledCards"`
+	// "coop" for two players who share the deck's fate, winning or losing
+	// together; defaults to "versus"
+	Mode string `json:"mode"`
+	// The theme every player sees the cards in, e.g. "space"; defaults to
+	// "classic"
+	Theme string `json:"theme"`
+}
+
+// Create room route
+func (s *Server) createRoom(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req CreateRoomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+	if req.TurnTimeout == 0 {
+		req.TurnTimeout = s.turnTimeout
+	}
+	if req.TurnTimeout < minTurnTimeout || req.TurnTimeout > maxTurnTimeout {
+		abortWithError(c, errInvalidRequest(fmt.Sprintf("turnTimeout must be between %d and %d seconds", minTurnTimeout, maxTurnTimeout)))
+		return
+	}
+	if req.Size == 0 {
+		req.Size = minRoomSize
+	}
+	if req.Size < minRoomSize || req.Size > maxRoomSize {
+		abortWithError(c, errInvalidRequest(fmt.Sprintf("size must be between %d and %d players", minRoomSize, maxRoomSize)))
+		return
+	}
+	if req.VsBot && req.Size != minRoomSize {
+		abortWithError(c, errInvalidRequest("Bot games are for two players"))
+		return
+	}
+	if req.BalanceMode == "" {
+		req.BalanceMode = BalanceNone
+	}
+	if req.BalanceMode != BalanceNone && req.BalanceMode != BalanceBalanced {
+		abortWithError(c, errInvalidRequest(`balanceMode must be "none" or "balanced"`))
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = RoomVersus
+	}
+	if req.Mode != RoomVersus && req.Mode != RoomCoop {
+		abortWithError(c, errInvalidRequest(`mode must be "versus" or "coop"`))
+		return
+	}
+	if req.Mode == RoomCoop && (req.Size != minRoomSize || req.VsBot) {
+		abortWithError(c, errInvalidRequest("Co-op games are for two players"))
+		return
+	}
+	if req.Theme == "" {
+		req.Theme = ThemeClassic
+	}
+	if !knownTheme(req.Theme) {
+		abortWithError(c, errInvalidRequest(fmt.Sprintf("Unknown theme %q", req.Theme)))
+		return
+	}
+	disabled, apiErr := validateDisabledCards(req.DisabledCards)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if req.VsBot {
+		if req.Difficulty == "" {
+			req.Difficulty = BotBasic
+		}
+		if !validBotDifficulty(req.Difficulty) {
+			abortWithError(c, errInvalidRequest(`difficulty must be "random" or "basic"`))
+			return
+		}
+	}
+
+	code, err := s.openRoom(ctx, req.Username, req.Size, req.TurnTimeout, req.BalanceMode, req.Mode, req.Theme)
+	if err != nil {
+		abortWithError(c, errStoreUnavailable("Error creating room"))
+		return
+	}
+	if code == "" {
+		abortWithError(c, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "Could not allocate a room code"))
+		return
+	}
+
+	if len(disabled) > 0 {
+		if err := s.store.SetDisabledCards(ctx, code, disabled); err != nil {
+			log.Printf("Error saving disabled cards of room %s: %v", code, err)
+			abortWithError(c, errStoreUnavailable("Error creating room"))
+			return
+		}
+	}
+
+	log.Printf("User %s created room %s", req.Username, code)
+	if req.VsBot {
+		room, err := s.startBotGame(ctx, code, req.Difficulty)
+		if err != nil {
+			log.Printf("Error starting bot game in room %s: %v", code, err)
+			abortWithError(c, errStoreUnavailable("Error starting room game"))
+			return
+		}
+		c.JSON(http.StatusOK, RoomResponse{
+			Message: "Room created against a bot",
+			Code:    code,
+			GameID:  room.gameID(),
+			Room:    room,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, RoomResponse{
+		Message: "Room created",
+		Code:    code,
+		GameID:  roomGameIDPrefix + code,
+	})
+}
+
+// Create a waiting room for size players owned by the player under a fresh
+// code, played as mode with the cards shown in theme. Returns "" if every
+// code tried was taken.
+func (s *Server) openRoom(ctx context.Context, owner string, size, turnTimeout int, balanceMode, mode, theme string) (string, error) {
+	// Retry on the unlikely event of a code collision
+	for i := 0; i < 5; i++ {
+		code := newRoomCode()
+		created, err := s.store.CreateRoom(ctx, code, owner)
+		if err != nil {
+			log.Printf("Error creating room for user %s: %v", owner, err)
+			return "", err
+		}
+		if !created {
+			continue
+		}
+		if err := s.store.UpdateRoom(ctx, &Room{Code: code, Status: RoomWaiting, TurnTimeout: turnTimeout, Size: size, Mode: mode, Theme: theme}); err != nil {
+			log.Printf("Error saving settings of room %s: %v", code, err)
+			return "", err
+		}
+		if err := s.store.SetBalanceMode(ctx, code, balanceMode); err != nil {
+			log.Printf("Error saving balance mode of room %s: %v", code, err)
+			return "", err
+		}
+		return code, nil
+	}
+	return "", nil
+}
+
+// Seat the bot in a freshly created room and start the game
+func (s *Server) startBotGame(ctx context.Context, code string, difficulty string) (*Room, error) {
+	room, err := s.store.JoinRoom(ctx, code, botName(code))
+	if err != nil {
+		return nil, err
+	}
+	room.BotDifficulty = difficulty
+	if err := s.startRoomGame(ctx, room); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// Join room route. The game starts as soon as the room is full.
+func (s *Server) joinRoom(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req RoomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+	code := strings.ToUpper(req.Code)
+	if isInviteToken(req.Code) {
+		var apiErr *APIError
+		code, apiErr = s.redeemInvite(ctx, req.Code)
+		if apiErr != nil {
+			abortWithError(c, apiErr)
+			return
+		}
+	}
+
+	room, err := s.store.JoinRoom(ctx, code, req.Username)
+	switch {
+	case errors.Is(err, errNoSuchRoom):
+		abortWithError(c, errRoomNotFound())
+		return
+	case errors.Is(err, errRoomIsFull):
+		abortWithError(c, errRoomFull())
+		return
+	case err != nil:
+		log.Printf("Error joining room %s for user %s: %v", code, req.Username, err)
+		abortWithError(c, errStoreUnavailable("Error joining room"))
+		return
+	}
+
+	if room.Status == RoomWaiting && len(room.Players) == room.Size {
+		if err := s.startRoomGame(ctx, room); err != nil {
+			log.Printf("Error starting game in room %s: %v", code, err)
+			abortWithError(c, errStoreUnavailable("Error starting room game"))
+			return
+		}
+	}
+
+	log.Printf("User %s joined room %s", req.Username, code)
+	c.JSON(http.StatusOK, RoomResponse{
+		Message: "Joined room",
+		GameID:  room.gameID(),
+		Room:    room,
+	})
+}
+
+// Check that the player may act in the room right now
+func (s *Server) checkTurn(room *Room, username string) *APIError {
+	switch {
+	case room.Status == RoomWaiting:
+		return errRoomNotReady()
+	case room.Status == RoomFinished:
+		return errGameFinished()
+	case room.isEliminated(username):
+		return errPlayerEliminated()
+	case room.Turn != username:
+		return errNotYourTurn()
+	case s.hasPendingAction(room.Code):
+		return errActionPending()
+	}
+	return nil
+}
+
+// Deal the shared deck and the opening hands, and hand the first turn to a
+// player drawn from the deck's seeded source, so the draw can be checked
+// along with the shuffle once the seed is revealed
+func (s *Server) startRoomGame(ctx context.Context, room *Room) error {
+	rng, err := s.dealSeededDeck(ctx, room.gameID(), roomDeckFor(len(room.Players), room.DisabledCards))
+	if err != nil {
+		return err
+	}
+	if err := s.store.MarkGameStarted(ctx, room.gameID(), s.clock.Now()); err != nil {
+		return err
+	}
+	s.trackActiveGame(ctx, room)
+	state, err := s.store.GetRoomState(ctx, room.Code)
+	if err != nil {
+		return err
+	}
+
+	order := room.turnOrder(room.Players[rng.Intn(len(room.Players))])
+	if err := s.saveGameRNG(ctx, room.gameID(), rng); err != nil {
+		return err
+	}
+	for seat, hand := range engine.OpeningHands(len(order), state.BalanceMode) {
+		if err := s.store.DealHand(ctx, order[seat], hand); err != nil {
+			return err
+		}
+	}
+	if err := s.store.SetFirstPlayer(ctx, room.Code, order[0]); err != nil {
+		return err
+	}
+
+	room.Turn = order[0]
+	room.Status = RoomActive
+	if err := s.store.UpdateRoom(ctx, room); err != nil {
+		return err
+	}
+	s.startTurnTimer(room)
+	if isBot(room.Turn) {
+		s.scheduleBotTurn(room.Code)
+	}
+
+	gamesStartedTotal.Inc()
+	log.Printf("Game started in room %s with turn order %v", room.Code, order)
+	s.hub.broadcastRoom(room.Code, RoomEvent{Type: "game_started", Username: room.Turn, Order: order, BalanceMode: state.BalanceMode})
+	return nil
+}
+
+// Events pushed to the sockets following a room
+type RoomEvent struct {
+	Type      string     `json:"type"`
+	Username  string     `json:"username"`
+	Card      *Card      `json:"card,omitempty"`
+	Message   string     `json:"message,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// When a "chat" message was sent; its text is Message
+	SentAt *time.Time `json:"sentAt,omitempty"`
+	// Set on "game_started": the turn order, from Username, who goes first,
+	// and how the room makes up for it
+	Order       []string `json:"order,omitempty"`
+	BalanceMode string   `json:"balanceMode,omitempty"`
+	// Set on "card_drawn": where the card went and what it changed, as the
+	// drawing player's DrawCardResponse says
+	Disposition string       `json:"disposition,omitempty"`
+	Effects     []DrawEffect `json:"effects,omitempty"`
+	// Set on "game_over" when the game had a winner: who won and lost, and
+	// both players' new totals
+	Winner string                 `json:"winner,omitempty"`
+	Loser  string                 `json:"loser,omitempty"`
+	Stats  map[string]PlayerStats `json:"stats,omitempty"`
+	// Set on "game_over" of a co-op game: the players share the result, and
+	// Partner shares Winner's win, or Loser's loss if there is no winner
+	Shared  bool   `json:"shared,omitempty"`
+	Partner string `json:"partner,omitempty"`
+	// Set on "game_over": how the game went for each of its human players
+	Summaries map[string]*GameSummary `json:"summaries,omitempty"`
+	// Set on "player_eliminated" and "game_over": who is out so far, first
+	// out first
+	Eliminated []string `json:"eliminated,omitempty"`
+	// Set on "game_over" of a room of two human players: their record against
+	// each other, this game included, with Winner on side A
+	HeadToHead *HeadToHeadRecord `json:"headToHead,omitempty"`
+	// Set on "deck_changed": how many cards the deck holds now
+	Remaining int `json:"remaining,omitempty"`
+	// Position in the room's event stream; see GET /ws?lastSeq=
+	Seq int64 `json:"seq,omitempty"`
+	// Set on "game_over": the same for every copy of it, including one the
+	// outbox sends again after an instance died, so a client drops repeats
+	EventID string `json:"eventId,omitempty"`
+}
+
+// Serve a WebSocket connection following a room's game events. A reconnecting
+// socket passes the last seq it saw to have the events it missed replayed.
+// Every socket starts with a snapshot of the room's state.
+func (s *Server) serveRoomSocket(ctx context.Context, conn *websocket.Conn, code string, lastSeq int64) {
+	defer func() {
+		s.hub.unregisterRoom(code, conn)
+		s.hub.close(conn)
+	}()
+
+	if lastSeq == noLastSeq {
+		s.hub.registerRoom(code, conn)
+	} else if _, err := s.hub.resumeRoom(code, conn, lastSeq); err != nil {
+		log.Println("Error replaying room events:", err)
+		return
+	}
+
+	log.Printf("WebSocket connection established for room: %s", code)
+
+	snapshot, err := s.roomSnapshot(ctx, code)
+	if err != nil {
+		log.Printf("Error building snapshot of room %s: %v", code, err)
+	} else if snapshot != nil {
+		if err := s.hub.send(conn, snapshot); err != nil {
+			log.Println("Error sending room snapshot:", err)
+			return
+		}
+	}
+
+	go s.keepAlive(conn)
+
+	err = s.readCommands(ctx, conn, SubscribeRequest{Room: code})
+	log.Println("Room connection closed:", err)
+}
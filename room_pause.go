@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long a room's game may spend paused in all, unless ROOM_MAX_PAUSE
+// says otherwise. A pause that uses up what is left ends by itself, so
+// nobody can hold a game hostage.
+const defaultMaxRoomPause = 5 * time.Minute
+
+// A pause of a room's game. Its turn clock is stopped with TurnLeftMs left
+// and every move is refused with a 423 until a player resumes it or
+// ResumesAt comes.
+type RoomPause struct {
+	// The player who paused the game
+	By         string    `json:"by"`
+	At         time.Time `json:"at"`
+	TurnLeftMs int64     `json:"turnLeftMs"`
+	ResumesAt  time.Time `json:"resumesAt"`
+}
+
+type PauseRequest struct {
+	Username string `json:"username"`
+}
+
+// Pause and resume routes
+type PauseResponse struct {
+	Message string `json:"message"`
+	Room    *Room  `json:"room"`
+	// How much more the game may be paused for
+	PauseLeftMs int64 `json:"pauseLeftMs"`
+}
+
+// The pause recorded in a room hash, nil if there is none
+func roomPauseFromHash(fields map[string]string) *RoomPause {
+	if fields["pausedBy"] == "" {
+		return nil
+	}
+	pause := &RoomPause{By: fields["pausedBy"]}
+	if ms, err := strconv.ParseInt(fields["pausedAt"], 10, 64); err == nil {
+		pause.At = time.UnixMilli(ms)
+	}
+	pause.TurnLeftMs, _ = strconv.ParseInt(fields["pauseTurnLeft"], 10, 64)
+	if ms, err := strconv.ParseInt(fields["pauseResumesAt"], 10, 64); err == nil {
+		pause.ResumesAt = time.UnixMilli(ms)
+	}
+	return pause
+}
+
+// How much more the room's game may be paused for, counting down during a
+// pause
+func (s *Server) pauseLeft(room *Room) time.Duration {
+	if room.Pause != nil {
+		if left := room.Pause.ResumesAt.Sub(s.clock.Now()); left > 0 {
+			return left
+		}
+		return 0
+	}
+	if left := s.maxRoomPause - time.Duration(room.PausedMs)*time.Millisecond; left > 0 {
+		return left
+	}
+	return 0
+}
+
+// Refuse moves in a room whose game is paused
+func checkNotPaused(game *GameSession) *APIError {
+	if game.Room != nil && game.Room.Pause != nil {
+		return errRoomPaused(game.Room.Pause)
+	}
+	return nil
+}
+
+// The room a player asks to pause or resume, which must have a game in
+// progress
+func (s *Server) pauseTarget(c *gin.Context) (*Room, string, *APIError) {
+	var req PauseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		return nil, "", errInvalidRequest("Invalid request")
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		return nil, "", errInvalidUsername()
+	}
+	code := strings.ToUpper(c.Param("code"))
+	room, err := s.store.GetRoom(c.Request.Context(), code)
+	if err != nil {
+		log.Printf("Error retrieving room %s: %v", code, err)
+		return nil, "", errStoreUnavailable("Error retrieving room")
+	}
+	if room == nil {
+		return nil, "", errRoomNotFound()
+	}
+	if !room.hasPlayer(req.Username) {
+		return nil, "", errNotInRoom()
+	}
+	if room.Status != RoomActive {
+		return nil, "", errPauseConflict("Only a game in progress can be paused or resumed")
+	}
+	return room, req.Username, nil
+}
+
+// Pause route: stop the room's game, e.g. while a player answers the door.
+// The turn clock keeps what was left of the turn for the resume.
+func (s *Server) pauseRoom(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	room, username, apiErr := s.pauseTarget(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if room.Pause != nil {
+		abortWithError(c, errPauseConflict("The game is already paused"))
+		return
+	}
+	left := s.pauseLeft(room)
+	if left <= 0 {
+		abortWithError(c, errPauseConflict("This game has no pause time left"))
+		return
+	}
+
+	state, err := s.store.GetRoomState(ctx, room.Code)
+	if err != nil {
+		log.Printf("Error retrieving state of room %s: %v", room.Code, err)
+		abortWithError(c, errStoreUnavailable("Error retrieving room"))
+		return
+	}
+	now := s.clock.Now()
+	turnLeft := s.roomTurnTimeout(room)
+	if !state.TurnDeadline.IsZero() {
+		if turnLeft = state.TurnDeadline.Sub(now); turnLeft < 0 {
+			turnLeft = 0
+		}
+	}
+	pause := RoomPause{By: username, At: now, TurnLeftMs: turnLeft.Milliseconds(), ResumesAt: now.Add(left)}
+	paused, err := s.store.PauseRoom(ctx, room.Code, pause)
+	if err != nil {
+		log.Printf("Error pausing room %s: %v", room.Code, err)
+		abortWithError(c, errStoreUnavailable("Error pausing game"))
+		return
+	}
+	if !paused {
+		abortWithError(c, errPauseConflict("The game is already paused"))
+		return
+	}
+	room.Pause = &pause
+
+	s.stopTurnTimer(room.Code)
+	s.startPauseTimer(room.Code, left)
+
+	log.Printf("User %s paused room %s for at most %v", username, room.Code, left)
+	s.hub.broadcastRoom(room.Code, RoomEvent{
+		Type:      "game_paused",
+		Username:  username,
+		Message:   fmt.Sprintf("%s paused the game", username),
+		ExpiresAt: &pause.ResumesAt,
+	})
+
+	c.JSON(http.StatusOK, PauseResponse{Message: "Game paused", Room: room, PauseLeftMs: left.Milliseconds()})
+}
+
+// Resume route: carry on with the room's paused game
+func (s *Server) resumeRoom(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	room, username, apiErr := s.pauseTarget(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if room.Pause == nil {
+		abortWithError(c, errPauseConflict("The game isn't paused"))
+		return
+	}
+	resumed, err := s.resumeRoomGame(ctx, room.Code, username)
+	if err != nil {
+		abortWithError(c, errStoreUnavailable("Error resuming game"))
+		return
+	}
+	if resumed == nil {
+		abortWithError(c, errPauseConflict("The game isn't paused"))
+		return
+	}
+
+	c.JSON(http.StatusOK, PauseResponse{Message: "Game resumed", Room: resumed, PauseLeftMs: s.pauseLeft(resumed).Milliseconds()})
+}
+
+// End the room's pause, for the player who asked or, with by "", because
+// the pause ran out, and restart its turn clock with the time the turn had
+// left. Returns the room, nil if it wasn't paused.
+func (s *Server) resumeRoomGame(ctx context.Context, code, by string) (*Room, error) {
+	pause, err := s.store.ResumeRoom(ctx, code, s.clock.Now())
+	if err != nil {
+		log.Printf("Error resuming room %s: %v", code, err)
+		return nil, err
+	}
+	if pause == nil {
+		return nil, nil
+	}
+	s.stopPauseTimer(code)
+
+	room, err := s.store.GetRoom(ctx, code)
+	if err != nil || room == nil {
+		log.Printf("Error reloading room %s after its pause: %v", code, err)
+		return nil, err
+	}
+	s.resumeTurnTimer(room, s.clock.Now().Add(time.Duration(pause.TurnLeftMs)*time.Millisecond))
+
+	message := fmt.Sprintf("%s resumed the game", by)
+	if by == "" {
+		log.Printf("Pause of room %s ran out", code)
+		message = "The pause ran out and the game resumed"
+	} else {
+		log.Printf("User %s resumed room %s", by, code)
+	}
+	s.hub.broadcastRoom(code, RoomEvent{Type: "game_resumed", Username: by, Message: message})
+
+	if isBot(room.Turn) {
+		s.scheduleBotTurn(code)
+	}
+	return room, nil
+}
+
+// End the room's pause once it has lasted d
+func (s *Server) startPauseTimer(code string, d time.Duration) {
+	s.timerMutex.Lock()
+	defer s.timerMutex.Unlock()
+
+	if timer := s.pauseTimers[code]; timer != nil {
+		timer.Stop()
+	}
+	s.pauseTimers[code] = s.clock.AfterFunc(d, func() {
+		if _, err := s.resumeRoomGame(context.Background(), code, ""); err != nil {
+			log.Printf("Error ending the pause of room %s: %v", code, err)
+		}
+	})
+}
+
+func (s *Server) stopPauseTimer(code string) {
+	s.timerMutex.Lock()
+	defer s.timerMutex.Unlock()
+
+	if timer := s.pauseTimers[code]; timer != nil {
+		timer.Stop()
+		delete(s.pauseTimers, code)
+	}
+}
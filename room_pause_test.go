@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+func (ts *testServer) pause(code, username string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	return ts.post("/rooms/"+code+"/pause", PauseRequest{Username: username})
+}
+
+func (ts *testServer) resume(code, username string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	return ts.post("/rooms/"+code+"/resume", PauseRequest{Username: username})
+}
+
+func TestPauseFreezesTurnClock(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		ts.setDeck(room.gameID(), "Cat", "Skip", "Cat", engine.ExplodingKitten)
+		ts.deal("alice")
+		ts.deal("bob")
+		socket := ts.dial("room=" + room.Code)
+		socket.next("snapshot")
+		stalled, other := room.Turn, room.nextAlive(room.Turn)
+		timeout := ts.roomTurnTimeout(room)
+
+		ts.clock.Advance(timeout / 3)
+		left := timeout - timeout/3
+		paused := decodeOK[PauseResponse](t, ts.pause(room.Code, other))
+		if paused.Room.Pause == nil || paused.Room.Pause.By != other || paused.Room.Pause.TurnLeftMs != left.Milliseconds() || paused.PauseLeftMs != ts.maxRoomPause.Milliseconds() {
+			t.Fatalf("pause = %+v, pause %+v", paused, paused.Room.Pause)
+		}
+		if event := decodeMessage[RoomEvent](t, socket.next("game_paused")); event.Username != other || event.ExpiresAt == nil || !event.ExpiresAt.Equal(ts.clock.Now().Add(ts.maxRoomPause)) {
+			t.Fatalf("game_paused = %+v", event)
+		}
+
+		// Nothing moves while the game is paused, however long the turn
+		// would have had
+		assertError(t, ts.post("/draw-card", User{Username: stalled, GameID: room.gameID()}), http.StatusLocked, ErrCodeRoomPaused)
+		assertError(t, ts.pause(room.Code, stalled), http.StatusConflict, ErrCodePauseConflict)
+		assertError(t, ts.pause(room.Code, "carol"), http.StatusForbidden, ErrCodeNotInRoom)
+		ts.clock.Advance(timeout)
+		if turn := ts.room(room.Code).Turn; turn != stalled || len(ts.deck(room.gameID())) != 4 {
+			t.Fatalf("turn moved to %q during the pause", turn)
+		}
+		snapshot := decodeMessage[RoomSnapshot](t, ts.dial("room="+room.Code).next("snapshot"))
+		if snapshot.Room.Pause == nil || snapshot.TurnDeadline != nil || snapshot.PauseLeftMs != (ts.maxRoomPause-timeout).Milliseconds() {
+			t.Fatalf("snapshot while paused = %+v, pause %+v", snapshot, snapshot.Room.Pause)
+		}
+
+		resumed := decodeOK[PauseResponse](t, ts.resume(room.Code, stalled))
+		if resumed.Room.Pause != nil || resumed.PauseLeftMs != (ts.maxRoomPause-timeout).Milliseconds() {
+			t.Fatalf("resume = %+v", resumed)
+		}
+		if event := decodeMessage[RoomEvent](t, socket.next("game_resumed")); event.Username != stalled {
+			t.Fatalf("game_resumed = %+v", event)
+		}
+		assertError(t, ts.resume(room.Code, stalled), http.StatusConflict, ErrCodePauseConflict)
+
+		// The turn has exactly what it had left when the game was paused
+		ts.clock.Advance(left - time.Millisecond)
+		if turn := ts.room(room.Code).Turn; turn != stalled {
+			t.Fatalf("turn moved to %q before the rest of the turn ran out", turn)
+		}
+		ts.clock.Advance(time.Millisecond)
+		if event := decodeMessage[RoomEvent](t, socket.next("turn_timeout")); event.Username != stalled {
+			t.Fatalf("turn_timeout = %+v", event)
+		}
+		if turn := ts.room(room.Code).Turn; turn != other {
+			t.Fatalf("turn = %q after the timeout, want %q", turn, other)
+		}
+	})
+}
+
+func TestPauseRunsOutAndResumesItself(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		ts.setDeck(room.gameID(), "Cat", "Skip", "Cat", engine.ExplodingKitten)
+		socket := ts.dial("room=" + room.Code)
+		socket.next("snapshot")
+		timeout := ts.roomTurnTimeout(room)
+
+		decodeOK[PauseResponse](t, ts.pause(room.Code, "alice"))
+		socket.next("game_paused")
+		ts.clock.Advance(ts.maxRoomPause - time.Millisecond)
+		if ts.room(room.Code).Pause == nil {
+			t.Fatal("pause ended before it ran out")
+		}
+		ts.clock.Advance(time.Millisecond)
+		if event := decodeMessage[RoomEvent](t, socket.next("game_resumed")); event.Username != "" {
+			t.Fatalf("game_resumed = %+v, want no player", event)
+		}
+		if ts.room(room.Code).Pause != nil {
+			t.Fatal("still paused after the pause ran out")
+		}
+
+		// The allowance is spent, and the turn carries on with its clock
+		assertError(t, ts.pause(room.Code, "bob"), http.StatusConflict, ErrCodePauseConflict)
+		ts.clock.Advance(timeout)
+		if event := decodeMessage[RoomEvent](t, socket.next("turn_timeout")); event.Username != room.Turn {
+			t.Fatalf("turn_timeout = %+v", event)
+		}
+	})
+}
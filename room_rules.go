@@ -0,0 +1,80 @@
+package main
+
+import "fmt"
+
+// Cards other than bombs a room's deck is dealt with at the least. Cards a
+// room disables are made up for with cat cards up to this many.
+const minRoomDeckCards = 16
+
+// Cards no room can play without
+var requiredCards = map[string]bool{
+	"Exploding Kitten": true,
+	"Defuse":           true,
+}
+
+// The rules a room's game is played by, for clients to hide what it leaves
+// out
+type RoomRules struct {
+	// Cards left out of the deck and refused by /play-card
+	DisabledCards []string `json:"disabledCards"`
+	// How many of each card the deck is dealt with
+	Deck map[string]int `json:"deck"`
+}
+
+// Check the cards a room is asked to play without, returning them without
+// repeats in registry order
+func validateDisabledCards(cards []string) ([]string, *APIError) {
+	disabled := make(map[string]bool, len(cards))
+	for _, card := range cards {
+		if _, ok := lookupCard(card); !ok {
+			return nil, errInvalidRequest(fmt.Sprintf("Unknown card %q", card))
+		}
+		if requiredCards[card] {
+			return nil, errInvalidRequest(fmt.Sprintf("%s can't be disabled", card))
+		}
+		disabled[card] = true
+	}
+	cats := 0
+	for _, count := range roomDeckConfig.Cards {
+		if catCards[count.Type] && !disabled[count.Type] {
+			cats++
+		}
+	}
+	if cats == 0 {
+		return nil, errInvalidRequest("At least one cat card must stay in the deck")
+	}
+
+	var valid []string
+	for _, def := range cardRegistry {
+		if disabled[def.Type] {
+			valid = append(valid, def.Type)
+		}
+	}
+	return valid, nil
+}
+
+func (r *Room) cardDisabled(card string) bool {
+	for _, disabled := range r.DisabledCards {
+		if disabled == card {
+			return true
+		}
+	}
+	return false
+}
+
+// The rules of the room's game
+func (r *Room) rules() RoomRules {
+	rules := RoomRules{DisabledCards: append([]string{}, r.DisabledCards...), Deck: map[string]int{}}
+	for _, count := range roomDeckFor(len(r.Players), r.DisabledCards).Cards {
+		rules.Deck[count.Type] = count.Count
+	}
+	return rules
+}
+
+// Refuse a card the game's room was set up without
+func checkCardEnabled(game *GameSession, card string) *APIError {
+	if game.Room != nil && game.Room.cardDisabled(card) {
+		return errCardDisabled(card)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// Open a room of alice and bob playing without the cards
+func (ts *testServer) openRoomWithout(cards ...string) *Room {
+	ts.t.Helper()
+	created := decodeOK[RoomResponse](ts.t, ts.post("/create-room", CreateRoomRequest{Username: "alice", DisabledCards: cards}))
+	decodeOK[RoomResponse](ts.t, ts.post("/join-room", RoomRequest{Username: "bob", Code: created.Code}))
+	return ts.room(created.Code)
+}
+
+func TestDisabledCardsAreLeftOutAndRefused(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		for _, cards := range [][]string{{"Imploding Kitten"}, {engine.ExplodingKitten}, {engine.Defuse}, {"Cat", "Tacocat", "Rainbow Cat", "Beard Cat"}} {
+			assertError(t, ts.post("/create-room", CreateRoomRequest{Username: "carol", DisabledCards: cards}), http.StatusBadRequest, ErrCodeInvalidRequest)
+		}
+
+		// Given out of order and twice, kept once in registry order
+		room := ts.openRoomWithout("Skip", "Shuffle", "Skip")
+		if !reflect.DeepEqual(room.DisabledCards, []string{"Shuffle", "Skip"}) {
+			t.Fatalf("disabled cards = %v", room.DisabledCards)
+		}
+		counts := countCards(ts.deck(room.gameID()))
+		want := map[string]int{
+			"Cat": 2, "Tacocat": 2, "Rainbow Cat": 2, "Beard Cat": 2, engine.Defuse: 2, "Favor": 2, "Attack": 2, "Nope": 2,
+			"Draw From Bottom": 1, "See the Future": 1, engine.ExplodingKitten: 1,
+		}
+		if !reflect.DeepEqual(counts, want) {
+			t.Fatalf("deck = %v, want %v", counts, want)
+		}
+
+		snapshot := decodeMessage[RoomSnapshot](t, ts.dial("room="+room.Code).next("snapshot"))
+		if !reflect.DeepEqual(snapshot.Rules, RoomRules{DisabledCards: []string{"Shuffle", "Skip"}, Deck: want}) {
+			t.Fatalf("rules = %+v", snapshot.Rules)
+		}
+
+		ts.deal(room.Turn, "Skip", "Shuffle", "Favor")
+		for _, card := range []string{"Skip", "Shuffle"} {
+			assertError(t, ts.play(room.Turn, room.gameID(), card), http.StatusBadRequest, ErrCodeCardDisabled)
+		}
+		if hand := ts.hand(room.Turn); len(hand) != 3 {
+			t.Fatalf("hand after the refused plays = %v", hand)
+		}
+	})
+}
+
+func TestDisabledCardsArePaddedWithCats(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		// Ten cards left besides the bomb, six short of what a deck needs: the
+		// first cat card left makes them up
+		room := ts.openRoomWithout("Cat", "Shuffle", "Skip", "Attack", "Favor", "Nope")
+		counts := countCards(ts.deck(room.gameID()))
+		want := map[string]int{
+			"Tacocat": 8, "Rainbow Cat": 2, "Beard Cat": 2, engine.Defuse: 2,
+			"Draw From Bottom": 1, "See the Future": 1, engine.ExplodingKitten: 1,
+		}
+		if !reflect.DeepEqual(counts, want) {
+			t.Fatalf("deck = %v, want %v", counts, want)
+		}
+		if rules := room.rules(); !reflect.DeepEqual(rules.Deck, want) {
+			t.Fatalf("rules deck = %v", rules.Deck)
+		}
+		// The same every time
+		if again := roomDeckFor(2, room.DisabledCards); !reflect.DeepEqual(again, roomDeckFor(2, room.DisabledCards)) || again.Size != minRoomDeckCards+1 {
+			t.Fatalf("deck config = %+v", again)
+		}
+	})
+}
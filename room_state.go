@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+)
+
+// What a room needs besides its room:{code} hash to pick up where it left off
+// after a restart. It lives in room:{code}:state; the turn itself and its
+// version stay in the room hash, where ClaimTurn updates them.
+type RoomState struct {
+	// When the current turn times out; zero if no clock is running
+	TurnDeadline time.Time     `json:"turnDeadline"`
+	Pending      *PendingState `json:"pending,omitempty"`
+	// Who was drawn to go first, and how the room makes up for it
+	FirstPlayer string `json:"firstPlayer,omitempty"`
+	BalanceMode string `json:"balanceMode,omitempty"`
+}
+
+// A saved pendingAction
+type PendingState struct {
+	Card      string    `json:"card"`
+	Player    string    `json:"player"`
+	LastActor string    `json:"lastActor"`
+	Nopes     int       `json:"nopes"`
+	Deadline  time.Time `json:"deadline"`
+}
+
+// Sent to a room socket when it connects, so a client coming back after a
+// restart or a dropped connection knows whose turn it is and how long is left
+type RoomSnapshot struct {
+	Type         string     `json:"type"`
+	Room         *Room      `json:"room"`
+	TurnDeadline *time.Time `json:"turnDeadline,omitempty"`
+	// The card waiting out its Nope window, and when the window closes
+	Pending *PendingState `json:"pending,omitempty"`
+	// The room's last chat messages, oldest first
+	Chat []ChatMessage `json:"chat"`
+	// The turn order, from the player drawn to go first, once the game has
+	// started
+	Order       []string `json:"order,omitempty"`
+	BalanceMode string   `json:"balanceMode,omitempty"`
+	// Display name and avatar of every player, by username
+	Profiles map[string]Profile `json:"profiles"`
+	// How much more the game may be paused for; Room.Pause says whether it
+	// is paused now
+	PauseLeftMs int64 `json:"pauseLeftMs"`
+	// The cards the game is played with and without
+	Rules RoomRules `json:"rules"`
+}
+
+// Fields of the state hash
+func (p *PendingState) hashFields() []interface{} {
+	return []interface{}{
+		"pendingCard", p.Card,
+		"pendingPlayer", p.Player,
+		"pendingLastActor", p.LastActor,
+		"pendingNopes", p.Nopes,
+		"pendingDeadline", p.Deadline.UnixMilli(),
+	}
+}
+
+var pendingStateFields = []string{"pendingCard", "pendingPlayer", "pendingLastActor", "pendingNopes", "pendingDeadline"}
+
+func roomStateFromHash(fields map[string]string) *RoomState {
+	state := &RoomState{FirstPlayer: fields["firstPlayer"], BalanceMode: fields["balanceMode"]}
+	if ms, err := strconv.ParseInt(fields["turnDeadline"], 10, 64); err == nil {
+		state.TurnDeadline = time.UnixMilli(ms)
+	}
+	if fields["pendingCard"] != "" {
+		nopes, _ := strconv.Atoi(fields["pendingNopes"])
+		deadline, _ := strconv.ParseInt(fields["pendingDeadline"], 10, 64)
+		state.Pending = &PendingState{
+			Card:      fields["pendingCard"],
+			Player:    fields["pendingPlayer"],
+			LastActor: fields["pendingLastActor"],
+			Nopes:     nopes,
+			Deadline:  time.UnixMilli(deadline),
+		}
+	}
+	return state
+}
+
+func (p *pendingAction) state() *PendingState {
+	return &PendingState{
+		Card:      p.card,
+		Player:    p.game.Username,
+		LastActor: p.lastActor,
+		Nopes:     p.nopes,
+		Deadline:  p.deadline,
+	}
+}
+
+// Save the room's pending action, or clear it when action is nil. Failures
+// are only logged: the game goes on, it just can't be recovered as well.
+func (s *Server) savePendingAction(code string, action *pendingAction) {
+	var state *PendingState
+	if action != nil {
+		state = action.state()
+	}
+	if err := s.store.SetPendingAction(context.Background(), code, state); err != nil {
+		log.Printf("Error saving pending action of room %s: %v", code, err)
+	}
+}
+
+// Rebuild the turn clocks and Nope windows of rooms left active by a previous
+// run from their saved deadlines. A turn whose deadline passed while the
+// server was down times out right away.
+func (s *Server) restoreRooms(ctx context.Context) error {
+	rooms, err := s.store.ActiveRooms(ctx)
+	if err != nil {
+		return err
+	}
+	now := s.clock.Now()
+	for _, room := range rooms {
+		// The turn clock may play for a player before any of them loads the game
+		if apiErr := s.upgradeGame(ctx, &GameSession{ID: room.gameID(), Room: room}); apiErr != nil {
+			log.Printf("Not restoring room %s: %v", room.Code, apiErr)
+			continue
+		}
+		state, err := s.store.GetRoomState(ctx, room.Code)
+		if err != nil {
+			return err
+		}
+
+		if state.Pending != nil {
+			s.restorePendingAction(room, state.Pending, now)
+		}
+		s.restorePendingBomb(ctx, room.gameID())
+
+		// A paused game keeps its clock stopped for the rest of the pause
+		if room.Pause != nil {
+			s.startPauseTimer(room.Code, room.Pause.ResumesAt.Sub(now))
+			continue
+		}
+
+		switch {
+		case state.TurnDeadline.IsZero():
+			// Saved before deadlines were kept: give the turn a fresh clock
+			s.startTurnTimer(room)
+		case state.TurnDeadline.After(now):
+			s.resumeTurnTimer(room, state.TurnDeadline)
+		default:
+			log.Printf("Turn of user %s in room %s ran out while the server was down", room.Turn, room.Code)
+			s.turnTimedOut(room.Code, room.TurnVersion)
+		}
+		if isBot(room.Turn) {
+			s.scheduleBotTurn(room.Code)
+		}
+	}
+	log.Printf("Restored %d active rooms", len(rooms))
+	return nil
+}
+
+// Put a saved action back on hold for the rest of its Nope window, or
+// resolve it now if the window has closed
+func (s *Server) restorePendingAction(room *Room, saved *PendingState, now time.Time) {
+	playable, ok := lookupCard(saved.Card)
+	if !ok || playable.Play == nil {
+		log.Printf("Dropping unknown pending card %q in room %s", saved.Card, room.Code)
+		s.savePendingAction(room.Code, nil)
+		return
+	}
+
+	s.pendingMutex.Lock()
+	action := &pendingAction{
+		game:      &GameSession{ID: room.gameID(), Username: saved.Player, Room: room},
+		card:      saved.Card,
+		playable:  playable,
+		lastActor: saved.LastActor,
+		nopes:     saved.Nopes,
+		deadline:  saved.Deadline,
+	}
+	code := room.Code
+	action.timer = s.clock.AfterFunc(saved.Deadline.Sub(now), func() { s.resolveAction(code, action) })
+	s.pending[code] = action
+	s.pendingMutex.Unlock()
+}
+
+// The room's state as sent to a socket when it connects
+func (s *Server) roomSnapshot(ctx context.Context, code string) (*RoomSnapshot, error) {
+	room, err := s.store.GetRoom(ctx, code)
+	if err != nil || room == nil {
+		return nil, err
+	}
+	state, err := s.store.GetRoomState(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := s.roomChat(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &RoomSnapshot{
+		Type:        "snapshot",
+		Room:        room,
+		Pending:     state.Pending,
+		Chat:        chat,
+		BalanceMode: state.BalanceMode,
+		Profiles:    s.profilesOf(ctx, room.Players),
+		PauseLeftMs: s.pauseLeft(room).Milliseconds(),
+		Rules:       room.rules(),
+	}
+	if state.FirstPlayer != "" {
+		snapshot.Order = room.turnOrder(state.FirstPlayer)
+	}
+	// A paused game's turn has Room.Pause.TurnLeftMs left instead
+	if !state.TurnDeadline.IsZero() && room.Status == RoomActive && room.Pause == nil {
+		snapshot.TurnDeadline = &state.TurnDeadline
+	}
+	return snapshot, nil
+}
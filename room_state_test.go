@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+// A fresh server against the same store, its clock where ts's is, as after a
+// restart; it has restored the rooms ts left active
+func (ts *testServer) restart(t *testing.T) *testServer {
+	t.Helper()
+	fresh := newTestServer(t, ts.store)
+	fresh.clock.Advance(ts.clock.Now().Sub(fresh.clock.Now()))
+	if err := fresh.restoreRooms(context.Background()); err != nil {
+		t.Fatalf("restoreRooms: %v", err)
+	}
+	return fresh
+}
+
+func TestRestoredRoomKeepsTurnAndClock(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		ts.setDeck(room.gameID(), "Cat", "Skip", "Cat", engine.ExplodingKitten)
+		ts.deal("alice")
+		ts.deal("bob")
+		stalled, next := room.Turn, room.nextAlive(room.Turn)
+		timeout := ts.roomTurnTimeout(room)
+		ts.clock.Advance(timeout / 4)
+
+		fresh := ts.restart(t)
+		restored := fresh.room(room.Code)
+		if restored.Turn != stalled || restored.TurnVersion != room.TurnVersion {
+			t.Fatalf("restored turn %q at version %d, want %q at %d", restored.Turn, restored.TurnVersion, stalled, room.TurnVersion)
+		}
+		socket := fresh.dial("room=" + room.Code)
+		snapshot := decodeMessage[RoomSnapshot](t, socket.next("snapshot"))
+		if want := testEpoch.Add(timeout); snapshot.TurnDeadline == nil || !snapshot.TurnDeadline.Equal(want) {
+			t.Fatalf("snapshot deadline = %v, want %v", snapshot.TurnDeadline, want)
+		}
+
+		// The clock runs out when it would have without the restart
+		fresh.clock.Advance(timeout*3/4 - time.Millisecond)
+		if turn := fresh.room(room.Code).Turn; turn != stalled {
+			t.Fatalf("turn moved to %q early", turn)
+		}
+		fresh.clock.Advance(time.Millisecond)
+		if turn := fresh.room(room.Code).Turn; turn != next {
+			t.Fatalf("turn = %q after the restored clock ran out, want %q", turn, next)
+		}
+		if hand := fresh.hand(stalled); len(hand) != 1 || hand[0] != "Cat" {
+			t.Fatalf("%s's hand = %v after the forced draw", stalled, hand)
+		}
+	})
+}
+
+func TestRestoreTimesOutTurnThatRanOutWhileDown(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		ts.setDeck(room.gameID(), "Cat", "Skip", "Cat", engine.ExplodingKitten)
+		ts.deal("alice")
+		ts.deal("bob")
+		stalled, next := room.Turn, room.nextAlive(room.Turn)
+
+		// Down past the deadline: the old server's clock never fires it
+		ts.clock.now = ts.clock.Now().Add(2 * ts.roomTurnTimeout(room))
+		fresh := ts.restart(t)
+
+		if turn := fresh.room(room.Code).Turn; turn != next {
+			t.Fatalf("turn = %q after restoring, want %q", turn, next)
+		}
+		if hand := fresh.hand(stalled); len(hand) != 1 || hand[0] != "Cat" {
+			t.Fatalf("%s's hand = %v", stalled, hand)
+		}
+	})
+}
+
+func TestRestoredNopeWindowStillApplies(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		player, opponent := room.Turn, room.nextAlive(room.Turn)
+		ts.deal(player, "Skip")
+		ts.deal(opponent)
+		if w := ts.play(player, room.gameID(), "Skip"); w.Code != http.StatusAccepted {
+			t.Fatalf("playing Skip = %d: %s", w.Code, w.Body.String())
+		}
+		ts.clock.now = ts.clock.Now().Add(ts.nopeWindow / 2)
+
+		fresh := ts.restart(t)
+		if !fresh.hasPendingAction(room.Code) {
+			t.Fatal("the Skip's Nope window wasn't restored")
+		}
+		fresh.clock.Advance(ts.nopeWindow / 2)
+		if turn := fresh.room(room.Code).Turn; turn != opponent {
+			t.Fatalf("turn = %q after the restored window, want %q", turn, opponent)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+)
+
+// The shape of the game hash this server writes, stored in its
+// schemaVersion field. Hashes without one were written before the field and
+// are version 1.
+const currentGameSchema = 3
+
+// A stored game with a schemaVersion above currentGameSchema, written by a
+// newer server
+type gameSchemaError struct {
+	Version int
+}
+
+func (e *gameSchemaError) Error() string {
+	return fmt.Sprintf("game schema %d is newer than %d", e.Version, currentGameSchema)
+}
+
+// What a migration upgrades: the fields of a game hash and, for a solo
+// game, of its player's user hash
+type gameRecord struct {
+	Game map[string]string
+	// nil for a room
+	User map[string]string
+	// Whether the game has a deck, which every game dealt before the schema
+	// had
+	Dealt bool
+}
+
+// Migrations in order: gameMigrations[i] upgrades a record from version
+// i+1 to i+2. Changing what the game hash holds only takes a new entry here
+// and a bump of currentGameSchema.
+var gameMigrations = []func(record *gameRecord){
+	// v1 -> v2: a dealt game without a status is one still being played
+	func(record *gameRecord) {
+		if record.Dealt && record.Game["status"] == "" {
+			record.Game["status"] = GameStatusActive
+		}
+	},
+	// v2 -> v3: the game hash's defuse flag becomes the player's defuse
+	// count, unless they already have one
+	func(record *gameRecord) {
+		flag, ok := record.Game["defuse"]
+		if !ok {
+			return
+		}
+		delete(record.Game, "defuse")
+		if record.User == nil || record.User["defuse"] != "" {
+			return
+		}
+		if held, _ := strconv.ParseBool(flag); held {
+			record.User["defuse"] = "1"
+		} else {
+			record.User["defuse"] = "0"
+		}
+	},
+}
+
+// The schema version of a game hash, 0 if nothing is stored
+func gameSchemaVersion(record gameRecord) (int, error) {
+	if len(record.Game) == 0 && !record.Dealt {
+		return 0, nil
+	}
+	raw := record.Game["schemaVersion"]
+	if raw == "" {
+		return 1, nil
+	}
+	version, err := strconv.Atoi(raw)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid game schema %q", raw)
+	}
+	if version > currentGameSchema {
+		return 0, &gameSchemaError{Version: version}
+	}
+	return version, nil
+}
+
+// Upgrade a record from version from to currentGameSchema in place
+func migrateGameRecord(record *gameRecord, from int) {
+	if record.Game == nil {
+		record.Game = make(map[string]string)
+	}
+	for version := from; version < currentGameSchema; version++ {
+		gameMigrations[version-1](record)
+	}
+	record.Game["schemaVersion"] = strconv.Itoa(currentGameSchema)
+}
+
+// The fields to set and delete to turn the hash before into after
+func hashChanges(before, after map[string]string) (map[string]interface{}, []string) {
+	set := make(map[string]interface{})
+	for field, value := range after {
+		if old, ok := before[field]; !ok || old != value {
+			set[field] = value
+		}
+	}
+	var deleted []string
+	for field := range before {
+		if _, ok := after[field]; !ok {
+			deleted = append(deleted, field)
+		}
+	}
+	return set, deleted
+}
+
+func copyHash(hash map[string]string) map[string]string {
+	if hash == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(hash))
+	for field, value := range hash {
+		copied[field] = value
+	}
+	return copied
+}
+
+// Game IDs this instance has seen at currentGameSchema. Games are only ever
+// written at the current schema from then on, so they aren't read again.
+type migratedGames struct {
+	mutex sync.Mutex
+	ids   map[string]bool
+}
+
+func (m *migratedGames) done(gameID string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.ids[gameID]
+}
+
+func (m *migratedGames) mark(gameID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.ids == nil {
+		m.ids = make(map[string]bool)
+	}
+	m.ids[gameID] = true
+}
+
+// Bring the stored game up to currentGameSchema before anything reads it.
+// A game written by a newer server is refused rather than misread.
+func (s *Server) upgradeGame(ctx context.Context, game *GameSession) *APIError {
+	if s.migrated.done(game.ID) {
+		return nil
+	}
+	from, err := s.store.MigrateGame(ctx, game.ID, game.Username)
+	if schemaErr, ok := err.(*gameSchemaError); ok {
+		log.Printf("Refusing game %s: %v", game.ID, err)
+		return errIncompatibleGame(schemaErr.Version)
+	}
+	if err != nil {
+		log.Printf("Error migrating game %s: %v", game.ID, err)
+		return errStoreUnavailable("Error loading game")
+	}
+	if from > 0 && from < currentGameSchema {
+		log.Printf("Migrated game %s from schema %d to %d", game.ID, from, currentGameSchema)
+	}
+	s.migrated.mark(game.ID)
+	return nil
+}
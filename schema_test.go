@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// Rewrite the player's solo game the way a version 1 server stored it: no
+// schemaVersion or status, and the Defuse as a flag in the game hash rather
+// than a count for the player
+func (ts *testServer) storeAsV1(username string, defuse bool) {
+	ts.t.Helper()
+	ctx := context.Background()
+	flag := "false"
+	if defuse {
+		flag = "true"
+	}
+	switch store := ts.store.(type) {
+	case *redisStore:
+		pipe := store.rdb.TxPipeline()
+		pipe.HDel(ctx, store.keys.game(username), "schemaVersion", "status")
+		pipe.HSet(ctx, store.keys.game(username), "defuse", flag)
+		pipe.HDel(ctx, store.keys.user(username), "defuse")
+		if _, err := pipe.Exec(ctx); err != nil {
+			ts.t.Fatalf("storing a v1 game: %v", err)
+		}
+	case *memoryStore:
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		delete(store.games[username], "schemaVersion")
+		delete(store.games[username], "status")
+		store.games[username]["defuse"] = flag
+		delete(store.defuse, username)
+	default:
+		ts.t.Fatalf("can't store a v1 game in a %T", store)
+	}
+}
+
+func TestV1GameMigratesOnLoad(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		ts.startGame("alice", engine.ExplodingKitten, "Cat", "Cat")
+		ts.storeAsV1("alice", true)
+		// A server that hasn't seen the game yet, as after a deploy
+		ts = ts.restart(t)
+
+		// The player's flag became a Defuse they can spend on the bomb
+		drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if drawn.Disposition != DispositionPendingDefuse || drawn.DefuseCount != 1 {
+			t.Fatalf("draw from a v1 game = %+v, want the bomb held for a Defuse", drawn)
+		}
+		hash, err := ts.store.GetGameHash(ctx, "alice")
+		if err != nil {
+			t.Fatalf("GetGameHash: %v", err)
+		}
+		if _, flagged := hash["defuse"]; flagged || hash["schemaVersion"] != strconv.Itoa(currentGameSchema) {
+			t.Fatalf("game hash after the migration = %v", hash)
+		}
+		if defused := ts.resolveBomb("alice", true); defused.Disposition != DispositionDefused || defused.DefuseCount != 0 {
+			t.Fatalf("defusing = %+v", defused)
+		}
+
+		// Without the flag there is nothing to spend
+		ts.startGame("bob", engine.ExplodingKitten, "Cat", "Cat")
+		ts.storeAsV1("bob", false)
+		ts = ts.restart(t)
+		if drawn := decodeOK[DrawCardResponse](t, ts.draw("bob")); drawn.Disposition != DispositionExploded {
+			t.Fatalf("draw from a v1 game without a Defuse = %+v", drawn)
+		}
+		if hash, _ := ts.store.GetGameHash(ctx, "bob"); hash["status"] != GameStatusLost || hash["schemaVersion"] != strconv.Itoa(currentGameSchema) {
+			t.Fatalf("game hash after the loss = %v", hash)
+		}
+	})
+}
+
+func TestNewerGameSchemaIsRefused(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", "Cat")
+		switch store := ts.store.(type) {
+		case *redisStore:
+			store.rdb.HSet(context.Background(), store.keys.game("alice"), "schemaVersion", currentGameSchema+1)
+		case *memoryStore:
+			store.mutex.Lock()
+			store.games["alice"]["schemaVersion"] = strconv.Itoa(currentGameSchema + 1)
+			store.mutex.Unlock()
+		}
+		ts = ts.restart(t)
+
+		assertError(t, ts.draw("alice"), http.StatusConflict, ErrCodeIncompatible)
+		if deck := ts.deck("alice"); len(deck) != 2 {
+			t.Fatalf("deck after the refused draw = %v", deck)
+		}
+	})
+}
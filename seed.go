@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"exploding-kitten/engine"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits of one POST /debug/seed
+const (
+	maxSeedUsers        = 50000
+	maxSeedGamesPerUser = 10000
+)
+
+// Users written per pipeline by GameStore.SeedUsers and DeleteSeeded
+const seedBatchSize = 500
+
+// Seeded usernames are this followed by a zero-padded number. Seeding again
+// overwrites users of the same name.
+const seedUserPrefix = "seed-"
+
+// Every seedInFlightEvery-th seeded user is left in the middle of a game
+const seedInFlightEvery = 20
+
+// How games are spread over seeded users
+const (
+	// Every user has played gamesPerUser games
+	SeedUniform = "uniform"
+	// Games follow a Zipf law: most users have played a handful and a few
+	// have played many times gamesPerUser
+	SeedZipf = "zipf"
+)
+
+type SeedRequest struct {
+	Users        int    `json:"users"`
+	GamesPerUser int    `json:"gamesPerUser"`
+	Distribution string `json:"distribution"`
+}
+
+// Debug seed route
+type SeedResponse struct {
+	Users int `json:"users"`
+	// Users left in the middle of a game
+	InFlight int   `json:"inFlight"`
+	Games    int64 `json:"games"`
+	// Milliseconds it took to write them
+	ElapsedMs int64 `json:"elapsedMs"`
+}
+
+// Debug seed wipe route
+type SeedWipeResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// A generated user as GameStore.SeedUsers writes them: their counts, the
+// game hash of their solo game, and its deck and move log
+type SeedUser struct {
+	Username     string
+	Wins, Losses int64
+	Streak       int64
+	GamesPlayed  int64
+	// GameStatusActive for a game in progress, else how the last one ended
+	Status string
+	// Cards left, top first, of a game in progress
+	Deck  []string
+	Moves [][]byte
+}
+
+// Generate users with believable records: each has a skill their win rate
+// hovers around, and its last game in the move log. Games in progress are
+// dealt deck.
+func generateSeedUsers(req SeedRequest, deck DeckConfig, rng *rand.Rand, now time.Time) []SeedUser {
+	var zipf *rand.Zipf
+	if req.Distribution == SeedZipf && req.GamesPerUser > 0 {
+		zipf = rand.NewZipf(rng, 1.2, 1, uint64(req.GamesPerUser)*10)
+	}
+
+	users := make([]SeedUser, req.Users)
+	for i := range users {
+		games := int64(req.GamesPerUser)
+		if zipf != nil {
+			games = int64(zipf.Uint64()) + 1
+		}
+		skill := 0.2 + 0.6*rng.Float64()
+		user := SeedUser{
+			Username:    fmt.Sprintf("%s%06d", seedUserPrefix, i+1),
+			GamesPlayed: games,
+			Status:      GameStatusLost,
+		}
+		for g := int64(0); g < games; g++ {
+			if rng.Float64() < skill {
+				user.Wins++
+				user.Streak++
+			} else {
+				user.Losses++
+				user.Streak = 0
+			}
+		}
+		if user.Streak > 0 {
+			user.Status = GameStatusWon
+		}
+		if i%seedInFlightEvery == seedInFlightEvery-1 {
+			user.Status = GameStatusActive
+			user.Deck = buildDeck(deck, rng)
+		}
+		user.Moves = seedMoves(user, rng, now)
+		users[i] = user
+	}
+	return users
+}
+
+// The move log of a seeded user's latest game: a few cards drawn and, for a
+// finished game, the one it ended on
+func seedMoves(user SeedUser, rng *rand.Rand, now time.Time) [][]byte {
+	if user.GamesPlayed == 0 {
+		return nil
+	}
+	held := []string{"Cat", "Tacocat", "Rainbow Cat", "Beard Cat", engine.Defuse}
+	draws := 2 + rng.Intn(6)
+	at := now.Add(-time.Duration(draws) * time.Minute)
+	var moves [][]byte
+	for seq := 1; seq <= draws+1; seq++ {
+		move := Move{Seq: int64(seq), Actor: user.Username, Action: MoveDraw, ResultingStatus: GameStatusActive, TS: at.UTC()}
+		switch {
+		case seq <= draws:
+			move.Card = held[rng.Intn(len(held))]
+		case user.Status == GameStatusLost:
+			move.Card, move.ResultingStatus = engine.ExplodingKitten, GameStatusLost
+		case user.Status == GameStatusWon:
+			move.Card, move.ResultingStatus = held[rng.Intn(len(held)-1)], GameStatusWon
+		default:
+			// Still playing
+			return moves
+		}
+		payload, err := json.Marshal(move)
+		if err != nil {
+			continue
+		}
+		moves = append(moves, payload)
+		at = at.Add(time.Minute)
+	}
+	return moves
+}
+
+// Debug seed route: generate users for load testing the leaderboard and
+// broadcasts, then send every client the new standings
+func (s *Server) debugSeed(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req SeedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	if req.Users < 1 || req.Users > maxSeedUsers {
+		abortWithError(c, errInvalidRequest(fmt.Sprintf("users must be between 1 and %d", maxSeedUsers)))
+		return
+	}
+	if req.GamesPerUser < 0 || req.GamesPerUser > maxSeedGamesPerUser {
+		abortWithError(c, errInvalidRequest(fmt.Sprintf("gamesPerUser must be between 0 and %d", maxSeedGamesPerUser)))
+		return
+	}
+	switch req.Distribution {
+	case "":
+		req.Distribution = SeedUniform
+	case SeedUniform, SeedZipf:
+	default:
+		abortWithError(c, errInvalidRequest("distribution must be uniform or zipf"))
+		return
+	}
+
+	started := s.clock.Now()
+	users := generateSeedUsers(req, s.soloDeck, newDeckRand(), started)
+	if err := s.store.SeedUsers(ctx, users); err != nil {
+		log.Printf("Error seeding %d users: %v", len(users), err)
+		abortWithError(c, errStoreUnavailable("Error seeding users"))
+		return
+	}
+
+	response := SeedResponse{Users: len(users)}
+	for _, user := range users {
+		response.Games += user.GamesPlayed
+		if user.Status == GameStatusActive {
+			response.InFlight++
+		}
+	}
+	response.ElapsedMs = s.clock.Now().Sub(started).Milliseconds()
+	log.Printf("Seeded %d users with %d games in %dms", response.Users, response.Games, response.ElapsedMs)
+
+	s.leaderboard.invalidate()
+	s.broadcastLeaderboard()
+	c.JSON(http.StatusOK, response)
+}
+
+// Debug seed wipe route: delete every seeded user
+func (s *Server) debugWipeSeed(c *gin.Context) {
+	deleted, err := s.store.DeleteSeeded(c.Request.Context())
+	if err != nil {
+		log.Printf("Error deleting seeded users after %d: %v", deleted, err)
+		abortWithError(c, errStoreUnavailable("Error deleting seeded users"))
+		return
+	}
+	log.Printf("Deleted %d seeded users", deleted)
+
+	s.leaderboard.invalidate()
+	s.broadcastLeaderboard()
+	c.JSON(http.StatusOK, SeedWipeResponse{Deleted: deleted})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// What a round trip to Redis is taken to cost against the budget
+const seedRoundTrip = time.Millisecond
+
+// A Redis hook moving a fake clock on by seedRoundTrip for each round trip,
+// so a budget counts trips rather than a loaded machine's wall-clock time
+type clockedRoundTrips struct{ clock *fakeClock }
+
+func (c clockedRoundTrips) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	c.clock.Advance(seedRoundTrip)
+	return ctx, nil
+}
+
+func (c clockedRoundTrips) AfterProcess(ctx context.Context, cmd redis.Cmder) error { return nil }
+
+func (c clockedRoundTrips) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	c.clock.Advance(seedRoundTrip)
+	return ctx, nil
+}
+
+func (c clockedRoundTrips) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestSeededLeaderboardStaysInBudget(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{"APP_ENV": "development"}))
+		seeded := decodeOK[SeedResponse](t, ts.post("/debug/seed", SeedRequest{Users: 1000, GamesPerUser: 20, Distribution: SeedZipf}))
+		if seeded.Users != 1000 || seeded.InFlight != 1000/seedInFlightEvery || seeded.Games < 1000 {
+			t.Fatalf("seeded %+v", seeded)
+		}
+		// The memory store makes no round trips, so its reads take no time
+		if redisStore, ok := store.(*redisStore); ok {
+			redisStore.rdb.AddHook(clockedRoundTrips{clock: ts.clock})
+		}
+
+		// The same budget a draw gets
+		start := ts.clock.Now()
+		rows := decodeOK[LeaderboardResponse](t, ts.get("/leaderboard?limit=1000")).Leaderboard
+		if took := ts.clock.Now().Sub(start); took > defaultDrawBudget {
+			t.Fatalf("leaderboard of 1000 users took %v", took)
+		}
+		seen := make(map[string]bool)
+		for i, row := range rows {
+			if seen[row.Username] || (i > 0 && row.Win > rows[i-1].Win) {
+				t.Fatalf("row %d = %+v after %+v", i, row, rows[i-1])
+			}
+			seen[row.Username] = true
+		}
+		if len(seen) != 1000 {
+			t.Fatalf("leaderboard has %d users, want 1000", len(seen))
+		}
+
+		// Paging through the store finds each of them once
+		paged := make(map[string]bool)
+		it := ts.store.LeaderboardStats(ctx, 100)
+		for {
+			page, ok := it.Next(ctx)
+			if !ok {
+				break
+			}
+			for _, stats := range page {
+				if paged[stats.Username] {
+					t.Fatalf("%s paged twice", stats.Username)
+				}
+				paged[stats.Username] = true
+			}
+		}
+		if err := it.Err(); err != nil || len(paged) != 1000 {
+			t.Fatalf("paged %d users: %v", len(paged), err)
+		}
+
+		socket := ts.dial("username=seed-000001")
+		socket.next("leaderboard")
+		ts.store.SetStats(ctx, "seed-000002", 1000, 0, AuditEntry{})
+		ts.leaderboard.invalidate()
+		start = ts.clock.Now()
+		ts.broadcastLeaderboard()
+		frame := decodeMessage[struct{ Leaderboard []LeaderboardEntry }](t, socket.next("leaderboard"))
+		if took := ts.clock.Now().Sub(start); took > defaultDrawBudget {
+			t.Fatalf("broadcast to 1000 users took %v", took)
+		}
+		if len(frame.Leaderboard) == 0 || frame.Leaderboard[0].Username != "seed-000002" {
+			t.Fatalf("broadcast leaderboard starts %+v", frame.Leaderboard[:1])
+		}
+
+		wiped := decodeOK[SeedWipeResponse](t, ts.request(http.MethodDelete, "/debug/seed", nil))
+		if wiped.Deleted != 1000 {
+			t.Fatalf("wiped %d users, want 1000", wiped.Deleted)
+		}
+		if rows := decodeOK[LeaderboardResponse](t, ts.get("/leaderboard?limit=1000")).Leaderboard; len(rows) != 0 {
+			t.Fatalf("leaderboard after the wipe has %d users", len(rows))
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Writes a connection's p95 is taken over, the most recent first
+const connWriteSamples = 100
+
+// Connections /admin/connections lists per page unless ?limit= says
+// otherwise, and the most it lists
+const (
+	defaultConnectionsPage = 50
+	maxConnectionsPage     = 500
+)
+
+// The label for frames whose type can't be read
+const otherFrameType = "other"
+
+// A frame waiting in a connection's send queue
+type queuedFrame struct {
+	data []byte
+	// The message type, labelling the frame's metrics
+	kind     string
+	queuedAt time.Time
+}
+
+// The durations of a connection's last connWriteSamples writes
+type writeSamples struct {
+	durations [connWriteSamples]time.Duration
+	next      int
+	count     int
+}
+
+func (w *writeSamples) add(d time.Duration) {
+	w.durations[w.next] = d
+	w.next = (w.next + 1) % connWriteSamples
+	if w.count < connWriteSamples {
+		w.count++
+	}
+}
+
+// The 95th percentile of the samples, zero if there are none
+func (w *writeSamples) p95() time.Duration {
+	if w.count == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, w.count)
+	copy(sorted, w.durations[:w.count])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(w.count*95+99)/100-1]
+}
+
+// One connection as /admin/connections lists it
+type ConnectionStats struct {
+	// The client's address with its host part masked and the port dropped
+	RemoteAddr string `json:"remoteAddr"`
+	// The player the socket is for, "" if it didn't say
+	Username    string    `json:"username"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	// Frames waiting to be written
+	QueueDepth int `json:"queueDepth"`
+	// Frames written so far
+	Writes     int64   `json:"writes"`
+	P95WriteMs float64 `json:"p95WriteMs"`
+
+	conn *websocket.Conn
+}
+
+// Admin connections route
+type AdminConnectionsResponse struct {
+	// Slowest first
+	Connections []ConnectionStats `json:"connections"`
+	Total       int               `json:"total"`
+	// The ?offset= of the next page, left out on the last
+	Next *int `json:"next,omitempty"`
+}
+
+// The type of a frame, for labelling its metrics: the "type" field of the
+// JSON object, or "other". Messages put their type first, so this seldom
+// reads past the start of the frame.
+func frameType(frame []byte) string {
+	decoder := json.NewDecoder(bytes.NewReader(frame))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return otherFrameType
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return otherFrameType
+		}
+		if key == "type" {
+			var kind string
+			if decoder.Decode(&kind) != nil || kind == "" {
+				return otherFrameType
+			}
+			return kind
+		}
+		var skipped json.RawMessage
+		if decoder.Decode(&skipped) != nil {
+			return otherFrameType
+		}
+	}
+	return otherFrameType
+}
+
+// A client address safe to show: IPv4 masked to its /24 and IPv6 to its /48,
+// without the port
+func anonymizeAddr(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// Every connection with frames to write, slowest first. Leaderboard sockets
+// that said whose they are after connecting take that player.
+func (h *Hub) connectionStats() []ConnectionStats {
+	stats := h.pool.connections()
+	h.mutex.Lock()
+	for i := range stats {
+		if username, ok := h.clientUser[stats[i].conn]; ok {
+			stats[i].Username = username
+		}
+	}
+	h.mutex.Unlock()
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].P95WriteMs != stats[j].P95WriteMs {
+			return stats[i].P95WriteMs > stats[j].P95WriteMs
+		}
+		return stats[i].QueueDepth > stats[j].QueueDepth
+	})
+	return stats
+}
+
+// Admin connections route: this instance's sockets, the slowest to write to
+// first, to find the clients holding up broadcasts
+func (s *Server) adminConnections(c *gin.Context) {
+	offset := 0
+	if raw := c.Query("offset"); raw != "" {
+		var err error
+		if offset, err = strconv.Atoi(raw); err != nil || offset < 0 {
+			abortWithError(c, errInvalidRequest("offset must be a non-negative integer"))
+			return
+		}
+	}
+	limit := defaultConnectionsPage
+	if raw := c.Query("limit"); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxConnectionsPage {
+			abortWithError(c, errInvalidRequest("limit must be between 1 and "+strconv.Itoa(maxConnectionsPage)))
+			return
+		}
+	}
+
+	stats := s.hub.connectionStats()
+	response := AdminConnectionsResponse{Connections: []ConnectionStats{}, Total: len(stats)}
+	if offset < len(stats) {
+		end := offset + limit
+		if end < len(stats) {
+			response.Next = &end
+		} else {
+			end = len(stats)
+		}
+		response.Connections = stats[offset:end]
+	}
+	c.JSON(http.StatusOK, response)
+}
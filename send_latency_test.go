@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A connection that takes delay over each write
+type slowConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+func (c slowConn) Write(p []byte) (int, error) {
+	time.Sleep(c.delay)
+	return c.Conn.Write(p)
+}
+
+type slowListener struct {
+	net.Listener
+	delay time.Duration
+}
+
+func (l slowListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return slowConn{Conn: conn, delay: l.delay}, nil
+}
+
+// A server-side socket whose writes each take delay, with its client end
+func slowSocketPair(t *testing.T, delay time.Duration) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	listener := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+			accepted <- conn
+		}
+	}))
+	listener.Listener = slowListener{Listener: listener.Listener, delay: delay}
+	listener.Start()
+	t.Cleanup(listener.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(listener.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+// The sample count and sum of a histogram's series for the message type
+func histogramOf(t *testing.T, name, kind string) (uint64, float64) {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "type" && label.GetValue() == kind {
+					return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+				}
+			}
+		}
+	}
+	return 0, 0
+}
+
+func TestWriteDelayShowsInHistogramAndDump(t *testing.T) {
+	eachAdminStore(t, func(t *testing.T, ts *testServer) {
+		const frames, delay = 5, 20 * time.Millisecond
+		slow, slowClient := slowSocketPair(t, delay)
+		fast, fastClient := slowSocketPair(t, 0)
+		writesBefore, writeSumBefore := histogramOf(t, "websocket_write_seconds", "slow_probe")
+		waitsBefore, waitSumBefore := histogramOf(t, "websocket_send_wait_seconds", "slow_probe")
+
+		ts.hub.pool.open(slow, "bob")
+		ts.hub.pool.open(fast, "alice")
+		for i := 0; i < frames; i++ {
+			if err := ts.hub.pool.send(slow, []byte(`{"type":"slow_probe"}`)); err != nil {
+				t.Fatal(err)
+			}
+			if err := ts.hub.pool.send(fast, []byte(`{"type":"fast_probe"}`)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, client := range []*websocket.Conn{slowClient, fastClient} {
+			for i := 0; i < frames; i++ {
+				if _, _, err := client.ReadMessage(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		eventually(t, "the slow writes to be timed", func() bool {
+			count, _ := histogramOf(t, "websocket_write_seconds", "slow_probe")
+			return count-writesBefore == frames
+		})
+
+		// Each write took the delay, and the frames behind it waited for it
+		_, writeSum := histogramOf(t, "websocket_write_seconds", "slow_probe")
+		if took := writeSum - writeSumBefore; took < (frames * delay).Seconds() {
+			t.Fatalf("slow writes took %vs in all, want at least %v", took, frames*delay)
+		}
+		waits, waitSum := histogramOf(t, "websocket_send_wait_seconds", "slow_probe")
+		if waits-waitsBefore != frames || waitSum-waitSumBefore < delay.Seconds() {
+			t.Fatalf("%d slow frames waited %vs", waits-waitsBefore, waitSum-waitSumBefore)
+		}
+
+		// The slow socket heads the dump, a page at a time
+		page := decodeOK[AdminConnectionsResponse](t, ts.get("/admin/connections?limit=1", asAdmin...))
+		if page.Total != 2 || page.Next == nil || *page.Next != 1 || len(page.Connections) != 1 {
+			t.Fatalf("first page = %+v", page)
+		}
+		worst := page.Connections[0]
+		if worst.Username != "bob" || worst.Writes != frames || worst.P95WriteMs < float64(delay.Milliseconds()) || worst.RemoteAddr != "127.0.0.0/24" || !worst.ConnectedAt.Equal(ts.clock.Now()) {
+			t.Fatalf("slowest connection = %+v", worst)
+		}
+		last := decodeOK[AdminConnectionsResponse](t, ts.get("/admin/connections?limit=1&offset=1", asAdmin...))
+		if last.Next != nil || len(last.Connections) != 1 || last.Connections[0].Username != "alice" || last.Connections[0].P95WriteMs >= worst.P95WriteMs {
+			t.Fatalf("second page = %+v", last)
+		}
+		assertError(t, ts.get("/admin/connections"), http.StatusUnauthorized, ErrCodeUnauthorized)
+		assertError(t, ts.get("/admin/connections?limit=0", asAdmin...), http.StatusBadRequest, ErrCodeInvalidRequest)
+	})
+}
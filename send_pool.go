@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Workers writing to sockets when BROADCAST_WORKERS isn't set
+const defaultBroadcastWorkers = 8
+
+// Frames a connection may have waiting, how long its queue may stay full
+// before the connection is dropped, and how long one write may take
+const (
+	sendQueueSize    = 64
+	sendQueueGrace   = 5 * time.Second
+	sendWriteTimeout = 10 * time.Second
+)
+
+var (
+	errConnClosed   = errors.New("connection is closed")
+	errConnBackedUp = errors.New("connection's send queue is full")
+)
+
+// A connection's outgoing frames, written in order
+type sendQueue struct {
+	conn   *websocket.Conn
+	frames chan queuedFrame
+	// When the queue was first found full; zero while it has room
+	fullSince time.Time
+	// Whether the queue is waiting for a worker or being drained by one
+	scheduled bool
+	closed    bool
+
+	// For /admin/connections
+	remoteAddr  string
+	username    string
+	connectedAt time.Time
+	writes      int64
+	samples     writeSamples
+}
+
+// sendPool writes every socket's frames with a fixed number of workers. A
+// broadcast only queues a frame per connection, so it never waits on a
+// socket; a slow client holds up one worker until its write times out, and
+// one that stays backed up for sendQueueGrace is dropped.
+type sendPool struct {
+	workers int
+	now     func() time.Time
+
+	mutex    sync.Mutex
+	wake     *sync.Cond
+	queues   map[*websocket.Conn]*sendQueue
+	ready    []*sendQueue
+	started  bool
+	stopping bool
+	done     sync.WaitGroup
+}
+
+func newSendPool(workers int, now func() time.Time) *sendPool {
+	p := &sendPool{workers: workers, now: now, queues: make(map[*websocket.Conn]*sendQueue)}
+	p.wake = sync.NewCond(&p.mutex)
+	return p
+}
+
+func (p *sendPool) newQueue(conn *websocket.Conn, username string) *sendQueue {
+	q := &sendQueue{
+		conn:        conn,
+		frames:      make(chan queuedFrame, sendQueueSize),
+		remoteAddr:  anonymizeAddr(conn.RemoteAddr()),
+		username:    username,
+		connectedAt: p.now(),
+	}
+	p.queues[conn] = q
+	return q
+}
+
+// Start a queue for a connection that has just been opened, by the player
+// it says it is for, if any
+func (p *sendPool) open(conn *websocket.Conn, username string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.queues[conn] == nil {
+		p.newQueue(conn, username)
+	}
+}
+
+// Queue a frame for the connection. Fails if the connection is closed or
+// its queue is full, dropping the connection once the queue has been full
+// for longer than sendQueueGrace.
+func (p *sendPool) send(conn *websocket.Conn, frame []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.started {
+		p.start()
+	}
+
+	q := p.queues[conn]
+	if q == nil {
+		q = p.newQueue(conn, "")
+	}
+	if q.closed || p.stopping {
+		return errConnClosed
+	}
+
+	select {
+	case q.frames <- queuedFrame{data: frame, kind: frameType(frame), queuedAt: time.Now()}:
+		q.fullSince = time.Time{}
+		sendQueueDepth.Observe(float64(len(q.frames)))
+	default:
+		now := p.now()
+		if q.fullSince.IsZero() {
+			q.fullSince = now
+		} else if now.Sub(q.fullSince) > sendQueueGrace {
+			log.Printf("Dropping a WebSocket client backed up for %s", now.Sub(q.fullSince).Round(time.Second))
+			websocketEvictionsTotal.Inc()
+			q.closed = true
+			conn.Close()
+			return errConnClosed
+		}
+		return errConnBackedUp
+	}
+
+	if !q.scheduled {
+		q.scheduled = true
+		p.ready = append(p.ready, q)
+		p.wake.Signal()
+	}
+	return nil
+}
+
+// Forget a connection that has closed, dropping the frames it still had
+// waiting. Callers close the connection.
+func (p *sendPool) remove(conn *websocket.Conn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if q := p.queues[conn]; q != nil {
+		q.closed = true
+		delete(p.queues, conn)
+	}
+}
+
+// Start the workers. Callers hold the mutex.
+func (p *sendPool) start() {
+	p.started = true
+	for i := 0; i < p.workers; i++ {
+		p.done.Add(1)
+		go p.work()
+	}
+}
+
+// Drain queues as they become ready, until the pool stops and nothing is left
+func (p *sendPool) work() {
+	defer p.done.Done()
+	for {
+		p.mutex.Lock()
+		for len(p.ready) == 0 && !p.stopping {
+			p.wake.Wait()
+		}
+		if len(p.ready) == 0 {
+			p.mutex.Unlock()
+			return
+		}
+		q := p.ready[0]
+		p.ready[0] = nil
+		p.ready = p.ready[1:]
+		p.mutex.Unlock()
+
+		p.drain(q)
+	}
+}
+
+// Write a queue's frames until it is empty, timing how long each waited and
+// took to write. A failed write closes the connection, whose reader then
+// unregisters it.
+func (p *sendPool) drain(q *sendQueue) {
+	for {
+		select {
+		case frame := <-q.frames:
+			p.mutex.Lock()
+			closed := q.closed
+			p.mutex.Unlock()
+			if closed {
+				continue
+			}
+			start := time.Now()
+			websocketSendWaitSeconds.WithLabelValues(frame.kind).Observe(start.Sub(frame.queuedAt).Seconds())
+			q.conn.SetWriteDeadline(start.Add(sendWriteTimeout))
+			err := q.conn.WriteMessage(websocket.TextMessage, frame.data)
+			took := time.Since(start)
+			websocketWriteSeconds.WithLabelValues(frame.kind).Observe(took.Seconds())
+			p.mutex.Lock()
+			q.writes++
+			q.samples.add(took)
+			p.mutex.Unlock()
+			if err != nil {
+				log.Printf("Error sending to a WebSocket client: %v", err)
+				p.mutex.Lock()
+				q.closed = true
+				p.mutex.Unlock()
+				q.conn.Close()
+			}
+		default:
+			p.mutex.Lock()
+			if len(q.frames) > 0 {
+				p.mutex.Unlock()
+				continue
+			}
+			q.scheduled = false
+			p.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// A snapshot of every queue, for /admin/connections
+func (p *sendPool) connections() []ConnectionStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	stats := make([]ConnectionStats, 0, len(p.queues))
+	for conn, q := range p.queues {
+		stats = append(stats, ConnectionStats{
+			RemoteAddr:  q.remoteAddr,
+			Username:    q.username,
+			ConnectedAt: q.connectedAt,
+			QueueDepth:  len(q.frames),
+			Writes:      q.writes,
+			P95WriteMs:  float64(q.samples.p95().Microseconds()) / 1000,
+			conn:        conn,
+		})
+	}
+	return stats
+}
+
+// Stop taking frames and wait, until ctx is done, for the workers to write
+// the ones already queued
+func (p *sendPool) stop(ctx context.Context) {
+	p.mutex.Lock()
+	p.stopping = true
+	p.wake.Broadcast()
+	p.mutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		p.done.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		log.Println("Gave up waiting for WebSocket frames to be written")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// n server-side sockets, each with its client end
+func socketPairs(tb testing.TB, n int) ([]*websocket.Conn, []*websocket.Conn) {
+	tb.Helper()
+	accepted := make(chan *websocket.Conn, n)
+	upgrader := websocket.Upgrader{}
+	listener := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}))
+	tb.Cleanup(listener.Close)
+
+	url := "ws" + strings.TrimPrefix(listener.URL, "http")
+	servers, clients := make([]*websocket.Conn, n), make([]*websocket.Conn, n)
+	for i := range clients {
+		client, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		clients[i], servers[i] = client, <-accepted
+		tb.Cleanup(func() {
+			client.Close()
+			servers[i].Close()
+		})
+	}
+	return servers, clients
+}
+
+func TestBlockedConnectionIsEvicted(t *testing.T) {
+	servers, clients := socketPairs(t, 4)
+	var mutex sync.Mutex
+	now := testEpoch
+	pool := newSendPool(2, func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return now
+	})
+	for _, conn := range servers {
+		pool.open(conn, "")
+	}
+	blocked := servers[0]
+
+	// The blocked client never reads, so once the socket's buffers fill its
+	// worker is stuck in a write and its queue fills up
+	big := bytes.Repeat([]byte("x"), 256<<10)
+	fills := 0
+	for i := 0; i < 10000 && fills < 2; i++ {
+		switch err := pool.send(blocked, big); err {
+		case nil:
+			fills = 0
+		case errConnBackedUp:
+			// Still full a moment later: the worker is stuck
+			fills++
+			time.Sleep(20 * time.Millisecond)
+		default:
+			t.Fatalf("send to the blocked socket: %v", err)
+		}
+	}
+	if fills < 2 {
+		t.Fatal("the blocked socket's queue never stayed full")
+	}
+
+	// The others are written by the free worker meanwhile
+	frame := []byte(`{"type":"leaderboard"}`)
+	for _, conn := range servers[1:] {
+		if err := pool.send(conn, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, client := range clients[1:] {
+		client.SetReadDeadline(time.Now().Add(socketTimeout))
+		if _, got, err := client.ReadMessage(); err != nil || !bytes.Equal(got, frame) {
+			t.Fatalf("client %d got %q, %v", i+1, got, err)
+		}
+	}
+
+	// Full within the grace period it is kept; past it, dropped
+	evictions := testutil.ToFloat64(websocketEvictionsTotal)
+	mutex.Lock()
+	now = now.Add(sendQueueGrace)
+	mutex.Unlock()
+	if err := pool.send(blocked, frame); err != errConnBackedUp {
+		t.Fatalf("send at the end of the grace period = %v", err)
+	}
+	mutex.Lock()
+	now = now.Add(time.Millisecond)
+	mutex.Unlock()
+	if err := pool.send(blocked, frame); err != errConnClosed {
+		t.Fatalf("send past the grace period = %v, want the socket dropped", err)
+	}
+	if got := testutil.ToFloat64(websocketEvictionsTotal) - evictions; got != 1 {
+		t.Fatalf("%v evictions counted", got)
+	}
+	if err := pool.send(blocked, frame); err != errConnClosed {
+		t.Fatalf("send after the eviction = %v", err)
+	}
+
+	// Closing the socket freed the stuck worker, so the pool drains
+	ctx, cancel := context.WithTimeout(context.Background(), socketTimeout)
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		pool.stop(ctx)
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(socketTimeout):
+		t.Fatal("the pool didn't drain")
+	}
+	if ctx.Err() != nil {
+		t.Fatal("the pool only stopped on its deadline")
+	}
+}
+
+// Broadcast a frame to 500 sockets and wait for every client to have it:
+// written one socket after another, as before the pool, and through it
+func BenchmarkBroadcast(b *testing.B) {
+	const conns = 500
+	frame := bytes.Repeat([]byte("x"), 4<<10)
+	for _, mode := range []string{"serial", "pool"} {
+		b.Run(mode, func(b *testing.B) {
+			servers, clients := socketPairs(b, conns)
+			received := make(chan struct{}, conns)
+			for _, client := range clients {
+				go func(client *websocket.Conn) {
+					for {
+						if _, _, err := client.ReadMessage(); err != nil {
+							return
+						}
+						received <- struct{}{}
+					}
+				}(client)
+			}
+			pool := newSendPool(defaultBroadcastWorkers, time.Now)
+			defer pool.stop(context.Background())
+			var mutex sync.Mutex
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if mode == "serial" {
+					mutex.Lock()
+					for _, conn := range servers {
+						conn.WriteMessage(websocket.TextMessage, frame)
+					}
+					mutex.Unlock()
+				} else {
+					for _, conn := range servers {
+						pool.send(conn, frame)
+					}
+				}
+				for range servers {
+					<-received
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Where the API listens when LISTEN_ADDR isn't set
+const defaultListenAddr = "0.0.0.0:8080"
+
+// How long in-flight requests get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// How the server listens; see Config.listen
+type listenConfig struct {
+	// LISTEN_ADDR: the API's address, plain HTTP or TLS
+	addr string
+	// TLS_CERT_FILE and TLS_KEY_FILE: serve TLS (and HTTP/2) when both are set
+	certFile string
+	keyFile  string
+	// HTTP_REDIRECT_ADDR: with TLS, a plain listener that redirects to it
+	redirectAddr string
+}
+
+func (c listenConfig) tls() bool {
+	return c.certFile != "" && c.keyFile != ""
+}
+
+// Run the handler on the configured listeners until ctx is done, then shut
+// them down, letting in-flight requests finish. Returns the error that
+// stopped a listener early, if any.
+func serve(ctx context.Context, config listenConfig, handler http.Handler) error {
+	servers := []*http.Server{{Addr: config.addr, Handler: handler}}
+	if config.redirectAddr != "" {
+		servers = append(servers, &http.Server{Addr: config.redirectAddr, Handler: redirectToTLS(config.addr)})
+	}
+
+	errs := make(chan error, len(servers))
+	for i, srv := range servers {
+		go func() {
+			var err error
+			switch {
+			case i > 0:
+				log.Printf("Redirecting http://%s to TLS", srv.Addr)
+				err = srv.ListenAndServe()
+			case config.tls():
+				// net/http negotiates HTTP/2 over TLS; WebSocket upgrades
+				// stay on HTTP/1.1 connections
+				log.Printf("Running server on https://%s", srv.Addr)
+				err = srv.ListenAndServeTLS(config.certFile, config.keyFile)
+			default:
+				log.Printf("Running server on http://%s", srv.Addr)
+				err = srv.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				errs <- err
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		log.Println("Shutting down server...")
+	case err = <-errs:
+		log.Printf("Server stopped: %v", err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil {
+			log.Printf("Error shutting down %s: %v", srv.Addr, shutdownErr)
+			err = errors.Join(err, shutdownErr)
+		}
+	}
+	return err
+}
+
+// Redirect plain HTTP requests to the same path on the TLS listener
+func redirectToTLS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Write a self-signed certificate for 127.0.0.1 and its key into dir,
+// returning their paths
+func selfSignedPair(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// A local address nothing is listening on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestServeTLSUpgradesWebSockets(t *testing.T) {
+	certFile, keyFile := selfSignedPair(t, t.TempDir())
+	addr, redirectAddr := freeAddr(t), freeAddr(t)
+	config := testConfig(t, map[string]string{
+		"LISTEN_ADDR":        addr,
+		"TLS_CERT_FILE":      certFile,
+		"TLS_KEY_FILE":       keyFile,
+		"HTTP_REDIRECT_ADDR": redirectAddr,
+	})
+	ts := newTestServerWith(t, newMemoryStore(), config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- serve(ctx, config.listen(), ts.routes) }()
+	defer func() {
+		cancel()
+		if err := <-stopped; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	}()
+
+	// The transport adds h2 to its config, so the dialer has one of its own
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, ForceAttemptHTTP2: true},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	// Wait for the listener
+	var health *http.Response
+	for deadline := time.Now().Add(socketTimeout); ; {
+		var err error
+		if health, err = client.Get("https://" + addr + "/healthz"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("TLS listener never answered: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	health.Body.Close()
+	if health.StatusCode != http.StatusOK || health.ProtoMajor != 2 {
+		t.Fatalf("healthz over TLS = %d on %s, want 200 on HTTP/2", health.StatusCode, health.Proto)
+	}
+
+	// The https origin of the frontend is allowed by default, and the
+	// handshake completes over wss
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, HandshakeTimeout: socketTimeout}
+	conn, response, err := dialer.Dial("wss://"+addr+"/ws", http.Header{"Origin": {"https://localhost:3000"}})
+	if err != nil {
+		t.Fatalf("wss handshake: %v", err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("wss handshake status = %d", response.StatusCode)
+	}
+	socket := &testSocket{t: t, conn: conn}
+	socket.next("leaderboard")
+	conn.Close()
+
+	_, response, err = dialer.Dial("wss://"+addr+"/ws", http.Header{"Origin": {"https://evil.example"}})
+	if err == nil || response == nil || response.StatusCode != http.StatusForbidden {
+		t.Fatalf("wss handshake from another origin: %v", err)
+	}
+
+	// Plain HTTP is sent to the TLS port
+	redirect, err := client.Get("http://" + redirectAddr + "/leaderboard?limit=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect.Body.Close()
+	if want := "https://" + addr + "/leaderboard?limit=5"; redirect.StatusCode != http.StatusPermanentRedirect || redirect.Header.Get("Location") != want {
+		t.Fatalf("redirect = %d to %q, want %q", redirect.StatusCode, redirect.Header.Get("Location"), want)
+	}
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	// The handlers log every move; tests that check the log capture it
+	log.SetOutput(io.Discard)
+	redis.SetLogger(discardLogger{})
+	os.Exit(m.Run())
+}
+
+// Drops go-redis's own logging, e.g. of connections a restarted Redis broke
+type discardLogger struct{}
+
+func (discardLogger) Printf(ctx context.Context, format string, v ...interface{}) {}
+
+// When every test server's clock starts: a Monday, well away from midnight
+var testEpoch = time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+
+// fakeClock is a Clock that only moves when Advance is called, firing the
+// timers that come due on the way, in order, on the caller's goroutine
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	due     time.Time
+	fn      func()
+	stopped bool
+}
+
+func newFakeClock(now time.Time) *fakeClock { return &fakeClock{now: now} }
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &fakeTimer{clock: c, due: c.now.Add(d), fn: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	if t.stopped {
+		return false
+	}
+	t.stopped = true
+	return true
+}
+
+// Move the clock on by d, firing each timer due by then at its due time
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	target := c.now.Add(d)
+	c.mutex.Unlock()
+	for {
+		c.mutex.Lock()
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].due.Before(c.timers[j].due) })
+		var next *fakeTimer
+		for len(c.timers) > 0 {
+			t := c.timers[0]
+			if t.stopped {
+				c.timers = c.timers[1:]
+				continue
+			}
+			if t.due.After(target) {
+				break
+			}
+			next = t
+			next.stopped = true
+			c.timers = c.timers[1:]
+			if next.due.After(c.now) {
+				c.now = next.due
+			}
+			break
+		}
+		if next == nil {
+			c.now = target
+			c.mutex.Unlock()
+			return
+		}
+		c.mutex.Unlock()
+		next.fn()
+	}
+}
+
+// Timers set and not yet fired or stopped
+func (c *fakeClock) pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n := 0
+	for _, t := range c.timers {
+		if !t.stopped {
+			n++
+		}
+	}
+	return n
+}
+
+// The configuration a server gets from the environment in env, and the
+// defaults for everything else
+func testConfig(t *testing.T, env map[string]string) Config {
+	t.Helper()
+	config, err := loadConfig(func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	return config
+}
+
+// A Server wired to a fake clock, and the router serving it
+type testServer struct {
+	*Server
+	t      *testing.T
+	clock  *fakeClock
+	routes *gin.Engine
+	// Serves routes over a real connection once a test dials a socket
+	listener *httptest.Server
+}
+
+// A server backed by store with the default configuration
+func newTestServer(t *testing.T, store GameStore) *testServer {
+	t.Helper()
+	return newTestServerWith(t, store, testConfig(t, nil))
+}
+
+func newTestServerWith(t *testing.T, store GameStore, config Config) *testServer {
+	t.Helper()
+	s := newServer(store, config)
+	clock := newFakeClock(testEpoch)
+	s.clock = clock
+	s.hub.clock = clock
+	ts := &testServer{Server: s, t: t, clock: clock}
+	ts.routes = s.router()
+	t.Cleanup(func() {
+		s.hub.pool.stop(context.Background())
+	})
+	return ts
+}
+
+// The stores the handler tests run against, each opened fresh for a test
+var testStores = []struct {
+	name string
+	open func(t *testing.T) GameStore
+}{
+	{"memory", func(t *testing.T) GameStore { return newMemoryStore() }},
+	{"redis", func(t *testing.T) GameStore { return newTestRedisStore(t, keyBuilder{}) }},
+}
+
+// A redisStore with the keys of keys, on a miniredis of its own that goes
+// away with the test
+func newTestRedisStore(t *testing.T, keys keyBuilder) *redisStore {
+	t.Helper()
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	store := newRedisStore(rdb)
+	store.keys = keys
+	return store
+}
+
+// Run test once against a server on each of testStores
+func eachStore(t *testing.T, test func(t *testing.T, ts *testServer)) {
+	for _, kind := range testStores {
+		t.Run(kind.name, func(t *testing.T) {
+			test(t, newTestServer(t, kind.open(t)))
+		})
+	}
+}
+
+// Serve a request with a JSON body, unless body is nil, and extra headers
+// given as name, value pairs
+func (ts *testServer) request(method, path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(body)
+	default:
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			ts.t.Fatalf("encoding request body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	ts.routes.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *testServer) post(path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	return ts.request(http.MethodPost, path, body, headers...)
+}
+
+func (ts *testServer) get(path string, headers ...string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	return ts.request(http.MethodGet, path, nil, headers...)
+}
+
+// Start username's solo game and deal it deck, top first, in place of the
+// shuffled one
+func (ts *testServer) startGame(username string, deck ...string) StartGameResponse {
+	ts.t.Helper()
+	w := ts.post("/start-game", User{Username: username})
+	response := decodeOK[StartGameResponse](ts.t, w)
+	if len(deck) > 0 {
+		ts.setDeck(username, deck...)
+	}
+	return response
+}
+
+// Replace the game's deck with cards, top first
+func (ts *testServer) setDeck(gameID string, cards ...string) {
+	ts.t.Helper()
+	if err := ts.store.CreateDeck(context.Background(), gameID, cards); err != nil {
+		ts.t.Fatalf("CreateDeck: %v", err)
+	}
+}
+
+func (ts *testServer) deck(gameID string) []string {
+	ts.t.Helper()
+	deck, err := ts.store.GetDeck(context.Background(), gameID)
+	if err != nil {
+		ts.t.Fatalf("GetDeck: %v", err)
+	}
+	return deck
+}
+
+func (ts *testServer) hand(username string) []string {
+	ts.t.Helper()
+	hand, err := ts.store.GetHand(context.Background(), username)
+	if err != nil {
+		ts.t.Fatalf("GetHand: %v", err)
+	}
+	return hand
+}
+
+func (ts *testServer) stats(username string) (int64, int64) {
+	ts.t.Helper()
+	win, lose, err := ts.store.GetStats(context.Background(), username)
+	if err != nil {
+		ts.t.Fatalf("GetStats: %v", err)
+	}
+	return win, lose
+}
+
+// Draw username's next card in their solo game
+func (ts *testServer) draw(username string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	return ts.post("/draw-card", User{Username: username})
+}
+
+// Decode a 200 response's body into a T
+func decodeOK[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", w.Code, w.Body.String())
+	}
+	return decodeBody[T](t, w)
+}
+
+// Decode the body as a T. A field T doesn't have fails the test, so a
+// handler can't drift from its documented response type.
+func decodeBody[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	decoder := json.NewDecoder(bytes.NewReader(w.Body.Bytes()))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&v); err != nil {
+		t.Fatalf("decoding %T from %s: %v", v, w.Body.String(), err)
+	}
+	return v
+}
+
+// Check the response is the error with the status and code
+func assertError(t *testing.T, w *httptest.ResponseRecorder, status int, code string) *APIError {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+	body := decodeBody[ErrorResponse](t, w)
+	if body.Error == nil || body.Error.Code != code {
+		t.Fatalf("error %s, want %s", w.Body.String(), code)
+	}
+	return body.Error
+}
+
+// What faultyStore's failing calls return
+var errStoreDown = errors.New("store is down")
+
+// faultyStore is a GameStore whose calls named in fail return errStoreDown,
+// standing in for a Redis that stopped answering
+type faultyStore struct {
+	GameStore
+	mutex sync.Mutex
+	fail  map[string]bool
+}
+
+func newFaultyStore(store GameStore) *faultyStore {
+	return &faultyStore{GameStore: store, fail: make(map[string]bool)}
+}
+
+// Make the named calls fail, or all calls if none are named
+func (f *faultyStore) breakCalls(names ...string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(names) == 0 {
+		names = []string{"*"}
+	}
+	for _, name := range names {
+		f.fail[name] = true
+	}
+}
+
+func (f *faultyStore) heal() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.fail = make(map[string]bool)
+}
+
+func (f *faultyStore) failing(name string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.fail[name] || f.fail["*"]
+}
+
+func (f *faultyStore) CreateDeck(ctx context.Context, gameID string, deck []string) error {
+	if f.failing("CreateDeck") {
+		return errStoreDown
+	}
+	return f.GameStore.CreateDeck(ctx, gameID, deck)
+}
+
+func (f *faultyStore) GetDeck(ctx context.Context, gameID string) ([]string, error) {
+	if f.failing("GetDeck") {
+		return nil, errStoreDown
+	}
+	return f.GameStore.GetDeck(ctx, gameID)
+}
+
+func (f *faultyStore) GetGameStatus(ctx context.Context, gameID string) (string, error) {
+	if f.failing("GetGameStatus") {
+		return "", errStoreDown
+	}
+	return f.GameStore.GetGameStatus(ctx, gameID)
+}
+
+func (f *faultyStore) DrawState(ctx context.Context, gameID, username string) (*DrawState, error) {
+	if f.failing("DrawState") {
+		return nil, errStoreDown
+	}
+	return f.GameStore.DrawState(ctx, gameID, username)
+}
+
+func (f *faultyStore) DrawCard(ctx context.Context, gameID, username string, version int64, fromBottom bool, hold *PendingDraw) (DrawnCard, error) {
+	if f.failing("DrawCard") {
+		return DrawnCard{}, errStoreDown
+	}
+	return f.GameStore.DrawCard(ctx, gameID, username, version, fromBottom, hold)
+}
+
+func (f *faultyStore) HoldCard(ctx context.Context, username, card string) error {
+	if f.failing("HoldCard") {
+		return errStoreDown
+	}
+	return f.GameStore.HoldCard(ctx, username, card)
+}
+
+func (f *faultyStore) CompleteGame(ctx context.Context, result GameResult) (*GameCompletion, error) {
+	if f.failing("CompleteGame") {
+		return nil, errStoreDown
+	}
+	return f.GameStore.CompleteGame(ctx, result)
+}
+
+func (f *faultyStore) GetStats(ctx context.Context, username string) (int64, int64, error) {
+	if f.failing("GetStats") {
+		return 0, 0, errStoreDown
+	}
+	return f.GameStore.GetStats(ctx, username)
+}
+
+func (f *faultyStore) LeaderboardStats(ctx context.Context, pageSize int) StatsIterator {
+	if f.failing("LeaderboardStats") {
+		return failedStats{}
+	}
+	return f.GameStore.LeaderboardStats(ctx, pageSize)
+}
+
+func (f *faultyStore) Ping(ctx context.Context) error {
+	if f.failing("Ping") {
+		return errStoreDown
+	}
+	return f.GameStore.Ping(ctx)
+}
+
+// A StatsIterator whose first page fails
+type failedStats struct{}
+
+func (failedStats) Next(ctx context.Context) ([]UserStats, bool) { return nil, false }
+func (failedStats) Err() error                                   { return errStoreDown }
+
+// Run test once against each of testStores, for checks made on the store
+// itself rather than through the handlers
+func eachGameStore(t *testing.T, test func(t *testing.T, store GameStore)) {
+	for _, kind := range testStores {
+		t.Run(kind.name, func(t *testing.T) {
+			test(t, kind.open(t))
+		})
+	}
+}
+
+// How long a test waits for a WebSocket message before giving up on it
+const socketTimeout = 2 * time.Second
+
+// A WebSocket client of a test server
+type testSocket struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+// Open /ws with query on a live listener serving the test server's routes
+func (ts *testServer) dial(query string, headers ...string) *testSocket {
+	ts.t.Helper()
+	socket, status, err := ts.tryDial(query, headers...)
+	if err != nil {
+		ts.t.Fatalf("dialing /ws?%s: %v (status %d)", query, err, status)
+	}
+	return socket
+}
+
+// Open /ws with query, returning the handshake's status and error rather
+// than failing the test
+func (ts *testServer) tryDial(query string, headers ...string) (*testSocket, int, error) {
+	ts.t.Helper()
+	if ts.listener == nil {
+		ts.listener = httptest.NewServer(ts.routes)
+		ts.t.Cleanup(ts.listener.Close)
+	}
+	header := http.Header{}
+	for i := 0; i+1 < len(headers); i += 2 {
+		header.Set(headers[i], headers[i+1])
+	}
+	url := "ws" + strings.TrimPrefix(ts.listener.URL, "http") + "/ws?" + query
+	conn, response, err := websocket.DefaultDialer.Dial(url, header)
+	status := 0
+	if response != nil {
+		status = response.StatusCode
+	}
+	if err != nil {
+		return nil, status, err
+	}
+	ts.t.Cleanup(func() { conn.Close() })
+	return &testSocket{t: ts.t, conn: conn}, status, nil
+}
+
+// Send v as a JSON message
+func (s *testSocket) send(v interface{}) {
+	s.t.Helper()
+	if err := s.conn.WriteJSON(v); err != nil {
+		s.t.Fatalf("writing to socket: %v", err)
+	}
+}
+
+// The next message of the given type, skipping any others, or of any type
+// if eventType is ""
+func (s *testSocket) next(eventType string) map[string]interface{} {
+	s.t.Helper()
+	s.conn.SetReadDeadline(time.Now().Add(socketTimeout))
+	for {
+		var message map[string]interface{}
+		if err := s.conn.ReadJSON(&message); err != nil {
+			s.t.Fatalf("waiting for a %q message: %v", eventType, err)
+		}
+		if eventType == "" || message["type"] == eventType {
+			return message
+		}
+	}
+}
+
+// Say hello with the capabilities and wait for the answer, dropping what
+// the socket got before it
+func (s *testSocket) hello(capabilities ...string) {
+	s.t.Helper()
+	s.send(HelloRequest{Type: CommandHello, ProtocolVersion: protocolVersion, Capabilities: capabilities})
+	if reply := s.next("result"); reply["status"] != float64(http.StatusOK) {
+		s.t.Fatalf("hello = %v", reply)
+	}
+}
+
+// The next message of the socket, whatever its type
+func (s *testSocket) any() map[string]interface{} {
+	s.t.Helper()
+	return s.next("")
+}
+
+// Decode a message read by next into a T
+func decodeMessage[T any](t *testing.T, message map[string]interface{}) T {
+	t.Helper()
+	var v T
+	encoded, _ := json.Marshal(message)
+	if err := json.Unmarshal(encoded, &v); err != nil {
+		t.Fatalf("decoding %s: %v", encoded, err)
+	}
+	return v
+}
+
+// Open a room seating players, the first creating it, and start its game
+func (ts *testServer) openRoom(players ...string) *Room {
+	ts.t.Helper()
+	created := decodeOK[RoomResponse](ts.t, ts.post("/create-room", CreateRoomRequest{Username: players[0], Size: len(players)}))
+	for _, player := range players[1:] {
+		decodeOK[RoomResponse](ts.t, ts.post("/join-room", RoomRequest{Username: player, Code: created.Code}))
+	}
+	return ts.room(created.Code)
+}
+
+func (ts *testServer) room(code string) *Room {
+	ts.t.Helper()
+	room, err := ts.store.GetRoom(context.Background(), code)
+	if err != nil || room == nil {
+		ts.t.Fatalf("GetRoom(%s) = %v, %v", code, room, err)
+	}
+	return room
+}
+
+// Replace the player's hand with cards
+func (ts *testServer) deal(username string, cards ...string) {
+	ts.t.Helper()
+	if err := ts.store.DealHand(context.Background(), username, cards); err != nil {
+		ts.t.Fatalf("DealHand: %v", err)
+	}
+}
+
+// Play a card from username's hand in the game
+func (ts *testServer) play(username, gameID, card string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	return ts.post("/play-card", PlayCardRequest{Username: username, GameID: gameID, Card: card})
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// Moves after a Shuffle before another one takes effect, when
+// SHUFFLE_COOLDOWN isn't set. Stops players fishing for a better deck.
+const defaultShuffleCooldown = 3
+
+// Claim the game's next move for a Shuffle. Returns false while the last
+// Shuffle, drawn or played, is fewer than s.shuffleCooldown moves back.
+func (s *Server) claimShuffle(ctx context.Context, game *GameSession) (bool, *APIError) {
+	claimed, err := s.store.ClaimShuffle(ctx, game.ID, s.shuffleCooldown)
+	if err != nil {
+		log.Printf("Error checking shuffle cooldown of game %s: %v", game.ID, err)
+		return false, errStoreUnavailable("Error checking shuffle cooldown")
+	}
+	if !claimed {
+		log.Printf("Shuffle by user %s in game %s is within the cooldown", game.Username, game.ID)
+	}
+	return claimed, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// The moves a snapshot says are left before a Shuffle takes effect again
+func (ts *testServer) shuffleCooldown(username string) int64 {
+	ts.t.Helper()
+	return decodeOK[GameSnapshot](ts.t, ts.get("/game/"+username+"/snapshot?username="+username)).ShuffleCooldown
+}
+
+func TestShuffleCooldownBoundary(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice")
+		ts.setDeck("alice", "Cat", "Cat", "Cat", "Cat", "Cat", "Cat", "Cat", "Cat")
+		ts.deal("alice", "Shuffle", "Shuffle", "Shuffle")
+		if wait := ts.shuffleCooldown("alice"); wait != 0 {
+			t.Fatalf("cooldown = %d before any Shuffle", wait)
+		}
+
+		// Move 1
+		decodeOK[PlayCardResponse](t, ts.play("alice", "alice", "Shuffle"))
+		if wait := ts.shuffleCooldown("alice"); wait != defaultShuffleCooldown-1 {
+			t.Fatalf("cooldown = %d after a Shuffle, want %d", wait, defaultShuffleCooldown-1)
+		}
+
+		// Move 2. A played Shuffle in the cooldown is refused and kept.
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if wait := ts.shuffleCooldown("alice"); wait != 1 {
+			t.Fatalf("cooldown = %d a move after a Shuffle, want 1", wait)
+		}
+		assertError(t, ts.play("alice", "alice", "Shuffle"), http.StatusConflict, ErrCodeShuffleCooldown)
+		if hand := ts.hand("alice"); len(hand) != 3 {
+			t.Fatalf("hand after the refused Shuffle = %v", hand)
+		}
+
+		// Move 3. Move 4 is exactly the cooldown after move 1, so a Shuffle
+		// goes through.
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if wait := ts.shuffleCooldown("alice"); wait != 0 {
+			t.Fatalf("cooldown = %d %d moves after a Shuffle", wait, defaultShuffleCooldown-1)
+		}
+		decodeOK[PlayCardResponse](t, ts.play("alice", "alice", "Shuffle"))
+
+		// A drawn Shuffle in the cooldown is spent for nothing: the deck
+		// keeps its order and size
+		rest := []string{"Cat", "Cat", "Cat"}
+		ts.setDeck("alice", append([]string{"Shuffle"}, rest...)...)
+		drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if drawn.Card.Type != "Shuffle" || drawn.MessageID != MsgShuffleCooling {
+			t.Fatalf("draw = %+v", drawn)
+		}
+		if deck := ts.deck("alice"); !reflect.DeepEqual(deck, rest) {
+			t.Fatalf("deck after a cooling Shuffle = %v, want %v", deck, rest)
+		}
+		if hand := ts.hand("alice"); len(hand) != 3 {
+			t.Fatalf("hand after a cooling Shuffle = %v, want it not held", hand)
+		}
+	})
+}
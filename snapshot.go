@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// What one player can see of a game, as read by GameStore.GameState. The
+// order of the deck is left out on purpose.
+type GameState struct {
+	Remaining   int
+	Hand        []string
+	DefuseCount int
+	// The game hash's status; "" for a room game, whose status is the room's
+	Status string
+	// The game hash's mode; "" for a room game or one that predates modes
+	Mode string
+	// The commitment to the seed of the game's deck; "" for games that
+	// predate seeds
+	Commitment string
+	Version    int64
+	EventSeq   int64
+	// The player the game waits on to discard and why; "" when not blocked
+	MustDiscard  string
+	BlockedCause string
+	// When an undecided bomb is defused for the player; zero without one
+	BombDeadline time.Time
+	// Seq of the game's latest move, and of its latest Shuffle
+	MoveSeq        int64
+	LastShuffleSeq int64
+	// When the room's current turn times out; zero for a solo game
+	TurnDeadline time.Time
+}
+
+// Build the snapshot of the game as its player sees it
+func (s *Server) gameSnapshot(ctx context.Context, game *GameSession) (*GameSnapshot, *APIError) {
+	roomCode := ""
+	if game.Room != nil {
+		roomCode = game.Room.Code
+	}
+	state, err := s.store.GameState(ctx, game.ID, game.Username, roomCode)
+	if err != nil {
+		log.Printf("Error retrieving state of game %s: %v", game.ID, err)
+		return nil, errStoreUnavailable("Error retrieving game state")
+	}
+
+	snapshot := &GameSnapshot{
+		GameID:       game.ID,
+		Username:     game.Username,
+		Status:       state.Status,
+		Mode:         ModeClassic,
+		Remaining:    state.Remaining,
+		Hand:         cardsFor(cardTheme(ctx, game), state.Hand),
+		DefuseCount:  state.DefuseCount,
+		Version:      state.Version,
+		LastSeq:      state.EventSeq,
+		MustDiscard:  state.MustDiscard,
+		BlockedCause: state.BlockedCause,
+		Commitment:   state.Commitment,
+	}
+	if state.Mode != "" {
+		snapshot.Mode = state.Mode
+	}
+	if state.LastShuffleSeq > 0 {
+		// The next move is MoveSeq+1; a Shuffle is allowed cooldown moves on
+		if wait := state.LastShuffleSeq + int64(s.shuffleCooldown) - (state.MoveSeq + 1); wait > 0 {
+			snapshot.ShuffleCooldown = wait
+		}
+	}
+	if state.MustDiscard == game.Username {
+		snapshot.Status = GameStatusMustDiscard
+		if state.BlockedCause == blockPendingDefuse {
+			snapshot.Status = GameStatusPendingDefuse
+		}
+	}
+	if !state.BombDeadline.IsZero() {
+		deadline := state.BombDeadline.UTC()
+		snapshot.BombDeadline = &deadline
+	}
+	if game.Room == nil && state.Status == GameStatusActive {
+		game.Mode = snapshot.Mode
+		snapshot.InevitableLoss = s.lossForced(ctx, game, state.BlockedCause == blockPendingDefuse)
+	}
+	if game.Room != nil && state.MustDiscard != game.Username {
+		snapshot.Status = game.Room.Status
+		snapshot.Turn = game.Room.Turn
+		if !state.TurnDeadline.IsZero() {
+			deadline := state.TurnDeadline.UTC()
+			snapshot.TurnDeadline = &deadline
+		}
+	}
+	return snapshot, nil
+}
+
+// Game snapshot route: everything a reloaded client needs to redraw a game
+func (s *Server) getGameSnapshot(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	username := c.Query("username")
+	if !usernamePattern.MatchString(username) {
+		abortWithError(c, errInvalidUsername())
+		return
+	}
+	game, apiErr := s.resolveGame(ctx, User{Username: username, GameID: c.Param("gameId")})
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	snapshot, apiErr := s.gameSnapshot(ctx, game)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	c.JSON(http.StatusOK, snapshot)
+}
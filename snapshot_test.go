@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// Fail if a response carries a list of cards other than the hand, which is
+// how an ordered deck would leak
+func assertNoDeck(t *testing.T, body []byte) {
+	t.Helper()
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+	for name, value := range fields {
+		if strings.Contains(strings.ToLower(name), "deck") {
+			t.Errorf("response has field %s: %s", name, value)
+		}
+		if name == "snapshot" {
+			assertNoDeck(t, value)
+		} else if name != "hand" && strings.HasPrefix(string(value), "[") {
+			t.Errorf("response has list %s: %s", name, value)
+		}
+	}
+}
+
+func TestSnapshotMatchesEngineAfterDraws(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		script := []string{engine.Defuse, "Cat", "Tacocat", engine.ExplodingKitten, "Cat"}
+		w := ts.post("/start-game", User{Username: "alice"})
+		assertNoDeck(t, w.Body.Bytes())
+		ts.setDeck("alice", script...)
+
+		// The same draws, played on the engine
+		game := &engine.Game{Deck: script}
+		for i := 0; i < 3; i++ {
+			decodeOK[DrawCardResponse](t, ts.draw("alice"))
+			card := game.Deck[0]
+			game.Deck = game.Deck[1:]
+			game.Settle(card, engine.Keep, nil)
+		}
+
+		w = ts.get("/game/alice/snapshot?username=alice")
+		snapshot := decodeOK[GameSnapshot](t, w)
+		assertNoDeck(t, w.Body.Bytes())
+		if snapshot.GameID != "alice" || snapshot.Status != GameStatusActive {
+			t.Fatalf("snapshot = %+v", snapshot)
+		}
+		if snapshot.Remaining != len(game.Deck) || snapshot.DefuseCount != game.DefuseCount {
+			t.Fatalf("remaining %d with %d Defuses, engine has %d with %d", snapshot.Remaining, snapshot.DefuseCount, len(game.Deck), game.DefuseCount)
+		}
+		hand := make([]string, len(snapshot.Hand))
+		for i, card := range snapshot.Hand {
+			hand[i] = card.Type
+		}
+		if !reflect.DeepEqual(hand, game.Hand) {
+			t.Fatalf("hand = %v, engine has %v", hand, game.Hand)
+		}
+		if snapshot.LastSeq == 0 || snapshot.Version == 0 {
+			t.Fatalf("snapshot has seq %d and version %d after three draws", snapshot.LastSeq, snapshot.Version)
+		}
+
+		// Resuming gives the same snapshot, still without the deck
+		w = ts.post("/start-game", User{Username: "alice"})
+		assertNoDeck(t, w.Body.Bytes())
+		if resumed := decodeOK[StartGameResponse](t, w); !reflect.DeepEqual(resumed.Snapshot, &snapshot) {
+			t.Fatalf("resumed snapshot = %+v, want %+v", resumed.Snapshot, snapshot)
+		}
+	})
+}
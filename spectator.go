@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Spectator events only carry public game state. Private details such as the
+// player's defuse count or peeked cards must never be added here.
+type SpectatorEvent struct {
+	Type      string `json:"type"`
+	Username  string `json:"username"`
+	Card      *Card  `json:"card,omitempty"`
+	Remaining int    `json:"remaining"`
+	Result    string `json:"result,omitempty"`
+	// Achievement name, for "achievement" events
+	Name string `json:"name,omitempty"`
+	// Set on "game_over": who won and lost, and the player's new totals
+	Winner string       `json:"winner,omitempty"`
+	Loser  string       `json:"loser,omitempty"`
+	Stats  *PlayerStats `json:"stats,omitempty"`
+	// Set on "game_over" of a co-op game, whose players share the result
+	Shared bool `json:"shared,omitempty"`
+	// Set on "game_over": how the game went for the player
+	Summary *SpectatorSummary `json:"summary,omitempty"`
+	// Set on "match_found": the room the player was seated in
+	Room string `json:"room,omitempty"`
+	// Position in the player's event stream; see GET /ws?lastSeq=
+	Seq int64 `json:"seq,omitempty"`
+	// Set on "game_over": the same for every copy of it, including one the
+	// outbox sends again after an instance died, so a client drops repeats
+	EventID string `json:"eventId,omitempty"`
+}
+
+// A GameSummary as spectators get it, without the Defuses the player spent,
+// which like their defuse count are theirs to know
+type SpectatorSummary struct {
+	*GameSummary
+	// Always nil, hiding GameSummary.DefusesUsed from the encoding
+	DefusesUsed *int64 `json:"defusesUsed,omitempty"`
+}
+
+func spectatorSummary(summary *GameSummary) *SpectatorSummary {
+	if summary == nil {
+		return nil
+	}
+	return &SpectatorSummary{GameSummary: summary}
+}
+
+// Serve a WebSocket connection that watches another player's game. A
+// reconnecting spectator passes the last seq it saw to have the events it
+// missed replayed.
+func (s *Server) serveSpectator(ctx context.Context, conn *websocket.Conn, username string, lastSeq int64) {
+	defer func() {
+		s.hub.unregisterSpectator(username, conn)
+		s.hub.close(conn)
+	}()
+
+	resumed := false
+	if lastSeq == noLastSeq {
+		s.hub.registerSpectator(username, conn)
+	} else {
+		var err error
+		if resumed, err = s.hub.resumeSpectator(username, conn, lastSeq); err != nil {
+			log.Println("Error replaying spectator events:", err)
+			return
+		}
+	}
+
+	log.Printf("Spectator connected to game of user: %s", username)
+
+	// Send the public game state so the spectator can render the table immediately
+	if !resumed {
+		snapshot, err := s.spectatorSnapshot(ctx, username)
+		if err != nil {
+			log.Printf("Error building spectator snapshot for user %s: %v", username, err)
+			return
+		}
+		if err := s.hub.send(conn, snapshot); err != nil {
+			log.Println("Error sending spectator snapshot:", err)
+			return
+		}
+	}
+
+	go s.keepAlive(conn)
+
+	err := s.readCommands(ctx, conn, SubscribeRequest{Spectate: username})
+	log.Println("Spectator connection closed:", err)
+}
+
+// Build the public view of a player's game
+func (s *Server) spectatorSnapshot(ctx context.Context, username string) (SpectatorEvent, error) {
+	deck, err := s.store.GetDeck(ctx, username)
+	if err != nil {
+		return SpectatorEvent{}, err
+	}
+
+	return SpectatorEvent{
+		Type:      "snapshot",
+		Username:  username,
+		Remaining: len(deck),
+	}, nil
+}
+
+// How often sockets are pinged unless WS_PING_INTERVAL says otherwise
+const defaultPingInterval = 30 * time.Second
+
+// Ping every pingInterval to keep the connection alive
+func (s *Server) keepAlive(conn *websocket.Conn) {
+	ticker := time.NewTicker(s.pingInterval)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+			log.Println("Ping failed:", err)
+			return
+		}
+	}
+}
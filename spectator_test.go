@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// The fields a spectator event may carry; anything else could be private
+var spectatorFields = map[string]bool{
+	"type": true, "username": true, "card": true, "remaining": true, "result": true, "name": true,
+	"winner": true, "loser": true, "stats": true, "shared": true, "summary": true, "room": true,
+	"seq": true, "eventId": true,
+}
+
+func assertPublic(t *testing.T, event map[string]interface{}) {
+	t.Helper()
+	for field := range event {
+		if !spectatorFields[field] {
+			t.Fatalf("%s event has field %q: %v", event["type"], field, event)
+		}
+	}
+}
+
+func TestSpectatorsSeePublicStateOnly(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", engine.Defuse, engine.ExplodingKitten, "Cat")
+		spectator := ts.dial("spectate=alice")
+
+		snapshot := spectator.next("snapshot")
+		assertPublic(t, snapshot)
+		if snapshot["remaining"] != float64(3) {
+			t.Fatalf("snapshot = %v", snapshot)
+		}
+
+		// Drawing the Defuse tells the player how many they hold, but not
+		// the spectator
+		drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if drawn.Card.Type != engine.Defuse {
+			t.Fatalf("draw = %+v", drawn)
+		}
+		event := spectator.next("card_drawn")
+		assertPublic(t, event)
+		if card, _ := event["card"].(map[string]interface{}); card["type"] != engine.Defuse {
+			t.Fatalf("card_drawn = %v", event)
+		}
+
+		// Spend the Defuse, then play on until the game is over
+		useDefuse := true
+		for drawn.GameStatus != GameStatusWon && drawn.GameStatus != GameStatusLost {
+			if drawn.GameStatus == GameStatusPendingDefuse {
+				drawn = decodeOK[DrawCardResponse](t, ts.post("/resolve-bomb", ResolveBombRequest{Username: "alice", UseDefuse: &useDefuse}))
+				continue
+			}
+			drawn = decodeOK[DrawCardResponse](t, ts.draw("alice"))
+			// A bomb's reveal holds back what follows it
+			ts.clock.Advance(ts.revealDelay)
+			assertPublic(t, spectator.next("card_drawn"))
+		}
+		if drawn.Summary == nil || drawn.Summary.DefusesUsed != 1 {
+			t.Fatalf("player's summary = %+v, want one Defuse used", drawn.Summary)
+		}
+
+		over := spectator.next("game_over")
+		assertPublic(t, over)
+		summary, _ := over["summary"].(map[string]interface{})
+		if summary == nil || summary["totalDraws"] == nil {
+			t.Fatalf("game_over = %v, want a summary", over)
+		}
+		if _, ok := summary["defusesUsed"]; ok {
+			t.Fatalf("spectator's summary has defusesUsed: %v", summary)
+		}
+	})
+}
+
+func TestSpectatorSnapshotOfMissingGame(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		spectator := ts.dial("spectate=nobody")
+		snapshot := spectator.next("snapshot")
+		assertPublic(t, snapshot)
+		if snapshot["remaining"] != float64(0) {
+			t.Fatalf("snapshot = %v", snapshot)
+		}
+	})
+}
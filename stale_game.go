@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// What became of solo games found on resume to have been dealt from a deck
+// composition the server no longer deals
+const (
+	// The game only lacked a recorded composition and its deck fits the
+	// current one, which is now recorded
+	StaleGameMigrated = "migrated"
+	// The game was thrown away and a new one started
+	StaleGameDiscarded = "discarded"
+)
+
+// The composition as recorded with a game: each card type and its count,
+// in order, e.g. "Cat:2,Defuse:1,Shuffle:1,Exploding Kitten:1"
+func (cfg DeckConfig) fingerprint() string {
+	counts := make([]string, len(cfg.Cards))
+	for i, card := range cfg.Cards {
+		counts[i] = fmt.Sprintf("%s:%d", card.Type, card.Count)
+	}
+	return strings.Join(counts, ",")
+}
+
+// Whether deck could be what is left of a deck of the composition: no card
+// it doesn't have and no more of any than it has
+func (cfg DeckConfig) holds(deck []string) bool {
+	if len(deck) > cfg.Size {
+		return false
+	}
+	left := make(map[string]int, len(cfg.Cards))
+	for _, card := range cfg.Cards {
+		left[card.Type] = card.Count
+	}
+	for _, card := range deck {
+		if left[card] == 0 {
+			return false
+		}
+		left[card]--
+	}
+	return true
+}
+
+// Whether the solo game in progress with the deck can be resumed under the
+// composition its mode is dealt now. One dealt from another composition is
+// stale: its odds and endings no longer follow the rules. A game from before
+// compositions were recorded is kept if its deck fits the current one, and
+// has it recorded; otherwise it is stale too.
+func (s *Server) resumable(ctx context.Context, game *GameSession, deck []string) (bool, *APIError) {
+	if apiErr := s.loadMode(ctx, game); apiErr != nil {
+		return false, apiErr
+	}
+	recorded, err := s.store.DeckConfig(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error retrieving deck composition of game %s: %v", game.ID, err)
+		return false, errStoreUnavailable("Error checking existing deck")
+	}
+
+	cfg := s.soloDeckFor(game.Mode)
+	current := cfg.fingerprint()
+	if recorded == current && cfg.holds(deck) {
+		return true, nil
+	}
+	if recorded == "" && cfg.holds(deck) {
+		if err := s.store.SetDeckConfig(ctx, game.ID, current); err != nil {
+			log.Printf("Error recording deck composition of game %s: %v", game.ID, err)
+			return false, errStoreUnavailable("Error checking existing deck")
+		}
+		log.Printf("Recorded the deck composition of game %s, which predates it", game.ID)
+		staleGamesTotal.WithLabelValues(StaleGameMigrated).Inc()
+		return true, nil
+	}
+
+	log.Printf("Discarding stale game %s: dealt from %q with %d cards left, now dealt from %q", game.ID, recorded, len(deck), current)
+	staleGamesTotal.WithLabelValues(StaleGameDiscarded).Inc()
+	return false, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"exploding-kitten/engine"
+)
+
+// The solo deck after the composition changed: twice the cards, two bombs
+const newerSoloDeck = "Cat=6,Defuse=1,Shuffle=1,Exploding Kitten=2"
+
+func TestStaleSoloGameIsDiscardedOnResume(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		old := newTestServer(t, store)
+		old.startGame("alice")
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{"SOLO_DECK": newerSoloDeck}))
+		capture := captureLog(t)
+		discarded := testutil.ToFloat64(staleGamesTotal.WithLabelValues(StaleGameDiscarded))
+
+		// Dealt from the five cards the server dealt before
+		started := decodeOK[StartGameResponse](t, ts.post("/start-game", User{Username: "alice"}))
+		if !started.StaleGameDiscarded || started.Snapshot.Remaining != 10 {
+			t.Fatalf("start-game over a game of the old deck = %+v, %d cards", started, started.Snapshot.Remaining)
+		}
+		if counts := countCards(ts.deck("alice")); counts[engine.ExplodingKitten] != 2 {
+			t.Fatalf("new deck = %v", ts.deck("alice"))
+		}
+		if recorded, _ := ts.store.DeckConfig(ctx, "alice"); recorded != ts.soloDeck.fingerprint() {
+			t.Fatalf("recorded composition = %q, want %q", recorded, ts.soloDeck.fingerprint())
+		}
+
+		// A legacy game from before compositions were recorded, with more
+		// bombs than the deck has now
+		ts.startGame("bob", "Cat", engine.ExplodingKitten, engine.ExplodingKitten, engine.ExplodingKitten, "Cat")
+		ts.store.SetDeckConfig(ctx, "bob", "")
+		if started := decodeOK[StartGameResponse](t, ts.post("/start-game", User{Username: "bob"})); !started.StaleGameDiscarded || started.Snapshot.Remaining != 10 {
+			t.Fatalf("start-game over a legacy game = %+v", started)
+		}
+
+		if got := testutil.ToFloat64(staleGamesTotal.WithLabelValues(StaleGameDiscarded)) - discarded; got != 2 {
+			t.Fatalf("counted %v discarded games, want 2", got)
+		}
+		for _, game := range []string{"alice", "bob"} {
+			if !strings.Contains(capture.String(), "Discarding stale game "+game+":") {
+				t.Fatalf("no discard logged for %s:\n%s", game, capture)
+			}
+		}
+		// The new games resume as they are
+		if started := decodeOK[StartGameResponse](t, ts.post("/start-game", User{Username: "alice"})); started.StaleGameDiscarded || started.Message != "Resuming game" {
+			t.Fatalf("start-game over the new game = %+v", started)
+		}
+	})
+}
+
+func TestUnrecordedGameThatFitsIsMigrated(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		migrated := testutil.ToFloat64(staleGamesTotal.WithLabelValues(StaleGameMigrated))
+		ts.startGame("alice", "Cat", "Shuffle", engine.ExplodingKitten)
+		ts.store.SetDeckConfig(ctx, "alice", "")
+
+		started := decodeOK[StartGameResponse](t, ts.post("/start-game", User{Username: "alice"}))
+		if started.StaleGameDiscarded || started.Message != "Resuming game" || started.Snapshot.Remaining != 3 {
+			t.Fatalf("start-game over an unrecorded game = %+v", started)
+		}
+		if recorded, _ := ts.store.DeckConfig(ctx, "alice"); recorded != ts.soloDeck.fingerprint() {
+			t.Fatalf("recorded composition = %q", recorded)
+		}
+		if got := testutil.ToFloat64(staleGamesTotal.WithLabelValues(StaleGameMigrated)) - migrated; got != 1 {
+			t.Fatalf("counted %v migrated games, want 1", got)
+		}
+	})
+}
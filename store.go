@@ -0,0 +1,3465 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// GameStore is the persistence layer used by the handlers. Every read or write
+// of game state goes through it so handlers never talk to Redis directly.
+type GameStore interface {
+	// Replace the game's deck with the given cards, top first. A solo game's ID
+	// is the player's username; a room's is "room:" + its code.
+	CreateDeck(ctx context.Context, gameID string, deck []string) error
+	// Delete the game's deck, game hash and event history
+	DeleteDeck(ctx context.Context, gameID string) error
+	// Return the game's remaining deck, top first
+	GetDeck(ctx context.Context, gameID string) ([]string, error)
+	// Put a card back into the game's deck at position from the top, or at the
+	// bottom if the deck is shorter
+	InsertCard(ctx context.Context, gameID, card string, position int) error
+	// Return the game's version, bumped by every draw, new deck and status change
+	GameVersion(ctx context.Context, gameID string) (int64, error)
+	// Atomically draw the top (or bottom) card for the user and return it
+	// with what is left of the deck, counting it among their draws in the
+	// game hash. The card is "" when the deck is empty. Decks created before
+	// the ordered deck model are drawn from at random. Returns
+	// errVersionConflict if the game is no longer at version. With hold, the
+	// card is also kept as the game's pending draw until ConfirmDraw, or
+	// until ReturnPendingDraws puts it back.
+	DrawCard(ctx context.Context, gameID, username string, version int64, fromBottom bool, hold *PendingDraw) (DrawnCard, error)
+	// Forget the game's pending draw, its player having seen the card
+	ConfirmDraw(ctx context.Context, gameID string) error
+	// Atomically undo each pending draw made before the given time that
+	// nothing has happened in its game since: the card leaves the player's
+	// hand for the top of the deck, and the idempotency key of the request
+	// that drew it is dropped. Returns the draws undone.
+	ReturnPendingDraws(ctx context.Context, before time.Time) ([]PendingDraw, error)
+	// Draw up to one card per roll from the top for the user and settle
+	// them: cards are held, and a bomb spends a held Defuse if there is one
+	// and goes back into the deck at its roll modulo the deck's size plus
+	// one, counted from the top. Stops after a bomb, a Shuffle, or once only
+	// bombs are left. The draws are atomic; the hand may be updated just
+	// after. Returns the draws and the cards left, or errVersionConflict
+	// like DrawCard.
+	DrawCards(ctx context.Context, gameID, username string, version int64, rolls []int64) ([]BatchDraw, int, error)
+	// Return the game's status from the game hash: GameStatusActive, or how
+	// it ended. Games that predate the field report "".
+	GetGameStatus(ctx context.Context, gameID string) (string, error)
+	SetGameStatus(ctx context.Context, gameID, status string) error
+	// Count another game played under gameID and return the new count
+	IncrGamesPlayed(ctx context.Context, gameID string) (int64, error)
+	// Record when the game started and reset its count of cards drawn, its
+	// players' counters and their summaries
+	MarkGameStarted(ctx context.Context, gameID string, at time.Time) error
+	// Record when the game ended, so SweepFinishedGames can drop it once it
+	// is past retention. A new start clears it.
+	MarkGameFinished(ctx context.Context, gameID string, at time.Time) error
+	// Return when the game started (zero if unknown) and the cards drawn since
+	GameProgress(ctx context.Context, gameID string) (time.Time, int64, error)
+	// Return what the player can see of the game, read in one round trip.
+	// roomCode is "" for a solo game.
+	GameState(ctx context.Context, gameID, username, roomCode string) (*GameState, error)
+	// Return what a draw checks before it takes a card, read in one round
+	// trip
+	DrawState(ctx context.Context, gameID, username string) (*DrawState, error)
+	// Block the game's draws until the player discards, recording why in the
+	// game hash
+	SetDiscardBlock(ctx context.Context, gameID, username, cause string) error
+	ClearDiscardBlock(ctx context.Context, gameID string) error
+	// Return the player the game waits on to discard and why, or "" if it
+	// isn't blocked
+	DiscardBlock(ctx context.Context, gameID string) (string, string, error)
+	// Block the game until the player decides about the bomb they drew, by
+	// deadline, recording it like a discard block with cause
+	// blockPendingDefuse
+	SetPendingBomb(ctx context.Context, gameID, username string, deadline time.Time) error
+	// Return the player the game waits on to decide about a bomb and their
+	// deadline, or "" if there is none
+	PendingBomb(ctx context.Context, gameID string) (string, time.Time, error)
+	// Atomically unblock the game if it waits on the player's bomb. Returns
+	// false if it doesn't, so a bomb is only ever settled once.
+	ClaimPendingBomb(ctx context.Context, gameID, username string) (bool, error)
+	// Record a Shuffle as the game's next move unless it comes within
+	// cooldown moves of the last one. Returns false if it does.
+	ClaimShuffle(ctx context.Context, gameID string, cooldown int) (bool, error)
+	// Record the mode of a solo game, which its draws follow
+	SetGameMode(ctx context.Context, gameID, mode string) error
+	// Return the game's mode, ModeClassic for games that predate the field
+	GameMode(ctx context.Context, gameID string) (string, error)
+	// Record the composition the game's deck was dealt from, as
+	// DeckConfig.fingerprint gives it
+	SetDeckConfig(ctx context.Context, gameID, config string) error
+	// Return the recorded deck composition, "" for games that predate it
+	DeckConfig(ctx context.Context, gameID string) (string, error)
+	// Keep the hex seed of the game's deck, hidden until the game is over,
+	// and the commitment to it published when the game starts
+	SetGameSeed(ctx context.Context, gameID, seed, commitment string) error
+	// Return the game's seed and commitment, "" for games that predate them
+	GameSeed(ctx context.Context, gameID string) (string, string, error)
+	// Keep the hex state of the game's seeded source, as its last random
+	// choice left it
+	SetGameRNG(ctx context.Context, gameID, state string) error
+	// Return the state SetGameRNG kept, "" for games that predate it
+	GameRNG(ctx context.Context, gameID string) (string, error)
+	// Set the draws an Attack left the player owing in the game, 0 for
+	// none. A new game drops them with the other player fields.
+	SetPendingDraws(ctx context.Context, gameID, username string, count int) error
+	// Return the draws the player owes, 0 if none
+	PendingDraws(ctx context.Context, gameID, username string) (int, error)
+	// Atomically take draws off what the player owes, not going below 0,
+	// and return what is left
+	PayPendingDraws(ctx context.Context, gameID, username string, draws int) (int, error)
+	// Return every field of the game hash, for debugging and the players'
+	// counters
+	GetGameHash(ctx context.Context, gameID string) (map[string]string, error)
+	// Keep the player's encoded GameSummary of the game in its game hash,
+	// next to its move log, until the next game starts
+	SaveGameSummary(ctx context.Context, gameID, username string, summary []byte) error
+	// Atomically upgrade the stored game to currentGameSchema through
+	// gameMigrations, along with the user hash of a solo game's player, and
+	// return the version it was at: 0 if nothing is stored. Returns a
+	// *gameSchemaError, changing nothing, for a schema newer than this
+	// server's.
+	MigrateGame(ctx context.Context, gameID, username string) (int, error)
+
+	GetDefuse(ctx context.Context, username string) (int, error)
+	SetDefuse(ctx context.Context, username string, count int) error
+
+	// Return the cards held in the user's hand
+	GetHand(ctx context.Context, username string) ([]string, error)
+	// Add a card to the user's hand. Holding a Defuse also bumps the defuse count.
+	HoldCard(ctx context.Context, username string, card string) error
+	// Remove one copy of a card from the user's hand. Returns false if it wasn't held.
+	RemoveFromHand(ctx context.Context, username string, card string) (bool, error)
+	// Spend one held Defuse on a bomb drawn in the game, counting it in the
+	// game hash, and return how many remain
+	UseDefuse(ctx context.Context, gameID, username string) (int, error)
+	// Empty the user's hand and reset the defuse count
+	ClearHand(ctx context.Context, username string) error
+	// Replace the user's hand with cards, counting the Defuses among them
+	DealHand(ctx context.Context, username string, cards []string) error
+	// Move a random card from one hand to another: it leaves the source hand
+	// atomically, then joins the other. Returns "" when the source hand is
+	// empty.
+	TakeRandomCard(ctx context.Context, from, to string) (string, error)
+	// Spend two of the thief's card and move a random card from the
+	// victim's hand to the thief's. Returns errPairMissing or
+	// errHandIsEmpty, leaving the hands as they were, if either can't cover
+	// it.
+	StealWithPair(ctx context.Context, thief, victim, card string) (string, error)
+
+	// Create a room owned by the given player. Returns false if the code is taken.
+	CreateRoom(ctx context.Context, code string, owner string) (bool, error)
+	// Return the room, or nil if it doesn't exist
+	GetRoom(ctx context.Context, code string) (*Room, error)
+	// Atomically add a player to a waiting room and return the updated room
+	JoinRoom(ctx context.Context, code string, username string) (*Room, error)
+	// Persist the room's turn, status, bot difficulty, turn timeout, size and
+	// mode
+	UpdateRoom(ctx context.Context, room *Room) error
+	// Atomically add the player to the end of the room's elimination order,
+	// unless they are already in it, and return the order
+	EliminatePlayer(ctx context.Context, code, username string) ([]string, error)
+	// Atomically bump the room's turn version if it still equals version.
+	// Returns false if another move got there first.
+	ClaimTurn(ctx context.Context, code string, version int64) (bool, error)
+	// Atomically pause the room's game unless it is already paused. Returns
+	// false if it is.
+	PauseRoom(ctx context.Context, code string, pause RoomPause) (bool, error)
+	// Atomically end the room's pause, adding the time since it began, up to
+	// at, to the room's paused time. Returns the pause ended, nil if the game
+	// wasn't paused.
+	ResumeRoom(ctx context.Context, code string, at time.Time) (*RoomPause, error)
+	// Save the cards the room plays without
+	SetDisabledCards(ctx context.Context, code string, cards []string) error
+	// Mark the room as seating a match of the tournament
+	SetRoomTournament(ctx context.Context, code, id string) error
+	// Save a new tournament, kept for ttl
+	CreateTournament(ctx context.Context, tournament *Tournament, ttl time.Duration) error
+	// Return the tournament, or nil if it doesn't exist
+	GetTournament(ctx context.Context, id string) (*Tournament, error)
+	// Atomically apply update to the tournament and save the result, unless
+	// update returns an error, which is returned as is. update may be called
+	// more than once. Returns errNoTournament if there is no such
+	// tournament.
+	UpdateTournament(ctx context.Context, id string, update func(*Tournament) error) (*Tournament, error)
+	// Put the user in the matchmaking queue, keeping their place if they are
+	// already in it
+	EnqueueMatch(ctx context.Context, username string, at time.Time) error
+	// Return the user's 1-based place in the matchmaking queue, or 0
+	MatchQueuePosition(ctx context.Context, username string) (int64, error)
+	// Take the user out of the matchmaking queue. Returns false if they
+	// weren't in it.
+	LeaveMatchQueue(ctx context.Context, username string) (bool, error)
+	// Atomically take the two longest-waiting users off the matchmaking
+	// queue. Returns nil, leaving the queue alone, while fewer are waiting.
+	PopMatch(ctx context.Context) ([]string, error)
+	// Cancel every invite to the room issued up to at, forgetting
+	// revocations older than keep
+	RevokeInvites(ctx context.Context, code string, at time.Time, keep time.Duration) error
+	// Return when the room's invites were last revoked, or zero
+	InvitesRevokedAt(ctx context.Context, code string) (time.Time, error)
+	// Return every room with a game in progress
+	ActiveRooms(ctx context.Context) ([]*Room, error)
+	// Insert the card into the deck of the room's game at depth, from 0 for
+	// the top to 1 for the bottom, reading the deck's length and the room's
+	// status in the same step. Returns the deck's new length, or false if
+	// the room has no game in progress.
+	InjectCard(ctx context.Context, code, card string, depth float64) (int, bool, error)
+	// Save when the room's current turn times out
+	SetTurnDeadline(ctx context.Context, code string, deadline time.Time) error
+	// Save the action waiting out its Nope window, or clear it when nil
+	SetPendingAction(ctx context.Context, code string, pending *PendingState) error
+	// Return the room's saved turn deadline and pending action
+	GetRoomState(ctx context.Context, code string) (*RoomState, error)
+	// Save how the room makes up for going first, BalanceNone or
+	// BalanceBalanced
+	SetBalanceMode(ctx context.Context, code, mode string) error
+	// Save who was drawn to go first when the room's game started
+	SetFirstPlayer(ctx context.Context, code, username string) error
+	DeleteRoomState(ctx context.Context, code string) error
+
+	// Reset the user's win/lose counters to zero, appending audit to the
+	// audit log with the counts before and after in the same write
+	ResetStats(ctx context.Context, username string, audit AuditEntry) error
+	// Return the user's win and lose counts
+	GetStats(ctx context.Context, username string) (int64, int64, error)
+	// Overwrite the user's win and lose counts, appending audit to the audit
+	// log with the counts before and after in the same write. Returns the
+	// counts before.
+	SetStats(ctx context.Context, username string, win, lose int64, audit AuditEntry) (*PlayerStats, error)
+	// Atomically end a running game and credit its players: set the game's
+	// (or the room's) status, add the win and loss, and extend the winner's
+	// streak while resetting the loser's. A co-op partner is credited as the
+	// winner if there is one, and as the loser otherwise. Each credit is
+	// appended to the audit log as result.Audit, with action "win" or
+	// "lose". The game is counted in the daily stats of result.Day. A game
+	// that has already ended is left alone and reported with Completed
+	// false, so only one call credits it.
+	//
+	// A result with an Announcement appends it to the outbox and reports its
+	// ID as the completion's OutboxID. One with HeadToHead counts the game
+	// toward the head-to-head record of its winner and loser.
+	//
+	// Against a Redis Cluster only ending the game is atomic, along with
+	// recording the rest in the game's slot: the other writes follow it,
+	// slot by slot, and a failure part way leaves them to FinishCompletions.
+	CompleteGame(ctx context.Context, result GameResult) (*GameCompletion, error)
+	// Make the writes left unmade by a failure part way through completing
+	// a game on a Redis Cluster, for every game that ended before
+	// endedBefore, making none of them twice. Returns how many games it
+	// finished. Elsewhere a completion is never left part way, and there is
+	// nothing to do.
+	FinishCompletions(ctx context.Context, endedBefore time.Time) (int, error)
+	// Hand the consumer up to count outbox entries no one has acknowledged
+	// within idle of them being handed out, taking them over from whoever
+	// had them.
+	// An entry is handed to one caller at a time, and again only once idle
+	// has passed without an AckOutbox.
+	ClaimOutbox(ctx context.Context, consumer string, idle time.Duration, count int) ([]OutboxEntry, error)
+	// Drop an outbox entry whose announcement has been sent
+	AckOutbox(ctx context.Context, id string) error
+	// Return the head-to-head record of two players, a's side first; players
+	// who never met have a record of zero games
+	HeadToHead(ctx context.Context, a, b string) (HeadToHeadRecord, error)
+	// Return the counts of the games completed on each UTC day, "2006-01-02",
+	// in the order given; a day without games has zero counts
+	DailyStats(ctx context.Context, days []string) ([]DailyStats, error)
+	// Record an achievement unless the user already has it. Returns true if
+	// it is new.
+	AwardAchievement(ctx context.Context, username, name string, at time.Time) (bool, error)
+	// Return the user's achievements, oldest first
+	Achievements(ctx context.Context, username string) ([]Achievement, error)
+	// Page through every user's all-time win/lose counts, pageSize users at
+	// a time
+	LeaderboardStats(ctx context.Context, pageSize int) StatsIterator
+	// Count a finished game in a time-bucketed leaderboard that expires
+	// after ttl
+	RecordWindowResult(ctx context.Context, bucket, username string, isWin bool, ttl time.Duration) error
+	// Return the win/lose counts of a time-bucketed leaderboard
+	WindowLeaderboard(ctx context.Context, bucket string) ([]UserStats, error)
+	// Keep the score of a survival run if it beats the user's best, and
+	// return the best
+	RecordSurvivalScore(ctx context.Context, username string, score int64) (int64, error)
+	// Return up to limit best survival runs after the first offset, highest
+	// first and ranked
+	SurvivalLeaderboard(ctx context.Context, offset, limit int) ([]SurvivalEntry, error)
+
+	// Append an entry to the audit log, which is capped
+	AppendAudit(ctx context.Context, entry AuditEntry) error
+	// Return up to limit audit entries after since (from the start if nil),
+	// oldest first, with their IDs
+	AuditLog(ctx context.Context, since *streamID, limit int) ([]AuditEntry, error)
+
+	// Reserve the next sequence number of an event stream: a game's ID, or a
+	// username for the events spectators see
+	NextEventSeq(ctx context.Context, stream string) (int64, error)
+	// Append an encoded event to the stream's capped history
+	AppendEvent(ctx context.Context, stream string, event []byte) error
+	// Return the stream's retained events, oldest first, and its latest
+	// sequence number
+	EventHistory(ctx context.Context, stream string) ([][]byte, int64, error)
+
+	// Reserve the next sequence number of a game's move log
+	NextMoveSeq(ctx context.Context, gameID string) (int64, error)
+	// Append an encoded move to the game's log. The log is capped and expires
+	// like event history.
+	AppendMove(ctx context.Context, gameID string, move []byte) error
+	// Return the game's logged moves, oldest first
+	Moves(ctx context.Context, gameID string) ([][]byte, error)
+	// Append an encoded chat message to the room's history, keeping the last
+	// limit
+	AppendChat(ctx context.Context, code string, message []byte, limit int64) error
+	// Return the room's chat history, oldest first
+	RoomChat(ctx context.Context, code string) ([][]byte, error)
+
+	// Delete the deck, events and moves of every game marked finished before
+	// the given time, and the room and room state of a room game. A solo
+	// game keeps its hash, which holds gamesPlayed. Returns the game IDs.
+	SweepFinishedGames(ctx context.Context, before time.Time) ([]string, error)
+	// Count the stored keys by keyPattern, measuring the memory of up to
+	// samples keys of each pattern
+	StorageUsage(ctx context.Context, samples int) ([]KeyUsage, error)
+	// Look for the corruptions bad deploys have left behind, repairing those
+	// that are safe to and counting everything in report. Keys are read at
+	// no more than about consistencyScanBatch per consistencyScanPause.
+	CheckConsistency(ctx context.Context, report *ConsistencyReport) error
+
+	// Claim an idempotency key for a game. Returns false if it was already claimed.
+	ClaimIdempotencyKey(ctx context.Context, gameID, key string, ttl time.Duration) (bool, error)
+	// Return the response saved under a claimed key, or nil while the request
+	// that claimed it is still running
+	GetIdempotentResponse(ctx context.Context, gameID, key string) ([]byte, error)
+	// Save the response for a claimed key
+	SaveIdempotentResponse(ctx context.Context, gameID, key string, response []byte, ttl time.Duration) error
+	// Give up a claimed key so the request can be retried
+	ReleaseIdempotencyKey(ctx context.Context, gameID, key string) error
+
+	// Whether anything is stored under the username: stats or game state
+	UserExists(ctx context.Context, username string) (bool, error)
+	// Create a guest's records. Returns false if the name is taken.
+	CreateGuest(ctx context.Context, username string) (bool, error)
+	// Return the guests that haven't claimed a permanent name
+	Guests(ctx context.Context) (map[string]bool, error)
+	// Count a game the user finished at the given time and return how many
+	// they finished in the window up to it, forgetting older ones
+	RecordFinish(ctx context.Context, username string, at time.Time, window time.Duration) (int64, error)
+	// Flag the user as a suspected cheat, keeping the reason of an earlier
+	// flag. Returns false if they were already flagged.
+	FlagUser(ctx context.Context, username, reason string) (bool, error)
+	// Clear the user's flag. Returns false if they weren't flagged.
+	UnflagUser(ctx context.Context, username string) (bool, error)
+	// Return the flagged users with the reason each was flagged
+	FlaggedUsers(ctx context.Context) (map[string]string, error)
+	// Atomically move everything stored under one username to another,
+	// cheat flag included, and drop the guest flag. Returns
+	// errUsernameTaken if to is in use. On a cluster the keys move one at a
+	// time.
+	RenameUser(ctx context.Context, from, to string) error
+	// Delete everything stored under the username: the keys of keyBuilder.userKeys, the
+	// daily counts of games started, the win/lose counts, the windowed
+	// leaderboards, presence, the guest flag and any cheat flag.
+	// Returns the keys that held something. Sessions are left to expire.
+	DeleteUser(ctx context.Context, username string) ([]string, error)
+	// Save the user's display name and avatar in their profile hash
+	SetProfile(ctx context.Context, username string, profile Profile) error
+	// Return the saved profiles of the users, read in one round trip. Users
+	// without one are left out.
+	Profiles(ctx context.Context, usernames []string) (map[string]Profile, error)
+	// Write generated users for load testing, in pipelined batches rather
+	// than one transaction, and add each to the seeded set
+	SeedUsers(ctx context.Context, users []SeedUser) error
+	// Delete every user in the seeded set like DeleteUser, leaving the
+	// windowed leaderboards to expire, then the set itself. Returns how many
+	// users were deleted.
+	DeleteSeeded(ctx context.Context) (int, error)
+	// Save the API key under its ID
+	SaveAPIKey(ctx context.Context, key APIKey) error
+	// Return the API key with the ID, or nil if there is none
+	APIKey(ctx context.Context, id string) (*APIKey, error)
+	// Return every API key
+	APIKeys(ctx context.Context) ([]APIKey, error)
+	// Delete the API key. Returns false if there was none.
+	RevokeAPIKey(ctx context.Context, id string) (bool, error)
+	// Record that the user was seen at the given time in the "online" sorted
+	// set. Returns true if they weren't in it.
+	TouchPresence(ctx context.Context, username string, at time.Time) (bool, error)
+	// Return the users seen since the given time, most recent first
+	OnlineUsers(ctx context.Context, since time.Time) ([]string, error)
+	// Atomically remove and return the users last seen before the given time
+	ExpirePresence(ctx context.Context, before time.Time) ([]string, error)
+
+	// Atomically take the player's lock on the game for the device, or
+	// refresh it if the device already holds it, for ttl. Returns "" once
+	// the device holds it, or the device that does and how long it has left.
+	AcquireGameLock(ctx context.Context, gameID, username, deviceID string, ttl time.Duration) (string, time.Duration, error)
+	// Give the player's lock on the game to the device for ttl, whoever
+	// holds it. Returns the device that held it, "" if none did.
+	TakeOverGameLock(ctx context.Context, gameID, username, deviceID string, ttl time.Duration) (string, error)
+	// Take the lock on creating the user's solo game for ttl, held with the
+	// caller's random token. Returns false if another request holds it.
+	LockGameCreation(ctx context.Context, username, token string, ttl time.Duration) (bool, error)
+	// Release the lock on creating the user's solo game if token still
+	// holds it, and not another request that took it once it lapsed
+	UnlockGameCreation(ctx context.Context, username, token string) error
+
+	// Atomically check the user's caps and, if the game fits under them,
+	// add it to their active games and count it among those they started on
+	// day. Caps the user has of their own, from SetGameLimits, replace
+	// maxActive and daily. A game already active takes no new slot, and
+	// games whose hash is gone are dropped first. The started count expires
+	// after ttl.
+	ClaimGameSlot(ctx context.Context, username, gameID, day string, maxActive, daily int64, ttl time.Duration) (*GameSlot, error)
+	// Add a game to each user's active games without checking their caps
+	TrackActiveGame(ctx context.Context, gameID string, usernames []string) error
+	// Remove a game that ended from each user's active games
+	ReleaseActiveGame(ctx context.Context, gameID string, usernames []string) error
+	// Return the user's own caps, zero where they have the server's
+	GameLimits(ctx context.Context, username string) (GameLimits, error)
+	// Give the user caps of their own; a zero cap reverts to the server's
+	SetGameLimits(ctx context.Context, username string, limits GameLimits) error
+
+	// Point a session token at a username
+	CreateSession(ctx context.Context, token, username string, ttl time.Duration) error
+	// Return the username a session token belongs to, or "" if it is unknown
+	SessionUser(ctx context.Context, token string) (string, error)
+	DeleteSession(ctx context.Context, token string) error
+
+	Ping(ctx context.Context) error
+}
+
+// Deck format recorded in the game hash. Decks without a deckVersion predate
+// ordered decks and keep the old random-draw behavior.
+const (
+	legacyDeckVersion  = 1
+	orderedDeckVersion = 2
+)
+
+// How much of an event stream is kept for replay after a reconnect by
+// default. Move logs are kept the same way; see retentionPolicy.
+const (
+	eventHistoryLength = 200
+	eventHistoryTTL    = 10 * time.Minute
+)
+
+// Number of times an optimistic transaction is retried on conflict
+const txRetries = 5
+
+// Errors returned by the store that handlers map to API errors
+var (
+	errNoSuchRoom    = errors.New("room not found")
+	errRoomIsFull    = errors.New("room is full")
+	errUsernameTaken = errors.New("username is taken")
+	errPairMissing   = errors.New("pair not in hand")
+	errHandIsEmpty   = errors.New("hand is empty")
+	errNoTournament  = errors.New("tournament not found")
+	// The game's version moved on between reading and changing it
+	errVersionConflict = errors.New("game version conflict")
+)
+
+const (
+	winKey   = "win"
+	loseKey  = "lose"
+	auditKey = "audit"
+	// Set of usernames created by POST /guest
+	guestsKey = "guests"
+	// Hash of suspected cheats to the reason they were flagged
+	flaggedKey = "flagged"
+	// Set of usernames generated by POST /debug/seed
+	seededKey = "seeded"
+	// Hash of API key IDs to their APIKey as JSON
+	apiKeysKey = "apikeys"
+	// Sorted set of usernames scored by when they were last seen, in unix ms
+	onlineKey = "online"
+	// Sorted set of usernames waiting for a match, scored by when they
+	// joined, in unix ms
+	matchQueueKey = "matchmaking"
+	// Sorted set of usernames scored by their best survival run
+	survivalKey = "leaderboard:survival"
+	// Sorted set of room codes scored by when their invites were last
+	// revoked, in unix ms
+	revokedInvitesKey = "invites:revoked"
+	// Stream of game_over announcements not yet known to be sent; see
+	// GameStore.ClaimOutbox
+	outboxKey = "outbox"
+)
+
+var _ GameStore = (*redisStore)(nil)
+
+// redisStore is the production GameStore backed by Redis
+type redisStore struct {
+	// A *redis.Client, or a *redis.ClusterClient when keys.cluster is set
+	rdb       redis.UniversalClient
+	retention retentionPolicy
+	keys      keyBuilder
+}
+
+func newRedisStore(rdb redis.UniversalClient) *redisStore {
+	return &redisStore{rdb: rdb, retention: defaultRetention}
+}
+
+// A pipeline for commands on keys in more than one slot: a transaction on a
+// single node, but on a cluster, which can't run one across slots, a plain
+// pipeline whose commands each go to their own slot
+func (s *redisStore) multiSlotPipeline() redis.Pipeliner {
+	if s.keys.cluster {
+		return s.rdb.Pipeline()
+	}
+	return s.rdb.TxPipeline()
+}
+
+// Call fn with each key matching pattern, one key at a time. A cluster's
+// keys are spread over its masters, so each master is scanned.
+func (s *redisStore) scanKeys(ctx context.Context, pattern string, count int64, fn func(key string) error) error {
+	cluster, ok := s.rdb.(*redis.ClusterClient)
+	if !ok {
+		iter := s.rdb.Scan(ctx, 0, pattern, count).Iterator()
+		for iter.Next(ctx) {
+			if err := fn(iter.Val()); err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
+
+	// ForEachMaster scans the masters at once
+	var mutex sync.Mutex
+	return cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		iter := master.Scan(ctx, 0, pattern, count).Iterator()
+		for iter.Next(ctx) {
+			mutex.Lock()
+			err := fn(iter.Val())
+			mutex.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	})
+}
+
+// RPUSH entry and trim the list to its newest limit entries, refreshing its
+// TTL unless ttl is 0. Every append-only log is written through here.
+func (s *redisStore) appendCapped(ctx context.Context, key string, entry []byte, limit int64, ttl time.Duration) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.RPush(ctx, key, entry)
+	pipe.LTrim(ctx, key, -limit, -1)
+	if ttl > 0 {
+		pipe.Expire(ctx, key, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) CreateDeck(ctx context.Context, gameID string, deck []string) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, s.keys.deck(gameID))
+	pipe.RPush(ctx, s.keys.deck(gameID), deck)
+	pipe.HSet(ctx, s.keys.game(gameID), "deckVersion", orderedDeckVersion, "schemaVersion", currentGameSchema)
+	pipe.HIncrBy(ctx, s.keys.game(gameID), "version", 1)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Insert ARGV[1] into the list at index ARGV[2], moving later cards down
+var insertCardScript = redis.NewScript(`
+local position = tonumber(ARGV[2])
+if position <= 0 then
+	return redis.call('LPUSH', KEYS[1], ARGV[1])
+end
+local tail = redis.call('LRANGE', KEYS[1], position, -1)
+redis.call('LTRIM', KEYS[1], 0, position - 1)
+redis.call('RPUSH', KEYS[1], ARGV[1])
+if #tail > 0 then
+	redis.call('RPUSH', KEYS[1], unpack(tail))
+end
+return redis.call('LLEN', KEYS[1])
+`)
+
+func (s *redisStore) InsertCard(ctx context.Context, gameID, card string, position int) error {
+	pipe := s.rdb.TxPipeline()
+	insertCardScript.Eval(ctx, pipe, []string{s.keys.deck(gameID)}, card, position)
+	pipe.HIncrBy(ctx, s.keys.game(gameID), "version", 1)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) GameVersion(ctx context.Context, gameID string) (int64, error) {
+	version, err := s.rdb.HGet(ctx, s.keys.game(gameID), "version").Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return version, err
+}
+
+func (s *redisStore) GetGameStatus(ctx context.Context, gameID string) (string, error) {
+	status, err := s.rdb.HGet(ctx, s.keys.game(gameID), "status").Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return status, err
+}
+
+func (s *redisStore) SetGameStatus(ctx context.Context, gameID, status string) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, s.keys.game(gameID), "status", status)
+	pipe.HIncrBy(ctx, s.keys.game(gameID), "version", 1)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) IncrGamesPlayed(ctx context.Context, gameID string) (int64, error) {
+	return s.rdb.HIncrBy(ctx, s.keys.game(gameID), "gamesPlayed", 1).Result()
+}
+
+func (s *redisStore) MarkGameStarted(ctx context.Context, gameID string, at time.Time) error {
+	fields, err := s.rdb.HKeys(ctx, s.keys.game(gameID)).Result()
+	if err != nil {
+		return err
+	}
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, s.keys.game(gameID), "startedAt", at.UnixMilli(), "cardsDrawn", 0, "moveSeq", 0)
+	pipe.HDel(ctx, s.keys.game(gameID), append(playerGameFields(fields), "mustDiscard", "blockedCause", "bombDeadline", "lastShuffleSeq", "finishedAt")...)
+	pipe.Del(ctx, s.keys.moves(gameID))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) MarkGameFinished(ctx context.Context, gameID string, at time.Time) error {
+	return s.rdb.HSet(ctx, s.keys.game(gameID), "finishedAt", at.UnixMilli()).Err()
+}
+
+func (s *redisStore) GameProgress(ctx context.Context, gameID string) (time.Time, int64, error) {
+	fields, err := s.rdb.HMGet(ctx, s.keys.game(gameID), "startedAt", "cardsDrawn").Result()
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return parseGameProgress(fields[0], fields[1])
+}
+
+func (s *redisStore) GameState(ctx context.Context, gameID, username, roomCode string) (*GameState, error) {
+	pipe := s.rdb.Pipeline()
+	remaining := pipe.LLen(ctx, s.keys.deck(gameID))
+	hand := pipe.LRange(ctx, s.keys.hand(username), 0, -1)
+	defuse := pipe.HGet(ctx, s.keys.user(username), "defuse")
+	game := pipe.HMGet(ctx, s.keys.game(gameID), "status", "version", "eventSeq", "mustDiscard", "blockedCause", "moveSeq", "lastShuffleSeq", "mode", "commitment", "bombDeadline")
+	var roomState *redis.StringStringMapCmd
+	if roomCode != "" {
+		roomState = pipe.HGetAll(ctx, s.keys.roomState(roomCode))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	state := &GameState{Remaining: int(remaining.Val()), Hand: hand.Val()}
+	state.DefuseCount, _ = defuse.Int()
+	fields := game.Val()
+	state.Status, _ = fields[0].(string)
+	if value, _ := fields[1].(string); value != "" {
+		state.Version, _ = strconv.ParseInt(value, 10, 64)
+	}
+	if value, _ := fields[2].(string); value != "" {
+		state.EventSeq, _ = strconv.ParseInt(value, 10, 64)
+	}
+	state.MustDiscard, _ = fields[3].(string)
+	state.BlockedCause, _ = fields[4].(string)
+	if value, _ := fields[5].(string); value != "" {
+		state.MoveSeq, _ = strconv.ParseInt(value, 10, 64)
+	}
+	if value, _ := fields[6].(string); value != "" {
+		state.LastShuffleSeq, _ = strconv.ParseInt(value, 10, 64)
+	}
+	state.Mode, _ = fields[7].(string)
+	state.Commitment, _ = fields[8].(string)
+	if _, deadline, err := parsePendingBomb(fields[3], fields[4], fields[9]); err == nil {
+		state.BombDeadline = deadline
+	}
+	if roomState != nil {
+		state.TurnDeadline = roomStateFromHash(roomState.Val()).TurnDeadline
+	}
+	return state, nil
+}
+
+func (s *redisStore) DrawState(ctx context.Context, gameID, username string) (*DrawState, error) {
+	pipe := s.rdb.Pipeline()
+	game := pipe.HMGet(ctx, s.keys.game(gameID), "status", "version", "mustDiscard", "mode", "blockedCause")
+	defuse := pipe.HGet(ctx, s.keys.user(username), "defuse")
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	state := &DrawState{Mode: ModeClassic}
+	state.Defuses, _ = defuse.Int()
+	fields := game.Val()
+	state.Status, _ = fields[0].(string)
+	if value, _ := fields[1].(string); value != "" {
+		state.Version, _ = strconv.ParseInt(value, 10, 64)
+	}
+	state.MustDiscard, _ = fields[2].(string)
+	if mode, _ := fields[3].(string); mode != "" {
+		state.Mode = mode
+	}
+	state.BlockedCause, _ = fields[4].(string)
+	return state, nil
+}
+
+func (s *redisStore) SetGameMode(ctx context.Context, gameID, mode string) error {
+	return s.rdb.HSet(ctx, s.keys.game(gameID), "mode", mode).Err()
+}
+
+func (s *redisStore) GameMode(ctx context.Context, gameID string) (string, error) {
+	mode, err := s.rdb.HGet(ctx, s.keys.game(gameID), "mode").Result()
+	if err == redis.Nil || mode == "" {
+		return ModeClassic, nil
+	}
+	return mode, err
+}
+
+func (s *redisStore) SetDeckConfig(ctx context.Context, gameID, config string) error {
+	return s.rdb.HSet(ctx, s.keys.game(gameID), "deckConfig", config).Err()
+}
+
+func (s *redisStore) DeckConfig(ctx context.Context, gameID string) (string, error) {
+	config, err := s.rdb.HGet(ctx, s.keys.game(gameID), "deckConfig").Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return config, err
+}
+
+func (s *redisStore) SetGameSeed(ctx context.Context, gameID, seed, commitment string) error {
+	return s.rdb.HSet(ctx, s.keys.game(gameID), "seed", seed, "commitment", commitment).Err()
+}
+
+func (s *redisStore) GameSeed(ctx context.Context, gameID string) (string, string, error) {
+	fields, err := s.rdb.HMGet(ctx, s.keys.game(gameID), "seed", "commitment").Result()
+	if err != nil {
+		return "", "", err
+	}
+	seed, _ := fields[0].(string)
+	commitment, _ := fields[1].(string)
+	return seed, commitment, nil
+}
+
+func (s *redisStore) SetGameRNG(ctx context.Context, gameID, state string) error {
+	return s.rdb.HSet(ctx, s.keys.game(gameID), "rng", state).Err()
+}
+
+func (s *redisStore) GameRNG(ctx context.Context, gameID string) (string, error) {
+	state, err := s.rdb.HGet(ctx, s.keys.game(gameID), "rng").Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return state, err
+}
+
+func (s *redisStore) SetPendingDraws(ctx context.Context, gameID, username string, count int) error {
+	if count <= 0 {
+		return s.rdb.HDel(ctx, s.keys.game(gameID), pendingDrawsField(username)).Err()
+	}
+	return s.rdb.HSet(ctx, s.keys.game(gameID), pendingDrawsField(username), count).Err()
+}
+
+func (s *redisStore) PendingDraws(ctx context.Context, gameID, username string) (int, error) {
+	count, err := s.rdb.HGet(ctx, s.keys.game(gameID), pendingDrawsField(username)).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return count, err
+}
+
+// KEYS: the game hash. ARGV: the player's field, the draws paid. Drops the
+// field once nothing is owed.
+var payPendingDrawsScript = redis.NewScript(`
+local left = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0') - tonumber(ARGV[2])
+if left <= 0 then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], left)
+return left
+`)
+
+func (s *redisStore) PayPendingDraws(ctx context.Context, gameID, username string, draws int) (int, error) {
+	return payPendingDrawsScript.Run(ctx, s.rdb, []string{s.keys.game(gameID)}, pendingDrawsField(username), draws).Int()
+}
+
+func (s *redisStore) SetDiscardBlock(ctx context.Context, gameID, username, cause string) error {
+	return s.rdb.HSet(ctx, s.keys.game(gameID), "mustDiscard", username, "blockedCause", cause).Err()
+}
+
+func (s *redisStore) ClearDiscardBlock(ctx context.Context, gameID string) error {
+	return s.rdb.HDel(ctx, s.keys.game(gameID), "mustDiscard", "blockedCause", "bombDeadline").Err()
+}
+
+func (s *redisStore) DiscardBlock(ctx context.Context, gameID string) (string, string, error) {
+	fields, err := s.rdb.HMGet(ctx, s.keys.game(gameID), "mustDiscard", "blockedCause").Result()
+	if err != nil {
+		return "", "", err
+	}
+	username, _ := fields[0].(string)
+	cause, _ := fields[1].(string)
+	return username, cause, nil
+}
+
+func (s *redisStore) SetPendingBomb(ctx context.Context, gameID, username string, deadline time.Time) error {
+	return s.rdb.HSet(ctx, s.keys.game(gameID), "mustDiscard", username, "blockedCause", blockPendingDefuse, "bombDeadline", deadline.UnixMilli()).Err()
+}
+
+func (s *redisStore) PendingBomb(ctx context.Context, gameID string) (string, time.Time, error) {
+	fields, err := s.rdb.HMGet(ctx, s.keys.game(gameID), "mustDiscard", "blockedCause", "bombDeadline").Result()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return parsePendingBomb(fields[0], fields[1], fields[2])
+}
+
+// ARGV: the player and blockPendingDefuse
+var claimPendingBombScript = redis.NewScript(`
+local fields = redis.call('HMGET', KEYS[1], 'mustDiscard', 'blockedCause')
+if fields[1] ~= ARGV[1] or fields[2] ~= ARGV[2] then
+	return 0
+end
+redis.call('HDEL', KEYS[1], 'mustDiscard', 'blockedCause', 'bombDeadline')
+return 1
+`)
+
+func (s *redisStore) ClaimPendingBomb(ctx context.Context, gameID, username string) (bool, error) {
+	claimed, err := claimPendingBombScript.Run(ctx, s.rdb, []string{s.keys.game(gameID)}, username, blockPendingDefuse).Int()
+	return claimed == 1, err
+}
+
+// Decode the mustDiscard, blockedCause and bombDeadline fields of a game
+// hash into the player a pending bomb waits on and their deadline
+func parsePendingBomb(username, cause, deadline interface{}) (string, time.Time, error) {
+	if value, _ := cause.(string); value != blockPendingDefuse {
+		return "", time.Time{}, nil
+	}
+	player, _ := username.(string)
+	var at time.Time
+	if value, _ := deadline.(string); value != "" {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		at = time.UnixMilli(ms)
+	}
+	return player, at, nil
+}
+
+// ARGV: the cooldown in moves. The Shuffle is the move after moveSeq.
+var claimShuffleScript = redis.NewScript(`
+local next = tonumber(redis.call('HGET', KEYS[1], 'moveSeq') or '0') + 1
+local last = tonumber(redis.call('HGET', KEYS[1], 'lastShuffleSeq') or '0')
+if last > 0 and next - last < tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'lastShuffleSeq', next)
+return 1
+`)
+
+func (s *redisStore) ClaimShuffle(ctx context.Context, gameID string, cooldown int) (bool, error) {
+	claimed, err := claimShuffleScript.Run(ctx, s.rdb, []string{s.keys.game(gameID)}, cooldown).Int()
+	return claimed == 1, err
+}
+
+func (s *redisStore) GetGameHash(ctx context.Context, gameID string) (map[string]string, error) {
+	return s.rdb.HGetAll(ctx, s.keys.game(gameID)).Result()
+}
+
+func (s *redisStore) SaveGameSummary(ctx context.Context, gameID, username string, summary []byte) error {
+	return s.rdb.HSet(ctx, s.keys.game(gameID), summaryField(username), summary).Err()
+}
+
+// WATCH the game hash, the deck and a solo player's user hash, and write the
+// migrated fields back only if none of them changed meanwhile
+func (s *redisStore) MigrateGame(ctx context.Context, gameID, username string) (int, error) {
+	watched := []string{s.keys.game(gameID), s.keys.deck(gameID)}
+	solo := gameID == username
+	if solo {
+		watched = append(watched, s.keys.user(username))
+	}
+
+	var from int
+	txf := func(tx *redis.Tx) error {
+		game, err := tx.HGetAll(ctx, s.keys.game(gameID)).Result()
+		if err != nil {
+			return err
+		}
+		dealt, err := tx.Exists(ctx, s.keys.deck(gameID)).Result()
+		if err != nil {
+			return err
+		}
+		record := gameRecord{Game: game, Dealt: dealt > 0}
+		if from, err = gameSchemaVersion(record); err != nil || from == 0 || from == currentGameSchema {
+			return err
+		}
+		if solo {
+			if record.User, err = tx.HGetAll(ctx, s.keys.user(username)).Result(); err != nil {
+				return err
+			}
+		}
+
+		before := gameRecord{Game: copyHash(record.Game), User: copyHash(record.User)}
+		migrateGameRecord(&record, from)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			writeHashChanges(ctx, pipe, s.keys.game(gameID), before.Game, record.Game)
+			if solo {
+				writeHashChanges(ctx, pipe, s.keys.user(username), before.User, record.User)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < txRetries; i++ {
+		err := s.rdb.Watch(ctx, txf, watched...)
+		if err != redis.TxFailedErr {
+			return from, err
+		}
+	}
+	return 0, redis.TxFailedErr
+}
+
+func writeHashChanges(ctx context.Context, pipe redis.Pipeliner, key string, before, after map[string]string) {
+	set, deleted := hashChanges(before, after)
+	if len(set) > 0 {
+		pipe.HSet(ctx, key, set)
+	}
+	if len(deleted) > 0 {
+		pipe.HDel(ctx, key, deleted...)
+	}
+}
+
+// Decode the startedAt and cardsDrawn fields of a game hash; missing fields
+// are nil or ""
+func parseGameProgress(startedAt, cardsDrawn interface{}) (time.Time, int64, error) {
+	var started time.Time
+	if value, _ := startedAt.(string); value != "" {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		started = time.UnixMilli(ms)
+	}
+	var drawn int64
+	if value, _ := cardsDrawn.(string); value != "" {
+		var err error
+		if drawn, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return time.Time{}, 0, err
+		}
+	}
+	return started, drawn, nil
+}
+
+func (s *redisStore) DeleteDeck(ctx context.Context, gameID string) error {
+	return s.rdb.Del(ctx, s.keys.deck(gameID), s.keys.game(gameID), s.keys.events(gameID)).Err()
+}
+
+func (s *redisStore) GetDeck(ctx context.Context, gameID string) ([]string, error) {
+	deck, err := s.rdb.LRange(ctx, s.keys.deck(gameID), 0, -1).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return deck, err
+}
+
+// Error code of the draw scripts' reply when the game hash's version isn't
+// the one the caller read. The reply has a message after the code, or Redis
+// 7 would put ERR in front of it.
+const versionConflictReply = "VERSION_CONFLICT"
+
+// Pop a card from an ordered deck, or remove a card at a caller-chosen random
+// index from a legacy deck, counting it in the game hash, in total and among
+// the drawer's draws, and bumping its version. KEYS: deck, game hash,
+// pending draw. ARGV: end, random index, expected version, drawer, then when
+// the card is held as pending, when it was drawn in unix ms and the request's
+// idempotency key. Returns {card, remaining, cleared}; card is false when
+// empty, and cleared is 1 when only bombs are left.
+var drawCardScript = redis.NewScript(`
+if tonumber(redis.call('HGET', KEYS[2], 'version') or '0') ~= tonumber(ARGV[3]) then
+	return redis.error_reply('VERSION_CONFLICT game version changed')
+end
+local card
+if redis.call('HGET', KEYS[2], 'deckVersion') then
+	if ARGV[1] == 'bottom' then
+		card = redis.call('RPOP', KEYS[1])
+	else
+		card = redis.call('LPOP', KEYS[1])
+	end
+else
+	local size = redis.call('LLEN', KEYS[1])
+	if size > 0 then
+		card = redis.call('LINDEX', KEYS[1], tonumber(ARGV[2]) % size)
+		redis.call('LREM', KEYS[1], 1, card)
+	end
+end
+if card then
+	redis.call('HINCRBY', KEYS[2], 'cardsDrawn', 1)
+	redis.call('HINCRBY', KEYS[2], 'drawn:' .. ARGV[4] .. ':' .. card, 1)
+	redis.call('HINCRBY', KEYS[2], 'version', 1)
+	if ARGV[5] then
+		redis.call('DEL', KEYS[3])
+		redis.call('HSET', KEYS[3], 'card', card, 'username', ARGV[4], 'at', ARGV[5], 'key', ARGV[6],
+			'version', redis.call('HGET', KEYS[2], 'version'))
+	end
+end
+local cleared = 1
+for _, left in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	if left ~= 'Exploding Kitten' then
+		cleared = 0
+		break
+	end
+end
+return {card, redis.call('LLEN', KEYS[1]), cleared}
+`)
+
+func (s *redisStore) DrawCard(ctx context.Context, gameID, username string, version int64, fromBottom bool, hold *PendingDraw) (DrawnCard, error) {
+	end := "top"
+	if fromBottom {
+		end = "bottom"
+	}
+
+	keys := []string{s.keys.deck(gameID), s.keys.game(gameID), s.keys.pendingDraw(gameID)}
+	args := []interface{}{end, rand.Int63(), version, username}
+	if hold != nil {
+		args = append(args, hold.At.UnixMilli(), hold.Key)
+	}
+	result, err := drawCardScript.Run(ctx, s.rdb, keys, args...).Slice()
+	if err != nil {
+		return DrawnCard{}, versionError(err)
+	}
+	var drawn DrawnCard
+	drawn.Card, _ = result[0].(string)
+	remaining, _ := result[1].(int64)
+	cleared, _ := result[2].(int64)
+	drawn.Remaining, drawn.Cleared = int(remaining), cleared == 1
+	return drawn, nil
+}
+
+func (s *redisStore) ConfirmDraw(ctx context.Context, gameID string) error {
+	return s.rdb.Del(ctx, s.keys.pendingDraw(gameID)).Err()
+}
+
+// KEYS: pending draw, deck, game hash, hand, user hash, then the idempotency
+// key of the request that drew the card if it had one. ARGV: the cutoff in
+// unix ms, the drawer. Undoes the draw if it was made before the cutoff and
+// the game is still at the version it left, and replies 1. A draw the game
+// has moved on from stands and is forgotten.
+var returnDrawScript = redis.NewScript(`
+local draw = redis.call('HMGET', KEYS[1], 'card', 'username', 'at', 'version')
+if not draw[1] or draw[2] ~= ARGV[2] or tonumber(draw[3]) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+if redis.call('HGET', KEYS[3], 'version') ~= draw[4] then
+	return 0
+end
+local card = draw[1]
+if redis.call('LREM', KEYS[4], 1, card) == 1 and card == 'Defuse' then
+	redis.call('HINCRBY', KEYS[5], 'defuse', -1)
+end
+redis.call('LPUSH', KEYS[2], card)
+redis.call('HINCRBY', KEYS[3], 'cardsDrawn', -1)
+redis.call('HINCRBY', KEYS[3], 'drawn:' .. ARGV[2] .. ':' .. card, -1)
+redis.call('HINCRBY', KEYS[3], 'version', 1)
+if KEYS[6] then
+	redis.call('DEL', KEYS[6])
+end
+return 1
+`)
+
+func (s *redisStore) ReturnPendingDraws(ctx context.Context, before time.Time) ([]PendingDraw, error) {
+	var returned []PendingDraw
+	err := s.scanKeys(ctx, s.keys.key("pending:*"), 100, func(key string) error {
+		fields, err := s.rdb.HGetAll(ctx, key).Result()
+		if err != nil || fields["card"] == "" {
+			// Confirmed since the scan
+			return err
+		}
+		gameID := s.keys.id(key, "pending:")
+		at, _ := strconv.ParseInt(fields["at"], 10, 64)
+		draw := PendingDraw{GameID: gameID, Username: fields["username"], Card: fields["card"], Key: fields["key"], At: time.UnixMilli(at)}
+
+		keys := []string{key, s.keys.deck(gameID), s.keys.game(gameID), s.keys.hand(draw.Username), s.keys.user(draw.Username)}
+		if draw.Key != "" {
+			keys = append(keys, s.keys.idempotency(gameID, draw.Key))
+		}
+		undone, err := returnDrawScript.Run(ctx, s.rdb, keys, before.UnixMilli(), draw.Username).Int()
+		if undone == 1 {
+			returned = append(returned, draw)
+		}
+		return err
+	})
+	return returned, err
+}
+
+// Map the scripts' conflict reply to errVersionConflict
+func versionError(err error) error {
+	if strings.HasPrefix(err.Error(), versionConflictReply+" ") {
+		return errVersionConflict
+	}
+	return err
+}
+
+// Batch version of drawCardScript that also settles each card, following
+// the same rules as engine.Game.Settle, against the Defuses the player holds.
+// The hand is left to the caller.
+// KEYS: deck, game hash. ARGV: count, expected version, user, Defuses held,
+// then a random number per draw: the index drawn from a legacy deck, and where
+// a defused bomb goes back in. Returns card, outcome pairs followed by the cards
+// left.
+var drawCardsScript = redis.NewScript(`
+local function cleared()
+	for _, card in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+		if card ~= 'Exploding Kitten' then
+			return false
+		end
+	end
+	return true
+end
+if tonumber(redis.call('HGET', KEYS[2], 'version') or '0') ~= tonumber(ARGV[2]) then
+	return redis.error_reply('VERSION_CONFLICT game version changed')
+end
+local ordered = redis.call('HGET', KEYS[2], 'deckVersion')
+local defuses = tonumber(ARGV[4])
+local results = {}
+for i = 1, tonumber(ARGV[1]) do
+	local card
+	if ordered then
+		card = redis.call('LPOP', KEYS[1])
+	else
+		local size = redis.call('LLEN', KEYS[1])
+		if size > 0 then
+			card = redis.call('LINDEX', KEYS[1], tonumber(ARGV[i + 4]) % size)
+			redis.call('LREM', KEYS[1], 1, card)
+		end
+	end
+	if not card then
+		break
+	end
+	redis.call('HINCRBY', KEYS[2], 'cardsDrawn', 1)
+	redis.call('HINCRBY', KEYS[2], 'drawn:' .. ARGV[3] .. ':' .. card, 1)
+	redis.call('HINCRBY', KEYS[2], 'version', 1)
+
+	local outcome = 'held'
+	if card == 'Exploding Kitten' then
+		if defuses > 0 then
+			defuses = defuses - 1
+			redis.call('HINCRBY', KEYS[2], 'defusesUsed:' .. ARGV[3], 1)
+			local position = tonumber(ARGV[i + 4]) % (redis.call('LLEN', KEYS[1]) + 1)
+			if position == 0 then
+				redis.call('LPUSH', KEYS[1], card)
+			else
+				local tail = redis.call('LRANGE', KEYS[1], position, -1)
+				redis.call('LTRIM', KEYS[1], 0, position - 1)
+				redis.call('RPUSH', KEYS[1], card)
+				if #tail > 0 then
+					redis.call('RPUSH', KEYS[1], unpack(tail))
+				end
+			end
+			outcome = 'defused'
+		else
+			outcome = 'exploded'
+		end
+	elseif card == 'Shuffle' then
+		outcome = 'shuffle'
+	elseif card == 'Defuse' then
+		defuses = defuses + 1
+	end
+	table.insert(results, card)
+	table.insert(results, outcome)
+	if outcome ~= 'held' or cleared() then
+		break
+	end
+end
+table.insert(results, redis.call('LLEN', KEYS[1]))
+return results
+`)
+
+// A room's deck and its players' hands are in different slots on a cluster,
+// so the cards are drawn first and the hand updated after
+func (s *redisStore) DrawCards(ctx context.Context, gameID, username string, version int64, rolls []int64) ([]BatchDraw, int, error) {
+	defuses, err := s.GetDefuse(ctx, username)
+	if err != nil {
+		return nil, 0, err
+	}
+	args := []interface{}{len(rolls), version, username, defuses}
+	for _, roll := range rolls {
+		args = append(args, roll)
+	}
+	keys := []string{s.keys.deck(gameID), s.keys.game(gameID)}
+	result, err := drawCardsScript.Run(ctx, s.rdb, keys, args...).Slice()
+	if err != nil {
+		return nil, 0, versionError(err)
+	}
+
+	var draws []BatchDraw
+	pipe := s.rdb.TxPipeline()
+	for i := 0; i+1 < len(result); i += 2 {
+		card, _ := result[i].(string)
+		outcome, _ := result[i+1].(string)
+		draws = append(draws, BatchDraw{Card: card, Outcome: outcome})
+		switch outcome {
+		case DrawDefused:
+			pipe.LRem(ctx, s.keys.hand(username), 1, "Defuse")
+			pipe.HIncrBy(ctx, s.keys.user(username), "defuse", -1)
+		case DrawHeld:
+			pipe.RPush(ctx, s.keys.hand(username), card)
+			if card == "Defuse" {
+				pipe.HIncrBy(ctx, s.keys.user(username), "defuse", 1)
+			}
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, 0, err
+	}
+	remaining, _ := result[len(result)-1].(int64)
+	return draws, int(remaining), nil
+}
+
+func (s *redisStore) GetDefuse(ctx context.Context, username string) (int, error) {
+	count, err := s.rdb.HGet(ctx, s.keys.user(username), "defuse").Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return count, err
+}
+
+func (s *redisStore) SetDefuse(ctx context.Context, username string, count int) error {
+	return s.rdb.HSet(ctx, s.keys.user(username), "defuse", count).Err()
+}
+
+func (s *redisStore) GetHand(ctx context.Context, username string) ([]string, error) {
+	hand, err := s.rdb.LRange(ctx, s.keys.hand(username), 0, -1).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return hand, err
+}
+
+func (s *redisStore) HoldCard(ctx context.Context, username string, card string) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.RPush(ctx, s.keys.hand(username), card)
+	if card == "Defuse" {
+		pipe.HIncrBy(ctx, s.keys.user(username), "defuse", 1)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) RemoveFromHand(ctx context.Context, username string, card string) (bool, error) {
+	removed, err := s.rdb.LRem(ctx, s.keys.hand(username), 1, card).Result()
+	if err != nil || removed == 0 {
+		return false, err
+	}
+	if card == "Defuse" {
+		if err := s.rdb.HIncrBy(ctx, s.keys.user(username), "defuse", -1).Err(); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+func (s *redisStore) UseDefuse(ctx context.Context, gameID, username string) (int, error) {
+	pipe := s.rdb.TxPipeline()
+	pipe.LRem(ctx, s.keys.hand(username), 1, "Defuse")
+	remaining := pipe.HIncrBy(ctx, s.keys.user(username), "defuse", -1)
+	pipe.HIncrBy(ctx, s.keys.game(gameID), defusesUsedField(username), 1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(remaining.Val()), nil
+}
+
+func (s *redisStore) ClearHand(ctx context.Context, username string) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, s.keys.hand(username))
+	pipe.HSet(ctx, s.keys.user(username), "defuse", 0)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) DealHand(ctx context.Context, username string, cards []string) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, s.keys.hand(username))
+	if len(cards) > 0 {
+		pipe.RPush(ctx, s.keys.hand(username), cards)
+	}
+	pipe.HSet(ctx, s.keys.user(username), "defuse", defusesIn(cards))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func defusesIn(cards []string) int {
+	count := 0
+	for _, card := range cards {
+		if card == "Defuse" {
+			count++
+		}
+	}
+	return count
+}
+
+// KEYS: a hand and its user hash. ARGV: a random number. Removes a random
+// card from the hand, counting a Defuse out of the user's, and replies it,
+// or nil if the hand is empty.
+var takeCardScript = redis.NewScript(`
+local size = redis.call('LLEN', KEYS[1])
+if size == 0 then
+	return false
+end
+local card = redis.call('LINDEX', KEYS[1], tonumber(ARGV[1]) % size)
+redis.call('LREM', KEYS[1], 1, card)
+if card == 'Defuse' then
+	redis.call('HINCRBY', KEYS[2], 'defuse', -1)
+end
+return card
+`)
+
+// KEYS: a hand. ARGV: a card. Removes two of the card and replies 1, or
+// replies 0 if the hand holds fewer.
+var spendPairScript = redis.NewScript(`
+local held = 0
+for _, card in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	if card == ARGV[1] then
+		held = held + 1
+	end
+end
+if held < 2 then
+	return 0
+end
+redis.call('LREM', KEYS[1], 2, ARGV[1])
+return 1
+`)
+
+// Take a random card from the user's hand, "" if it is empty
+func (s *redisStore) takeRandomCard(ctx context.Context, username string) (string, error) {
+	card, err := takeCardScript.Run(ctx, s.rdb, []string{s.keys.hand(username), s.keys.user(username)}, rand.Int63()).Text()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return card, err
+}
+
+// Add a card to the user's hand
+func (s *redisStore) giveCard(ctx context.Context, username, card string) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.RPush(ctx, s.keys.hand(username), card)
+	if card == "Defuse" {
+		pipe.HIncrBy(ctx, s.keys.user(username), "defuse", 1)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Two players' hands are in different slots on a cluster, so the card
+// leaves one hand before it joins the other
+func (s *redisStore) TakeRandomCard(ctx context.Context, from, to string) (string, error) {
+	card, err := s.takeRandomCard(ctx, from)
+	if err != nil || card == "" {
+		return "", err
+	}
+	return card, s.giveCard(ctx, to, card)
+}
+
+func (s *redisStore) StealWithPair(ctx context.Context, thief, victim, card string) (string, error) {
+	spent, err := spendPairScript.Run(ctx, s.rdb, []string{s.keys.hand(thief)}, card).Int()
+	if err != nil {
+		return "", err
+	}
+	if spent == 0 {
+		return "", errPairMissing
+	}
+	stolen, err := s.takeRandomCard(ctx, victim)
+	if err != nil {
+		return "", err
+	}
+	if stolen == "" {
+		// Nothing to steal: the thief keeps their pair
+		if err := s.rdb.RPush(ctx, s.keys.hand(thief), card, card).Err(); err != nil {
+			return "", err
+		}
+		return "", errHandIsEmpty
+	}
+	return stolen, s.giveCard(ctx, thief, stolen)
+}
+
+// How many copies of card the hand holds
+func countOf(hand []string, card string) int {
+	count := 0
+	for _, c := range hand {
+		if c == card {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *redisStore) CreateRoom(ctx context.Context, code string, owner string) (bool, error) {
+	created, err := s.rdb.HSetNX(ctx, s.keys.room(code), "players", owner).Result()
+	if err != nil || !created {
+		return false, err
+	}
+	err = s.rdb.HSet(ctx, s.keys.room(code), "status", RoomWaiting).Err()
+	return err == nil, err
+}
+
+func (s *redisStore) GetRoom(ctx context.Context, code string) (*Room, error) {
+	fields, err := s.rdb.HGetAll(ctx, s.keys.room(code)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return roomFromHash(code, fields), nil
+}
+
+func (s *redisStore) JoinRoom(ctx context.Context, code string, username string) (*Room, error) {
+	var room *Room
+	txf := func(tx *redis.Tx) error {
+		fields, err := tx.HGetAll(ctx, s.keys.room(code)).Result()
+		if err != nil {
+			return err
+		}
+		if len(fields) == 0 {
+			return errNoSuchRoom
+		}
+		room = roomFromHash(code, fields)
+		if room.hasPlayer(username) {
+			return nil
+		}
+		if room.Status != RoomWaiting || len(room.Players) >= room.Size {
+			return errRoomIsFull
+		}
+		room.Players = append(room.Players, username)
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, s.keys.room(code), "players", strings.Join(room.Players, ","))
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < txRetries; i++ {
+		err := s.rdb.Watch(ctx, txf, s.keys.room(code))
+		if err != redis.TxFailedErr {
+			return room, err
+		}
+	}
+	return nil, redis.TxFailedErr
+}
+
+func (s *redisStore) UpdateRoom(ctx context.Context, room *Room) error {
+	return s.rdb.HSet(ctx, s.keys.room(room.Code), "turn", room.Turn, "status", room.Status,
+		"botDifficulty", room.BotDifficulty, "turnTimeout", room.TurnTimeout, "size", room.Size, "mode", room.Mode, "theme", room.Theme).Err()
+}
+
+// Append ARGV[1] to the room's comma-separated elimination order if it isn't
+// there yet. Returns the order.
+var eliminatePlayerScript = redis.NewScript(`
+local eliminated = redis.call('HGET', KEYS[1], 'eliminated') or ''
+for name in string.gmatch(eliminated, '[^,]+') do
+	if name == ARGV[1] then
+		return eliminated
+	end
+end
+if eliminated ~= '' then
+	eliminated = eliminated .. ','
+end
+eliminated = eliminated .. ARGV[1]
+redis.call('HSET', KEYS[1], 'eliminated', eliminated)
+return eliminated
+`)
+
+func (s *redisStore) EliminatePlayer(ctx context.Context, code, username string) ([]string, error) {
+	eliminated, err := eliminatePlayerScript.Run(ctx, s.rdb, []string{s.keys.room(code)}, username).Text()
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(eliminated, ","), nil
+}
+
+// Compare-and-increment of the room's turnVersion. Returns 1 if it matched.
+var claimTurnScript = redis.NewScript(`
+if tonumber(redis.call('HGET', KEYS[1], 'turnVersion') or '0') ~= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('HINCRBY', KEYS[1], 'turnVersion', 1)
+return 1
+`)
+
+func (s *redisStore) ClaimTurn(ctx context.Context, code string, version int64) (bool, error) {
+	claimed, err := claimTurnScript.Run(ctx, s.rdb, []string{s.keys.room(code)}, version).Int()
+	return claimed == 1, err
+}
+
+// Set the pause fields of a room hash unless they are set. ARGV: who, when,
+// turn time left and when the pause ends. Returns 1 if they were set.
+var pauseRoomScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], 'pausedBy') == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'pausedBy', ARGV[1], 'pausedAt', ARGV[2], 'pauseTurnLeft', ARGV[3], 'pauseResumesAt', ARGV[4])
+return 1
+`)
+
+func (s *redisStore) PauseRoom(ctx context.Context, code string, pause RoomPause) (bool, error) {
+	paused, err := pauseRoomScript.Run(ctx, s.rdb, []string{s.keys.room(code)},
+		pause.By, pause.At.UnixMilli(), pause.TurnLeftMs, pause.ResumesAt.UnixMilli()).Int()
+	return paused == 1, err
+}
+
+// Clear the pause fields of a room hash, adding the pause's length up to
+// ARGV[1] to pausedMs. Returns the fields cleared, or nil if there were none.
+var resumeRoomScript = redis.NewScript(`
+local pause = redis.call('HMGET', KEYS[1], 'pausedBy', 'pausedAt', 'pauseTurnLeft', 'pauseResumesAt')
+if not pause[1] then
+	return false
+end
+local paused = tonumber(ARGV[1]) - tonumber(pause[2] or ARGV[1])
+if paused > 0 then
+	redis.call('HINCRBY', KEYS[1], 'pausedMs', paused)
+end
+redis.call('HDEL', KEYS[1], 'pausedBy', 'pausedAt', 'pauseTurnLeft', 'pauseResumesAt')
+return pause
+`)
+
+func (s *redisStore) ResumeRoom(ctx context.Context, code string, at time.Time) (*RoomPause, error) {
+	fields, err := resumeRoomScript.Run(ctx, s.rdb, []string{s.keys.room(code)}, at.UnixMilli()).StringSlice()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return roomPauseFromHash(map[string]string{
+		"pausedBy":       fields[0],
+		"pausedAt":       fields[1],
+		"pauseTurnLeft":  fields[2],
+		"pauseResumesAt": fields[3],
+	}), nil
+}
+
+func (s *redisStore) SetDisabledCards(ctx context.Context, code string, cards []string) error {
+	return s.rdb.HSet(ctx, s.keys.room(code), "disabledCards", strings.Join(cards, ",")).Err()
+}
+
+func (s *redisStore) SetRoomTournament(ctx context.Context, code, id string) error {
+	return s.rdb.HSet(ctx, s.keys.room(code), "tournament", id).Err()
+}
+
+func (s *redisStore) CreateTournament(ctx context.Context, tournament *Tournament, ttl time.Duration) error {
+	payload, err := json.Marshal(tournament)
+	if err != nil {
+		return err
+	}
+	return s.rdb.Set(ctx, s.keys.tournament(tournament.ID), payload, ttl).Err()
+}
+
+func (s *redisStore) GetTournament(ctx context.Context, id string) (*Tournament, error) {
+	payload, err := s.rdb.Get(ctx, s.keys.tournament(id)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tournament Tournament
+	if err := json.Unmarshal(payload, &tournament); err != nil {
+		return nil, err
+	}
+	return &tournament, nil
+}
+
+func (s *redisStore) UpdateTournament(ctx context.Context, id string, update func(*Tournament) error) (*Tournament, error) {
+	key := s.keys.tournament(id)
+	var tournament *Tournament
+	txf := func(tx *redis.Tx) error {
+		payload, err := tx.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			return errNoTournament
+		}
+		if err != nil {
+			return err
+		}
+		tournament = &Tournament{}
+		if err := json.Unmarshal(payload, tournament); err != nil {
+			return err
+		}
+		if err := update(tournament); err != nil {
+			return err
+		}
+		if payload, err = json.Marshal(tournament); err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, payload, redis.KeepTTL)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < txRetries; i++ {
+		err := s.rdb.Watch(ctx, txf, key)
+		if err != redis.TxFailedErr {
+			return tournament, err
+		}
+	}
+	return nil, redis.TxFailedErr
+}
+
+func (s *redisStore) EnqueueMatch(ctx context.Context, username string, at time.Time) error {
+	return s.rdb.ZAddNX(ctx, s.keys.matchQueue(), &redis.Z{Score: float64(at.UnixMilli()), Member: username}).Err()
+}
+
+func (s *redisStore) MatchQueuePosition(ctx context.Context, username string) (int64, error) {
+	rank, err := s.rdb.ZRank(ctx, s.keys.matchQueue(), username).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return rank + 1, err
+}
+
+func (s *redisStore) LeaveMatchQueue(ctx context.Context, username string) (bool, error) {
+	removed, err := s.rdb.ZRem(ctx, s.keys.matchQueue(), username).Result()
+	return removed == 1, err
+}
+
+// Pop the two lowest-scored members, or none if fewer are queued
+var popMatchScript = redis.NewScript(`
+local pair = redis.call('ZRANGE', KEYS[1], 0, 1)
+if #pair < 2 then
+	return {}
+end
+redis.call('ZREM', KEYS[1], pair[1], pair[2])
+return pair
+`)
+
+func (s *redisStore) PopMatch(ctx context.Context) ([]string, error) {
+	pair, err := popMatchScript.Run(ctx, s.rdb, []string{s.keys.matchQueue()}).StringSlice()
+	if err != nil || len(pair) < 2 {
+		return nil, err
+	}
+	return pair, nil
+}
+
+func (s *redisStore) RevokeInvites(ctx context.Context, code string, at time.Time, keep time.Duration) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.ZAdd(ctx, s.keys.revokedInvites(), &redis.Z{Score: float64(at.UnixMilli()), Member: code})
+	pipe.ZRemRangeByScore(ctx, s.keys.revokedInvites(), "-inf", "("+strconv.FormatInt(at.Add(-keep).UnixMilli(), 10))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) InvitesRevokedAt(ctx context.Context, code string) (time.Time, error) {
+	score, err := s.rdb.ZScore(ctx, s.keys.revokedInvites(), code).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(int64(score)), nil
+}
+
+func (s *redisStore) ActiveRooms(ctx context.Context) ([]*Room, error) {
+	var rooms []*Room
+	err := s.scanKeys(ctx, s.keys.room("*"), 100, func(key string) error {
+		code := s.keys.id(key, "room:")
+		if strings.Contains(code, ":") {
+			// room:{code}:state
+			return nil
+		}
+		room, err := s.GetRoom(ctx, code)
+		if room != nil && room.Status == RoomActive {
+			rooms = append(rooms, room)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rooms, nil
+}
+
+// KEYS: the room hash, the deck and the game hash. ARGV: the card, the
+// depth and RoomActive. Returns the deck's new length, or -1 if the room
+// isn't active.
+var injectCardScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'status') ~= ARGV[3] then
+	return -1
+end
+local size = redis.call('LLEN', KEYS[2])
+local position = math.floor(tonumber(ARGV[2]) * (size + 1))
+if position > size then
+	position = size
+end
+if position <= 0 then
+	redis.call('LPUSH', KEYS[2], ARGV[1])
+else
+	local tail = redis.call('LRANGE', KEYS[2], position, -1)
+	redis.call('LTRIM', KEYS[2], 0, position - 1)
+	redis.call('RPUSH', KEYS[2], ARGV[1])
+	if #tail > 0 then
+		redis.call('RPUSH', KEYS[2], unpack(tail))
+	end
+end
+redis.call('HINCRBY', KEYS[3], 'version', 1)
+return size + 1
+`)
+
+func (s *redisStore) InjectCard(ctx context.Context, code, card string, depth float64) (int, bool, error) {
+	gameID := roomGameIDPrefix + code
+	keys := []string{s.keys.room(code), s.keys.deck(gameID), s.keys.game(gameID)}
+	size, err := injectCardScript.Run(ctx, s.rdb, keys, card, depth, RoomActive).Int()
+	if err != nil {
+		return 0, false, err
+	}
+	return size, size >= 0, nil
+}
+
+func (s *redisStore) SetTurnDeadline(ctx context.Context, code string, deadline time.Time) error {
+	return s.rdb.HSet(ctx, s.keys.roomState(code), "turnDeadline", deadline.UnixMilli()).Err()
+}
+
+func (s *redisStore) SetPendingAction(ctx context.Context, code string, pending *PendingState) error {
+	if pending == nil {
+		return s.rdb.HDel(ctx, s.keys.roomState(code), pendingStateFields...).Err()
+	}
+	return s.rdb.HSet(ctx, s.keys.roomState(code), pending.hashFields()...).Err()
+}
+
+func (s *redisStore) GetRoomState(ctx context.Context, code string) (*RoomState, error) {
+	fields, err := s.rdb.HGetAll(ctx, s.keys.roomState(code)).Result()
+	if err != nil {
+		return nil, err
+	}
+	return roomStateFromHash(fields), nil
+}
+
+func (s *redisStore) SetBalanceMode(ctx context.Context, code, mode string) error {
+	return s.rdb.HSet(ctx, s.keys.roomState(code), "balanceMode", mode).Err()
+}
+
+func (s *redisStore) SetFirstPlayer(ctx context.Context, code, username string) error {
+	return s.rdb.HSet(ctx, s.keys.roomState(code), "firstPlayer", username).Err()
+}
+
+func (s *redisStore) DeleteRoomState(ctx context.Context, code string) error {
+	return s.rdb.Del(ctx, s.keys.roomState(code)).Err()
+}
+
+func (s *redisStore) ResetStats(ctx context.Context, username string, audit AuditEntry) error {
+	_, err := s.SetStats(ctx, username, 0, 0, audit)
+	return err
+}
+
+func (s *redisStore) GetStats(ctx context.Context, username string) (int64, int64, error) {
+	pipe := s.rdb.Pipeline()
+	win := pipe.HGet(ctx, s.keys.win(), username)
+	lose := pipe.HGet(ctx, s.keys.lose(), username)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, 0, err
+	}
+	winCount, _ := win.Int64()
+	loseCount, _ := lose.Int64()
+	return winCount, loseCount, nil
+}
+
+// Lua defining audit(key, template, cap, fields): append the JSON-encoded
+// audit entry template to the stream at key, with fields set on it, keeping
+// about cap entries
+const auditLua = `
+local function audit(key, template, cap, fields)
+	local entry = cjson.decode(template)
+	for name, value in pairs(fields) do
+		entry[name] = value
+	end
+	redis.call('XADD', key, 'MAXLEN', '~', cap, '*', 'entry', cjson.encode(entry))
+end
+`
+
+// KEYS: the win and lose hashes and the audit stream. ARGV: the username, the
+// new win and lose counts, the audit entry and the audit cap. Replies the old
+// {win, lose}.
+var setStatsScript = redis.NewScript(auditLua + `
+local win = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0')
+local lose = tonumber(redis.call('HGET', KEYS[2], ARGV[1]) or '0')
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+audit(KEYS[3], ARGV[4], ARGV[5], {
+	before = {wins = win, losses = lose},
+	after = {wins = tonumber(ARGV[2]), losses = tonumber(ARGV[3])},
+})
+return {win, lose}
+`)
+
+func (s *redisStore) SetStats(ctx context.Context, username string, win, lose int64, audit AuditEntry) (*PlayerStats, error) {
+	entry, err := json.Marshal(audit)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{s.keys.win(), s.keys.lose(), s.keys.audit()}
+	before, err := setStatsScript.Run(ctx, s.rdb, keys, username, win, lose, entry, s.retention.Audit).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	return &PlayerStats{Wins: before[0], Losses: before[1]}, nil
+}
+
+// Lua defining endGame(): end the running game at KEYS[1], counting up its
+// game hash at KEYS[2]. ARGV: the status while the game runs, the status to
+// set, 1 to bump the game version, 1 if a bomb went off, then the prefix of
+// the game hash's draw counts, the suffix of its bomb draw counts and the
+// prefix of its Defuse counts. Returns nil if the game had already ended,
+// and otherwise its draws, bombs and Defuses used.
+const endGameLua = `
+local function endGame()
+	local status = redis.call('HGET', KEYS[1], 'status')
+	if status and status ~= '' and status ~= ARGV[1] then
+		return nil
+	end
+	redis.call('HSET', KEYS[1], 'status', ARGV[2])
+	if ARGV[3] == '1' then
+		redis.call('HINCRBY', KEYS[1], 'version', 1)
+	end
+
+	local draws = tonumber(redis.call('HGET', KEYS[2], 'cardsDrawn')) or 0
+	local bombs, defused = 0, 0
+	local fields = redis.call('HGETALL', KEYS[2])
+	for i = 1, #fields, 2 do
+		local field, count = fields[i], tonumber(fields[i + 1]) or 0
+		if string.sub(field, 1, #ARGV[7]) == ARGV[7] then
+			defused = defused + count
+		elseif string.sub(field, 1, #ARGV[5]) == ARGV[5] and string.sub(field, -#ARGV[6]) == ARGV[6] then
+			bombs = bombs + count
+		end
+	end
+	return draws, bombs, defused
+end
+`
+
+// KEYS: the hash holding the status, the game hash, the daily stats hash,
+// the outbox, the win and lose hashes, the audit stream, the user hashes of
+// the winner, the loser and the partner and, if the game counts head to
+// head, the players' head-to-head hash. ARGV: those of endGame, then the
+// announcement or "", the head-to-head winner's field, the time the game
+// ended in unix ms, the winner, the loser and the partner ("" for nobody),
+// the audit entry and the audit cap. Replies {1, wins, losses, streak,
+// partner's wins, losses and streak, outbox entry ID or ""} if this ended
+// the game, {0} if it had already ended.
+var completeGameScript = redis.NewScript(auditLua + endGameLua + `
+local draws, bombs, defused = endGame()
+if not draws then
+	return {0}
+end
+redis.call('HINCRBY', KEYS[3], 'games', 1)
+redis.call('HINCRBY', KEYS[3], 'draws', draws)
+redis.call('HINCRBY', KEYS[3], 'bombs', bombs)
+redis.call('HINCRBY', KEYS[3], 'defused', defused)
+redis.call('HINCRBY', KEYS[3], 'exploded', ARGV[4])
+local id = ''
+if ARGV[8] ~= '' then
+	id = redis.call('XADD', KEYS[4], '*', 'payload', ARGV[8])
+end
+if KEYS[11] then
+	redis.call('HINCRBY', KEYS[11], ARGV[9], 1)
+	redis.call('HINCRBY', KEYS[11], 'games', 1)
+	redis.call('HSET', KEYS[11], 'lastPlayed', ARGV[10])
+end
+
+local function credit(winner, loser, winnerHash, loserHash)
+	local wins, losses, streak = 0, 0, 0
+	if winner ~= '' then
+		wins = redis.call('HINCRBY', KEYS[5], winner, 1)
+		streak = redis.call('HINCRBY', winnerHash, 'streak', 1)
+		audit(KEYS[7], ARGV[14], ARGV[15], {
+			action = 'win', username = winner,
+			before = {wins = wins - 1, streak = streak - 1},
+			after = {wins = wins, streak = streak},
+		})
+	end
+	if loser ~= '' then
+		losses = redis.call('HINCRBY', KEYS[6], loser, 1)
+		redis.call('HSET', loserHash, 'streak', 0)
+		audit(KEYS[7], ARGV[14], ARGV[15], {
+			action = 'lose', username = loser,
+			before = {losses = losses - 1},
+			after = {losses = losses},
+		})
+	end
+	return wins, losses, streak
+end
+local wins, losses, streak = credit(ARGV[11], ARGV[12], KEYS[8], KEYS[9])
+local partnerWins, partnerLosses, partnerStreak = 0, 0, 0
+if ARGV[13] ~= '' then
+	if ARGV[11] ~= '' then
+		partnerWins, partnerLosses, partnerStreak = credit(ARGV[13], '', KEYS[10], KEYS[10])
+	else
+		partnerWins, partnerLosses, partnerStreak = credit('', ARGV[13], KEYS[10], KEYS[10])
+	end
+end
+return {1, wins, losses, streak, partnerWins, partnerLosses, partnerStreak, id}
+`)
+
+// KEYS: the hash holding the status, the game hash and the completion's
+// record. ARGV: those of endGame, then the encoded GameResult. Replies {1,
+// draws, bombs, defused} if this ended the game, having written the record,
+// {0} if it had already ended.
+var endGameScript = redis.NewScript(endGameLua + `
+local draws, bombs, defused = endGame()
+if not draws then
+	return {0}
+end
+redis.call('HSET', KEYS[3], 'result', ARGV[8], 'draws', draws, 'bombs', bombs, 'defused', defused)
+return {1, draws, bombs, defused}
+`)
+
+// Lua defining once(): set KEYS[1], the marker of a completion's write to the
+// keys after it, for ARGV[1] ms. Returns false if it was already set, and
+// the write made.
+const onceLua = `
+local function once()
+	return redis.call('SET', KEYS[1], 1, 'NX', 'PX', ARGV[1])
+end
+`
+
+// KEYS: the marker and the daily stats hash. ARGV: the marker's TTL in ms,
+// the draws, bombs and Defuses used, and 1 if a bomb went off.
+var countDailyScript = redis.NewScript(onceLua + `
+if once() then
+	redis.call('HINCRBY', KEYS[2], 'games', 1)
+	redis.call('HINCRBY', KEYS[2], 'draws', ARGV[2])
+	redis.call('HINCRBY', KEYS[2], 'bombs', ARGV[3])
+	redis.call('HINCRBY', KEYS[2], 'defused', ARGV[4])
+	redis.call('HINCRBY', KEYS[2], 'exploded', ARGV[5])
+end
+return 1
+`)
+
+// KEYS: the marker and the players' head-to-head hash. ARGV: the marker's
+// TTL in ms, the winner's field and the time the game ended in unix ms.
+var countHeadToHeadScript = redis.NewScript(onceLua + `
+if once() then
+	redis.call('HINCRBY', KEYS[2], ARGV[2], 1)
+	redis.call('HINCRBY', KEYS[2], 'games', 1)
+	redis.call('HSET', KEYS[2], 'lastPlayed', ARGV[3])
+end
+return 1
+`)
+
+// KEYS: the marker and the outbox. ARGV: the marker's TTL in ms and the
+// announcement. Replies the ID of the announcement's entry, which the marker
+// holds.
+var appendOutboxScript = redis.NewScript(`
+local id = redis.call('GET', KEYS[1])
+if not id then
+	id = redis.call('XADD', KEYS[2], '*', 'payload', ARGV[2])
+	redis.call('SET', KEYS[1], id, 'PX', ARGV[1])
+end
+return id
+`)
+
+// KEYS: the marker and the player's user hash. ARGV: the marker's TTL in ms
+// and 1 to extend the player's streak, 0 to end it. Replies the streak.
+var creditStreakScript = redis.NewScript(onceLua + `
+if once() then
+	if ARGV[2] == '1' then
+		return redis.call('HINCRBY', KEYS[2], 'streak', 1)
+	end
+	redis.call('HSET', KEYS[2], 'streak', 0)
+end
+return tonumber(redis.call('HGET', KEYS[2], 'streak') or '0')
+`)
+
+// KEYS: the marker, the win and lose hashes and the audit stream. ARGV: the
+// marker's TTL in ms, the winner and the loser ("" for nobody), the winner's
+// streak after the game, the audit entry and the audit cap. Replies {wins,
+// losses}.
+var creditResultScript = redis.NewScript(auditLua + onceLua + `
+local wins, losses = 0, 0
+if not once() then
+	if ARGV[2] ~= '' then
+		wins = tonumber(redis.call('HGET', KEYS[2], ARGV[2]) or '0')
+	end
+	if ARGV[3] ~= '' then
+		losses = tonumber(redis.call('HGET', KEYS[3], ARGV[3]) or '0')
+	end
+	return {wins, losses}
+end
+if ARGV[2] ~= '' then
+	wins = redis.call('HINCRBY', KEYS[2], ARGV[2], 1)
+	local streak = tonumber(ARGV[4])
+	audit(KEYS[4], ARGV[5], ARGV[6], {
+		action = 'win', username = ARGV[2],
+		before = {wins = wins - 1, streak = streak - 1},
+		after = {wins = wins, streak = streak},
+	})
+end
+if ARGV[3] ~= '' then
+	losses = redis.call('HINCRBY', KEYS[3], ARGV[3], 1)
+	audit(KEYS[4], ARGV[5], ARGV[6], {
+		action = 'lose', username = ARGV[3],
+		before = {losses = losses - 1},
+		after = {losses = losses},
+	})
+end
+return {wins, losses}
+`)
+
+// On a single node the whole completion is one script, so the game can't end
+// without its players being credited. On a cluster see completeGameInSlots.
+func (s *redisStore) CompleteGame(ctx context.Context, result GameResult) (*GameCompletion, error) {
+	entry, err := json.Marshal(result.Audit)
+	if err != nil {
+		return nil, err
+	}
+	statusKey, running, bump := s.keys.game(result.GameID), GameStatusActive, 1
+	if result.RoomCode != "" {
+		statusKey, running, bump = s.keys.room(result.RoomCode), RoomActive, 0
+	}
+	exploded := 0
+	if result.Exploded {
+		exploded = 1
+	}
+	endArgs := []interface{}{running, result.Status, bump, exploded, drawCountPrefix, bombDrawSuffix, defusesUsedPrefix}
+	if s.keys.cluster {
+		return s.completeGameInSlots(ctx, result, statusKey, endArgs)
+	}
+
+	keys := []string{
+		statusKey, s.keys.game(result.GameID), s.keys.daily(result.Day), s.keys.outbox(),
+		s.keys.win(), s.keys.lose(), s.keys.audit(),
+		s.keys.user(result.Winner), s.keys.user(result.Loser), s.keys.user(result.Partner),
+	}
+	if result.HeadToHead {
+		keys = append(keys, s.keys.headToHead(result.Winner, result.Loser))
+	}
+	args := append(endArgs, result.Announcement, headToHeadWinsField(result.Winner), result.EndedAt.UnixMilli(),
+		result.Winner, result.Loser, result.Partner, entry, s.retention.Audit)
+	reply, err := completeGameScript.Run(ctx, s.rdb, keys, args...).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if ended, _ := reply[0].(int64); ended == 0 {
+		return &GameCompletion{}, nil
+	}
+	var counts [6]int64
+	for i := range counts {
+		counts[i], _ = reply[i+1].(int64)
+	}
+	completion := &GameCompletion{Completed: true, Wins: counts[0], Losses: counts[1], Streak: counts[2]}
+	completion.OutboxID, _ = reply[7].(string)
+	if result.Partner != "" {
+		completion.Partner = &GameCompletion{Completed: true, Wins: counts[3], Losses: counts[4], Streak: counts[5]}
+	}
+	return completion, nil
+}
+
+// How long a cluster's completion remembers which of its writes were made,
+// so that finishing it again makes none of them twice. Far longer than its
+// record should wait for FinishCompletions.
+const completionMarkerTTL = 7 * 24 * time.Hour
+
+// What a game completed on a cluster still has to write outside its own
+// slot, kept in a record in the slot until it has all been written
+type completionRecord struct {
+	// Names the completion in the markers of the writes made
+	ID     string
+	Result GameResult
+	// The game's draws, bombs drawn and Defuses used, for the daily stats
+	Draws, Bombs, Defused int64
+}
+
+func parseCompletionRecord(id string, hash map[string]string) (completionRecord, error) {
+	record := completionRecord{ID: id}
+	if err := json.Unmarshal([]byte(hash["result"]), &record.Result); err != nil {
+		return record, err
+	}
+	record.Draws, _ = strconv.ParseInt(hash["draws"], 10, 64)
+	record.Bombs, _ = strconv.ParseInt(hash["bombs"], 10, 64)
+	record.Defused, _ = strconv.ParseInt(hash["defused"], 10, 64)
+	return record, nil
+}
+
+// The game, the players and the stats each have a slot of their own on a
+// cluster, so no one script can write them all. The script ending the game
+// writes a record of the rest in the game's slot, and finishCompletion then
+// makes each write, marking it made in its own slot so that it's never made
+// twice, before dropping the record. A record a failure leaves behind is
+// finished by FinishCompletions.
+func (s *redisStore) completeGameInSlots(ctx context.Context, result GameResult, statusKey string, endArgs []interface{}) (*GameCompletion, error) {
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	record := completionRecord{ID: strconv.FormatInt(rand.Int63(), 36), Result: result}
+	key := s.keys.completion(result.GameID, record.ID)
+	keys := []string{statusKey, s.keys.game(result.GameID), key}
+	reply, err := endGameScript.Run(ctx, s.rdb, keys, append(endArgs, encoded)...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if reply[0] == 0 {
+		return &GameCompletion{}, nil
+	}
+	record.Draws, record.Bombs, record.Defused = reply[1], reply[2], reply[3]
+	return s.finishCompletion(ctx, key, record)
+}
+
+// Make the writes of the completion recorded at key that haven't been made,
+// and drop the record
+func (s *redisStore) finishCompletion(ctx context.Context, key string, record completionRecord) (*GameCompletion, error) {
+	result := record.Result
+	entry, err := json.Marshal(result.Audit)
+	if err != nil {
+		return nil, err
+	}
+	ttl := completionMarkerTTL.Milliseconds()
+	daily := s.keys.daily(result.Day)
+	exploded := 0
+	if result.Exploded {
+		exploded = 1
+	}
+	err = countDailyScript.Run(ctx, s.rdb, []string{s.keys.applied(daily, record.ID), daily},
+		ttl, record.Draws, record.Bombs, record.Defused, exploded).Err()
+	if err != nil {
+		return nil, err
+	}
+	if result.HeadToHead {
+		headToHead := s.keys.headToHead(result.Winner, result.Loser)
+		err := countHeadToHeadScript.Run(ctx, s.rdb, []string{s.keys.applied(headToHead, record.ID), headToHead},
+			ttl, headToHeadWinsField(result.Winner), result.EndedAt.UnixMilli()).Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+	outboxID := ""
+	if result.Announcement != nil {
+		outbox := s.keys.outbox()
+		outboxID, err = appendOutboxScript.Run(ctx, s.rdb, []string{s.keys.applied(outbox, record.ID), outbox},
+			ttl, result.Announcement).Text()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	completion, err := s.creditResult(ctx, record.ID, result.Winner, result.Loser, entry)
+	if err != nil {
+		return nil, err
+	}
+	completion.OutboxID = outboxID
+	if result.Partner != "" {
+		winner, loser := result.Partner, ""
+		if result.Winner == "" {
+			winner, loser = "", result.Partner
+		}
+		if completion.Partner, err = s.creditResult(ctx, record.ID+":partner", winner, loser, entry); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.rdb.Del(ctx, key).Err(); err != nil {
+		// Every write is made, and finishing the record again makes none
+		log.Printf("Error dropping completion record %s: %v", s.keys.name(key), err)
+	}
+	return completion, nil
+}
+
+func (s *redisStore) FinishCompletions(ctx context.Context, endedBefore time.Time) (int, error) {
+	if !s.keys.cluster {
+		return 0, nil
+	}
+	var keys []string
+	err := s.scanKeys(ctx, s.keys.key("completion:*"), 100, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	finished := 0
+	for _, key := range keys {
+		hash, err := s.rdb.HGetAll(ctx, key).Result()
+		if err != nil {
+			return finished, err
+		}
+		if len(hash) == 0 {
+			// Finished since the scan
+			continue
+		}
+		id := key[strings.LastIndexByte(key, ':')+1:]
+		record, err := parseCompletionRecord(id, hash)
+		if err != nil {
+			log.Printf("Warning: skipping unreadable completion record %s: %v", s.keys.name(key), err)
+			continue
+		}
+		if !record.Result.EndedAt.Before(endedBefore) {
+			continue
+		}
+		if _, err := s.finishCompletion(ctx, key, record); err != nil {
+			return finished, err
+		}
+		finished++
+	}
+	return finished, nil
+}
+
+// The consumer group of every instance's dispatcher
+const outboxGroup = "dispatchers"
+
+// New entries are read into the dispatchers' group as they arrive, making
+// them pending, and XCLAIM hands over only the pending entries XPENDING
+// finds idle longer than idle, checking the idle time again and resetting it
+// as it does, so two instances never take the same entry at once. XAUTOCLAIM
+// would do both in one call, but go-redis v8 can't parse its reply from
+// Redis 7. The group is made on first use.
+func (s *redisStore) ClaimOutbox(ctx context.Context, consumer string, idle time.Duration, count int) ([]OutboxEntry, error) {
+	key := s.keys.outbox()
+	err := s.rdb.XGroupCreateMkStream(ctx, key, outboxGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, err
+	}
+	err = s.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    outboxGroup,
+		Consumer: consumer,
+		Streams:  []string{key, ">"},
+		Count:    int64(count),
+		Block:    -1,
+	}).Err()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	pending, err := s.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: key,
+		Group:  outboxGroup,
+		Idle:   idle,
+		Start:  "-",
+		End:    "+",
+		Count:  int64(count),
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+	ids := make([]string, len(pending))
+	for i, entry := range pending {
+		ids[i] = entry.ID
+	}
+	messages, err := s.rdb.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   key,
+		Group:    outboxGroup,
+		Consumer: consumer,
+		MinIdle:  idle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]OutboxEntry, 0, len(messages))
+	for _, message := range messages {
+		payload, _ := message.Values["payload"].(string)
+		entries = append(entries, OutboxEntry{ID: message.ID, Payload: []byte(payload)})
+	}
+	return entries, nil
+}
+
+// An entry no dispatcher has read yet has no group, or isn't pending in it
+func (s *redisStore) AckOutbox(ctx context.Context, id string) error {
+	key := s.keys.outbox()
+	pipe := s.rdb.TxPipeline()
+	pipe.XDel(ctx, key, id)
+	pipe.XAck(ctx, key, outboxGroup, id)
+	if _, err := pipe.Exec(ctx); err != nil && !strings.HasPrefix(err.Error(), "NOGROUP") {
+		return err
+	}
+	return nil
+}
+
+func (s *redisStore) HeadToHead(ctx context.Context, a, b string) (HeadToHeadRecord, error) {
+	hash, err := s.rdb.HGetAll(ctx, s.keys.headToHead(a, b)).Result()
+	if err != nil {
+		return HeadToHeadRecord{}, err
+	}
+	return parseHeadToHead(a, b, hash), nil
+}
+
+// Each day's stats are a hash of counts, read with one pipeline
+func (s *redisStore) DailyStats(ctx context.Context, days []string) ([]DailyStats, error) {
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(days))
+	for i, day := range days {
+		cmds[i] = pipe.HGetAll(ctx, s.keys.daily(day))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	stats := make([]DailyStats, len(days))
+	for i, cmd := range cmds {
+		stats[i] = parseDailyStats(days[i], cmd.Val())
+	}
+	return stats, nil
+}
+
+// Credit the winner and the loser of an ended game, either of which may be
+// "", once for the completion id. The two players' hashes have slots of their
+// own, so their streaks are set one at a time.
+func (s *redisStore) creditResult(ctx context.Context, id, winner, loser string, entry []byte) (*GameCompletion, error) {
+	ttl := completionMarkerTTL.Milliseconds()
+	completion := &GameCompletion{Completed: true}
+	if winner != "" {
+		user := s.keys.user(winner)
+		streak, err := creditStreakScript.Run(ctx, s.rdb, []string{s.keys.applied(user, id), user}, ttl, 1).Int64()
+		if err != nil {
+			return nil, err
+		}
+		completion.Streak = streak
+	}
+	if loser != "" {
+		user := s.keys.user(loser)
+		if err := creditStreakScript.Run(ctx, s.rdb, []string{s.keys.applied(user, id), user}, ttl, 0).Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := []string{s.keys.applied(s.keys.win(), id), s.keys.win(), s.keys.lose(), s.keys.audit()}
+	reply, err := creditResultScript.Run(ctx, s.rdb, keys,
+		ttl, winner, loser, completion.Streak, entry, s.retention.Audit).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	completion.Wins, completion.Losses = reply[0], reply[1]
+	return completion, nil
+}
+
+// Achievements are a sorted set scored by the Unix time they were earned
+func (s *redisStore) AwardAchievement(ctx context.Context, username, name string, at time.Time) (bool, error) {
+	added, err := s.rdb.ZAddNX(ctx, s.keys.achievements(username), &redis.Z{
+		Score:  float64(at.Unix()),
+		Member: name,
+	}).Result()
+	return added == 1, err
+}
+
+func (s *redisStore) Achievements(ctx context.Context, username string) ([]Achievement, error) {
+	entries, err := s.rdb.ZRangeWithScores(ctx, s.keys.achievements(username), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	achievements := make([]Achievement, len(entries))
+	for i, entry := range entries {
+		achievements[i] = Achievement{
+			Name:     entry.Member.(string),
+			EarnedAt: time.Unix(int64(entry.Score), 0).UTC(),
+		}
+	}
+	return achievements, nil
+}
+
+func (s *redisStore) LeaderboardStats(ctx context.Context, pageSize int) StatsIterator {
+	return &redisStatsIterator{store: s, pageSize: pageSize, seen: make(map[string]struct{})}
+}
+
+// Pages through the win hash with HSCAN, reading the page's losses with one
+// HMGET. Only a page of counts is held at once.
+type redisStatsIterator struct {
+	store    *redisStore
+	pageSize int
+	cursor   uint64
+	done     bool
+	err      error
+	// Users already returned, as HSCAN repeats fields when the hash is
+	// resized during the scan. The names are all that is kept of them.
+	seen map[string]struct{}
+}
+
+func (it *redisStatsIterator) Next(ctx context.Context) ([]UserStats, bool) {
+	for !it.done && it.err == nil {
+		fields, cursor, err := it.store.rdb.HScan(ctx, it.store.keys.win(), it.cursor, "", int64(it.pageSize)).Result()
+		if err != nil {
+			it.err = err
+			return nil, false
+		}
+		it.cursor = cursor
+		it.done = cursor == 0
+
+		// fields alternates usernames and their wins
+		page := make([]UserStats, 0, len(fields)/2)
+		usernames := make([]string, 0, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			if _, ok := it.seen[fields[i]]; ok {
+				continue
+			}
+			it.seen[fields[i]] = struct{}{}
+			wins, _ := strconv.ParseInt(fields[i+1], 10, 64)
+			page = append(page, UserStats{Username: fields[i], Win: wins})
+			usernames = append(usernames, fields[i])
+		}
+		if len(page) == 0 {
+			continue
+		}
+		losses, err := it.store.rdb.HMGet(ctx, it.store.keys.lose(), usernames...).Result()
+		if err != nil {
+			it.err = err
+			return nil, false
+		}
+		for i, value := range losses {
+			if value, ok := value.(string); ok {
+				page[i].Lose, _ = strconv.ParseInt(value, 10, 64)
+			}
+		}
+		return page, true
+	}
+	return nil, false
+}
+
+func (it *redisStatsIterator) Err() error {
+	return it.err
+}
+
+func (s *redisStore) RecordWindowResult(ctx context.Context, bucket, username string, isWin bool, ttl time.Duration) error {
+	key := s.keys.window(bucket, isWin)
+	pipe := s.rdb.TxPipeline()
+	pipe.ZIncrBy(ctx, key, 1, username)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) WindowLeaderboard(ctx context.Context, bucket string) ([]UserStats, error) {
+	wins, err := s.rdb.ZRangeWithScores(ctx, s.keys.window(bucket, true), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	loses, err := s.rdb.ZRangeWithScores(ctx, s.keys.window(bucket, false), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]*UserStats, len(wins))
+	count := func(username string) *UserStats {
+		if counts[username] == nil {
+			counts[username] = &UserStats{Username: username}
+		}
+		return counts[username]
+	}
+	for _, z := range wins {
+		count(z.Member.(string)).Win = int64(z.Score)
+	}
+	for _, z := range loses {
+		count(z.Member.(string)).Lose = int64(z.Score)
+	}
+	return statsOf(counts), nil
+}
+
+// ZADD GT keeps the best score; the reply is read back in the same
+// transaction
+func (s *redisStore) RecordSurvivalScore(ctx context.Context, username string, score int64) (int64, error) {
+	pipe := s.rdb.TxPipeline()
+	pipe.ZAddArgs(ctx, s.keys.survival(), redis.ZAddArgs{GT: true, Members: []redis.Z{{Score: float64(score), Member: username}}})
+	best := pipe.ZScore(ctx, s.keys.survival(), username)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int64(best.Val()), nil
+}
+
+func (s *redisStore) SurvivalLeaderboard(ctx context.Context, offset, limit int) ([]SurvivalEntry, error) {
+	runs, err := s.rdb.ZRevRangeWithScores(ctx, s.keys.survival(), int64(offset), int64(offset+limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]SurvivalEntry, len(runs))
+	for i, run := range runs {
+		entries[i] = SurvivalEntry{Username: run.Member.(string), Score: int64(run.Score)}
+	}
+	if len(entries) == 0 {
+		return entries, nil
+	}
+	// The first run ranks below every better one, wherever they are
+	ahead, err := s.rdb.ZCount(ctx, s.keys.survival(), "("+strconv.FormatInt(entries[0].Score, 10), "+inf").Result()
+	if err != nil {
+		return nil, err
+	}
+	rankSurvivalEntries(entries, offset, int(ahead)+1)
+	return entries, nil
+}
+
+// The audit log is a stream of entries, each a JSON-encoded AuditEntry in
+// its "entry" field
+func (s *redisStore) AppendAudit(ctx context.Context, entry AuditEntry) error {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.keys.audit(),
+		MaxLen: s.retention.Audit,
+		Approx: true,
+		Values: []interface{}{"entry", encoded},
+	}).Err()
+}
+
+func (s *redisStore) AuditLog(ctx context.Context, since *streamID, limit int) ([]AuditEntry, error) {
+	start := "-"
+	if since != nil {
+		start = since.next().String()
+	}
+	messages, err := s.rdb.XRangeN(ctx, s.keys.audit(), start, "+", int64(limit)).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]AuditEntry, 0, len(messages))
+	for _, message := range messages {
+		var entry AuditEntry
+		encoded, _ := message.Values["entry"].(string)
+		if err := json.Unmarshal([]byte(encoded), &entry); err != nil {
+			log.Printf("Skipping unreadable audit entry %s: %v", message.ID, err)
+			continue
+		}
+		entry.ID = message.ID
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Move an audit log kept as a list, before the log became a stream, into
+// the stream. The entries keep their times but get new IDs.
+func (s *redisStore) MigrateAuditLog(ctx context.Context) error {
+	kind, err := s.rdb.Type(ctx, s.keys.audit()).Result()
+	if err != nil || kind != "list" {
+		return err
+	}
+	legacy := s.keys.audit() + ":list"
+	if err := s.rdb.Rename(ctx, s.keys.audit(), legacy).Err(); err != nil {
+		return err
+	}
+	entries, err := s.rdb.LRange(ctx, legacy, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	pipe := s.rdb.TxPipeline()
+	for _, entry := range entries {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: s.keys.audit(), Values: []interface{}{"entry", entry}})
+	}
+	pipe.XTrimMaxLenApprox(ctx, s.keys.audit(), s.retention.Audit, 0)
+	pipe.Del(ctx, legacy)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	log.Printf("Moved %d audit entries from a list into the audit stream", len(entries))
+	return nil
+}
+
+func (s *redisStore) NextEventSeq(ctx context.Context, stream string) (int64, error) {
+	return s.rdb.HIncrBy(ctx, s.keys.game(stream), "eventSeq", 1).Result()
+}
+
+func (s *redisStore) AppendEvent(ctx context.Context, stream string, event []byte) error {
+	return s.appendCapped(ctx, s.keys.events(stream), event, s.retention.Events, s.retention.LogTTL)
+}
+
+func (s *redisStore) EventHistory(ctx context.Context, stream string) ([][]byte, int64, error) {
+	pipe := s.rdb.Pipeline()
+	entries := pipe.LRange(ctx, s.keys.events(stream), 0, -1)
+	latest := pipe.HGet(ctx, s.keys.game(stream), "eventSeq")
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, 0, err
+	}
+
+	events := make([][]byte, len(entries.Val()))
+	for i, entry := range entries.Val() {
+		events[i] = []byte(entry)
+	}
+	seq, _ := latest.Int64()
+	return events, seq, nil
+}
+
+func (s *redisStore) NextMoveSeq(ctx context.Context, gameID string) (int64, error) {
+	return s.rdb.HIncrBy(ctx, s.keys.game(gameID), "moveSeq", 1).Result()
+}
+
+func (s *redisStore) AppendMove(ctx context.Context, gameID string, move []byte) error {
+	return s.appendCapped(ctx, s.keys.moves(gameID), move, s.retention.Moves, s.retention.LogTTL)
+}
+
+func (s *redisStore) AppendChat(ctx context.Context, code string, message []byte, limit int64) error {
+	return s.appendCapped(ctx, s.keys.chat(code), message, limit, 0)
+}
+
+func (s *redisStore) RoomChat(ctx context.Context, code string) ([][]byte, error) {
+	entries, err := s.rdb.LRange(ctx, s.keys.chat(code), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	messages := make([][]byte, len(entries))
+	for i, entry := range entries {
+		messages[i] = []byte(entry)
+	}
+	return messages, nil
+}
+
+func (s *redisStore) Moves(ctx context.Context, gameID string) ([][]byte, error) {
+	entries, err := s.rdb.LRange(ctx, s.keys.moves(gameID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	moves := make([][]byte, len(entries))
+	for i, entry := range entries {
+		moves[i] = []byte(entry)
+	}
+	return moves, nil
+}
+
+// KEYS: the game hash, deck, events and moves, then for a room game the room
+// and room state hashes. ARGV: the cutoff in unix ms. Deletes the game if it
+// was marked finished before the cutoff and replies 1; a game restarted
+// since has lost its mark and is left alone.
+var sweepGameScript = redis.NewScript(`
+local finished = tonumber(redis.call('HGET', KEYS[1], 'finishedAt') or '')
+if not finished or finished >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('DEL', KEYS[2], KEYS[3], KEYS[4])
+if #KEYS > 4 then
+	redis.call('DEL', KEYS[1], KEYS[5], KEYS[6], KEYS[7])
+else
+	redis.call('HDEL', KEYS[1], 'finishedAt')
+end
+return 1
+`)
+
+func (s *redisStore) SweepFinishedGames(ctx context.Context, before time.Time) ([]string, error) {
+	var swept []string
+	// A room game's key is tagged inside its ID, so game("*") wouldn't match
+	// it on a cluster
+	err := s.scanKeys(ctx, s.keys.key("game:*"), 100, func(key string) error {
+		if strings.HasSuffix(key, ":moves") {
+			return nil
+		}
+		gameID := s.keys.id(key, "game:")
+		keys := []string{s.keys.game(gameID), s.keys.deck(gameID), s.keys.events(gameID), s.keys.moves(gameID)}
+		if code, ok := roomCodeFromGameID(gameID); ok {
+			keys = append(keys, s.keys.room(code), s.keys.roomState(code), s.keys.chat(code))
+		}
+		deleted, err := sweepGameScript.Run(ctx, s.rdb, keys, before.UnixMilli()).Int()
+		if deleted == 1 {
+			swept = append(swept, gameID)
+		}
+		return err
+	})
+	return swept, err
+}
+
+func (s *redisStore) StorageUsage(ctx context.Context, samples int) ([]KeyUsage, error) {
+	tally := newStorageTally(samples)
+	err := s.scanKeys(ctx, s.keys.key("*"), 1000, func(key string) error {
+		pattern, wanted := tally.count(s.keys.name(key))
+		if !wanted {
+			return nil
+		}
+		bytes, err := s.rdb.MemoryUsage(ctx, key).Result()
+		if err == redis.Nil {
+			// Expired since the scan
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		tally.sample(pattern, bytes)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tally.report(), nil
+}
+
+// Set a hash field to ARGV[2] if it still holds ARGV[1]. KEYS: the hash.
+var replaceFieldScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+return 1
+`)
+
+func (s *redisStore) CheckConsistency(ctx context.Context, report *ConsistencyReport) error {
+	pacer := &scanPacer{}
+	scan := func(kind string, check func(key string) error) error {
+		return s.scanKeys(ctx, s.keys.key(kind+"*"), consistencyScanBatch, func(key string) error {
+			if err := pacer.wait(ctx); err != nil {
+				return err
+			}
+			report.Checked++
+			return check(key)
+		})
+	}
+
+	// A bug once wrote defuse counts to keys of their own, which nothing
+	// reads: the count lives in the user hash
+	err := scan("defuse:", func(key string) error {
+		if err := s.rdb.Del(ctx, key).Err(); err != nil {
+			return err
+		}
+		report.repaired("deleted orphaned key %s", s.keys.name(key))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := scan("user:", func(key string) error { return s.checkUserHash(ctx, report, key) }); err != nil {
+		return err
+	}
+	if err := scan("deck:", func(key string) error { return s.checkDeck(ctx, report, key) }); err != nil {
+		return err
+	}
+	if err := scan("hand:", func(key string) error {
+		_, err := s.hasType(ctx, report, key, "list")
+		return err
+	}); err != nil {
+		return err
+	}
+	return scan("game:", func(key string) error { return s.checkGameHash(ctx, report, key) })
+}
+
+// Whether the key holds a value of type want. One that holds something else
+// is unrepairable; one deleted since the scan is simply skipped.
+func (s *redisStore) hasType(ctx context.Context, report *ConsistencyReport, key, want string) (bool, error) {
+	got, err := s.rdb.Type(ctx, key).Result()
+	if err != nil || got == "none" {
+		return false, err
+	}
+	if got != want {
+		report.unrepairable("%s holds a %s, not a %s", s.keys.name(key), got, want)
+		return false, nil
+	}
+	return true, nil
+}
+
+// A user hash's defuse count must be an integer. Old writes left boolean
+// flags, which become 1 or 0; a streak that isn't an integer can't be
+// guessed at.
+func (s *redisStore) checkUserHash(ctx context.Context, report *ConsistencyReport, key string) error {
+	if ok, err := s.hasType(ctx, report, key, "hash"); !ok || err != nil {
+		return err
+	}
+	fields, err := s.rdb.HMGet(ctx, key, "defuse", "streak").Result()
+	if err != nil {
+		return err
+	}
+	if defuse, ok := fields[0].(string); ok {
+		if _, err := strconv.ParseInt(defuse, 10, 64); err != nil {
+			count, ok := defuseFlagCount(defuse)
+			if !ok {
+				report.unrepairable("%s has defuse %q, which isn't a count", s.keys.name(key), defuse)
+			} else if replaced, err := replaceFieldScript.Run(ctx, s.rdb, []string{key}, "defuse", defuse, count).Int(); err != nil {
+				return err
+			} else if replaced == 1 {
+				report.repaired("set defuse of %s from %q to %s", s.keys.name(key), defuse, count)
+			}
+		}
+	}
+	if streak, ok := fields[1].(string); ok {
+		if _, err := strconv.ParseInt(streak, 10, 64); err != nil {
+			report.unrepairable("%s has streak %q, which isn't a count", s.keys.name(key), streak)
+		}
+	}
+	return nil
+}
+
+// Cards taken out of the game can't be drawn, so they are dropped from the
+// decks still holding them
+func (s *redisStore) checkDeck(ctx context.Context, report *ConsistencyReport, key string) error {
+	if ok, err := s.hasType(ctx, report, key, "list"); !ok || err != nil {
+		return err
+	}
+	deck, err := s.rdb.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	retired := retiredCards(deck)
+	if len(retired) == 0 {
+		return nil
+	}
+	pipe := s.rdb.TxPipeline()
+	for _, card := range retired {
+		pipe.LRem(ctx, key, 0, card)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	report.repaired("dropped retired cards %q from %s", retired, s.keys.name(key))
+	return nil
+}
+
+// A game's move log is a list and the game itself a hash, whose schema
+// version, if set, must be one this server knows
+func (s *redisStore) checkGameHash(ctx context.Context, report *ConsistencyReport, key string) error {
+	if strings.HasSuffix(key, ":moves") {
+		_, err := s.hasType(ctx, report, key, "list")
+		return err
+	}
+	if ok, err := s.hasType(ctx, report, key, "hash"); !ok || err != nil {
+		return err
+	}
+	version, err := s.rdb.HGet(ctx, key, "schemaVersion").Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(version); err != nil || n < 1 || n > currentGameSchema {
+		report.unrepairable("%s has schemaVersion %q", s.keys.name(key), version)
+	}
+	return nil
+}
+
+// A claimed key holds an empty value until the response is saved
+func (s *redisStore) ClaimIdempotencyKey(ctx context.Context, gameID, key string, ttl time.Duration) (bool, error) {
+	return s.rdb.SetNX(ctx, s.keys.idempotency(gameID, key), "", ttl).Result()
+}
+
+func (s *redisStore) GetIdempotentResponse(ctx context.Context, gameID, key string) ([]byte, error) {
+	response, err := s.rdb.Get(ctx, s.keys.idempotency(gameID, key)).Bytes()
+	if err == redis.Nil || len(response) == 0 {
+		return nil, nil
+	}
+	return response, err
+}
+
+func (s *redisStore) SaveIdempotentResponse(ctx context.Context, gameID, key string, response []byte, ttl time.Duration) error {
+	return s.rdb.Set(ctx, s.keys.idempotency(gameID, key), response, ttl).Err()
+}
+
+func (s *redisStore) ReleaseIdempotencyKey(ctx context.Context, gameID, key string) error {
+	return s.rdb.Del(ctx, s.keys.idempotency(gameID, key)).Err()
+}
+
+func (s *redisStore) UserExists(ctx context.Context, username string) (bool, error) {
+	return s.userExists(ctx, s.rdb, username)
+}
+
+func (s *redisStore) userExists(ctx context.Context, rdb redis.Cmdable, username string) (bool, error) {
+	keys, err := rdb.Exists(ctx, s.keys.userKeys(username)...).Result()
+	if err != nil || keys > 0 {
+		return keys > 0, err
+	}
+	if won, err := rdb.HExists(ctx, s.keys.win(), username).Result(); err != nil || won {
+		return won, err
+	}
+	return rdb.HExists(ctx, s.keys.lose(), username).Result()
+}
+
+// The win field doubles as the claim on the name
+func (s *redisStore) CreateGuest(ctx context.Context, username string) (bool, error) {
+	exists, err := s.rdb.Exists(ctx, s.keys.userKeys(username)...).Result()
+	if err != nil || exists > 0 {
+		return false, err
+	}
+	created, err := s.rdb.HSetNX(ctx, s.keys.win(), username, 0).Result()
+	if err != nil || !created {
+		return false, err
+	}
+	pipe := s.multiSlotPipeline()
+	pipe.HSet(ctx, s.keys.lose(), username, 0)
+	pipe.SAdd(ctx, s.keys.guests(), username)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *redisStore) Guests(ctx context.Context) (map[string]bool, error) {
+	members, err := s.rdb.SMembers(ctx, s.keys.guests()).Result()
+	if err != nil {
+		return nil, err
+	}
+	guests := make(map[string]bool, len(members))
+	for _, member := range members {
+		guests[member] = true
+	}
+	return guests, nil
+}
+
+// Finishes are a sorted set of end times in unix ns, scored in unix ms,
+// which expires along with the window
+func (s *redisStore) RecordFinish(ctx context.Context, username string, at time.Time, window time.Duration) (int64, error) {
+	key := s.keys.finishes(username)
+	pipe := s.rdb.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(at.Add(-window).UnixMilli(), 10))
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(at.UnixMilli()), Member: strconv.FormatInt(at.UnixNano(), 10)})
+	pipe.PExpire(ctx, key, window)
+	count := pipe.ZCard(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+func (s *redisStore) FlagUser(ctx context.Context, username, reason string) (bool, error) {
+	return s.rdb.HSetNX(ctx, s.keys.flagged(), username, reason).Result()
+}
+
+func (s *redisStore) UnflagUser(ctx context.Context, username string) (bool, error) {
+	removed, err := s.rdb.HDel(ctx, s.keys.flagged(), username).Result()
+	return removed > 0, err
+}
+
+func (s *redisStore) FlaggedUsers(ctx context.Context) (map[string]string, error) {
+	return s.rdb.HGetAll(ctx, s.keys.flagged()).Result()
+}
+
+func (s *redisStore) SaveAPIKey(ctx context.Context, key APIKey) error {
+	encoded, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, s.keys.apiKeys(), key.ID, encoded).Err()
+}
+
+func (s *redisStore) APIKey(ctx context.Context, id string) (*APIKey, error) {
+	encoded, err := s.rdb.HGet(ctx, s.keys.apiKeys(), id).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var key APIKey
+	if err := json.Unmarshal([]byte(encoded), &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *redisStore) APIKeys(ctx context.Context) ([]APIKey, error) {
+	all, err := s.rdb.HGetAll(ctx, s.keys.apiKeys()).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]APIKey, 0, len(all))
+	for id, encoded := range all {
+		var key APIKey
+		if err := json.Unmarshal([]byte(encoded), &key); err != nil {
+			log.Printf("Skipping unreadable API key %s: %v", id, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *redisStore) RevokeAPIKey(ctx context.Context, id string) (bool, error) {
+	removed, err := s.rdb.HDel(ctx, s.keys.apiKeys(), id).Result()
+	return removed > 0, err
+}
+
+func (s *redisStore) RenameUser(ctx context.Context, from, to string) error {
+	if s.keys.cluster {
+		return s.renameUserAcrossSlots(ctx, from, to)
+	}
+	fromKeys, toKeys := s.keys.userKeys(from), s.keys.userKeys(to)
+
+	txf := func(tx *redis.Tx) error {
+		taken, err := s.userExists(ctx, tx, to)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errUsernameTaken
+		}
+
+		present := make([]bool, len(fromKeys))
+		for i, key := range fromKeys {
+			n, err := tx.Exists(ctx, key).Result()
+			if err != nil {
+				return err
+			}
+			present[i] = n > 0
+		}
+		win, err := tx.HGet(ctx, s.keys.win(), from).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		lose, err := tx.HGet(ctx, s.keys.lose(), from).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		flag, err := tx.HGet(ctx, s.keys.flagged(), from).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range fromKeys {
+				if present[i] {
+					pipe.Rename(ctx, key, toKeys[i])
+				}
+			}
+			if win != "" {
+				pipe.HSet(ctx, s.keys.win(), to, win)
+			}
+			if lose != "" {
+				pipe.HSet(ctx, s.keys.lose(), to, lose)
+			}
+			// A flag follows the player to their new name
+			if flag != "" {
+				pipe.HSet(ctx, s.keys.flagged(), to, flag)
+			}
+			pipe.HDel(ctx, s.keys.win(), from)
+			pipe.HDel(ctx, s.keys.lose(), from)
+			pipe.HDel(ctx, s.keys.flagged(), from)
+			pipe.SRem(ctx, s.keys.guests(), from)
+			return nil
+		})
+		return err
+	}
+
+	watched := append(append([]string{s.keys.win(), s.keys.lose(), s.keys.flagged()}, fromKeys...), toKeys...)
+	for i := 0; i < txRetries; i++ {
+		err := s.rdb.Watch(ctx, txf, watched...)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return redis.TxFailedErr
+}
+
+// RenameUser on a cluster, where the two names' keys are in different slots
+// that neither WATCH nor RENAME can span. Each key is copied with DUMP and
+// RESTORE, then deleted; a RESTORE onto a key written since the check fails
+// rather than overwrite it.
+func (s *redisStore) renameUserAcrossSlots(ctx context.Context, from, to string) error {
+	taken, err := s.userExists(ctx, s.rdb, to)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errUsernameTaken
+	}
+
+	fromKeys, toKeys := s.keys.userKeys(from), s.keys.userKeys(to)
+	for i, key := range fromKeys {
+		dump, err := s.rdb.Dump(ctx, key).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ttl, err := s.rdb.PTTL(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if ttl < 0 {
+			// No TTL
+			ttl = 0
+		}
+		if err := s.rdb.Restore(ctx, toKeys[i], ttl, dump).Err(); err != nil {
+			return err
+		}
+		if err := s.rdb.Del(ctx, key).Err(); err != nil {
+			return err
+		}
+	}
+
+	pipe := s.rdb.Pipeline()
+	win := pipe.HGet(ctx, s.keys.win(), from)
+	lose := pipe.HGet(ctx, s.keys.lose(), from)
+	flag := pipe.HGet(ctx, s.keys.flagged(), from)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return err
+	}
+	pipe = s.rdb.Pipeline()
+	if win.Val() != "" {
+		pipe.HSet(ctx, s.keys.win(), to, win.Val())
+	}
+	if lose.Val() != "" {
+		pipe.HSet(ctx, s.keys.lose(), to, lose.Val())
+	}
+	// A flag follows the player to their new name
+	if flag.Val() != "" {
+		pipe.HSet(ctx, s.keys.flagged(), to, flag.Val())
+	}
+	pipe.HDel(ctx, s.keys.win(), from)
+	pipe.HDel(ctx, s.keys.lose(), from)
+	pipe.HDel(ctx, s.keys.flagged(), from)
+	pipe.SRem(ctx, s.keys.guests(), from)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) DeleteUser(ctx context.Context, username string) ([]string, error) {
+	// The pattern of the win sets matches the lose sets too
+	var windows []string
+	err := s.scanKeys(ctx, s.keys.window("*", true), 100, func(key string) error {
+		windows = append(windows, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A username holds no glob characters, so the pattern matches only the
+	// user's own daily counts
+	keys := s.keys.userKeys(username)
+	err = s.scanKeys(ctx, s.keys.gamesStarted(username, "*"), 100, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	removed := make(map[string]*redis.IntCmd)
+	pipe := s.multiSlotPipeline()
+	for _, key := range keys {
+		removed[key] = pipe.Del(ctx, key)
+	}
+	removed[s.keys.win()] = pipe.HDel(ctx, s.keys.win(), username)
+	removed[s.keys.lose()] = pipe.HDel(ctx, s.keys.lose(), username)
+	for _, key := range windows {
+		removed[key] = pipe.ZRem(ctx, key, username)
+	}
+	removed[s.keys.online()] = pipe.ZRem(ctx, s.keys.online(), username)
+	removed[s.keys.guests()] = pipe.SRem(ctx, s.keys.guests(), username)
+	removed[s.keys.flagged()] = pipe.HDel(ctx, s.keys.flagged(), username)
+	removed[s.keys.seeded()] = pipe.SRem(ctx, s.keys.seeded(), username)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	var summary []string
+	for key, cmd := range removed {
+		if cmd.Val() > 0 {
+			summary = append(summary, s.keys.name(key))
+		}
+	}
+	sort.Strings(summary)
+	return summary, nil
+}
+
+func (s *redisStore) SetProfile(ctx context.Context, username string, profile Profile) error {
+	return s.rdb.HSet(ctx, s.keys.profile(username), "displayName", profile.DisplayName, "avatarEmoji", profile.AvatarEmoji).Err()
+}
+
+func (s *redisStore) Profiles(ctx context.Context, usernames []string) (map[string]Profile, error) {
+	profiles := make(map[string]Profile)
+	if len(usernames) == 0 {
+		return profiles, nil
+	}
+	pipe := s.rdb.Pipeline()
+	fields := make([]*redis.SliceCmd, len(usernames))
+	for i, username := range usernames {
+		fields[i] = pipe.HMGet(ctx, s.keys.profile(username), "displayName", "avatarEmoji")
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for i, username := range usernames {
+		values := fields[i].Val()
+		name, _ := values[0].(string)
+		avatar, _ := values[1].(string)
+		if name != "" || avatar != "" {
+			profiles[username] = Profile{DisplayName: name, AvatarEmoji: avatar}
+		}
+	}
+	return profiles, nil
+}
+
+func (s *redisStore) SeedUsers(ctx context.Context, users []SeedUser) error {
+	for start := 0; start < len(users); start += seedBatchSize {
+		end := start + seedBatchSize
+		if end > len(users) {
+			end = len(users)
+		}
+		pipe := s.rdb.Pipeline()
+		for _, user := range users[start:end] {
+			name := user.Username
+			pipe.HSet(ctx, s.keys.win(), name, user.Wins)
+			pipe.HSet(ctx, s.keys.lose(), name, user.Losses)
+			pipe.HSet(ctx, s.keys.user(name), "streak", user.Streak, "defuse", 0)
+			pipe.Del(ctx, s.keys.game(name), s.keys.deck(name), s.keys.moves(name))
+			pipe.HSet(ctx, s.keys.game(name), "status", user.Status, "gamesPlayed", user.GamesPlayed,
+				"mode", ModeClassic, "schemaVersion", currentGameSchema, "version", 1)
+			if len(user.Deck) > 0 {
+				pipe.RPush(ctx, s.keys.deck(name), user.Deck)
+				pipe.HSet(ctx, s.keys.game(name), "deckVersion", orderedDeckVersion)
+			}
+			if len(user.Moves) > 0 {
+				moves := make([]interface{}, len(user.Moves))
+				for i, move := range user.Moves {
+					moves[i] = move
+				}
+				pipe.RPush(ctx, s.keys.moves(name), moves...)
+				pipe.Expire(ctx, s.keys.moves(name), s.retention.LogTTL)
+			}
+			pipe.SAdd(ctx, s.keys.seeded(), name)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redisStore) DeleteSeeded(ctx context.Context) (int, error) {
+	users, err := s.rdb.SMembers(ctx, s.keys.seeded()).Result()
+	if err != nil {
+		return 0, err
+	}
+	for start := 0; start < len(users); start += seedBatchSize {
+		end := start + seedBatchSize
+		if end > len(users) {
+			end = len(users)
+		}
+		pipe := s.rdb.Pipeline()
+		for _, username := range users[start:end] {
+			pipe.Del(ctx, s.keys.userKeys(username)...)
+			pipe.HDel(ctx, s.keys.win(), username)
+			pipe.HDel(ctx, s.keys.lose(), username)
+			pipe.HDel(ctx, s.keys.flagged(), username)
+			pipe.ZRem(ctx, s.keys.online(), username)
+			pipe.ZRem(ctx, s.keys.survival(), username)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return start, err
+		}
+	}
+	return len(users), s.rdb.Del(ctx, s.keys.seeded()).Err()
+}
+
+func (s *redisStore) TouchPresence(ctx context.Context, username string, at time.Time) (bool, error) {
+	added, err := s.rdb.ZAdd(ctx, s.keys.online(), &redis.Z{Score: float64(at.UnixMilli()), Member: username}).Result()
+	return added == 1, err
+}
+
+func (s *redisStore) OnlineUsers(ctx context.Context, since time.Time) ([]string, error) {
+	return s.rdb.ZRevRangeByScore(ctx, s.keys.online(), &redis.ZRangeBy{
+		Min: strconv.FormatInt(since.UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+}
+
+// Remove and return the members scored below ARGV[1]
+var expirePresenceScript = redis.NewScript(`
+local gone = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[1])
+if #gone > 0 then
+	redis.call('ZREM', KEYS[1], unpack(gone))
+end
+return gone
+`)
+
+func (s *redisStore) ExpirePresence(ctx context.Context, before time.Time) ([]string, error) {
+	gone, err := expirePresenceScript.Run(ctx, s.rdb, []string{s.keys.online()}, before.UnixMilli()).StringSlice()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return gone, err
+}
+
+// ARGV: the device and the TTL in milliseconds. Returns the holder and its
+// TTL, or an empty holder once the device holds the lock.
+var acquireGameLockScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return {'', 0}
+end
+local holder = redis.call('GET', KEYS[1])
+if holder == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return {'', 0}
+end
+return {holder, redis.call('PTTL', KEYS[1])}
+`)
+
+func (s *redisStore) AcquireGameLock(ctx context.Context, gameID, username, deviceID string, ttl time.Duration) (string, time.Duration, error) {
+	result, err := acquireGameLockScript.Run(ctx, s.rdb, []string{s.keys.gameLock(gameID, username)}, deviceID, ttl.Milliseconds()).Slice()
+	if err != nil {
+		return "", 0, err
+	}
+	holder, _ := result[0].(string)
+	left, _ := result[1].(int64)
+	return holder, time.Duration(left) * time.Millisecond, nil
+}
+
+// ARGV: the device and the TTL in milliseconds. Returns the previous holder.
+var takeOverGameLockScript = redis.NewScript(`
+local holder = redis.call('GET', KEYS[1])
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return holder or ''
+`)
+
+func (s *redisStore) TakeOverGameLock(ctx context.Context, gameID, username, deviceID string, ttl time.Duration) (string, error) {
+	return takeOverGameLockScript.Run(ctx, s.rdb, []string{s.keys.gameLock(gameID, username)}, deviceID, ttl.Milliseconds()).Text()
+}
+
+func (s *redisStore) LockGameCreation(ctx context.Context, username, token string, ttl time.Duration) (bool, error) {
+	return s.rdb.SetNX(ctx, s.keys.creating(username), token, ttl).Result()
+}
+
+// Delete the creation lock in KEYS[1] only if it still holds the token in
+// ARGV[1]
+var unlockGameCreationScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (s *redisStore) UnlockGameCreation(ctx context.Context, username, token string) error {
+	return unlockGameCreationScript.Run(ctx, s.rdb, []string{s.keys.creating(username)}, token).Err()
+}
+
+// Drop the user's active games whose game hash is gone, e.g. rooms that
+// expired without finishing, so they don't hold a slot forever
+func (s *redisStore) pruneActiveGames(ctx context.Context, username string) error {
+	games, err := s.rdb.SMembers(ctx, s.keys.activeGames(username)).Result()
+	if err != nil || len(games) == 0 {
+		return err
+	}
+	pipe := s.rdb.Pipeline()
+	exists := make([]*redis.IntCmd, len(games))
+	for i, gameID := range games {
+		exists[i] = pipe.Exists(ctx, s.keys.game(gameID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	var gone []interface{}
+	for i, gameID := range games {
+		if exists[i].Val() == 0 {
+			gone = append(gone, gameID)
+		}
+	}
+	if len(gone) == 0 {
+		return nil
+	}
+	return s.rdb.SRem(ctx, s.keys.activeGames(username), gone...).Err()
+}
+
+// KEYS: active games, started count, user hash. ARGV: game, default caps on
+// active and daily games, started count TTL in seconds. Returns the cap
+// refused ("" if none), that cap, the started count and the active games.
+var claimGameSlotScript = redis.NewScript(`
+local maxActive = tonumber(redis.call('HGET', KEYS[3], 'maxActiveGames') or '0')
+if maxActive <= 0 then
+	maxActive = tonumber(ARGV[2])
+end
+local daily = tonumber(redis.call('HGET', KEYS[3], 'dailyGames') or '0')
+if daily <= 0 then
+	daily = tonumber(ARGV[3])
+end
+local started = tonumber(redis.call('GET', KEYS[2]) or '0')
+if started >= daily then
+	return {'daily_games', daily, started, redis.call('SMEMBERS', KEYS[1])}
+end
+if redis.call('SADD', KEYS[1], ARGV[1]) == 1 and redis.call('SCARD', KEYS[1]) > maxActive then
+	redis.call('SREM', KEYS[1], ARGV[1])
+	return {'active_games', maxActive, started, redis.call('SMEMBERS', KEYS[1])}
+end
+started = redis.call('INCR', KEYS[2])
+redis.call('EXPIRE', KEYS[2], ARGV[4])
+return {'', maxActive, started, redis.call('SMEMBERS', KEYS[1])}
+`)
+
+func (s *redisStore) ClaimGameSlot(ctx context.Context, username, gameID, day string, maxActive, daily int64, ttl time.Duration) (*GameSlot, error) {
+	if err := s.pruneActiveGames(ctx, username); err != nil {
+		return nil, err
+	}
+	keys := []string{s.keys.activeGames(username), s.keys.gamesStarted(username, day), s.keys.user(username)}
+	result, err := claimGameSlotScript.Run(ctx, s.rdb, keys, gameID, maxActive, daily, int64(ttl.Seconds())).Slice()
+	if err != nil {
+		return nil, err
+	}
+	slot := &GameSlot{}
+	slot.Refused, _ = result[0].(string)
+	slot.Limit, _ = result[1].(int64)
+	slot.Started, _ = result[2].(int64)
+	members, _ := result[3].([]interface{})
+	for _, member := range members {
+		if gameID, ok := member.(string); ok {
+			slot.Active = append(slot.Active, gameID)
+		}
+	}
+	sort.Strings(slot.Active)
+	return slot, nil
+}
+
+func (s *redisStore) TrackActiveGame(ctx context.Context, gameID string, usernames []string) error {
+	pipe := s.multiSlotPipeline()
+	for _, username := range usernames {
+		pipe.SAdd(ctx, s.keys.activeGames(username), gameID)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) ReleaseActiveGame(ctx context.Context, gameID string, usernames []string) error {
+	pipe := s.multiSlotPipeline()
+	for _, username := range usernames {
+		pipe.SRem(ctx, s.keys.activeGames(username), gameID)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) GameLimits(ctx context.Context, username string) (GameLimits, error) {
+	fields, err := s.rdb.HMGet(ctx, s.keys.user(username), "maxActiveGames", "dailyGames").Result()
+	if err != nil {
+		return GameLimits{}, err
+	}
+	var limits GameLimits
+	if value, _ := fields[0].(string); value != "" {
+		limits.MaxActiveGames, _ = strconv.ParseInt(value, 10, 64)
+	}
+	if value, _ := fields[1].(string); value != "" {
+		limits.DailyGames, _ = strconv.ParseInt(value, 10, 64)
+	}
+	return limits, nil
+}
+
+func (s *redisStore) SetGameLimits(ctx context.Context, username string, limits GameLimits) error {
+	pipe := s.rdb.TxPipeline()
+	for field, limit := range map[string]int64{"maxActiveGames": limits.MaxActiveGames, "dailyGames": limits.DailyGames} {
+		if limit > 0 {
+			pipe.HSet(ctx, s.keys.user(username), field, limit)
+		} else {
+			pipe.HDel(ctx, s.keys.user(username), field)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) CreateSession(ctx context.Context, token, username string, ttl time.Duration) error {
+	return s.rdb.Set(ctx, s.keys.session(token), username, ttl).Err()
+}
+
+func (s *redisStore) SessionUser(ctx context.Context, token string) (string, error) {
+	username, err := s.rdb.Get(ctx, s.keys.session(token)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return username, err
+}
+
+func (s *redisStore) DeleteSession(ctx context.Context, token string) error {
+	return s.rdb.Del(ctx, s.keys.session(token)).Err()
+}
+
+func (s *redisStore) Ping(ctx context.Context) error {
+	return s.rdb.Ping(ctx).Err()
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"exploding-kitten/engine"
+)
+
+func TestStoreDrawsInDeckOrder(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		if err := store.CreateDeck(ctx, "alice", []string{"Cat", engine.Defuse, engine.ExplodingKitten, "Skip"}); err != nil {
+			t.Fatal(err)
+		}
+		version, err := store.GameVersion(ctx, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		top, err := store.DrawCard(ctx, "alice", "alice", version, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if top.Card != "Cat" || top.Remaining != 3 || top.Cleared {
+			t.Fatalf("top draw = %+v", top)
+		}
+		// The first draw moved the game on
+		if _, err := store.DrawCard(ctx, "alice", "alice", version, false, nil); err != errVersionConflict {
+			t.Fatalf("draw at a stale version: %v, want errVersionConflict", err)
+		}
+		version, _ = store.GameVersion(ctx, "alice")
+		bottom, err := store.DrawCard(ctx, "alice", "alice", version, true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bottom.Card != "Skip" || bottom.Remaining != 2 {
+			t.Fatalf("bottom draw = %+v", bottom)
+		}
+
+		if err := store.InsertCard(ctx, "alice", "Cat", 1); err != nil {
+			t.Fatal(err)
+		}
+		deck, _ := store.GetDeck(ctx, "alice")
+		if want := []string{engine.Defuse, "Cat", engine.ExplodingKitten}; !reflect.DeepEqual(deck, want) {
+			t.Fatalf("deck = %v, want %v", deck, want)
+		}
+	})
+}
+
+func TestStoreReportsClearedDeck(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		store.CreateDeck(ctx, "alice", []string{"Cat", engine.ExplodingKitten})
+		version, _ := store.GameVersion(ctx, "alice")
+		drawn, err := store.DrawCard(ctx, "alice", "alice", version, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !drawn.Cleared || drawn.Remaining != 1 {
+			t.Fatalf("draw = %+v, want the bombs alone left", drawn)
+		}
+	})
+}
+
+func TestStoreHands(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		for _, card := range []string{"Cat", engine.Defuse, "Cat"} {
+			if err := store.HoldCard(ctx, "alice", card); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if defuses, _ := store.GetDefuse(ctx, "alice"); defuses != 1 {
+			t.Fatalf("defuses = %d, want 1", defuses)
+		}
+		if removed, _ := store.RemoveFromHand(ctx, "alice", "Skip"); removed {
+			t.Fatal("removed a card that wasn't held")
+		}
+		if removed, _ := store.RemoveFromHand(ctx, "alice", "Cat"); !removed {
+			t.Fatal("didn't remove a held card")
+		}
+		hand, _ := store.GetHand(ctx, "alice")
+		sort.Strings(hand)
+		if want := []string{"Cat", engine.Defuse}; !reflect.DeepEqual(hand, want) {
+			t.Fatalf("hand = %v, want %v", hand, want)
+		}
+
+		taken, err := store.TakeRandomCard(ctx, "alice", "bob")
+		if err != nil || taken == "" {
+			t.Fatalf("take = %q, %v", taken, err)
+		}
+		if bobHand, _ := store.GetHand(ctx, "bob"); !reflect.DeepEqual(bobHand, []string{taken}) {
+			t.Fatalf("bob's hand = %v, want [%s]", bobHand, taken)
+		}
+		if taken, _ := store.TakeRandomCard(ctx, "carol", "bob"); taken != "" {
+			t.Fatalf("took %q from an empty hand", taken)
+		}
+
+		if _, err := store.StealWithPair(ctx, "alice", "bob", "Tacocat"); err != errPairMissing {
+			t.Fatalf("steal without a pair: %v, want errPairMissing", err)
+		}
+	})
+}
+
+func TestStoreRooms(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		if created, _ := store.CreateRoom(ctx, "ABCD", "alice"); !created {
+			t.Fatal("room not created")
+		}
+		if created, _ := store.CreateRoom(ctx, "ABCD", "bob"); created {
+			t.Fatal("room code taken twice")
+		}
+		room, err := store.JoinRoom(ctx, "ABCD", "bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"alice", "bob"}; !reflect.DeepEqual(room.Players, want) {
+			t.Fatalf("players = %v, want %v", room.Players, want)
+		}
+		if _, err := store.JoinRoom(ctx, "ABCD", "carol"); err != errRoomIsFull {
+			t.Fatalf("joining a full room: %v, want errRoomIsFull", err)
+		}
+		if _, err := store.JoinRoom(ctx, "WXYZ", "carol"); err != errNoSuchRoom {
+			t.Fatalf("joining a missing room: %v, want errNoSuchRoom", err)
+		}
+		if missing, _ := store.GetRoom(ctx, "WXYZ"); missing != nil {
+			t.Fatalf("missing room = %+v", missing)
+		}
+
+		room.Turn, room.Status = "bob", RoomActive
+		if err := store.UpdateRoom(ctx, room); err != nil {
+			t.Fatal(err)
+		}
+		if claimed, _ := store.ClaimTurn(ctx, "ABCD", room.TurnVersion); !claimed {
+			t.Fatal("turn not claimed")
+		}
+		if claimed, _ := store.ClaimTurn(ctx, "ABCD", room.TurnVersion); claimed {
+			t.Fatal("turn claimed twice at one version")
+		}
+		stored, _ := store.GetRoom(ctx, "ABCD")
+		if stored.Turn != "bob" || stored.Status != RoomActive || stored.TurnVersion != room.TurnVersion+1 {
+			t.Fatalf("stored room = %+v", stored)
+		}
+	})
+}
+
+func TestStoreCompletesGameOnce(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		store.CreateDeck(ctx, "alice", []string{"Cat"})
+		store.SetGameStatus(ctx, "alice", GameStatusActive)
+		result := GameResult{GameID: "alice", Status: GameStatusWon, Winner: "alice", Day: "2026-03-02", EndedAt: testEpoch}
+
+		completion, err := store.CompleteGame(ctx, result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !completion.Completed || completion.Wins != 1 || completion.Streak != 1 {
+			t.Fatalf("completion = %+v", completion)
+		}
+		again, err := store.CompleteGame(ctx, result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.Completed {
+			t.Fatal("game completed twice")
+		}
+		if win, lose, _ := store.GetStats(ctx, "alice"); win != 1 || lose != 0 {
+			t.Fatalf("stats = %d/%d, want 1/0", win, lose)
+		}
+		if status, _ := store.GetGameStatus(ctx, "alice"); status != GameStatusWon {
+			t.Fatalf("status = %q", status)
+		}
+		daily, _ := store.DailyStats(ctx, []string{"2026-03-02"})
+		if daily[0].Games != 1 {
+			t.Fatalf("daily stats = %+v, want one game", daily[0])
+		}
+	})
+}
+
+// Fails the nth command or pipeline sent after arm, as a crash of the
+// instance between two of a store call's round trips would leave it
+type failNth struct {
+	mutex sync.Mutex
+	n     int
+}
+
+func (f *failNth) arm(n int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.n = n
+}
+
+func (f *failNth) next() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.n == 0 {
+		return nil
+	}
+	if f.n--; f.n == 0 {
+		return errStoreDown
+	}
+	return nil
+}
+
+func (f *failNth) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, f.next()
+}
+
+func (f *failNth) AfterProcess(ctx context.Context, cmd redis.Cmder) error { return nil }
+
+func (f *failNth) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, f.next()
+}
+
+func (f *failNth) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error { return nil }
+
+// Failing each of CompleteGame's round trips in turn, a single node ends and
+// credits a game together or not at all, and a retry credits it once. The
+// cluster's completion takes several round trips, and a failure after the
+// first leaves the game ended with the rest for FinishCompletions, which
+// makes each write once.
+func TestStoreCompletionIsAllOrNothing(t *testing.T) {
+	for _, cluster := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cluster=%t", cluster), func(t *testing.T) {
+			finished := 0
+			for n := 1; ; n++ {
+				ctx := context.Background()
+				store := newTestRedisStore(t, keyBuilder{cluster: cluster})
+				failing := &failNth{}
+				store.rdb.AddHook(failing)
+				store.CreateDeck(ctx, "bob", []string{"Cat"})
+				store.SetGameStatus(ctx, "bob", GameStatusActive)
+				result := GameResult{
+					GameID: "bob", Status: GameStatusLost, Loser: "bob", Exploded: true,
+					Announcement: []byte(`{}`), Day: "2026-03-02", EndedAt: testEpoch,
+				}
+
+				failing.arm(n)
+				_, err := store.CompleteGame(ctx, result)
+				failing.arm(0)
+				if err == nil {
+					// Every round trip has had its failure
+					break
+				}
+
+				status, _ := store.GetGameStatus(ctx, "bob")
+				_, lose, _ := store.GetStats(ctx, "bob")
+				ended, credited := status == GameStatusLost, lose == 1
+				if ended != credited {
+					if !cluster {
+						t.Fatalf("failing round trip %d: status %q with %d losses", n, status, lose)
+					}
+					// Not before the game's instance has had its chance
+					if count, err := store.FinishCompletions(ctx, testEpoch); err != nil || count != 0 {
+						t.Fatalf("failing round trip %d: finished %d completions early, %v", n, count, err)
+					}
+					if count, err := store.FinishCompletions(ctx, testEpoch.Add(time.Second)); err != nil || count != 1 {
+						t.Fatalf("failing round trip %d: finished %d completions, %v", n, count, err)
+					}
+					finished++
+				}
+
+				retried, err := store.CompleteGame(ctx, result)
+				if err != nil {
+					t.Fatalf("failing round trip %d, the retry: %v", n, err)
+				}
+				if retried.Completed == ended {
+					t.Fatalf("failing round trip %d: retry completed %t after the game ended %t", n, retried.Completed, ended)
+				}
+				if count, err := store.FinishCompletions(ctx, testEpoch.Add(time.Second)); err != nil || count != 0 {
+					t.Fatalf("failing round trip %d: finished %d completions again, %v", n, count, err)
+				}
+				if _, lose, _ := store.GetStats(ctx, "bob"); lose != 1 {
+					t.Fatalf("failing round trip %d: %d losses after the retry, want 1", n, lose)
+				}
+				days, _ := store.DailyStats(ctx, []string{result.Day})
+				if days[0].Games != 1 || days[0].Exploded != 1 {
+					t.Fatalf("failing round trip %d: daily stats %+v, want the game once", n, days[0])
+				}
+				if entries, _ := store.ClaimOutbox(ctx, "check", 0, defaultOutboxBatch); len(entries) != 1 {
+					t.Fatalf("failing round trip %d: %d outbox entries, want 1", n, len(entries))
+				}
+			}
+			if cluster && finished == 0 {
+				t.Fatal("no failure left the cluster's game ended without its loss")
+			}
+		})
+	}
+}
+
+func TestStoreCountsConcurrentWins(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		const games = 100
+		for i := 0; i < games; i++ {
+			gameID := fmt.Sprintf("game%d", i)
+			store.CreateDeck(ctx, gameID, []string{"Cat"})
+			store.SetGameStatus(ctx, gameID, GameStatusActive)
+		}
+
+		wins := make([]int64, games)
+		errs := make([]error, games)
+		var wg sync.WaitGroup
+		for i := 0; i < games; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				result := GameResult{GameID: fmt.Sprintf("game%d", i), Status: GameStatusWon, Winner: "alice", Day: "2026-03-02", EndedAt: testEpoch}
+				completion, err := store.CompleteGame(ctx, result)
+				if err == nil {
+					wins[i] = completion.Wins
+				}
+				errs[i] = err
+			}(i)
+		}
+		wg.Wait()
+
+		// Each game saw its own count: no increment was lost or repeated
+		seen := make(map[int64]bool)
+		for i, err := range errs {
+			if err != nil {
+				t.Fatalf("game %d: %v", i, err)
+			}
+			seen[wins[i]] = true
+		}
+		if len(seen) != games {
+			t.Fatalf("%d distinct win counts, want %d", len(seen), games)
+		}
+		if win, _, _ := store.GetStats(ctx, "alice"); win != games {
+			t.Fatalf("wins = %d, want %d", win, games)
+		}
+	})
+}
+
+func TestStoreIdempotencyKeys(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		if claimed, _ := store.ClaimIdempotencyKey(ctx, "alice", "k1", time.Minute); !claimed {
+			t.Fatal("key not claimed")
+		}
+		if claimed, _ := store.ClaimIdempotencyKey(ctx, "alice", "k1", time.Minute); claimed {
+			t.Fatal("key claimed twice")
+		}
+		if response, _ := store.GetIdempotentResponse(ctx, "alice", "k1"); response != nil {
+			t.Fatalf("response before it was saved: %s", response)
+		}
+		store.SaveIdempotentResponse(ctx, "alice", "k1", []byte(`{"ok":true}`), time.Minute)
+		if response, _ := store.GetIdempotentResponse(ctx, "alice", "k1"); string(response) != `{"ok":true}` {
+			t.Fatalf("response = %s", response)
+		}
+
+		store.ClaimIdempotencyKey(ctx, "alice", "k2", time.Minute)
+		store.ReleaseIdempotencyKey(ctx, "alice", "k2")
+		if claimed, _ := store.ClaimIdempotencyKey(ctx, "alice", "k2", time.Minute); !claimed {
+			t.Fatal("released key not claimable")
+		}
+	})
+}
+
+func TestStoreMatchQueue(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		for i, username := range []string{"alice", "bob", "carol"} {
+			store.EnqueueMatch(ctx, username, testEpoch.Add(time.Duration(i)*time.Second))
+		}
+		// Enqueueing again keeps the place
+		store.EnqueueMatch(ctx, "alice", testEpoch.Add(time.Minute))
+		if position, _ := store.MatchQueuePosition(ctx, "carol"); position != 3 {
+			t.Fatalf("carol's position = %d, want 3", position)
+		}
+		pair, err := store.PopMatch(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"alice", "bob"}; !reflect.DeepEqual(pair, want) {
+			t.Fatalf("pair = %v, want %v", pair, want)
+		}
+		if pair, _ := store.PopMatch(ctx); pair != nil {
+			t.Fatalf("paired %v with one player waiting", pair)
+		}
+		if left, _ := store.LeaveMatchQueue(ctx, "carol"); !left {
+			t.Fatal("carol wasn't queued")
+		}
+	})
+}
+
+func TestStorePresence(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		if isNew, _ := store.TouchPresence(ctx, "alice", testEpoch); !isNew {
+			t.Fatal("alice not new")
+		}
+		if isNew, _ := store.TouchPresence(ctx, "alice", testEpoch.Add(time.Second)); isNew {
+			t.Fatal("alice new twice")
+		}
+		store.TouchPresence(ctx, "bob", testEpoch.Add(2*time.Second))
+		online, _ := store.OnlineUsers(ctx, testEpoch)
+		if want := []string{"bob", "alice"}; !reflect.DeepEqual(online, want) {
+			t.Fatalf("online = %v, want %v", online, want)
+		}
+		expired, _ := store.ExpirePresence(ctx, testEpoch.Add(2*time.Second))
+		if want := []string{"alice"}; !reflect.DeepEqual(expired, want) {
+			t.Fatalf("expired = %v, want %v", expired, want)
+		}
+	})
+}
+
+func TestStoreGameLocks(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		if holder, _, _ := store.AcquireGameLock(ctx, "alice", "alice", "phone", time.Minute); holder != "" {
+			t.Fatalf("lock held by %q", holder)
+		}
+		holder, left, _ := store.AcquireGameLock(ctx, "alice", "alice", "laptop", time.Minute)
+		if holder != "phone" || left <= 0 {
+			t.Fatalf("second device got holder %q, %s left", holder, left)
+		}
+		if previous, _ := store.TakeOverGameLock(ctx, "alice", "alice", "laptop", time.Minute); previous != "phone" {
+			t.Fatalf("took over from %q", previous)
+		}
+		if holder, _, _ := store.AcquireGameLock(ctx, "alice", "alice", "phone", time.Minute); holder != "laptop" {
+			t.Fatalf("lock held by %q after takeover", holder)
+		}
+	})
+}
+
+func TestStoreSessionsAndGuests(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		ctx := context.Background()
+		store.CreateSession(ctx, "token", "alice", time.Hour)
+		if user, _ := store.SessionUser(ctx, "token"); user != "alice" {
+			t.Fatalf("session user = %q", user)
+		}
+		store.DeleteSession(ctx, "token")
+		if user, _ := store.SessionUser(ctx, "token"); user != "" {
+			t.Fatalf("deleted session user = %q", user)
+		}
+
+		if created, _ := store.CreateGuest(ctx, "guest-1"); !created {
+			t.Fatal("guest not created")
+		}
+		if created, _ := store.CreateGuest(ctx, "guest-1"); created {
+			t.Fatal("guest created twice")
+		}
+		guests, _ := store.Guests(ctx)
+		if !guests["guest-1"] {
+			t.Fatalf("guests = %v", guests)
+		}
+	})
+}
+
+// Records every script and transaction whose keys don't all share one hash
+// tag, which a cluster would refuse with CROSSSLOT
+type slotAudit struct {
+	mutex   sync.Mutex
+	crossed []string
+}
+
+// The keys cmd touches: a script's are counted out after it, DEL's are all
+// its arguments and every other command used in a transaction takes one
+func commandKeys(cmd redis.Cmder) []string {
+	args := make([]string, len(cmd.Args()))
+	for i, arg := range cmd.Args() {
+		args[i] = fmt.Sprint(arg)
+	}
+	switch strings.ToLower(args[0]) {
+	case "eval", "evalsha":
+		n, _ := strconv.Atoi(args[2])
+		return args[3 : 3+n]
+	case "del", "unlink":
+		return args[1:]
+	case "multi", "exec":
+		return nil
+	}
+	return args[1:2]
+}
+
+func (a *slotAudit) check(name string, keys []string) {
+	for _, key := range keys[min(1, len(keys)):] {
+		if hashTag(key) != hashTag(keys[0]) {
+			a.mutex.Lock()
+			a.crossed = append(a.crossed, fmt.Sprintf("%s %v", name, keys))
+			a.mutex.Unlock()
+			return
+		}
+	}
+}
+
+func (a *slotAudit) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if name := strings.ToLower(cmd.Name()); name == "eval" || name == "evalsha" {
+		a.check(name, commandKeys(cmd))
+	}
+	return ctx, nil
+}
+
+func (a *slotAudit) AfterProcess(ctx context.Context, cmd redis.Cmder) error { return nil }
+
+// Only transactions must keep to one slot: a plain pipeline's commands are
+// sent to their own slots
+func (a *slotAudit) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	if len(cmds) == 0 || cmds[0].Name() != "multi" {
+		return ctx, nil
+	}
+	var keys []string
+	for _, cmd := range cmds {
+		keys = append(keys, commandKeys(cmd)...)
+	}
+	a.check("transaction", keys)
+	return ctx, nil
+}
+
+func (a *slotAudit) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error { return nil }
+
+func TestClusterKeysShareHashTags(t *testing.T) {
+	store := newTestRedisStore(t, keyBuilder{cluster: true})
+	audit := &slotAudit{}
+	store.rdb.AddHook(audit)
+	ts := newTestServerWith(t, store, testConfig(t, map[string]string{"ADMIN_TOKEN": testAdminToken}))
+
+	// Solo games won and lost
+	ts.startGame("alice", "Cat", engine.ExplodingKitten)
+	decodeOK[DrawCardResponse](t, ts.draw("alice"))
+	ts.startGame("bob", engine.ExplodingKitten, "Cat")
+	decodeOK[DrawCardResponse](t, ts.draw("bob"))
+
+	// A room game, ended head to head
+	room := ts.openRoom("carol", "dave")
+	decodeOK[ForfeitResponse](t, ts.post("/forfeit", User{Username: "dave", GameID: room.gameID()}))
+
+	// The admin API's writes
+	win, lose := int64(7), int64(3)
+	decodeOK[AdminStatsResponse](t, ts.post("/admin/users/alice/stats", AdminStatsRequest{Win: &win, Lose: &lose}, asAdmin...))
+	decodeOK[LeaderboardResponse](t, ts.get("/leaderboard"))
+	if w := ts.request(http.MethodDelete, "/admin/users/bob/game", nil, asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("reset: %d %s", w.Code, w.Body)
+	}
+
+	// A guest who plays, then deletes their account. Claiming a name on a
+	// cluster copies keys with DUMP, which miniredis has only for strings.
+	guest := ts.guest()
+	ts.startGame(guest.Username, "Cat", engine.ExplodingKitten)
+	decodeOK[DrawCardResponse](t, ts.draw(guest.Username))
+	if w := ts.request(http.MethodDelete, "/users/me", DeleteUserRequest{Confirm: guest.Username}, bearer(guest.Token)...); w.Code != http.StatusOK {
+		t.Fatalf("delete account: %d %s", w.Code, w.Body)
+	}
+
+	if win, lose := ts.stats("carol"); win != 1 || lose != 0 {
+		t.Fatalf("carol's stats = %d/%d, want 1/0", win, lose)
+	}
+	for _, crossed := range audit.crossed {
+		t.Errorf("keys in more than one slot: %s", crossed)
+	}
+}
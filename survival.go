@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// One player's best survival run
+type SurvivalEntry struct {
+	Rank     int    `json:"rank"`
+	Username string `json:"username"`
+	// Cards drawn before the bomb that ended the run
+	Score int64 `json:"score"`
+}
+
+// Order runs by score, highest first, then by username, last first, as
+// Redis orders the ties of a sorted set read highest first
+func sortSurvivalEntries(entries []SurvivalEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		return entries[i].Username > entries[j].Username
+	})
+}
+
+// Rank a page of runs in leaderboard order, offset rows from the top. Runs
+// tied on score share a rank, and those tied with the first run can be on
+// earlier pages, so its rank is given.
+func rankSurvivalEntries(entries []SurvivalEntry, offset, first int) {
+	for i := range entries {
+		switch {
+		case i == 0:
+			entries[i].Rank = first
+		case entries[i].Score == entries[i-1].Score:
+			entries[i].Rank = entries[i-1].Rank
+		default:
+			entries[i].Rank = offset + i + 1
+		}
+	}
+}
+
+// The mode /start-game was asked for; "" is classic
+func parseGameMode(mode string) (string, *APIError) {
+	switch mode {
+	case "", ModeClassic:
+		return ModeClassic, nil
+	case ModeSurvival:
+		return ModeSurvival, nil
+	}
+	return "", errInvalidRequest(`mode must be "classic" or "survival"`)
+}
+
+// Fill in game.Mode the first time a draw needs it. Rooms are always
+// classic; a solo game's mode is kept in its game hash, and comes along
+// with the draw state when that has been read.
+func (s *Server) loadMode(ctx context.Context, game *GameSession) *APIError {
+	if game.Mode != "" {
+		return nil
+	}
+	if game.Room != nil {
+		game.Mode = ModeClassic
+		return nil
+	}
+	if game.draw != nil {
+		game.Mode = game.draw.Mode
+		return nil
+	}
+	mode, err := s.store.GameMode(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error retrieving mode of game %s: %v", game.ID, err)
+		return errStoreUnavailable("Error retrieving game mode")
+	}
+	game.Mode = mode
+	return nil
+}
+
+// End a survival run, on the bomb just drawn if onBomb or else on the card
+// that left nothing but bombs: the score is every card drawn before the
+// bomb. The run counts for the survival leaderboard only, not for wins and
+// losses.
+func (s *Server) endSurvivalRun(ctx context.Context, game *GameSession, card Card, onBomb bool) (*DrawCardResponse, *APIError) {
+	_, drawn, err := s.store.GameProgress(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error retrieving progress of game %s: %v", game.ID, err)
+		return nil, errStoreUnavailable("Error retrieving game progress")
+	}
+	// The bomb that ended the run doesn't count
+	score := drawn
+	if onBomb {
+		score--
+	}
+	if score < 0 {
+		score = 0
+	}
+
+	outcome := gameOutcome{Loser: game.Username, LoserResult: "lose", Reveal: true, Unranked: true}
+	if _, apiErr := s.completeGame(ctx, game, outcome); apiErr != nil {
+		return nil, apiErr
+	}
+	best, err := s.store.RecordSurvivalScore(ctx, game.Username, score)
+	if err != nil {
+		// The run is over either way; only the leaderboard misses it
+		log.Printf("Error recording survival score of user %s: %v", game.Username, err)
+		best = score
+	}
+	logGameEvent(game.Username, game.ID, map[string]any{"event": "survival_over", "score": score, "best": best})
+	s.reportGameFinished(ctx, game, game.Username, "lose")
+	s.announceGameOver(ctx, game, outcome)
+
+	return &DrawCardResponse{
+		Message:    localize(ctx, MsgSurvivalOver, score, best),
+		MessageID:  MsgSurvivalOver,
+		Card:       card,
+		GameStatus: GameStatusLost,
+		Score:      score,
+		BestScore:  best,
+	}, nil
+}
+
+// Leaderboard route with ?mode=survival: up to ?limit= best runs after the
+// first ?offset=, highest first. Players tied on score share a rank.
+func (s *Server) getSurvivalLeaderboard(c *gin.Context) {
+	offset, limit, apiErr := parseLeaderboardPage(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	entries, err := s.store.SurvivalLeaderboard(c.Request.Context(), offset, limit)
+	if err != nil {
+		log.Printf("Error fetching survival leaderboard: %v", err)
+		abortWithError(c, errStoreUnavailable("Error fetching leaderboard"))
+		return
+	}
+	c.JSON(http.StatusOK, LeaderboardResponse{
+		Leaderboard: []LeaderboardEntry{},
+		Survival:    entries,
+		Next:        nextLeaderboardPage(offset, limit, len(entries)),
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// Start a survival run for the player
+func (ts *testServer) startSurvival(username string, deck ...string) {
+	ts.t.Helper()
+	started := decodeOK[StartGameResponse](ts.t, ts.post("/start-game", User{Username: username, Mode: ModeSurvival}))
+	if started.Snapshot.Mode != ModeSurvival || started.Snapshot.Remaining != survivalDeckConfig.Size {
+		ts.t.Fatalf("started %s with %d cards", started.Snapshot.Mode, started.Snapshot.Remaining)
+	}
+	if len(deck) > 0 {
+		ts.setDeck(username, deck...)
+	}
+}
+
+// The player's best run on the survival leaderboard, -1 if they have none
+func (ts *testServer) survivalBest(username string) int64 {
+	ts.t.Helper()
+	for _, entry := range decodeOK[LeaderboardResponse](ts.t, ts.get("/leaderboard?mode=survival")).Survival {
+		if entry.Username == username {
+			return entry.Score
+		}
+	}
+	return -1
+}
+
+func TestSurvivalScoreIsDrawsBeforeBomb(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startSurvival("alice", "Cat", "Tacocat", "Cat", "Beard Cat", "Cat", engine.ExplodingKitten, "Cat")
+		for i := 0; i < 5; i++ {
+			if drawn := decodeOK[DrawCardResponse](t, ts.draw("alice")); drawn.GameStatus != GameStatusActive {
+				t.Fatalf("draw %d = %+v", i+1, drawn)
+			}
+		}
+		over := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if over.GameStatus != GameStatusLost || over.MessageID != MsgSurvivalOver || over.Score != 5 || over.BestScore != 5 {
+			t.Fatalf("draw of the bomb = %+v, want a score of 5", over)
+		}
+		if best := ts.survivalBest("alice"); best != 5 {
+			t.Fatalf("survival leaderboard has %d for alice", best)
+		}
+		// The run doesn't count as a loss
+		if win, lose := ts.stats("alice"); win != 0 || lose != 0 {
+			t.Fatalf("stats = %d/%d after a survival run", win, lose)
+		}
+
+		// A shorter run keeps the best
+		ts.startSurvival("alice", "Cat", engine.ExplodingKitten)
+		decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		over = decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		if over.Score != 1 || over.BestScore != 5 || ts.survivalBest("alice") != 5 {
+			t.Fatalf("second run = %+v, want 1 with the best kept at 5", over)
+		}
+	})
+}
+
+// A run on the deck survival deals, played out whatever the shuffle, scores
+// every draw but the bomb's
+func TestSurvivalRunScoresEveryDraw(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startSurvival("alice")
+		useDefuse := true
+		var draws int64
+		var drawn DrawCardResponse
+		for drawn.GameStatus != GameStatusLost {
+			if drawn.GameStatus == GameStatusPendingDefuse {
+				drawn = decodeOK[DrawCardResponse](t, ts.post("/resolve-bomb", ResolveBombRequest{Username: "alice", UseDefuse: &useDefuse}))
+				continue
+			}
+			// Cats pile up past the hand limit
+			if drawn.GameStatus == GameStatusMustDiscard {
+				discarded := decodeOK[DiscardResponse](t, ts.post("/discard", DiscardRequest{Username: "alice", Card: "Cat"}))
+				drawn.GameStatus = discarded.GameStatus
+				continue
+			}
+			if draws > int64(survivalDeckConfig.Size)*2 {
+				t.Fatalf("still going after %d draws", draws)
+			}
+			drawn = decodeOK[DrawCardResponse](t, ts.draw("alice"))
+			draws++
+		}
+		if drawn.Card.Type != engine.ExplodingKitten || drawn.Score != draws-1 {
+			t.Fatalf("run ended on %s with a score of %d after %d draws", drawn.Card.Type, drawn.Score, draws)
+		}
+		if best := ts.survivalBest("alice"); best != draws-1 {
+			t.Fatalf("survival leaderboard has %d, want %d", best, draws-1)
+		}
+	})
+}
+
+func TestSurvivalLeaderboardPages(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		for username, score := range map[string]int64{"amy": 9, "ben": 7, "cal": 7, "dee": 7, "eve": 3} {
+			if _, err := ts.store.RecordSurvivalScore(context.Background(), username, score); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Ties are listed by username, last first, and keep their rank on
+		// the page after the first of them
+		for offset, want := range map[int]string{0: "1:amy 2:dee", 2: "2:cal 2:ben", 4: "5:eve", 5: ""} {
+			response := decodeOK[LeaderboardResponse](t, ts.get(fmt.Sprintf("/leaderboard?mode=survival&limit=2&offset=%d", offset)))
+			rows := make([]string, len(response.Survival))
+			for i, entry := range response.Survival {
+				rows[i] = fmt.Sprintf("%d:%s", entry.Rank, entry.Username)
+			}
+			if got := strings.Join(rows, " "); got != want {
+				t.Fatalf("offset %d: got %s, want %s", offset, got, want)
+			}
+			wantNext := 0
+			if len(rows) == 2 {
+				wantNext = offset + 2
+			}
+			if response.Next != wantNext {
+				t.Fatalf("offset %d: next = %d, want %d", offset, response.Next, wantNext)
+			}
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"exploding-kitten/engine"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The theme whose art is the card registry's own
+const ThemeClassic = "classic"
+
+// What a theme draws a card with in place of the registry's art
+type CardArt struct {
+	Emoji     string
+	Color     string
+	ImageSlug string
+}
+
+// A set of art for the cards, keyed by card type. The types stay the same,
+// so the game plays the same whatever the theme.
+type CardTheme struct {
+	Name string
+	Art  map[string]CardArt
+}
+
+// The registered themes but classic, by name
+var cardThemes = map[string]CardTheme{}
+
+// Add a theme. A theme without a name or one whose name is taken is a
+// programming error and panics at startup; one that misses a card is caught
+// by validateThemes.
+func registerTheme(theme CardTheme) {
+	if theme.Name == "" {
+		panic("card themes: theme without a name")
+	}
+	if _, ok := cardThemes[theme.Name]; ok || theme.Name == ThemeClassic {
+		panic(fmt.Sprintf("card themes: theme %q is registered twice", theme.Name))
+	}
+	cardThemes[theme.Name] = theme
+}
+
+func init() {
+	registerTheme(CardTheme{
+		Name: "space",
+		Art: map[string]CardArt{
+			"Cat":                  {Emoji: "👽", Color: "#3949ab", ImageSlug: "space-cat"},
+			engine.Defuse:          {Emoji: "🛡️", Color: "#00acc1", ImageSlug: "space-defuse"},
+			engine.Shuffle:         {Emoji: "🌀", Color: "#7e57c2", ImageSlug: "space-shuffle"},
+			engine.ExplodingKitten: {Emoji: "☄️", Color: "#ff3d00", ImageSlug: "space-exploding-kitten"},
+			"Favor":                {Emoji: "🛸", Color: "#ffb300", ImageSlug: "space-favor"},
+			"Skip":                 {Emoji: "🚀", Color: "#1565c0", ImageSlug: "space-skip"},
+			"Attack":               {Emoji: "🔫", Color: "#b71c1c", ImageSlug: "space-attack"},
+			"Nope":                 {Emoji: "🕳️", Color: "#212121", ImageSlug: "space-nope"},
+			"Draw From Bottom":     {Emoji: "🛰️", Color: "#00897b", ImageSlug: "space-draw-from-bottom"},
+			"See the Future":       {Emoji: "🔭", Color: "#6a1b9a", ImageSlug: "space-see-the-future"},
+			"Tacocat":              {Emoji: "🪐", Color: "#ef6c00", ImageSlug: "space-tacocat"},
+			"Rainbow Cat":          {Emoji: "🌌", Color: "#d81b60", ImageSlug: "space-rainbow-cat"},
+			"Beard Cat":            {Emoji: "👨‍🚀", Color: "#5d4037", ImageSlug: "space-beard-cat"},
+		},
+	})
+}
+
+// Check every theme draws every registered card, and only those. Run at
+// startup, once every card and theme is registered.
+func validateThemes() error {
+	var problems []string
+	for name, theme := range cardThemes {
+		for _, def := range cardRegistry {
+			art, ok := theme.Art[def.Type]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("theme %s has no art for %s", name, def.Type))
+				continue
+			}
+			if art.Emoji == "" || art.Color == "" || art.ImageSlug == "" {
+				problems = append(problems, fmt.Sprintf("theme %s has incomplete art for %s", name, def.Type))
+			}
+		}
+		for cardType := range theme.Art {
+			if _, ok := lookupCard(cardType); !ok {
+				problems = append(problems, fmt.Sprintf("theme %s has art for unknown card %s", name, cardType))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("card themes: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func knownTheme(name string) bool {
+	_, ok := cardThemes[name]
+	return ok || name == ThemeClassic
+}
+
+// The card with the theme's art in place of the registry's. Classic, "" and
+// unknown themes leave it as it is.
+func themedCard(theme string, card Card) Card {
+	if art, ok := cardThemes[theme].Art[card.Type]; ok {
+		card.Emoji, card.Color, card.ImageSlug = art.Emoji, art.Color, art.ImageSlug
+	}
+	return card
+}
+
+type themeContextKey struct{}
+
+// The theme attached to ctx, or classic
+func themeFrom(ctx context.Context) string {
+	if theme, ok := ctx.Value(themeContextKey{}).(string); ok {
+		return theme
+	}
+	return ThemeClassic
+}
+
+// Gin middleware attaching the theme in ?theme= to the request context,
+// refusing one that isn't registered
+func themeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		theme := c.Query("theme")
+		if theme == "" {
+			c.Next()
+			return
+		}
+		if !knownTheme(theme) {
+			abortWithError(c, errInvalidRequest(fmt.Sprintf("Unknown theme %q", theme)))
+			return
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), themeContextKey{}, theme))
+		c.Next()
+	}
+}
+
+// The theme a game's cards are shown in: the room's, so everyone in it sees
+// the same cards, or in a solo game the one the request asked for
+func cardTheme(ctx context.Context, game *GameSession) string {
+	if game.Room != nil {
+		return game.Room.theme()
+	}
+	return themeFrom(ctx)
+}
+
+// The theme the room's cards are shown in
+func (r *Room) theme() string {
+	if r.Theme == "" {
+		return ThemeClassic
+	}
+	return r.Theme
+}
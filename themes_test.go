@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// Register a theme for the length of the test
+func registerTestTheme(t *testing.T, theme CardTheme) {
+	t.Helper()
+	registerTheme(theme)
+	t.Cleanup(func() { delete(cardThemes, theme.Name) })
+}
+
+func TestThemeValidationNeedsEveryCard(t *testing.T) {
+	if err := validateThemes(); err != nil {
+		t.Fatalf("registered themes: %v", err)
+	}
+
+	art := map[string]CardArt{}
+	for _, def := range cardRegistry {
+		art[def.Type] = CardArt{Emoji: "🐟", Color: "#0277bd", ImageSlug: "sea-" + def.Type}
+	}
+	delete(art, "Nope")
+	art["Skip"] = CardArt{Emoji: "🐠"}
+	art["Barking Kitten"] = CardArt{Emoji: "🦈", Color: "#263238", ImageSlug: "sea-barking-kitten"}
+	registerTestTheme(t, CardTheme{Name: "sea", Art: art})
+
+	err := validateThemes()
+	if err == nil {
+		t.Fatal("a theme missing a card passed validation")
+	}
+	for _, problem := range []string{
+		"theme sea has no art for Nope",
+		"theme sea has incomplete art for Skip",
+		"theme sea has art for unknown card Barking Kitten",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Fatalf("error %q doesn't say %q", err, problem)
+		}
+	}
+
+	for _, name := range []string{"", ThemeClassic, "space"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("registering theme %q didn't panic", name)
+				}
+			}()
+			registerTheme(CardTheme{Name: name, Art: art})
+		}()
+	}
+}
+
+func TestSpaceRoomShowsThemedCards(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		assertError(t, ts.post("/create-room", CreateRoomRequest{Username: "carol", Theme: "sea"}), http.StatusBadRequest, ErrCodeInvalidRequest)
+
+		created := decodeOK[RoomResponse](t, ts.post("/create-room", CreateRoomRequest{Username: "alice", Theme: "space"}))
+		decodeOK[RoomResponse](t, ts.post("/join-room", RoomRequest{Username: "bob", Code: created.Code}))
+		room := ts.room(created.Code)
+		if room.Theme != "space" {
+			t.Fatalf("room theme = %q", room.Theme)
+		}
+		ts.setDeck(room.gameID(), "Cat", "Skip", engine.ExplodingKitten)
+		ts.deal(room.Turn, engine.Defuse)
+
+		// The room's theme wins over the request's, and keeps the types
+		// and text
+		spaceCat := themedCard("space", *findCard(ThemeClassic, "Cat"))
+		if spaceCat.Emoji != "👽" || spaceCat.ImageSlug != "space-cat" || spaceCat.ShortDesc != findCard(ThemeClassic, "Cat").ShortDesc {
+			t.Fatalf("space Cat = %+v", spaceCat)
+		}
+		drawn := decodeOK[DrawCardResponse](t, ts.post("/draw-card?theme=classic", User{Username: room.Turn, GameID: room.gameID()}))
+		if drawn.Card != spaceCat {
+			t.Fatalf("drawn card = %+v, want %+v", drawn.Card, spaceCat)
+		}
+		snapshot := decodeOK[GameSnapshot](t, ts.get("/game/"+room.gameID()+"/snapshot?username="+room.Turn))
+		want := []Card{*findCard("space", engine.Defuse), spaceCat}
+		if len(snapshot.Hand) != 2 || snapshot.Hand[0] != want[0] || snapshot.Hand[1] != want[1] {
+			t.Fatalf("snapshot hand = %+v, want %+v", snapshot.Hand, want)
+		}
+	})
+}
+
+func TestCardsRouteTakesTheme(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	cards := decodeOK[CardsResponse](t, ts.get("/cards?theme=space"))
+	for i, card := range cards.Cards {
+		if card.Card != themedCard("space", cardRegistry[i].Card) || card.Card == cardRegistry[i].Card {
+			t.Fatalf("card %d = %+v", i, card.Card)
+		}
+	}
+	assertError(t, ts.get("/cards?theme=sea"), http.StatusBadRequest, ErrCodeInvalidRequest)
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Players a tournament may have
+const (
+	minTournamentPlayers = 2
+	maxTournamentPlayers = 64
+)
+
+// How long a tournament is kept, finished or not, and how long a player has
+// to show up for a match before they forfeit it, unless
+// TOURNAMENT_NO_SHOW_TIMEOUT says otherwise
+const (
+	tournamentTTL        = 7 * 24 * time.Hour
+	defaultNoShowTimeout = 2 * time.Minute
+)
+
+// Tournament statuses
+const (
+	TournamentRegistering = "registering"
+	TournamentRunning     = "running"
+	TournamentFinished    = "finished"
+)
+
+// Statuses of a match in a bracket
+const (
+	// A player is still to come out of an earlier match
+	BracketWaiting  = "waiting"
+	BracketPlaying  = "playing"
+	BracketFinished = "finished"
+	// The match had one player, who went through without playing
+	BracketBye = "bye"
+)
+
+// Returned by the tournament updates below to leave the tournament as it was
+var (
+	errRegistrationClosed = errors.New("tournament registration is closed")
+	errMatchDecided       = errors.New("match already decided")
+)
+
+// A single-elimination tournament. Each match is played in a two-player room
+// of its own, and its winner goes on to the next round.
+type Tournament struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Who created it
+	Organizer string `json:"organizer"`
+	// Players it takes; the bracket is drawn once they have all joined
+	Size int `json:"size"`
+	// In seed order, best first: the order they were listed or joined in
+	Players []string `json:"players"`
+	// The first round first. The winner of match i of a round plays in
+	// match i/2 of the next; the last round is the final.
+	Rounds    [][]BracketMatch `json:"rounds,omitempty"`
+	Winner    string           `json:"winner,omitempty"`
+	CreatedAt time.Time        `json:"createdAt"`
+}
+
+// One match of a bracket
+type BracketMatch struct {
+	// Both of its players, "" for one still to come out of an earlier match
+	// or missing from a bye
+	Players []string `json:"players"`
+	Status  string   `json:"status"`
+	// The room it is being played in, and since when
+	Room      string     `json:"room,omitempty"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	Winner    string     `json:"winner,omitempty"`
+	// Games started for it; a game nobody won is played again
+	Games int `json:"games,omitempty"`
+}
+
+// Where a match is in a bracket
+type matchRef struct {
+	Round int
+	Index int
+}
+
+type CreateTournamentRequest struct {
+	// The players, best seed first. The bracket is drawn and its first
+	// round started right away.
+	Players []string `json:"players"`
+	// Players to take by registration instead, with POST
+	// /tournaments/:id/join
+	Size int `json:"size"`
+}
+
+// Create and join tournament routes
+type TournamentResponse struct {
+	Message    string      `json:"message"`
+	Tournament *Tournament `json:"tournament"`
+}
+
+// Pushed to the sockets following a tournament whenever its bracket changes
+type TournamentEvent struct {
+	// "bracket_updated"
+	Type       string      `json:"type"`
+	Tournament *Tournament `json:"tournament"`
+}
+
+func newTournamentID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Slots in a bracket of size, a power of two, in seed order from the top:
+// 0, 7, 3, 4, 1, 6, 2, 5 for 8. The best seeds meet as late as they can.
+func bracketOrder(size int) []int {
+	order := []int{0}
+	for n := 1; n < size; n *= 2 {
+		next := make([]int, 0, 2*n)
+		for _, seed := range order {
+			next = append(next, seed, 2*n-1-seed)
+		}
+		order = next
+	}
+	return order
+}
+
+func (t *Tournament) match(ref matchRef) *BracketMatch {
+	return &t.Rounds[ref.Round][ref.Index]
+}
+
+// The match being played in the room
+func (t *Tournament) matchIn(code string) (matchRef, bool) {
+	for r, round := range t.Rounds {
+		for i, match := range round {
+			if match.Room == code {
+				return matchRef{Round: r, Index: i}, true
+			}
+		}
+	}
+	return matchRef{}, false
+}
+
+// The player's seed, from 0 for the best
+func (t *Tournament) seed(username string) int {
+	for i, player := range t.Players {
+		if player == username {
+			return i
+		}
+	}
+	return len(t.Players)
+}
+
+// Draw the bracket for the players, sending the best seeds through the
+// byes a field short of a power of two needs, and claim the first matches
+func (t *Tournament) start() []matchRef {
+	slots := 2
+	for slots < len(t.Players) {
+		slots *= 2
+	}
+	t.Rounds = nil
+	for matches := slots / 2; matches >= 1; matches /= 2 {
+		round := make([]BracketMatch, matches)
+		for i := range round {
+			round[i] = BracketMatch{Players: []string{"", ""}, Status: BracketWaiting}
+		}
+		t.Rounds = append(t.Rounds, round)
+	}
+
+	order := bracketOrder(slots)
+	for i := range t.Rounds[0] {
+		match := &t.Rounds[0][i]
+		for slot, seed := range order[2*i : 2*i+2] {
+			if seed < len(t.Players) {
+				match.Players[slot] = t.Players[seed]
+			}
+		}
+		// The better seed has the first slot, so a bye always leaves the
+		// second empty
+		if match.Players[1] == "" {
+			match.Status = BracketBye
+			t.advance(matchRef{Index: i}, match.Players[0])
+		}
+	}
+	t.Status = TournamentRunning
+	return t.claimReady()
+}
+
+// Send the match's winner on to the next round, or make them the winner of
+// the tournament after the final
+func (t *Tournament) advance(ref matchRef, winner string) {
+	t.match(ref).Winner = winner
+	if ref.Round == len(t.Rounds)-1 {
+		t.Winner = winner
+		t.Status = TournamentFinished
+		return
+	}
+	t.Rounds[ref.Round+1][ref.Index/2].Players[ref.Index%2] = winner
+}
+
+// Mark every match whose players are both known, but which isn't being
+// played yet, as playing. Returns them for the caller to start.
+func (t *Tournament) claimReady() []matchRef {
+	var ready []matchRef
+	for r, round := range t.Rounds {
+		for i := range round {
+			match := &round[i]
+			if match.Status == BracketWaiting && match.Players[0] != "" && match.Players[1] != "" {
+				match.Status = BracketPlaying
+				match.Room = ""
+				ready = append(ready, matchRef{Round: r, Index: i})
+			}
+		}
+	}
+	return ready
+}
+
+// Create tournament route. With a list of players the first round starts
+// at once; with a size, once that many have joined.
+func (s *Server) createTournament(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	_, organizer, apiErr := s.sessionUser(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	var req CreateTournamentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error parsing request: %v", err)
+		abortWithError(c, errInvalidRequest("Invalid request"))
+		return
+	}
+	if len(req.Players) > 0 {
+		if req.Size != 0 {
+			abortWithError(c, errInvalidRequest("Give either players or size, not both"))
+			return
+		}
+		req.Size = len(req.Players)
+	}
+	if req.Size < minTournamentPlayers || req.Size > maxTournamentPlayers {
+		abortWithError(c, errInvalidRequest(fmt.Sprintf("A tournament takes %d to %d players", minTournamentPlayers, maxTournamentPlayers)))
+		return
+	}
+	listed := make(map[string]bool, len(req.Players))
+	for _, username := range req.Players {
+		if !usernamePattern.MatchString(username) || isBot(username) {
+			abortWithError(c, errInvalidRequest(fmt.Sprintf("Invalid player %q", username)))
+			return
+		}
+		if listed[username] {
+			abortWithError(c, errInvalidRequest(fmt.Sprintf("Player %q is listed twice", username)))
+			return
+		}
+		listed[username] = true
+	}
+
+	id, err := newTournamentID()
+	if err != nil {
+		log.Printf("Error generating tournament ID: %v", err)
+		abortWithError(c, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "Error creating tournament"))
+		return
+	}
+	tournament := &Tournament{
+		ID:        id,
+		Status:    TournamentRegistering,
+		Organizer: organizer,
+		Size:      req.Size,
+		Players:   append([]string{}, req.Players...),
+		CreatedAt: s.clock.Now().UTC(),
+	}
+	var ready []matchRef
+	if len(tournament.Players) == tournament.Size {
+		ready = tournament.start()
+	}
+	if err := s.store.CreateTournament(ctx, tournament, tournamentTTL); err != nil {
+		log.Printf("Error creating tournament %s: %v", id, err)
+		abortWithError(c, errStoreUnavailable("Error creating tournament"))
+		return
+	}
+
+	log.Printf("User %s created tournament %s for %d players", organizer, id, tournament.Size)
+	tournament = s.launchMatches(ctx, tournament, ready)
+	c.JSON(http.StatusOK, TournamentResponse{Message: "Tournament created", Tournament: tournament})
+}
+
+// Join tournament route: register the session's user. The player who fills
+// the tournament draws its bracket and starts the first round.
+func (s *Server) joinTournament(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	_, username, apiErr := s.sessionUser(c)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	id := c.Param("id")
+
+	var ready []matchRef
+	joined := false
+	tournament, err := s.store.UpdateTournament(ctx, id, func(t *Tournament) error {
+		ready, joined = nil, false
+		if t.seed(username) < len(t.Players) {
+			return nil
+		}
+		if t.Status != TournamentRegistering {
+			return errRegistrationClosed
+		}
+		t.Players = append(t.Players, username)
+		joined = true
+		if len(t.Players) == t.Size {
+			ready = t.start()
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errNoTournament):
+		abortWithError(c, errTournamentNotFound())
+		return
+	case errors.Is(err, errRegistrationClosed):
+		abortWithError(c, errTournamentClosed())
+		return
+	case err != nil:
+		log.Printf("Error joining tournament %s for user %s: %v", id, username, err)
+		abortWithError(c, errStoreUnavailable("Error joining tournament"))
+		return
+	}
+
+	if joined {
+		log.Printf("User %s joined tournament %s", username, id)
+		tournament = s.launchMatches(ctx, tournament, ready)
+	}
+	c.JSON(http.StatusOK, TournamentResponse{Message: "Joined tournament", Tournament: tournament})
+}
+
+// Get tournament route: the bracket as it stands
+func (s *Server) getTournament(c *gin.Context) {
+	tournament, err := s.store.GetTournament(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		log.Printf("Error retrieving tournament %s: %v", c.Param("id"), err)
+		abortWithError(c, errStoreUnavailable("Error retrieving tournament"))
+		return
+	}
+	if tournament == nil {
+		abortWithError(c, errTournamentNotFound())
+		return
+	}
+	c.JSON(http.StatusOK, tournament)
+}
+
+// Start a room game for each of the matches claimed, then push the bracket
+// to the tournament's followers. Returns the bracket as it was left. A match
+// that couldn't be started goes back to waiting, to be tried again the next
+// time the bracket moves.
+func (s *Server) launchMatches(ctx context.Context, tournament *Tournament, ready []matchRef) *Tournament {
+	for _, ref := range ready {
+		updated, err := s.startBracketMatch(ctx, tournament, ref)
+		if err != nil {
+			log.Printf("Error starting match %d of round %d of tournament %s: %v", ref.Index+1, ref.Round+1, tournament.ID, err)
+			updated, err = s.store.UpdateTournament(ctx, tournament.ID, func(t *Tournament) error {
+				t.match(ref).Status = BracketWaiting
+				return nil
+			})
+			if err != nil {
+				log.Printf("Error putting back match %d of round %d of tournament %s: %v", ref.Index+1, ref.Round+1, tournament.ID, err)
+				continue
+			}
+		}
+		tournament = updated
+	}
+	s.hub.broadcastTournament(tournament.ID, TournamentEvent{Type: "bracket_updated", Tournament: tournament})
+	return tournament
+}
+
+// Seat a claimed match's players in a new room, record it in the bracket
+// and start the game, telling each of them where it is
+func (s *Server) startBracketMatch(ctx context.Context, tournament *Tournament, ref matchRef) (*Tournament, error) {
+	players := tournament.match(ref).Players
+	code, err := s.openRoom(ctx, players[0], minRoomSize, s.turnTimeout, BalanceNone, RoomVersus, ThemeClassic)
+	if err != nil {
+		return nil, err
+	}
+	if code == "" {
+		return nil, errNoRoomCode
+	}
+	if err := s.store.SetRoomTournament(ctx, code, tournament.ID); err != nil {
+		return nil, err
+	}
+	room, err := s.store.JoinRoom(ctx, code, players[1])
+	if err != nil {
+		return nil, err
+	}
+
+	// The room goes into the bracket first, so its game can't end before
+	// the bracket knows where it was played
+	startedAt := s.clock.Now().UTC()
+	tournament, err = s.store.UpdateTournament(ctx, tournament.ID, func(t *Tournament) error {
+		match := t.match(ref)
+		match.Room = code
+		match.StartedAt = &startedAt
+		match.Games++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.startRoomGame(ctx, room); err != nil {
+		return nil, err
+	}
+	s.startNoShowTimer(tournament.ID, code, startedAt)
+
+	log.Printf("Started match of users %s and %s of tournament %s in room %s", players[0], players[1], tournament.ID, code)
+	for _, username := range players {
+		s.hub.notifySpectators(username, SpectatorEvent{Type: "match_found", Username: username, Room: code})
+	}
+	return tournament, nil
+}
+
+// Move the room's tournament on now that its game is over: the winner goes
+// through, or with no winner the match is played again in a new room
+func (s *Server) tournamentGameOver(ctx context.Context, room *Room, winner string) {
+	if room.Tournament == "" {
+		return
+	}
+
+	var ready []matchRef
+	tournament, err := s.store.UpdateTournament(ctx, room.Tournament, func(t *Tournament) error {
+		ready = nil
+		ref, ok := t.matchIn(room.Code)
+		if !ok || t.match(ref).Status != BracketPlaying {
+			return errMatchDecided
+		}
+		if winner == "" {
+			t.match(ref).Status = BracketWaiting
+		} else {
+			t.match(ref).Status = BracketFinished
+			t.advance(ref, winner)
+		}
+		ready = t.claimReady()
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error recording the game of room %s in tournament %s: %v", room.Code, room.Tournament, err)
+		return
+	}
+
+	switch {
+	case winner == "":
+		log.Printf("The game of room %s ended without a winner; its match of tournament %s is played again", room.Code, tournament.ID)
+	case tournament.Status == TournamentFinished:
+		log.Printf("User %s won tournament %s", winner, tournament.ID)
+	default:
+		log.Printf("User %s goes through in tournament %s", winner, tournament.ID)
+	}
+	s.launchMatches(ctx, tournament, ready)
+}
+
+// Give a match's players until the no-show timeout to show up
+func (s *Server) startNoShowTimer(id, code string, startedAt time.Time) {
+	s.timerMutex.Lock()
+	defer s.timerMutex.Unlock()
+
+	if timer := s.noShowTimers[code]; timer != nil {
+		timer.Stop()
+	}
+	s.noShowTimers[code] = s.clock.AfterFunc(s.noShowTimeout, func() { s.checkShowedUp(id, code, startedAt) })
+}
+
+func (s *Server) stopNoShowTimer(code string) {
+	s.timerMutex.Lock()
+	defer s.timerMutex.Unlock()
+
+	if timer := s.noShowTimers[code]; timer != nil {
+		timer.Stop()
+		delete(s.noShowTimers, code)
+	}
+}
+
+// Forfeit the match for a player who hasn't been seen since it started: not
+// a move, a request or a leaderboard socket's pong. If neither player showed
+// up, the better seed goes through.
+func (s *Server) checkShowedUp(id, code string, since time.Time) {
+	s.timerMutex.Lock()
+	delete(s.noShowTimers, code)
+	s.timerMutex.Unlock()
+	ctx := context.Background()
+
+	room, err := s.store.GetRoom(ctx, code)
+	if err != nil || room == nil {
+		log.Printf("Error loading room %s for its no-show check: %v", code, err)
+		return
+	}
+	if room.Status != RoomActive {
+		return
+	}
+	online, err := s.store.OnlineUsers(ctx, since)
+	if err != nil {
+		log.Printf("Error listing online users for the no-show check of room %s: %v", code, err)
+		return
+	}
+	seen := make(map[string]bool, len(online))
+	for _, username := range online {
+		seen[username] = true
+	}
+	var absent []string
+	for _, username := range room.Players {
+		if !seen[username] {
+			absent = append(absent, username)
+		}
+	}
+	if len(absent) == 0 {
+		return
+	}
+
+	forfeiter := absent[0]
+	if len(absent) > 1 {
+		tournament, err := s.store.GetTournament(ctx, id)
+		if err != nil || tournament == nil {
+			log.Printf("Error loading tournament %s for the no-show check of room %s: %v", id, code, err)
+			return
+		}
+		for _, username := range absent[1:] {
+			if tournament.seed(username) > tournament.seed(forfeiter) {
+				forfeiter = username
+			}
+		}
+	}
+
+	log.Printf("User %s didn't show up for their match in room %s", forfeiter, code)
+	game := &GameSession{ID: room.gameID(), Username: forfeiter, Room: room}
+	if _, apiErr := s.forfeitRoom(ctx, game); apiErr != nil {
+		log.Printf("Error forfeiting user %s in room %s: %s", forfeiter, code, apiErr.Message)
+	}
+}
+
+// Serve a WebSocket connection following a tournament, starting with the
+// bracket as it is now
+func (s *Server) serveTournamentSocket(ctx context.Context, conn *websocket.Conn, id string) {
+	defer func() {
+		s.hub.unregisterTournament(id, conn)
+		s.hub.close(conn)
+	}()
+
+	// Registered first, so no update falls between the bracket and the
+	// events that follow it
+	s.hub.registerTournament(id, conn)
+	log.Printf("WebSocket connection established for tournament: %s", id)
+
+	tournament, err := s.store.GetTournament(ctx, id)
+	if err != nil {
+		log.Printf("Error retrieving tournament %s: %v", id, err)
+	} else if tournament != nil {
+		if err := s.hub.send(conn, TournamentEvent{Type: "bracket_updated", Tournament: tournament}); err != nil {
+			log.Println("Error sending bracket:", err)
+			return
+		}
+	}
+
+	go s.keepAlive(conn)
+
+	err = s.readCommands(ctx, conn, SubscribeRequest{})
+	log.Println("Tournament connection closed:", err)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+func (ts *testServer) tournament(id string) Tournament {
+	ts.t.Helper()
+	return decodeOK[Tournament](ts.t, ts.get("/tournaments/"+id))
+}
+
+// Have the loser of the match being played in the room forfeit it
+func (ts *testServer) loseMatch(code, loser string) {
+	ts.t.Helper()
+	decodeOK[ForfeitResponse](ts.t, ts.post("/forfeit", User{Username: loser, GameID: ts.room(code).gameID()}))
+}
+
+func TestFourPlayerBracketPlaysBothRounds(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		organizer := ts.guest()
+		assertError(t, ts.post("/tournaments", CreateTournamentRequest{Size: 4}), http.StatusUnauthorized, ErrCodeUnauthorized)
+		created := decodeOK[TournamentResponse](t, ts.post("/tournaments", CreateTournamentRequest{Size: 4}, bearer(organizer.Token)...)).Tournament
+		if created.Status != TournamentRegistering || created.Organizer != organizer.Username || len(created.Rounds) != 0 {
+			t.Fatalf("created = %+v", created)
+		}
+		socket := ts.dial("tournament=" + created.ID)
+		socket.next("bracket_updated")
+
+		// Seeded in the order they join
+		var seeds []string
+		for i := 0; i < 4; i++ {
+			player := ts.guest()
+			seeds = append(seeds, player.Username)
+			joined := decodeOK[TournamentResponse](t, ts.post("/tournaments/"+created.ID+"/join", nil, bearer(player.Token)...)).Tournament
+			if len(joined.Players) != i+1 {
+				t.Fatalf("players after %d joined = %v", i+1, joined.Players)
+			}
+		}
+		assertError(t, ts.post("/tournaments/"+created.ID+"/join", nil, bearer(ts.guest().Token)...), http.StatusConflict, ErrCodeTournamentClosed)
+
+		// Each player joining moves the bracket on. The best seed meets the
+		// worst, and the two in the middle meet.
+		var bracket *Tournament
+		for range seeds {
+			bracket = decodeMessage[TournamentEvent](t, socket.next("bracket_updated")).Tournament
+		}
+		if bracket.Status != TournamentRunning || len(bracket.Rounds) != 2 || len(bracket.Rounds[0]) != 2 || len(bracket.Rounds[1]) != 1 {
+			t.Fatalf("bracket = %+v", bracket)
+		}
+		first, second := bracket.Rounds[0][0], bracket.Rounds[0][1]
+		if !reflect.DeepEqual(first.Players, []string{seeds[0], seeds[3]}) || !reflect.DeepEqual(second.Players, []string{seeds[1], seeds[2]}) {
+			t.Fatalf("first round = %v, %v", first.Players, second.Players)
+		}
+		for _, match := range []BracketMatch{first, second} {
+			room := ts.room(match.Room)
+			if match.Status != BracketPlaying || match.Games != 1 || room.Status != RoomActive || room.Tournament != created.ID || !reflect.DeepEqual(room.Players, match.Players) {
+				t.Fatalf("match %+v is played in room %+v", match, room)
+			}
+		}
+		if final := bracket.Rounds[1][0]; final.Status != BracketWaiting || final.Room != "" {
+			t.Fatalf("final before the first round = %+v", final)
+		}
+
+		// The favourite goes through, then the third seed upsets the second
+		ts.loseMatch(first.Room, seeds[3])
+		bracket = decodeMessage[TournamentEvent](t, socket.next("bracket_updated")).Tournament
+		if match := bracket.Rounds[0][0]; match.Status != BracketFinished || match.Winner != seeds[0] {
+			t.Fatalf("first match = %+v", match)
+		}
+		if final := bracket.Rounds[1][0]; final.Status != BracketWaiting || !reflect.DeepEqual(final.Players, []string{seeds[0], ""}) {
+			t.Fatalf("final after one match = %+v", final)
+		}
+		ts.loseMatch(second.Room, seeds[1])
+		bracket = decodeMessage[TournamentEvent](t, socket.next("bracket_updated")).Tournament
+		final := bracket.Rounds[1][0]
+		if final.Status != BracketPlaying || !reflect.DeepEqual(final.Players, []string{seeds[0], seeds[2]}) || final.Room == "" || final.Room == first.Room || final.Room == second.Room {
+			t.Fatalf("final = %+v", final)
+		}
+		if room := ts.room(final.Room); room.Status != RoomActive || room.Tournament != created.ID {
+			t.Fatalf("final room = %+v", room)
+		}
+
+		ts.loseMatch(final.Room, seeds[0])
+		bracket = decodeMessage[TournamentEvent](t, socket.next("bracket_updated")).Tournament
+		if bracket.Status != TournamentFinished || bracket.Winner != seeds[2] || bracket.Rounds[1][0].Winner != seeds[2] {
+			t.Fatalf("finished bracket = %+v", bracket)
+		}
+		if got := ts.tournament(created.ID); !reflect.DeepEqual(got, *bracket) {
+			t.Fatalf("GET = %+v, pushed %+v", got, bracket)
+		}
+		assertError(t, ts.get("/tournaments/nope"), http.StatusNotFound, ErrCodeNoTournament)
+	})
+}
+
+func TestByeAndNoShowMoveTheBracketOn(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		// A no-show timeout that comes before a turn's
+		ts := newTestServerWith(t, store, testConfig(t, map[string]string{"TOURNAMENT_NO_SHOW_TIMEOUT": "20s"}))
+		organizer := ts.guest()
+		bracket := decodeOK[TournamentResponse](t, ts.post("/tournaments", CreateTournamentRequest{Players: []string{"alice", "bob", "carol"}}, bearer(organizer.Token)...)).Tournament
+
+		// Three players: the best seed has the bye
+		if bye := bracket.Rounds[0][0]; bye.Status != BracketBye || bye.Winner != "alice" || bye.Room != "" {
+			t.Fatalf("bye = %+v", bye)
+		}
+		match := bracket.Rounds[0][1]
+		if match.Status != BracketPlaying || !reflect.DeepEqual(match.Players, []string{"bob", "carol"}) {
+			t.Fatalf("match = %+v", match)
+		}
+		if final := bracket.Rounds[1][0]; !reflect.DeepEqual(final.Players, []string{"alice", ""}) {
+			t.Fatalf("final = %+v", final)
+		}
+
+		// Only the player on turn shows up
+		room := ts.room(match.Room)
+		ts.setDeck(room.gameID(), "Cat", "Cat", "Cat", engine.ExplodingKitten)
+		present, absent := room.Turn, room.nextAlive(room.Turn)
+		ts.clock.Advance(time.Second)
+		decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: present, GameID: room.gameID()}))
+
+		ts.clock.Advance(ts.noShowTimeout - time.Second - time.Millisecond)
+		if status := ts.room(match.Room).Status; status != RoomActive {
+			t.Fatalf("room is %s before the no-show timeout", status)
+		}
+		ts.clock.Advance(time.Millisecond)
+		got := ts.tournament(bracket.ID)
+		if played := got.Rounds[0][1]; played.Status != BracketFinished || played.Winner != present {
+			t.Fatalf("match after %s didn't show = %+v", absent, played)
+		}
+		if final := got.Rounds[1][0]; final.Status != BracketPlaying || !reflect.DeepEqual(final.Players, []string{"alice", present}) {
+			t.Fatalf("final = %+v", final)
+		}
+	})
+}
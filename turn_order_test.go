@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"exploding-kitten/engine"
+)
+
+// Who the room's seed draws to go first: the deck is shuffled from it
+// first, as startRoomGame does
+func seededFirstPlayer(seed [engine.SeedSize]byte, room *Room) string {
+	rng := engine.SeededRNG(seed)
+	buildDeck(roomDeckFor(len(room.Players), room.DisabledCards), rng)
+	return room.Players[rng.Intn(len(room.Players))]
+}
+
+func TestSeedsPickEitherPlayerFirst(t *testing.T) {
+	room := &Room{Players: []string{"alice", "bob"}}
+	firsts := map[string]int{}
+	for i := 0; i < 32; i++ {
+		var seed [engine.SeedSize]byte
+		seed[0] = byte(i)
+		first := seededFirstPlayer(seed, room)
+		if again := seededFirstPlayer(seed, room); again != first {
+			t.Fatalf("seed %d picked %s, then %s", i, first, again)
+		}
+		firsts[first]++
+	}
+	if firsts["alice"] == 0 || firsts["bob"] == 0 {
+		t.Fatalf("first players over 32 seeds = %v", firsts)
+	}
+}
+
+func TestRoomFirstPlayerComesFromSeed(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		firsts := map[string]int{}
+		for i := 0; i < 20; i++ {
+			creator, joiner := fmt.Sprintf("alice%d", i), fmt.Sprintf("bob%d", i)
+			room := ts.openRoom(creator, joiner)
+			seedHex, _, err := ts.store.GameSeed(ctx, room.gameID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var seed [engine.SeedSize]byte
+			hex.Decode(seed[:], []byte(seedHex))
+			if want := seededFirstPlayer(seed, room); room.Turn != want {
+				t.Fatalf("room %s started with %s, its seed draws %s", room.Code, room.Turn, want)
+			}
+			if room.Turn == creator {
+				firsts["creator"]++
+			} else {
+				firsts["joiner"]++
+			}
+		}
+		if firsts["creator"] == 0 || firsts["joiner"] == 0 {
+			t.Fatalf("first players over 20 rooms = %v", firsts)
+		}
+	})
+}
+
+func TestBalancedRoomGivesSecondPlayerDefuse(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		for _, balance := range []string{BalanceNone, BalanceBalanced} {
+			owner := "alice" + balance
+			created := decodeOK[RoomResponse](t, ts.post("/create-room", CreateRoomRequest{Username: owner, Size: 2, BalanceMode: balance}))
+			// The snapshot comes once the socket is following the room
+			watcher := ts.dial("room=" + created.Code)
+			watcher.next("snapshot")
+			joined := decodeOK[RoomResponse](t, ts.post("/join-room", RoomRequest{Username: "bob" + balance, Code: created.Code}))
+			room := joined.Room
+			order := room.turnOrder(room.Turn)
+
+			started := decodeMessage[RoomEvent](t, watcher.next("game_started"))
+			if !reflect.DeepEqual(started.Order, order) || started.BalanceMode != balance {
+				t.Fatalf("%s: game_started = %+v, want order %v", balance, started, order)
+			}
+			snapshot := decodeMessage[RoomSnapshot](t, ts.dial("room="+created.Code).next("snapshot"))
+			if !reflect.DeepEqual(snapshot.Order, order) || snapshot.BalanceMode != balance {
+				t.Fatalf("%s: snapshot order %v in %q mode, want %v", balance, snapshot.Order, snapshot.BalanceMode, order)
+			}
+
+			var second []string
+			if balance == BalanceBalanced {
+				second = []string{engine.Defuse}
+			}
+			if hand := ts.hand(order[0]); len(hand) != 0 {
+				t.Fatalf("%s: first player %s holds %v", balance, order[0], hand)
+			}
+			if hand := ts.hand(order[1]); !reflect.DeepEqual(hand, second) && len(hand)+len(second) > 0 {
+				t.Fatalf("%s: second player %s holds %v, want %v", balance, order[1], hand, second)
+			}
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Seconds a room player has to move unless the room or TURN_TIMEOUT says
+// otherwise, and the range either may set
+const (
+	defaultTurnTimeout = 30
+	minTurnTimeout     = 5
+	maxTurnTimeout     = 300
+)
+
+func (s *Server) roomTurnTimeout(room *Room) time.Duration {
+	if room.TurnTimeout <= 0 {
+		return time.Duration(s.turnTimeout) * time.Second
+	}
+	return time.Duration(room.TurnTimeout) * time.Second
+}
+
+// Claim the current turn for a move so the player's own move and a forced
+// draw on timeout can't both happen
+func (s *Server) claimTurn(ctx context.Context, room *Room) *APIError {
+	claimed, err := s.store.ClaimTurn(ctx, room.Code, room.TurnVersion)
+	if err != nil {
+		log.Printf("Error claiming turn in room %s: %v", room.Code, err)
+		return errStoreUnavailable("Error claiming turn")
+	}
+	if !claimed {
+		return errNotYourTurn()
+	}
+	room.TurnVersion++
+	return nil
+}
+
+// (Re)start the clock on the room's current turn. Only the timer for the
+// latest turn version can fire.
+func (s *Server) startTurnTimer(room *Room) {
+	s.resumeTurnTimer(room, s.clock.Now().Add(s.roomTurnTimeout(room)))
+}
+
+// Run the clock on the room's current turn until deadline, and save the
+// deadline so a restarted server can pick it up
+func (s *Server) resumeTurnTimer(room *Room, deadline time.Time) {
+	s.timerMutex.Lock()
+	defer s.timerMutex.Unlock()
+
+	if timer := s.turnTimers[room.Code]; timer != nil {
+		timer.Stop()
+		delete(s.turnTimers, room.Code)
+	}
+	// A paused game's clock starts again when it resumes
+	if room.Status != RoomActive || room.Pause != nil {
+		return
+	}
+
+	code, version := room.Code, room.TurnVersion
+	s.turnTimers[code] = s.clock.AfterFunc(deadline.Sub(s.clock.Now()), func() { s.turnTimedOut(code, version) })
+	if err := s.store.SetTurnDeadline(context.Background(), code, deadline); err != nil {
+		log.Printf("Error saving turn deadline of room %s: %v", code, err)
+	}
+}
+
+// Stop the room's turn clock, e.g. when the game ends
+func (s *Server) stopTurnTimer(code string) {
+	s.timerMutex.Lock()
+	defer s.timerMutex.Unlock()
+
+	if timer := s.turnTimers[code]; timer != nil {
+		timer.Stop()
+		delete(s.turnTimers, code)
+	}
+}
+
+// The player didn't move in time: draw for them through the normal draw logic
+func (s *Server) turnTimedOut(code string, version int64) {
+	ctx := context.Background()
+
+	room, err := s.store.GetRoom(ctx, code)
+	if err != nil || room == nil {
+		log.Printf("Error loading room %s for turn timeout: %v", code, err)
+		return
+	}
+	if room.Status != RoomActive || room.TurnVersion != version || room.Pause != nil {
+		// The player moved, the game ended or was paused, just as the timer
+		// fired
+		return
+	}
+
+	// Let a played card finish its Nope window first
+	if s.hasPendingAction(code) {
+		s.startTurnTimer(room)
+		return
+	}
+
+	// Nobody draws while a player over the hand limit still has to discard
+	game := &GameSession{ID: room.gameID(), Username: room.Turn, Room: room}
+	if s.checkNotBlocked(ctx, game) != nil {
+		s.startTurnTimer(room)
+		return
+	}
+
+	if apiErr := s.claimTurn(ctx, room); apiErr != nil {
+		return
+	}
+
+	log.Printf("Turn of user %s in room %s timed out", room.Turn, code)
+	s.hub.broadcastRoom(code, RoomEvent{Type: "turn_timeout", Username: room.Turn})
+
+	if _, apiErr := s.performDraw(ctx, game, false); apiErr != nil && apiErr.Code != ErrCodeDeckEmpty {
+		log.Printf("Error forcing a draw in room %s: %s", code, apiErr.Message)
+	}
+}
+
+// Mark the room's game as over and stop everything still running for it
+func (s *Server) finishRoom(ctx context.Context, room *Room) error {
+	room.Status = RoomFinished
+	if err := s.store.UpdateRoom(ctx, room); err != nil {
+		log.Printf("Error finishing room %s: %v", room.Code, err)
+		return err
+	}
+	s.releaseRoom(ctx, room.Code)
+	s.markFinished(ctx, room.gameID())
+	s.releaseActiveGame(ctx, room.gameID(), room.Players)
+	s.tournamentGameOver(ctx, room, "")
+	return nil
+}
+
+// Stop the clock of a finished room and drop its saved state
+func (s *Server) releaseRoom(ctx context.Context, code string) {
+	s.stopTurnTimer(code)
+	s.stopPauseTimer(code)
+	s.stopNoShowTimer(code)
+	s.dropPendingAction(code)
+	if err := s.store.DeleteRoomState(ctx, code); err != nil {
+		log.Printf("Error deleting state of room %s: %v", code, err)
+	}
+}
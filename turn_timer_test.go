@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+func TestTurnTimeoutForcesDraw(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		socket := ts.dial("room=" + room.Code)
+		ts.setDeck(room.gameID(), "Cat", "Skip", "Cat", engine.ExplodingKitten)
+		ts.deal("alice")
+		ts.deal("bob")
+		stalled, next := room.Turn, room.nextAlive(room.Turn)
+		timeout := ts.roomTurnTimeout(room)
+
+		ts.clock.Advance(timeout - time.Millisecond)
+		if turn := ts.room(room.Code).Turn; turn != stalled || len(ts.deck(room.gameID())) != 4 {
+			t.Fatalf("turn moved to %q before the timeout", turn)
+		}
+		ts.clock.Advance(time.Millisecond)
+
+		if event := decodeMessage[RoomEvent](t, socket.next("turn_timeout")); event.Username != stalled {
+			t.Fatalf("turn_timeout = %+v, want %s's", event, stalled)
+		}
+		if hand := ts.hand(stalled); len(hand) != 1 || hand[0] != "Cat" {
+			t.Fatalf("%s's hand = %v after the forced draw", stalled, hand)
+		}
+		if turn := ts.room(room.Code).Turn; turn != next {
+			t.Fatalf("turn = %q after the timeout, want %q", turn, next)
+		}
+
+		// The next player's clock started with their turn
+		ts.clock.Advance(timeout)
+		if hand := ts.hand(next); len(hand) != 1 || hand[0] != "Skip" {
+			t.Fatalf("%s's hand = %v after their timeout", next, hand)
+		}
+	})
+}
+
+func TestMoveRestartsTurnClock(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		ts.setDeck(room.gameID(), "Cat", "Skip", "Cat", engine.ExplodingKitten)
+		first, second := room.Turn, room.nextAlive(room.Turn)
+		timeout := ts.roomTurnTimeout(room)
+
+		ts.clock.Advance(timeout * 2 / 3)
+		decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: first, GameID: room.gameID()}))
+
+		// The first player's clock would have run out by now
+		ts.clock.Advance(timeout / 2)
+		if turn := ts.room(room.Code).Turn; turn != second || len(ts.deck(room.gameID())) != 3 {
+			t.Fatalf("turn = %q, deck %v: a stale clock fired", turn, ts.deck(room.gameID()))
+		}
+		ts.clock.Advance(timeout / 2)
+		if turn := ts.room(room.Code).Turn; turn != first {
+			t.Fatalf("turn = %q, want %q after the second player's clock ran out", turn, first)
+		}
+	})
+}
+
+func TestTurnTimeoutRacingMoveDrawsOnce(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		ts.setDeck(room.gameID(), "Cat", "Skip", "Cat", engine.ExplodingKitten)
+
+		// The player moves just as the timer for the turn fires
+		decodeOK[DrawCardResponse](t, ts.post("/draw-card", User{Username: room.Turn, GameID: room.gameID()}))
+		ts.turnTimedOut(room.Code, room.TurnVersion)
+
+		if deck := ts.deck(room.gameID()); len(deck) != 3 {
+			t.Fatalf("deck = %v, want one card drawn", deck)
+		}
+		if turn := ts.room(room.Code).Turn; turn != room.nextAlive(room.Turn) {
+			t.Fatalf("turn = %q", turn)
+		}
+	})
+}
+
+func TestGameOverStopsTurnClock(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		decodeOK[ForfeitResponse](t, ts.post("/forfeit", User{Username: "alice", GameID: room.gameID()}))
+
+		ts.timerMutex.Lock()
+		timer := ts.turnTimers[room.Code]
+		ts.timerMutex.Unlock()
+		if timer != nil {
+			t.Fatal("turn clock still running after the game ended")
+		}
+		ts.clock.Advance(ts.roomTurnTimeout(room))
+		if _, lose := ts.stats("bob"); lose != 0 {
+			t.Fatalf("bob lost %d games after alice forfeited", lose)
+		}
+	})
+}
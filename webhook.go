@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Events waiting for delivery before new ones are dropped
+const webhookQueueSize = 256
+
+// Retries after a failed first attempt before an event is dropped
+const webhookRetries = 5
+
+// Delay before the first retry; doubled for every retry after it
+const webhookBackoff = 500 * time.Millisecond
+
+// An event sent to EVENT_WEBHOOK_URL
+type WebhookEvent struct {
+	Event      string    `json:"event"`
+	Username   string    `json:"username"`
+	Result     string    `json:"result"`
+	DurationMs int64     `json:"durationMs"`
+	CardsDrawn int64     `json:"cardsDrawn"`
+	TS         time.Time `json:"ts"`
+}
+
+// webhookSender delivers events from a queue on its own goroutine, so
+// handlers only ever do a non-blocking send
+type webhookSender struct {
+	url     string
+	client  *http.Client
+	queue   chan WebhookEvent
+	backoff time.Duration
+}
+
+func newWebhookSender(url string) *webhookSender {
+	return &webhookSender{
+		url:     url,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		queue:   make(chan WebhookEvent, webhookQueueSize),
+		backoff: webhookBackoff,
+	}
+}
+
+// Queue an event without waiting. Drops it if the queue is full.
+func (w *webhookSender) enqueue(event WebhookEvent) {
+	select {
+	case w.queue <- event:
+	default:
+		log.Printf("Webhook queue is full, dropping %s event for user %s", event.Event, event.Username)
+		webhookDeliveriesTotal.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Deliver queued events until ctx is done
+func (w *webhookSender) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-w.queue:
+			w.deliver(ctx, event)
+		}
+	}
+}
+
+// POST the event, retrying with exponential backoff
+func (w *webhookSender) deliver(ctx context.Context, event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding webhook event: %v", err)
+		return
+	}
+
+	backoff := w.backoff
+	for attempt := 0; ; attempt++ {
+		err := w.post(ctx, body)
+		if err == nil {
+			webhookDeliveriesTotal.WithLabelValues("delivered").Inc()
+			return
+		}
+		if attempt == webhookRetries {
+			log.Printf("Warning: dropping %s event for user %s after %d attempts: %v", event.Event, event.Username, attempt+1, err)
+			webhookDeliveriesTotal.WithLabelValues("dropped").Inc()
+			return
+		}
+
+		log.Printf("Webhook delivery failed, retrying in %v: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (w *webhookSender) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Send a game_finished event for the player, if a webhook is configured.
+// Bots aren't reported.
+func (s *Server) reportGameFinished(ctx context.Context, game *GameSession, username, result string) {
+	if s.webhook == nil || isBot(username) {
+		return
+	}
+
+	now := s.clock.Now()
+	event := WebhookEvent{Event: "game_finished", Username: username, Result: result, TS: now.UTC()}
+	startedAt, cardsDrawn, err := s.store.GameProgress(ctx, game.ID)
+	if err != nil {
+		log.Printf("Error retrieving progress of game %s: %v", game.ID, err)
+	}
+	if !startedAt.IsZero() {
+		event.DurationMs = now.Sub(startedAt).Milliseconds()
+	}
+	event.CardsDrawn = cardsDrawn
+
+	s.webhook.enqueue(event)
+}
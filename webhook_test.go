@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"exploding-kitten/engine"
+)
+
+// A webhook endpoint failing its first failures requests with a 500 and
+// passing every event it accepts to received
+type fakeWebhook struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	failures int
+	attempts int
+	received chan WebhookEvent
+	// Held by a test to keep requests waiting
+	gate sync.RWMutex
+}
+
+func newFakeWebhook(t *testing.T, failures int) *fakeWebhook {
+	hook := &fakeWebhook{failures: failures, received: make(chan WebhookEvent, 16)}
+	hook.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hook.gate.RLock()
+		defer hook.gate.RUnlock()
+
+		hook.mutex.Lock()
+		hook.attempts++
+		fail := hook.attempts <= hook.failures
+		hook.mutex.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var event WebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decoding webhook event: %v", err)
+		}
+		hook.received <- event
+	}))
+	t.Cleanup(hook.Close)
+	return hook
+}
+
+func (h *fakeWebhook) attemptCount() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.attempts
+}
+
+// A sender to the hook with a negligible backoff, delivering until the test
+// ends
+func startWebhookSender(t *testing.T, hook *fakeWebhook) *webhookSender {
+	sender := newWebhookSender(hook.URL)
+	sender.backoff = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sender.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return sender
+}
+
+func TestWebhookRetriesUntilDelivered(t *testing.T) {
+	hook := newFakeWebhook(t, 2)
+	sender := startWebhookSender(t, hook)
+	delivered := testutil.ToFloat64(webhookDeliveriesTotal.WithLabelValues("delivered"))
+
+	sent := WebhookEvent{Event: "game_finished", Username: "alice", Result: "win", CardsDrawn: 3, TS: testEpoch}
+	sender.enqueue(sent)
+	select {
+	case got := <-hook.received:
+		if got != sent {
+			t.Fatalf("received %+v, want %+v", got, sent)
+		}
+	case <-time.After(socketTimeout):
+		t.Fatal("event never delivered")
+	}
+	if attempts := hook.attemptCount(); attempts != 3 {
+		t.Fatalf("%d attempts, want 2 failures and a delivery", attempts)
+	}
+	// The sender counts the delivery once the response is in
+	for deadline := time.Now().Add(socketTimeout); testutil.ToFloat64(webhookDeliveriesTotal.WithLabelValues("delivered"))-delivered != 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("delivery never counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWebhookDropsAfterRetries(t *testing.T) {
+	hook := newFakeWebhook(t, 1000)
+	sender := newWebhookSender(hook.URL)
+	sender.backoff = time.Millisecond
+	dropped := testutil.ToFloat64(webhookDeliveriesTotal.WithLabelValues("dropped"))
+
+	sender.deliver(context.Background(), WebhookEvent{Event: "game_finished", Username: "alice"})
+	if attempts := hook.attemptCount(); attempts != webhookRetries+1 {
+		t.Fatalf("%d attempts, want %d", attempts, webhookRetries+1)
+	}
+	if got := testutil.ToFloat64(webhookDeliveriesTotal.WithLabelValues("dropped")) - dropped; got != 1 {
+		t.Fatalf("dropped counter moved by %v", got)
+	}
+}
+
+func TestDrawDoesNotWaitForWebhook(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		hook := newFakeWebhook(t, 0)
+		ts.webhook = startWebhookSender(t, hook)
+		ts.startGame("alice", "Cat", engine.ExplodingKitten)
+
+		// The endpoint hangs until the draw has returned
+		hook.gate.Lock()
+		drawn := decodeOK[DrawCardResponse](t, ts.draw("alice"))
+		hook.gate.Unlock()
+		if drawn.GameStatus != GameStatusWon {
+			t.Fatalf("draw = %+v", drawn)
+		}
+
+		select {
+		case event := <-hook.received:
+			if event.Event != "game_finished" || event.Username != "alice" || event.Result != "win" || event.CardsDrawn != 1 {
+				t.Fatalf("event = %+v", event)
+			}
+		case <-time.After(socketTimeout):
+			t.Fatal("event never delivered")
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingStore is a GameStore whose leaderboard reads hang until their
+// context is done, counting how each one ended
+type blockingStore struct {
+	GameStore
+	started   chan struct{}
+	completed atomic.Int64
+	canceled  atomic.Int64
+}
+
+type blockingStatsIterator struct {
+	StatsIterator
+	store *blockingStore
+}
+
+func (s *blockingStore) LeaderboardStats(ctx context.Context, pageSize int) StatsIterator {
+	return &blockingStatsIterator{StatsIterator: s.GameStore.LeaderboardStats(ctx, pageSize), store: s}
+}
+
+func (it *blockingStatsIterator) Next(ctx context.Context) ([]UserStats, bool) {
+	select {
+	case it.store.started <- struct{}{}:
+	default:
+	}
+	select {
+	case <-ctx.Done():
+		it.store.canceled.Add(1)
+		return nil, false
+	case <-time.After(5 * time.Second):
+		it.store.completed.Add(1)
+		return it.StatsIterator.Next(ctx)
+	}
+}
+
+func (h *Hub) clientCount() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.clients)
+}
+
+// Wait up to a second for cond to hold
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestDisconnectCancelsInitialLeaderboard(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		blocking := &blockingStore{GameStore: store, started: make(chan struct{}, 1)}
+		ts := newTestServer(t, blocking)
+		socket := ts.dial("")
+		select {
+		case <-blocking.started:
+		case <-time.After(time.Second):
+			t.Fatal("the initial leaderboard was never read")
+		}
+		// The connection is registered while its snapshot is still being read
+		if n := ts.hub.clientCount(); n != 1 {
+			t.Fatalf("%d clients registered during the snapshot read", n)
+		}
+
+		socket.conn.Close()
+		eventually(t, "the snapshot read to be canceled", func() bool { return blocking.canceled.Load() == 1 })
+		eventually(t, "the connection to be unregistered", func() bool { return ts.hub.clientCount() == 0 })
+		if n := blocking.completed.Load(); n != 0 {
+			t.Fatalf("%d snapshot reads completed for a client that left", n)
+		}
+	})
+}
+
+func TestImmediateDisconnectSkipsOrCancelsSnapshot(t *testing.T) {
+	eachGameStore(t, func(t *testing.T, store GameStore) {
+		blocking := &blockingStore{GameStore: store, started: make(chan struct{}, 1)}
+		ts := newTestServer(t, blocking)
+		for i := 0; i < 10; i++ {
+			ts.dial("").conn.Close()
+		}
+		eventually(t, "the connections to be unregistered", func() bool { return ts.hub.clientCount() == 0 })
+		// Anything read for them was let go rather than finished
+		time.Sleep(10 * time.Millisecond)
+		if n := blocking.completed.Load(); n != 0 {
+			t.Fatalf("%d snapshot reads completed for clients that left", n)
+		}
+	})
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Commands a socket may send, handled by the same game functions as the
+// REST routes
+const (
+	CommandDraw        = "draw"
+	CommandPlayCard    = "play_card"
+	CommandPlayPair    = "play_pair"
+	CommandDiscard     = "discard"
+	CommandResolveBomb = "resolve_bomb"
+	CommandSubscribe   = "subscribe"
+	// A leaderboard socket reporting the standings version it holds
+	CommandLeaderboard = "leaderboard"
+	CommandChat        = "chat"
+	// A socket's first message, agreeing on what it understands; see
+	// HelloRequest
+	CommandHello = "hello"
+)
+
+// Per-connection command rate: a burst of wsCommandBurst, refilled at
+// wsCommandRate per second
+const (
+	wsCommandRate  = 5
+	wsCommandBurst = 10
+)
+
+// A command sent by the client. ID is echoed back on the reply.
+type WSCommand struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// The reply to a command: type "result" with the same body the REST route
+// returns, or type "error"
+type WSReply struct {
+	ID     string      `json:"id,omitempty"`
+	Type   string      `json:"type"`
+	Status int         `json:"status"`
+	Result interface{} `json:"result,omitempty"`
+	Error  *APIError   `json:"error,omitempty"`
+}
+
+// Payload of a subscribe command: a room to follow or a player to spectate.
+// On a leaderboard socket, username instead names the player whose own row
+// it gets after the top rows.
+type SubscribeRequest struct {
+	Room     string `json:"room"`
+	Spectate string `json:"spectate"`
+	Username string `json:"username,omitempty"`
+}
+
+// Payload of a leaderboard command, and its reply: the version of the
+// standings the socket holds, then the current one
+type LeaderboardSyncRequest struct {
+	Version uint64 `json:"version"`
+}
+
+// Token bucket limiting how fast one connection may send commands
+type commandLimiter struct {
+	tokens float64
+	last   time.Time
+}
+
+func (l *commandLimiter) allow(now time.Time) bool {
+	if l.last.IsZero() {
+		l.tokens = wsCommandBurst
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * wsCommandRate
+		if l.tokens > wsCommandBurst {
+			l.tokens = wsCommandBurst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// What one socket has subscribed to through commands, so it can be
+// unregistered when the socket closes
+type commandSession struct {
+	conn       *websocket.Conn
+	limiter    commandLimiter
+	rooms      map[string]bool
+	spectating map[string]bool
+	// When the socket connected and how many messages it has sent, which
+	// only a hello may be the first of
+	opened   time.Time
+	commands int
+}
+
+// Read commands from the socket until it closes, replying to each one and
+// running them under ctx. following is what the socket was opened for, so
+// subscribing to it again is a no-op. Returns the error that ended the
+// connection.
+func (s *Server) readCommands(ctx context.Context, conn *websocket.Conn, following SubscribeRequest) error {
+	session := &commandSession{
+		conn:       conn,
+		rooms:      make(map[string]bool),
+		spectating: make(map[string]bool),
+		opened:     s.clock.Now(),
+	}
+	if following.Room != "" {
+		session.rooms[following.Room] = true
+	}
+	if following.Spectate != "" {
+		session.spectating[following.Spectate] = true
+	}
+	defer func() {
+		for code := range session.rooms {
+			s.hub.unregisterRoom(code, conn)
+		}
+		for username := range session.spectating {
+			s.hub.unregisterSpectator(username, conn)
+		}
+	}()
+
+	for {
+		message, err := readMessage(conn)
+		if err != nil {
+			return err
+		}
+
+		session.commands++
+		var command WSCommand
+		if err := json.Unmarshal(message, &command); err != nil {
+			s.reply(conn, WSReply{}, errInvalidRequest("Commands must be JSON objects"))
+			continue
+		}
+		reply := WSReply{ID: command.ID}
+		if !session.limiter.allow(s.clock.Now()) {
+			s.reply(conn, reply, errRateLimited())
+			continue
+		}
+
+		var apiErr *APIError
+		if command.Type == CommandHello {
+			// A hello carries its fields at the top level, not in a payload
+			reply.Status = http.StatusOK
+			reply.Result, apiErr = s.hello(session, message)
+		} else {
+			reply.Status, reply.Result, apiErr = s.runCommand(ctx, session, command)
+		}
+		s.reply(conn, reply, apiErr)
+	}
+}
+
+// Dispatch a command to the game function behind the matching REST route
+func (s *Server) runCommand(ctx context.Context, session *commandSession, command WSCommand) (int, interface{}, *APIError) {
+	log.Printf("WebSocket command %s (id %q)", command.Type, command.ID)
+
+	switch command.Type {
+	case CommandDraw:
+		var user User
+		if apiErr := decodePayload(command.Payload, &user); apiErr != nil {
+			return 0, nil, apiErr
+		}
+		if !usernamePattern.MatchString(user.Username) {
+			return 0, nil, errInvalidUsername()
+		}
+		game, apiErr := s.resolveMove(ctx, user)
+		if apiErr != nil {
+			return 0, nil, apiErr
+		}
+		response, apiErr := s.drawTurn(ctx, game)
+		if apiErr != nil {
+			return 0, nil, apiErr
+		}
+		return http.StatusOK, response, nil
+
+	case CommandPlayCard:
+		var req PlayCardRequest
+		if apiErr := decodePayload(command.Payload, &req); apiErr != nil {
+			return 0, nil, apiErr
+		}
+		if !usernamePattern.MatchString(req.Username) {
+			return 0, nil, errInvalidUsername()
+		}
+		return s.play(ctx, req)
+
+	case CommandPlayPair:
+		var req PlayPairRequest
+		if apiErr := decodePayload(command.Payload, &req); apiErr != nil {
+			return 0, nil, apiErr
+		}
+		if !usernamePattern.MatchString(req.Username) {
+			return 0, nil, errInvalidUsername()
+		}
+		response, apiErr := s.stealWithPair(ctx, req)
+		if apiErr != nil {
+			return 0, nil, apiErr
+		}
+		return http.StatusOK, response, nil
+
+	case CommandDiscard:
+		var req DiscardRequest
+		if apiErr := decodePayload(command.Payload, &req); apiErr != nil {
+			return 0, nil, apiErr
+		}
+		if !usernamePattern.MatchString(req.Username) {
+			return 0, nil, errInvalidUsername()
+		}
+		response, apiErr := s.discard(ctx, req)
+		if apiErr != nil {
+			return 0, nil, apiErr
+		}
+		return http.StatusOK, response, nil
+
+	case CommandResolveBomb:
+		var req ResolveBombRequest
+		if apiErr := decodePayload(command.Payload, &req); apiErr != nil {
+			return 0, nil, apiErr
+		}
+		if !usernamePattern.MatchString(req.Username) {
+			return 0, nil, errInvalidUsername()
+		}
+		response, apiErr := s.resolveBomb(ctx, req)
+		if apiErr != nil {
+			return 0, nil, apiErr
+		}
+		return http.StatusOK, response, nil
+
+	case CommandSubscribe:
+		var req SubscribeRequest
+		if apiErr := decodePayload(command.Payload, &req); apiErr != nil {
+			return 0, nil, apiErr
+		}
+		if apiErr := s.subscribe(ctx, session, req); apiErr != nil {
+			return 0, nil, apiErr
+		}
+		return http.StatusOK, req, nil
+
+	case CommandChat:
+		var req ChatRequest
+		if apiErr := decodePayload(command.Payload, &req); apiErr != nil {
+			return 0, nil, apiErr
+		}
+		if !usernamePattern.MatchString(req.Username) {
+			return 0, nil, errInvalidUsername()
+		}
+		message, apiErr := s.chat(ctx, req)
+		if apiErr != nil {
+			return 0, nil, apiErr
+		}
+		return http.StatusOK, message, nil
+
+	case CommandLeaderboard:
+		var req LeaderboardSyncRequest
+		if apiErr := decodePayload(command.Payload, &req); apiErr != nil {
+			return 0, nil, apiErr
+		}
+		version, apiErr := s.syncLeaderboard(ctx, session.conn, req.Version)
+		if apiErr != nil {
+			return 0, nil, apiErr
+		}
+		return http.StatusOK, LeaderboardSyncRequest{Version: version}, nil
+	}
+
+	return 0, nil, errUnknownCommand(command.Type)
+}
+
+func decodePayload(payload json.RawMessage, v interface{}) *APIError {
+	if len(payload) == 0 {
+		return errInvalidRequest("Missing payload")
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return errInvalidRequest("Invalid payload")
+	}
+	return nil
+}
+
+// Start delivering a room's or a player's events to the socket
+func (s *Server) subscribe(ctx context.Context, session *commandSession, req SubscribeRequest) *APIError {
+	switch {
+	case req.Room != "":
+		code := strings.ToUpper(req.Room)
+		room, err := s.store.GetRoom(ctx, code)
+		if err != nil {
+			log.Printf("Error retrieving room %s: %v", code, err)
+			return errStoreUnavailable("Error retrieving room")
+		}
+		if room == nil {
+			return errRoomNotFound()
+		}
+		if !session.rooms[code] {
+			session.rooms[code] = true
+			s.hub.registerRoom(code, session.conn)
+		}
+
+	case req.Spectate != "":
+		if !usernamePattern.MatchString(req.Spectate) {
+			return errInvalidUsername()
+		}
+		if !session.spectating[req.Spectate] {
+			session.spectating[req.Spectate] = true
+			s.hub.registerSpectator(req.Spectate, session.conn)
+		}
+
+	case req.Username != "":
+		if !usernamePattern.MatchString(req.Username) {
+			return errInvalidUsername()
+		}
+		if !s.hub.identifyClient(session.conn, req.Username) {
+			return errInvalidRequest("Only leaderboard sockets subscribe as a player")
+		}
+		// Its next frame is the first to carry the player's row, so send one now
+		if err := s.sendLeaderboard(ctx, session.conn); err != nil {
+			log.Println("Error sending leaderboard data:", err)
+		}
+
+	default:
+		return errInvalidRequest("subscribe needs a room, a player to spectate, or a username")
+	}
+	return nil
+}
+
+// Answer a leaderboard socket reporting the standings version it holds: a
+// socket that missed a delta, or applied one to the wrong standings, gets a
+// full snapshot. Returns the current version.
+func (s *Server) syncLeaderboard(ctx context.Context, conn *websocket.Conn, version uint64) (uint64, *APIError) {
+	if !s.hub.isClient(conn) {
+		return 0, errInvalidRequest("Only leaderboard sockets sync the leaderboard")
+	}
+	if current := s.hub.leaderboardVersion(); version != 0 && version == current {
+		return current, nil
+	}
+	if err := s.sendLeaderboard(ctx, conn); err != nil {
+		log.Println("Error sending leaderboard data:", err)
+		return 0, errStoreUnavailable("Error fetching leaderboard")
+	}
+	return s.hub.leaderboardVersion(), nil
+}
+
+// Send a command's reply, or its error
+func (s *Server) reply(conn *websocket.Conn, reply WSReply, apiErr *APIError) {
+	if apiErr != nil {
+		reply.Type = "error"
+		reply.Status = apiErr.HTTPStatus
+		reply.Result = nil
+		reply.Error = apiErr
+	} else {
+		reply.Type = "result"
+	}
+	if err := s.hub.send(conn, reply); err != nil {
+		log.Println("Error sending command reply:", err)
+	}
+}
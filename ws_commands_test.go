@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"exploding-kitten/engine"
+)
+
+// Send a command and wait for the reply carrying its id
+func (s *testSocket) command(id, commandType string, payload interface{}) WSReply {
+	s.t.Helper()
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		s.t.Fatalf("encoding payload: %v", err)
+	}
+	s.send(WSCommand{ID: id, Type: commandType, Payload: encoded})
+	for {
+		message := s.any()
+		if message["id"] == id {
+			return decodeMessage[WSReply](s.t, message)
+		}
+	}
+}
+
+// Decode a reply's result into a T, failing unless the command succeeded
+func replyResult[T any](t *testing.T, reply WSReply) T {
+	t.Helper()
+	if reply.Type != "result" || reply.Status != http.StatusOK {
+		t.Fatalf("reply = %+v", reply)
+	}
+	return decodeMessage[T](t, reply.Result.(map[string]interface{}))
+}
+
+func TestSoloGameOverSocket(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ts.startGame("alice", "Cat", "Skip", engine.ExplodingKitten)
+		socket := ts.dial("username=alice")
+		socket.hello()
+
+		first := replyResult[DrawCardResponse](t, socket.command("1", CommandDraw, User{Username: "alice"}))
+		if first.Card.Type != "Cat" || first.GameStatus != GameStatusActive {
+			t.Fatalf("first draw = %+v", first)
+		}
+		last := replyResult[DrawCardResponse](t, socket.command("2", CommandDraw, User{Username: "alice"}))
+		if last.Card.Type != "Skip" || last.GameStatus != GameStatusWon {
+			t.Fatalf("last draw = %+v", last)
+		}
+		if win, _ := ts.stats("alice"); win != 1 {
+			t.Fatalf("wins = %d, want 1", win)
+		}
+
+		// Moves on the finished game fail as they do over REST
+		over := socket.command("3", CommandDraw, User{Username: "alice"})
+		if over.Type != "error" || over.Status != http.StatusConflict || over.Error.Code != ErrCodeGameFinished {
+			t.Fatalf("draw after the game = %+v", over)
+		}
+	})
+}
+
+func TestRoomGameOverSockets(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		room := ts.openRoom("alice", "bob")
+		first, second := room.Turn, room.nextAlive(room.Turn)
+		ts.setDeck(room.gameID(), "Cat", engine.ExplodingKitten, "Cat")
+		ts.deal(first, "Skip")
+		ts.deal(second)
+
+		sockets := make(map[string]*testSocket)
+		for _, player := range []string{first, second} {
+			sockets[player] = ts.dial(fmt.Sprintf("room=%s&username=%s", room.Code, player))
+			sockets[player].hello()
+		}
+
+		// The Skip waits out its Nope window, as it does over REST
+		played := sockets[first].command("skip", CommandPlayCard, PlayCardRequest{Username: first, GameID: room.gameID(), Card: "Skip"})
+		if played.Type != "result" || played.Status != http.StatusAccepted {
+			t.Fatalf("play = %+v", played)
+		}
+		ts.clock.Advance(ts.nopeWindow)
+		if turn := ts.room(room.Code).Turn; turn != second {
+			t.Fatalf("turn = %q after the Skip, want %q", turn, second)
+		}
+		drawn := replyResult[DrawCardResponse](t, sockets[second].command("draw", CommandDraw, User{Username: second, GameID: room.gameID()}))
+		if drawn.Card.Type != "Cat" {
+			t.Fatalf("%s drew %+v", second, drawn)
+		}
+		exploded := replyResult[DrawCardResponse](t, sockets[first].command("draw", CommandDraw, User{Username: first, GameID: room.gameID()}))
+		if exploded.Disposition != DispositionExploded || exploded.GameStatus != GameStatusLost {
+			t.Fatalf("%s drew %+v", first, exploded)
+		}
+		ts.clock.Advance(ts.revealDelay)
+
+		for player, socket := range sockets {
+			if over := decodeMessage[RoomEvent](t, socket.next("game_over")); over.Winner != second {
+				t.Fatalf("%s got game_over %+v, want %s to win", player, over, second)
+			}
+		}
+		if win, _ := ts.stats(second); win != 1 {
+			t.Fatalf("%s has %d wins", second, win)
+		}
+	})
+}
+
+func TestSocketRejectsUnknownCommands(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		socket := ts.dial("username=alice")
+		socket.hello()
+
+		reply := socket.command("1", "teleport", nil)
+		if reply.Type != "error" || reply.Error.Code != ErrCodeUnknownCommand {
+			t.Fatalf("reply = %+v", reply)
+		}
+	})
+}
+
+func TestSocketRateLimitsCommands(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		socket := ts.dial("username=alice")
+		socket.hello()
+
+		// The hello took a token from the burst
+		for i := 1; i < wsCommandBurst; i++ {
+			if reply := socket.command(fmt.Sprint(i), "teleport", nil); reply.Error.Code != ErrCodeUnknownCommand {
+				t.Fatalf("command %d = %+v", i, reply)
+			}
+		}
+		if reply := socket.command("over", "teleport", nil); reply.Error == nil || reply.Error.Code != ErrCodeRateLimited {
+			t.Fatalf("command over the burst = %+v", reply)
+		}
+
+		// The fake clock refills the bucket
+		ts.clock.Advance(time.Second)
+		if reply := socket.command("later", "teleport", nil); reply.Error.Code != ErrCodeUnknownCommand {
+			t.Fatalf("command after a second = %+v", reply)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"compress/flate"
+	"io"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Largest message a socket may send; commands are small JSON objects. A
+// bigger frame closes the socket with 1009 (message too big).
+const wsReadLimit = 4096
+
+// Sockets open at once, unless WS_MAX_CONNECTIONS and
+// WS_MAX_CONNECTIONS_PER_USER say otherwise. Past the global cap upgrades are
+// refused with a 503; past a player's, their oldest socket is closed.
+const (
+	defaultMaxConns     = 5000
+	defaultMaxUserConns = 3
+)
+
+// Close code and reason of a socket closed for a newer one of its player's
+const (
+	closeSuperseded       = 4001
+	closeSupersededReason = "superseded"
+)
+
+// Rows of the leaderboard sent over a socket: the top wsLeaderboardTop, plus
+// the socket's own player's row if they rank below
+const wsLeaderboardTop = 50
+
+// Compress what the server writes to a socket, when the client negotiated
+// permessage-deflate, and limit what it reads
+func configureConn(conn *websocket.Conn) {
+	conn.EnableWriteCompression(true)
+	if err := conn.SetCompressionLevel(flate.BestSpeed); err != nil {
+		log.Println("Error setting WebSocket compression level:", err)
+	}
+	conn.SetReadLimit(wsReadLimit)
+}
+
+// Read the next message from a socket, at most wsReadLimit bytes once
+// decompressed. The read limit only counts the bytes on the wire, so a
+// compressed message that inflates past it is refused here, with the same
+// 1009 close.
+func readMessage(conn *websocket.Conn) ([]byte, error) {
+	_, r, err := conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	message, err := io.ReadAll(io.LimitReader(r, wsReadLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(message) > wsReadLimit {
+		closeMessage := websocket.FormatCloseMessage(websocket.CloseMessageTooBig, "")
+		conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+		return nil, websocket.ErrReadLimit
+	}
+	return message, nil
+}
+
+// The rows of entries a socket gets: the top wsLeaderboardTop, and
+// username's own row after them if it ranks below. Returns whether the own
+// row was added.
+func topLeaderboard(entries []LeaderboardEntry, username string) ([]LeaderboardEntry, bool) {
+	if len(entries) <= wsLeaderboardTop {
+		return entries, false
+	}
+	top := entries[:wsLeaderboardTop:wsLeaderboardTop]
+	if username == "" {
+		return top, false
+	}
+	for _, entry := range entries[wsLeaderboardTop:] {
+		if entry.Username == username {
+			return append(top, entry), true
+		}
+	}
+	return top, false
+}
+
+// Take one of the hub's connection slots for a socket about to be upgraded,
+// false if all maxConns are taken. Each slot taken is given back with
+// releaseConn once its socket closes, or if the upgrade fails.
+func (h *Hub) reserveConn() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.conns >= h.maxConns {
+		return false
+	}
+	h.conns++
+	return true
+}
+
+// Give back a slot taken by reserveConn
+func (h *Hub) releaseConn() {
+	h.mutex.Lock()
+	h.conns--
+	h.mutex.Unlock()
+}
+
+// Count conn among the player's sockets, closing their oldest ones with 4001
+// (superseded) if that takes them past maxUserConns. The closed sockets'
+// handlers see their reads fail and clean up as for any other drop.
+func (h *Hub) admitUserConn(username string, conn *websocket.Conn) {
+	h.mutex.Lock()
+	conns := append(h.userConns[username], conn)
+	var superseded []*websocket.Conn
+	if over := len(conns) - h.maxUserConns; over > 0 {
+		superseded = append(superseded, conns[:over]...)
+		conns = append([]*websocket.Conn(nil), conns[over:]...)
+	}
+	h.userConns[username] = conns
+	h.mutex.Unlock()
+
+	for _, old := range superseded {
+		log.Printf("Closing a WebSocket of user %s for a newer one", username)
+		closeMessage := websocket.FormatCloseMessage(closeSuperseded, closeSupersededReason)
+		old.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+		old.Close()
+	}
+}
+
+// Stop counting conn among the player's sockets. It may already have been
+// dropped by admitUserConn.
+func (h *Hub) removeUserConn(username string, conn *websocket.Conn) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	conns := h.userConns[username]
+	for i, c := range conns {
+		if c == conn {
+			conns = append(conns[:i:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) == 0 {
+		delete(h.userConns, username)
+	} else {
+		h.userConns[username] = conns
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Read until the socket closes, returning the close error
+func (s *testSocket) closed() error {
+	s.t.Helper()
+	s.conn.SetReadDeadline(time.Now().Add(socketTimeout))
+	for {
+		if _, _, err := s.conn.ReadMessage(); err != nil {
+			return err
+		}
+	}
+}
+
+func TestOversizedMessagesCloseWith1009(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	ts.dial("")
+	url := "ws" + strings.TrimPrefix(ts.listener.URL, "http") + "/ws"
+
+	for name, compress := range map[string]bool{"plain": false, "inflating past the limit": true} {
+		t.Run(name, func(t *testing.T) {
+			dialer := websocket.Dialer{EnableCompression: compress, HandshakeTimeout: socketTimeout}
+			conn, _, err := dialer.Dial(url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			socket := &testSocket{t: t, conn: conn}
+			socket.next("leaderboard")
+
+			// A message at the limit is read, and refused as any bad command
+			conn.WriteMessage(websocket.TextMessage, bytes.Repeat([]byte(" "), wsReadLimit))
+			socket.next("error")
+
+			// Spaces compress to a few bytes on the wire
+			size := wsReadLimit + 1
+			if compress {
+				size = 64 * wsReadLimit
+			}
+			conn.WriteMessage(websocket.TextMessage, bytes.Repeat([]byte(" "), size))
+			if err := socket.closed(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+				t.Fatalf("socket closed with %v, want 1009", err)
+			}
+		})
+	}
+}
+
+func TestLeaderboardFrameIsTopAndOwnRow(t *testing.T) {
+	eachStore(t, func(t *testing.T, ts *testServer) {
+		ctx := context.Background()
+		const players = 1000
+		for i := 0; i < players; i++ {
+			ts.store.SetStats(ctx, fmt.Sprintf("user%04d", i), int64(players-i), 0, AuditEntry{})
+		}
+		rows := func(query string) []LeaderboardEntry {
+			t.Helper()
+			return decodeMessage[struct{ Leaderboard []LeaderboardEntry }](t, ts.dial(query).next("leaderboard")).Leaderboard
+		}
+
+		last := rows("username=user0999")
+		if len(last) != wsLeaderboardTop+1 || last[0].Username != "user0000" {
+			t.Fatalf("frame for the last player has %d rows, want %d", len(last), wsLeaderboardTop+1)
+		}
+		if own := last[wsLeaderboardTop]; own.Username != "user0999" || own.Rank != players {
+			t.Fatalf("own row = %+v", own)
+		}
+		// A player in the top, or a socket of nobody's, gets the top alone
+		for _, query := range []string{"username=user0003", ""} {
+			if top := rows(query); len(top) != wsLeaderboardTop || top[wsLeaderboardTop-1].Username != "user0049" {
+				t.Fatalf("frame for %q has %d rows", query, len(top))
+			}
+		}
+	})
+}
+
+func (h *Hub) connCounts() (conns int, users map[string]int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	users = make(map[string]int)
+	for username, sockets := range h.userConns {
+		users[username] = len(sockets)
+	}
+	return h.conns, users
+}
+
+func TestFourthSocketOfAPlayerClosesTheFirst(t *testing.T) {
+	ts := newTestServer(t, newMemoryStore())
+	bob := ts.dial("username=bob")
+	bob.next("leaderboard")
+	var sockets []*testSocket
+	for i := 0; i < defaultMaxUserConns+1; i++ {
+		socket := ts.dial("username=alice")
+		socket.next("leaderboard")
+		sockets = append(sockets, socket)
+	}
+
+	err := sockets[0].closed()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != closeSuperseded || closeErr.Text != closeSupersededReason {
+		t.Fatalf("first socket closed with %v, want 4001 superseded", err)
+	}
+	eventually(t, "the first socket to be let go", func() bool {
+		conns, users := ts.hub.connCounts()
+		return conns == defaultMaxUserConns+1 && users["alice"] == defaultMaxUserConns && users["bob"] == 1
+	})
+
+	// The newer ones, and other players', still get broadcasts
+	ts.store.SetStats(context.Background(), "carol", 1, 0, AuditEntry{})
+	ts.leaderboard.invalidate()
+	ts.broadcastLeaderboard()
+	for _, socket := range append(sockets[1:], bob) {
+		socket.next("leaderboard")
+	}
+}
+
+func TestGlobalConnectionCapRefusesUpgrade(t *testing.T) {
+	ts := newTestServerWith(t, newMemoryStore(), testConfig(t, map[string]string{"WS_MAX_CONNECTIONS": "2"}))
+	first := ts.dial("")
+	first.next("leaderboard")
+	ts.dial("username=alice").next("leaderboard")
+
+	if _, status, err := ts.tryDial(""); err == nil || status != http.StatusServiceUnavailable {
+		t.Fatalf("third socket = %d, %v, want a 503 before the upgrade", status, err)
+	}
+	assertError(t, ts.get("/ws"), http.StatusServiceUnavailable, ErrCodeServerBusy)
+
+	// A closed socket gives its slot back
+	first.conn.Close()
+	eventually(t, "the slot to be given back", func() bool {
+		conns, _ := ts.hub.connCounts()
+		return conns == 1
+	})
+	ts.dial("").next("leaderboard")
+}
+
+func TestConnectionCountsSurviveConcurrentChurn(t *testing.T) {
+	ts := newTestServerWith(t, newMemoryStore(), testConfig(t, map[string]string{"WS_MAX_CONNECTIONS_PER_USER": "2"}))
+	ts.dial("").conn.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			socket, _, err := ts.tryDial(fmt.Sprintf("username=user%d", i%5))
+			if err == nil {
+				socket.conn.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+	eventually(t, "every socket to be let go", func() bool {
+		conns, users := ts.hub.connCounts()
+		return conns == 0 && len(users) == 0 && ts.hub.clientCount() == 0
+	})
+}